@@ -0,0 +1,522 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const (
+	alertRulesFile = "alert_rules.json"
+
+	// How often rules are evaluated
+	alertEvalInterval = 30 * time.Second
+
+	alertDefaultWindow   = 5 * time.Minute
+	alertDefaultMinCalls = 10
+)
+
+// Metrics an alert rule can watch
+const (
+	alertMetricCalls       = "calls"            // Calls that ended within the window
+	alertMetricFailureRate = "failure_rate"     // Percent of them that failed
+	alertMetricASR         = "asr"              // Route answer-seizure ratio, percent
+	alertMetricACD         = "acd_sec"          // Route average call duration
+	alertMetricPDD         = "pdd_sec"          // Route average post-dial delay
+	alertMetricConcurrent  = "concurrent_calls" // Calls up right now
+)
+
+var alertMetrics = []string{alertMetricCalls, alertMetricFailureRate, alertMetricASR, alertMetricACD, alertMetricPDD, alertMetricConcurrent}
+
+// Metrics computed from the outbound gateway legs of route statistics
+var alertRouteMetrics = []string{alertMetricASR, alertMetricACD, alertMetricPDD}
+
+// Hangup causes of unanswered calls that are not failures: the caller gave
+// up, or the callee was busy, did not answer or declined
+var alertNormalCauses = []string{
+	"NORMAL_CLEARING", "ORIGINATOR_CANCEL", "USER_BUSY", "NO_ANSWER", "NO_USER_RESPONSE",
+	"CALL_REJECTED", "LOSE_RACE", "PICKED_OFF",
+}
+
+// checkSMTPSettings validates FSAPI_SMTP_ADDR and FSAPI_SMTP_FROM
+func checkSMTPSettings() error {
+	if FSAPI_SMTP_ADDR == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(FSAPI_SMTP_ADDR); err != nil {
+		return fmt.Errorf("%q is not host:port", FSAPI_SMTP_ADDR)
+	}
+	if _, err := mail.ParseAddress(FSAPI_SMTP_FROM); err != nil || strings.ContainsAny(FSAPI_SMTP_FROM, "\n\r") {
+		return fmt.Errorf("FSAPI_SMTP_FROM %q is not an e-mail address", FSAPI_SMTP_FROM)
+	}
+	return nil
+}
+
+// AlertRule raises an alert while a metric is above or below a threshold
+type AlertRule struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Metric    string    `json:"metric"`
+	Op        string    `json:"op"` // ">" or "<"
+	Threshold float64   `json:"threshold"`
+	WindowSec int       `json:"window_sec,omitempty"` // Calls looked at; default 300
+	MinCalls  int       `json:"min_calls,omitempty"`  // Rates and averages need this many calls; default 10
+	Context   string    `json:"context,omitempty"`    // Empty watches every context
+	Gateway   string    `json:"gateway,omitempty"`    // Route metrics only
+	Email     []string  `json:"email,omitempty"`      // Also notified by e-mail
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Alert is a rule whose condition holds
+type Alert struct {
+	RuleID    string    `json:"rule_id"`
+	Name      string    `json:"name"`
+	Metric    string    `json:"metric"`
+	Op        string    `json:"op"`
+	Threshold float64   `json:"threshold"`
+	Value     float64   `json:"value"`
+	Context   string    `json:"context,omitempty"`
+	Gateway   string    `json:"gateway,omitempty"`
+	Since     time.Time `json:"since"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// window returns the rule's evaluation window
+func (rule *AlertRule) window() time.Duration {
+	if rule.WindowSec == 0 {
+		return alertDefaultWindow
+	}
+	return time.Duration(rule.WindowSec) * time.Second
+}
+
+// minCalls returns the calls a rate or average needs to be evaluated
+func (rule *AlertRule) minCalls() int {
+	if rule.MinCalls == 0 {
+		return alertDefaultMinCalls
+	}
+	return rule.MinCalls
+}
+
+// holds reports whether value meets the rule's condition
+func (rule *AlertRule) holds(value float64) bool {
+	if rule.Op == "<" {
+		return value < rule.Threshold
+	}
+	return value > rule.Threshold
+}
+
+// validate checks the rule
+func (rule *AlertRule) validate(emailEnabled bool) error {
+	if rule.Name == "" || strings.ContainsAny(rule.Name, "\n\r") {
+		return fmt.Errorf("name is required and must be a single line")
+	}
+	if !containsString(alertMetrics, rule.Metric) {
+		return fmt.Errorf("metric must be one of: %s", strings.Join(alertMetrics, ", "))
+	}
+	if rule.Op != ">" && rule.Op != "<" {
+		return fmt.Errorf("op must be > or <")
+	}
+	if rule.Threshold < 0 || math.IsNaN(rule.Threshold) {
+		return fmt.Errorf("threshold must not be negative")
+	}
+	if rule.WindowSec < 0 || rule.MinCalls < 0 {
+		return fmt.Errorf("window_sec and min_calls must not be negative")
+	}
+	if rule.Context != "" && !domainPattern.MatchString(rule.Context) {
+		return fmt.Errorf("context must be a domain")
+	}
+	if rule.Gateway != "" {
+		if !containsString(alertRouteMetrics, rule.Metric) {
+			return fmt.Errorf("gateway only applies to metrics %s", strings.Join(alertRouteMetrics, ", "))
+		}
+		if !isValidName(rule.Gateway) {
+			return fmt.Errorf("gateway name must be letters, digits, '_', '.' or '-'")
+		}
+	}
+	if len(rule.Email) > 0 && !emailEnabled {
+		return fmt.Errorf("email requires FSAPI_SMTP_ADDR")
+	}
+	// Only the bare address is kept, so a display name cannot add to the
+	// headers of the mail
+	for i, addr := range rule.Email {
+		parsed, err := mail.ParseAddress(addr)
+		if err != nil || strings.ContainsAny(addr, "\n\r") {
+			return fmt.Errorf("email: %q is not an e-mail address", addr)
+		}
+		rule.Email[i] = parsed.Address
+	}
+	return nil
+}
+
+// alertManager keeps the persisted alert rules and, with the event stream,
+// evaluates them against CDRs, route statistics and realtime gauges
+type alertManager struct {
+	h *APIHandler
+
+	mu     sync.Mutex
+	rules  map[string]*AlertRule
+	active map[string]*Alert // Rule ID -> alert
+
+	fired *counterVec
+}
+
+// newAlertManager loads the alert rules from FSAPI_DATA_DIR
+func newAlertManager(h *APIHandler) *alertManager {
+	m := &alertManager{
+		h:      h,
+		rules:  make(map[string]*AlertRule),
+		active: make(map[string]*Alert),
+		fired:  h.metrics.counter("fsapi_alerts_fired_total", "Alerts raised by alert rules, by metric.", "metric"),
+	}
+	var rules []*AlertRule
+	if err := loadJSONFile(alertRulesFile, &rules); err != nil {
+		log.Printf("WARNING: Failed to load alert rules: %v", err)
+	}
+	for _, rule := range rules {
+		m.rules[rule.ID] = rule
+	}
+	return m
+}
+
+// sortedRules returns the rules sorted by name. Caller must hold mu.
+func (m *alertManager) sortedRules() []*AlertRule {
+	rules := make([]*AlertRule, 0, len(m.rules))
+	for _, rule := range m.rules {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].Name != rules[j].Name {
+			return rules[i].Name < rules[j].Name
+		}
+		return rules[i].ID < rules[j].ID
+	})
+	return rules
+}
+
+// save persists the rules. Caller must hold mu.
+func (m *alertManager) save() error {
+	return saveJSONFile(alertRulesFile, m.sortedRules())
+}
+
+// run evaluates the rules every alertEvalInterval until shutdown starts
+func (m *alertManager) run() {
+	ticker := time.NewTicker(alertEvalInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			m.evaluate(now)
+		case <-m.h.jobs.stopping():
+			return
+		}
+	}
+}
+
+// measure returns the current value of the rule's metric, or false when
+// there are too few calls to tell
+func (m *alertManager) measure(rule *AlertRule, now time.Time) (float64, bool) {
+	since := now.Add(-rule.window())
+	switch rule.Metric {
+	case alertMetricCalls, alertMetricFailureRate:
+		// Both legs of a bridged call produce a CDR; count each pair once
+		rows := m.h.cdrs.query(since, func(rec *CDR) bool { return rule.Context == "" || rec.Context == rule.Context })
+		seen := make(map[string]bool, len(rows))
+		for _, rec := range rows {
+			seen[rec.UUID] = true
+		}
+		calls, failed := 0, 0
+		for _, rec := range rows {
+			if seen[rec.OtherLegUUID] && rec.OtherLegUUID < rec.UUID {
+				continue
+			}
+			calls++
+			if rec.AnswerTime == nil && !containsString(alertNormalCauses, rec.HangupCause) {
+				failed++
+			}
+		}
+		if rule.Metric == alertMetricCalls {
+			return float64(calls), true
+		}
+		if calls == 0 || calls < rule.minCalls() {
+			return 0, false
+		}
+		return math.Round(float64(failed)/float64(calls)*1000) / 10, true
+
+	case alertMetricASR, alertMetricACD, alertMetricPDD:
+		if m.h.routeStats == nil {
+			return 0, false
+		}
+		var attempts []routeAttempt
+		for _, a := range m.h.routeStats.since(since) {
+			if (rule.Context == "" || a.context == rule.Context) && (rule.Gateway == "" || a.gateway == rule.Gateway) {
+				attempts = append(attempts, a)
+			}
+		}
+		s := summarizeRoute(attempts)
+		switch rule.Metric {
+		case alertMetricASR:
+			return s.ASR, s.Attempts > 0 && s.Attempts >= rule.minCalls()
+		case alertMetricACD:
+			return s.ACDSec, s.Answered > 0 && s.Answered >= rule.minCalls()
+		default:
+			if s.PDDSec == nil || s.Attempts < rule.minCalls() {
+				return 0, false
+			}
+			return *s.PDDSec, true
+		}
+
+	case alertMetricConcurrent:
+		if m.h.realtime == nil {
+			return 0, false
+		}
+		key := rule.Context
+		if key == "" {
+			key = realtimeTotalKey
+		}
+		m.h.realtime.mu.Lock()
+		g, ok := m.h.realtime.gauges(now)[key]
+		m.h.realtime.mu.Unlock()
+		if !ok {
+			return 0, true
+		}
+		return float64(g.Calls), true
+	}
+	return 0, false
+}
+
+// evaluate raises the alerts of rules whose condition now holds and
+// resolves those whose condition no longer does
+func (m *alertManager) evaluate(now time.Time) {
+	m.mu.Lock()
+	rules := m.sortedRules()
+	m.mu.Unlock()
+
+	type change struct {
+		event string
+		alert Alert
+		email []string
+	}
+	var changes []change
+	for _, rule := range rules {
+		value, ok := m.measure(rule, now)
+		if !ok {
+			// Too few calls to tell: an active alert stays as it is
+			continue
+		}
+		m.mu.Lock()
+		alert, active := m.active[rule.ID]
+		switch {
+		case rule.holds(value) && !active:
+			alert = &Alert{
+				RuleID:    rule.ID,
+				Name:      rule.Name,
+				Metric:    rule.Metric,
+				Op:        rule.Op,
+				Threshold: rule.Threshold,
+				Value:     value,
+				Context:   rule.Context,
+				Gateway:   rule.Gateway,
+				Since:     now.UTC(),
+				UpdatedAt: now.UTC(),
+			}
+			m.active[rule.ID] = alert
+			changes = append(changes, change{"alert.firing", *alert, rule.Email})
+		case rule.holds(value):
+			alert.Value = value
+			alert.UpdatedAt = now.UTC()
+		case active:
+			delete(m.active, rule.ID)
+			alert.Value = value
+			alert.UpdatedAt = now.UTC()
+			changes = append(changes, change{"alert.resolved", *alert, rule.Email})
+		}
+		m.mu.Unlock()
+	}
+
+	for _, c := range changes {
+		if c.event == "alert.firing" {
+			m.fired.inc(c.alert.Metric)
+		}
+		log.Printf("Alert %q %s: %s %g %s %g", c.alert.Name, strings.TrimPrefix(c.event, "alert."), c.alert.Metric, c.alert.Value, c.alert.Op, c.alert.Threshold)
+		m.h.webhooks.dispatch(c.event, c.alert.Context, c.alert)
+		if len(c.email) > 0 {
+			alert, event, to := c.alert, c.event, c.email
+			m.h.jobs.goJob("alert e-mail "+alert.RuleID, func() { sendAlertEmail(event, &alert, to) })
+		}
+	}
+}
+
+// forget drops the active alert of a deleted rule
+func (m *alertManager) forget(ruleID string) {
+	m.mu.Lock()
+	delete(m.active, ruleID)
+	m.mu.Unlock()
+}
+
+// sendAlertEmail mails an alert change through FSAPI_SMTP_ADDR
+func sendAlertEmail(event string, alert *Alert, to []string) {
+	state := "FIRING"
+	if event == "alert.resolved" {
+		state = "RESOLVED"
+	}
+	scope := alert.Context
+	if scope == "" {
+		scope = "all contexts"
+	}
+	if alert.Gateway != "" {
+		scope += ", gateway " + alert.Gateway
+	}
+	var body strings.Builder
+	fmt.Fprintf(&body, "From: %s\r\n", FSAPI_SMTP_FROM)
+	recipients := make([]string, len(to))
+	for i, addr := range to {
+		recipients[i] = (&mail.Address{Address: addr}).String()
+	}
+	fmt.Fprintf(&body, "To: %s\r\n", strings.Join(recipients, ", "))
+	fmt.Fprintf(&body, "Subject: [fs-api] %s: %s\r\n", state, alert.Name)
+	fmt.Fprintf(&body, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	body.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&body, "Alert:     %s (%s)\r\n", alert.Name, state)
+	fmt.Fprintf(&body, "Scope:     %s\r\n", scope)
+	fmt.Fprintf(&body, "Condition: %s %s %g\r\n", alert.Metric, alert.Op, alert.Threshold)
+	fmt.Fprintf(&body, "Value:     %g\r\n", alert.Value)
+	fmt.Fprintf(&body, "Since:     %s\r\n", alert.Since.Format(time.RFC3339))
+
+	var auth smtp.Auth
+	if FSAPI_SMTP_USERNAME != "" {
+		host, _, _ := strings.Cut(FSAPI_SMTP_ADDR, ":")
+		auth = smtp.PlainAuth("", FSAPI_SMTP_USERNAME, FSAPI_SMTP_PASSWORD, host)
+	}
+	if err := smtp.SendMail(FSAPI_SMTP_ADDR, auth, FSAPI_SMTP_FROM, to, []byte(body.String())); err != nil {
+		log.Printf("WARNING: Failed to e-mail alert %q: %v", alert.Name, err)
+	}
+}
+
+// --- Alert handlers ---
+
+// GET /v1/alerts
+func (h *APIHandler) ListAlerts(w http.ResponseWriter, r *http.Request) {
+	if h.eventHistory == nil {
+		h.respondError(w, r, "Alerts require FSAPI_EVENTS=true", http.StatusServiceUnavailable)
+		return
+	}
+	contextFilter := r.URL.Query().Get("context")
+	h.alerts.mu.Lock()
+	rows := []Alert{}
+	for _, alert := range h.alerts.active {
+		if contextFilter != "" && alert.Context != contextFilter {
+			continue
+		}
+		// Alerts over every context are only shown to unrestricted callers
+		if (alert.Context == "" && isUnrestrictedAccess(r)) || (alert.Context != "" && isContextAllowed(r, alert.Context)) {
+			rows = append(rows, *alert)
+		}
+	}
+	h.alerts.mu.Unlock()
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Since.Before(rows[j].Since) })
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status":    "success",
+		"row_count": len(rows),
+		"rows":      rows,
+	})
+}
+
+// GET /v1/alerts/rules
+func (h *APIHandler) ListAlertRules(w http.ResponseWriter, r *http.Request) {
+	contextFilter := r.URL.Query().Get("context")
+	h.alerts.mu.Lock()
+	rows := []*AlertRule{}
+	for _, rule := range h.alerts.sortedRules() {
+		if contextFilter != "" && rule.Context != contextFilter {
+			continue
+		}
+		if (rule.Context == "" && isUnrestrictedAccess(r)) || (rule.Context != "" && isContextAllowed(r, rule.Context)) {
+			rows = append(rows, rule)
+		}
+	}
+	h.alerts.mu.Unlock()
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status":    "success",
+		"row_count": len(rows),
+		"rows":      rows,
+	})
+}
+
+// POST /v1/alerts/rules
+func (h *APIHandler) CreateAlertRule(w http.ResponseWriter, r *http.Request) {
+	var rule AlertRule
+	if !h.decodeRequest(w, r, &rule) {
+		return
+	}
+	if err := rule.validate(FSAPI_SMTP_ADDR != ""); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Only unrestricted callers may watch every tenant
+	if rule.Context == "" {
+		if !isUnrestrictedAccess(r) {
+			h.respondError(w, r, "context is required without unrestricted access", http.StatusForbidden)
+			return
+		}
+	} else if !h.validateRequestContext(w, r, rule.Context) {
+		return
+	}
+	rule.ID = uuid.New().String()
+	rule.CreatedAt = time.Now().UTC()
+
+	h.alerts.mu.Lock()
+	h.alerts.rules[rule.ID] = &rule
+	err := h.alerts.save()
+	h.alerts.mu.Unlock()
+	if err != nil {
+		logWarn(getRequestID(r), fmt.Sprintf("Failed to persist alert rules: %v", err))
+	}
+
+	logInfo(getRequestID(r), fmt.Sprintf("Added alert rule %q: %s %s %g", rule.Name, rule.Metric, rule.Op, rule.Threshold))
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   rule,
+	})
+}
+
+// DELETE /v1/alerts/rules/{id}
+func (h *APIHandler) DeleteAlertRule(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	h.alerts.mu.Lock()
+	rule, ok := h.alerts.rules[id]
+	h.alerts.mu.Unlock()
+	if !ok || (rule.Context != "" && !isContextAllowed(r, rule.Context)) {
+		h.respondError(w, r, fmt.Sprintf("Alert rule %s not found", id), http.StatusNotFound)
+		return
+	}
+	if rule.Context == "" && !isUnrestrictedAccess(r) {
+		h.respondError(w, r, "Rules for every context can only be deleted with unrestricted access", http.StatusForbidden)
+		return
+	}
+
+	h.alerts.mu.Lock()
+	delete(h.alerts.rules, id)
+	err := h.alerts.save()
+	h.alerts.mu.Unlock()
+	if err != nil {
+		logWarn(getRequestID(r), fmt.Sprintf("Failed to persist alert rules: %v", err))
+	}
+	// A firing alert of the rule ends without an alert.resolved
+	h.alerts.forget(id)
+
+	logInfo(getRequestID(r), fmt.Sprintf("Deleted alert rule %q", rule.Name))
+	h.respondSuccess(w, r, fmt.Sprintf("Alert rule %s deleted", id))
+}
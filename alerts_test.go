@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestAlertRuleEmailStoredBare(t *testing.T) {
+	rule := &AlertRule{
+		Name:      "ASR drop",
+		Metric:    alertMetrics[0],
+		Op:        "<",
+		Threshold: 0.5,
+		Email:     []string{"NOC <noc@example.com>", `"Ops, night" <ops@example.com>`, "alice@example.com"},
+	}
+	if err := rule.validate(true); err != nil {
+		t.Fatalf("validate() = %v", err)
+	}
+	want := []string{"noc@example.com", "ops@example.com", "alice@example.com"}
+	for i, addr := range rule.Email {
+		if addr != want[i] {
+			t.Errorf("Email[%d] = %q, want %q", i, addr, want[i])
+		}
+	}
+
+	for _, addr := range []string{"not an address", "x@example.com\r\nBcc: y@example.com"} {
+		rule.Email = []string{addr}
+		if err := rule.validate(true); err == nil {
+			t.Errorf("validate() accepted email %q", addr)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const auditFile = "audit.jsonl"
+
+const tokenIDKey contextKey = "tokenID"
+
+// AuditEntry records a privileged action and who performed it
+type AuditEntry struct {
+	Time      time.Time         `json:"time"`
+	RequestID string            `json:"request_id"`
+	Token     string            `json:"token"` // Token fingerprint, never the token itself
+	Remote    string            `json:"remote_addr"`
+	Action    string            `json:"action"`
+	Target    string            `json:"target"`
+	Context   string            `json:"context,omitempty"`
+	Reason    string            `json:"reason,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+}
+
+// tokenFingerprint identifies a bearer token in logs without revealing it
+func tokenFingerprint(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "tok_" + hex.EncodeToString(sum[:])[:12]
+}
+
+// getTokenID returns the fingerprint of the request's bearer token, or
+// "anonymous" when authentication is disabled
+func getTokenID(r *http.Request) string {
+	if id, ok := r.Context().Value(tokenIDKey).(string); ok {
+		return id
+	}
+	return "anonymous"
+}
+
+// audit appends an entry for the request to audit.jsonl and the log
+func (h *APIHandler) audit(r *http.Request, entry AuditEntry) {
+	entry.Time = time.Now().UTC()
+	entry.RequestID = getRequestID(r)
+	entry.Token = getTokenID(r)
+	entry.Remote = r.RemoteAddr
+
+	logInfo(entry.RequestID, fmt.Sprintf("AUDIT %s %s by %s (reason: %q)", entry.Action, entry.Target, entry.Token, entry.Reason))
+	if err := appendJSONLine(auditFile, entry); err != nil {
+		logWarn(entry.RequestID, fmt.Sprintf("Failed to persist audit entry: %v", err))
+	}
+}
+
+// GET /v1/audit
+func (h *APIHandler) ListAudit(w http.ResponseWriter, r *http.Request) {
+	since := time.Time{}
+	if v := r.URL.Query().Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			h.respondError(w, r, "since must be an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		since = t
+	}
+
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			h.respondError(w, r, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	action := r.URL.Query().Get("action")
+	rows := []AuditEntry{}
+	err := loadJSONLines(auditFile, func(line []byte) {
+		var entry AuditEntry
+		if json.Unmarshal(line, &entry) != nil || entry.Time.Before(since) {
+			return
+		}
+		if action != "" && entry.Action != action {
+			return
+		}
+		// Entries without a context are admin-only
+		if !isUnrestrictedAccess(r) && (entry.Context == "" || !isContextAllowed(r, entry.Context)) {
+			return
+		}
+		rows = append(rows, entry)
+	})
+	if err != nil {
+		h.respondError(w, r, fmt.Sprintf("Failed to read audit log: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Most recent entries win when the limit applies
+	if len(rows) > limit {
+		rows = rows[len(rows)-limit:]
+	}
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status":    "success",
+		"row_count": len(rows),
+		"rows":      rows,
+	})
+}
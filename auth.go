@@ -10,6 +10,7 @@ import (
 
 const (
 	allowedContextsKey contextKey = "allowedContexts"
+	callContextKey     contextKey = "callContext" // *CallContextInfo of the call in the URL
 	WILDCARD_CONTEXT              = "*"
 )
 
@@ -24,6 +25,7 @@ type CallContextInfo struct {
 	UUID        string
 	AccountCode string
 	Found       bool
+	ETag        string // State version, see callETag
 }
 
 // isUnrestrictedAccess checks if the request has unrestricted context access
@@ -42,12 +44,42 @@ func getAllowedContexts(r *http.Request) []string {
 	return nil
 }
 
+// contextsDeclared reports whether the caller stated which contexts it may
+// access, with the X-Allowed-Contexts header or through a session token
+// minted for specific contexts
+func contextsDeclared(r *http.Request) bool {
+	if r.Header.Get("X-Allowed-Contexts") != "" {
+		return true
+	}
+	session, ok := getSession(r)
+	return ok && len(session.Contexts) > 0
+}
+
+// isContextAllowed reports whether the request may access the given context
+func isContextAllowed(r *http.Request, context string) bool {
+	if isUnrestrictedAccess(r) {
+		return true
+	}
+	for _, allowed := range getAllowedContexts(r) {
+		if context == allowed {
+			return true
+		}
+	}
+	return false
+}
+
 // getCallContext fetches call context information from FreeSWITCH
 func (h *APIHandler) getCallContext(callUUID string) (*CallContextInfo, error) {
 	// Use uuid_dump to get full channel variables for the call
 	response, err := h.eslClient.SendCommand(fmt.Sprintf("api uuid_dump %s json", callUUID))
+	if isESLNotFound(err) {
+		return &CallContextInfo{
+			UUID:  callUUID,
+			Found: false,
+		}, nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve call: %v", err)
+		return nil, fmt.Errorf("failed to retrieve call: %w", err)
 	}
 
 	// If uuid_dump returns an error (call not found), the response won't be valid JSON
@@ -73,33 +105,45 @@ func (h *APIHandler) getCallContext(callUUID string) (*CallContextInfo, error) {
 		UUID:        callUUID,
 		AccountCode: callContext,
 		Found:       true,
+		ETag:        callETag(dumpData, h.traces.activeRecordings(strings.ToLower(callUUID))),
 	}, nil
 }
 
+// lookupCallContext returns the call's context info, reusing the one the
+// policy middleware fetched for the call in the URL. With If-Match it is
+// fetched again, as the call may have changed while the request waited for
+// the call's lock.
+func (h *APIHandler) lookupCallContext(r *http.Request, callUUID string) (*CallContextInfo, error) {
+	if info, ok := r.Context().Value(callContextKey).(*CallContextInfo); ok && strings.EqualFold(info.UUID, callUUID) && r.Header.Get("If-Match") == "" {
+		return info, nil
+	}
+	return h.getCallContext(callUUID)
+}
+
 // validateCallContext validates that a call belongs to an allowed context
 // Returns the call context info and true if valid, or responds with error and returns false
 func (h *APIHandler) validateCallContext(w http.ResponseWriter, r *http.Request, callUUID string) (*CallContextInfo, bool) {
 	// Check if unrestricted access
 	if isUnrestrictedAccess(r) {
 		// Still verify call exists for proper 404
-		callInfo, err := h.getCallContext(callUUID)
+		callInfo, err := h.lookupCallContext(r, callUUID)
 		if err != nil {
-			h.respondError(w, r, fmt.Sprintf("Failed to verify call: %v", err), http.StatusInternalServerError)
+			h.respondESLError(w, r, fmt.Sprintf("Failed to verify call: %v", err), err)
 			return nil, false
 		}
 		if !callInfo.Found {
 			h.respondError(w, r, fmt.Sprintf("Call %s not found", callUUID), http.StatusNotFound)
 			return nil, false
 		}
-		return callInfo, true
+		return callInfo, h.checkCallPrecondition(w, r, callInfo)
 	}
 
 	allowedContexts := getAllowedContexts(r)
 
 	// Fetch call context
-	callInfo, err := h.getCallContext(callUUID)
+	callInfo, err := h.lookupCallContext(r, callUUID)
 	if err != nil {
-		h.respondError(w, r, fmt.Sprintf("Failed to verify call context: %v", err), http.StatusInternalServerError)
+		h.respondESLError(w, r, fmt.Sprintf("Failed to verify call context: %v", err), err)
 		return nil, false
 	}
 
@@ -111,7 +155,7 @@ func (h *APIHandler) validateCallContext(w http.ResponseWriter, r *http.Request,
 	// Check if call context is allowed
 	for _, allowed := range allowedContexts {
 		if callInfo.AccountCode == allowed {
-			return callInfo, true
+			return callInfo, h.checkCallPrecondition(w, r, callInfo)
 		}
 	}
 
@@ -150,6 +194,16 @@ func (h *APIHandler) validateRequestContext(w http.ResponseWriter, r *http.Reque
 	return false
 }
 
+// requireUnrestricted rejects restricted callers from admin endpoints.
+// Returns true if allowed, or responds with 403 and returns false.
+func (h *APIHandler) requireUnrestricted(w http.ResponseWriter, r *http.Request) bool {
+	if isUnrestrictedAccess(r) {
+		return true
+	}
+	h.respondError(w, r, "This endpoint requires unrestricted access", http.StatusForbidden)
+	return false
+}
+
 // contextAuthMiddleware extracts X-Allowed-Contexts header and stores in request context
 func contextAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -178,6 +232,22 @@ func contextAuthMiddleware(next http.Handler) http.Handler {
 			}
 		}
 
+		// A session token never reaches beyond the contexts it was minted for
+		if session, ok := getSession(r); ok && len(session.Contexts) > 0 {
+			if isUnrestricted {
+				allowedContexts = session.Contexts
+			} else {
+				var both []string
+				for _, ctx := range allowedContexts {
+					if containsString(session.Contexts, ctx) {
+						both = append(both, ctx)
+					}
+				}
+				allowedContexts = both
+			}
+			isUnrestricted = false
+		}
+
 		// Store both the list and unrestricted flag
 		auth := contextAuth{
 			Contexts:     allowedContexts,
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tracked sources; idle ones are dropped first beyond this
+const authGuardMaxEntries = 10000
+
+// authFailures is the failure history of one source IP or rejected token
+type authFailures struct {
+	times       []time.Time // Failures inside the window, oldest first
+	lockedUntil time.Time
+}
+
+// authGuard counts failed authentications per source IP and per rejected
+// token, locking a source out for a while once it reaches maxFailures
+// within window. Failed attempts can also be slowed down (tarpit).
+type authGuard struct {
+	maxFailures int
+	window      time.Duration
+	lockout     time.Duration
+	tarpit      time.Duration // Longest delay added to a failed attempt; 0 disables
+
+	audit    func(r *http.Request, entry AuditEntry)
+	failures *counterVec
+	lockouts *counterVec
+	blocked  *counterVec
+
+	mu      sync.Mutex
+	sources map[string]*authFailures // "ip:<addr>" or "token:<fingerprint>"
+}
+
+func newAuthGuard(maxFailures int, window, lockout, tarpit time.Duration, metrics *metricsRegistry, audit func(*http.Request, AuditEntry)) *authGuard {
+	return &authGuard{
+		maxFailures: maxFailures,
+		window:      window,
+		lockout:     lockout,
+		tarpit:      tarpit,
+		audit:       audit,
+		failures:    metrics.counter("fsapi_auth_failures_total", "Failed authentication attempts by reason.", "reason"),
+		lockouts:    metrics.counter("fsapi_auth_lockouts_total", "Sources locked out after repeated authentication failures.", "scope"),
+		blocked:     metrics.counter("fsapi_auth_blocked_total", "Requests refused because their source was locked out.", "scope"),
+		sources:     make(map[string]*authFailures),
+	}
+}
+
+// sourceIP is the client address without the port
+func sourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// guardKeys returns the tracking keys of a request and the token it
+// presented (empty when none). Tokens are keyed by the fingerprint of the
+// whole token, so failures with one token never count against another.
+func guardKeys(r *http.Request, credential string) []string {
+	keys := []string{"ip:" + sourceIP(r)}
+	if credential != "" {
+		keys = append(keys, "token:"+tokenFingerprint(credential))
+	}
+	return keys
+}
+
+// locked reports whether any key of the request is locked out and for how
+// much longer
+func (g *authGuard) locked(r *http.Request, credential string) (time.Duration, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	for _, key := range guardKeys(r, credential) {
+		if f, ok := g.sources[key]; ok && now.Before(f.lockedUntil) {
+			g.blocked.inc(guardScope(key))
+			return f.lockedUntil.Sub(now), true
+		}
+	}
+	return 0, false
+}
+
+// fail records a failed attempt and returns how long to delay the response
+func (g *authGuard) fail(r *http.Request, credential, reason string) time.Duration {
+	g.failures.inc(reason)
+	now := time.Now()
+	cutoff := now.Add(-g.window)
+
+	g.mu.Lock()
+	most := 0
+	var lockedKeys []string
+	for _, key := range guardKeys(r, credential) {
+		f, ok := g.sources[key]
+		if !ok {
+			g.prune(now)
+			f = &authFailures{}
+			g.sources[key] = f
+		}
+		kept := f.times[:0]
+		for _, t := range f.times {
+			if t.After(cutoff) {
+				kept = append(kept, t)
+			}
+		}
+		f.times = append(kept, now)
+		most = max(most, len(f.times))
+		if len(f.times) >= g.maxFailures && !now.Before(f.lockedUntil) {
+			f.lockedUntil = now.Add(g.lockout)
+			f.times = nil
+			g.lockouts.inc(guardScope(key))
+			lockedKeys = append(lockedKeys, key)
+		}
+	}
+	g.mu.Unlock()
+
+	for _, key := range lockedKeys {
+		logWarn(getRequestID(r), fmt.Sprintf("Authentication locked out for %s after %d failures", key, g.maxFailures))
+		g.audit(r, AuditEntry{
+			Action: "auth.lockout",
+			Target: key,
+			Reason: reason,
+			Details: map[string]string{
+				"failures":    strconv.Itoa(g.maxFailures),
+				"window_sec":  strconv.Itoa(int(g.window.Seconds())),
+				"lockout_sec": strconv.Itoa(int(g.lockout.Seconds())),
+			},
+		})
+	}
+
+	if g.tarpit <= 0 {
+		return 0
+	}
+	// One second per recent failure, up to the tarpit limit
+	return min(time.Duration(most-1)*time.Second, g.tarpit)
+}
+
+// prune drops sources with no recent failures and no active lockout, then
+// evicts arbitrary entries beyond authGuardMaxEntries. Caller must hold mu.
+func (g *authGuard) prune(now time.Time) {
+	if len(g.sources) < authGuardMaxEntries {
+		return
+	}
+	cutoff := now.Add(-g.window)
+	for key, f := range g.sources {
+		if now.After(f.lockedUntil) && (len(f.times) == 0 || f.times[len(f.times)-1].Before(cutoff)) {
+			delete(g.sources, key)
+		}
+	}
+	for key := range g.sources {
+		if len(g.sources) < authGuardMaxEntries {
+			break
+		}
+		delete(g.sources, key)
+	}
+}
+
+// guardScope is the metrics label of a tracking key
+func guardScope(key string) string {
+	if strings.HasPrefix(key, "ip:") {
+		return "ip"
+	}
+	return "token"
+}
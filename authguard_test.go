@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAuthLockoutSparesOtherTokens(t *testing.T) {
+	const valid = "acme-valid-token-0001"
+	guard := newAuthGuard(3, time.Minute, time.Minute, 0, newMetricsRegistry(), func(*http.Request, AuditEntry) {})
+	handler := bearerAuthMiddleware(authConfig{tokens: []string{valid}, guard: guard})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// Each attempt from its own IP, so only the token is tracked across them
+	n := 0
+	call := func(token string) int {
+		n++
+		req := httptest.NewRequest("GET", "/v1/calls", nil)
+		req.RemoteAddr = "192.0.2." + strconv.Itoa(n) + ":5060"
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// A wrong token sharing the valid one's prefix gets locked out
+	for i := 0; i < 3; i++ {
+		if code := call("acme-valid-wrong"); code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: HTTP %d, want 401", i+1, code)
+		}
+	}
+	if code := call("acme-valid-wrong"); code != http.StatusTooManyRequests {
+		t.Errorf("locked token: HTTP %d, want 429", code)
+	}
+	if code := call(valid); code != http.StatusOK {
+		t.Errorf("valid token with the same prefix: HTTP %d, want 200", code)
+	}
+	if code := call("acme-valid-other"); code != http.StatusUnauthorized {
+		t.Errorf("another wrong token: HTTP %d, want 401", code)
+	}
+}
+
+func TestAuthLockoutBySourceIP(t *testing.T) {
+	const valid = "acme-valid-token-0001"
+	guard := newAuthGuard(2, time.Minute, time.Minute, 0, newMetricsRegistry(), func(*http.Request, AuditEntry) {})
+	handler := bearerAuthMiddleware(authConfig{tokens: []string{valid}, guard: guard})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	call := func(ip, token string) int {
+		req := httptest.NewRequest("GET", "/v1/calls", nil)
+		req.RemoteAddr = ip + ":5060"
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	call("192.0.2.1", "guess-1")
+	call("192.0.2.1", "guess-2")
+	if code := call("192.0.2.1", valid); code != http.StatusTooManyRequests {
+		t.Errorf("valid token from a locked-out IP: HTTP %d, want 429", code)
+	}
+	if code := call("192.0.2.2", valid); code != http.StatusOK {
+		t.Errorf("valid token from another IP: HTTP %d, want 200", code)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Channel variables carrying billing tags. They travel with the call into
+// FreeSWITCH CDRs and come back on hangup events.
+const (
+	billingVarRatePlan    = "fsapi_billing_rate_plan_id"
+	billingVarCustomerRef = "fsapi_billing_customer_ref"
+)
+
+// Billing values end up inside originate {var=value} blocks and uuid_setvar
+// arguments, so separators and quotes are not allowed
+var billingValueRegex = regexp.MustCompile(`^[A-Za-z0-9._:@/+-]{1,128}$`)
+
+// validateBilling checks the billing object's values
+func validateBilling(b *BillingInfo) error {
+	if b == nil {
+		return nil
+	}
+	if b.RatePlanID != "" && !billingValueRegex.MatchString(b.RatePlanID) {
+		return fmt.Errorf("billing.rate_plan_id contains invalid characters")
+	}
+	if b.CustomerRef != "" && !billingValueRegex.MatchString(b.CustomerRef) {
+		return fmt.Errorf("billing.customer_ref contains invalid characters")
+	}
+	return nil
+}
+
+// billingVars returns the channel variables for b as name/value pairs
+func billingVars(b *BillingInfo) [][2]string {
+	if b == nil {
+		return nil
+	}
+	var vars [][2]string
+	if b.RatePlanID != "" {
+		vars = append(vars, [2]string{billingVarRatePlan, b.RatePlanID})
+	}
+	if b.CustomerRef != "" {
+		vars = append(vars, [2]string{billingVarCustomerRef, b.CustomerRef})
+	}
+	return vars
+}
+
+// billingFromEvent reads the billing tags back from an event's variables
+func billingFromEvent(ev *Event) *BillingInfo {
+	b := &BillingInfo{
+		RatePlanID:  ev.Var(billingVarRatePlan),
+		CustomerRef: ev.Var(billingVarCustomerRef),
+	}
+	if b.RatePlanID == "" && b.CustomerRef == "" {
+		return nil
+	}
+	return b
+}
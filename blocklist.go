@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const blocklistFile = "blocklist.json"
+
+// Numbers dialed out of the switch: sofia/gateway/<gw>/<number>,
+// loopback/<number>[/context] and sofia/<profile>/<number>@host
+var dialedNumberPattern = regexp.MustCompile(`(?:sofia/gateway/[A-Za-z0-9_.-]+/|loopback/|sofia/[A-Za-z0-9_.-]+/)(\+?[0-9*#]+)`)
+
+// BlocklistEntry refuses calls to the numbers starting with Prefix
+type BlocklistEntry struct {
+	ID          string    `json:"id"`
+	Context     string    `json:"context,omitempty"` // Tenant context; empty applies to every tenant
+	Prefix      string    `json:"prefix"`            // With a leading '+', matches international numbers by country code
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// validate checks the entry
+func (e *BlocklistEntry) validate() error {
+	if !e164Pattern.MatchString(e.Prefix) {
+		return fmt.Errorf("prefix must be 1-15 digits with an optional leading '+'")
+	}
+	if e.Context != "" && !domainPattern.MatchString(e.Context) {
+		return fmt.Errorf("context must be a domain")
+	}
+	if strings.ContainsAny(e.Description, "\n\r") {
+		return fmt.Errorf("description must be a single line")
+	}
+	return nil
+}
+
+// matches reports whether a call to number hits the entry. A '+' prefix is
+// a country code and matches "+CC", "00CC" and "011CC"; a prefix without
+// one matches the dialed digits as they are.
+func (e *BlocklistEntry) matches(number string) bool {
+	if cc, ok := strings.CutPrefix(e.Prefix, "+"); ok {
+		for _, intl := range []string{"+", "00", "011"} {
+			if rest, ok := strings.CutPrefix(number, intl); ok && strings.HasPrefix(rest, cc) {
+				return true
+			}
+		}
+		return false
+	}
+	return strings.HasPrefix(strings.TrimPrefix(number, "+"), e.Prefix)
+}
+
+// blocklist is the persisted destination blocklist, keyed by entry ID
+type blocklist struct {
+	mu      sync.Mutex
+	entries map[string]*BlocklistEntry
+}
+
+// newBlocklist loads the blocklist from FSAPI_DATA_DIR
+func newBlocklist() *blocklist {
+	b := &blocklist{entries: make(map[string]*BlocklistEntry)}
+	var entries []*BlocklistEntry
+	if err := loadJSONFile(blocklistFile, &entries); err != nil {
+		log.Printf("WARNING: Failed to load blocklist: %v", err)
+	}
+	for _, e := range entries {
+		b.entries[e.ID] = e
+	}
+	return b
+}
+
+// list returns the entries sorted by context, then prefix
+func (b *blocklist) list() []*BlocklistEntry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sorted()
+}
+
+// sorted returns the entries sorted by context, then prefix. Caller must hold mu.
+func (b *blocklist) sorted() []*BlocklistEntry {
+	entries := make([]*BlocklistEntry, 0, len(b.entries))
+	for _, e := range b.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Context != entries[j].Context {
+			return entries[i].Context < entries[j].Context
+		}
+		return entries[i].Prefix < entries[j].Prefix
+	})
+	return entries
+}
+
+// save persists the blocklist. Caller must hold mu.
+func (b *blocklist) save() error {
+	return saveJSONFile(blocklistFile, b.sorted())
+}
+
+// match returns the entry blocking a call to number for a tenant in one of
+// contexts, or nil
+func (b *blocklist) match(number string, contexts []string) *BlocklistEntry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, e := range b.sorted() {
+		if (e.Context == "" || containsString(contexts, e.Context)) && e.matches(number) {
+			return e
+		}
+	}
+	return nil
+}
+
+// dialedNumbers returns the numbers a dial string calls out to
+func dialedNumbers(dial string) []string {
+	var numbers []string
+	for _, m := range dialedNumberPattern.FindAllStringSubmatch(dial, -1) {
+		numbers = append(numbers, m[1])
+	}
+	return numbers
+}
+
+// blegNumbers returns the numbers a B-leg reaches: a dialplan extension,
+// the dial strings of an application such as &bridge(), or the extension
+// &transfer() and &execute_extension() continue at
+func blegNumbers(bleg string) []string {
+	if bleg == "" {
+		return nil
+	}
+	if !strings.HasPrefix(bleg, "&") {
+		return []string{bleg}
+	}
+	numbers := dialedNumbers(bleg)
+	app, args, _ := strings.Cut(strings.TrimSuffix(bleg[1:], ")"), "(")
+	if app == "transfer" || app == "execute_extension" {
+		for _, arg := range strings.Fields(args) {
+			// transfer's -bleg/-both flags come before the extension
+			if !strings.HasPrefix(arg, "-") {
+				numbers = append(numbers, arg)
+				break
+			}
+		}
+	}
+	return numbers
+}
+
+// channelVarNumbers returns the numbers dial strings in channel variable
+// values call out to, e.g. an execute_on_answer that bridges to a gateway
+func channelVarNumbers(vars map[string]interface{}) []string {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var numbers []string
+	for _, name := range names {
+		numbers = append(numbers, dialedNumbers(fmt.Sprint(vars[name]))...)
+	}
+	return numbers
+}
+
+// blocklistContexts returns the tenants whose entries screen a request: the
+// given contexts and, for restricted callers, every context they act for
+func blocklistContexts(r *http.Request, contexts ...string) []string {
+	if !isUnrestrictedAccess(r) {
+		contexts = append(contexts, getAllowedContexts(r)...)
+	}
+	return contexts
+}
+
+// screenDestinations writes a 403 and an audit entry when one of numbers is
+// blocked for contexts. operation names the request in the audit log.
+func (h *APIHandler) screenDestinations(w http.ResponseWriter, r *http.Request, operation string, numbers, contexts []string) bool {
+	// The audit entry goes to the tenant the call belongs to
+	tenant := ""
+	for _, c := range contexts {
+		if c != "" {
+			tenant = c
+			break
+		}
+	}
+	for _, number := range numbers {
+		e := h.blocklist.match(number, contexts)
+		if e == nil {
+			continue
+		}
+		h.audit(r, AuditEntry{
+			Action:  "call.blocked",
+			Target:  number,
+			Context: tenant,
+			Reason:  e.Description,
+			Details: map[string]string{
+				"operation": operation,
+				"entry_id":  e.ID,
+				"prefix":    e.Prefix,
+			},
+		})
+		h.respondErrorBody(w, r, ErrorResponse{
+			Status:  "error",
+			Message: fmt.Sprintf("Calls to %s are blocked (prefix %s)", number, e.Prefix),
+			Code:    ErrCodeDestinationBlocked,
+		}, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// --- Blocklist handlers ---
+
+// GET /v1/blocklist
+func (h *APIHandler) ListBlocklist(w http.ResponseWriter, r *http.Request) {
+	contextFilter := r.URL.Query().Get("context")
+	rows := []*BlocklistEntry{}
+	for _, e := range h.blocklist.list() {
+		if contextFilter != "" && e.Context != contextFilter {
+			continue
+		}
+		// Entries for every tenant are shown to all of them
+		if e.Context == "" || isContextAllowed(r, e.Context) {
+			rows = append(rows, e)
+		}
+	}
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status":    "success",
+		"row_count": len(rows),
+		"rows":      rows,
+	})
+}
+
+// POST /v1/blocklist
+func (h *APIHandler) CreateBlocklistEntry(w http.ResponseWriter, r *http.Request) {
+	var entry BlocklistEntry
+	if !h.decodeRequest(w, r, &entry) {
+		return
+	}
+	if err := entry.validate(); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Only unrestricted callers may block numbers for every tenant
+	if entry.Context == "" {
+		if !isUnrestrictedAccess(r) {
+			h.respondError(w, r, "context is required without unrestricted access", http.StatusForbidden)
+			return
+		}
+	} else if !h.validateRequestContext(w, r, entry.Context) {
+		return
+	}
+	entry.ID = uuid.New().String()
+	entry.CreatedAt = time.Now().UTC()
+
+	h.blocklist.mu.Lock()
+	for _, e := range h.blocklist.entries {
+		if e.Context == entry.Context && e.Prefix == entry.Prefix {
+			h.blocklist.mu.Unlock()
+			h.respondError(w, r, fmt.Sprintf("Prefix %s is already blocked (%s)", entry.Prefix, e.ID), http.StatusConflict)
+			return
+		}
+	}
+	h.blocklist.entries[entry.ID] = &entry
+	err := h.blocklist.save()
+	h.blocklist.mu.Unlock()
+	if err != nil {
+		logWarn(getRequestID(r), fmt.Sprintf("Failed to persist blocklist: %v", err))
+	}
+
+	logInfo(getRequestID(r), fmt.Sprintf("Blocked prefix %s for context %q", entry.Prefix, entry.Context))
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   entry,
+	})
+}
+
+// DELETE /v1/blocklist/{id}
+func (h *APIHandler) DeleteBlocklistEntry(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	h.blocklist.mu.Lock()
+	e, ok := h.blocklist.entries[id]
+	h.blocklist.mu.Unlock()
+	if !ok || (e.Context != "" && !isContextAllowed(r, e.Context)) {
+		h.respondError(w, r, fmt.Sprintf("Blocklist entry %s not found", id), http.StatusNotFound)
+		return
+	}
+	if e.Context == "" && !isUnrestrictedAccess(r) {
+		h.respondError(w, r, "Entries for every tenant can only be deleted with unrestricted access", http.StatusForbidden)
+		return
+	}
+
+	h.blocklist.mu.Lock()
+	delete(h.blocklist.entries, id)
+	err := h.blocklist.save()
+	h.blocklist.mu.Unlock()
+	if err != nil {
+		logWarn(getRequestID(r), fmt.Sprintf("Failed to persist blocklist: %v", err))
+	}
+
+	logInfo(getRequestID(r), fmt.Sprintf("Unblocked prefix %s for context %q", e.Prefix, e.Context))
+	h.respondSuccess(w, r, fmt.Sprintf("Blocklist entry %s deleted", id))
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	businessHoursFile = "business_hours.json"
+
+	// How far ahead evaluate looks for the next opening or closing
+	hoursLookahead = 14 * 24 * time.Hour
+)
+
+// What a closed calendar does to a request
+const (
+	hoursActionAllow   = "allow"
+	hoursActionReject  = "reject"
+	hoursActionReroute = "reroute" // Transfers only: to after_hours_destination
+)
+
+// HoursWindow is a weekly opening window
+type HoursWindow struct {
+	Days  []string `json:"days"`  // sun, mon, tue, wed, thu, fri, sat
+	Open  string   `json:"open"`  // HH:MM
+	Close string   `json:"close"` // HH:MM, after open
+}
+
+// contains reports whether the local time falls within the window
+func (win *HoursWindow) contains(local time.Time) bool {
+	clock := local.Format(overflowClockTime)
+	return containsString(win.Days, overflowDays[local.Weekday()]) && clock >= win.Open && clock < win.Close
+}
+
+// HoursHoliday closes the calendar for a day, or opens it only between Open
+// and Close
+type HoursHoliday struct {
+	Date  string `json:"date"` // YYYY-MM-DD, or MM-DD for every year
+	Name  string `json:"name,omitempty"`
+	Open  string `json:"open,omitempty"` // HH:MM; empty = closed all day
+	Close string `json:"close,omitempty"`
+}
+
+// on reports whether the holiday falls on the local time's date
+func (hol *HoursHoliday) on(local time.Time) bool {
+	return hol.Date == local.Format("2006-01-02") || hol.Date == local.Format("01-02")
+}
+
+// HoursCalendar are the business hours and holidays of a tenant context,
+// and what happens to its originates and transfers while it is closed
+type HoursCalendar struct {
+	Context               string         `json:"context"`
+	Timezone              string         `json:"timezone"` // IANA name, e.g. Europe/Berlin
+	Hours                 []HoursWindow  `json:"hours"`
+	Holidays              []HoursHoliday `json:"holidays,omitempty"`
+	ClosedOriginate       string         `json:"closed_originate"`                  // allow (default) or reject
+	ClosedTransfer        string         `json:"closed_transfer"`                   // allow (default), reject or reroute
+	AfterHoursDestination string         `json:"after_hours_destination,omitempty"` // Extension in the context, e.g. an after-hours mailbox
+	CreatedAt             time.Time      `json:"created_at"`
+	UpdatedAt             time.Time      `json:"updated_at"`
+
+	loc *time.Location // Timezone, loaded when the calendar is validated or read from disk
+}
+
+// checkWindow validates an HH:MM open/close pair
+func checkWindow(field, open, closing string) error {
+	o, err1 := time.Parse(overflowClockTime, open)
+	c, err2 := time.Parse(overflowClockTime, closing)
+	if err1 != nil || err2 != nil || !c.After(o) {
+		return fmt.Errorf("%s.open and %s.close must be HH:MM with close after open", field, field)
+	}
+	return nil
+}
+
+// normalize lowercases day names and fills in the default actions
+func (cal *HoursCalendar) normalize() {
+	for i := range cal.Hours {
+		for j, day := range cal.Hours[i].Days {
+			cal.Hours[i].Days[j] = strings.ToLower(day)
+		}
+	}
+	if cal.ClosedOriginate == "" {
+		cal.ClosedOriginate = hoursActionAllow
+	}
+	if cal.ClosedTransfer == "" {
+		cal.ClosedTransfer = hoursActionAllow
+	}
+}
+
+// validate checks the calendar's settings and loads its time zone
+func (cal *HoursCalendar) validate() error {
+	loc, err := time.LoadLocation(cal.Timezone)
+	if err != nil || cal.Timezone == "" {
+		return fmt.Errorf("timezone must be an IANA time zone, e.g. Europe/Berlin")
+	}
+	cal.loc = loc
+	for i, win := range cal.Hours {
+		if len(win.Days) == 0 {
+			return fmt.Errorf("hours[%d].days must list at least one day", i)
+		}
+		for _, day := range win.Days {
+			if !containsString(overflowDays, day) {
+				return fmt.Errorf("hours[%d].days must be some of: %v", i, overflowDays)
+			}
+		}
+		if err := checkWindow(fmt.Sprintf("hours[%d]", i), win.Open, win.Close); err != nil {
+			return err
+		}
+	}
+	for i, hol := range cal.Holidays {
+		_, err1 := time.Parse("2006-01-02", hol.Date)
+		_, err2 := time.Parse("01-02", hol.Date)
+		if err1 != nil && err2 != nil {
+			return fmt.Errorf("holidays[%d].date must be YYYY-MM-DD or MM-DD", i)
+		}
+		if hol.Open != "" || hol.Close != "" {
+			if err := checkWindow(fmt.Sprintf("holidays[%d]", i), hol.Open, hol.Close); err != nil {
+				return err
+			}
+		}
+		if strings.ContainsAny(hol.Name, "\n\r") {
+			return fmt.Errorf("holidays[%d].name must be a single line", i)
+		}
+	}
+	if cal.ClosedOriginate != hoursActionAllow && cal.ClosedOriginate != hoursActionReject {
+		return fmt.Errorf("closed_originate must be allow or reject")
+	}
+	if !containsString([]string{hoursActionAllow, hoursActionReject, hoursActionReroute}, cal.ClosedTransfer) {
+		return fmt.Errorf("closed_transfer must be allow, reject or reroute")
+	}
+	if cal.AfterHoursDestination != "" && !userIDPattern.MatchString(cal.AfterHoursDestination) {
+		return fmt.Errorf("after_hours_destination must be an extension in the context (letters, digits and . _ + -)")
+	}
+	if cal.ClosedTransfer == hoursActionReroute && cal.AfterHoursDestination == "" {
+		return fmt.Errorf("closed_transfer reroute requires after_hours_destination")
+	}
+	return nil
+}
+
+// location returns the calendar's time zone
+func (cal *HoursCalendar) location() *time.Location {
+	if cal.loc != nil {
+		return cal.loc
+	}
+	loc, err := time.LoadLocation(cal.Timezone)
+	if err != nil {
+		// Validated when the calendar was saved
+		return time.UTC
+	}
+	return loc
+}
+
+// isOpen reports whether the calendar is open at the local time, and the
+// holiday that applies, if any
+func (cal *HoursCalendar) isOpen(local time.Time) (bool, *HoursHoliday) {
+	for i := range cal.Holidays {
+		hol := &cal.Holidays[i]
+		if !hol.on(local) {
+			continue
+		}
+		clock := local.Format(overflowClockTime)
+		return hol.Open != "" && clock >= hol.Open && clock < hol.Close, hol
+	}
+	for i := range cal.Hours {
+		if cal.Hours[i].contains(local) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// nextChange returns when the calendar next opens or closes after t, to the
+// minute, or nil beyond hoursLookahead
+func (cal *HoursCalendar) nextChange(t time.Time) *time.Time {
+	t = t.In(cal.location())
+	open, _ := cal.isOpen(t)
+	for at := t.Truncate(time.Minute).Add(time.Minute); at.Sub(t) <= hoursLookahead; at = at.Add(time.Minute) {
+		if o, _ := cal.isOpen(at); o != open {
+			at = at.UTC()
+			return &at
+		}
+	}
+	return nil
+}
+
+// HoursEvaluation is a calendar's state at a point in time
+type HoursEvaluation struct {
+	Context    string     `json:"context"`
+	At         time.Time  `json:"at"`
+	LocalTime  string     `json:"local_time"` // At in the calendar's time zone
+	Open       bool       `json:"open"`
+	Holiday    string     `json:"holiday,omitempty"`     // Name (or date) of the holiday that applies
+	NextChange *time.Time `json:"next_change,omitempty"` // Only filled in by withNextChange
+	Originate  string     `json:"originate"`             // What happens to an originate: allow or reject
+	Transfer   string     `json:"transfer"`              // What happens to a transfer: allow, reject or reroute
+	// Where rerouted transfers and originates without a B-leg go while closed
+	AfterHoursDestination string `json:"after_hours_destination,omitempty"`
+}
+
+// evaluate returns the calendar's state at t. It leaves NextChange empty,
+// since finding it scans the calendar minute by minute.
+func (cal *HoursCalendar) evaluate(t time.Time) HoursEvaluation {
+	local := t.In(cal.location())
+	open, hol := cal.isOpen(local)
+	ev := HoursEvaluation{
+		Context:   cal.Context,
+		At:        t.UTC(),
+		LocalTime: local.Format(time.RFC3339),
+		Open:      open,
+		Originate: hoursActionAllow,
+		Transfer:  hoursActionAllow,
+	}
+	if hol != nil {
+		ev.Holiday = hol.Name
+		if ev.Holiday == "" {
+			ev.Holiday = hol.Date
+		}
+	}
+	if !open {
+		ev.Originate = cal.ClosedOriginate
+		ev.Transfer = cal.ClosedTransfer
+		ev.AfterHoursDestination = cal.AfterHoursDestination
+	}
+	return ev
+}
+
+// withNextChange returns the evaluation with NextChange filled in
+func (cal *HoursCalendar) withNextChange(ev HoursEvaluation) HoursEvaluation {
+	ev.NextChange = cal.nextChange(ev.At)
+	return ev
+}
+
+// businessHours is the persisted set of calendars, keyed by context
+type businessHours struct {
+	mu        sync.Mutex
+	calendars map[string]*HoursCalendar
+}
+
+// newBusinessHours loads the calendars from FSAPI_DATA_DIR
+func newBusinessHours() *businessHours {
+	bh := &businessHours{calendars: make(map[string]*HoursCalendar)}
+	var calendars []*HoursCalendar
+	if err := loadJSONFile(businessHoursFile, &calendars); err != nil {
+		log.Printf("WARNING: Failed to load business hours: %v", err)
+	}
+	for _, cal := range calendars {
+		cal.loc = cal.location()
+		bh.calendars[cal.Context] = cal
+	}
+	return bh
+}
+
+// list returns the calendars sorted by context
+func (bh *businessHours) list() []*HoursCalendar {
+	bh.mu.Lock()
+	defer bh.mu.Unlock()
+	return bh.sorted()
+}
+
+// sorted returns the calendars sorted by context. Caller must hold mu.
+func (bh *businessHours) sorted() []*HoursCalendar {
+	calendars := make([]*HoursCalendar, 0, len(bh.calendars))
+	for _, cal := range bh.calendars {
+		calendars = append(calendars, cal)
+	}
+	sort.Slice(calendars, func(i, j int) bool { return calendars[i].Context < calendars[j].Context })
+	return calendars
+}
+
+// save persists the calendars. Caller must hold mu.
+func (bh *businessHours) save() error {
+	return saveJSONFile(businessHoursFile, bh.sorted())
+}
+
+// get returns the calendar of context, or nil
+func (bh *businessHours) get(context string) *HoursCalendar {
+	bh.mu.Lock()
+	defer bh.mu.Unlock()
+	return bh.calendars[context]
+}
+
+// evaluate returns the state of the calendar of context now, or nil when
+// the context has none
+func (bh *businessHours) evaluate(context string) *HoursEvaluation {
+	if context == "" {
+		return nil
+	}
+	cal := bh.get(context)
+	if cal == nil {
+		return nil
+	}
+	ev := cal.evaluate(time.Now())
+	return &ev
+}
+
+// respondClosed writes the 403 for a request refused outside business hours
+func (h *APIHandler) respondClosed(w http.ResponseWriter, r *http.Request, ev *HoursEvaluation) {
+	if cal := h.hours.get(ev.Context); cal != nil && ev.NextChange == nil {
+		next := cal.withNextChange(*ev)
+		ev = &next
+	}
+	message := fmt.Sprintf("Context %s is closed", ev.Context)
+	if ev.Holiday != "" {
+		message += fmt.Sprintf(" (%s)", ev.Holiday)
+	}
+	if ev.NextChange != nil {
+		message += fmt.Sprintf(" until %s", ev.NextChange.Format(time.RFC3339))
+	}
+	h.respondErrorBody(w, r, ErrorResponse{
+		Status:  "error",
+		Message: message,
+		Code:    ErrCodeOutsideHours,
+	}, http.StatusForbidden)
+}
+
+// --- Business hours handlers ---
+
+// GET /v1/policies/hours
+func (h *APIHandler) ListBusinessHours(w http.ResponseWriter, r *http.Request) {
+	rows := []*HoursCalendar{}
+	for _, cal := range h.hours.list() {
+		if isContextAllowed(r, cal.Context) {
+			rows = append(rows, cal)
+		}
+	}
+	h.respondJSON(w, r, map[string]interface{}{
+		"status":    "success",
+		"row_count": len(rows),
+		"rows":      rows,
+	})
+}
+
+// lookupHours returns the calendar of the context in the URL if the caller
+// may see it
+func (h *APIHandler) lookupHours(w http.ResponseWriter, r *http.Request, context string) (*HoursCalendar, bool) {
+	cal := h.hours.get(context)
+	if cal == nil || !isContextAllowed(r, context) {
+		h.respondError(w, r, fmt.Sprintf("No business hours for context %s", context), http.StatusNotFound)
+		return nil, false
+	}
+	return cal, true
+}
+
+// GET /v1/policies/hours/{context}
+func (h *APIHandler) GetBusinessHours(w http.ResponseWriter, r *http.Request) {
+	cal, ok := h.lookupHours(w, r, mux.Vars(r)["context"])
+	if !ok {
+		return
+	}
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   cal,
+	})
+}
+
+// PUT /v1/policies/hours/{context}
+func (h *APIHandler) SetBusinessHours(w http.ResponseWriter, r *http.Request) {
+	context := mux.Vars(r)["context"]
+	if !domainPattern.MatchString(context) {
+		h.respondError(w, r, "context must be a domain", http.StatusBadRequest)
+		return
+	}
+	if !h.validateRequestContext(w, r, context) {
+		return
+	}
+
+	var cal HoursCalendar
+	if !h.decodeRequest(w, r, &cal) {
+		return
+	}
+	if cal.Context != "" && cal.Context != context {
+		h.respondError(w, r, "context does not match the URL", http.StatusBadRequest)
+		return
+	}
+	cal.Context = context
+	cal.normalize()
+	if err := cal.validate(); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().UTC()
+	cal.CreatedAt = now
+	cal.UpdatedAt = now
+	h.hours.mu.Lock()
+	if existing, ok := h.hours.calendars[context]; ok {
+		cal.CreatedAt = existing.CreatedAt
+	}
+	h.hours.calendars[context] = &cal
+	err := h.hours.save()
+	h.hours.mu.Unlock()
+	if err != nil {
+		logWarn(getRequestID(r), fmt.Sprintf("Failed to persist business hours: %v", err))
+	}
+
+	logInfo(getRequestID(r), fmt.Sprintf("Business hours of %s set", context))
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   cal,
+	})
+}
+
+// DELETE /v1/policies/hours/{context}
+func (h *APIHandler) DeleteBusinessHours(w http.ResponseWriter, r *http.Request) {
+	context := mux.Vars(r)["context"]
+	if _, ok := h.lookupHours(w, r, context); !ok {
+		return
+	}
+
+	h.hours.mu.Lock()
+	delete(h.hours.calendars, context)
+	err := h.hours.save()
+	h.hours.mu.Unlock()
+	if err != nil {
+		logWarn(getRequestID(r), fmt.Sprintf("Failed to persist business hours: %v", err))
+	}
+	h.respondSuccess(w, r, fmt.Sprintf("Business hours of %s deleted", context))
+}
+
+// GET /v1/policies/hours/evaluate?context=name[&at=RFC3339]
+func (h *APIHandler) EvaluateBusinessHours(w http.ResponseWriter, r *http.Request) {
+	context := r.URL.Query().Get("context")
+	if context == "" {
+		h.respondError(w, r, "context is required", http.StatusBadRequest)
+		return
+	}
+	at := time.Now()
+	if v := r.URL.Query().Get("at"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			h.respondError(w, r, "at must be an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		at = t
+	}
+	cal, ok := h.lookupHours(w, r, context)
+	if !ok {
+		return
+	}
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   cal.withNextChange(cal.evaluate(at)),
+	})
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHoursCalendarIsOpen(t *testing.T) {
+	cal := &HoursCalendar{
+		Context:  "acme.example",
+		Timezone: "Europe/Berlin",
+		Hours: []HoursWindow{
+			{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Open: "09:00", Close: "17:00"},
+		},
+		Holidays: []HoursHoliday{
+			{Date: "12-25", Name: "Christmas"},
+			{Date: "2026-12-24", Name: "Christmas Eve", Open: "09:00", Close: "12:00"},
+			{Date: "2026-12-19", Name: "Inventory", Open: "10:00", Close: "14:00"},
+		},
+	}
+	cal.normalize()
+	if err := cal.validate(); err != nil {
+		t.Fatalf("validate() = %v", err)
+	}
+	loc := cal.location()
+	at := func(date, clock string) time.Time {
+		tm, err := time.ParseInLocation("2006-01-02 15:04", date+" "+clock, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+
+	tests := []struct {
+		name    string
+		at      time.Time
+		open    bool
+		holiday string
+	}{
+		{"weekday during hours", at("2026-12-21", "10:00"), true, ""},
+		{"weekday at opening", at("2026-12-21", "09:00"), true, ""},
+		{"weekday at closing", at("2026-12-21", "17:00"), false, ""},
+		{"weekday before hours", at("2026-12-21", "08:59"), false, ""},
+		{"weekend", at("2026-12-20", "10:00"), false, ""},
+		{"holiday closed all day on a weekday", at("2026-12-25", "10:00"), false, "Christmas"},
+		{"yearly holiday in another year", at("2025-12-25", "10:00"), false, "Christmas"},
+		{"holiday with shorter hours, open", at("2026-12-24", "11:59"), true, "Christmas Eve"},
+		{"holiday with shorter hours, after its close", at("2026-12-24", "13:00"), false, "Christmas Eve"},
+		{"dated holiday only in its year", at("2027-12-24", "13:00"), true, ""},
+		{"holiday opens a weekend day", at("2026-12-19", "12:00"), true, "Inventory"},
+		{"holiday hours replace weekly hours", at("2026-12-19", "09:30"), false, "Inventory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			open, hol := cal.isOpen(tt.at)
+			name := ""
+			if hol != nil {
+				name = hol.Name
+			}
+			if open != tt.open || name != tt.holiday {
+				t.Errorf("isOpen(%s) = %v, %q; want %v, %q", tt.at.Format(time.RFC3339), open, name, tt.open, tt.holiday)
+			}
+		})
+	}
+
+	// evaluate works in the calendar's time zone whatever zone t is in
+	if ev := cal.evaluate(at("2026-12-21", "10:00").UTC()); !ev.Open {
+		t.Errorf("evaluate() at 10:00 Berlin in UTC: open = false, want true")
+	}
+}
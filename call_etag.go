@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// uuid_dump headers making up a call's state version. Channel variables
+// are left out: fs-api itself sets some on every mutating request.
+var callStateFields = []string{
+	"Channel-State", "Channel-Call-State", "Answer-State", "Other-Leg-Unique-ID",
+	"Caller-Context", "Caller-Destination-Number", "Caller-Caller-ID-Number", "Caller-Callee-ID-Number",
+	"variable_current_application", "variable_current_application_data",
+}
+
+// callETag returns the strong ETag of a call's state: its uuid_dump state
+// headers and the files it is being recorded to
+func callETag(dump map[string]interface{}, recordings []string) string {
+	hash := sha256.New()
+	for _, field := range callStateFields {
+		v, _ := dump[field].(string)
+		fmt.Fprintf(hash, "%s=%s\n", field, v)
+	}
+	fmt.Fprintf(hash, "recordings=%s\n", strings.Join(recordings, "|"))
+	return `"` + hex.EncodeToString(hash.Sum(nil))[:16] + `"`
+}
+
+// etagMatches reports whether an If-Match header value lists etag, or is "*".
+// Weak tags never match, as If-Match uses strong comparison.
+func etagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCallPrecondition writes a 412 when a mutating request on the call in
+// its URL carries an If-Match that no longer matches the call's state, so
+// two clients acting on the same call do not race. Requests on other calls
+// (the legs of a bridge) are not checked.
+func (h *APIHandler) checkCallPrecondition(w http.ResponseWriter, r *http.Request, callInfo *CallContextInfo) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" || r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return true
+	}
+	if !strings.EqualFold(mux.Vars(r)["uuid"], callInfo.UUID) || etagMatches(header, callInfo.ETag) {
+		return true
+	}
+	w.Header().Set("ETag", callInfo.ETag)
+	h.respondErrorBody(w, r, ErrorResponse{
+		Status:  "error",
+		Message: fmt.Sprintf("Call %s changed since it was read (now %s)", callInfo.UUID, callInfo.ETag),
+		Code:    ErrCodeCallStateChanged,
+	}, http.StatusPreconditionFailed)
+	return false
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// How long a mutating request waits for other requests on the same calls
+// before it is refused
+const callLockWait = 10 * time.Second
+
+// callLock serializes the requests on one call UUID
+type callLock struct {
+	sem  chan struct{} // Holds a token while a request runs
+	refs int           // Requests holding or waiting for it
+}
+
+// callLocks serializes concurrent mutating API requests on the same call, so
+// their ESL commands (a transfer and a bridge, say) are not interleaved.
+// Locks exist only while requests hold or wait for them.
+type callLocks struct {
+	mu    sync.Mutex
+	locks map[string]*callLock
+
+	waits *counterVec
+}
+
+func newCallLocks(metrics *metricsRegistry) *callLocks {
+	return &callLocks{
+		locks: make(map[string]*callLock),
+		waits: metrics.counter("fsapi_call_lock_waits_total", "Mutating requests that waited for another request on the same call, by outcome.", "outcome"),
+	}
+}
+
+// ref returns the lock of id, counting the caller in
+func (l *callLocks) ref(id string) *callLock {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lock, ok := l.locks[id]
+	if !ok {
+		lock = &callLock{sem: make(chan struct{}, 1)}
+		l.locks[id] = lock
+	}
+	lock.refs++
+	return lock
+}
+
+// unref counts the caller out of the lock of id, dropping it when unused
+func (l *callLocks) unref(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if lock := l.locks[id]; lock != nil {
+		lock.refs--
+		if lock.refs == 0 {
+			delete(l.locks, id)
+		}
+	}
+}
+
+// acquire locks every call in ids, in sorted order so two requests naming
+// the same calls cannot deadlock. It returns the function releasing them,
+// or false when ctx ends first.
+func (l *callLocks) acquire(ctx context.Context, ids []string) (func(), bool) {
+	var held []string
+	release := func() {
+		for i := len(held) - 1; i >= 0; i-- {
+			l.mu.Lock()
+			lock := l.locks[held[i]]
+			l.mu.Unlock()
+			<-lock.sem
+			l.unref(held[i])
+		}
+	}
+	waited := false
+	for _, id := range ids {
+		lock := l.ref(id)
+		select {
+		case lock.sem <- struct{}{}:
+		default:
+			waited = true
+			select {
+			case lock.sem <- struct{}{}:
+			case <-ctx.Done():
+				l.unref(id)
+				release()
+				l.waits.inc("timeout")
+				return nil, false
+			}
+		}
+		held = append(held, id)
+	}
+	if waited {
+		l.waits.inc("acquired")
+	}
+	return release, true
+}
+
+// lockedCalls returns the call UUIDs a mutating request acts on: the {uuid}
+// in its URL and, for a bridge, both legs named in its body. They are
+// lowercased, sorted and without duplicates. Other UUIDs in a body, such as
+// an originate's origination_uuid or a GraphQL filter, are not locked.
+func lockedCalls(r *http.Request) []string {
+	var ids []string
+	if callUUID := mux.Vars(r)["uuid"]; callUUID != "" {
+		ids = append(ids, callUUID)
+	}
+	if r.Method == http.MethodPost && r.URL.Path == "/v1/calls/bridge" {
+		var req BridgeRequest
+		if body := peekRawBody(r); body != nil && json.Unmarshal(body, &req) == nil {
+			for _, id := range []string{req.UUIDA, req.UUIDB} {
+				if validateUUID(id) == nil {
+					ids = append(ids, id)
+				}
+			}
+		}
+	}
+	seen := make(map[string]bool, len(ids))
+	unique := ids[:0]
+	for _, id := range ids {
+		id = strings.ToLower(id)
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
+
+// callLockMiddleware runs mutating requests on the same call one at a time.
+// A request that cannot get its calls within callLockWait gets a 409.
+func (h *APIHandler) callLockMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ids := lockedCalls(r)
+		if len(ids) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), callLockWait)
+		release, ok := h.callLocks.acquire(ctx, ids)
+		cancel()
+		if !ok {
+			if r.Context().Err() != nil {
+				return // Client went away
+			}
+			w.Header().Set("Retry-After", "1")
+			h.respondErrorBody(w, r, ErrorResponse{
+				Status:     "error",
+				Message:    fmt.Sprintf("Another request on call %s is still running", strings.Join(ids, ", ")),
+				Code:       ErrCodeCallBusy,
+				RetryAfter: 1,
+			}, http.StatusConflict)
+			return
+		}
+		defer release()
+		next.ServeHTTP(w, r)
+	})
+}
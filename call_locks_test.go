@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestLockedCalls(t *testing.T) {
+	const (
+		a = "11111111-1111-1111-1111-111111111111"
+		b = "22222222-2222-2222-2222-222222222222"
+	)
+	tests := []struct {
+		name   string
+		target string
+		vars   map[string]string
+		body   string
+		want   []string
+	}{
+		{"call in the URL", "/v1/calls/" + a + "/hangup", map[string]string{"uuid": a}, `{"cause":"NORMAL_CLEARING"}`, []string{a}},
+		{"URL UUID lowercased", "/v1/calls/" + strings.ToUpper(a) + "/hangup", map[string]string{"uuid": strings.ToUpper(a)}, "", []string{a}},
+		{"both bridge legs", "/v1/calls/bridge", nil, `{"uuid_a":"` + b + `","uuid_b":"` + a + `"}`, []string{a, b}},
+		{"bridge leg that is not a UUID", "/v1/calls/bridge", nil, `{"uuid_a":"` + a + `","uuid_b":"nope"}`, []string{a}},
+		{"originate with origination_uuid", "/v1/calls/originate", nil, `{"aleg":"user/1000","channel_variables":{"origination_uuid":"` + a + `"}}`, nil},
+		{"GraphQL filter", "/v1/graphql", nil, `{"query":"{ call(uuid: \"` + a + `\") { uuid } }"}`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.target, strings.NewReader(tt.body))
+			if tt.vars != nil {
+				req = mux.SetURLVars(req, tt.vars)
+			}
+			if got := lockedCalls(req); !reflect.DeepEqual(got, tt.want) && (len(got) != 0 || len(tt.want) != 0) {
+				t.Errorf("lockedCalls() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
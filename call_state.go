@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// CallState is the controllable state of a call
+type CallState struct {
+	Held       bool     `json:"held"`
+	Parked     bool     `json:"parked"`
+	Recording  bool     `json:"recording"`
+	Recordings []string `json:"recordings"` // Files being recorded
+}
+
+// CallStateRequest declares the state a call should be in. Omitted fields
+// are left as they are.
+type CallStateRequest struct {
+	Held          *bool  `json:"held,omitempty"`
+	Parked        *bool  `json:"parked,omitempty"`
+	Recording     *bool  `json:"recording,omitempty"`
+	RecordingFile string `json:"recording_file,omitempty"` // File to record to; with it, recording is met only by this file
+}
+
+// CallStateResult is the data of PUT /v1/calls/{uuid}/state
+type CallStateResult struct {
+	UUID    string              `json:"uuid"`
+	State   CallState           `json:"state"`   // After the actions
+	Changed bool                `json:"changed"` // False when the call already was in the state
+	Actions []*CallActionResult `json:"actions"` // Commands sent, in order
+}
+
+// callState reads the state of callUUID: hold and park from uuid_dump,
+// recordings from the call's trace (uuid_record commands, and RECORD_START
+// and RECORD_STOP events with the event stream)
+func (h *APIHandler) callState(callUUID string) (*CallState, error) {
+	response, err := h.eslClient.SendCommand(fmt.Sprintf("api uuid_dump %s json", callUUID))
+	if err != nil {
+		return nil, err
+	}
+	var dump map[string]interface{}
+	if err := json.Unmarshal([]byte(response), &dump); err != nil {
+		return nil, fmt.Errorf("failed to parse uuid_dump: %v", err)
+	}
+	str := func(key string) string {
+		v, _ := dump[key].(string)
+		return v
+	}
+	state := &CallState{
+		Held: str("Channel-Call-State") == "HELD",
+		// uuid_park puts the channel in CS_PARK; the park dialplan app runs in CS_EXECUTE
+		Parked:     str("Channel-State") == "CS_PARK" || str("variable_current_application") == "park",
+		Recordings: h.traces.activeRecordings(strings.ToLower(callUUID)),
+	}
+	state.Recording = len(state.Recordings) > 0
+	return state, nil
+}
+
+// GET /v1/calls/{uuid}/state
+func (h *APIHandler) GetCallState(w http.ResponseWriter, r *http.Request) {
+	callUUID := mux.Vars(r)["uuid"]
+	if err := validateUUID(callUUID); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	callInfo, ok := h.validateCallContext(w, r, callUUID)
+	if !ok {
+		return
+	}
+
+	state, err := h.callState(callUUID)
+	if err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to read call state: %v", err), err)
+		return
+	}
+	w.Header().Set("ETag", callInfo.ETag)
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   state,
+	})
+}
+
+// PUT /v1/calls/{uuid}/state
+//
+// Sends only the commands needed to bring the call into the declared state,
+// so orchestrators can repeat the request safely: a call already in the
+// state gets none.
+func (h *APIHandler) EnsureCallState(w http.ResponseWriter, r *http.Request) {
+	callUUID := mux.Vars(r)["uuid"]
+	if err := validateUUID(callUUID); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, ok := h.validateCallContext(w, r, callUUID); !ok {
+		return
+	}
+
+	var req CallStateRequest
+	if !h.decodeRequest(w, r, &req) {
+		return
+	}
+	if req.Held == nil && req.Parked == nil && req.Recording == nil {
+		h.respondError(w, r, "at least one of held, parked or recording is required", http.StatusBadRequest)
+		return
+	}
+	if req.RecordingFile != "" {
+		if req.Recording == nil || !*req.Recording {
+			h.respondError(w, r, "recording_file requires recording: true", http.StatusBadRequest)
+			return
+		}
+		if err := validateFilePath(req.RecordingFile); err != nil {
+			h.respondError(w, r, fmt.Sprintf("Invalid recording_file: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	state, err := h.callState(callUUID)
+	if err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to read call state: %v", err), err)
+		return
+	}
+
+	// Work out the commands before sending any, so an impossible state is
+	// refused without changing the call
+	type step struct {
+		action string
+		cmd    string
+		params map[string]interface{}
+	}
+	var steps []step
+	if req.Parked != nil && *req.Parked != state.Parked {
+		if !*req.Parked {
+			h.respondError(w, r, "A parked call leaves park by transfer or bridge, not by state", http.StatusConflict)
+			return
+		}
+		steps = append(steps, step{"park", fmt.Sprintf("api uuid_park %s", callUUID), nil})
+	}
+	if req.Held != nil && *req.Held != state.Held {
+		if *req.Held {
+			steps = append(steps, step{"hold", fmt.Sprintf("api uuid_hold %s", callUUID), nil})
+		} else {
+			steps = append(steps, step{"unhold", fmt.Sprintf("api uuid_hold off %s", callUUID), nil})
+		}
+	}
+	if req.Recording != nil {
+		switch {
+		case *req.Recording && req.RecordingFile != "" && !containsString(state.Recordings, req.RecordingFile):
+			steps = append(steps, step{"record_start", fmt.Sprintf("api uuid_record %s start %s", callUUID, req.RecordingFile),
+				map[string]interface{}{"filename": req.RecordingFile}})
+		case *req.Recording && req.RecordingFile == "" && !state.Recording:
+			h.respondError(w, r, "recording_file is required to start recording", http.StatusBadRequest)
+			return
+		case !*req.Recording && state.Recording:
+			steps = append(steps, step{"record_stop", fmt.Sprintf("api uuid_record %s stop all", callUUID), nil})
+		}
+	}
+
+	result := &CallStateResult{UUID: callUUID, Changed: len(steps) > 0, Actions: []*CallActionResult{}}
+	for _, s := range steps {
+		action, err := h.runCallAction(callUUID, s.action, s.cmd, s.params)
+		if err != nil {
+			// Steps already sent stay applied; repeating the request completes the rest
+			h.respondESLError(w, r, fmt.Sprintf("Failed to %s call: %v", strings.ReplaceAll(s.action, "_", " "), err), err)
+			return
+		}
+		result.Actions = append(result.Actions, action)
+	}
+
+	message := fmt.Sprintf("Call %s already in the requested state", callUUID)
+	if result.Changed {
+		if state, err = h.callState(callUUID); err != nil {
+			h.respondESLError(w, r, fmt.Sprintf("Failed to read call state: %v", err), err)
+			return
+		}
+		var actions []string
+		for _, s := range steps {
+			actions = append(actions, s.action)
+		}
+		message = fmt.Sprintf("Call %s brought into the requested state (%s)", callUUID, strings.Join(actions, ", "))
+	}
+	result.State = *state
+
+	logInfo(getRequestID(r), message)
+	h.respondJSON(w, r, SuccessResponse{
+		Status:  "success",
+		Message: message,
+		Data:    result,
+	})
+}
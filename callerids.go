@@ -0,0 +1,373 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	callerIDsFile = "caller_ids.json"
+
+	// Session scope letting an unrestricted session present any caller ID.
+	// Unrestricted tokens hold it implicitly.
+	callerIDOverrideScope = "callerids:override"
+)
+
+// Channel variables that set the caller ID an originate presents
+var callerIDVars = []string{"origination_caller_id_number", "effective_caller_id_number"}
+
+// SIP headers, as sip_h_ channel variables, that assert the caller's
+// identity to the far end. Names are matched ignoring case.
+var callerIDHeaderVars = []string{"sip_h_P-Asserted-Identity", "sip_h_P-Preferred-Identity", "sip_h_Remote-Party-ID"}
+
+// identityURIUser matches the user part of a sip:, sips: or tel: URI
+var identityURIUser = regexp.MustCompile(`(?i)(?:sips?|tel):([^@;>]+)`)
+
+// CallerID is a number a tenant may present as caller ID
+type CallerID struct {
+	Number      string    `json:"number"`
+	Context     string    `json:"context"` // Tenant context (domain) the number is allowed for
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// id is the entry's key and URL name, number@context
+func (c *CallerID) id() string {
+	return c.Number + "@" + c.Context
+}
+
+// callerIDs is the persisted caller ID allowlist, keyed by number@context
+type callerIDs struct {
+	mu      sync.Mutex
+	numbers map[string]*CallerID
+}
+
+// newCallerIDs loads the caller ID allowlist from FSAPI_DATA_DIR
+func newCallerIDs() *callerIDs {
+	c := &callerIDs{numbers: make(map[string]*CallerID)}
+	var entries []*CallerID
+	if err := loadJSONFile(callerIDsFile, &entries); err != nil {
+		log.Printf("WARNING: Failed to load caller IDs: %v", err)
+	}
+	for _, e := range entries {
+		c.numbers[e.id()] = e
+	}
+	return c
+}
+
+// list returns the entries sorted by context, then number
+func (c *callerIDs) list() []*CallerID {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sorted()
+}
+
+// sorted returns the entries sorted by context, then number. Caller must hold mu.
+func (c *callerIDs) sorted() []*CallerID {
+	entries := make([]*CallerID, 0, len(c.numbers))
+	for _, e := range c.numbers {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Context != entries[j].Context {
+			return entries[i].Context < entries[j].Context
+		}
+		return entries[i].Number < entries[j].Number
+	})
+	return entries
+}
+
+// save persists the allowlist. Caller must hold mu.
+func (c *callerIDs) save() error {
+	return saveJSONFile(callerIDsFile, c.sorted())
+}
+
+// allowed reports whether one of contexts has an allowlist, and if so
+// whether number is on one of them. Numbers match with or without a
+// leading '+'.
+func (c *callerIDs) allowed(number string, contexts []string) (enforced, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	number = strings.TrimPrefix(number, "+")
+	for _, e := range c.numbers {
+		if !containsString(contexts, e.Context) {
+			continue
+		}
+		enforced = true
+		if strings.TrimPrefix(e.Number, "+") == number {
+			return true, true
+		}
+	}
+	return enforced, false
+}
+
+// validate checks the entry
+func (c *CallerID) validate() error {
+	if !didNumberPattern.MatchString(c.Number) {
+		return fmt.Errorf("number must be 3-20 digits with an optional leading '+'")
+	}
+	if !domainPattern.MatchString(c.Context) {
+		return fmt.Errorf("context is required")
+	}
+	if strings.ContainsAny(c.Description, "\n\r") {
+		return fmt.Errorf("description must be a single line")
+	}
+	return nil
+}
+
+// callerIDOverride reports whether the caller may present any caller ID:
+// unrestricted tokens can, unrestricted sessions need callerids:override
+func callerIDOverride(r *http.Request) bool {
+	if !isUnrestrictedAccess(r) {
+		return false
+	}
+	if session, ok := getSession(r); ok {
+		return session.allows(callerIDOverrideScope)
+	}
+	return true
+}
+
+// presentedCallerIDs returns the caller ID numbers an originate presents:
+// caller_id_number, and the caller ID variables and identity headers set in
+// channel_variables or the variable blocks of either leg's dial string
+func presentedCallerIDs(req *OriginateRequest) []string {
+	var numbers []string
+	if req.CallerIDNumber != "" {
+		numbers = append(numbers, req.CallerIDNumber)
+	}
+	names := make([]string, 0, len(req.ChannelVariables))
+	for name := range req.ChannelVariables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	vars := make([][2]string, 0, len(names))
+	for _, name := range names {
+		vars = append(vars, [2]string{name, fmt.Sprint(req.ChannelVariables[name])})
+	}
+	vars = append(vars, dialStringVars(req.ALeg)...)
+	vars = append(vars, dialStringVars(req.BLeg)...)
+
+	for _, kv := range vars {
+		name, value := kv[0], kv[1]
+		if containsString(callerIDVars, name) {
+			numbers = append(numbers, value)
+			continue
+		}
+		for _, header := range callerIDHeaderVars {
+			if strings.EqualFold(name, header) {
+				numbers = append(numbers, identityNumber(value))
+				break
+			}
+		}
+	}
+	return numbers
+}
+
+// identityNumber is the user part of the URI in an identity header value,
+// or the whole value when it has none, which no allowlist entry matches
+func identityNumber(value string) string {
+	if m := identityURIUser.FindStringSubmatch(value); m != nil {
+		return m[1]
+	}
+	return value
+}
+
+// checkCallerIDs writes a 403 and an audit entry when an originate presents
+// a caller ID that is not on the allowlist of its tenant: the request's
+// context, or the contexts a restricted caller acts for
+func (h *APIHandler) checkCallerIDs(w http.ResponseWriter, r *http.Request, req *OriginateRequest) bool {
+	if callerIDOverride(r) {
+		return true
+	}
+	contexts := []string{req.Context}
+	if req.Context == "" {
+		contexts = getAllowedContexts(r)
+	}
+	for _, number := range presentedCallerIDs(req) {
+		enforced, ok := h.callerIDs.allowed(number, contexts)
+		if !enforced {
+			return true
+		}
+		if ok {
+			continue
+		}
+		h.audit(r, AuditEntry{
+			Action:  "call.callerid_rejected",
+			Target:  number,
+			Context: strings.Join(contexts, ","),
+			Details: map[string]string{"operation": "originate"},
+		})
+		h.respondErrorBody(w, r, ErrorResponse{
+			Status:  "error",
+			Message: fmt.Sprintf("Caller ID %s is not allowed for context %s", number, strings.Join(contexts, ", ")),
+			Code:    ErrCodeCallerIDNotAllowed,
+		}, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// --- Caller ID handlers ---
+
+// requireCallerIDAdmin keeps tenants from allowing themselves numbers: they
+// can only read their allowlists
+func (h *APIHandler) requireCallerIDAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if isUnrestrictedAccess(r) {
+		return true
+	}
+	h.respondError(w, r, "Caller IDs can only be managed with unrestricted access", http.StatusForbidden)
+	return false
+}
+
+// lookupCallerID resolves {id}, hiding entries of other tenants
+func (h *APIHandler) lookupCallerID(w http.ResponseWriter, r *http.Request) (*CallerID, bool) {
+	id := mux.Vars(r)["id"]
+	h.callerIDs.mu.Lock()
+	e, ok := h.callerIDs.numbers[id]
+	h.callerIDs.mu.Unlock()
+	if !ok || !isContextAllowed(r, e.Context) {
+		h.respondError(w, r, fmt.Sprintf("Caller ID %s not found", id), http.StatusNotFound)
+		return nil, false
+	}
+	return e, true
+}
+
+// GET /v1/callerids
+func (h *APIHandler) ListCallerIDs(w http.ResponseWriter, r *http.Request) {
+	contextFilter := r.URL.Query().Get("context")
+	rows := []*CallerID{}
+	for _, e := range h.callerIDs.list() {
+		if contextFilter != "" && e.Context != contextFilter {
+			continue
+		}
+		if isContextAllowed(r, e.Context) {
+			rows = append(rows, e)
+		}
+	}
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status":    "success",
+		"row_count": len(rows),
+		"rows":      rows,
+	})
+}
+
+// POST /v1/callerids
+func (h *APIHandler) CreateCallerID(w http.ResponseWriter, r *http.Request) {
+	var entry CallerID
+	if !h.decodeRequest(w, r, &entry) {
+		return
+	}
+	if err := entry.validate(); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !h.requireCallerIDAdmin(w, r) {
+		return
+	}
+	entry.CreatedAt = time.Now().UTC()
+	entry.UpdatedAt = entry.CreatedAt
+
+	h.callerIDs.mu.Lock()
+	if _, exists := h.callerIDs.numbers[entry.id()]; exists {
+		h.callerIDs.mu.Unlock()
+		h.respondError(w, r, fmt.Sprintf("Caller ID %s already exists", entry.id()), http.StatusConflict)
+		return
+	}
+	h.callerIDs.numbers[entry.id()] = &entry
+	err := h.callerIDs.save()
+	h.callerIDs.mu.Unlock()
+	if err != nil {
+		logWarn(getRequestID(r), fmt.Sprintf("Failed to persist caller IDs: %v", err))
+	}
+
+	logInfo(getRequestID(r), fmt.Sprintf("Allowed caller ID %s for context %s", entry.Number, entry.Context))
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   entry,
+	})
+}
+
+// GET /v1/callerids/{id}
+func (h *APIHandler) GetCallerID(w http.ResponseWriter, r *http.Request) {
+	e, ok := h.lookupCallerID(w, r)
+	if !ok {
+		return
+	}
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   e,
+	})
+}
+
+// PUT /v1/callerids/{id}
+func (h *APIHandler) UpdateCallerID(w http.ResponseWriter, r *http.Request) {
+	if !h.requireCallerIDAdmin(w, r) {
+		return
+	}
+	existing, ok := h.lookupCallerID(w, r)
+	if !ok {
+		return
+	}
+
+	var entry CallerID
+	if !h.decodeRequest(w, r, &entry) {
+		return
+	}
+	if (entry.Number != "" && entry.Number != existing.Number) || (entry.Context != "" && entry.Context != existing.Context) {
+		h.respondError(w, r, "number and context cannot be changed", http.StatusBadRequest)
+		return
+	}
+	entry.Number = existing.Number
+	entry.Context = existing.Context
+	if err := entry.validate(); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entry.CreatedAt = existing.CreatedAt
+	entry.UpdatedAt = time.Now().UTC()
+
+	h.callerIDs.mu.Lock()
+	h.callerIDs.numbers[entry.id()] = &entry
+	err := h.callerIDs.save()
+	h.callerIDs.mu.Unlock()
+	if err != nil {
+		logWarn(getRequestID(r), fmt.Sprintf("Failed to persist caller IDs: %v", err))
+	}
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   entry,
+	})
+}
+
+// DELETE /v1/callerids/{id}
+func (h *APIHandler) DeleteCallerID(w http.ResponseWriter, r *http.Request) {
+	if !h.requireCallerIDAdmin(w, r) {
+		return
+	}
+	e, ok := h.lookupCallerID(w, r)
+	if !ok {
+		return
+	}
+
+	h.callerIDs.mu.Lock()
+	delete(h.callerIDs.numbers, e.id())
+	err := h.callerIDs.save()
+	h.callerIDs.mu.Unlock()
+	if err != nil {
+		logWarn(getRequestID(r), fmt.Sprintf("Failed to persist caller IDs: %v", err))
+	}
+
+	logInfo(getRequestID(r), fmt.Sprintf("Removed caller ID %s from context %s", e.Number, e.Context))
+	h.respondSuccess(w, r, fmt.Sprintf("Caller ID %s deleted", e.id()))
+}
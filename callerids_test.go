@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPresentedCallerIDs(t *testing.T) {
+	tests := []struct {
+		name string
+		req  OriginateRequest
+		want []string
+	}{
+		{
+			name: "caller_id_number",
+			req:  OriginateRequest{ALeg: "user/1000", CallerIDNumber: "+15145550100"},
+			want: []string{"+15145550100"},
+		},
+		{
+			name: "inline variables of both legs",
+			req: OriginateRequest{
+				ALeg: "{origination_caller_id_number=15145550101,ignore_early_media=true}[effective_caller_id_number='15145550102']sofia/gateway/carrier/18005550199",
+				BLeg: "&bridge(<origination_caller_id_number=15145550103>sofia/gateway/carrier/18005550198)",
+			},
+			want: []string{"15145550101", "15145550102", "15145550103"},
+		},
+		{
+			name: "custom separator",
+			req:  OriginateRequest{ALeg: "{^^:a=1:origination_caller_id_number=15145550104}user/1000"},
+			want: []string{"15145550104"},
+		},
+		{
+			name: "identity headers",
+			req: OriginateRequest{
+				ALeg: "[sip_h_P-Asserted-Identity=<sip:+15145550105@carrier.example.com>]sofia/gateway/carrier/18005550199",
+				ChannelVariables: map[string]interface{}{
+					"sip_h_remote-party-id":      `"Acme" <sip:15145550106@pbx.example.com>;party=calling`,
+					"sip_h_P-Preferred-Identity": "tel:+15145550107",
+				},
+			},
+			want: []string{"+15145550107", "15145550106", "+15145550105"},
+		},
+		{
+			name: "identity header without a URI",
+			req:  OriginateRequest{ChannelVariables: map[string]interface{}{"sip_h_P-Asserted-Identity": "anonymous"}},
+			want: []string{"anonymous"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := presentedCallerIDs(&tt.req); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("presentedCallerIDs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
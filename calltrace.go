@@ -0,0 +1,519 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Per-call history bounds. Traces of ended calls are kept for
+// callTraceRetention; the oldest traces are evicted beyond callTraceMax.
+const (
+	callTraceMax         = 1000
+	callTraceMaxEvents   = 50
+	callTraceMaxCommands = 100
+	callTraceRetention   = 30 * time.Minute
+	callTraceMaxResponse = 512
+)
+
+var uuidInText = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+
+// Read-only commands are not worth tracing; the debug bundle itself issues
+// several of them
+var callTraceSkipCommands = []string{"uuid_dump", "uuid_getvar", "uuid_exists", "show", "uuid_set_media_stats"}
+
+// TracedCommand is an ESL command fs-api issued for a call. RequestID,
+// Token and Request name the API request it was issued for; they are empty
+// for commands fs-api issued on its own (schedules, watchdog, recalls).
+type TracedCommand struct {
+	Time      time.Time `json:"time"`
+	Command   string    `json:"command"`
+	Response  string    `json:"response,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	Token     string    `json:"token,omitempty"`   // Token fingerprint of the API client
+	Request   string    `json:"request,omitempty"` // Method and path, e.g. POST /v1/calls/{uuid}/transfer
+}
+
+// commandSource is an API request in flight and the calls it acts on
+type commandSource struct {
+	requestID string
+	token     string
+	request   string
+	mutating  bool // Not a GET or HEAD: its commands tag the calls with it
+	uuids     []string
+	tagged    map[string]bool // Calls already tagged with correlationVars
+}
+
+// Channel variables naming the last API request that changed a call, so
+// CDRs and event consumers can trace a call back to the API client
+const (
+	lastRequestIDVar = "fsapi_last_request_id"
+	lastTokenVar     = "fsapi_last_token"
+)
+
+// correlationVars returns the channel variables that tag a call with src
+func correlationVars(src *commandSource) [][2]string {
+	return [][2]string{{lastRequestIDVar, src.requestID}, {lastTokenVar, src.token}}
+}
+
+const commandSourceKey contextKey = "commandSource"
+
+type callTrace struct {
+	context  string
+	events   []*Event
+	commands []TracedCommand
+	ended    time.Time
+}
+
+// callTraces keeps a short history of events and ESL commands per call UUID
+type callTraces struct {
+	mu      sync.Mutex
+	calls   map[string]*callTrace
+	order   []string                    // Oldest first, for eviction
+	sources map[string][]*commandSource // Call UUID -> requests in flight naming it
+}
+
+func newCallTraces() *callTraces {
+	return &callTraces{calls: make(map[string]*callTrace), sources: make(map[string][]*commandSource)}
+}
+
+// attach marks src as acting on callUUIDs until it is detached
+func (t *callTraces) attach(src *commandSource, callUUIDs ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, id := range callUUIDs {
+		id = strings.ToLower(id)
+		if containsString(src.uuids, id) {
+			continue
+		}
+		src.uuids = append(src.uuids, id)
+		t.sources[id] = append(t.sources[id], src)
+	}
+}
+
+// detach forgets src once its request has completed
+func (t *callTraces) detach(src *commandSource) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, id := range src.uuids {
+		kept := t.sources[id][:0]
+		for _, other := range t.sources[id] {
+			if other != src {
+				kept = append(kept, other)
+			}
+		}
+		if len(kept) == 0 {
+			delete(t.sources, id)
+		} else {
+			t.sources[id] = kept
+		}
+	}
+}
+
+// claimCall attributes the commands for callUUID to r, for handlers acting
+// on calls their URL and body do not name (the UUID of a new call). It
+// returns the channel variables that tag the call with r.
+func (h *APIHandler) claimCall(r *http.Request, callUUID string) [][2]string {
+	src, ok := r.Context().Value(commandSourceKey).(*commandSource)
+	if !ok {
+		return nil
+	}
+	h.traces.attach(src, callUUID)
+	return correlationVars(src)
+}
+
+// commandSourceMiddleware attributes the ESL commands a request causes to
+// it, through the call UUIDs in its URL and body
+func (h *APIHandler) commandSourceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		src := &commandSource{
+			requestID: getRequestID(r),
+			token:     getTokenID(r),
+			request:   r.Method + " " + r.URL.Path,
+			mutating:  r.Method != http.MethodGet && r.Method != http.MethodHead,
+		}
+		var uuids []string
+		if callUUID := mux.Vars(r)["uuid"]; callUUID != "" {
+			uuids = append(uuids, callUUID)
+		}
+		if src.mutating {
+			uuids = append(uuids, uuidInText.FindAllString(string(peekRawBody(r)), -1)...)
+		}
+		h.traces.attach(src, uuids...)
+		defer h.traces.detach(src)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), commandSourceKey, src)))
+	})
+}
+
+// get returns the trace of callUUID, creating it. Caller must hold mu.
+func (t *callTraces) get(callUUID string) *callTrace {
+	trace, ok := t.calls[callUUID]
+	if ok {
+		return trace
+	}
+	t.prune()
+	trace = &callTrace{}
+	t.calls[callUUID] = trace
+	t.order = append(t.order, callUUID)
+	return trace
+}
+
+// prune drops expired traces and evicts the oldest beyond callTraceMax.
+// Caller must hold mu.
+func (t *callTraces) prune() {
+	cutoff := time.Now().Add(-callTraceRetention)
+	kept := t.order[:0]
+	for _, id := range t.order {
+		trace := t.calls[id]
+		if !trace.ended.IsZero() && trace.ended.Before(cutoff) {
+			delete(t.calls, id)
+			continue
+		}
+		kept = append(kept, id)
+	}
+	t.order = kept
+	for len(t.order) >= callTraceMax {
+		delete(t.calls, t.order[0])
+		t.order = t.order[1:]
+	}
+}
+
+// recordEvent is an event bus subscriber
+func (t *callTraces) recordEvent(ev *Event) {
+	callUUID := ev.UUID()
+	if callUUID == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	trace := t.get(callUUID)
+	if ctx := ev.Context(); ctx != "" {
+		trace.context = ctx
+	}
+	if len(trace.events) >= callTraceMaxEvents {
+		trace.events = trace.events[1:]
+	}
+	trace.events = append(trace.events, ev)
+	if ev.Name == "CHANNEL_HANGUP_COMPLETE" || ev.Name == "CHANNEL_DESTROY" {
+		trace.ended = time.Now()
+	}
+}
+
+// correlationCommands returns the uuid_setvar_multi commands that tag the
+// calls cmd acts on with the mutating API request it is issued for, once per
+// request and call. They are sent ahead of cmd, so a hangup still reaches
+// the CDR. originate is tagged through its own channel variables instead.
+func (t *callTraces) correlationCommands(cmd string) []string {
+	fields := strings.Fields(strings.TrimPrefix(cmd, "api "))
+	if len(fields) == 0 || containsString(callTraceSkipCommands, fields[0]) || fields[0] == "originate" {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var cmds []string
+	for _, id := range uuidInText.FindAllString(cmd, -1) {
+		id = strings.ToLower(id)
+		srcs := t.sources[id]
+		if len(srcs) == 0 {
+			continue
+		}
+		src := srcs[len(srcs)-1]
+		if !src.mutating || src.tagged[id] {
+			continue
+		}
+		if src.tagged == nil {
+			src.tagged = make(map[string]bool)
+		}
+		src.tagged[id] = true
+		var vars []string
+		for _, kv := range correlationVars(src) {
+			vars = append(vars, kv[0]+"="+kv[1])
+		}
+		cmds = append(cmds, fmt.Sprintf("api uuid_setvar_multi %s %s", id, strings.Join(vars, ";")))
+	}
+	return cmds
+}
+
+// recordCommand traces an "api ..." command under every call UUID it names.
+// originate replies with the new call's UUID, so its reply is searched too.
+func (t *callTraces) recordCommand(cmd, response string, err error) {
+	fields := strings.Fields(strings.TrimPrefix(cmd, "api "))
+	if len(fields) == 0 || containsString(callTraceSkipCommands, fields[0]) {
+		return
+	}
+	uuids := uuidInText.FindAllString(cmd, -1)
+	if fields[0] == "originate" && err == nil {
+		uuids = append(uuids, uuidInText.FindAllString(response, -1)...)
+	}
+	if len(uuids) == 0 {
+		return
+	}
+
+	entry := TracedCommand{Time: time.Now().UTC(), Command: cmd, Response: strings.TrimSpace(response)}
+	if len(entry.Response) > callTraceMaxResponse {
+		entry.Response = entry.Response[:callTraceMaxResponse] + "..."
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// The most recent request in flight that names one of the calls
+	for _, id := range uuids {
+		if srcs := t.sources[strings.ToLower(id)]; len(srcs) > 0 {
+			src := srcs[len(srcs)-1]
+			entry.RequestID, entry.Token, entry.Request = src.requestID, src.token, src.request
+			break
+		}
+	}
+	seen := map[string]bool{}
+	for _, id := range uuids {
+		id = strings.ToLower(id)
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		trace := t.get(id)
+		if len(trace.commands) >= callTraceMaxCommands {
+			trace.commands = trace.commands[1:]
+		}
+		trace.commands = append(trace.commands, entry)
+	}
+}
+
+// snapshot copies the trace of callUUID
+func (t *callTraces) snapshot(callUUID string) (context string, events []*Event, commands []TracedCommand, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	trace, ok := t.calls[callUUID]
+	if !ok {
+		return "", []*Event{}, []TracedCommand{}, false
+	}
+	events = append([]*Event{}, trace.events...)
+	commands = append([]TracedCommand{}, trace.commands...)
+	return trace.context, events, commands, true
+}
+
+// recordings returns the files recorded on callUUID, from RECORD_START
+// events and from uuid_record commands fs-api issued
+func (t *callTraces) recordings(callUUID string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	trace, ok := t.calls[callUUID]
+	if !ok {
+		return nil
+	}
+	var paths []string
+	add := func(path string) {
+		if path != "" && !containsString(paths, path) {
+			paths = append(paths, path)
+		}
+	}
+	for _, ev := range trace.events {
+		if ev.Name == "RECORD_START" {
+			add(ev.Get("Record-File-Path"))
+		}
+	}
+	for _, cmd := range trace.commands {
+		// api uuid_record <uuid> start <path>
+		if f := strings.Fields(cmd.Command); cmd.Error == "" && len(f) == 5 && f[1] == "uuid_record" && f[3] == "start" {
+			add(f[4])
+		}
+	}
+	return paths
+}
+
+// activeRecordings returns the files still being recorded on callUUID:
+// RECORD_START events and uuid_record start commands, less the RECORD_STOP
+// events and uuid_record stop commands after them
+func (t *callTraces) activeRecordings(callUUID string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	trace, ok := t.calls[callUUID]
+	if !ok {
+		return []string{}
+	}
+	type change struct {
+		at    time.Time
+		start bool
+		path  string // "all" stops every recording
+	}
+	var changes []change
+	for _, ev := range trace.events {
+		switch ev.Name {
+		case "RECORD_START", "RECORD_STOP":
+			changes = append(changes, change{ev.Time, ev.Name == "RECORD_START", ev.Get("Record-File-Path")})
+		}
+	}
+	for _, cmd := range trace.commands {
+		// api uuid_record <uuid> start|stop <path>|all
+		if f := strings.Fields(cmd.Command); cmd.Error == "" && len(f) == 5 && f[1] == "uuid_record" {
+			changes = append(changes, change{cmd.Time, f[3] == "start", f[4]})
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].at.Before(changes[j].at) })
+
+	paths := []string{}
+	for _, c := range changes {
+		switch {
+		case c.path == "":
+		case c.start && !containsString(paths, c.path):
+			paths = append(paths, c.path)
+		case !c.start && c.path == "all":
+			paths = paths[:0]
+		case !c.start:
+			kept := paths[:0]
+			for _, p := range paths {
+				if p != c.path {
+					kept = append(kept, p)
+				}
+			}
+			paths = kept
+		}
+	}
+	return paths
+}
+
+// rtpStats picks the rtp_audio_* / rtp_video_* variables out of channel
+// headers (uuid_dump output or a hangup event)
+func rtpStats(headers map[string]string) map[string]string {
+	stats := map[string]string{}
+	for k, v := range headers {
+		name := strings.ToLower(strings.TrimPrefix(strings.TrimPrefix(k, "variable_"), "Variable_"))
+		if strings.HasPrefix(name, "rtp_audio_") || strings.HasPrefix(name, "rtp_video_") {
+			stats[name] = v
+		}
+	}
+	return stats
+}
+
+// GET /v1/calls/{uuid}/debug
+func (h *APIHandler) GetCallDebug(w http.ResponseWriter, r *http.Request) {
+	callUUID := mux.Vars(r)["uuid"]
+	if err := validateUUID(callUUID); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	callContext, events, commands, traced := h.traces.snapshot(callUUID)
+
+	// Live call: refresh the RTP counters into the channel variables first
+	var channel map[string]string
+	h.eslClient.SendCommand(fmt.Sprintf("api uuid_set_media_stats %s", callUUID))
+	response, err := h.eslClient.SendCommand(fmt.Sprintf("api uuid_dump %s json", callUUID))
+	if err != nil && !isESLNotFound(err) {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to retrieve call: %v", err), err)
+		return
+	}
+	if err == nil {
+		var dump map[string]interface{}
+		if json.Unmarshal([]byte(response), &dump) == nil {
+			channel = make(map[string]string, len(dump))
+			for k, v := range dump {
+				channel[k] = fmt.Sprint(v)
+			}
+			switch {
+			case channel["variable_accountcode"] != "":
+				callContext = channel["variable_accountcode"]
+			case channel["Caller-Context"] != "":
+				callContext = channel["Caller-Context"]
+			case channel["variable_domain_name"] != "":
+				callContext = channel["variable_domain_name"]
+			}
+		}
+	}
+
+	var cdr *CDR
+	if h.cdrs != nil {
+		if rows := h.cdrs.query(time.Time{}, func(rec *CDR) bool { return rec.UUID == callUUID }); len(rows) > 0 {
+			cdr = rows[len(rows)-1]
+			if callContext == "" {
+				callContext = cdr.Context
+			}
+		}
+	}
+
+	if channel == nil && !traced && cdr == nil {
+		h.respondError(w, r, fmt.Sprintf("Call %s not found", callUUID), http.StatusNotFound)
+		return
+	}
+	if !isContextAllowed(r, callContext) {
+		h.respondError(w, r, fmt.Sprintf("Call %s belongs to context '%s' which is not in your allowed contexts: [%s]",
+			callUUID, callContext, strings.Join(getAllowedContexts(r), ", ")), http.StatusForbidden)
+		return
+	}
+
+	// A finished call's counters are on its hangup event
+	stats := map[string]string{}
+	if channel != nil {
+		stats = rtpStats(channel)
+	} else {
+		for _, ev := range events {
+			if ev.Name == "CHANNEL_HANGUP_COMPLETE" {
+				stats = rtpStats(ev.Headers)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="call-%s-debug.json"`, callUUID))
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data": map[string]interface{}{
+			"uuid":         callUUID,
+			"generated_at": time.Now().UTC(),
+			"live":         channel != nil,
+			"context":      callContext,
+			"channel":      channel,
+			"rtp_stats":    stats,
+			"events":       events,
+			"commands":     commands,
+			"cdr":          cdr,
+		},
+	})
+}
+
+// GET /v1/calls/{uuid}/commands
+func (h *APIHandler) ListCallCommands(w http.ResponseWriter, r *http.Request) {
+	callUUID := mux.Vars(r)["uuid"]
+	if err := validateUUID(callUUID); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	callContext, _, commands, traced := h.traces.snapshot(callUUID)
+	if !traced {
+		h.respondError(w, r, fmt.Sprintf("Call %s not found", callUUID), http.StatusNotFound)
+		return
+	}
+	if callContext == "" {
+		if info, err := h.getCallContext(callUUID); err == nil && info.Found {
+			callContext = info.AccountCode
+		}
+	}
+	if callContext == "" && h.cdrs != nil {
+		if rows := h.cdrs.query(time.Time{}, func(rec *CDR) bool { return rec.UUID == callUUID }); len(rows) > 0 {
+			callContext = rows[len(rows)-1].Context
+		}
+	}
+	if !isContextAllowed(r, callContext) {
+		h.respondError(w, r, fmt.Sprintf("Call %s belongs to context '%s' which is not in your allowed contexts: [%s]",
+			callUUID, callContext, strings.Join(getAllowedContexts(r), ", ")), http.StatusForbidden)
+		return
+	}
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status":    "success",
+		"row_count": len(commands),
+		"rows":      commands,
+	})
+}
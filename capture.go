@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	captureDefaultDuration = 60
+	captureMaxDuration     = 600
+)
+
+// SIP capture modes. Both are profile-wide in FreeSWITCH: siptrace writes
+// every SIP message of the profile to the console log, capture sends them
+// to the HEP capture server configured on the profile (e.g. Homer).
+const (
+	captureSIPNone    = "none"
+	captureSIPTrace   = "siptrace"
+	captureSIPCapture = "capture"
+)
+
+// uuid_debug_media direction: inbound and outbound audio
+const captureMediaFilter = "both"
+
+// captureRetrieval describes where the captured data can be found
+func captureRetrieval(callUUID, sipCallID string, media bool, sipMode string, start, end time.Time) map[string]string {
+	retrieve := map[string]string{}
+	if media {
+		retrieve["media"] = fmt.Sprintf("FreeSWITCH console log at debug level, lines tagged with %s (GET /v1/admin/logs/stream?level=debug)", callUUID)
+	}
+	switch sipMode {
+	case captureSIPTrace:
+		retrieve["sip"] = "FreeSWITCH console log (GET /v1/admin/logs/stream?level=console)"
+	case captureSIPCapture:
+		retrieve["sip"] = "HEP capture server configured on the sofia profile"
+		if FSAPI_CAPTURE_URL != "" {
+			replacer := strings.NewReplacer(
+				"{call_id}", url.QueryEscape(sipCallID),
+				"{uuid}", callUUID,
+				"{start}", strconv.FormatInt(start.Unix(), 10),
+				"{end}", strconv.FormatInt(end.Unix(), 10),
+			)
+			retrieve["sip"] = replacer.Replace(FSAPI_CAPTURE_URL)
+		}
+	}
+	return retrieve
+}
+
+func (req *CaptureRequest) applyDefaults() {
+	if req.DurationSec == 0 {
+		req.DurationSec = captureDefaultDuration
+	}
+	if req.Media == nil {
+		media := true
+		req.Media = &media
+	}
+	if req.SIP == "" {
+		req.SIP = captureSIPNone
+	}
+}
+
+// POST /v1/calls/{uuid}/capture
+func (h *APIHandler) CaptureCall(w http.ResponseWriter, r *http.Request) {
+	callUUID := mux.Vars(r)["uuid"]
+	if err := validateUUID(callUUID); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req CaptureRequest
+	if !h.decodeOptionalRequest(w, r, &req) {
+		return
+	}
+	if req.DurationSec < 0 || req.DurationSec > captureMaxDuration {
+		h.respondError(w, r, fmt.Sprintf("duration_sec must be between 1 and %d", captureMaxDuration), http.StatusBadRequest)
+		return
+	}
+	media := *req.Media
+	if req.SIP != captureSIPNone && req.SIP != captureSIPTrace && req.SIP != captureSIPCapture {
+		h.respondError(w, r, "sip must be none, siptrace or capture", http.StatusBadRequest)
+		return
+	}
+	if !media && req.SIP == captureSIPNone {
+		h.respondError(w, r, "nothing to capture: enable media or sip", http.StatusBadRequest)
+		return
+	}
+	// SIP tracing affects every call on the profile
+	if req.SIP != captureSIPNone && !h.requireUnrestricted(w, r) {
+		return
+	}
+
+	callInfo, ok := h.validateCallContext(w, r, callUUID)
+	if !ok {
+		return
+	}
+
+	// SIP tracing is enabled on the profile the call uses
+	var profile, sipCallID string
+	if req.SIP != captureSIPNone {
+		profile, _ = h.eslClient.SendCommand(fmt.Sprintf("api uuid_getvar %s sofia_profile_name", callUUID))
+		profile = strings.TrimSpace(profile)
+		if profile == "" || profile == "_undef_" || !isValidName(profile) {
+			h.respondError(w, r, fmt.Sprintf("Call %s is not a SIP call", callUUID), http.StatusBadRequest)
+			return
+		}
+		sipCallID, _ = h.eslClient.SendCommand(fmt.Sprintf("api uuid_getvar %s sip_call_id", callUUID))
+		if sipCallID = strings.TrimSpace(sipCallID); sipCallID == "_undef_" {
+			sipCallID = ""
+		}
+	}
+
+	start := time.Now().UTC()
+	end := start.Add(time.Duration(req.DurationSec) * time.Second)
+	// FreeSWITCH turns tracing off itself (sched_api), so the capture stays
+	// bounded even if fs-api restarts
+	schedule := func(group, cmd string) error {
+		_, err := h.eslClient.SendCommand(fmt.Sprintf("api sched_api +%d %s %s", req.DurationSec, group, cmd))
+		return err
+	}
+
+	result := map[string]interface{}{
+		"uuid":       callUUID,
+		"media":      media,
+		"sip":        req.SIP,
+		"started_at": start,
+		"expires_at": end,
+	}
+
+	if media {
+		if _, err := h.eslClient.SendCommand(fmt.Sprintf("api uuid_debug_media %s %s on", callUUID, captureMediaFilter)); err != nil {
+			h.respondESLError(w, r, fmt.Sprintf("Failed to enable media debug: %v", err), err)
+			return
+		}
+		if err := schedule("fsapi_capture_"+callUUID, fmt.Sprintf("uuid_debug_media %s %s off", callUUID, captureMediaFilter)); err != nil {
+			// Do not leave tracing on without a scheduled stop
+			h.eslClient.SendCommand(fmt.Sprintf("api uuid_debug_media %s %s off", callUUID, captureMediaFilter))
+			h.respondESLError(w, r, fmt.Sprintf("Failed to schedule end of media debug: %v", err), err)
+			return
+		}
+	}
+
+	if req.SIP != captureSIPNone {
+		if _, err := h.eslClient.SendCommand(fmt.Sprintf("api sofia profile %s %s on", profile, req.SIP)); err != nil {
+			h.respondESLError(w, r, fmt.Sprintf("Failed to enable %s on profile %s: %v", req.SIP, profile, err), err)
+			return
+		}
+		if err := schedule("fsapi_capture_"+profile, fmt.Sprintf("sofia profile %s %s off", profile, req.SIP)); err != nil {
+			h.eslClient.SendCommand(fmt.Sprintf("api sofia profile %s %s off", profile, req.SIP))
+			h.respondESLError(w, r, fmt.Sprintf("Failed to schedule end of %s: %v", req.SIP, err), err)
+			return
+		}
+		result["profile"] = profile
+		result["sip_call_id"] = sipCallID
+	}
+	result["retrieve"] = captureRetrieval(callUUID, sipCallID, media, req.SIP, start, end)
+
+	h.audit(r, AuditEntry{
+		Action:  "calls.capture",
+		Target:  callUUID,
+		Context: callInfo.AccountCode,
+		Details: map[string]string{
+			"media":        strconv.FormatBool(media),
+			"sip":          req.SIP,
+			"duration_sec": strconv.Itoa(req.DurationSec),
+		},
+	})
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   result,
+	})
+}
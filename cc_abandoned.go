@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Longest wait for the caller to answer a callback
+const ccCallbackMaxTimeout = 120
+
+// AbandonedCall is a queue member who hung up before reaching an agent
+type AbandonedCall struct {
+	ID           string     `json:"id"` // Member UUID
+	Queue        string     `json:"queue"`
+	CallUUID     string     `json:"call_uuid"`
+	CallerNumber string     `json:"caller_number"`
+	CallerName   string     `json:"caller_name,omitempty"`
+	JoinedAt     time.Time  `json:"joined_at"`
+	AbandonedAt  time.Time  `json:"abandoned_at"`
+	WaitSec      int        `json:"wait_sec"`
+	CallbackAt   *time.Time `json:"callback_at,omitempty"`
+	CallbackUUID string     `json:"callback_uuid,omitempty"`
+}
+
+// abandonedCall converts an outcome. Caller must hold the tracker's lock.
+func abandonedCall(o *ccMemberOutcome) AbandonedCall {
+	call := AbandonedCall{
+		ID:           o.MemberUUID,
+		Queue:        o.Queue,
+		CallUUID:     o.SessionID,
+		CallerNumber: o.CIDNumber,
+		CallerName:   o.CIDName,
+		JoinedAt:     o.Joined,
+		AbandonedAt:  o.Ended,
+		WaitSec:      int(o.WaitSec()),
+		CallbackUUID: o.CallbackUUID,
+	}
+	if !o.CallbackAt.IsZero() {
+		at := o.CallbackAt
+		call.CallbackAt = &at
+	}
+	return call
+}
+
+// abandoned returns the members of queue who abandoned at or after since,
+// newest first
+func (t *ccTracker) abandoned(queue string, since time.Time) []AbandonedCall {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	calls := []AbandonedCall{}
+	list := t.outcomes[queue]
+	for i := len(list) - 1; i >= 0; i-- {
+		o := list[i]
+		if o.Outcome == ccOutcomeAbandoned && !o.Ended.Before(since) {
+			calls = append(calls, abandonedCall(o))
+		}
+	}
+	return calls
+}
+
+// claimCallback marks the abandoned member id of queue as called back by
+// callUUID. found is false when the member is unknown; an existing
+// callback is returned in call without being replaced.
+func (t *ccTracker) claimCallback(queue, id, callUUID string) (call AbandonedCall, found, claimed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, o := range t.outcomes[queue] {
+		if o.MemberUUID != id || o.Outcome != ccOutcomeAbandoned {
+			continue
+		}
+		if o.CallbackAt.IsZero() {
+			o.CallbackAt = time.Now().UTC()
+			o.CallbackUUID = callUUID
+			claimed = true
+		}
+		return abandonedCall(o), true, claimed
+	}
+	return AbandonedCall{}, false, false
+}
+
+// releaseCallback undoes claimCallback after the callback failed
+func (t *ccTracker) releaseCallback(queue, id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, o := range t.outcomes[queue] {
+		if o.MemberUUID == id {
+			o.CallbackAt = time.Time{}
+			o.CallbackUUID = ""
+		}
+	}
+}
+
+// CCListAbandoned handles GET /v1/callcenter/queues/{queue_name}/abandoned
+func (h *APIHandler) CCListAbandoned(w http.ResponseWriter, r *http.Request) {
+	queueName := mux.Vars(r)["queue_name"]
+	if !h.validateCCDomain(w, r, queueName, "Queue") {
+		return
+	}
+
+	since := time.Now().Add(-ccSLAMaxWindow)
+	if v := r.URL.Query().Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			h.respondError(w, r, "since must be an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		since = t
+	}
+
+	rows := h.callcenter.abandoned(queueName, since)
+	if r.URL.Query().Get("pending") == "true" {
+		pending := []AbandonedCall{}
+		for _, call := range rows {
+			if call.CallbackAt == nil {
+				pending = append(pending, call)
+			}
+		}
+		rows = pending
+	}
+	h.respondJSON(w, r, map[string]interface{}{
+		"status":    "success",
+		"row_count": len(rows),
+		"rows":      rows,
+	})
+}
+
+// CCCallbackAbandoned handles POST /v1/callcenter/queues/{queue_name}/abandoned/{id}/callback
+func (h *APIHandler) CCCallbackAbandoned(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	queueName := vars["queue_name"]
+	id := vars["id"]
+	if err := validateUUID(id); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !queueNamePattern.MatchString(queueName) {
+		h.respondError(w, r, "queue name must be name@domain", http.StatusBadRequest)
+		return
+	}
+	if !h.validateCCDomain(w, r, queueName, "Queue") {
+		return
+	}
+
+	var req AbandonedCallbackRequest
+	if !h.decodeOptionalRequest(w, r, &req) {
+		return
+	}
+	if req.CallerIDNumber != "" && !dialNumberPattern.MatchString(req.CallerIDNumber) {
+		h.respondError(w, r, "caller_id_number must be digits, '*' or '#', optionally with a leading '+'", http.StatusBadRequest)
+		return
+	}
+	if err := checkESLArg("caller_id_name", req.CallerIDName, eslVarValueSeparators); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.TimeoutSec < 0 || req.TimeoutSec > ccCallbackMaxTimeout {
+		h.respondError(w, r, fmt.Sprintf("timeout_sec must be between 0 and %d", ccCallbackMaxTimeout), http.StatusBadRequest)
+		return
+	}
+	if err := validateWhisper(req.Whisper); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	callUUID := uuid.New().String()
+	call, found, claimed := h.callcenter.claimCallback(queueName, id, callUUID)
+	if !found {
+		h.respondError(w, r, fmt.Sprintf("No abandoned call %s in queue %s", id, queueName), http.StatusNotFound)
+		return
+	}
+	if !claimed {
+		h.respondError(w, r, fmt.Sprintf("Abandoned call %s was already called back at %s (%s)",
+			id, call.CallbackAt.Format(time.RFC3339), call.CallbackUUID), http.StatusConflict)
+		return
+	}
+
+	// The caller is dialed through the queue domain's dialplan unless an
+	// endpoint says how to reach them
+	domain := extractDomain(queueName)
+	dialString := ""
+	var err error
+	if req.Endpoint != nil {
+		if req.Endpoint.Number == "" && req.Endpoint.Type != dialTypeUser {
+			req.Endpoint.Number = call.CallerNumber
+		}
+		dialString, err = req.Endpoint.dialString()
+		if err == nil && req.Endpoint.Type == dialTypeUser && !isContextAllowed(r, req.Endpoint.Domain) {
+			err = fmt.Errorf("endpoint domain %s is not in your allowed contexts", req.Endpoint.Domain)
+		}
+	} else if !dialNumberPattern.MatchString(call.CallerNumber) {
+		err = fmt.Errorf("the caller's number %q cannot be dialed; give an endpoint", call.CallerNumber)
+	} else {
+		dialString = fmt.Sprintf("loopback/%s/%s", call.CallerNumber, domain)
+	}
+	if err != nil {
+		h.callcenter.releaseCallback(queueName, id)
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Back in the queue with the score of the time already waited, so the
+	// caller does not start over at the end of the line
+	chanVars := []string{
+		"origination_uuid=" + callUUID,
+		"fsapi_callback_of=" + id,
+		fmt.Sprintf("cc_base_score=%d", call.WaitSec),
+		"accountcode=" + domain,
+	}
+	for _, kv := range h.claimCall(r, callUUID) {
+		chanVars = append(chanVars, fmt.Sprintf("%s=%s", kv[0], kv[1]))
+	}
+	if req.CallerIDNumber != "" {
+		chanVars = append(chanVars, "origination_caller_id_number="+req.CallerIDNumber)
+	}
+	if req.CallerIDName != "" {
+		chanVars = append(chanVars, fmt.Sprintf("origination_caller_id_name='%s'", req.CallerIDName))
+	}
+	if req.TimeoutSec > 0 {
+		chanVars = append(chanVars, fmt.Sprintf("originate_timeout=%d", req.TimeoutSec))
+	}
+	bleg := fmt.Sprintf("&callcenter(%s)", queueName)
+	for _, kv := range h.whisperVars(req.Whisper, bleg) {
+		chanVars = append(chanVars, fmt.Sprintf("%s=%s", kv[0], kv[1]))
+	}
+
+	cmd := fmt.Sprintf("api originate {%s}%s %s", strings.Join(chanVars, ","), dialString, bleg)
+	response, err := h.eslClient.SendCommand(cmd)
+	if cause := parseESLErrCause(response); cause != "" {
+		h.callcenter.releaseCallback(queueName, id)
+		h.respondOriginateFailure(w, r, cause)
+		return
+	}
+	if err != nil {
+		h.callcenter.releaseCallback(queueName, id)
+		h.respondESLError(w, r, fmt.Sprintf("Failed to call back %s: %v", call.CallerNumber, err), err)
+		return
+	}
+
+	logInfo(getRequestID(r), fmt.Sprintf("Abandoned call %s in queue %s called back as %s", id, queueName, callUUID))
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   call,
+	})
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Minimum interval for repeating announcements, so callers are not talked
+// over continuously
+const ccAnnounceMinInterval = 15 * time.Second
+
+// ccAnnouncer repeats a queue announcement until stopped
+type ccAnnouncer struct {
+	req  QueueAnnounceRequest
+	stop chan struct{}
+}
+
+// ccAnnouncers tracks the repeating announcements by queue
+type ccAnnouncers struct {
+	mu     sync.Mutex
+	queues map[string]*ccAnnouncer
+}
+
+func newCCAnnouncers() *ccAnnouncers {
+	return &ccAnnouncers{queues: make(map[string]*ccAnnouncer)}
+}
+
+// renderAnnouncement fills the {position}, {waiting}, {wait_min} and
+// {eta_min} placeholders for one member
+func renderAnnouncement(template string, m *queueMember, waiting int, eta time.Duration, etaKnown bool) string {
+	waitMin := 0
+	if !m.Joined.IsZero() {
+		waitMin = int(time.Since(m.Joined).Minutes())
+	}
+	etaMin := ""
+	if etaKnown {
+		etaMin = strconv.Itoa(int(math.Ceil(eta.Minutes())))
+	}
+	return strings.NewReplacer(
+		"{position}", strconv.Itoa(m.Position),
+		"{waiting}", strconv.Itoa(waiting),
+		"{wait_min}", strconv.Itoa(waitMin),
+		"{eta_min}", etaMin,
+	).Replace(template)
+}
+
+// checkTTS checks that tts names a safe engine and voice and carries a
+// single line of text
+func checkTTS(tts *AnnounceTTS) error {
+	if tts.Engine == "" || tts.Text == "" {
+		return fmt.Errorf("tts.engine and tts.text are required")
+	}
+	if !isValidChannelVarName(tts.Engine) || (tts.Voice != "" && !isValidChannelVarName(tts.Voice)) {
+		return fmt.Errorf("tts.engine and tts.voice may only contain letters, digits, '_', '-' and '.'")
+	}
+	return checkESLArg("tts.text", tts.Text, "")
+}
+
+// validateAnnounceRequest checks that exactly one of file, tts and say is
+// set
+func validateAnnounceRequest(req *QueueAnnounceRequest) error {
+	set := 0
+	for _, ok := range []bool{req.File != "", req.TTS != nil, req.Say != nil} {
+		if ok {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("exactly one of file, tts or say is required")
+	}
+	if req.File != "" {
+		if err := checkESLArg("file", req.File, eslArgSeparators); err != nil {
+			return err
+		}
+		if err := validateFilePath(req.File); err != nil {
+			return fmt.Errorf("invalid file: %v", err)
+		}
+	}
+	if req.TTS != nil {
+		if err := checkTTS(req.TTS); err != nil {
+			return err
+		}
+	}
+	if req.Say != nil {
+		// The value is checked with every placeholder standing for 1
+		say := *req.Say
+		say.Value = strings.NewReplacer("{position}", "1", "{waiting}", "1", "{wait_min}", "1", "{eta_min}", "1").Replace(say.Value)
+		if err := checkSay(&say); err != nil {
+			return err
+		}
+	}
+	if req.IntervalSec < 0 || (req.IntervalSec > 0 && time.Duration(req.IntervalSec)*time.Second < ccAnnounceMinInterval) {
+		return fmt.Errorf("interval_sec must be 0 (one-shot) or at least %d", int(ccAnnounceMinInterval.Seconds()))
+	}
+	return nil
+}
+
+// announce broadcasts the announcement to every waiting member of queue
+// and returns how many members were reached and how many failed
+func (h *APIHandler) announce(queue string, req QueueAnnounceRequest) (int, int, error) {
+	members, err := h.waitingMembers(queue)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	announced, failed := 0, 0
+	for _, m := range members {
+		if m.SessionUUID == "" {
+			continue
+		}
+		eta, etaKnown := h.estimatedWait(queue, m.Position)
+
+		var target string
+		switch {
+		case req.File != "":
+			target = renderAnnouncement(req.File, m, len(members), eta, etaKnown)
+		case req.Say != nil:
+			// A value such as {eta_min} may not say anything for every
+			// caller, e.g. while there is no estimate
+			say := *req.Say
+			say.Value = renderAnnouncement(say.Value, m, len(members), eta, etaKnown)
+			args, err := say.args()
+			if err != nil {
+				failed++
+				continue
+			}
+			setCmd := fmt.Sprintf("api uuid_setvar %s fsapi_announce_say %s", m.SessionUUID, args)
+			if _, err := h.eslClient.SendCommand(setCmd); err != nil {
+				failed++
+				continue
+			}
+			target = "say::${fsapi_announce_say}"
+		default:
+			text := renderAnnouncement(req.TTS.Text, m, len(members), eta, etaKnown)
+			if file, ok := h.ttsCache.lookup(&AnnounceTTS{Engine: req.TTS.Engine, Voice: req.TTS.Voice, Text: text}); ok {
+				target = file
+			} else {
+				// Text with spaces cannot be passed through uuid_broadcast
+				// directly; it is stored on the channel and expanded when the
+				// speak application runs
+				setCmd := fmt.Sprintf("api uuid_setvar %s fsapi_announce_text %s", m.SessionUUID, text)
+				if _, err := h.eslClient.SendCommand(setCmd); err != nil {
+					failed++
+					continue
+				}
+				target = fmt.Sprintf("speak::%s|%s|${fsapi_announce_text}", req.TTS.Engine, req.TTS.Voice)
+			}
+		}
+
+		if _, err := h.eslClient.SendCommand(fmt.Sprintf("api uuid_broadcast %s %s aleg", m.SessionUUID, target)); err != nil {
+			failed++
+			continue
+		}
+		announced++
+	}
+	return announced, failed, nil
+}
+
+// startAnnouncer repeats the announcement every interval until stopped or
+// shutdown starts, replacing any announcer already running for queue
+func (h *APIHandler) startAnnouncer(queue string, req QueueAnnounceRequest) {
+	a := &ccAnnouncer{req: req, stop: make(chan struct{})}
+
+	h.announcers.mu.Lock()
+	if prev, ok := h.announcers.queues[queue]; ok {
+		close(prev.stop)
+	}
+	h.announcers.queues[queue] = a
+	h.announcers.mu.Unlock()
+
+	h.jobs.goJob("announce "+queue, func() {
+		ticker := time.NewTicker(time.Duration(req.IntervalSec) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if _, _, err := h.announce(queue, req); err != nil {
+					log.Printf("Queue announcement for %s failed: %v", queue, err)
+				}
+			case <-a.stop:
+				return
+			case <-h.jobs.stopping():
+				return
+			}
+		}
+	})
+}
+
+// stopAnnouncer stops the repeating announcement for queue, if any
+func (h *APIHandler) stopAnnouncer(queue string) bool {
+	h.announcers.mu.Lock()
+	defer h.announcers.mu.Unlock()
+	a, ok := h.announcers.queues[queue]
+	if ok {
+		close(a.stop)
+		delete(h.announcers.queues, queue)
+	}
+	return ok
+}
+
+// CCAnnounceQueue handles POST /v1/callcenter/queues/{queue_name}/announce
+func (h *APIHandler) CCAnnounceQueue(w http.ResponseWriter, r *http.Request) {
+	queueName := mux.Vars(r)["queue_name"]
+	if !h.validateCCDomain(w, r, queueName, "Queue") {
+		return
+	}
+
+	var req QueueAnnounceRequest
+	if !h.decodeRequest(w, r, &req) {
+		return
+	}
+	// Callers keep their own timezone; only the language applies
+	loc, ok := h.requestLocale(w, r, req.Locale, "")
+	if !ok {
+		return
+	}
+	h.localize(loc, req.TTS, req.Say)
+	if err := validateAnnounceRequest(&req); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	announced, failed, err := h.announce(queueName, req)
+	if err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to list queue members: %v", err), err)
+		return
+	}
+
+	if req.IntervalSec > 0 {
+		h.startAnnouncer(queueName, req)
+		logInfo(getRequestID(r), fmt.Sprintf("Queue %s announcement repeating every %ds", queueName, req.IntervalSec))
+	}
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data": map[string]interface{}{
+			"queue":        queueName,
+			"announced":    announced,
+			"failed":       failed,
+			"interval_sec": req.IntervalSec,
+		},
+	})
+}
+
+// CCStopQueueAnnounce handles DELETE /v1/callcenter/queues/{queue_name}/announce
+func (h *APIHandler) CCStopQueueAnnounce(w http.ResponseWriter, r *http.Request) {
+	queueName := mux.Vars(r)["queue_name"]
+	if !h.validateCCDomain(w, r, queueName, "Queue") {
+		return
+	}
+
+	if !h.stopAnnouncer(queueName) {
+		h.respondError(w, r, fmt.Sprintf("No repeating announcement for queue %s", queueName), http.StatusNotFound)
+		return
+	}
+	h.respondSuccess(w, r, fmt.Sprintf("Repeating announcement for queue %s stopped", queueName))
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	ccBulkMaxAgents   = 1000 // Agents per bulk request
+	ccBulkConcurrency = 8    // callcenter_config commands in flight at once
+)
+
+// Bulk outcome results
+const (
+	bulkResultUpdated   = "updated"
+	bulkResultNotFound  = "not_found"
+	bulkResultForbidden = "forbidden"
+	bulkResultFailed    = "failed"
+)
+
+// setAgentStatus applies status and state (either may be empty) to one agent
+func (h *APIHandler) setAgentStatus(agentName, status, state string) error {
+	for _, change := range []struct{ key, value string }{{"status", status}, {"state", state}} {
+		if change.value == "" {
+			continue
+		}
+		if _, err := h.sendCCCommand(fmt.Sprintf("agent set %s %s '%s'", change.key, agentName, change.value)); err != nil {
+			return fmt.Errorf("failed to set %s: %v", change.key, err)
+		}
+	}
+	return nil
+}
+
+// CCBulkAgentStatus handles PUT /v1/callcenter/agents/status
+func (h *APIHandler) CCBulkAgentStatus(w http.ResponseWriter, r *http.Request) {
+	var req AgentBulkStatusRequest
+	if !h.decodeRequest(w, r, &req) {
+		return
+	}
+	if len(req.Agents) == 0 {
+		h.respondError(w, r, "agents is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Agents) > ccBulkMaxAgents {
+		h.respondError(w, r, fmt.Sprintf("at most %d agents can be updated at once", ccBulkMaxAgents), http.StatusBadRequest)
+		return
+	}
+	if req.Status == "" && req.State == "" {
+		h.respondError(w, r, "status or state is required", http.StatusBadRequest)
+		return
+	}
+	if req.Status != "" && !containsString(ccAgentStatuses, req.Status) {
+		h.respondError(w, r, fmt.Sprintf("invalid status '%s': must be one of: %s", req.Status, strings.Join(ccAgentStatuses, ", ")), http.StatusBadRequest)
+		return
+	}
+	if req.State != "" && !containsString(ccAgentStates, req.State) {
+		h.respondError(w, r, fmt.Sprintf("invalid state '%s': must be one of: %s", req.State, strings.Join(ccAgentStates, ", ")), http.StatusBadRequest)
+		return
+	}
+	seen := make(map[string]bool, len(req.Agents))
+	for _, name := range req.Agents {
+		if name == "" {
+			h.respondError(w, r, "agents cannot contain empty names", http.StatusBadRequest)
+			return
+		}
+		if seen[name] {
+			h.respondError(w, r, fmt.Sprintf("agent '%s' is listed more than once", name), http.StatusBadRequest)
+			return
+		}
+		seen[name] = true
+	}
+
+	// One agent list for all domain checks
+	response, err := h.sendCCCommand("agent list")
+	if err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to list agents: %v", err), err)
+		return
+	}
+	agents := make(map[string]map[string]string)
+	for _, row := range ParsePipeDelimited(response) {
+		agents[row["name"]] = row
+	}
+
+	outcomes := make([]AgentBulkOutcome, len(req.Agents))
+	var pending []int
+	for i, name := range req.Agents {
+		outcomes[i].Agent = name
+		agent, ok := agents[name]
+		switch {
+		case !ok:
+			outcomes[i].Result = bulkResultNotFound
+			outcomes[i].Error = fmt.Sprintf("Agent %s not found", name)
+		case !isContextAllowed(r, ExtractDomainFromContact(agent["contact"])):
+			outcomes[i].Result = bulkResultForbidden
+			outcomes[i].Error = fmt.Sprintf("Agent %s is not in your allowed contexts", name)
+		default:
+			pending = append(pending, i)
+		}
+	}
+
+	// Shift changes update hundreds of agents; run them a few at a time
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for n := 0; n < ccBulkConcurrency && n < len(pending); n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				if err := h.setAgentStatus(outcomes[i].Agent, req.Status, req.State); err != nil {
+					outcomes[i].Result = bulkResultFailed
+					outcomes[i].Error = err.Error()
+					continue
+				}
+				outcomes[i].Result = bulkResultUpdated
+			}
+		}()
+	}
+	for _, i := range pending {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	updated := 0
+	for _, o := range outcomes {
+		if o.Result == bulkResultUpdated {
+			updated++
+		}
+	}
+	logInfo(getRequestID(r), fmt.Sprintf("Bulk agent status: %d of %d agents updated (status '%s', state '%s')", updated, len(outcomes), req.Status, req.State))
+	h.respondJSON(w, r, map[string]interface{}{
+		"status":    "success",
+		"updated":   updated,
+		"failed":    len(outcomes) - updated,
+		"row_count": len(outcomes),
+		"rows":      outcomes,
+	})
+}
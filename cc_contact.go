@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Longest an agent contact may ring, in seconds
+const agentContactMaxTimeout = 600
+
+// agentConfirmKeyPattern matches the key an agent accepts a call with
+var agentConfirmKeyPattern = regexp.MustCompile(`^[0-9*#]$`)
+
+// contactString validates c and renders it as the contact string of an
+// agent, carrying its domain as domain_name so the agent is filtered by it:
+//
+//	[domain_name=<domain>,call_timeout=N,group_confirm_file=F,group_confirm_key=K]user/<user>@<domain>
+func (c *AgentContact) contactString() (string, error) {
+	dial, err := (&DialTarget{Type: dialTypeUser, User: c.User, Domain: c.Domain}).dialString()
+	if err != nil {
+		return "", fmt.Errorf("contact.%v", err)
+	}
+	vars := []string{"domain_name=" + c.Domain}
+	if c.DialTimeoutSec < 0 || c.DialTimeoutSec > agentContactMaxTimeout {
+		return "", fmt.Errorf("contact.dial_timeout_sec must be between 1 and %d", agentContactMaxTimeout)
+	}
+	if c.DialTimeoutSec > 0 {
+		vars = append(vars, fmt.Sprintf("call_timeout=%d", c.DialTimeoutSec))
+	}
+	if c.Confirm != nil {
+		if err := checkPlaybackArgs(c.Confirm.File); err != nil {
+			return "", fmt.Errorf("contact.confirm.file: %v", strings.TrimPrefix(err.Error(), "application_args "))
+		}
+		if err := checkESLArg("contact.confirm.file", c.Confirm.File, eslVarValueSeparators); err != nil {
+			return "", err
+		}
+		key := c.Confirm.Key
+		if key == "" {
+			key = "1"
+		}
+		if !agentConfirmKeyPattern.MatchString(key) {
+			return "", fmt.Errorf("contact.confirm.key must be one of 0-9, * or #")
+		}
+		vars = append(vars, "group_confirm_file="+c.Confirm.File, "group_confirm_key="+key)
+	}
+	return fmt.Sprintf("[%s]%s", strings.Join(vars, ","), dial), nil
+}
+
+// agentContact fills in the domain of contact from the agent's domain and
+// renders it, checking that the caller may place an agent in that domain.
+// On failure the error response has been written and ok is false.
+func (h *APIHandler) agentContact(w http.ResponseWriter, r *http.Request, contact *AgentContact, agentDomain string) (string, bool) {
+	if contact.Domain == "" {
+		contact.Domain = agentDomain
+	} else if agentDomain != "" && contact.Domain != agentDomain {
+		h.respondError(w, r, "contact.domain must be the agent's domain", http.StatusBadRequest)
+		return "", false
+	}
+	s, err := contact.contactString()
+	if err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	if !h.validateCCDomainRaw(w, r, contact.Domain, "Agent") {
+		return "", false
+	}
+	return s, true
+}
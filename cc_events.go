@@ -0,0 +1,159 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// mod_callcenter publishes its events as CUSTOM callcenter::info with the
+// action in CC-Action
+const ccEventSubclass = "callcenter::info"
+
+// Queue member outcomes
+const (
+	ccOutcomeAnswered  = "answered"
+	ccOutcomeAbandoned = "abandoned"
+	ccOutcomeTimeout   = "timeout"
+	ccOutcomeBreakOut  = "break_out"
+)
+
+// ccMemberOutcome records how a queue member left the queue
+type ccMemberOutcome struct {
+	Queue      string
+	MemberUUID string
+	SessionID  string
+	CIDNumber  string
+	CIDName    string
+	Joined     time.Time
+	Answered   time.Time // Zero unless an agent answered
+	Ended      time.Time
+	Outcome    string
+
+	// Callback of an abandoned member, set under the tracker's lock
+	CallbackAt   time.Time
+	CallbackUUID string
+}
+
+// WaitSec is the time the member spent waiting for an agent
+func (o *ccMemberOutcome) WaitSec() float64 {
+	end := o.Ended
+	if !o.Answered.IsZero() {
+		end = o.Answered
+	}
+	return end.Sub(o.Joined).Seconds()
+}
+
+// ccTracker follows callcenter member events and keeps recent member
+// outcomes per queue for service-level statistics
+type ccTracker struct {
+	mu        sync.Mutex
+	retention time.Duration
+	answered  map[string]time.Time          // member UUID -> agent answer time
+	outcomes  map[string][]*ccMemberOutcome // queue -> outcomes, oldest first
+}
+
+func newCCTracker(retention time.Duration) *ccTracker {
+	return &ccTracker{
+		retention: retention,
+		answered:  make(map[string]time.Time),
+		outcomes:  make(map[string][]*ccMemberOutcome),
+	}
+}
+
+// isCCEvent reports whether ev is a mod_callcenter event
+func isCCEvent(ev *Event) bool {
+	return ev.Name == "CUSTOM" && ev.Get("Event-Subclass") == ccEventSubclass
+}
+
+// ccEpoch parses a callcenter epoch-seconds header, falling back to the
+// event time
+func ccEpoch(ev *Event, header string) time.Time {
+	sec, err := strconv.ParseInt(ev.Get(header), 10, 64)
+	if err != nil || sec <= 0 {
+		return ev.Time
+	}
+	return time.Unix(sec, 0).UTC()
+}
+
+// handleEvent is the event bus subscriber
+func (t *ccTracker) handleEvent(ev *Event) {
+	if !isCCEvent(ev) {
+		return
+	}
+
+	switch ev.Get("CC-Action") {
+	case "bridge-agent-start":
+		t.mu.Lock()
+		t.answered[ev.Get("CC-Member-UUID")] = ccEpoch(ev, "CC-Agent-Answered-Time")
+		t.mu.Unlock()
+
+	case "member-queue-end":
+		o := &ccMemberOutcome{
+			Queue:      ev.Get("CC-Queue"),
+			MemberUUID: ev.Get("CC-Member-UUID"),
+			SessionID:  ev.Get("CC-Member-Session-UUID"),
+			CIDNumber:  ev.Get("CC-Member-CID-Number"),
+			CIDName:    ev.Get("CC-Member-CID-Name"),
+			Joined:     ccEpoch(ev, "CC-Member-Joined-Time"),
+			Ended:      ccEpoch(ev, "CC-Member-Leaving-Time"),
+		}
+
+		t.mu.Lock()
+		if answered, ok := t.answered[o.MemberUUID]; ok {
+			o.Answered = answered
+			delete(t.answered, o.MemberUUID)
+		}
+		t.mu.Unlock()
+
+		switch {
+		case !o.Answered.IsZero() || ev.Get("CC-Cause") == "Terminated":
+			o.Outcome = ccOutcomeAnswered
+			if o.Answered.IsZero() {
+				o.Answered = o.Ended
+			}
+		case ev.Get("CC-Cancel-Reason") == "TIMEOUT" || ev.Get("CC-Cancel-Reason") == "NO_AGENT_TIMEOUT":
+			o.Outcome = ccOutcomeTimeout
+		case ev.Get("CC-Cancel-Reason") == "BREAK_OUT":
+			o.Outcome = ccOutcomeBreakOut
+		default:
+			o.Outcome = ccOutcomeAbandoned
+		}
+		t.add(o)
+	}
+}
+
+func (t *ccTracker) add(o *ccMemberOutcome) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cutoff := time.Now().Add(-t.retention)
+	list := append(t.outcomes[o.Queue], o)
+	drop := 0
+	for drop < len(list) && list[drop].Ended.Before(cutoff) {
+		drop++
+	}
+	t.outcomes[o.Queue] = list[drop:]
+
+	// Members whose queue-end never arrived must not accumulate
+	for member, answered := range t.answered {
+		if answered.Before(cutoff) {
+			delete(t.answered, member)
+		}
+	}
+}
+
+// recent returns the outcomes for queue that ended at or after since,
+// oldest first
+func (t *ccTracker) recent(queue string, since time.Time) []*ccMemberOutcome {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var rows []*ccMemberOutcome
+	for _, o := range t.outcomes[queue] {
+		if !o.Ended.Before(since) {
+			rows = append(rows, o)
+		}
+	}
+	return rows
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Agent statuses and states accepted by the supervisor override
+var (
+	ccAgentStatuses = []string{"Logged Out", "Available", "Available (On Demand)", "On Break"}
+	ccAgentStates   = []string{"Idle", "Waiting", "Receiving", "In a queue call"}
+)
+
+// agentSIPAddress returns user@domain for "user/..." agent contacts, or ""
+// for contacts that do not name a registered user (gateways, loopback)
+func agentSIPAddress(contact string) string {
+	// Strip leading {...} and [...] variable blocks
+	for len(contact) > 0 && (contact[0] == '{' || contact[0] == '[') {
+		end := strings.IndexAny(contact, "}]")
+		if end == -1 {
+			return ""
+		}
+		contact = contact[end+1:]
+	}
+	if !strings.HasPrefix(contact, "user/") {
+		return ""
+	}
+	return strings.TrimPrefix(contact, "user/")
+}
+
+// notifyAgent tells the agent about a supervisor override, either as a SIP
+// MESSAGE (sms) or by playing a file into the agent's active channels
+func (h *APIHandler) notifyAgent(address string, notify *AgentNotify) error {
+	switch notify.Method {
+	case "sms":
+		domain := address[strings.Index(address, "@")+1:]
+		_, err := h.eslClient.SendCommand(fmt.Sprintf("api chat sip|fs-api@%s|%s|%s", domain, address, notify.Message))
+		return err
+
+	case "broadcast":
+		response, err := h.eslClient.SendCommand("api show channels as json")
+		if err != nil {
+			return err
+		}
+		var channels struct {
+			Rows []map[string]string `json:"rows"`
+		}
+		if err := json.Unmarshal([]byte(response), &channels); err != nil {
+			return fmt.Errorf("failed to parse channels: %v", err)
+		}
+		played := 0
+		for _, ch := range channels.Rows {
+			if ch["presence_id"] != address {
+				continue
+			}
+			if _, err := h.eslClient.SendCommand(fmt.Sprintf("api uuid_broadcast %s %s aleg", ch["uuid"], notify.File)); err != nil {
+				return err
+			}
+			played++
+		}
+		if played == 0 {
+			return fmt.Errorf("agent %s has no active channel", address)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown notify method %q", notify.Method)
+}
+
+// validateAgentNotify checks the optional notification of a force request
+func validateAgentNotify(notify *AgentNotify) error {
+	switch notify.Method {
+	case "sms":
+		if notify.Message == "" {
+			return fmt.Errorf("notify.message is required")
+		}
+		// chat takes proto|from|to|message
+		if err := checkESLArg("notify.message", notify.Message, "|"); err != nil {
+			return err
+		}
+	case "broadcast":
+		if notify.File == "" {
+			return fmt.Errorf("notify.file is required")
+		}
+		if err := checkESLArg("notify.file", notify.File, eslArgSeparators); err != nil {
+			return err
+		}
+		if err := validateFilePath(notify.File); err != nil {
+			return fmt.Errorf("invalid notify.file: %v", err)
+		}
+	default:
+		return fmt.Errorf("notify.method must be sms or broadcast")
+	}
+	return nil
+}
+
+// CCForceAgent handles POST /v1/callcenter/agents/{agent_name}/force
+func (h *APIHandler) CCForceAgent(w http.ResponseWriter, r *http.Request) {
+	agentName := mux.Vars(r)["agent_name"]
+
+	var req AgentForceRequest
+	if !h.decodeRequest(w, r, &req) {
+		return
+	}
+	if req.Status == "" && req.State == "" {
+		h.respondError(w, r, "status or state is required", http.StatusBadRequest)
+		return
+	}
+	if req.Status != "" && !containsString(ccAgentStatuses, req.Status) {
+		h.respondError(w, r, fmt.Sprintf("invalid status '%s': must be one of: %s", req.Status, strings.Join(ccAgentStatuses, ", ")), http.StatusBadRequest)
+		return
+	}
+	if req.State != "" && !containsString(ccAgentStates, req.State) {
+		h.respondError(w, r, fmt.Sprintf("invalid state '%s': must be one of: %s", req.State, strings.Join(ccAgentStates, ", ")), http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Reason) == "" {
+		h.respondError(w, r, "reason is required", http.StatusBadRequest)
+		return
+	}
+	if req.Notify != nil {
+		if err := validateAgentNotify(req.Notify); err != nil {
+			h.respondError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	agent, ok := h.lookupCCAgent(w, r, agentName)
+	if !ok {
+		return
+	}
+	address := agentSIPAddress(agent["contact"])
+	if req.Notify != nil && address == "" {
+		h.respondError(w, r, "notify requires an agent contact of the form user/<user>@<domain>", http.StatusBadRequest)
+		return
+	}
+
+	details := map[string]string{}
+	for _, change := range []struct{ key, value, previous string }{
+		{"status", req.Status, agent["status"]},
+		{"state", req.State, agent["state"]},
+	} {
+		if change.value == "" {
+			continue
+		}
+		if _, err := h.sendCCCommand(fmt.Sprintf("agent set %s %s '%s'", change.key, agentName, change.value)); err != nil {
+			h.respondESLError(w, r, fmt.Sprintf("Failed to set agent %s: %v", change.key, err), err)
+			return
+		}
+		details[change.key] = change.value
+		details["previous_"+change.key] = change.previous
+	}
+
+	result := map[string]interface{}{
+		"agent":  agentName,
+		"status": agent["status"],
+		"state":  agent["state"],
+	}
+	if req.Status != "" {
+		result["status"] = req.Status
+	}
+	if req.State != "" {
+		result["state"] = req.State
+	}
+
+	// A failed notification does not undo the override
+	if req.Notify != nil {
+		details["notify"] = req.Notify.Method
+		if err := h.notifyAgent(address, req.Notify); err != nil {
+			details["notify_error"] = err.Error()
+			result["notify_error"] = err.Error()
+			logWarn(getRequestID(r), fmt.Sprintf("Failed to notify agent %s: %v", agentName, err))
+		}
+		result["notified"] = details["notify_error"] == ""
+	}
+
+	h.audit(r, AuditEntry{
+		Action:  "callcenter.agent.force",
+		Target:  agentName,
+		Context: ExtractDomainFromContact(agent["contact"]),
+		Reason:  req.Reason,
+		Details: details,
+	})
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   result,
+	})
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/gorilla/mux"
@@ -123,14 +125,34 @@ func (h *APIHandler) sendCCCommand(args string) (string, error) {
 	return h.eslClient.SendCommand(cmd)
 }
 
+// lookupCCAgent finds agentName in the agent list and checks that the
+// domain of its contact string is allowed. On failure the error response
+// has been written and ok is false.
+func (h *APIHandler) lookupCCAgent(w http.ResponseWriter, r *http.Request, agentName string) (map[string]string, bool) {
+	response, err := h.sendCCCommand("agent list")
+	if err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to list agents: %v", err), err)
+		return nil, false
+	}
+	for _, row := range ParsePipeDelimited(response) {
+		if row["name"] == agentName {
+			if !h.validateCCDomainRaw(w, r, ExtractDomainFromContact(row["contact"]), "Agent") {
+				return nil, false
+			}
+			return row, true
+		}
+	}
+	h.respondError(w, r, fmt.Sprintf("Agent %s not found", agentName), http.StatusNotFound)
+	return nil, false
+}
+
 // --- Queue handlers ---
 
 // CCListQueues handles GET /v1/callcenter/queues
 func (h *APIHandler) CCListQueues(w http.ResponseWriter, r *http.Request) {
 	response, err := h.sendCCCommand("queue list")
 	if err != nil {
-		statusCode := h.getErrorStatusCode(err)
-		h.respondError(w, r, fmt.Sprintf("Failed to list queues: %v", err), statusCode)
+		h.respondESLError(w, r, fmt.Sprintf("Failed to list queues: %v", err), err)
 		return
 	}
 
@@ -152,8 +174,7 @@ func (h *APIHandler) CCCountQueues(w http.ResponseWriter, r *http.Request) {
 	if isUnrestrictedAccess(r) {
 		response, err := h.sendCCCommand("queue count")
 		if err != nil {
-			statusCode := h.getErrorStatusCode(err)
-			h.respondError(w, r, fmt.Sprintf("Failed to count queues: %v", err), statusCode)
+			h.respondESLError(w, r, fmt.Sprintf("Failed to count queues: %v", err), err)
 			return
 		}
 		count, err := ParsePlainCount(response)
@@ -168,8 +189,7 @@ func (h *APIHandler) CCCountQueues(w http.ResponseWriter, r *http.Request) {
 	// Restricted: list + filter + count
 	response, err := h.sendCCCommand("queue list")
 	if err != nil {
-		statusCode := h.getErrorStatusCode(err)
-		h.respondError(w, r, fmt.Sprintf("Failed to list queues: %v", err), statusCode)
+		h.respondESLError(w, r, fmt.Sprintf("Failed to list queues: %v", err), err)
 		return
 	}
 	rows := ParsePipeDelimited(response)
@@ -186,8 +206,7 @@ func (h *APIHandler) CCListQueueAgents(w http.ResponseWriter, r *http.Request) {
 
 	response, err := h.sendCCCommand(fmt.Sprintf("queue list agents %s", queueName))
 	if err != nil {
-		statusCode := h.getErrorStatusCode(err)
-		h.respondError(w, r, fmt.Sprintf("Failed to list queue agents: %v", err), statusCode)
+		h.respondESLError(w, r, fmt.Sprintf("Failed to list queue agents: %v", err), err)
 		return
 	}
 
@@ -208,8 +227,7 @@ func (h *APIHandler) CCListQueueMembers(w http.ResponseWriter, r *http.Request)
 
 	response, err := h.sendCCCommand(fmt.Sprintf("queue list members %s", queueName))
 	if err != nil {
-		statusCode := h.getErrorStatusCode(err)
-		h.respondError(w, r, fmt.Sprintf("Failed to list queue members: %v", err), statusCode)
+		h.respondESLError(w, r, fmt.Sprintf("Failed to list queue members: %v", err), err)
 		return
 	}
 
@@ -230,8 +248,7 @@ func (h *APIHandler) CCListQueueTiers(w http.ResponseWriter, r *http.Request) {
 
 	response, err := h.sendCCCommand(fmt.Sprintf("queue list tiers %s", queueName))
 	if err != nil {
-		statusCode := h.getErrorStatusCode(err)
-		h.respondError(w, r, fmt.Sprintf("Failed to list queue tiers: %v", err), statusCode)
+		h.respondESLError(w, r, fmt.Sprintf("Failed to list queue tiers: %v", err), err)
 		return
 	}
 
@@ -258,8 +275,7 @@ func (h *APIHandler) CCCountQueueAgents(w http.ResponseWriter, r *http.Request)
 
 	response, err := h.sendCCCommand(cmd)
 	if err != nil {
-		statusCode := h.getErrorStatusCode(err)
-		h.respondError(w, r, fmt.Sprintf("Failed to count queue agents: %v", err), statusCode)
+		h.respondESLError(w, r, fmt.Sprintf("Failed to count queue agents: %v", err), err)
 		return
 	}
 
@@ -281,8 +297,7 @@ func (h *APIHandler) CCCountQueueMembers(w http.ResponseWriter, r *http.Request)
 
 	response, err := h.sendCCCommand(fmt.Sprintf("queue count members %s", queueName))
 	if err != nil {
-		statusCode := h.getErrorStatusCode(err)
-		h.respondError(w, r, fmt.Sprintf("Failed to count queue members: %v", err), statusCode)
+		h.respondESLError(w, r, fmt.Sprintf("Failed to count queue members: %v", err), err)
 		return
 	}
 
@@ -304,8 +319,7 @@ func (h *APIHandler) CCCountQueueTiers(w http.ResponseWriter, r *http.Request) {
 
 	response, err := h.sendCCCommand(fmt.Sprintf("queue count tiers %s", queueName))
 	if err != nil {
-		statusCode := h.getErrorStatusCode(err)
-		h.respondError(w, r, fmt.Sprintf("Failed to count queue tiers: %v", err), statusCode)
+		h.respondESLError(w, r, fmt.Sprintf("Failed to count queue tiers: %v", err), err)
 		return
 	}
 
@@ -327,8 +341,7 @@ func (h *APIHandler) CCLoadQueue(w http.ResponseWriter, r *http.Request) {
 
 	_, err := h.sendCCCommand(fmt.Sprintf("queue load %s", queueName))
 	if err != nil {
-		statusCode := h.getErrorStatusCode(err)
-		h.respondError(w, r, fmt.Sprintf("Failed to load queue: %v", err), statusCode)
+		h.respondESLError(w, r, fmt.Sprintf("Failed to load queue: %v", err), err)
 		return
 	}
 
@@ -344,8 +357,7 @@ func (h *APIHandler) CCUnloadQueue(w http.ResponseWriter, r *http.Request) {
 
 	_, err := h.sendCCCommand(fmt.Sprintf("queue unload %s", queueName))
 	if err != nil {
-		statusCode := h.getErrorStatusCode(err)
-		h.respondError(w, r, fmt.Sprintf("Failed to unload queue: %v", err), statusCode)
+		h.respondESLError(w, r, fmt.Sprintf("Failed to unload queue: %v", err), err)
 		return
 	}
 
@@ -361,8 +373,7 @@ func (h *APIHandler) CCReloadQueue(w http.ResponseWriter, r *http.Request) {
 
 	_, err := h.sendCCCommand(fmt.Sprintf("queue reload %s", queueName))
 	if err != nil {
-		statusCode := h.getErrorStatusCode(err)
-		h.respondError(w, r, fmt.Sprintf("Failed to reload queue: %v", err), statusCode)
+		h.respondESLError(w, r, fmt.Sprintf("Failed to reload queue: %v", err), err)
 		return
 	}
 
@@ -375,8 +386,7 @@ func (h *APIHandler) CCReloadQueue(w http.ResponseWriter, r *http.Request) {
 func (h *APIHandler) CCListAgents(w http.ResponseWriter, r *http.Request) {
 	response, err := h.sendCCCommand("agent list")
 	if err != nil {
-		statusCode := h.getErrorStatusCode(err)
-		h.respondError(w, r, fmt.Sprintf("Failed to list agents: %v", err), statusCode)
+		h.respondESLError(w, r, fmt.Sprintf("Failed to list agents: %v", err), err)
 		return
 	}
 
@@ -396,8 +406,7 @@ func (h *APIHandler) CCListAgents(w http.ResponseWriter, r *http.Request) {
 // CCAddAgent handles POST /v1/callcenter/agents
 func (h *APIHandler) CCAddAgent(w http.ResponseWriter, r *http.Request) {
 	var req AgentAddRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.respondError(w, r, "Invalid request body", http.StatusBadRequest)
+	if !h.decodeRequest(w, r, &req) {
 		return
 	}
 
@@ -425,14 +434,71 @@ func (h *APIHandler) CCAddAgent(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Settings applied after the add, in order: the status last, so the
+	// agent is not offered calls before it is configured
+	var sets [][2]string
+	if req.Contact != nil {
+		if req.Type != "callback" {
+			h.respondError(w, r, "contact is only used with type 'callback'", http.StatusBadRequest)
+			return
+		}
+		domain := req.Domain
+		if domain == "" {
+			domain = extractDomain(req.Name)
+		}
+		contact, ok := h.agentContact(w, r, req.Contact, domain)
+		if !ok {
+			return
+		}
+		sets = append(sets, [2]string{"contact", contact})
+	}
+	for _, f := range []struct {
+		key   string
+		value int
+	}{
+		{"max_no_answer", req.MaxNoAnswer}, {"wrap_up_time", req.WrapUpTime},
+		{"reject_delay_time", req.RejectDelayTime}, {"busy_delay_time", req.BusyDelayTime},
+	} {
+		if f.value < 0 {
+			h.respondError(w, r, fmt.Sprintf("%s must not be negative", f.key), http.StatusBadRequest)
+			return
+		}
+		if f.value > 0 {
+			sets = append(sets, [2]string{f.key, strconv.Itoa(f.value)})
+		}
+	}
+	if req.Status != "" {
+		if !containsString(ccAgentStatuses, req.Status) {
+			h.respondError(w, r, fmt.Sprintf("status must be one of: %s", strings.Join(ccAgentStatuses, ", ")), http.StatusBadRequest)
+			return
+		}
+		sets = append(sets, [2]string{"status", req.Status})
+	}
+
 	_, err := h.sendCCCommand(fmt.Sprintf("agent add %s %s", req.Name, req.Type))
 	if err != nil {
-		statusCode := h.getErrorStatusCode(err)
-		h.respondError(w, r, fmt.Sprintf("Failed to add agent: %v", err), statusCode)
+		h.respondESLError(w, r, fmt.Sprintf("Failed to add agent: %v", err), err)
+		return
+	}
+	if len(sets) == 0 {
+		h.respondSuccess(w, r, fmt.Sprintf("Agent %s added with type %s", req.Name, req.Type))
 		return
 	}
 
-	h.respondSuccess(w, r, fmt.Sprintf("Agent %s added with type %s", req.Name, req.Type))
+	// The agent is removed again if a setting fails, so it never exists
+	// half configured
+	keys := make([]string, 0, len(sets))
+	for _, kv := range sets {
+		if _, err := h.sendCCCommand(fmt.Sprintf("agent set %s %s '%s'", kv[0], req.Name, kv[1])); err != nil {
+			if _, delErr := h.sendCCCommand(fmt.Sprintf("agent del %s", req.Name)); delErr != nil {
+				logWarn(getRequestID(r), fmt.Sprintf("Failed to remove agent %s after its %s failed: %v", req.Name, kv[0], delErr))
+			}
+			h.respondESLError(w, r, fmt.Sprintf("Failed to set agent %s: %v", kv[0], err), err)
+			return
+		}
+		keys = append(keys, kv[0])
+	}
+	h.respondSuccess(w, r, fmt.Sprintf("Agent %s added with type %s and %s set", req.Name, req.Type, strings.Join(keys, ", ")))
 }
 
 // CCDeleteAgent handles DELETE /v1/callcenter/agents/{agent_name}
@@ -440,8 +506,12 @@ func (h *APIHandler) CCDeleteAgent(w http.ResponseWriter, r *http.Request) {
 	agentName := mux.Vars(r)["agent_name"]
 
 	var req AgentDelRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := decodeJSONBody(r, &req); err != nil {
 		// Allow empty body for unrestricted access
+		if err != io.EOF {
+			h.respondBodyError(w, r, err)
+			return
+		}
 		if !isUnrestrictedAccess(r) {
 			h.respondError(w, r, "Invalid request body: domain is required for authorization", http.StatusBadRequest)
 			return
@@ -461,8 +531,7 @@ func (h *APIHandler) CCDeleteAgent(w http.ResponseWriter, r *http.Request) {
 
 	_, err := h.sendCCCommand(fmt.Sprintf("agent del %s", agentName))
 	if err != nil {
-		statusCode := h.getErrorStatusCode(err)
-		h.respondError(w, r, fmt.Sprintf("Failed to delete agent: %v", err), statusCode)
+		h.respondESLError(w, r, fmt.Sprintf("Failed to delete agent: %v", err), err)
 		return
 	}
 
@@ -474,11 +543,13 @@ func (h *APIHandler) CCSetAgent(w http.ResponseWriter, r *http.Request) {
 	agentName := mux.Vars(r)["agent_name"]
 
 	var req AgentSetRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.respondError(w, r, "Invalid request body", http.StatusBadRequest)
+	if !h.decodeRequest(w, r, &req) {
 		return
 	}
 
+	if req.Contact != nil && req.Key == "" {
+		req.Key = "contact"
+	}
 	if req.Key == "" {
 		h.respondError(w, r, "key is required", http.StatusBadRequest)
 		return
@@ -499,11 +570,32 @@ func (h *APIHandler) CCSetAgent(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// A contact is rendered from its fields. Restricted callers cannot set
+	// a raw contact string, whose domain_name would decide which tenant
+	// sees the agent.
+	if req.Contact != nil {
+		if req.Key != "contact" || req.Value != "" {
+			h.respondError(w, r, "contact is used instead of value, with key contact", http.StatusBadRequest)
+			return
+		}
+		domain := req.Domain
+		if domain == "" {
+			domain = extractDomain(agentName)
+		}
+		contact, ok := h.agentContact(w, r, req.Contact, domain)
+		if !ok {
+			return
+		}
+		req.Value = contact
+	} else if req.Key == "contact" && !isUnrestrictedAccess(r) {
+		h.respondError(w, r, "contact must be given as an object (user, domain, dial_timeout_sec, confirm)", http.StatusBadRequest)
+		return
+	}
+
 	// Command format: agent set <key> <agent_name> <value>
 	_, err := h.sendCCCommand(fmt.Sprintf("agent set %s %s '%s'", req.Key, agentName, req.Value))
 	if err != nil {
-		statusCode := h.getErrorStatusCode(err)
-		h.respondError(w, r, fmt.Sprintf("Failed to set agent %s: %v", req.Key, err), statusCode)
+		h.respondESLError(w, r, fmt.Sprintf("Failed to set agent %s: %v", req.Key, err), err)
 		return
 	}
 
@@ -516,8 +608,7 @@ func (h *APIHandler) CCSetAgent(w http.ResponseWriter, r *http.Request) {
 func (h *APIHandler) CCListTiers(w http.ResponseWriter, r *http.Request) {
 	response, err := h.sendCCCommand("tier list")
 	if err != nil {
-		statusCode := h.getErrorStatusCode(err)
-		h.respondError(w, r, fmt.Sprintf("Failed to list tiers: %v", err), statusCode)
+		h.respondESLError(w, r, fmt.Sprintf("Failed to list tiers: %v", err), err)
 		return
 	}
 
@@ -537,8 +628,7 @@ func (h *APIHandler) CCListTiers(w http.ResponseWriter, r *http.Request) {
 // CCAddTier handles POST /v1/callcenter/tiers
 func (h *APIHandler) CCAddTier(w http.ResponseWriter, r *http.Request) {
 	var req TierAddRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.respondError(w, r, "Invalid request body", http.StatusBadRequest)
+	if !h.decodeRequest(w, r, &req) {
 		return
 	}
 
@@ -567,8 +657,7 @@ func (h *APIHandler) CCAddTier(w http.ResponseWriter, r *http.Request) {
 
 	_, err := h.sendCCCommand(cmd)
 	if err != nil {
-		statusCode := h.getErrorStatusCode(err)
-		h.respondError(w, r, fmt.Sprintf("Failed to add tier: %v", err), statusCode)
+		h.respondESLError(w, r, fmt.Sprintf("Failed to add tier: %v", err), err)
 		return
 	}
 
@@ -578,8 +667,7 @@ func (h *APIHandler) CCAddTier(w http.ResponseWriter, r *http.Request) {
 // CCDeleteTier handles DELETE /v1/callcenter/tiers
 func (h *APIHandler) CCDeleteTier(w http.ResponseWriter, r *http.Request) {
 	var req TierDelRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.respondError(w, r, "Invalid request body", http.StatusBadRequest)
+	if !h.decodeRequest(w, r, &req) {
 		return
 	}
 
@@ -600,8 +688,7 @@ func (h *APIHandler) CCDeleteTier(w http.ResponseWriter, r *http.Request) {
 	// Command format: tier del <queue> <agent> (queue first!)
 	_, err := h.sendCCCommand(fmt.Sprintf("tier del %s %s", req.Queue, req.Agent))
 	if err != nil {
-		statusCode := h.getErrorStatusCode(err)
-		h.respondError(w, r, fmt.Sprintf("Failed to delete tier: %v", err), statusCode)
+		h.respondESLError(w, r, fmt.Sprintf("Failed to delete tier: %v", err), err)
 		return
 	}
 
@@ -611,8 +698,7 @@ func (h *APIHandler) CCDeleteTier(w http.ResponseWriter, r *http.Request) {
 // CCSetTier handles PUT /v1/callcenter/tiers
 func (h *APIHandler) CCSetTier(w http.ResponseWriter, r *http.Request) {
 	var req TierSetRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.respondError(w, r, "Invalid request body", http.StatusBadRequest)
+	if !h.decodeRequest(w, r, &req) {
 		return
 	}
 
@@ -641,8 +727,7 @@ func (h *APIHandler) CCSetTier(w http.ResponseWriter, r *http.Request) {
 	// Command format: tier set <key> <queue> <agent> <value>
 	_, err := h.sendCCCommand(fmt.Sprintf("tier set %s %s %s '%s'", req.Key, req.Queue, req.Agent, req.Value))
 	if err != nil {
-		statusCode := h.getErrorStatusCode(err)
-		h.respondError(w, r, fmt.Sprintf("Failed to set tier %s: %v", req.Key, err), statusCode)
+		h.respondESLError(w, r, fmt.Sprintf("Failed to set tier %s: %v", req.Key, err), err)
 		return
 	}
 
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	queueOverflowFile = "queue_overflow.json"
+
+	// How often waiting members are checked against the rules
+	queueOverflowInterval = 5 * time.Second
+
+	// How long a transferred member is skipped while mod_callcenter still
+	// lists it as waiting
+	queueOverflowHold = time.Minute
+
+	// Layout of opening and closing times
+	overflowClockTime = "15:04"
+)
+
+// Reasons a member overflows, in the order they are checked
+const (
+	overflowReasonAfterHours = "after_hours"
+	overflowReasonMaxMembers = "max_members"
+	overflowReasonMaxWait    = "max_wait"
+)
+
+// Day names of opening hours, indexed by time.Weekday
+var overflowDays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// QueueOverflowHours are the opening hours of a queue; members waiting
+// outside them overflow
+type QueueOverflowHours struct {
+	Timezone string   `json:"timezone"` // IANA name, e.g. Europe/Berlin
+	Days     []string `json:"days"`     // sun, mon, tue, wed, thu, fri, sat
+	Open     string   `json:"open"`     // HH:MM
+	Close    string   `json:"close"`    // HH:MM, after open
+}
+
+// QueueOverflowRule moves members out of a queue to a fallback extension in
+// the queue's domain when it is closed, too full, or they waited too long
+type QueueOverflowRule struct {
+	Queue       string              `json:"queue"`
+	MaxWaitSec  int                 `json:"max_wait_sec,omitempty"` // 0 = no limit
+	MaxMembers  int                 `json:"max_members,omitempty"`  // 0 = no limit
+	Hours       *QueueOverflowHours `json:"hours,omitempty"`        // Empty = always open
+	Destination string              `json:"destination"`            // Extension in the queue's domain
+	CreatedAt   time.Time           `json:"created_at"`
+	UpdatedAt   time.Time           `json:"updated_at"`
+}
+
+// validate checks the rule's settings
+func (rule *QueueOverflowRule) validate() error {
+	if !userIDPattern.MatchString(rule.Destination) {
+		return fmt.Errorf("destination must be an extension in the queue's domain (letters, digits and . _ + -)")
+	}
+	if rule.MaxWaitSec < 0 || rule.MaxMembers < 0 {
+		return fmt.Errorf("max_wait_sec and max_members cannot be negative")
+	}
+	if rule.MaxWaitSec == 0 && rule.MaxMembers == 0 && rule.Hours == nil {
+		return fmt.Errorf("at least one of max_wait_sec, max_members or hours is required")
+	}
+	if hours := rule.Hours; hours != nil {
+		if _, err := time.LoadLocation(hours.Timezone); err != nil || hours.Timezone == "" {
+			return fmt.Errorf("hours.timezone must be an IANA time zone, e.g. Europe/Berlin")
+		}
+		if len(hours.Days) == 0 {
+			return fmt.Errorf("hours.days must list at least one day")
+		}
+		for _, day := range hours.Days {
+			if !containsString(overflowDays, day) {
+				return fmt.Errorf("hours.days must be some of: %v", overflowDays)
+			}
+		}
+		open, err1 := time.Parse(overflowClockTime, hours.Open)
+		closing, err2 := time.Parse(overflowClockTime, hours.Close)
+		if err1 != nil || err2 != nil || !closing.After(open) {
+			return fmt.Errorf("hours.open and hours.close must be HH:MM with close after open")
+		}
+	}
+	return nil
+}
+
+// isOpen reports whether now falls within the opening hours
+func (hours *QueueOverflowHours) isOpen(now time.Time) bool {
+	loc, err := time.LoadLocation(hours.Timezone)
+	if err != nil {
+		// Validated when the rule was saved
+		return true
+	}
+	now = now.In(loc)
+	if !containsString(hours.Days, overflowDays[now.Weekday()]) {
+		return false
+	}
+	clock := now.Format(overflowClockTime)
+	return clock >= hours.Open && clock < hours.Close
+}
+
+// overflowing returns the reason each member in waiting (in service order)
+// overflows at now, keyed by member UUID. Members beyond max_members are
+// the ones last in line.
+func (rule *QueueOverflowRule) overflowing(waiting []*queueMember, now time.Time) map[string]string {
+	reasons := make(map[string]string)
+	for _, m := range waiting {
+		switch {
+		case rule.Hours != nil && !rule.Hours.isOpen(now):
+			reasons[m.UUID] = overflowReasonAfterHours
+		case rule.MaxMembers > 0 && m.Position > rule.MaxMembers:
+			reasons[m.UUID] = overflowReasonMaxMembers
+		case rule.MaxWaitSec > 0 && !m.Joined.IsZero() && now.Sub(m.Joined) >= time.Duration(rule.MaxWaitSec)*time.Second:
+			reasons[m.UUID] = overflowReasonMaxWait
+		}
+	}
+	return reasons
+}
+
+// queueOverflow is the persisted set of overflow rules, keyed by queue
+type queueOverflow struct {
+	mu          sync.Mutex
+	rules       map[string]*QueueOverflowRule
+	transferred map[string]time.Time // Member UUID -> when it was transferred
+	overflowed  *counterVec
+}
+
+// newQueueOverflow loads the rules from FSAPI_DATA_DIR
+func newQueueOverflow(metrics *metricsRegistry) *queueOverflow {
+	store := &queueOverflow{
+		rules:       make(map[string]*QueueOverflowRule),
+		transferred: make(map[string]time.Time),
+		overflowed:  metrics.counter("fsapi_queue_overflow_total", "Queue members transferred by overflow rules, by reason.", "reason"),
+	}
+	var rules []*QueueOverflowRule
+	if err := loadJSONFile(queueOverflowFile, &rules); err != nil {
+		log.Printf("WARNING: Failed to load queue overflow rules: %v", err)
+	}
+	for _, rule := range rules {
+		store.rules[rule.Queue] = rule
+	}
+	return store
+}
+
+// list returns the rules sorted by queue
+func (store *queueOverflow) list() []*QueueOverflowRule {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	rules := make([]*QueueOverflowRule, 0, len(store.rules))
+	for _, rule := range store.rules {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Queue < rules[j].Queue })
+	return rules
+}
+
+func (store *queueOverflow) get(queue string) *QueueOverflowRule {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.rules[queue]
+}
+
+// save persists the rules. Caller must hold mu.
+func (store *queueOverflow) save() error {
+	rules := make([]*QueueOverflowRule, 0, len(store.rules))
+	for _, rule := range store.rules {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Queue < rules[j].Queue })
+	return saveJSONFile(queueOverflowFile, rules)
+}
+
+// claim marks memberUUID as transferred, reporting false when it already
+// was within queueOverflowHold
+func (store *queueOverflow) claim(memberUUID string, now time.Time) bool {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for member, at := range store.transferred {
+		if now.Sub(at) > queueOverflowHold {
+			delete(store.transferred, member)
+		}
+	}
+	if _, ok := store.transferred[memberUUID]; ok {
+		return false
+	}
+	store.transferred[memberUUID] = now
+	return true
+}
+
+// --- Enforcement ---
+
+// runQueueOverflow applies the rules to waiting members until shutdown
+// starts. Member events catch a full or closed queue as soon as a caller
+// joins; the ticker catches members whose wait runs out and callers who
+// joined while events were off.
+func (h *APIHandler) runQueueOverflow() {
+	ticker := time.NewTicker(queueOverflowInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, rule := range h.overflow.list() {
+				h.applyOverflowRule(rule)
+			}
+		case <-h.jobs.stopping():
+			return
+		}
+	}
+}
+
+// handleOverflowEvent checks a queue's rule when a member joins it. Listing
+// and transferring members are ESL round trips, which must not block the
+// bus; claim keeps concurrent checks of one queue from moving a member twice.
+func (h *APIHandler) handleOverflowEvent(ev *Event) {
+	if !isCCEvent(ev) || ev.Get("CC-Action") != "member-queue-start" {
+		return
+	}
+	if rule := h.overflow.get(ev.Get("CC-Queue")); rule != nil {
+		h.jobs.goJob("queue overflow "+rule.Queue, func() {
+			h.applyOverflowRule(rule)
+		})
+	}
+}
+
+// applyOverflowRule transfers the members of rule's queue that overflow to
+// its destination
+func (h *APIHandler) applyOverflowRule(rule *QueueOverflowRule) {
+	waiting, err := h.waitingMembers(rule.Queue)
+	if err != nil {
+		log.Printf("WARNING: Queue overflow: failed to list members of %s: %v", rule.Queue, err)
+		return
+	}
+	now := time.Now()
+	reasons := rule.overflowing(waiting, now)
+	domain := extractDomain(rule.Queue)
+	for _, m := range waiting {
+		reason, ok := reasons[m.UUID]
+		if !ok || m.SessionUUID == "" || !h.overflow.claim(m.UUID, now) {
+			continue
+		}
+
+		// The reason is left on the channel for the fallback dialplan
+		h.eslClient.SendCommand(fmt.Sprintf("api uuid_setvar %s fsapi_overflow_reason %s", m.SessionUUID, reason))
+		cmd := fmt.Sprintf("api uuid_transfer %s %s XML %s", m.SessionUUID, rule.Destination, domain)
+		if _, err := h.eslClient.SendCommand(cmd); err != nil {
+			log.Printf("WARNING: Queue overflow: failed to transfer %s out of %s: %v", m.SessionUUID, rule.Queue, err)
+			continue
+		}
+		h.overflow.overflowed.inc(reason)
+
+		waitSec := 0
+		if !m.Joined.IsZero() {
+			waitSec = int(now.Sub(m.Joined).Seconds())
+		}
+		log.Printf("Queue overflow: %s moved from %s to %s (%s)", m.SessionUUID, rule.Queue, rule.Destination, reason)
+		h.webhooks.dispatch("callcenter.overflow", domain, map[string]interface{}{
+			"queue":         rule.Queue,
+			"call_uuid":     m.SessionUUID,
+			"member_uuid":   m.UUID,
+			"caller_number": m.CIDNumber,
+			"reason":        reason,
+			"destination":   rule.Destination,
+			"wait_sec":      waitSec,
+		})
+	}
+}
+
+// --- Overflow rule handlers ---
+
+// CCListOverflowRules handles GET /v1/callcenter/overflow
+func (h *APIHandler) CCListOverflowRules(w http.ResponseWriter, r *http.Request) {
+	rows := []*QueueOverflowRule{}
+	for _, rule := range h.overflow.list() {
+		if isUnrestrictedAccess(r) || isDomainAllowed(rule.Queue, getAllowedContexts(r)) {
+			rows = append(rows, rule)
+		}
+	}
+	h.respondJSON(w, r, map[string]interface{}{
+		"status":    "success",
+		"row_count": len(rows),
+		"rows":      rows,
+	})
+}
+
+// CCGetOverflowRule handles GET /v1/callcenter/queues/{queue_name}/overflow
+func (h *APIHandler) CCGetOverflowRule(w http.ResponseWriter, r *http.Request) {
+	queueName := mux.Vars(r)["queue_name"]
+	if !h.validateCCDomain(w, r, queueName, "Queue") {
+		return
+	}
+	rule := h.overflow.get(queueName)
+	if rule == nil {
+		h.respondError(w, r, fmt.Sprintf("No overflow rule for queue %s", queueName), http.StatusNotFound)
+		return
+	}
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   rule,
+	})
+}
+
+// CCSetOverflowRule handles PUT /v1/callcenter/queues/{queue_name}/overflow
+func (h *APIHandler) CCSetOverflowRule(w http.ResponseWriter, r *http.Request) {
+	queueName := mux.Vars(r)["queue_name"]
+	if !queueNamePattern.MatchString(queueName) {
+		h.respondError(w, r, "queue name must be name@domain", http.StatusBadRequest)
+		return
+	}
+	if !h.validateCCDomain(w, r, queueName, "Queue") {
+		return
+	}
+
+	var rule QueueOverflowRule
+	if !h.decodeRequest(w, r, &rule) {
+		return
+	}
+	if rule.Queue != "" && rule.Queue != queueName {
+		h.respondError(w, r, "queue does not match the URL", http.StatusBadRequest)
+		return
+	}
+	rule.Queue = queueName
+	if rule.Hours != nil {
+		for i, day := range rule.Hours.Days {
+			rule.Hours.Days[i] = strings.ToLower(day)
+		}
+	}
+	if err := rule.validate(); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().UTC()
+	rule.CreatedAt = now
+	rule.UpdatedAt = now
+	h.overflow.mu.Lock()
+	if existing, ok := h.overflow.rules[queueName]; ok {
+		rule.CreatedAt = existing.CreatedAt
+	}
+	h.overflow.rules[queueName] = &rule
+	err := h.overflow.save()
+	h.overflow.mu.Unlock()
+	if err != nil {
+		logWarn(getRequestID(r), fmt.Sprintf("Failed to persist queue overflow rules: %v", err))
+	}
+
+	logInfo(getRequestID(r), fmt.Sprintf("Queue %s overflow rule set (to %s)", queueName, rule.Destination))
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   rule,
+	})
+}
+
+// CCDeleteOverflowRule handles DELETE /v1/callcenter/queues/{queue_name}/overflow
+func (h *APIHandler) CCDeleteOverflowRule(w http.ResponseWriter, r *http.Request) {
+	queueName := mux.Vars(r)["queue_name"]
+	if !h.validateCCDomain(w, r, queueName, "Queue") {
+		return
+	}
+
+	h.overflow.mu.Lock()
+	_, ok := h.overflow.rules[queueName]
+	var err error
+	if ok {
+		delete(h.overflow.rules, queueName)
+		err = h.overflow.save()
+	}
+	h.overflow.mu.Unlock()
+	if !ok {
+		h.respondError(w, r, fmt.Sprintf("No overflow rule for queue %s", queueName), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logWarn(getRequestID(r), fmt.Sprintf("Failed to persist queue overflow rules: %v", err))
+	}
+	h.respondSuccess(w, r, fmt.Sprintf("Overflow rule for queue %s deleted", queueName))
+}
@@ -87,10 +87,11 @@ func ExtractDomainFromContact(contact string) string {
 		return ""
 	}
 
-	// The value ends at the next delimiter (comma, space, curly brace, or end of string)
+	// The value ends at the next delimiter (comma, space, closing brace or
+	// bracket, quote, or end of string)
 	rest := contact[start:]
 	for i, ch := range rest {
-		if ch == ',' || ch == ' ' || ch == '}' || ch == '\'' || ch == '"' {
+		if ch == ',' || ch == ' ' || ch == '}' || ch == ']' || ch == '\'' || ch == '"' {
 			return rest[:i]
 		}
 	}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Answers within this window set the rate behind estimated waits
+const ccAnswerRateWindow = 30 * time.Minute
+
+// queueMember is a caller waiting in a queue, in service order
+type queueMember struct {
+	UUID        string
+	SessionUUID string
+	CIDNumber   string
+	CIDName     string
+	Joined      time.Time
+	Score       int
+	Position    int // 1-based
+}
+
+// waitingMembers returns the members of queue still waiting for an agent,
+// ordered the way mod_callcenter offers them: highest score first, then
+// longest waiting
+func (h *APIHandler) waitingMembers(queue string) ([]*queueMember, error) {
+	response, err := h.sendCCCommand(fmt.Sprintf("queue list members %s", queue))
+	if err != nil {
+		return nil, err
+	}
+
+	var members []*queueMember
+	for _, row := range ParsePipeDelimited(response) {
+		if row["state"] != "Waiting" && row["state"] != "Trying" {
+			continue
+		}
+		m := &queueMember{
+			UUID:        row["uuid"],
+			SessionUUID: row["session_uuid"],
+			CIDNumber:   row["cid_number"],
+			CIDName:     row["cid_name"],
+		}
+		if joined, err := strconv.ParseInt(row["joined_epoch"], 10, 64); err == nil {
+			m.Joined = time.Unix(joined, 0).UTC()
+		}
+		m.Score, _ = strconv.Atoi(row["score"])
+		members = append(members, m)
+	}
+
+	sort.SliceStable(members, func(i, j int) bool {
+		if members[i].Score != members[j].Score {
+			return members[i].Score > members[j].Score
+		}
+		return members[i].Joined.Before(members[j].Joined)
+	})
+	for i, m := range members {
+		m.Position = i + 1
+	}
+	return members, nil
+}
+
+// estimatedWait projects the wait for position from the queue's recent
+// answer rate. ok is false when no calls were answered recently.
+func (h *APIHandler) estimatedWait(queue string, position int) (time.Duration, bool) {
+	answered := 0
+	for _, o := range h.callcenter.recent(queue, time.Now().Add(-ccAnswerRateWindow)) {
+		if o.Outcome == ccOutcomeAnswered {
+			answered++
+		}
+	}
+	if answered == 0 {
+		return 0, false
+	}
+	perCall := ccAnswerRateWindow / time.Duration(answered)
+	return perCall * time.Duration(position), true
+}
+
+// QueuePosition is the response of the member position endpoint
+type QueuePosition struct {
+	Queue            string `json:"queue"`
+	MemberUUID       string `json:"member_uuid"`
+	SessionUUID      string `json:"session_uuid"`
+	Position         int    `json:"position"`
+	Waiting          int    `json:"waiting"`
+	WaitSec          int    `json:"wait_sec"`
+	EstimatedWaitSec *int   `json:"estimated_wait_sec"`
+}
+
+// CCMemberPosition handles GET /v1/callcenter/queues/{queue_name}/members/{uuid}/position
+func (h *APIHandler) CCMemberPosition(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	queueName := vars["queue_name"]
+	memberUUID := vars["uuid"]
+
+	if err := validateUUID(memberUUID); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !h.validateCCDomain(w, r, queueName, "Queue") {
+		return
+	}
+
+	members, err := h.waitingMembers(queueName)
+	if err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to list queue members: %v", err), err)
+		return
+	}
+
+	// Accept either the member UUID or the caller's channel UUID
+	var member *queueMember
+	for _, m := range members {
+		if m.UUID == memberUUID || m.SessionUUID == memberUUID {
+			member = m
+			break
+		}
+	}
+	if member == nil {
+		h.respondError(w, r, fmt.Sprintf("Member %s is not waiting in queue %s", memberUUID, queueName), http.StatusNotFound)
+		return
+	}
+
+	pos := QueuePosition{
+		Queue:       queueName,
+		MemberUUID:  member.UUID,
+		SessionUUID: member.SessionUUID,
+		Position:    member.Position,
+		Waiting:     len(members),
+	}
+	if !member.Joined.IsZero() {
+		pos.WaitSec = int(time.Since(member.Joined).Seconds())
+	}
+	if eta, ok := h.estimatedWait(queueName, member.Position); ok {
+		sec := int(math.Ceil(eta.Seconds()))
+		pos.EstimatedWaitSec = &sec
+	}
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   pos,
+	})
+}
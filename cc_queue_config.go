@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// Queue definitions are written one file per queue into FSAPI_CC_QUEUE_DIR,
+// which callcenter.conf.xml includes inside <queues>:
+//
+//	<X-PRE-PROCESS cmd="include" data="callcenter_queues/*.xml"/>
+//
+// After a change fs-api runs reloadxml and loads/reloads the queue.
+
+var queueNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+@[A-Za-z0-9.-]+$`)
+
+var ccQueueStrategies = []string{
+	"ring-all", "longest-idle-agent", "round-robin", "top-down",
+	"agent-with-least-talk-time", "agent-with-fewest-calls",
+	"sequentially-by-agent-order", "random", "ring-progressively",
+}
+
+// QueueConfig is a mod_callcenter queue definition. Unset fields are left
+// out of the XML so mod_callcenter's defaults apply.
+type QueueConfig struct {
+	Name                              string `json:"name"`
+	Strategy                          string `json:"strategy"`
+	MOHSound                          string `json:"moh_sound,omitempty"`
+	RecordTemplate                    string `json:"record_template,omitempty"`
+	TimeBaseScore                     string `json:"time_base_score,omitempty"` // queue or system
+	MaxWaitTime                       *int   `json:"max_wait_time,omitempty"`
+	MaxWaitTimeWithNoAgent            *int   `json:"max_wait_time_with_no_agent,omitempty"`
+	MaxWaitTimeWithNoAgentTimeReached *int   `json:"max_wait_time_with_no_agent_time_reached,omitempty"`
+	TierRulesApply                    *bool  `json:"tier_rules_apply,omitempty"`
+	TierRuleWaitSecond                *int   `json:"tier_rule_wait_second,omitempty"`
+	TierRuleWaitMultiplyLevel         *bool  `json:"tier_rule_wait_multiply_level,omitempty"`
+	TierRuleNoAgentNoWait             *bool  `json:"tier_rule_no_agent_no_wait,omitempty"`
+	DiscardAbandonedAfter             *int   `json:"discard_abandoned_after,omitempty"`
+	AbandonedResumeAllowed            *bool  `json:"abandoned_resume_allowed,omitempty"`
+	AnnounceSound                     string `json:"announce_sound,omitempty"`
+	AnnounceFrequency                 *int   `json:"announce_frequency,omitempty"`
+	RingProgressivelyDelay            *int   `json:"ring_progressively_delay,omitempty"`
+}
+
+type queueXMLParam struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type queueXML struct {
+	XMLName xml.Name        `xml:"queue"`
+	Name    string          `xml:"name,attr"`
+	Params  []queueXMLParam `xml:"param"`
+}
+
+type queueXMLFile struct {
+	XMLName xml.Name `xml:"include"`
+	Queue   queueXML `xml:"queue"`
+}
+
+// queueConfigField binds a QueueConfig field to its XML param
+type queueConfigField struct {
+	param string
+	str   func(c *QueueConfig) *string
+	num   func(c *QueueConfig) **int
+	flag  func(c *QueueConfig) **bool
+}
+
+var queueConfigFields = []queueConfigField{
+	{param: "strategy", str: func(c *QueueConfig) *string { return &c.Strategy }},
+	{param: "moh-sound", str: func(c *QueueConfig) *string { return &c.MOHSound }},
+	{param: "record-template", str: func(c *QueueConfig) *string { return &c.RecordTemplate }},
+	{param: "time-base-score", str: func(c *QueueConfig) *string { return &c.TimeBaseScore }},
+	{param: "max-wait-time", num: func(c *QueueConfig) **int { return &c.MaxWaitTime }},
+	{param: "max-wait-time-with-no-agent", num: func(c *QueueConfig) **int { return &c.MaxWaitTimeWithNoAgent }},
+	{param: "max-wait-time-with-no-agent-time-reached", num: func(c *QueueConfig) **int { return &c.MaxWaitTimeWithNoAgentTimeReached }},
+	{param: "tier-rules-apply", flag: func(c *QueueConfig) **bool { return &c.TierRulesApply }},
+	{param: "tier-rule-wait-second", num: func(c *QueueConfig) **int { return &c.TierRuleWaitSecond }},
+	{param: "tier-rule-wait-multiply-level", flag: func(c *QueueConfig) **bool { return &c.TierRuleWaitMultiplyLevel }},
+	{param: "tier-rule-no-agent-no-wait", flag: func(c *QueueConfig) **bool { return &c.TierRuleNoAgentNoWait }},
+	{param: "discard-abandoned-after", num: func(c *QueueConfig) **int { return &c.DiscardAbandonedAfter }},
+	{param: "abandoned-resume-allowed", flag: func(c *QueueConfig) **bool { return &c.AbandonedResumeAllowed }},
+	{param: "announce-sound", str: func(c *QueueConfig) *string { return &c.AnnounceSound }},
+	{param: "announce-frequency", num: func(c *QueueConfig) **int { return &c.AnnounceFrequency }},
+	{param: "ring-progressively-delay", num: func(c *QueueConfig) **int { return &c.RingProgressivelyDelay }},
+}
+
+// validate checks the definition before it is written
+func (c *QueueConfig) validate() error {
+	if !containsString(ccQueueStrategies, c.Strategy) {
+		return fmt.Errorf("strategy must be one of: %v", ccQueueStrategies)
+	}
+	if c.TimeBaseScore != "" && c.TimeBaseScore != "queue" && c.TimeBaseScore != "system" {
+		return fmt.Errorf("time_base_score must be queue or system")
+	}
+	for _, f := range queueConfigFields {
+		if f.num != nil {
+			if v := *f.num(c); v != nil && *v < 0 {
+				return fmt.Errorf("%s must not be negative", f.param)
+			}
+		}
+	}
+	return nil
+}
+
+// toXML renders the definition as an include file
+func (c *QueueConfig) toXML() ([]byte, error) {
+	q := queueXML{Name: c.Name}
+	for _, f := range queueConfigFields {
+		var value string
+		switch {
+		case f.str != nil:
+			value = *f.str(c)
+		case f.num != nil:
+			if v := *f.num(c); v != nil {
+				value = strconv.Itoa(*v)
+			}
+		case f.flag != nil:
+			if v := *f.flag(c); v != nil {
+				value = strconv.FormatBool(*v)
+			}
+		}
+		if value != "" {
+			q.Params = append(q.Params, queueXMLParam{Name: f.param, Value: value})
+		}
+	}
+	data, err := xml.MarshalIndent(queueXMLFile{Queue: q}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// setParam sets the field of the XML param name; unknown params and values
+// that do not parse are ignored
+func (c *QueueConfig) setParam(name, value string) {
+	for _, f := range queueConfigFields {
+		if f.param != name {
+			continue
+		}
+		switch {
+		case f.str != nil:
+			*f.str(c) = value
+		case f.num != nil:
+			if n, err := strconv.Atoi(value); err == nil {
+				*f.num(c) = &n
+			}
+		case f.flag != nil:
+			if b, err := strconv.ParseBool(value); err == nil {
+				*f.flag(c) = &b
+			}
+		}
+	}
+}
+
+// queueConfigFromXML parses an include file written by toXML
+func queueConfigFromXML(data []byte) (*QueueConfig, error) {
+	var file queueXMLFile
+	if err := xml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	c := &QueueConfig{Name: file.Queue.Name}
+	for _, p := range file.Queue.Params {
+		c.setParam(p.Name, p.Value)
+	}
+	return c, nil
+}
+
+// queueConfigPath returns the include file of queue, or "" when queue
+// provisioning is disabled. On "" the error response has been written.
+func (h *APIHandler) queueConfigPath(w http.ResponseWriter, r *http.Request, queue string) string {
+	if FSAPI_CC_QUEUE_DIR == "" {
+		h.respondError(w, r, "Queue provisioning is disabled (FSAPI_CC_QUEUE_DIR is not set)", http.StatusNotImplemented)
+		return ""
+	}
+	if !queueNamePattern.MatchString(queue) {
+		h.respondError(w, r, "queue name must be of the form name@domain", http.StatusBadRequest)
+		return ""
+	}
+	return filepath.Join(FSAPI_CC_QUEUE_DIR, queue+".xml")
+}
+
+// writeQueueConfig writes the include file, reloads the XML registry and
+// loads the queue (or reloads it when it is already running)
+func (h *APIHandler) writeQueueConfig(path string, c *QueueConfig, existed bool) error {
+	data, err := c.toXML()
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	if _, err := h.eslClient.SendCommand("api reloadxml"); err != nil {
+		return err
+	}
+	action := "load"
+	if existed {
+		action = "reload"
+	}
+	_, err = h.sendCCCommand(fmt.Sprintf("queue %s %s", action, c.Name))
+	return err
+}
+
+// decodeQueueConfig reads and validates a queue definition body
+func (h *APIHandler) decodeQueueConfig(w http.ResponseWriter, r *http.Request) (*QueueConfig, bool) {
+	var c QueueConfig
+	if !h.decodeRequest(w, r, &c) {
+		return nil, false
+	}
+	if err := c.validate(); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return &c, true
+}
+
+// CCCreateQueue handles POST /v1/callcenter/queues
+func (h *APIHandler) CCCreateQueue(w http.ResponseWriter, r *http.Request) {
+	c, ok := h.decodeQueueConfig(w, r)
+	if !ok {
+		return
+	}
+	path := h.queueConfigPath(w, r, c.Name)
+	if path == "" {
+		return
+	}
+	if !h.validateCCDomain(w, r, c.Name, "Queue") {
+		return
+	}
+	if _, err := os.Stat(path); err == nil {
+		h.respondError(w, r, fmt.Sprintf("Queue %s already exists", c.Name), http.StatusConflict)
+		return
+	}
+
+	if err := h.writeQueueConfig(path, c, false); err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to create queue: %v", err), err)
+		return
+	}
+
+	logInfo(getRequestID(r), fmt.Sprintf("Queue %s created", c.Name))
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   c,
+	})
+}
+
+// CCUpdateQueue handles PUT /v1/callcenter/queues/{queue_name}
+func (h *APIHandler) CCUpdateQueue(w http.ResponseWriter, r *http.Request) {
+	queueName := mux.Vars(r)["queue_name"]
+	path := h.queueConfigPath(w, r, queueName)
+	if path == "" {
+		return
+	}
+	if !h.validateCCDomain(w, r, queueName, "Queue") {
+		return
+	}
+	c, ok := h.decodeQueueConfig(w, r)
+	if !ok {
+		return
+	}
+	if c.Name != "" && c.Name != queueName {
+		h.respondError(w, r, "name in body does not match the URL", http.StatusBadRequest)
+		return
+	}
+	c.Name = queueName
+
+	_, statErr := os.Stat(path)
+	if err := h.writeQueueConfig(path, c, statErr == nil); err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to update queue: %v", err), err)
+		return
+	}
+
+	logInfo(getRequestID(r), fmt.Sprintf("Queue %s definition written", queueName))
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   c,
+	})
+}
+
+// CCGetQueueConfig handles GET /v1/callcenter/queues/{queue_name}/config
+func (h *APIHandler) CCGetQueueConfig(w http.ResponseWriter, r *http.Request) {
+	queueName := mux.Vars(r)["queue_name"]
+	path := h.queueConfigPath(w, r, queueName)
+	if path == "" {
+		return
+	}
+	if !h.validateCCDomain(w, r, queueName, "Queue") {
+		return
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		h.respondError(w, r, fmt.Sprintf("Queue %s is not provisioned through the API", queueName), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.respondError(w, r, fmt.Sprintf("Failed to read queue definition: %v", err), http.StatusInternalServerError)
+		return
+	}
+	c, err := queueConfigFromXML(data)
+	if err != nil {
+		h.respondError(w, r, fmt.Sprintf("Failed to parse queue definition: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   c,
+	})
+}
+
+// CCDeleteQueue handles DELETE /v1/callcenter/queues/{queue_name}
+func (h *APIHandler) CCDeleteQueue(w http.ResponseWriter, r *http.Request) {
+	queueName := mux.Vars(r)["queue_name"]
+	path := h.queueConfigPath(w, r, queueName)
+	if path == "" {
+		return
+	}
+	if !h.validateCCDomain(w, r, queueName, "Queue") {
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		h.respondError(w, r, fmt.Sprintf("Queue %s is not provisioned through the API", queueName), http.StatusNotFound)
+		return
+	}
+
+	// A queue that is not running cannot be unloaded; that is fine here
+	h.sendCCCommand(fmt.Sprintf("queue unload %s", queueName))
+
+	if err := os.Remove(path); err != nil {
+		h.respondError(w, r, fmt.Sprintf("Failed to remove queue definition: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if _, err := h.eslClient.SendCommand("api reloadxml"); err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Queue definition removed but reloadxml failed: %v", err), err)
+		return
+	}
+
+	h.respondSuccess(w, r, fmt.Sprintf("Queue %s deleted", queueName))
+}
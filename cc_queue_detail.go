@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// QueueDetail is the effective configuration of a queue: the parameters
+// mod_callcenter runs it with, completed from its XML definition for those
+// its queue list does not show (announce_sound, announce_frequency)
+type QueueDetail struct {
+	QueueConfig
+	Loaded         bool `json:"loaded"`      // Running in mod_callcenter
+	Provisioned    bool `json:"provisioned"` // Defined through the API (FSAPI_CC_QUEUE_DIR)
+	CallsAnswered  int  `json:"calls_answered"`
+	CallsAbandoned int  `json:"calls_abandoned"`
+}
+
+// callcenterConfXML is the part of callcenter.conf that defines queues
+type callcenterConfXML struct {
+	Queues []queueXML `xml:"queues>queue"`
+}
+
+// queueDefinition returns the XML definition of queue as FreeSWITCH has
+// loaded it (xml_locate), or else the include file fs-api wrote for it;
+// nil when neither has it
+func (h *APIHandler) queueDefinition(queue string) *QueueConfig {
+	response, err := h.eslClient.SendCommand("api xml_locate configuration configuration name callcenter.conf")
+	if err == nil && strings.HasPrefix(strings.TrimSpace(response), "<") {
+		var conf callcenterConfXML
+		if xml.Unmarshal([]byte(response), &conf) == nil {
+			for _, q := range conf.Queues {
+				if q.Name != queue {
+					continue
+				}
+				c := &QueueConfig{Name: q.Name}
+				for _, p := range q.Params {
+					c.setParam(p.Name, p.Value)
+				}
+				return c
+			}
+		}
+	}
+	if FSAPI_CC_QUEUE_DIR != "" && queueNamePattern.MatchString(queue) {
+		if data, err := os.ReadFile(filepath.Join(FSAPI_CC_QUEUE_DIR, queue+".xml")); err == nil {
+			if c, err := queueConfigFromXML(data); err == nil {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// CCGetQueue handles GET /v1/callcenter/queues/{queue_name}
+func (h *APIHandler) CCGetQueue(w http.ResponseWriter, r *http.Request) {
+	queueName := mux.Vars(r)["queue_name"]
+	if !h.validateCCDomain(w, r, queueName, "Queue") {
+		return
+	}
+
+	response, err := h.sendCCCommand("queue list")
+	if err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to list queues: %v", err), err)
+		return
+	}
+	var row map[string]string
+	for _, q := range ParsePipeDelimited(response) {
+		if q["name"] == queueName {
+			row = q
+			break
+		}
+	}
+	def := h.queueDefinition(queueName)
+	if row == nil && def == nil {
+		h.respondError(w, r, fmt.Sprintf("Queue %s not found", queueName), http.StatusNotFound)
+		return
+	}
+
+	detail := QueueDetail{QueueConfig: QueueConfig{Name: queueName}}
+	if def != nil {
+		detail.QueueConfig = *def
+	}
+	// What the running queue reports wins over its definition, which may
+	// have changed without a reload
+	if row != nil {
+		detail.Loaded = true
+		for col, v := range row {
+			detail.setParam(strings.ReplaceAll(col, "_", "-"), v)
+		}
+		detail.CallsAnswered, _ = strconv.Atoi(row["calls_answered"])
+		detail.CallsAbandoned, _ = strconv.Atoi(row["calls_abandoned"])
+	}
+	if FSAPI_CC_QUEUE_DIR != "" && queueNamePattern.MatchString(queueName) {
+		_, err := os.Stat(filepath.Join(FSAPI_CC_QUEUE_DIR, queueName+".xml"))
+		detail.Provisioned = err == nil
+	}
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   detail,
+	})
+}
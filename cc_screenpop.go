@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ScreenPop is the data of the agent.screen_pop webhook, sent when a queue
+// call is bridged to an agent
+type ScreenPop struct {
+	Agent      string            `json:"agent"`
+	AgentUUID  string            `json:"agent_uuid,omitempty"`
+	Queue      string            `json:"queue"`
+	Domain     string            `json:"domain"`
+	CallUUID   string            `json:"call_uuid"`
+	MemberUUID string            `json:"member_uuid"`
+	CIDNumber  string            `json:"caller_number"`
+	CIDName    string            `json:"caller_name"`
+	Joined     time.Time         `json:"joined_at"`
+	Answered   time.Time         `json:"answered_at"`
+	WaitSec    int               `json:"wait_sec"`
+	Variables  map[string]string `json:"variables"`
+}
+
+// handleScreenPopEvent is the event bus subscriber sending agent.screen_pop
+// on bridge-agent-start. The webhook context is the queue's domain, so
+// tenants register a webhook scoped to their domain to receive pops.
+func (h *APIHandler) handleScreenPopEvent(ev *Event) {
+	if !isCCEvent(ev) || ev.Get("CC-Action") != "bridge-agent-start" {
+		return
+	}
+
+	queue := ev.Get("CC-Queue")
+	pop := &ScreenPop{
+		Agent:      ev.Get("CC-Agent"),
+		AgentUUID:  ev.Get("CC-Agent-UUID"),
+		Queue:      queue,
+		Domain:     extractDomain(queue),
+		CallUUID:   ev.Get("CC-Member-Session-UUID"),
+		MemberUUID: ev.Get("CC-Member-UUID"),
+		CIDNumber:  ev.Get("CC-Member-CID-Number"),
+		CIDName:    ev.Get("CC-Member-CID-Name"),
+		Joined:     ccEpoch(ev, "CC-Member-Joined-Time"),
+		Answered:   ccEpoch(ev, "CC-Agent-Answered-Time"),
+		Variables:  make(map[string]string, len(h.screenPopVars)),
+	}
+	pop.WaitSec = int(pop.Answered.Sub(pop.Joined).Seconds())
+
+	// Variables missing from the event (channels without verbose events)
+	// are read from the caller's channel, which must not block the bus
+	var missing []string
+	for _, name := range h.screenPopVars {
+		if v := ev.Var(name); v != "" {
+			pop.Variables[name] = v
+		} else {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 || pop.CallUUID == "" {
+		h.webhooks.dispatch("agent.screen_pop", pop.Domain, pop)
+		return
+	}
+
+	h.jobs.goJob("screen pop "+pop.CallUUID, func() {
+		for _, name := range missing {
+			value, err := h.eslClient.SendCommand(fmt.Sprintf("api uuid_getvar %s %s", pop.CallUUID, name))
+			if err != nil {
+				continue
+			}
+			if value = strings.TrimSpace(value); value != "" && value != "_undef_" {
+				pop.Variables[name] = value
+			}
+		}
+		h.webhooks.dispatch("agent.screen_pop", pop.Domain, pop)
+	})
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Bounds for the ?window= of the SLA endpoint; the upper bound is also how
+// long member outcomes are kept
+const (
+	ccSLADefaultWindow = time.Hour
+	ccSLAMaxWindow     = 24 * time.Hour
+)
+
+// QueueSLA is the service-level summary for one queue over a window
+type QueueSLA struct {
+	Queue                   string   `json:"queue"`
+	WindowSec               int      `json:"window_sec"`
+	ThresholdSec            int      `json:"threshold_sec"`
+	Offered                 int      `json:"offered"`
+	Answered                int      `json:"answered"`
+	AnsweredWithinThreshold int      `json:"answered_within_threshold"`
+	Abandoned               int      `json:"abandoned"`
+	TimedOut                int      `json:"timed_out"`
+	ServiceLevel            *float64 `json:"service_level"`    // answered within threshold / offered
+	AbandonmentRate         *float64 `json:"abandonment_rate"` // abandoned / offered
+	AverageWaitSec          float64  `json:"average_wait_sec"` // answered members only
+	Waiting                 int      `json:"waiting"`
+	LongestCurrentWaitSec   int      `json:"longest_current_wait_sec"`
+}
+
+// slaThreshold returns the answer threshold configured for queue
+func (h *APIHandler) slaThreshold(queue string) time.Duration {
+	if threshold, ok := h.slaThresholds[queue]; ok {
+		return threshold
+	}
+	if threshold, ok := h.slaThresholds["*"]; ok {
+		return threshold
+	}
+	return 20 * time.Second
+}
+
+// ratio returns n/d rounded to three decimals, or nil when d is zero
+func ratio(n, d int) *float64 {
+	if d == 0 {
+		return nil
+	}
+	v := math.Round(float64(n)/float64(d)*1000) / 1000
+	return &v
+}
+
+// CCQueueSLA handles GET /v1/callcenter/queues/{queue_name}/sla
+func (h *APIHandler) CCQueueSLA(w http.ResponseWriter, r *http.Request) {
+	queueName := mux.Vars(r)["queue_name"]
+	if !h.validateCCDomain(w, r, queueName, "Queue") {
+		return
+	}
+
+	window := ccSLADefaultWindow
+	if v := r.URL.Query().Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || d > ccSLAMaxWindow {
+			h.respondError(w, r, fmt.Sprintf("window must be a duration between 1s and %s (e.g. 15m, 1h)", ccSLAMaxWindow), http.StatusBadRequest)
+			return
+		}
+		window = d
+	}
+
+	threshold := h.slaThreshold(queueName)
+	if v := r.URL.Query().Get("threshold"); v != "" {
+		sec, err := strconv.Atoi(v)
+		if err != nil || sec <= 0 {
+			h.respondError(w, r, "threshold must be a positive number of seconds", http.StatusBadRequest)
+			return
+		}
+		threshold = time.Duration(sec) * time.Second
+	}
+
+	// Current waiters come from mod_callcenter itself so they survive restarts
+	response, err := h.sendCCCommand(fmt.Sprintf("queue list members %s", queueName))
+	if err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to list queue members: %v", err), err)
+		return
+	}
+
+	now := time.Now()
+	sla := QueueSLA{
+		Queue:        queueName,
+		WindowSec:    int(window.Seconds()),
+		ThresholdSec: int(threshold.Seconds()),
+	}
+
+	for _, member := range ParsePipeDelimited(response) {
+		if member["state"] != "Waiting" && member["state"] != "Trying" {
+			continue
+		}
+		sla.Waiting++
+		joined, err := strconv.ParseInt(member["joined_epoch"], 10, 64)
+		if err != nil || joined <= 0 {
+			continue
+		}
+		if wait := int(now.Sub(time.Unix(joined, 0)).Seconds()); wait > sla.LongestCurrentWaitSec {
+			sla.LongestCurrentWaitSec = wait
+		}
+	}
+
+	totalWait := 0.0
+	for _, o := range h.callcenter.recent(queueName, now.Add(-window)) {
+		sla.Offered++
+		switch o.Outcome {
+		case ccOutcomeAnswered:
+			sla.Answered++
+			totalWait += o.WaitSec()
+			if o.WaitSec() <= threshold.Seconds() {
+				sla.AnsweredWithinThreshold++
+			}
+		case ccOutcomeAbandoned, ccOutcomeBreakOut:
+			sla.Abandoned++
+		case ccOutcomeTimeout:
+			sla.TimedOut++
+		}
+	}
+	if sla.Answered > 0 {
+		sla.AverageWaitSec = math.Round(totalWait/float64(sla.Answered)*10) / 10
+	}
+	sla.ServiceLevel = ratio(sla.AnsweredWithinThreshold, sla.Offered)
+	sla.AbandonmentRate = ratio(sla.Abandoned, sla.Offered)
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   sla,
+	})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// CCCounts are the numbers of callcenter objects, as the /count endpoints
+// report them one at a time
+type CCCounts struct {
+	Queues  int `json:"queues"`
+	Agents  int `json:"agents"`
+	Members int `json:"members"`
+	Tiers   int `json:"tiers"`
+}
+
+// CallcenterStats is returned by GET /v1/callcenter/stats: the counts the
+// caller can see, in total and per domain. Agents count towards the domain_name of
+// their contact; queues, members and tiers towards the domain of the queue.
+type CallcenterStats struct {
+	CCCounts
+	Domains map[string]*CCCounts `json:"domains"`
+}
+
+// domain returns the counts of domain, adding it when it has none yet
+func (s *CallcenterStats) domain(domain string) *CCCounts {
+	counts, ok := s.Domains[domain]
+	if !ok {
+		counts = &CCCounts{}
+		s.Domains[domain] = counts
+	}
+	return counts
+}
+
+// CCStats handles GET /v1/callcenter/stats
+func (h *APIHandler) CCStats(w http.ResponseWriter, r *http.Request) {
+	unrestricted := isUnrestrictedAccess(r)
+	allowed := getAllowedContexts(r)
+
+	response, err := h.sendCCCommand("queue list")
+	if err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to list queues: %v", err), err)
+		return
+	}
+	queues := ParsePipeDelimited(response)
+
+	response, err = h.sendCCCommand("agent list")
+	if err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to list agents: %v", err), err)
+		return
+	}
+	agents := ParsePipeDelimited(response)
+
+	response, err = h.sendCCCommand("tier list")
+	if err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to list tiers: %v", err), err)
+		return
+	}
+	tiers := ParsePipeDelimited(response)
+
+	if !unrestricted {
+		queues = filterByDomain(queues, "name", allowed)
+		agents = filterAgentsByDomain(agents, allowed)
+		tiers = filterByDomain(tiers, "queue", allowed)
+	}
+
+	stats := CallcenterStats{Domains: make(map[string]*CCCounts)}
+	// Restricted callers see every domain they may access, even empty ones
+	if !unrestricted {
+		for _, domain := range allowed {
+			stats.domain(domain)
+		}
+	}
+
+	for _, queue := range queues {
+		name := queue["name"]
+		response, err := h.sendCCCommand(fmt.Sprintf("queue count members %s", name))
+		if err != nil {
+			h.respondESLError(w, r, fmt.Sprintf("Failed to count members of queue %s: %v", name, err), err)
+			return
+		}
+		members, err := ParsePlainCount(response)
+		if err != nil {
+			h.respondError(w, r, fmt.Sprintf("Failed to parse member count: %v", err), http.StatusInternalServerError)
+			return
+		}
+		stats.Queues++
+		stats.Members += members
+		if domain := extractDomain(name); domain != "" {
+			counts := stats.domain(domain)
+			counts.Queues++
+			counts.Members += members
+		}
+	}
+	for _, agent := range agents {
+		stats.Agents++
+		if domain := ExtractDomainFromContact(agent["contact"]); domain != "" {
+			stats.domain(domain).Agents++
+		}
+	}
+	for _, tier := range tiers {
+		stats.Tiers++
+		if domain := extractDomain(tier["queue"]); domain != "" {
+			stats.domain(domain).Tiers++
+		}
+	}
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   stats,
+	})
+}
@@ -3,15 +3,38 @@ package main
 // Callcenter request types
 
 type AgentAddRequest struct {
-	Name   string `json:"name"`   // UUID
-	Type   string `json:"type"`   // callback or uuid-standby
-	Domain string `json:"domain"` // for auth validation
+	Name    string        `json:"name"`              // UUID
+	Type    string        `json:"type"`              // callback or uuid-standby
+	Domain  string        `json:"domain"`            // for auth validation
+	Contact *AgentContact `json:"contact,omitempty"` // Optional: set with the agent (callback only)
+
+	// Optional settings applied with the agent; zero leaves mod_callcenter's default
+	Status          string `json:"status,omitempty"` // Set last, once the agent is configured
+	MaxNoAnswer     int    `json:"max_no_answer,omitempty"`
+	WrapUpTime      int    `json:"wrap_up_time,omitempty"`
+	RejectDelayTime int    `json:"reject_delay_time,omitempty"`
+	BusyDelayTime   int    `json:"busy_delay_time,omitempty"`
 }
 
 type AgentSetRequest struct {
-	Key    string `json:"key"`
-	Value  string `json:"value"`
-	Domain string `json:"domain"` // for auth validation
+	Key     string        `json:"key"`
+	Value   string        `json:"value"`
+	Domain  string        `json:"domain"`            // for auth validation
+	Contact *AgentContact `json:"contact,omitempty"` // Instead of value for key contact
+}
+
+// AgentContact is how mod_callcenter rings an agent, rendered by fs-api
+// into the agent's contact string
+type AgentContact struct {
+	User           string        `json:"user"`                       // Directory user rung
+	Domain         string        `json:"domain,omitempty"`           // Directory domain; default the agent's domain
+	DialTimeoutSec int           `json:"dial_timeout_sec,omitempty"` // How long the user rings (call_timeout)
+	Confirm        *AgentConfirm `json:"confirm,omitempty"`          // Optional: the agent accepts the call with a key
+}
+
+type AgentConfirm struct {
+	File string `json:"file"`          // Prompt played when the agent answers
+	Key  string `json:"key,omitempty"` // Digit that accepts the call; default 1
 }
 
 type AgentDelRequest struct {
@@ -37,6 +60,51 @@ type TierSetRequest struct {
 	Value string `json:"value"`
 }
 
+type QueueAnnounceRequest struct {
+	File        string       `json:"file,omitempty"`         // Audio file path template
+	TTS         *AnnounceTTS `json:"tts,omitempty"`          // Or text-to-speech
+	Say         *SayPrompt   `json:"say,omitempty"`          // Or a value spoken with mod_say
+	Locale      string       `json:"locale,omitempty"`       // Instead of X-Locale: default say language and TTS voice
+	IntervalSec int          `json:"interval_sec,omitempty"` // 0 = one-shot
+}
+
+type AnnounceTTS struct {
+	Engine string `json:"engine"`          // e.g. flite
+	Voice  string `json:"voice,omitempty"` // e.g. kal
+	Text   string `json:"text"`            // Text template
+}
+
+type AbandonedCallbackRequest struct {
+	Endpoint       *DialTarget    `json:"endpoint,omitempty"` // Default: loopback through the queue domain's dialplan
+	CallerIDNumber string         `json:"caller_id_number,omitempty"`
+	CallerIDName   string         `json:"caller_id_name,omitempty"`
+	TimeoutSec     int            `json:"timeout_sec,omitempty"`
+	Whisper        *WhisperPrompt `json:"whisper,omitempty"` // Played to the agent before connecting
+}
+
+type WrapUpExtendRequest struct {
+	Seconds int `json:"seconds"` // Added to the current wrap-up deadline
+}
+
+type AgentForceRequest struct {
+	Status string       `json:"status,omitempty"`
+	State  string       `json:"state,omitempty"`
+	Reason string       `json:"reason"`           // Recorded in the audit log
+	Notify *AgentNotify `json:"notify,omitempty"` // Optional agent notification
+}
+
+type AgentNotify struct {
+	Method  string `json:"method"`            // sms or broadcast
+	Message string `json:"message,omitempty"` // sms text
+	File    string `json:"file,omitempty"`    // broadcast audio file
+}
+
+type AgentBulkStatusRequest struct {
+	Agents []string `json:"agents"`
+	Status string   `json:"status,omitempty"`
+	State  string   `json:"state,omitempty"`
+}
+
 // Callcenter response types
 
 type CCListResponse struct {
@@ -50,6 +118,13 @@ type CCCountResponse struct {
 	Count  int    `json:"count"`
 }
 
+// AgentBulkOutcome is the result of a bulk status update for one agent
+type AgentBulkOutcome struct {
+	Agent  string `json:"agent"`
+	Result string `json:"result"`          // updated, not_found, forbidden or failed
+	Error  string `json:"error,omitempty"` // Why the agent was not updated
+}
+
 // Validation maps for allowed set keys
 
 var validAgentSetKeys = map[string]bool{
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const agentActivityFile = "agent_activity.jsonl"
+
+// How long agent activity transitions are kept
+const agentActivityRetention = 31 * 24 * time.Hour
+
+// Agent activities derived from mod_callcenter status and state
+const (
+	agentActivityLoggedOut = "Logged Out"
+	agentActivityAvailable = "Available"
+	agentActivityOnBreak   = "On Break"
+	agentActivityReceiving = "Receiving"
+	agentActivityInCall    = "In a queue call"
+	agentActivityWrapUp    = "Wrap Up"
+	agentActivityUnknown   = "Unknown"
+)
+
+// agentTransition records an agent entering an activity
+type agentTransition struct {
+	Agent    string    `json:"agent"`
+	At       time.Time `json:"at"`
+	Status   string    `json:"status"`
+	State    string    `json:"state"`
+	Activity string    `json:"activity"`
+}
+
+// agentActivityLog follows callcenter agent status/state events and keeps
+// each agent's activity transitions, persisted to agent_activity.jsonl
+type agentActivityLog struct {
+	mu          sync.Mutex
+	transitions map[string][]*agentTransition // agent -> oldest first
+	current     map[string]*agentTransition
+}
+
+// newAgentActivityLog loads transitions from disk, dropping those older than
+// the retention period
+func newAgentActivityLog() *agentActivityLog {
+	a := &agentActivityLog{
+		transitions: make(map[string][]*agentTransition),
+		current:     make(map[string]*agentTransition),
+	}
+	cutoff := time.Now().Add(-agentActivityRetention)
+	total, kept := 0, 0
+	err := loadJSONLines(agentActivityFile, func(line []byte) {
+		var t agentTransition
+		if json.Unmarshal(line, &t) != nil || t.Agent == "" {
+			return
+		}
+		total++
+		if t.At.Before(cutoff) {
+			return
+		}
+		kept++
+		a.transitions[t.Agent] = append(a.transitions[t.Agent], &t)
+		a.current[t.Agent] = &t
+	})
+	if err != nil {
+		log.Printf("WARNING: Failed to load agent activity: %v", err)
+	}
+	if kept < total {
+		var all []*agentTransition
+		for _, list := range a.transitions {
+			all = append(all, list...)
+		}
+		if err := rewriteJSONLines(agentActivityFile, len(all), func(i int) interface{} { return all[i] }); err != nil {
+			log.Printf("WARNING: Failed to compact agent activity: %v", err)
+		}
+	}
+	return a
+}
+
+// agentActivityFor maps a callcenter status/state pair to an activity
+func agentActivityFor(status, state string) string {
+	switch {
+	case state == "In a queue call":
+		return agentActivityInCall
+	case state == "Receiving":
+		return agentActivityReceiving
+	case status == "Logged Out":
+		return agentActivityLoggedOut
+	case status == "On Break":
+		return agentActivityOnBreak
+	case status == "" && state == "":
+		return agentActivityUnknown
+	default:
+		return agentActivityAvailable
+	}
+}
+
+// handleEvent is the event bus subscriber
+func (a *agentActivityLog) handleEvent(ev *Event) {
+	if !isCCEvent(ev) {
+		return
+	}
+	agent := ev.Get("CC-Agent")
+	if agent == "" {
+		return
+	}
+
+	a.mu.Lock()
+	prev := a.current[agent]
+	next := &agentTransition{Agent: agent, At: ev.Time}
+	if prev != nil {
+		next.Status, next.State = prev.Status, prev.State
+	}
+
+	switch ev.Get("CC-Action") {
+	case "agent-status-change":
+		next.Status = ev.Get("CC-Agent-Status")
+		next.Activity = agentActivityFor(next.Status, next.State)
+	case "agent-state-change":
+		next.State = ev.Get("CC-Agent-State")
+		next.Activity = agentActivityFor(next.Status, next.State)
+	case "bridge-agent-end":
+		// Wrap-up lasts until the next status/state change; it is capped at
+		// the agent's wrap_up_time when reporting
+		next.At = ccEpoch(ev, "CC-Bridge-Terminated-Time")
+		next.Activity = agentActivityWrapUp
+	default:
+		a.mu.Unlock()
+		return
+	}
+
+	if prev != nil && prev.Activity == next.Activity {
+		a.mu.Unlock()
+		return
+	}
+	a.current[agent] = next
+	list := append(a.transitions[agent], next)
+	cutoff := time.Now().Add(-agentActivityRetention)
+	for len(list) > 1 && list[1].At.Before(cutoff) {
+		list = list[1:]
+	}
+	a.transitions[agent] = list
+	a.mu.Unlock()
+
+	if err := appendJSONLine(agentActivityFile, next); err != nil {
+		log.Printf("WARNING: Failed to persist agent activity for %s: %v", agent, err)
+	}
+}
+
+// history returns a copy of agent's transitions
+func (a *agentActivityLog) history(agent string) []agentTransition {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	list := make([]agentTransition, 0, len(a.transitions[agent]))
+	for _, t := range a.transitions[agent] {
+		list = append(list, *t)
+	}
+	return list
+}
+
+// AgentUtilization is the time-in-activity breakdown for one agent
+type AgentUtilization struct {
+	Agent           string             `json:"agent"`
+	From            time.Time          `json:"from"`
+	To              time.Time          `json:"to"`
+	PeriodSec       int                `json:"period_sec"`
+	Seconds         map[string]float64 `json:"seconds"`
+	LoggedInSec     float64            `json:"logged_in_sec"`
+	Utilization     *float64           `json:"utilization"` // (in call + wrap up) / logged in
+	CallsHandled    int                `json:"calls_handled"`
+	CurrentActivity string             `json:"current_activity"`
+}
+
+// utilization splits [from, to) into time per activity. Wrap-up segments
+// longer than wrapUp are cut off there and the rest counted as the activity
+// the agent's status implies.
+func utilization(history []agentTransition, from, to time.Time, wrapUp time.Duration) (map[string]float64, int) {
+	seconds := map[string]float64{}
+	calls := 0
+
+	add := func(activity string, start, end time.Time) {
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if end.After(start) {
+			seconds[activity] += end.Sub(start).Seconds()
+		}
+	}
+
+	// Time before the first known transition is unaccounted for
+	if len(history) == 0 || history[0].At.After(from) {
+		end := to
+		if len(history) > 0 {
+			end = history[0].At
+		}
+		add(agentActivityUnknown, from, end)
+	}
+
+	for i, t := range history {
+		end := to
+		if i+1 < len(history) {
+			end = history[i+1].At
+		}
+		if t.Activity == agentActivityWrapUp {
+			if !t.At.Before(from) && t.At.Before(to) {
+				calls++
+			}
+			if wrapUp > 0 && end.Sub(t.At) > wrapUp {
+				add(agentActivityWrapUp, t.At, t.At.Add(wrapUp))
+				add(agentActivityFor(t.Status, "Waiting"), t.At.Add(wrapUp), end)
+				continue
+			}
+		}
+		add(t.Activity, t.At, end)
+	}
+
+	for activity, sec := range seconds {
+		seconds[activity] = math.Round(sec*10) / 10
+	}
+	return seconds, calls
+}
+
+// CCAgentUtilization handles GET /v1/callcenter/agents/{agent_name}/utilization
+func (h *APIHandler) CCAgentUtilization(w http.ResponseWriter, r *http.Request) {
+	agentName := mux.Vars(r)["agent_name"]
+
+	now := time.Now().UTC()
+	to := now
+	from := now.Add(-24 * time.Hour)
+	for param, target := range map[string]*time.Time{"from": &from, "to": &to} {
+		if v := r.URL.Query().Get(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				h.respondError(w, r, fmt.Sprintf("%s must be an RFC 3339 timestamp", param), http.StatusBadRequest)
+				return
+			}
+			*target = t.UTC()
+		}
+	}
+	if to.After(now) {
+		to = now
+	}
+	if !from.Before(to) {
+		h.respondError(w, r, "from must be before to", http.StatusBadRequest)
+		return
+	}
+
+	// The agent's domain lives in its contact string
+	agent, ok := h.lookupCCAgent(w, r, agentName)
+	if !ok {
+		return
+	}
+
+	wrapUpSec, _ := strconv.Atoi(agent["wrap_up_time"])
+	history := h.agentActivity.history(agentName)
+	seconds, calls := utilization(history, from, to, time.Duration(wrapUpSec)*time.Second)
+
+	report := AgentUtilization{
+		Agent:           agentName,
+		From:            from,
+		To:              to,
+		PeriodSec:       int(to.Sub(from).Seconds()),
+		Seconds:         seconds,
+		CallsHandled:    calls,
+		CurrentActivity: agentActivityFor(agent["status"], agent["state"]),
+	}
+	for activity, sec := range seconds {
+		if activity != agentActivityLoggedOut && activity != agentActivityUnknown {
+			report.LoggedInSec += sec
+		}
+	}
+	if report.LoggedInSec > 0 {
+		busy := seconds[agentActivityInCall] + seconds[agentActivityWrapUp]
+		v := math.Round(busy/report.LoggedInSec*1000) / 1000
+		report.Utilization = &v
+	}
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   report,
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Longest single wrap-up extension
+const ccWrapUpMaxExtend = time.Hour
+
+// mod_callcenter keeps an agent in wrap-up by not offering calls until
+// ready_time (epoch seconds); the endpoints below move that deadline
+
+// setAgentReadyTime sets the agent's ready_time and answers with the new
+// deadline
+func (h *APIHandler) setAgentReadyTime(w http.ResponseWriter, r *http.Request, agentName string, ready time.Time) {
+	ready = ready.Truncate(time.Second)
+	if _, err := h.sendCCCommand(fmt.Sprintf("agent set ready_time %s %d", agentName, ready.Unix())); err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to set agent ready_time: %v", err), err)
+		return
+	}
+
+	remaining := int(time.Until(ready).Seconds())
+	if remaining < 0 {
+		remaining = 0
+	}
+	logInfo(getRequestID(r), fmt.Sprintf("Agent %s ready_time set to %d", agentName, ready.Unix()))
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data": map[string]interface{}{
+			"agent":         agentName,
+			"ready_time":    ready.UTC(),
+			"remaining_sec": remaining,
+		},
+	})
+}
+
+// CCExtendWrapUp handles POST /v1/callcenter/agents/{agent_name}/wrapup/extend
+func (h *APIHandler) CCExtendWrapUp(w http.ResponseWriter, r *http.Request) {
+	agentName := mux.Vars(r)["agent_name"]
+
+	var req WrapUpExtendRequest
+	if !h.decodeRequest(w, r, &req) {
+		return
+	}
+	if req.Seconds <= 0 || time.Duration(req.Seconds)*time.Second > ccWrapUpMaxExtend {
+		h.respondError(w, r, fmt.Sprintf("seconds must be between 1 and %d", int(ccWrapUpMaxExtend.Seconds())), http.StatusBadRequest)
+		return
+	}
+
+	agent, ok := h.lookupCCAgent(w, r, agentName)
+	if !ok {
+		return
+	}
+
+	// Extend from the current deadline, or from now when wrap-up already ended
+	ready := time.Now()
+	if sec, err := strconv.ParseInt(agent["ready_time"], 10, 64); err == nil && time.Unix(sec, 0).After(ready) {
+		ready = time.Unix(sec, 0)
+	}
+	h.setAgentReadyTime(w, r, agentName, ready.Add(time.Duration(req.Seconds)*time.Second))
+}
+
+// CCEndWrapUp handles POST /v1/callcenter/agents/{agent_name}/wrapup/end
+func (h *APIHandler) CCEndWrapUp(w http.ResponseWriter, r *http.Request) {
+	agentName := mux.Vars(r)["agent_name"]
+
+	agent, ok := h.lookupCCAgent(w, r, agentName)
+	if !ok {
+		return
+	}
+
+	// An agent left Idle after the call would still not be offered calls
+	if agent["state"] == "Idle" {
+		if _, err := h.sendCCCommand(fmt.Sprintf("agent set state %s 'Waiting'", agentName)); err != nil {
+			h.respondESLError(w, r, fmt.Sprintf("Failed to set agent state: %v", err), err)
+			return
+		}
+	}
+	h.setAgentReadyTime(w, r, agentName, time.Now())
+}
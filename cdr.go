@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const cdrFile = "cdrs.jsonl"
+
+// CDR is the normalized record fs-api stores for every channel that hangs up
+type CDR struct {
+	UUID              string            `json:"uuid"`
+	Direction         string            `json:"direction,omitempty"`
+	Context           string            `json:"context,omitempty"`
+	CallerIDName      string            `json:"caller_id_name,omitempty"`
+	CallerIDNumber    string            `json:"caller_id_number,omitempty"`
+	DestinationNumber string            `json:"destination_number,omitempty"`
+	CalleeIDName      string            `json:"callee_id_name,omitempty"`
+	CalleeIDNumber    string            `json:"callee_id_number,omitempty"`
+	OtherLegUUID      string            `json:"other_leg_uuid,omitempty"`
+	HangupCause       string            `json:"hangup_cause"`
+	HangupCauseQ850   int               `json:"hangup_cause_q850,omitempty"`
+	HangupDisposition string            `json:"hangup_disposition,omitempty"` // e.g. send_bye, recv_bye, send_cancel
+	StartTime         time.Time         `json:"start_time"`
+	AnswerTime        *time.Time        `json:"answer_time,omitempty"`
+	EndTime           time.Time         `json:"end_time"`
+	DurationSec       int               `json:"duration_sec"`
+	BillSec           int               `json:"billsec"`
+	Billing           *BillingInfo      `json:"billing,omitempty"`
+	Emergency         bool              `json:"emergency,omitempty"`       // Originated with priority emergency
+	Recordings        []string          `json:"recordings,omitempty"`      // Files recorded during the call
+	Variables         map[string]string `json:"variables,omitempty"`       // FSAPI_CDR_VARS channel variables
+	LastRequestID     string            `json:"last_request_id,omitempty"` // Last API request that changed the call
+	LastToken         string            `json:"last_token,omitempty"`      // Token fingerprint of its client
+}
+
+// cdrStore keeps the most recent CDRs in memory (bounded by limit) and
+// appends every record to cdrs.jsonl in FSAPI_DATA_DIR
+type cdrStore struct {
+	mu      sync.RWMutex
+	records []*CDR
+	limit   int
+}
+
+// newCDRStore loads the last limit records from disk and compacts the file
+// to that size
+func newCDRStore(limit int) *cdrStore {
+	s := &cdrStore{limit: limit}
+	err := loadJSONLines(cdrFile, func(line []byte) {
+		var rec CDR
+		if json.Unmarshal(line, &rec) == nil {
+			s.records = append(s.records, &rec)
+		}
+	})
+	if err != nil {
+		log.Printf("WARNING: Failed to load CDRs: %v", err)
+	}
+	if len(s.records) > limit {
+		s.records = s.records[len(s.records)-limit:]
+		if err := rewriteJSONLines(cdrFile, len(s.records), func(i int) interface{} { return s.records[i] }); err != nil {
+			log.Printf("WARNING: Failed to compact CDRs: %v", err)
+		}
+	}
+	return s
+}
+
+// cdrFromEvent builds a CDR from a CHANNEL_HANGUP_COMPLETE event
+func cdrFromEvent(ev *Event) *CDR {
+	rec := &CDR{
+		UUID:              ev.UUID(),
+		Direction:         ev.Get("Call-Direction"),
+		Context:           ev.Context(),
+		CallerIDName:      ev.Get("Caller-Caller-ID-Name"),
+		CallerIDNumber:    ev.Get("Caller-Caller-ID-Number"),
+		DestinationNumber: ev.Get("Caller-Destination-Number"),
+		CalleeIDName:      ev.Get("Caller-Callee-ID-Name"),
+		CalleeIDNumber:    ev.Get("Caller-Callee-ID-Number"),
+		OtherLegUUID:      ev.Get("Other-Leg-Unique-ID"),
+		HangupCause:       ev.Get("Hangup-Cause"),
+		HangupDisposition: ev.Var("sip_hangup_disposition"),
+		StartTime:         ev.EventTime("Caller-Channel-Created-Time"),
+		EndTime:           ev.EventTime("Caller-Channel-Hangup-Time"),
+		Billing:           billingFromEvent(ev),
+		Emergency:         ev.Var(emergencyVar) == "true",
+		LastRequestID:     ev.Var(lastRequestIDVar),
+		LastToken:         ev.Var(lastTokenVar),
+	}
+	if rec.EndTime.IsZero() {
+		rec.EndTime = ev.Time
+	}
+	if answered := ev.EventTime("Caller-Channel-Answered-Time"); !answered.IsZero() {
+		rec.AnswerTime = &answered
+	}
+	rec.DurationSec, _ = strconv.Atoi(ev.Var("duration"))
+	rec.BillSec, _ = strconv.Atoi(ev.Var("billsec"))
+	rec.HangupCauseQ850, _ = strconv.Atoi(ev.Var("hangup_cause_q850"))
+	return rec
+}
+
+func (s *cdrStore) add(rec *CDR) {
+	s.mu.Lock()
+	s.records = append(s.records, rec)
+	if len(s.records) > s.limit {
+		s.records = s.records[len(s.records)-s.limit:]
+	}
+	s.mu.Unlock()
+
+	if err := appendJSONLine(cdrFile, rec); err != nil {
+		log.Printf("WARNING: Failed to persist CDR %s: %v", rec.UUID, err)
+	}
+}
+
+// query returns CDRs ending at or after since, oldest first, filtered by
+// match when it is non-nil
+func (s *cdrStore) query(since time.Time, match func(*CDR) bool) []*CDR {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows := []*CDR{}
+	for _, rec := range s.records {
+		if rec.EndTime.Before(since) {
+			continue
+		}
+		if match != nil && !match(rec) {
+			continue
+		}
+		rows = append(rows, rec)
+	}
+	return rows
+}
+
+// handleHangupEvent stores a CDR for every completed hangup and sends the
+// call.hangup webhook
+func (h *APIHandler) handleHangupEvent(ev *Event) {
+	// Channels rendering speech for the TTS cache are not calls
+	if ev.Name != "CHANNEL_HANGUP_COMPLETE" || ev.Var("fsapi_tts_render") != "" {
+		return
+	}
+	rec := cdrFromEvent(ev)
+	h.normalizeCDR(rec)
+	rec.Recordings = h.traces.recordings(rec.UUID)
+	for _, name := range h.cdrVars {
+		if value := ev.Var(name); value != "" {
+			if rec.Variables == nil {
+				rec.Variables = map[string]string{}
+			}
+			rec.Variables[name] = value
+		}
+	}
+	h.cdrs.add(rec)
+	h.webhooks.dispatch("call.hangup", rec.Context, rec)
+}
+
+// GET /v1/cdrs
+func (h *APIHandler) ListCDRs(w http.ResponseWriter, r *http.Request) {
+	since := time.Time{}
+	if v := r.URL.Query().Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			h.respondError(w, r, "since must be an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		since = t
+	}
+
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			h.respondError(w, r, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	contextFilter := r.URL.Query().Get("context")
+	rows := h.cdrs.query(since, func(rec *CDR) bool {
+		if contextFilter != "" && rec.Context != contextFilter {
+			return false
+		}
+		return isContextAllowed(r, rec.Context)
+	})
+
+	// Most recent records win when the limit applies
+	if len(rows) > limit {
+		rows = rows[len(rows)-limit:]
+	}
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status":    "success",
+		"row_count": len(rows),
+		"rows":      rows,
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// cidLookup returns the caller name mod_cidlookup finds for number, or ""
+// when it finds none
+func (h *APIHandler) cidLookup(number string) (string, error) {
+	response, err := h.eslClient.SendCommand("api cidlookup " + number)
+	if err != nil {
+		return "", err
+	}
+	name := cleanCallerIDName(response)
+	if strings.EqualFold(name, "UNKNOWN") {
+		return "", nil
+	}
+	return name, nil
+}
+
+// cleanCallerIDName keeps the characters of a looked-up name that are safe in
+// originate variables and uuid_display (letters, digits, spaces, '.', '-',
+// '&'), so a CNAM source cannot inject syntax
+func cleanCallerIDName(name string) string {
+	var b strings.Builder
+	for _, c := range strings.TrimSpace(name) {
+		switch {
+		case c == ' ' || c == '.' || c == '-' || c == '&',
+			c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+			b.WriteRune(c)
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// GET /v1/tools/cidlookup?number=
+func (h *APIHandler) LookupCallerID(w http.ResponseWriter, r *http.Request) {
+	number := r.URL.Query().Get("number")
+	if !e164Pattern.MatchString(number) {
+		h.respondError(w, r, "number must be a phone number (up to 15 digits, optional leading '+')", http.StatusBadRequest)
+		return
+	}
+
+	name, err := h.cidLookup(number)
+	if err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to look up caller ID: %v", err), err)
+		return
+	}
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data": map[string]interface{}{
+			"number": number,
+			"name":   name,
+			"found":  name != "",
+		},
+	})
+}
+
+// displayCallerName looks up the caller name of fromUUID's caller ID number
+// and shows it on toUUID's phone with uuid_display. Failures are only logged;
+// the bridge has already been made. Returns the name shown, if any.
+func (h *APIHandler) displayCallerName(r *http.Request, fromUUID, toUUID string) string {
+	requestID := getRequestID(r)
+	number, err := h.eslClient.SendCommand(fmt.Sprintf("api uuid_getvar %s caller_id_number", fromUUID))
+	number = strings.TrimSpace(number)
+	if err != nil || !e164Pattern.MatchString(number) {
+		logWarn(requestID, fmt.Sprintf("Caller ID lookup skipped: no caller number on %s", fromUUID))
+		return ""
+	}
+	name, err := h.cidLookup(number)
+	if err != nil {
+		logWarn(requestID, fmt.Sprintf("Caller ID lookup for %s failed: %v", number, err))
+		return ""
+	}
+	if name == "" {
+		return ""
+	}
+	if _, err := h.eslClient.SendCommand(fmt.Sprintf("api uuid_display %s %s|%s", toUUID, name, number)); err != nil {
+		logWarn(requestID, fmt.Sprintf("Failed to display caller name on %s: %v", toUUID, err))
+		return ""
+	}
+	return name
+}
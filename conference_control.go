@@ -0,0 +1,329 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	conferenceRecordingsFile = "conference_recordings.json"
+
+	// mod_conference publishes its events as CUSTOM conference::maintain with
+	// the action in Action
+	conferenceEventSubclass = "conference::maintain"
+)
+
+// Conference recording statuses
+const (
+	recordingStatusRecording = "recording"
+	recordingStatusPaused    = "paused"
+	recordingStatusStopped   = "stopped"
+)
+
+// vid-layout names, e.g. 2x2, 1up_top_left+5 or group:grid
+var videoLayoutPattern = regexp.MustCompile(`^[A-Za-z0-9_.:+-]{1,64}$`)
+
+// ConferenceRecording is a recording of a conference room, written by
+// mod_conference under the room's context in FSAPI_RECORDING_DIR
+type ConferenceRecording struct {
+	ID         string     `json:"id"`
+	Room       string     `json:"room"` // name@context
+	Context    string     `json:"context"`
+	Conference string     `json:"conference"` // mod_conference name
+	Path       string     `json:"path"`
+	Status     string     `json:"status"` // recording, paused or stopped
+	StartedAt  time.Time  `json:"started_at"`
+	StoppedAt  *time.Time `json:"stopped_at,omitempty"`
+}
+
+// conferenceRecordings is the persisted list of conference recordings
+type conferenceRecordings struct {
+	mu   sync.Mutex
+	recs []*ConferenceRecording
+}
+
+// newConferenceRecordings loads the recordings from FSAPI_DATA_DIR
+func newConferenceRecordings() *conferenceRecordings {
+	store := &conferenceRecordings{}
+	if err := loadJSONFile(conferenceRecordingsFile, &store.recs); err != nil {
+		log.Printf("WARNING: Failed to load conference recordings: %v", err)
+	}
+	return store
+}
+
+// save persists the recordings. Caller must hold mu.
+func (store *conferenceRecordings) save() error {
+	return saveJSONFile(conferenceRecordingsFile, store.recs)
+}
+
+// forRoom returns copies of roomID's recordings, newest first
+func (store *conferenceRecordings) forRoom(roomID string) []ConferenceRecording {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	recs := []ConferenceRecording{}
+	for _, rec := range store.recs {
+		if rec.Room == roomID {
+			recs = append(recs, *rec)
+		}
+	}
+	sort.Slice(recs, func(i, j int) bool { return recs[i].StartedAt.After(recs[j].StartedAt) })
+	return recs
+}
+
+// active returns roomID's recording that has not stopped. Caller must hold
+// mu.
+func (store *conferenceRecordings) active(roomID string) *ConferenceRecording {
+	for _, rec := range store.recs {
+		if rec.Room == roomID && rec.Status != recordingStatusStopped {
+			return rec
+		}
+	}
+	return nil
+}
+
+// stop marks the recordings matching match as stopped, persisting the change
+func (store *conferenceRecordings) stop(match func(*ConferenceRecording) bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	now := time.Now().UTC()
+	changed := false
+	for _, rec := range store.recs {
+		if rec.Status != recordingStatusStopped && match(rec) {
+			rec.Status = recordingStatusStopped
+			rec.StoppedAt = &now
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	if err := store.save(); err != nil {
+		log.Printf("WARNING: Failed to persist conference recordings: %v", err)
+	}
+}
+
+// conferenceRecordingPath returns where a recording of room started at t is
+// written: FSAPI_RECORDING_DIR/<context>/conference/<name>/<time>.wav
+func conferenceRecordingPath(room *ConferenceRoom, t time.Time) string {
+	return filepath.Join(FSAPI_RECORDING_DIR, room.Context, "conference", room.Name, t.Format("20060102T150405Z")+".wav")
+}
+
+// handleConferenceEvent marks recordings stopped when mod_conference stops
+// them on its own, e.g. when the last member leaves
+func (h *APIHandler) handleConferenceEvent(ev *Event) {
+	if ev.Name != "CUSTOM" || ev.Get("Event-Subclass") != conferenceEventSubclass {
+		return
+	}
+	switch ev.Get("Action") {
+	case "stop-recording":
+		path := ev.Get("Path")
+		h.recordings.stop(func(rec *ConferenceRecording) bool { return rec.Path == path })
+	case "conference-destroy":
+		name := ev.Get("Conference-Name")
+		h.recordings.stop(func(rec *ConferenceRecording) bool { return rec.Conference == name })
+	}
+}
+
+// requireRunningConference answers 409 unless room's conference has members
+func (h *APIHandler) requireRunningConference(w http.ResponseWriter, r *http.Request, room *ConferenceRoom) bool {
+	count, err := h.conferenceMemberCount(room.conferenceName())
+	if err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to read conference members: %v", err), err)
+		return false
+	}
+	if count == 0 {
+		h.respondError(w, r, fmt.Sprintf("Conference room %s has no members", room.id()), http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// --- Conference control handlers ---
+
+// POST /v1/conference-rooms/{room}/recording
+func (h *APIHandler) ControlConferenceRecording(w http.ResponseWriter, r *http.Request) {
+	room, ok := h.lookupConferenceRoom(w, r)
+	if !ok {
+		return
+	}
+
+	var req ConferenceRecordingRequest
+	if !h.decodeRequest(w, r, &req) {
+		return
+	}
+	switch req.Action {
+	case "start", "stop", "pause", "resume":
+	default:
+		h.respondError(w, r, "action must be 'start', 'stop', 'pause' or 'resume'", http.StatusBadRequest)
+		return
+	}
+	if !h.requireRunningConference(w, r, room) {
+		return
+	}
+
+	h.recordings.mu.Lock()
+	rec := h.recordings.active(room.id())
+	if req.Action == "start" {
+		if rec != nil {
+			h.recordings.mu.Unlock()
+			h.respondError(w, r, fmt.Sprintf("Conference room %s is already being recorded to %s", room.id(), rec.Path), http.StatusConflict)
+			return
+		}
+		// Added up front so a concurrent start sees it
+		now := time.Now().UTC()
+		rec = &ConferenceRecording{
+			ID:         uuid.New().String(),
+			Room:       room.id(),
+			Context:    room.Context,
+			Conference: room.conferenceName(),
+			Path:       conferenceRecordingPath(room, now),
+			Status:     recordingStatusRecording,
+			StartedAt:  now,
+		}
+		h.recordings.recs = append(h.recordings.recs, rec)
+	}
+	h.recordings.mu.Unlock()
+	if rec == nil {
+		h.respondError(w, r, fmt.Sprintf("Conference room %s is not being recorded", room.id()), http.StatusConflict)
+		return
+	}
+
+	cmd := fmt.Sprintf("api conference %s recording %s %s", room.conferenceName(), req.Action, rec.Path)
+	if _, err := h.eslClient.SendCommand(cmd); err != nil {
+		if req.Action == "start" {
+			h.recordings.mu.Lock()
+			for i, other := range h.recordings.recs {
+				if other == rec {
+					h.recordings.recs = append(h.recordings.recs[:i], h.recordings.recs[i+1:]...)
+					break
+				}
+			}
+			h.recordings.mu.Unlock()
+		}
+		h.respondESLError(w, r, fmt.Sprintf("Failed to %s recording: %v", req.Action, err), err)
+		return
+	}
+
+	h.recordings.mu.Lock()
+	switch req.Action {
+	case "stop":
+		now := time.Now().UTC()
+		rec.Status = recordingStatusStopped
+		rec.StoppedAt = &now
+	case "pause":
+		rec.Status = recordingStatusPaused
+	case "resume":
+		rec.Status = recordingStatusRecording
+	}
+	err := h.recordings.save()
+	data := *rec
+	h.recordings.mu.Unlock()
+	if err != nil {
+		logWarn(getRequestID(r), fmt.Sprintf("Failed to persist conference recordings: %v", err))
+	}
+
+	logInfo(getRequestID(r), fmt.Sprintf("Conference room %s recording %s: %s", room.id(), data.Status, data.Path))
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   data,
+	})
+}
+
+// GET /v1/conference-rooms/{room}/recordings
+func (h *APIHandler) ListConferenceRecordings(w http.ResponseWriter, r *http.Request) {
+	room, ok := h.lookupConferenceRoom(w, r)
+	if !ok {
+		return
+	}
+	rows := h.recordings.forRoom(room.id())
+	h.respondJSON(w, r, map[string]interface{}{
+		"status":    "success",
+		"row_count": len(rows),
+		"rows":      rows,
+	})
+}
+
+// POST /v1/conference-rooms/{room}/layout
+func (h *APIHandler) SetConferenceLayout(w http.ResponseWriter, r *http.Request) {
+	room, ok := h.lookupConferenceRoom(w, r)
+	if !ok {
+		return
+	}
+
+	var req ConferenceLayoutRequest
+	if !h.decodeRequest(w, r, &req) {
+		return
+	}
+	if !videoLayoutPattern.MatchString(req.Layout) {
+		h.respondError(w, r, "layout must be a video layout name, e.g. 2x2 or group:grid", http.StatusBadRequest)
+		return
+	}
+	if req.Canvas < 0 {
+		h.respondError(w, r, "canvas cannot be negative", http.StatusBadRequest)
+		return
+	}
+	if !h.requireRunningConference(w, r, room) {
+		return
+	}
+
+	cmd := fmt.Sprintf("api conference %s vid-layout %s", room.conferenceName(), req.Layout)
+	if req.Canvas > 0 {
+		cmd += fmt.Sprintf(" %d", req.Canvas)
+	}
+	if _, err := h.eslClient.SendCommand(cmd); err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to change layout: %v", err), err)
+		return
+	}
+	h.respondSuccess(w, r, fmt.Sprintf("Conference room %s layout set to %s", room.id(), req.Layout))
+}
+
+// POST /v1/conference-rooms/{room}/floor
+func (h *APIHandler) SetConferenceFloor(w http.ResponseWriter, r *http.Request) {
+	room, ok := h.lookupConferenceRoom(w, r)
+	if !ok {
+		return
+	}
+
+	var req ConferenceFloorRequest
+	if !h.decodeRequest(w, r, &req) {
+		return
+	}
+	if req.Clear == (req.MemberID != 0) {
+		h.respondError(w, r, "Set either member_id or clear", http.StatusBadRequest)
+		return
+	}
+	if req.MemberID < 0 {
+		h.respondError(w, r, "member_id must be positive", http.StatusBadRequest)
+		return
+	}
+	if !h.requireRunningConference(w, r, room) {
+		return
+	}
+
+	var cmd, message string
+	if req.Clear {
+		// The floor goes back to whoever is speaking
+		cmd = fmt.Sprintf("api conference %s clear-vid-floor", room.conferenceName())
+		message = fmt.Sprintf("Conference room %s video floor released", room.id())
+	} else {
+		// force holds the floor on the member until it is cleared
+		cmd = fmt.Sprintf("api conference %s vid-floor %d", room.conferenceName(), req.MemberID)
+		if req.Force {
+			cmd += " force"
+		}
+		message = fmt.Sprintf("Conference room %s video floor given to member %d", room.id(), req.MemberID)
+	}
+	if _, err := h.eslClient.SendCommand(cmd); err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to set the video floor: %v", err), err)
+		return
+	}
+	h.respondSuccess(w, r, message)
+}
@@ -0,0 +1,572 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	conferenceRoomsFile = "conference_rooms.json"
+
+	// How often room schedules are checked
+	conferenceScheduleInterval = 10 * time.Second
+)
+
+// Conference room statuses, derived from the schedule
+const (
+	roomStatusScheduled = "scheduled" // Before start_at; calls are refused
+	roomStatusOpen      = "open"      // Members may join
+	roomStatusClosing   = "closing"   // Past end_at, within the grace period; locked
+	roomStatusEnded     = "ended"     // Members were hung up; calls are refused
+)
+
+var (
+	// No dots, so the FreeSWITCH conference name <name>.<context> is unique
+	roomNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+	roomPINPattern  = regexp.MustCompile(`^[0-9]{3,12}$`)
+)
+
+func checkConferenceProfile(v string) error {
+	if !isValidName(v) {
+		return fmt.Errorf("%q is not a profile name", v)
+	}
+	return nil
+}
+
+func checkConferenceJoinURL(v string) error {
+	if v == "" {
+		return nil
+	}
+	if u, err := url.Parse(v); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an http(s) URL", v)
+	}
+	return nil
+}
+
+// ConferenceRoom is a mod_conference room owned by a tenant context, open
+// between StartAt and EndAt
+type ConferenceRoom struct {
+	Name        string     `json:"name"`
+	Context     string     `json:"context"`             // Tenant context (domain) owning the room
+	Extension   string     `json:"extension,omitempty"` // Dial-in extension in the context, routed over xml_curl
+	PIN         string     `json:"pin,omitempty"`       // Asked of callers dialing in
+	MaxMembers  int        `json:"max_members,omitempty"`
+	StartAt     *time.Time `json:"start_at,omitempty"` // Empty = open from creation
+	EndAt       *time.Time `json:"end_at,omitempty"`   // Empty = never ends
+	GraceSec    int        `json:"grace_sec,omitempty"`
+	Description string     `json:"description,omitempty"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+
+	Join *ConferenceJoinInfo `json:"join,omitempty"` // Set in responses only
+}
+
+// ConferenceJoinInfo tells participants how to reach a room
+type ConferenceJoinInfo struct {
+	Conference string   `json:"conference"`          // mod_conference name, name@profile
+	Extension  string   `json:"extension,omitempty"` // Dial-in extension in context
+	Context    string   `json:"context"`
+	Numbers    []string `json:"numbers,omitempty"` // DIDs routed to the extension
+	URL        string   `json:"url,omitempty"`     // FSAPI_CONFERENCE_JOIN_URL for the room
+}
+
+// id is the room's key and URL name, name@context
+func (room *ConferenceRoom) id() string {
+	return room.Name + "@" + room.Context
+}
+
+// conferenceName is the room's name in mod_conference
+func (room *ConferenceRoom) conferenceName() string {
+	return room.Name + "." + room.Context
+}
+
+// statusAt returns the status room's schedule gives it at now
+func (room *ConferenceRoom) statusAt(now time.Time) string {
+	switch {
+	case room.StartAt != nil && now.Before(*room.StartAt):
+		return roomStatusScheduled
+	case room.EndAt == nil || now.Before(*room.EndAt):
+		return roomStatusOpen
+	case now.Before(room.EndAt.Add(time.Duration(room.GraceSec) * time.Second)):
+		return roomStatusClosing
+	}
+	return roomStatusEnded
+}
+
+// validate checks the room's settings
+func (room *ConferenceRoom) validate() error {
+	if !roomNamePattern.MatchString(room.Name) {
+		return fmt.Errorf("name must be 1-64 letters, digits, '_' or '-'")
+	}
+	if !domainPattern.MatchString(room.Context) {
+		return fmt.Errorf("context is required")
+	}
+	if room.Extension != "" && !userIDPattern.MatchString(room.Extension) {
+		return fmt.Errorf("extension must be letters, digits and . _ + -")
+	}
+	if room.PIN != "" && !roomPINPattern.MatchString(room.PIN) {
+		return fmt.Errorf("pin must be 3-12 digits")
+	}
+	if room.MaxMembers < 0 {
+		return fmt.Errorf("max_members cannot be negative")
+	}
+	if room.GraceSec < 0 {
+		return fmt.Errorf("grace_sec cannot be negative")
+	}
+	if room.StartAt != nil && room.EndAt != nil && !room.EndAt.After(*room.StartAt) {
+		return fmt.Errorf("end_at must be after start_at")
+	}
+	if strings.ContainsAny(room.Description, "\n\r") {
+		return fmt.Errorf("description must be a single line")
+	}
+	return nil
+}
+
+// conferenceRooms is the persisted room inventory, keyed by name@context
+type conferenceRooms struct {
+	mu    sync.Mutex
+	rooms map[string]*ConferenceRoom
+}
+
+// newConferenceRooms loads the rooms from FSAPI_DATA_DIR
+func newConferenceRooms() *conferenceRooms {
+	reg := &conferenceRooms{rooms: make(map[string]*ConferenceRoom)}
+	var rooms []*ConferenceRoom
+	if err := loadJSONFile(conferenceRoomsFile, &rooms); err != nil {
+		log.Printf("WARNING: Failed to load conference rooms: %v", err)
+	}
+	for _, room := range rooms {
+		reg.rooms[room.id()] = room
+	}
+	return reg
+}
+
+// list returns the rooms sorted by name@context
+func (reg *conferenceRooms) list() []*ConferenceRoom {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	rooms := make([]*ConferenceRoom, 0, len(reg.rooms))
+	for _, room := range reg.rooms {
+		rooms = append(rooms, room)
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].id() < rooms[j].id() })
+	return rooms
+}
+
+// byExtension finds the room dialed as extension in callContext
+func (reg *conferenceRooms) byExtension(callContext, extension string) *ConferenceRoom {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	for _, room := range reg.rooms {
+		if room.Extension != "" && room.Context == callContext && room.Extension == extension {
+			return room
+		}
+	}
+	return nil
+}
+
+// save persists the inventory. Caller must hold mu.
+func (reg *conferenceRooms) save() error {
+	rooms := make([]*ConferenceRoom, 0, len(reg.rooms))
+	for _, room := range reg.rooms {
+		rooms = append(rooms, room)
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].id() < rooms[j].id() })
+	return saveJSONFile(conferenceRoomsFile, rooms)
+}
+
+// --- Schedule ---
+
+// runConferenceSchedule moves rooms through their schedule until shutdown
+// starts
+func (h *APIHandler) runConferenceSchedule() {
+	ticker := time.NewTicker(conferenceScheduleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			h.checkConferenceSchedule(time.Now().UTC())
+		case <-h.jobs.stopping():
+			return
+		}
+	}
+}
+
+// checkConferenceSchedule applies the status change of every room whose
+// schedule has moved on: a room that closes is locked so nobody else joins,
+// and a room that ends has its members hung up
+func (h *APIHandler) checkConferenceSchedule(now time.Time) {
+	type change struct {
+		room     ConferenceRoom
+		previous string
+	}
+	var changes []change
+	h.rooms.mu.Lock()
+	for id, room := range h.rooms.rooms {
+		status := room.statusAt(now)
+		if status == room.Status {
+			continue
+		}
+		// Replaced rather than changed in place; handlers hold the old copy
+		updated := *room
+		updated.Status = status
+		h.rooms.rooms[id] = &updated
+		changes = append(changes, change{room: updated, previous: room.Status})
+	}
+	var err error
+	if len(changes) > 0 {
+		err = h.rooms.save()
+	}
+	h.rooms.mu.Unlock()
+	if err != nil {
+		log.Printf("WARNING: Failed to persist conference rooms: %v", err)
+	}
+
+	for _, c := range changes {
+		name := c.room.conferenceName()
+		switch c.room.Status {
+		case roomStatusClosing:
+			h.eslClient.SendCommand(fmt.Sprintf("api conference %s lock", name))
+		case roomStatusEnded:
+			h.eslClient.SendCommand(fmt.Sprintf("api conference %s lock", name))
+			h.eslClient.SendCommand(fmt.Sprintf("api conference %s hupall", name))
+			h.recordings.stop(func(rec *ConferenceRecording) bool { return rec.Conference == name })
+		case roomStatusOpen:
+			// A room reopened by a new schedule may still hold a locked conference
+			h.eslClient.SendCommand(fmt.Sprintf("api conference %s unlock", name))
+		}
+		log.Printf("Conference room %s: %s -> %s", c.room.id(), c.previous, c.room.Status)
+		h.webhooks.dispatch("conference.room."+c.room.Status, c.room.Context, map[string]interface{}{
+			"room":       c.room.id(),
+			"conference": name,
+			"status":     c.room.Status,
+			"previous":   c.previous,
+		})
+	}
+}
+
+// conferenceMemberCount returns the number of members in a running
+// conference, 0 when it is not running
+func (h *APIHandler) conferenceMemberCount(name string) (int, error) {
+	response, err := h.eslClient.SendCommand(fmt.Sprintf("api conference %s list count", name))
+	if err != nil {
+		return 0, err
+	}
+	// "Conference <name> not found" until the first member joins
+	count, err := strconv.Atoi(strings.TrimSpace(response))
+	if err != nil {
+		return 0, nil
+	}
+	return count, nil
+}
+
+// roomJoinCheck reports why room cannot take another member now, or ""
+func (h *APIHandler) roomJoinCheck(room *ConferenceRoom) (string, error) {
+	if status := room.statusAt(time.Now()); status != roomStatusOpen {
+		return fmt.Sprintf("Conference room %s is %s", room.id(), status), nil
+	}
+	if room.MaxMembers > 0 {
+		count, err := h.conferenceMemberCount(room.conferenceName())
+		if err != nil {
+			return "", err
+		}
+		if count >= room.MaxMembers {
+			return fmt.Sprintf("Conference room %s is full (%d members)", room.id(), count), nil
+		}
+	}
+	return "", nil
+}
+
+// conferenceRoomExtension renders the dial-in extension of a room for the
+// xml_curl dialplan. Callers are refused outside the schedule or when the
+// room is full; member limits are checked as the call is routed.
+func (h *APIHandler) conferenceRoomExtension(room *ConferenceRoom) didXMLExtension {
+	var ext didXMLExtension
+	ext.Name = "fsapi_conference_" + room.Name
+	ext.Condition.Field = "destination_number"
+	ext.Condition.Expression = "^" + regexp.QuoteMeta(room.Extension) + "$"
+
+	reason, err := h.roomJoinCheck(room)
+	switch {
+	case err != nil:
+		ext.Condition.Actions = []didXMLAction{{"hangup", "NORMAL_TEMPORARY_FAILURE"}}
+	case reason != "":
+		ext.Condition.Actions = []didXMLAction{{"hangup", "CALL_REJECTED"}}
+	default:
+		target := room.conferenceName() + "@" + FSAPI_CONFERENCE_PROFILE
+		if room.PIN != "" {
+			target += "+" + room.PIN
+		}
+		ext.Condition.Actions = []didXMLAction{
+			{"set", "domain_name=" + room.Context},
+			{"set", "accountcode=" + room.Context},
+			{"answer", ""},
+			{"conference", target},
+		}
+	}
+	return ext
+}
+
+// joinInfo returns a copy of room with its join details, for responses
+func (h *APIHandler) joinInfo(room *ConferenceRoom) *ConferenceRoom {
+	out := *room
+	out.Status = room.statusAt(time.Now())
+	join := &ConferenceJoinInfo{
+		Conference: room.conferenceName() + "@" + FSAPI_CONFERENCE_PROFILE,
+		Extension:  room.Extension,
+		Context:    room.Context,
+	}
+	if room.Extension != "" {
+		for _, did := range h.dids.list() {
+			if did.Type == didDestExtension && did.Context == room.Context && did.Target == room.Extension {
+				join.Numbers = append(join.Numbers, did.Number)
+			}
+		}
+	}
+	if FSAPI_CONFERENCE_JOIN_URL != "" {
+		join.URL = strings.NewReplacer("{room}", room.Name, "{context}", room.Context).Replace(FSAPI_CONFERENCE_JOIN_URL)
+	}
+	out.Join = join
+	return &out
+}
+
+// --- Conference room handlers ---
+
+// GET /v1/conference-rooms
+func (h *APIHandler) ListConferenceRooms(w http.ResponseWriter, r *http.Request) {
+	contextFilter := r.URL.Query().Get("context")
+	rows := []*ConferenceRoom{}
+	for _, room := range h.rooms.list() {
+		if contextFilter != "" && room.Context != contextFilter {
+			continue
+		}
+		if isContextAllowed(r, room.Context) {
+			rows = append(rows, h.joinInfo(room))
+		}
+	}
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status":    "success",
+		"row_count": len(rows),
+		"rows":      rows,
+	})
+}
+
+// POST /v1/conference-rooms
+func (h *APIHandler) CreateConferenceRoom(w http.ResponseWriter, r *http.Request) {
+	var room ConferenceRoom
+	if !h.decodeRequest(w, r, &room) {
+		return
+	}
+	if err := room.validate(); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !h.validateRequestContext(w, r, room.Context) {
+		return
+	}
+	room.Join = nil
+	room.CreatedAt = time.Now().UTC()
+	room.UpdatedAt = room.CreatedAt
+	room.Status = room.statusAt(room.CreatedAt)
+
+	h.rooms.mu.Lock()
+	if _, exists := h.rooms.rooms[room.id()]; exists {
+		h.rooms.mu.Unlock()
+		h.respondError(w, r, fmt.Sprintf("Conference room %s already exists", room.id()), http.StatusConflict)
+		return
+	}
+	if room.Extension != "" {
+		for _, other := range h.rooms.rooms {
+			if other.Context == room.Context && other.Extension == room.Extension {
+				h.rooms.mu.Unlock()
+				h.respondError(w, r, fmt.Sprintf("Extension %s is used by conference room %s", room.Extension, other.id()), http.StatusConflict)
+				return
+			}
+		}
+	}
+	h.rooms.rooms[room.id()] = &room
+	err := h.rooms.save()
+	h.rooms.mu.Unlock()
+	if err != nil {
+		logWarn(getRequestID(r), fmt.Sprintf("Failed to persist conference rooms: %v", err))
+	}
+
+	logInfo(getRequestID(r), fmt.Sprintf("Conference room %s created (%s)", room.id(), room.Status))
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   h.joinInfo(&room),
+	})
+}
+
+// lookupConferenceRoom returns the room named in the URL if the caller may
+// see it
+func (h *APIHandler) lookupConferenceRoom(w http.ResponseWriter, r *http.Request) (*ConferenceRoom, bool) {
+	id := mux.Vars(r)["room"]
+	h.rooms.mu.Lock()
+	room, ok := h.rooms.rooms[id]
+	h.rooms.mu.Unlock()
+	if !ok || !isContextAllowed(r, room.Context) {
+		h.respondError(w, r, fmt.Sprintf("Conference room %s not found", id), http.StatusNotFound)
+		return nil, false
+	}
+	return room, true
+}
+
+// GET /v1/conference-rooms/{room}
+func (h *APIHandler) GetConferenceRoom(w http.ResponseWriter, r *http.Request) {
+	room, ok := h.lookupConferenceRoom(w, r)
+	if !ok {
+		return
+	}
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   h.joinInfo(room),
+	})
+}
+
+// PUT /v1/conference-rooms/{room}
+func (h *APIHandler) UpdateConferenceRoom(w http.ResponseWriter, r *http.Request) {
+	existing, ok := h.lookupConferenceRoom(w, r)
+	if !ok {
+		return
+	}
+
+	var room ConferenceRoom
+	if !h.decodeRequest(w, r, &room) {
+		return
+	}
+	if (room.Name != "" && room.Name != existing.Name) || (room.Context != "" && room.Context != existing.Context) {
+		h.respondError(w, r, "name and context cannot be changed", http.StatusBadRequest)
+		return
+	}
+	room.Name = existing.Name
+	room.Context = existing.Context
+	if err := room.validate(); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	room.Join = nil
+	room.CreatedAt = existing.CreatedAt
+	room.UpdatedAt = time.Now().UTC()
+	// The scheduler applies a status change caused by the new schedule
+	room.Status = existing.Status
+
+	h.rooms.mu.Lock()
+	if room.Extension != "" {
+		for _, other := range h.rooms.rooms {
+			if other.id() != room.id() && other.Context == room.Context && other.Extension == room.Extension {
+				h.rooms.mu.Unlock()
+				h.respondError(w, r, fmt.Sprintf("Extension %s is used by conference room %s", room.Extension, other.id()), http.StatusConflict)
+				return
+			}
+		}
+	}
+	h.rooms.rooms[room.id()] = &room
+	err := h.rooms.save()
+	h.rooms.mu.Unlock()
+	if err != nil {
+		logWarn(getRequestID(r), fmt.Sprintf("Failed to persist conference rooms: %v", err))
+	}
+	h.checkConferenceSchedule(room.UpdatedAt)
+
+	logInfo(getRequestID(r), fmt.Sprintf("Conference room %s updated", room.id()))
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   h.joinInfo(&room),
+	})
+}
+
+// DELETE /v1/conference-rooms/{room}
+func (h *APIHandler) DeleteConferenceRoom(w http.ResponseWriter, r *http.Request) {
+	room, ok := h.lookupConferenceRoom(w, r)
+	if !ok {
+		return
+	}
+
+	h.rooms.mu.Lock()
+	delete(h.rooms.rooms, room.id())
+	err := h.rooms.save()
+	h.rooms.mu.Unlock()
+	if err != nil {
+		logWarn(getRequestID(r), fmt.Sprintf("Failed to persist conference rooms: %v", err))
+	}
+
+	// Members in the room stay connected; hang them up with the room
+	if r.URL.Query().Get("hangup") == "true" {
+		if _, err := h.eslClient.SendCommand(fmt.Sprintf("api conference %s hupall", room.conferenceName())); err != nil {
+			logWarn(getRequestID(r), fmt.Sprintf("Failed to hang up conference %s: %v", room.conferenceName(), err))
+		}
+	}
+
+	h.respondSuccess(w, r, fmt.Sprintf("Conference room %s deleted", room.id()))
+}
+
+// POST /v1/conference-rooms/{room}/join
+func (h *APIHandler) JoinConferenceRoom(w http.ResponseWriter, r *http.Request) {
+	room, ok := h.lookupConferenceRoom(w, r)
+	if !ok {
+		return
+	}
+
+	var req ConferenceJoinRequest
+	if !h.decodeRequest(w, r, &req) {
+		return
+	}
+	user, domain, ok := strings.Cut(req.User, "@")
+	if !ok || !userIDPattern.MatchString(user) || !domainPattern.MatchString(domain) {
+		h.respondError(w, r, "user must be a directory user as user@domain", http.StatusBadRequest)
+		return
+	}
+	if !h.validateCCDomainRaw(w, r, domain, "User") {
+		return
+	}
+
+	reason, err := h.roomJoinCheck(room)
+	if err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to read conference members: %v", err), err)
+		return
+	}
+	if reason != "" {
+		h.respondError(w, r, reason, http.StatusConflict)
+		return
+	}
+
+	// Members called by the API are not asked for the PIN
+	cmd := fmt.Sprintf("api originate {origination_caller_id_name='%s',accountcode=%s}user/%s@%s &conference(%s@%s)",
+		room.Name, room.Context, user, domain, room.conferenceName(), FSAPI_CONFERENCE_PROFILE)
+	response, err := h.eslClient.SendCommand(cmd)
+	if cause := parseESLErrCause(response); cause != "" {
+		h.respondOriginateFailure(w, r, cause)
+		return
+	}
+	if err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to call %s into conference room %s: %v", req.User, room.id(), err), err)
+		return
+	}
+
+	callUUID := ""
+	if fields := strings.Fields(response); len(fields) > 1 {
+		callUUID = fields[1]
+	}
+	logInfo(getRequestID(r), fmt.Sprintf("Calling %s into conference room %s", req.User, room.id()))
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data": map[string]interface{}{
+			"uuid":       callUUID,
+			"room":       room.id(),
+			"conference": room.conferenceName(),
+		},
+	})
+}
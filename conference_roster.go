@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ConferenceMember is a member of a running conference, with its talk and
+// mute state
+type ConferenceMember struct {
+	ID             int        `json:"id"` // mod_conference member id
+	UUID           string     `json:"uuid"`
+	CallerIDName   string     `json:"caller_id_name,omitempty"`
+	CallerIDNumber string     `json:"caller_id_number,omitempty"`
+	Talking        bool       `json:"talking"`
+	Muted          bool       `json:"muted"`
+	Deaf           bool       `json:"deaf"`
+	Moderator      bool       `json:"moderator"`
+	JoinedAt       time.Time  `json:"joined_at"`
+	LastTalkedAt   *time.Time `json:"last_talked_at,omitempty"`
+}
+
+// rosterConference is the cached member list of one conference
+type rosterConference struct {
+	context string // Context of the first member, for conferences outside the rooms
+	members map[int]*ConferenceMember
+}
+
+// conferenceRoster caches conference members from conference::maintain
+// events, so member lists do not need a round trip to FreeSWITCH. A
+// conference missing from the cache (fs-api started after it) is loaded
+// with json_list on first use.
+type conferenceRoster struct {
+	mu          sync.Mutex
+	conferences map[string]*rosterConference
+}
+
+func newConferenceRoster() *conferenceRoster {
+	return &conferenceRoster{conferences: make(map[string]*rosterConference)}
+}
+
+// memberFromEvent reads a member's state from the headers mod_conference
+// adds to its member events
+func memberFromEvent(ev *Event, m *ConferenceMember) {
+	m.UUID = ev.UUID()
+	m.CallerIDName = ev.Get("Caller-Caller-ID-Name")
+	m.CallerIDNumber = ev.Get("Caller-Caller-ID-Number")
+	m.Talking = ev.Get("Talking") == "true"
+	m.Muted = ev.Get("Speak") == "false"
+	m.Deaf = ev.Get("Hear") == "false"
+	m.Moderator = ev.Get("Member-Type") == "moderator"
+}
+
+// handleEvent updates the roster from a conference::maintain event
+func (ros *conferenceRoster) handleEvent(ev *Event) {
+	if ev.Name != "CUSTOM" || ev.Get("Event-Subclass") != conferenceEventSubclass {
+		return
+	}
+	name := ev.Get("Conference-Name")
+	action := ev.Get("Action")
+	ros.mu.Lock()
+	defer ros.mu.Unlock()
+	if action == "conference-destroy" {
+		delete(ros.conferences, name)
+		return
+	}
+	id, err := strconv.Atoi(ev.Get("Member-ID"))
+	if err != nil {
+		return
+	}
+	conf := ros.conferences[name]
+	if conf == nil {
+		if action != "add-member" {
+			// Not cached yet; loaded in full when first asked for
+			return
+		}
+		conf = &rosterConference{context: ev.Context(), members: make(map[int]*ConferenceMember)}
+		ros.conferences[name] = conf
+	}
+	if action == "del-member" {
+		delete(conf.members, id)
+		return
+	}
+
+	m := conf.members[id]
+	if m == nil {
+		m = &ConferenceMember{ID: id, JoinedAt: ev.Time}
+		conf.members[id] = m
+	}
+	memberFromEvent(ev, m)
+	switch action {
+	case "start-talking":
+		m.Talking = true
+	case "stop-talking":
+		m.Talking = false
+		t := ev.Time
+		m.LastTalkedAt = &t
+	}
+}
+
+// list returns copies of a cached conference's members sorted by id, or ok
+// false when the conference is not cached
+func (ros *conferenceRoster) list(name string) (members []ConferenceMember, context string, ok bool) {
+	ros.mu.Lock()
+	defer ros.mu.Unlock()
+	conf := ros.conferences[name]
+	if conf == nil {
+		return nil, "", false
+	}
+	members = make([]ConferenceMember, 0, len(conf.members))
+	for _, m := range conf.members {
+		members = append(members, *m)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+	return members, conf.context, true
+}
+
+// seed caches a conference loaded from json_list, unless events have
+// cached it in the meantime
+func (ros *conferenceRoster) seed(name string, members []ConferenceMember) {
+	ros.mu.Lock()
+	defer ros.mu.Unlock()
+	if _, ok := ros.conferences[name]; ok {
+		return
+	}
+	conf := &rosterConference{members: make(map[int]*ConferenceMember, len(members))}
+	for i := range members {
+		m := members[i]
+		conf.members[m.ID] = &m
+	}
+	ros.conferences[name] = conf
+}
+
+// conferenceJSONList is the part of "conference <name> json_list" fs-api reads
+type conferenceJSONList []struct {
+	ConferenceName string `json:"conference_name"`
+	Members        []struct {
+		Type           string `json:"type"`
+		ID             int    `json:"id"`
+		UUID           string `json:"uuid"`
+		CallerIDName   string `json:"caller_id_name"`
+		CallerIDNumber string `json:"caller_id_number"`
+		JoinTime       int    `json:"join_time"` // Seconds since the member joined
+		Flags          struct {
+			CanHear     bool `json:"can_hear"`
+			CanSpeak    bool `json:"can_speak"`
+			Talking     bool `json:"talking"`
+			IsModerator bool `json:"is_moderator"`
+		} `json:"flags"`
+	} `json:"members"`
+}
+
+// loadConferenceMembers reads a conference's members from FreeSWITCH. ok is
+// false when the conference is not running.
+func (h *APIHandler) loadConferenceMembers(name string) (members []ConferenceMember, ok bool, err error) {
+	response, err := h.eslClient.SendCommand(fmt.Sprintf("api conference %s json_list", name))
+	if err != nil {
+		return nil, false, err
+	}
+	if !strings.HasPrefix(strings.TrimSpace(response), "[") {
+		// "Conference <name> not found"
+		return nil, false, nil
+	}
+	var list conferenceJSONList
+	if err := json.Unmarshal([]byte(response), &list); err != nil {
+		return nil, false, fmt.Errorf("failed to parse json_list: %v", err)
+	}
+	now := time.Now().UTC()
+	members = []ConferenceMember{}
+	for _, conf := range list {
+		if conf.ConferenceName != name {
+			continue
+		}
+		for _, m := range conf.Members {
+			if m.Type != "" && m.Type != "caller" {
+				// Recording and playback pseudo-members
+				continue
+			}
+			members = append(members, ConferenceMember{
+				ID:             m.ID,
+				UUID:           m.UUID,
+				CallerIDName:   m.CallerIDName,
+				CallerIDNumber: m.CallerIDNumber,
+				Talking:        m.Flags.Talking,
+				Muted:          !m.Flags.CanSpeak,
+				Deaf:           !m.Flags.CanHear,
+				Moderator:      m.Flags.IsModerator,
+				JoinedAt:       now.Add(-time.Duration(m.JoinTime) * time.Second),
+			})
+		}
+		return members, true, nil
+	}
+	return nil, false, nil
+}
+
+// GET /v1/conferences/{name}/members
+func (h *APIHandler) ListConferenceMembers(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !conferenceNamePattern.MatchString(name) || strings.Contains(name, "@") {
+		h.respondError(w, r, "invalid conference name", http.StatusBadRequest)
+		return
+	}
+	notFound := func() {
+		h.respondError(w, r, fmt.Sprintf("Conference %s not found", name), http.StatusNotFound)
+	}
+
+	members, memberContext, cached := h.roster.list(name)
+	source := "cache"
+	// Without the event stream the cache is not kept up to date
+	if !cached || h.eventHistory == nil {
+		var running bool
+		var err error
+		members, running, err = h.loadConferenceMembers(name)
+		if err != nil {
+			h.respondESLError(w, r, fmt.Sprintf("Failed to list conference members: %v", err), err)
+			return
+		}
+		if !running {
+			notFound()
+			return
+		}
+		if h.eventHistory != nil {
+			h.roster.seed(name, members)
+		}
+		source = "switch"
+	}
+
+	// A room's conference belongs to the room's context; any other conference
+	// to the context of the member that started it
+	conferenceContext := memberContext
+	for _, room := range h.rooms.list() {
+		if room.conferenceName() == name {
+			conferenceContext = room.Context
+			break
+		}
+	}
+	if !isUnrestrictedAccess(r) && (conferenceContext == "" || !isContextAllowed(r, conferenceContext)) {
+		notFound()
+		return
+	}
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status":    "success",
+		"row_count": len(members),
+		"rows":      members,
+		"source":    source,
+	})
+}
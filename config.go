@@ -0,0 +1,382 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config check results
+const (
+	checkOK    = "ok"
+	checkWarn  = "warn"
+	checkError = "error"
+	checkSkip  = "skip"
+)
+
+// ConfigCheck is one line of the -validate-config report
+type ConfigCheck struct {
+	Category string `json:"category"` // settings, auth, directories, esl
+	Name     string `json:"name"`     // Environment variable or check name
+	Status   string `json:"status"`   // ok, warn, error or skip
+	Message  string `json:"message,omitempty"`
+}
+
+// ConfigReport is the result of -validate-config
+type ConfigReport struct {
+	Version string        `json:"version"`
+	Valid   bool          `json:"valid"` // No check failed; warnings are allowed
+	Errors  int           `json:"errors"`
+	Warns   int           `json:"warnings"`
+	Checks  []ConfigCheck `json:"checks"`
+}
+
+func (rep *ConfigReport) add(category, name, status, message string) {
+	rep.Checks = append(rep.Checks, ConfigCheck{Category: category, Name: name, Status: status, Message: message})
+	switch status {
+	case checkError:
+		rep.Errors++
+	case checkWarn:
+		rep.Warns++
+	}
+}
+
+// check adds ok, or error with err's message
+func (rep *ConfigReport) check(category, name string, err error) {
+	if err != nil {
+		rep.add(category, name, checkError, err.Error())
+		return
+	}
+	rep.add(category, name, checkOK, "")
+}
+
+// Integer settings and their accepted range (max 0 means no upper bound),
+// matching the startup checks in main
+var intSettings = []struct {
+	name     string
+	value    *string
+	min, max int
+}{
+	{"FSAPI_PORT", &FSAPI_PORT, 1, 65535},
+	{"FSAPI_ESL_WAIT_TIMEOUT", &FSAPI_ESL_WAIT_TIMEOUT, 1, 0},
+	{"FSAPI_DRAIN_TIMEOUT", &FSAPI_DRAIN_TIMEOUT, 0, 0},
+	{"FSAPI_CDR_RETENTION", &FSAPI_CDR_RETENTION, 1, 0},
+	{"FSAPI_TRASH_RETENTION", &FSAPI_TRASH_RETENTION, 0, 0},
+	{"FSAPI_EVENT_BUFFER", &FSAPI_EVENT_BUFFER, 1, 0},
+	{"FSAPI_ROUTE_STATS_WINDOW", &FSAPI_ROUTE_STATS_WINDOW, 1, 0},
+	{"FSAPI_SWITCH_PAUSE", &FSAPI_SWITCH_PAUSE, 0, 0},
+	{"FSAPI_AUTH_MAX_FAILURES", &FSAPI_AUTH_MAX_FAILURES, 0, 0},
+	{"FSAPI_AUTH_FAILURE_WINDOW", &FSAPI_AUTH_FAILURE_WINDOW, 1, 0},
+	{"FSAPI_AUTH_LOCKOUT", &FSAPI_AUTH_LOCKOUT, 1, 0},
+	{"FSAPI_AUTH_TARPIT", &FSAPI_AUTH_TARPIT, 0, 0},
+	{"FSAPI_SESSION_MAX_TTL", &FSAPI_SESSION_MAX_TTL, 1, 0},
+	{"FSAPI_SIGNATURE_WINDOW", &FSAPI_SIGNATURE_WINDOW, 1, 0},
+	{"FSAPI_REPLAY_WINDOW", &FSAPI_REPLAY_WINDOW, 1, 0},
+	{"FSAPI_POLICY_TIMEOUT", &FSAPI_POLICY_TIMEOUT, 1, 0},
+	{"FSAPI_POLICY_CACHE_TTL", &FSAPI_POLICY_CACHE_TTL, 0, 0},
+	{"FSAPI_WATCHDOG_WARN_BEFORE", &FSAPI_WATCHDOG_WARN_BEFORE, 0, 0},
+	{"FSAPI_WATCHDOG_INTERVAL", &FSAPI_WATCHDOG_INTERVAL, 1, 0},
+}
+
+// Boolean settings, which must be exactly "true" or "false"
+var boolSettings = []struct {
+	name  string
+	value *string
+}{
+	{"FSAPI_WAIT_FOR_ESL", &FSAPI_WAIT_FOR_ESL},
+	{"FSAPI_EVENTS", &FSAPI_EVENTS},
+	{"FSAPI_GRAPHQL", &FSAPI_GRAPHQL},
+	{"FSAPI_POLICY_FAIL_OPEN", &FSAPI_POLICY_FAIL_OPEN},
+	{"FSAPI_REPLAY_PROTECTION", &FSAPI_REPLAY_PROTECTION},
+}
+
+// Shortest bearer token or signing secret not reported as weak
+const minSecretLength = 16
+
+// validateConfig checks the environment configuration without starting the
+// server. With checkESL it also connects to FreeSWITCH.
+func validateConfig(checkESL bool) *ConfigReport {
+	rep := &ConfigReport{Version: Version}
+
+	// Settings
+	if FSAPI_MODE != "live" && FSAPI_MODE != "mock" {
+		rep.add("settings", "FSAPI_MODE", checkError, fmt.Sprintf("%q (expected live or mock)", FSAPI_MODE))
+	} else {
+		rep.add("settings", "FSAPI_MODE", checkOK, FSAPI_MODE)
+	}
+	for _, s := range intSettings {
+		n, err := strconv.Atoi(*s.value)
+		switch {
+		case err != nil || n < s.min:
+			rep.add("settings", s.name, checkError, fmt.Sprintf("%q (expected an integer >= %d)", *s.value, s.min))
+		case s.max > 0 && n > s.max:
+			rep.add("settings", s.name, checkError, fmt.Sprintf("%q (expected at most %d)", *s.value, s.max))
+		default:
+			rep.add("settings", s.name, checkOK, "")
+		}
+	}
+	for _, s := range boolSettings {
+		if *s.value != "true" && *s.value != "false" {
+			rep.add("settings", s.name, checkError, fmt.Sprintf("%q (expected true or false)", *s.value))
+			continue
+		}
+		rep.add("settings", s.name, checkOK, "")
+	}
+	switch {
+	case !containsString(storeDrivers, FSAPI_STORE):
+		rep.add("settings", "FSAPI_STORE", checkError, fmt.Sprintf("%q (expected sqlite, postgres or file)", FSAPI_STORE))
+	case FSAPI_STORE == storePostgres && FSAPI_STORE_DSN == "":
+		rep.add("settings", "FSAPI_STORE", checkError, "postgres requires FSAPI_STORE_DSN")
+	default:
+		rep.add("settings", "FSAPI_STORE", checkOK, FSAPI_STORE)
+	}
+	_, err := parseBodyLimits(FSAPI_BODY_LIMITS)
+	rep.check("settings", "FSAPI_BODY_LIMITS", err)
+	_, err = parseContextDurations(FSAPI_SLA_THRESHOLDS)
+	rep.check("settings", "FSAPI_SLA_THRESHOLDS", err)
+	_, err = parseContextValues(FSAPI_HANGUP_CAUSE, checkHangupCause)
+	rep.check("settings", "FSAPI_HANGUP_CAUSE", err)
+	_, err = parseContextValues(FSAPI_DTMF_DURATION, checkDTMFDuration)
+	rep.check("settings", "FSAPI_DTMF_DURATION", err)
+	_, err = parseContextValues(FSAPI_PARK_APP, checkParkApp)
+	rep.check("settings", "FSAPI_PARK_APP", err)
+	_, err = parseContextValues(FSAPI_PRESENCE_SYNC, checkPresenceSyncMode)
+	rep.check("settings", "FSAPI_PRESENCE_SYNC", err)
+	_, err = parseContextValues(FSAPI_CALL_ALERTS, checkAlertThreshold)
+	rep.check("settings", "FSAPI_CALL_ALERTS", err)
+	_, err = parseContextValues(FSAPI_SPS_ALERTS, checkAlertThreshold)
+	rep.check("settings", "FSAPI_SPS_ALERTS", err)
+	_, err = parseContextValues(FSAPI_GATEWAY_CAPS, checkGatewayCap)
+	rep.check("settings", "FSAPI_GATEWAY_CAPS", err)
+	_, err = parseGatewayGroups(FSAPI_GATEWAY_GROUPS)
+	rep.check("settings", "FSAPI_GATEWAY_GROUPS", err)
+	_, err = parseFailoverCauses(FSAPI_GATEWAY_FAILOVER_CAUSES)
+	rep.check("settings", "FSAPI_GATEWAY_FAILOVER_CAUSES", err)
+	_, err = parseTTSVoices(FSAPI_TTS_VOICES)
+	rep.check("settings", "FSAPI_TTS_VOICES", err)
+	_, err = newNumberNormalizer(FSAPI_NUMBER_COUNTRY)
+	rep.check("settings", "FSAPI_NUMBER_COUNTRY", err)
+	rep.check("settings", "FSAPI_SMTP_ADDR", checkSMTPSettings())
+	rep.check("settings", "FSAPI_VOICEMAIL_PROFILE", checkVoicemailProfile(FSAPI_VOICEMAIL_PROFILE))
+	rep.check("settings", "FSAPI_VOICEMAIL_EXTENSION", checkVoicemailExtension(FSAPI_VOICEMAIL_EXTENSION))
+	rep.check("settings", "FSAPI_CONFERENCE_PROFILE", checkConferenceProfile(FSAPI_CONFERENCE_PROFILE))
+	rep.check("settings", "FSAPI_CONFERENCE_JOIN_URL", checkConferenceJoinURL(FSAPI_CONFERENCE_JOIN_URL))
+	rep.check("settings", "FSAPI_RECORDING_DIR", validateFilePath(FSAPI_RECORDING_DIR))
+	if FSAPI_STIR_ATTESTATION_HEADER != "" && !sipHeaderNamePattern.MatchString(FSAPI_STIR_ATTESTATION_HEADER) {
+		rep.add("settings", "FSAPI_STIR_ATTESTATION_HEADER", checkError, fmt.Sprintf("%q is not a SIP header name", FSAPI_STIR_ATTESTATION_HEADER))
+	}
+	_, err = parseEmergencyNumbers(FSAPI_EMERGENCY_NUMBERS)
+	rep.check("settings", "FSAPI_EMERGENCY_NUMBERS", err)
+	if FSAPI_WATCHDOG_MAX_DURATION != "" {
+		_, err = parseContextDurations(FSAPI_WATCHDOG_MAX_DURATION)
+		rep.check("settings", "FSAPI_WATCHDOG_MAX_DURATION", err)
+		if FSAPI_WATCHDOG_ACTION != watchdogActionFlag && FSAPI_WATCHDOG_ACTION != watchdogActionHangup {
+			rep.add("settings", "FSAPI_WATCHDOG_ACTION", checkError, fmt.Sprintf("%q (expected flag or hangup)", FSAPI_WATCHDOG_ACTION))
+		}
+	}
+	for _, vars := range []struct{ name, value string }{
+		{"FSAPI_CDR_VARS", FSAPI_CDR_VARS},
+		{"FSAPI_SCREENPOP_VARS", FSAPI_SCREENPOP_VARS},
+	} {
+		err = nil
+		for _, v := range splitCSV(vars.value) {
+			if !isValidChannelVarName(v) {
+				err = fmt.Errorf("bad variable name %q", v)
+				break
+			}
+		}
+		rep.check("settings", vars.name, err)
+	}
+	err = nil
+	for _, section := range splitCSV(FSAPI_XML_CURL_SECTIONS) {
+		if !containsString(xmlCurlSectionNames, section) {
+			err = fmt.Errorf("unknown section %q", section)
+			break
+		}
+	}
+	rep.check("settings", "FSAPI_XML_CURL_SECTIONS", err)
+	if FSAPI_TTS_CACHE_DIR != "" {
+		if maxBytes, err := strconv.ParseInt(FSAPI_TTS_CACHE_MAX_BYTES, 10, 64); err != nil || maxBytes <= 0 {
+			rep.add("settings", "FSAPI_TTS_CACHE_MAX_BYTES", checkError, fmt.Sprintf("%q is not a positive number of bytes", FSAPI_TTS_CACHE_MAX_BYTES))
+		}
+		if FSAPI_EVENTS != "true" {
+			rep.add("settings", "FSAPI_TTS_CACHE_DIR", checkError, "requires FSAPI_EVENTS=true")
+		}
+	}
+	if FSAPI_GRAPHQL == "true" {
+		_, err = newGraphSchema()
+		rep.check("settings", "graphql_schema", err)
+	}
+
+	validateAuthConfig(rep)
+
+	// Directories
+	checkDataDir(rep)
+	for _, d := range []struct{ name, dir string }{
+		{"FSAPI_CC_QUEUE_DIR", FSAPI_CC_QUEUE_DIR},
+		{"FSAPI_DIRECTORY_DIR", FSAPI_DIRECTORY_DIR},
+		{"FSAPI_SOFIA_ALIAS_DIR", FSAPI_SOFIA_ALIAS_DIR},
+		{"FSAPI_MEDIA_DIR", FSAPI_MEDIA_DIR},
+		{"FSAPI_TTS_CACHE_DIR", FSAPI_TTS_CACHE_DIR},
+	} {
+		if d.dir == "" {
+			rep.add("directories", d.name, checkSkip, "not set")
+			continue
+		}
+		rep.check("directories", d.name, checkWritableDir(d.dir))
+	}
+	if FSAPI_DID_DIALPLAN_FILE == "" {
+		rep.add("directories", "FSAPI_DID_DIALPLAN_FILE", checkSkip, "not set")
+	} else {
+		rep.check("directories", "FSAPI_DID_DIALPLAN_FILE", checkWritableDir(filepath.Dir(FSAPI_DID_DIALPLAN_FILE)))
+	}
+
+	// ESL
+	switch {
+	case !checkESL:
+		rep.add("esl", "connect", checkSkip, "pass -check-esl to connect")
+	case FSAPI_MODE == "mock":
+		rep.add("esl", "connect", checkSkip, "FSAPI_MODE=mock")
+	default:
+		client := NewESLClient(ESL_HOST, ESL_PORT, ESL_PASSWORD)
+		if err := client.Connect(); err != nil {
+			rep.add("esl", "connect", checkError, fmt.Sprintf("%s:%s: %v", ESL_HOST, ESL_PORT, err))
+		} else {
+			rep.add("esl", "connect", checkOK, fmt.Sprintf("%s:%s", ESL_HOST, ESL_PORT))
+			client.Close()
+		}
+	}
+
+	rep.Valid = rep.Errors == 0
+	return rep
+}
+
+// validateAuthConfig checks that tokens, signing keys, sessions and the
+// policy endpoint are consistent with each other
+func validateAuthConfig(rep *ConfigReport) {
+	tokens := splitCSV(FSAPI_AUTH_TOKENS)
+	var keys map[string]string
+	if FSAPI_SIGNING_KEYS != "" {
+		var err error
+		keys, err = parseSigningKeys(FSAPI_SIGNING_KEYS)
+		rep.check("auth", "FSAPI_SIGNING_KEYS", err)
+	}
+	if FSAPI_REPLAY_PROTECTION == "true" && len(keys) == 0 {
+		rep.add("auth", "FSAPI_REPLAY_PROTECTION", checkError, "requires FSAPI_SIGNING_KEYS")
+	}
+	if len(tokens) == 0 && len(keys) == 0 {
+		rep.add("auth", "credentials", checkWarn, "no FSAPI_AUTH_TOKENS or FSAPI_SIGNING_KEYS; the API is accessible without authentication and session tokens are disabled")
+	}
+
+	seen := make(map[string]bool)
+	for i, token := range tokens {
+		name := fmt.Sprintf("FSAPI_AUTH_TOKENS[%d]", i)
+		switch {
+		case strings.HasPrefix(token, sessionTokenPrefix):
+			rep.add("auth", name, checkError, fmt.Sprintf("starts with %q, which is reserved for session tokens", sessionTokenPrefix))
+		case seen[token]:
+			rep.add("auth", name, checkWarn, "duplicate token")
+		case len(token) < minSecretLength:
+			rep.add("auth", name, checkWarn, fmt.Sprintf("shorter than %d characters", minSecretLength))
+		default:
+			rep.add("auth", name, checkOK, "")
+		}
+		seen[token] = true
+	}
+	ids := make([]string, 0, len(keys))
+	for id := range keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		name := "FSAPI_SIGNING_KEYS[" + id + "]"
+		if len(keys[id]) < minSecretLength {
+			rep.add("auth", name, checkWarn, fmt.Sprintf("secret shorter than %d characters", minSecretLength))
+			continue
+		}
+		rep.add("auth", name, checkOK, "")
+	}
+
+	if FSAPI_POLICY_URL != "" {
+		if u, err := url.Parse(FSAPI_POLICY_URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			rep.add("auth", "FSAPI_POLICY_URL", checkError, fmt.Sprintf("%q is not an http(s) URL", FSAPI_POLICY_URL))
+		} else if FSAPI_POLICY_FAIL_OPEN == "true" {
+			rep.add("auth", "FSAPI_POLICY_URL", checkWarn, "FSAPI_POLICY_FAIL_OPEN=true allows mutating requests whenever the policy endpoint is down")
+		} else {
+			rep.add("auth", "FSAPI_POLICY_URL", checkOK, "")
+		}
+	}
+	if maxTTL, err := strconv.Atoi(FSAPI_SESSION_MAX_TTL); err == nil && time.Duration(maxTTL)*time.Second > 24*time.Hour {
+		rep.add("auth", "FSAPI_SESSION_MAX_TTL", checkWarn, "session tokens may live longer than a day")
+	}
+}
+
+// checkDataDir reports whether FSAPI_DATA_DIR exists and is writable, or can
+// be created
+func checkDataDir(rep *ConfigReport) {
+	info, err := os.Stat(FSAPI_DATA_DIR)
+	if os.IsNotExist(err) {
+		if err := checkWritableDir(filepath.Dir(FSAPI_DATA_DIR)); err != nil {
+			rep.add("directories", "FSAPI_DATA_DIR", checkError, fmt.Sprintf("%q does not exist and cannot be created: %v", FSAPI_DATA_DIR, err))
+			return
+		}
+		rep.add("directories", "FSAPI_DATA_DIR", checkWarn, fmt.Sprintf("%q does not exist yet; it is created on first write", FSAPI_DATA_DIR))
+		return
+	}
+	if err == nil && !info.IsDir() {
+		err = fmt.Errorf("%q is not a directory", FSAPI_DATA_DIR)
+	}
+	if err == nil {
+		err = checkWritableDir(FSAPI_DATA_DIR)
+	}
+	rep.check("directories", "FSAPI_DATA_DIR", err)
+}
+
+// checkWritableDir returns an error unless dir is a directory fs-api can
+// create files in
+func checkWritableDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%q is not a directory", dir)
+	}
+	f, err := os.CreateTemp(dir, ".fsapi-validate-*")
+	if err != nil {
+		return fmt.Errorf("%q is not writable: %v", dir, err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return nil
+}
+
+// write prints the report as aligned text or, with asJSON, as one JSON
+// document
+func (rep *ConfigReport) write(w io.Writer, asJSON bool) {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(rep)
+		return
+	}
+	for _, c := range rep.Checks {
+		line := fmt.Sprintf("%-5s  %-11s  %s", strings.ToUpper(c.Status), c.Category, c.Name)
+		if c.Message != "" {
+			line += ": " + c.Message
+		}
+		fmt.Fprintln(w, line)
+	}
+	result := "valid"
+	if !rep.Valid {
+		result = "INVALID"
+	}
+	fmt.Fprintf(w, "\nfs-api v%s configuration %s (%d error(s), %d warning(s))\n", rep.Version, result, rep.Errors, rep.Warns)
+}
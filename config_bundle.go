@@ -0,0 +1,365 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Import modes: merge adds and replaces entries by key; replace makes each
+// section in the bundle the whole of its store
+const (
+	importMerge   = "merge"
+	importReplace = "replace"
+)
+
+// ConfigBundle is the configuration fs-api manages, as exported by GET
+// /v1/admin/export and read by POST /v1/admin/import. A section left out
+// of an import is not touched.
+type ConfigBundle struct {
+	Version       string            `json:"version,omitempty"` // fs-api version that exported it
+	ExportedAt    time.Time         `json:"exported_at"`
+	Tokens        []string          `json:"tokens"` // Fingerprints of FSAPI_AUTH_TOKENS; set through the environment, never imported
+	Webhooks      []*Webhook        `json:"webhooks"`
+	DIDs          []*DID            `json:"dids"`
+	FlowTemplates []*FlowTemplate   `json:"flow_templates"`
+	BusinessHours []*HoursCalendar  `json:"business_hours"`
+	Blocklist     []*BlocklistEntry `json:"blocklist"`
+	CallerIDs     []*CallerID       `json:"caller_ids"`
+}
+
+// ConfigImportResult is what an import changed, per section
+type ConfigImportResult struct {
+	Mode          string   `json:"mode"`
+	Webhooks      int      `json:"webhooks"`
+	DIDs          int      `json:"dids"`
+	FlowTemplates int      `json:"flow_templates"`
+	BusinessHours int      `json:"business_hours"`
+	Blocklist     int      `json:"blocklist"`
+	CallerIDs     int      `json:"caller_ids"`
+	MissingTokens []string `json:"missing_tokens"` // Exported tokens not configured here
+}
+
+// tokenFingerprints returns the fingerprints of FSAPI_AUTH_TOKENS, sorted
+func tokenFingerprints() []string {
+	prints := []string{}
+	for _, token := range splitCSV(FSAPI_AUTH_TOKENS) {
+		prints = append(prints, tokenFingerprint(token))
+	}
+	sort.Strings(prints)
+	return prints
+}
+
+// validate checks every entry of the bundle, filling in what the API would
+// on creation, and returns the first problem as section[i]: message
+func (b *ConfigBundle) validate() error {
+	now := time.Now().UTC()
+	stamp := func(created, updated *time.Time) {
+		if created.IsZero() {
+			*created = now
+		}
+		if updated != nil && updated.IsZero() {
+			*updated = *created
+		}
+	}
+
+	seen := map[string]bool{}
+	unique := func(section, key string) error {
+		if seen[section+" "+key] {
+			return fmt.Errorf("%s has %s twice", section, key)
+		}
+		seen[section+" "+key] = true
+		return nil
+	}
+	for i, hook := range b.Webhooks {
+		if hook == nil {
+			return fmt.Errorf("webhooks[%d] is null", i)
+		}
+		if hook.ID == "" {
+			hook.ID = uuid.New().String()
+		}
+		if err := checkWebhookURL(hook.URL, hook.Restricted); err != nil {
+			return fmt.Errorf("webhooks[%d]: %v", i, err)
+		}
+		for _, ctx := range hook.Contexts {
+			if !domainPattern.MatchString(ctx) {
+				return fmt.Errorf("webhooks[%d]: contexts must be domains", i)
+			}
+		}
+		if err := unique("webhooks", hook.ID); err != nil {
+			return err
+		}
+		stamp(&hook.CreatedAt, nil)
+	}
+	for i, did := range b.DIDs {
+		if did == nil {
+			return fmt.Errorf("dids[%d] is null", i)
+		}
+		if err := did.validate(); err != nil {
+			return fmt.Errorf("dids[%d]: %v", i, err)
+		}
+		if err := unique("dids", did.Number); err != nil {
+			return err
+		}
+		stamp(&did.CreatedAt, &did.UpdatedAt)
+	}
+	for i, t := range b.FlowTemplates {
+		if t == nil {
+			return fmt.Errorf("flow_templates[%d] is null", i)
+		}
+		if err := t.validate(); err != nil {
+			return fmt.Errorf("flow_templates[%d]: %v", i, err)
+		}
+		if err := unique("flow_templates", t.id()); err != nil {
+			return err
+		}
+		stamp(&t.CreatedAt, &t.UpdatedAt)
+	}
+	for i, cal := range b.BusinessHours {
+		if cal == nil {
+			return fmt.Errorf("business_hours[%d] is null", i)
+		}
+		if !domainPattern.MatchString(cal.Context) {
+			return fmt.Errorf("business_hours[%d]: context must be a domain", i)
+		}
+		cal.normalize()
+		if err := cal.validate(); err != nil {
+			return fmt.Errorf("business_hours[%d]: %v", i, err)
+		}
+		if err := unique("business_hours", cal.Context); err != nil {
+			return err
+		}
+		stamp(&cal.CreatedAt, &cal.UpdatedAt)
+	}
+	for i, entry := range b.Blocklist {
+		if entry == nil {
+			return fmt.Errorf("blocklist[%d] is null", i)
+		}
+		if entry.ID == "" {
+			entry.ID = uuid.New().String()
+		}
+		if err := entry.validate(); err != nil {
+			return fmt.Errorf("blocklist[%d]: %v", i, err)
+		}
+		if err := unique("blocklist", entry.ID); err != nil {
+			return err
+		}
+		key := entry.Prefix
+		if entry.Context != "" {
+			key += "@" + entry.Context
+		}
+		if err := unique("blocklist", key); err != nil {
+			return err
+		}
+		stamp(&entry.CreatedAt, nil)
+	}
+	for i, c := range b.CallerIDs {
+		if c == nil {
+			return fmt.Errorf("caller_ids[%d] is null", i)
+		}
+		if err := c.validate(); err != nil {
+			return fmt.Errorf("caller_ids[%d]: %v", i, err)
+		}
+		if err := unique("caller_ids", c.id()); err != nil {
+			return err
+		}
+		stamp(&c.CreatedAt, &c.UpdatedAt)
+	}
+	return nil
+}
+
+// GET /v1/admin/export
+//
+// The bundle holds webhook signing secrets; treat it as a secret.
+func (h *APIHandler) ExportConfig(w http.ResponseWriter, r *http.Request) {
+	if !h.requireUnrestricted(w, r) {
+		return
+	}
+
+	bundle := ConfigBundle{
+		Version:       Version,
+		ExportedAt:    time.Now().UTC(),
+		Tokens:        tokenFingerprints(),
+		DIDs:          h.dids.list(),
+		BusinessHours: h.hours.list(),
+		Blocklist:     h.blocklist.list(),
+		CallerIDs:     h.callerIDs.list(),
+	}
+	h.webhooks.mu.Lock()
+	bundle.Webhooks = make([]*Webhook, 0, len(h.webhooks.hooks))
+	for _, hook := range h.webhooks.hooks {
+		bundle.Webhooks = append(bundle.Webhooks, hook)
+	}
+	h.webhooks.mu.Unlock()
+	sort.Slice(bundle.Webhooks, func(i, j int) bool { return bundle.Webhooks[i].CreatedAt.Before(bundle.Webhooks[j].CreatedAt) })
+	h.flowTemplates.mu.Lock()
+	bundle.FlowTemplates = make([]*FlowTemplate, 0, len(h.flowTemplates.templates))
+	for _, t := range h.flowTemplates.templates {
+		bundle.FlowTemplates = append(bundle.FlowTemplates, t)
+	}
+	h.flowTemplates.mu.Unlock()
+	sort.Slice(bundle.FlowTemplates, func(i, j int) bool { return bundle.FlowTemplates[i].id() < bundle.FlowTemplates[j].id() })
+
+	h.audit(r, AuditEntry{Action: "admin.export", Target: "config"})
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="fs-api-config-%s.json"`, bundle.ExportedAt.Format("20060102T150405Z")))
+	h.respondJSON(w, r, bundle)
+}
+
+// POST /v1/admin/import?mode=merge|replace
+//
+// The whole bundle is validated before anything is changed.
+func (h *APIHandler) ImportConfig(w http.ResponseWriter, r *http.Request) {
+	if !h.requireUnrestricted(w, r) {
+		return
+	}
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = importMerge
+	}
+	if mode != importMerge && mode != importReplace {
+		h.respondError(w, r, "mode must be merge or replace", http.StatusBadRequest)
+		return
+	}
+
+	var bundle ConfigBundle
+	if !h.decodeRequest(w, r, &bundle) {
+		return
+	}
+	if err := bundle.validate(); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	requestID := getRequestID(r)
+	result := ConfigImportResult{Mode: mode, MissingTokens: []string{}}
+	configured := tokenFingerprints()
+	for _, print := range bundle.Tokens {
+		if !containsString(configured, print) {
+			result.MissingTokens = append(result.MissingTokens, print)
+		}
+	}
+
+	// A section is imported when the bundle has it, even empty: in replace
+	// mode an empty list clears the store
+	if bundle.Webhooks != nil {
+		h.webhooks.mu.Lock()
+		if mode == importReplace {
+			h.webhooks.hooks = make(map[string]*Webhook)
+		}
+		for _, hook := range bundle.Webhooks {
+			h.webhooks.hooks[hook.ID] = hook
+		}
+		if err := h.webhooks.save(); err != nil {
+			logWarn(requestID, fmt.Sprintf("Failed to persist webhooks: %v", err))
+		}
+		h.webhooks.mu.Unlock()
+		result.Webhooks = len(bundle.Webhooks)
+	}
+	if bundle.DIDs != nil {
+		h.dids.mu.Lock()
+		if mode == importReplace {
+			h.dids.dids = make(map[string]*DID)
+		}
+		for _, did := range bundle.DIDs {
+			h.dids.dids[did.Number] = did
+		}
+		if err := h.dids.save(); err != nil {
+			logWarn(requestID, fmt.Sprintf("Failed to persist DIDs: %v", err))
+		}
+		h.dids.mu.Unlock()
+		if err := h.writeDIDDialplan(); err != nil {
+			logWarn(requestID, fmt.Sprintf("Failed to write DID dialplan: %v", err))
+		}
+		result.DIDs = len(bundle.DIDs)
+	}
+	if bundle.FlowTemplates != nil {
+		h.flowTemplates.mu.Lock()
+		if mode == importReplace {
+			h.flowTemplates.templates = make(map[string]*FlowTemplate)
+		}
+		for _, t := range bundle.FlowTemplates {
+			h.flowTemplates.templates[t.id()] = t
+		}
+		if err := h.flowTemplates.save(); err != nil {
+			logWarn(requestID, fmt.Sprintf("Failed to persist flow templates: %v", err))
+		}
+		h.flowTemplates.mu.Unlock()
+		result.FlowTemplates = len(bundle.FlowTemplates)
+	}
+	if bundle.BusinessHours != nil {
+		h.hours.mu.Lock()
+		if mode == importReplace {
+			h.hours.calendars = make(map[string]*HoursCalendar)
+		}
+		for _, cal := range bundle.BusinessHours {
+			h.hours.calendars[cal.Context] = cal
+		}
+		if err := h.hours.save(); err != nil {
+			logWarn(requestID, fmt.Sprintf("Failed to persist business hours: %v", err))
+		}
+		h.hours.mu.Unlock()
+		result.BusinessHours = len(bundle.BusinessHours)
+	}
+	if bundle.Blocklist != nil {
+		h.blocklist.mu.Lock()
+		if mode == importReplace {
+			h.blocklist.entries = make(map[string]*BlocklistEntry)
+		}
+		for _, entry := range bundle.Blocklist {
+			// An entry for the same prefix takes the place of the one here
+			for id, e := range h.blocklist.entries {
+				if e.Context == entry.Context && e.Prefix == entry.Prefix {
+					delete(h.blocklist.entries, id)
+				}
+			}
+			h.blocklist.entries[entry.ID] = entry
+		}
+		if err := h.blocklist.save(); err != nil {
+			logWarn(requestID, fmt.Sprintf("Failed to persist blocklist: %v", err))
+		}
+		h.blocklist.mu.Unlock()
+		result.Blocklist = len(bundle.Blocklist)
+	}
+	if bundle.CallerIDs != nil {
+		h.callerIDs.mu.Lock()
+		if mode == importReplace {
+			h.callerIDs.numbers = make(map[string]*CallerID)
+		}
+		for _, c := range bundle.CallerIDs {
+			h.callerIDs.numbers[c.id()] = c
+		}
+		if err := h.callerIDs.save(); err != nil {
+			logWarn(requestID, fmt.Sprintf("Failed to persist caller IDs: %v", err))
+		}
+		h.callerIDs.mu.Unlock()
+		result.CallerIDs = len(bundle.CallerIDs)
+	}
+
+	details := map[string]string{
+		"mode":           mode,
+		"webhooks":       fmt.Sprint(result.Webhooks),
+		"dids":           fmt.Sprint(result.DIDs),
+		"flow_templates": fmt.Sprint(result.FlowTemplates),
+		"business_hours": fmt.Sprint(result.BusinessHours),
+		"blocklist":      fmt.Sprint(result.Blocklist),
+		"caller_ids":     fmt.Sprint(result.CallerIDs),
+	}
+	if !bundle.ExportedAt.IsZero() {
+		details["exported_at"] = bundle.ExportedAt.Format(time.RFC3339)
+	}
+	h.audit(r, AuditEntry{Action: "admin.import", Target: "config", Details: details})
+
+	message := fmt.Sprintf("Configuration imported (%s)", mode)
+	if len(result.MissingTokens) > 0 {
+		message += fmt.Sprintf("; tokens not configured here: %s", strings.Join(result.MissingTokens, ", "))
+	}
+	h.respondJSON(w, r, SuccessResponse{
+		Status:  "success",
+		Message: message,
+		Data:    result,
+	})
+}
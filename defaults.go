@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Built-in values for fields a request leaves out, used for contexts that
+// FSAPI_HANGUP_CAUSE, FSAPI_DTMF_DURATION and FSAPI_PARK_APP do not cover
+const (
+	builtinHangupCause  = "NORMAL_CLEARING"
+	builtinDTMFDuration = 100 // Milliseconds per digit
+	builtinParkApp      = "&park()"
+)
+
+// parkAppPattern matches an inline dialplan application: &name(args)
+var parkAppPattern = regexp.MustCompile(`^&[a-z][a-z0-9_]*\(.*\)$`)
+
+// callDefaults holds the configured defaults as context -> value, "*" for
+// every other context
+type callDefaults struct {
+	hangupCause  map[string]string
+	dtmfDuration map[string]string
+	parkApp      map[string]string
+}
+
+// parseContextValues parses "ctx=value,*=value", checking each value. Values
+// cannot contain commas.
+func parseContextValues(value string, check func(string) error) (map[string]string, error) {
+	values := make(map[string]string)
+	for _, entry := range splitCSV(value) {
+		ctx, v, ok := strings.Cut(entry, "=")
+		ctx, v = strings.TrimSpace(ctx), strings.TrimSpace(v)
+		if !ok || ctx == "" {
+			return nil, fmt.Errorf("invalid entry %q (expected context=value)", entry)
+		}
+		if err := check(v); err != nil {
+			return nil, fmt.Errorf("%s: %v", ctx, err)
+		}
+		values[ctx] = v
+	}
+	return values, nil
+}
+
+func checkHangupCause(v string) error {
+	if !hangupCausePattern.MatchString(v) {
+		return fmt.Errorf("%q is not a hangup cause (e.g. NORMAL_CLEARING)", v)
+	}
+	return nil
+}
+
+func checkDTMFDuration(v string) error {
+	if ms, err := strconv.Atoi(v); err != nil || ms <= 0 {
+		return fmt.Errorf("%q is not a positive number of milliseconds", v)
+	}
+	return nil
+}
+
+func checkParkApp(v string) error {
+	if !parkAppPattern.MatchString(v) {
+		return fmt.Errorf("%q is not an inline application (e.g. &park())", v)
+	}
+	return nil
+}
+
+// contextValue returns the value for callContext, the "*" value, or builtin
+func contextValue(values map[string]string, callContext, builtin string) string {
+	if v, ok := values[callContext]; ok {
+		return v
+	}
+	if v, ok := values["*"]; ok {
+		return v
+	}
+	return builtin
+}
+
+// hangupCauseFor returns the cause used when a hangup names none
+func (d *callDefaults) hangupCauseFor(callContext string) string {
+	return contextValue(d.hangupCause, callContext, builtinHangupCause)
+}
+
+// dtmfDurationFor returns the per-digit duration in milliseconds used when a
+// DTMF request gives none
+func (d *callDefaults) dtmfDurationFor(callContext string) int {
+	ms, err := strconv.Atoi(contextValue(d.dtmfDuration, callContext, ""))
+	if err != nil {
+		return builtinDTMFDuration
+	}
+	return ms
+}
+
+// parkAppFor returns the B-leg application used when an originate gives none
+func (d *callDefaults) parkAppFor(callContext string) string {
+	return contextValue(d.parkApp, callContext, builtinParkApp)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Endpoint types a dial string can be built for
+const (
+	dialTypeUser          = "user"           // user/<user>@<domain>
+	dialTypeGateway       = "gateway"        // sofia/gateway/<gateway>/<number>
+	dialTypeSofiaExternal = "sofia_external" // sofia/external/<number>@<domain>
+)
+
+var (
+	dialNumberPattern = regexp.MustCompile(`^\+?[0-9*#]{1,32}$`)
+	sipHostPattern    = regexp.MustCompile(`^[A-Za-z0-9-]+(\.[A-Za-z0-9-]+)*(:[0-9]{1,5})?$`)
+)
+
+// DialTarget describes an endpoint in fields instead of a raw channel string
+type DialTarget struct {
+	Type    string `json:"type"`              // user, gateway or sofia_external
+	User    string `json:"user,omitempty"`    // Directory user ID (user)
+	Domain  string `json:"domain,omitempty"`  // Directory domain (user) or SIP host[:port] (sofia_external)
+	Gateway string `json:"gateway,omitempty"` // Sofia gateway name (gateway)
+	Number  string `json:"number,omitempty"`  // Number dialed (gateway, sofia_external)
+}
+
+// dialString validates the target and returns its FreeSWITCH dial string
+func (t *DialTarget) dialString() (string, error) {
+	// Fields each type uses; anything else set is a mistake worth reporting
+	used := map[string]bool{}
+	switch t.Type {
+	case dialTypeUser:
+		used["user"], used["domain"] = true, true
+		if !userIDPattern.MatchString(t.User) {
+			return "", fmt.Errorf("user must be a directory user ID")
+		}
+		if !domainPattern.MatchString(t.Domain) {
+			return "", fmt.Errorf("domain must be a domain name")
+		}
+	case dialTypeGateway:
+		used["gateway"], used["number"] = true, true
+		if !isValidName(t.Gateway) {
+			return "", fmt.Errorf("gateway must be a gateway name (letters, digits, '_', '.', '-')")
+		}
+		if !dialNumberPattern.MatchString(t.Number) {
+			return "", fmt.Errorf("number must be digits, '*' or '#', optionally with a leading '+'")
+		}
+	case dialTypeSofiaExternal:
+		used["number"], used["domain"] = true, true
+		if !dialNumberPattern.MatchString(t.Number) {
+			return "", fmt.Errorf("number must be digits, '*' or '#', optionally with a leading '+'")
+		}
+		if !sipHostPattern.MatchString(t.Domain) {
+			return "", fmt.Errorf("domain must be a SIP host, optionally with :port")
+		}
+	default:
+		return "", fmt.Errorf("type must be user, gateway or sofia_external")
+	}
+
+	for _, f := range []struct{ name, value string }{
+		{"user", t.User}, {"domain", t.Domain}, {"gateway", t.Gateway}, {"number", t.Number},
+	} {
+		if f.value != "" && !used[f.name] {
+			return "", fmt.Errorf("%s is not used with type %s", f.name, t.Type)
+		}
+	}
+
+	switch t.Type {
+	case dialTypeUser:
+		return fmt.Sprintf("user/%s@%s", t.User, t.Domain), nil
+	case dialTypeGateway:
+		return fmt.Sprintf("sofia/gateway/%s/%s", t.Gateway, t.Number), nil
+	default:
+		return fmt.Sprintf("sofia/external/%s@%s", t.Number, t.Domain), nil
+	}
+}
+
+// varBlockClosers pairs the characters opening a dial string's variable
+// blocks: {} for every leg, [] for one leg, <> for one enterprise leg
+var varBlockClosers = map[byte]byte{'{': '}', '[': ']', '<': '>'}
+
+// dialStringVars returns the name/value pairs set in the variable blocks of
+// a dial string, in order. Blocks nested in a value, such as the <sip:...>
+// of a SIP header, are part of that value.
+func dialStringVars(dial string) [][2]string {
+	var vars [][2]string
+	var open []int
+	for i := 0; i < len(dial); i++ {
+		if _, ok := varBlockClosers[dial[i]]; ok {
+			open = append(open, i)
+			continue
+		}
+		n := len(open)
+		if n == 0 || dial[i] != varBlockClosers[dial[open[n-1]]] {
+			continue
+		}
+		start := open[n-1]
+		open = open[:n-1]
+		if n == 1 {
+			vars = append(vars, parseVarBlock(dial[start+1:i])...)
+		}
+	}
+	return vars
+}
+
+// parseVarBlock splits the inside of a variable block into name/value
+// pairs. "^^<sep>" at its start replaces the comma separator.
+func parseVarBlock(block string) [][2]string {
+	sep := ","
+	if len(block) > 2 && strings.HasPrefix(block, "^^") {
+		sep, block = block[2:3], block[3:]
+	}
+	var vars [][2]string
+	for _, item := range strings.Split(block, sep) {
+		if name, value, ok := strings.Cut(item, "="); ok {
+			vars = append(vars, [2]string{strings.TrimSpace(name), strings.Trim(value, "'\"")})
+		}
+	}
+	return vars
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const didsFile = "dids.json"
+
+// DID destination types
+const (
+	didDestUser      = "user"
+	didDestQueue     = "queue"
+	didDestIVR       = "ivr"
+	didDestExtension = "extension"
+)
+
+var didNumberPattern = regexp.MustCompile(`^\+?[0-9]{3,20}$`)
+
+// DID maps an inbound number to a destination inside a tenant context
+type DID struct {
+	Number      string    `json:"number"`
+	Context     string    `json:"context"` // Tenant context (domain) the number belongs to
+	Type        string    `json:"type"`    // user, queue, ivr or extension
+	Target      string    `json:"target"`  // User id, queue name@domain, IVR menu or extension
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// didRegistry is the persisted DID inventory, keyed by number without a
+// leading '+'
+type didRegistry struct {
+	mu   sync.Mutex
+	dids map[string]*DID
+}
+
+// newDIDRegistry loads the DID inventory from FSAPI_DATA_DIR
+func newDIDRegistry() *didRegistry {
+	reg := &didRegistry{dids: make(map[string]*DID)}
+	var dids []*DID
+	if err := loadJSONFile(didsFile, &dids); err != nil {
+		log.Printf("WARNING: Failed to load DIDs: %v", err)
+	}
+	// Older inventories may list a number both with and without '+'; the
+	// bare one is the one calls were routed to
+	sort.SliceStable(dids, func(i, j int) bool {
+		return !strings.HasPrefix(dids[i].Number, "+") && strings.HasPrefix(dids[j].Number, "+")
+	})
+	for _, did := range dids {
+		did.Number = strings.TrimPrefix(did.Number, "+")
+		if existing, ok := reg.dids[did.Number]; ok {
+			log.Printf("WARNING: DID %s is listed twice; keeping the one in context %s", did.Number, existing.Context)
+			continue
+		}
+		reg.dids[did.Number] = did
+	}
+	return reg
+}
+
+// list returns the DIDs sorted by number
+func (reg *didRegistry) list() []*DID {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	dids := make([]*DID, 0, len(reg.dids))
+	for _, did := range reg.dids {
+		dids = append(dids, did)
+	}
+	sort.Slice(dids, func(i, j int) bool { return dids[i].Number < dids[j].Number })
+	return dids
+}
+
+// lookup finds the DID for a dialed number, with or without a leading '+'
+func (reg *didRegistry) lookup(number string) *DID {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	return reg.dids[strings.TrimPrefix(number, "+")]
+}
+
+// save persists the inventory. Caller must hold mu.
+func (reg *didRegistry) save() error {
+	dids := make([]*DID, 0, len(reg.dids))
+	for _, did := range reg.dids {
+		dids = append(dids, did)
+	}
+	sort.Slice(dids, func(i, j int) bool { return dids[i].Number < dids[j].Number })
+	return saveJSONFile(didsFile, dids)
+}
+
+// validate checks the DID and normalizes its number, which is stored
+// without a leading '+', and its target
+func (did *DID) validate() error {
+	if !didNumberPattern.MatchString(did.Number) {
+		return fmt.Errorf("number must be 3-20 digits with an optional leading '+'")
+	}
+	did.Number = strings.TrimPrefix(did.Number, "+")
+	if !domainPattern.MatchString(did.Context) {
+		return fmt.Errorf("context is required")
+	}
+	switch did.Type {
+	case didDestUser, didDestExtension:
+		if !userIDPattern.MatchString(did.Target) {
+			return fmt.Errorf("target must be a %s in the DID's context (letters, digits and . _ + -)", did.Type)
+		}
+	case didDestQueue:
+		if !queueNamePattern.MatchString(did.Target) || extractDomain(did.Target) != did.Context {
+			return fmt.Errorf("target must be a queue name@%s", did.Context)
+		}
+	case didDestIVR:
+		if !isValidChannelVarName(did.Target) {
+			return fmt.Errorf("target must be an IVR menu name")
+		}
+	default:
+		return fmt.Errorf("type must be one of: user, queue, ivr, extension")
+	}
+	if strings.ContainsAny(did.Description, "\n\r") {
+		return fmt.Errorf("description must be a single line")
+	}
+	return nil
+}
+
+// --- Dialplan rendering ---
+
+type didXMLAction struct {
+	Application string `xml:"application,attr"`
+	Data        string `xml:"data,attr,omitempty"`
+}
+
+type didXMLExtension struct {
+	Name      string `xml:"name,attr"`
+	Condition struct {
+		Field      string         `xml:"field,attr"`
+		Expression string         `xml:"expression,attr"`
+		Actions    []didXMLAction `xml:"action"`
+	} `xml:"condition"`
+}
+
+type didXMLInclude struct {
+	XMLName    xml.Name          `xml:"include"`
+	Extensions []didXMLExtension `xml:"extension"`
+}
+
+// didExtension renders one DID as a dialplan extension. The call is tagged
+// with the tenant's domain_name and accountcode so fs-api attributes it to
+// the DID's context.
+func didExtension(did *DID) didXMLExtension {
+	var ext didXMLExtension
+	ext.Name = "fsapi_did_" + strings.TrimPrefix(did.Number, "+")
+	ext.Condition.Field = "destination_number"
+	ext.Condition.Expression = "^\\+?" + strings.TrimPrefix(did.Number, "+") + "$"
+
+	actions := []didXMLAction{
+		{"set", "domain_name=" + did.Context},
+		{"set", "accountcode=" + did.Context},
+	}
+	switch did.Type {
+	case didDestUser:
+		actions = append(actions, didXMLAction{"bridge", "user/" + did.Target + "@" + did.Context})
+	case didDestQueue:
+		actions = append(actions, didXMLAction{"answer", ""}, didXMLAction{"callcenter", did.Target})
+	case didDestIVR:
+		actions = append(actions, didXMLAction{"answer", ""}, didXMLAction{"ivr", did.Target})
+	case didDestExtension:
+		actions = append(actions, didXMLAction{"transfer", did.Target + " XML " + did.Context})
+	}
+	ext.Condition.Actions = actions
+	return ext
+}
+
+// renderDIDDialplan renders the DIDs as an <include> of extensions for the
+// inbound (FSAPI_DID_CONTEXT) dialplan context
+func renderDIDDialplan(dids []*DID) ([]byte, error) {
+	doc := didXMLInclude{Extensions: []didXMLExtension{}}
+	for _, did := range dids {
+		doc.Extensions = append(doc.Extensions, didExtension(did))
+	}
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// writeDIDDialplan rewrites FSAPI_DID_DIALPLAN_FILE, when set, and reloads
+// the XML registry
+func (h *APIHandler) writeDIDDialplan() error {
+	if FSAPI_DID_DIALPLAN_FILE == "" {
+		return nil
+	}
+	data, err := renderDIDDialplan(h.dids.list())
+	if err != nil {
+		return err
+	}
+	tmp := FSAPI_DID_DIALPLAN_FILE + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, FSAPI_DID_DIALPLAN_FILE); err != nil {
+		return err
+	}
+	_, err = h.eslClient.SendCommand("api reloadxml")
+	return err
+}
+
+// --- DID handlers ---
+
+// GET /v1/dids
+func (h *APIHandler) ListDIDs(w http.ResponseWriter, r *http.Request) {
+	contextFilter := r.URL.Query().Get("context")
+	rows := []*DID{}
+	for _, did := range h.dids.list() {
+		if contextFilter != "" && did.Context != contextFilter {
+			continue
+		}
+		if isContextAllowed(r, did.Context) {
+			rows = append(rows, did)
+		}
+	}
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status":    "success",
+		"row_count": len(rows),
+		"rows":      rows,
+	})
+}
+
+// POST /v1/dids
+func (h *APIHandler) CreateDID(w http.ResponseWriter, r *http.Request) {
+	var did DID
+	if !h.decodeRequest(w, r, &did) {
+		return
+	}
+	if err := did.validate(); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !h.validateRequestContext(w, r, did.Context) {
+		return
+	}
+	did.CreatedAt = time.Now().UTC()
+	did.UpdatedAt = did.CreatedAt
+
+	h.dids.mu.Lock()
+	if _, exists := h.dids.dids[did.Number]; exists {
+		h.dids.mu.Unlock()
+		h.respondError(w, r, fmt.Sprintf("DID %s already exists", did.Number), http.StatusConflict)
+		return
+	}
+	h.dids.dids[did.Number] = &did
+	err := h.dids.save()
+	h.dids.mu.Unlock()
+	if err != nil {
+		logWarn(getRequestID(r), fmt.Sprintf("Failed to persist DIDs: %v", err))
+	}
+	if err := h.writeDIDDialplan(); err != nil {
+		logWarn(getRequestID(r), fmt.Sprintf("Failed to write DID dialplan: %v", err))
+	}
+
+	logInfo(getRequestID(r), fmt.Sprintf("DID %s routed to %s %s", did.Number, did.Type, did.Target))
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   did,
+	})
+}
+
+// lookupDID returns the DID named in the URL if the caller may see it
+func (h *APIHandler) lookupDID(w http.ResponseWriter, r *http.Request) (*DID, bool) {
+	number := mux.Vars(r)["number"]
+	h.dids.mu.Lock()
+	did, ok := h.dids.dids[strings.TrimPrefix(number, "+")]
+	h.dids.mu.Unlock()
+	if !ok || !isContextAllowed(r, did.Context) {
+		h.respondError(w, r, fmt.Sprintf("DID %s not found", number), http.StatusNotFound)
+		return nil, false
+	}
+	return did, true
+}
+
+// GET /v1/dids/{number}
+func (h *APIHandler) GetDID(w http.ResponseWriter, r *http.Request) {
+	did, ok := h.lookupDID(w, r)
+	if !ok {
+		return
+	}
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   did,
+	})
+}
+
+// PUT /v1/dids/{number}
+func (h *APIHandler) UpdateDID(w http.ResponseWriter, r *http.Request) {
+	existing, ok := h.lookupDID(w, r)
+	if !ok {
+		return
+	}
+
+	var did DID
+	if !h.decodeRequest(w, r, &did) {
+		return
+	}
+	if did.Number != "" && strings.TrimPrefix(did.Number, "+") != existing.Number {
+		h.respondError(w, r, "number cannot be changed", http.StatusBadRequest)
+		return
+	}
+	did.Number = existing.Number
+	if did.Context == "" {
+		did.Context = existing.Context
+	}
+	if err := did.validate(); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Moving a number to another tenant requires access to both
+	if !h.validateRequestContext(w, r, did.Context) {
+		return
+	}
+	did.CreatedAt = existing.CreatedAt
+	did.UpdatedAt = time.Now().UTC()
+
+	h.dids.mu.Lock()
+	h.dids.dids[did.Number] = &did
+	err := h.dids.save()
+	h.dids.mu.Unlock()
+	if err != nil {
+		logWarn(getRequestID(r), fmt.Sprintf("Failed to persist DIDs: %v", err))
+	}
+	if err := h.writeDIDDialplan(); err != nil {
+		logWarn(getRequestID(r), fmt.Sprintf("Failed to write DID dialplan: %v", err))
+	}
+
+	logInfo(getRequestID(r), fmt.Sprintf("DID %s routed to %s %s", did.Number, did.Type, did.Target))
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   did,
+	})
+}
+
+// DELETE /v1/dids/{number}
+func (h *APIHandler) DeleteDID(w http.ResponseWriter, r *http.Request) {
+	did, ok := h.lookupDID(w, r)
+	if !ok {
+		return
+	}
+
+	h.dids.mu.Lock()
+	delete(h.dids.dids, did.Number)
+	err := h.dids.save()
+	h.dids.mu.Unlock()
+	if err != nil {
+		logWarn(getRequestID(r), fmt.Sprintf("Failed to persist DIDs: %v", err))
+	}
+	if err := h.writeDIDDialplan(); err != nil {
+		logWarn(getRequestID(r), fmt.Sprintf("Failed to write DID dialplan: %v", err))
+	}
+
+	h.respondSuccess(w, r, h.trashDeleted(r, fmt.Sprintf("DID %s deleted", did.Number), trashDID, did.Number, []string{did.Context}, did))
+}
+
+// GET /v1/dids/dialplan
+func (h *APIHandler) GetDIDDialplan(w http.ResponseWriter, r *http.Request) {
+	if !h.requireUnrestricted(w, r) {
+		return
+	}
+	data, err := renderDIDDialplan(h.dids.list())
+	if err != nil {
+		h.respondError(w, r, fmt.Sprintf("Failed to render dialplan: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml")
+	w.Header().Set("X-Request-ID", getRequestID(r))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestDIDNumberStoredWithoutPlus(t *testing.T) {
+	did := DID{Number: "+15551234567", Context: "acme.example", Type: didDestExtension, Target: "1000"}
+	if err := did.validate(); err != nil {
+		t.Fatalf("validate() = %v", err)
+	}
+	if did.Number != "15551234567" {
+		t.Fatalf("Number = %q, want 15551234567", did.Number)
+	}
+
+	reg := &didRegistry{dids: map[string]*DID{did.Number: &did}}
+	for _, dialed := range []string{"15551234567", "+15551234567"} {
+		if got := reg.lookup(dialed); got != &did {
+			t.Errorf("lookup(%q) = %v, want the DID", dialed, got)
+		}
+	}
+
+	// The other form of the number is the same key, so it conflicts
+	other := DID{Number: "15551234567", Context: "other.example", Type: didDestExtension, Target: "1000"}
+	if err := other.validate(); err != nil {
+		t.Fatalf("validate() = %v", err)
+	}
+	if _, exists := reg.dids[other.Number]; !exists {
+		t.Errorf("%s does not collide with +15551234567", other.Number)
+	}
+}
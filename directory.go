@@ -0,0 +1,431 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Users are written one file per user into FSAPI_DIRECTORY_DIR/<domain>/,
+// which each domain's <users> section includes:
+//
+//	<X-PRE-PROCESS cmd="include" data="fsapi/customer1.example.com/*.xml"/>
+//
+// After a change fs-api runs reloadxml.
+
+var (
+	userIDPattern = regexp.MustCompile(`^[A-Za-z0-9._+-]{1,64}$`)
+	domainPattern = regexp.MustCompile(`^[A-Za-z0-9-]+(\.[A-Za-z0-9-]+)*$`)
+)
+
+// DirectoryUser is a FreeSWITCH directory user
+type DirectoryUser struct {
+	ID                      string            `json:"id"`
+	Domain                  string            `json:"domain"`
+	Password                string            `json:"password,omitempty"`
+	VMPassword              string            `json:"vm_password,omitempty"`
+	EffectiveCallerIDName   string            `json:"effective_caller_id_name,omitempty"`
+	EffectiveCallerIDNumber string            `json:"effective_caller_id_number,omitempty"`
+	OutboundCallerIDName    string            `json:"outbound_caller_id_name,omitempty"`
+	OutboundCallerIDNumber  string            `json:"outbound_caller_id_number,omitempty"`
+	Variables               map[string]string `json:"variables,omitempty"`
+}
+
+// directoryUserView hides the passwords in API responses
+type directoryUserView struct {
+	ID                      string            `json:"id"`
+	Domain                  string            `json:"domain"`
+	HasPassword             bool              `json:"has_password"`
+	HasVMPassword           bool              `json:"has_vm_password"`
+	EffectiveCallerIDName   string            `json:"effective_caller_id_name,omitempty"`
+	EffectiveCallerIDNumber string            `json:"effective_caller_id_number,omitempty"`
+	OutboundCallerIDName    string            `json:"outbound_caller_id_name,omitempty"`
+	OutboundCallerIDNumber  string            `json:"outbound_caller_id_number,omitempty"`
+	Variables               map[string]string `json:"variables"`
+}
+
+func (u *DirectoryUser) view() directoryUserView {
+	vars := map[string]string{}
+	for k, v := range u.Variables {
+		vars[k] = v
+	}
+	return directoryUserView{
+		ID:                      u.ID,
+		Domain:                  u.Domain,
+		HasPassword:             u.Password != "",
+		HasVMPassword:           u.VMPassword != "",
+		EffectiveCallerIDName:   u.EffectiveCallerIDName,
+		EffectiveCallerIDNumber: u.EffectiveCallerIDNumber,
+		OutboundCallerIDName:    u.OutboundCallerIDName,
+		OutboundCallerIDNumber:  u.OutboundCallerIDNumber,
+		Variables:               vars,
+	}
+}
+
+type directoryXMLNameValue struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type directoryXMLUser struct {
+	ID        string                  `xml:"id,attr"`
+	Params    []directoryXMLNameValue `xml:"params>param"`
+	Variables []directoryXMLNameValue `xml:"variables>variable"`
+}
+
+type directoryXMLFile struct {
+	XMLName xml.Name         `xml:"include"`
+	User    directoryXMLUser `xml:"user"`
+}
+
+// Variables set through dedicated fields rather than variables
+var directoryReservedVars = map[string]bool{
+	"effective_caller_id_name":   true,
+	"effective_caller_id_number": true,
+	"outbound_caller_id_name":    true,
+	"outbound_caller_id_number":  true,
+}
+
+func (u *DirectoryUser) validate() error {
+	if !userIDPattern.MatchString(u.ID) {
+		return fmt.Errorf("id may only contain letters, digits and . _ + - (up to 64 characters)")
+	}
+	if !domainPattern.MatchString(u.Domain) {
+		return fmt.Errorf("domain must be a valid domain name")
+	}
+	if u.VMPassword != "" && strings.Trim(u.VMPassword, "0123456789") != "" {
+		return fmt.Errorf("vm_password must be numeric")
+	}
+	for name := range u.Variables {
+		if !isValidChannelVarName(name) {
+			return fmt.Errorf("invalid variable name '%s'", name)
+		}
+		if directoryReservedVars[name] {
+			return fmt.Errorf("variable '%s' must be set through its own field", name)
+		}
+	}
+	return nil
+}
+
+// xmlUser renders the <user> element
+func (u *DirectoryUser) xmlUser() directoryXMLUser {
+	user := directoryXMLUser{ID: u.ID}
+	if u.Password != "" {
+		user.Params = append(user.Params, directoryXMLNameValue{"password", u.Password})
+	}
+	if u.VMPassword != "" {
+		user.Params = append(user.Params, directoryXMLNameValue{"vm-password", u.VMPassword})
+	}
+
+	add := func(name, value string) {
+		if value != "" {
+			user.Variables = append(user.Variables, directoryXMLNameValue{name, value})
+		}
+	}
+	add("effective_caller_id_name", u.EffectiveCallerIDName)
+	add("effective_caller_id_number", u.EffectiveCallerIDNumber)
+	add("outbound_caller_id_name", u.OutboundCallerIDName)
+	add("outbound_caller_id_number", u.OutboundCallerIDNumber)
+	names := make([]string, 0, len(u.Variables))
+	for name := range u.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		add(name, u.Variables[name])
+	}
+	return user
+}
+
+func (u *DirectoryUser) toXML() ([]byte, error) {
+	data, err := xml.MarshalIndent(directoryXMLFile{User: u.xmlUser()}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func directoryUserFromXML(domain string, data []byte) (*DirectoryUser, error) {
+	var file directoryXMLFile
+	if err := xml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	u := &DirectoryUser{ID: file.User.ID, Domain: domain, Variables: map[string]string{}}
+	for _, p := range file.User.Params {
+		switch p.Name {
+		case "password":
+			u.Password = p.Value
+		case "vm-password":
+			u.VMPassword = p.Value
+		}
+	}
+	for _, v := range file.User.Variables {
+		switch v.Name {
+		case "effective_caller_id_name":
+			u.EffectiveCallerIDName = v.Value
+		case "effective_caller_id_number":
+			u.EffectiveCallerIDNumber = v.Value
+		case "outbound_caller_id_name":
+			u.OutboundCallerIDName = v.Value
+		case "outbound_caller_id_number":
+			u.OutboundCallerIDNumber = v.Value
+		default:
+			u.Variables[v.Name] = v.Value
+		}
+	}
+	return u, nil
+}
+
+// directoryEnabled writes a 501 when user provisioning is disabled
+func (h *APIHandler) directoryEnabled(w http.ResponseWriter, r *http.Request) bool {
+	if FSAPI_DIRECTORY_DIR == "" {
+		h.respondError(w, r, "User provisioning is disabled (FSAPI_DIRECTORY_DIR is not set)", http.StatusNotImplemented)
+		return false
+	}
+	return true
+}
+
+// splitUserAddress splits "user@domain" from the URL and checks access to
+// the domain. On failure the error response has been written.
+func (h *APIHandler) splitUserAddress(w http.ResponseWriter, r *http.Request, address string) (string, string, bool) {
+	parts := strings.SplitN(address, "@", 2)
+	if len(parts) != 2 || !userIDPattern.MatchString(parts[0]) || !domainPattern.MatchString(parts[1]) {
+		h.respondError(w, r, "user must be of the form id@domain", http.StatusBadRequest)
+		return "", "", false
+	}
+	if !h.validateCCDomain(w, r, address, "User") {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+func directoryUserPath(id, domain string) string {
+	return filepath.Join(FSAPI_DIRECTORY_DIR, domain, id+".xml")
+}
+
+func loadDirectoryUser(id, domain string) (*DirectoryUser, error) {
+	data, err := os.ReadFile(directoryUserPath(id, domain))
+	if err != nil {
+		return nil, err
+	}
+	return directoryUserFromXML(domain, data)
+}
+
+// writeDirectoryUser writes the user's file and reloads the XML registry
+func (h *APIHandler) writeDirectoryUser(u *DirectoryUser) error {
+	data, err := u.toXML()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(FSAPI_DIRECTORY_DIR, u.Domain), 0755); err != nil {
+		return err
+	}
+	path := directoryUserPath(u.ID, u.Domain)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0640); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	_, err = h.eslClient.SendCommand("api reloadxml")
+	return err
+}
+
+// GET /v1/users?domain=
+func (h *APIHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	if !h.directoryEnabled(w, r) {
+		return
+	}
+	domain := r.URL.Query().Get("domain")
+	if domain == "" || !domainPattern.MatchString(domain) {
+		h.respondError(w, r, "domain query parameter is required", http.StatusBadRequest)
+		return
+	}
+	if !h.validateRequestContext(w, r, domain) {
+		return
+	}
+
+	files, err := filepath.Glob(filepath.Join(FSAPI_DIRECTORY_DIR, domain, "*.xml"))
+	if err != nil {
+		h.respondError(w, r, fmt.Sprintf("Failed to list users: %v", err), http.StatusInternalServerError)
+		return
+	}
+	rows := []directoryUserView{}
+	for _, file := range files {
+		u, err := loadDirectoryUser(strings.TrimSuffix(filepath.Base(file), ".xml"), domain)
+		if err != nil {
+			logWarn(getRequestID(r), fmt.Sprintf("Skipping unreadable user file %s: %v", file, err))
+			continue
+		}
+		rows = append(rows, u.view())
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].ID < rows[j].ID })
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status":    "success",
+		"row_count": len(rows),
+		"rows":      rows,
+	})
+}
+
+// POST /v1/users
+func (h *APIHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	if !h.directoryEnabled(w, r) {
+		return
+	}
+	var u DirectoryUser
+	if !h.decodeRequest(w, r, &u) {
+		return
+	}
+	if err := u.validate(); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if u.Password == "" {
+		h.respondError(w, r, "password is required", http.StatusBadRequest)
+		return
+	}
+	if !h.validateRequestContext(w, r, u.Domain) {
+		return
+	}
+	if _, err := os.Stat(directoryUserPath(u.ID, u.Domain)); err == nil {
+		h.respondError(w, r, fmt.Sprintf("User %s@%s already exists", u.ID, u.Domain), http.StatusConflict)
+		return
+	}
+
+	if err := h.writeDirectoryUser(&u); err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to create user: %v", err), err)
+		return
+	}
+
+	logInfo(getRequestID(r), fmt.Sprintf("User %s@%s created", u.ID, u.Domain))
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   u.view(),
+	})
+}
+
+// GET /v1/users/{user}
+func (h *APIHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	if !h.directoryEnabled(w, r) {
+		return
+	}
+	id, domain, ok := h.splitUserAddress(w, r, mux.Vars(r)["user"])
+	if !ok {
+		return
+	}
+
+	u, err := loadDirectoryUser(id, domain)
+	if errors.Is(err, os.ErrNotExist) {
+		h.respondError(w, r, fmt.Sprintf("User %s@%s not found", id, domain), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.respondError(w, r, fmt.Sprintf("Failed to read user: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   u.view(),
+	})
+}
+
+// PUT /v1/users/{user}
+//
+// Fields left out keep their current values; variables are replaced as a
+// whole when given.
+func (h *APIHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	if !h.directoryEnabled(w, r) {
+		return
+	}
+	id, domain, ok := h.splitUserAddress(w, r, mux.Vars(r)["user"])
+	if !ok {
+		return
+	}
+
+	u, err := loadDirectoryUser(id, domain)
+	if errors.Is(err, os.ErrNotExist) {
+		h.respondError(w, r, fmt.Sprintf("User %s@%s not found", id, domain), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.respondError(w, r, fmt.Sprintf("Failed to read user: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var req DirectoryUser
+	if !h.decodeRequest(w, r, &req) {
+		return
+	}
+	if (req.ID != "" && req.ID != id) || (req.Domain != "" && req.Domain != domain) {
+		h.respondError(w, r, "id and domain cannot be changed", http.StatusBadRequest)
+		return
+	}
+	for _, f := range []struct{ dst, src *string }{
+		{&u.Password, &req.Password},
+		{&u.VMPassword, &req.VMPassword},
+		{&u.EffectiveCallerIDName, &req.EffectiveCallerIDName},
+		{&u.EffectiveCallerIDNumber, &req.EffectiveCallerIDNumber},
+		{&u.OutboundCallerIDName, &req.OutboundCallerIDName},
+		{&u.OutboundCallerIDNumber, &req.OutboundCallerIDNumber},
+	} {
+		if *f.src != "" {
+			*f.dst = *f.src
+		}
+	}
+	if req.Variables != nil {
+		u.Variables = req.Variables
+	}
+	if err := u.validate(); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.writeDirectoryUser(u); err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to update user: %v", err), err)
+		return
+	}
+
+	logInfo(getRequestID(r), fmt.Sprintf("User %s@%s updated", id, domain))
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   u.view(),
+	})
+}
+
+// DELETE /v1/users/{user}
+func (h *APIHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	if !h.directoryEnabled(w, r) {
+		return
+	}
+	id, domain, ok := h.splitUserAddress(w, r, mux.Vars(r)["user"])
+	if !ok {
+		return
+	}
+
+	err := os.Remove(directoryUserPath(id, domain))
+	if errors.Is(err, os.ErrNotExist) {
+		h.respondError(w, r, fmt.Sprintf("User %s@%s not found", id, domain), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.respondError(w, r, fmt.Sprintf("Failed to delete user: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if _, err := h.eslClient.SendCommand("api reloadxml"); err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("User removed but reloadxml failed: %v", err), err)
+		return
+	}
+
+	h.respondSuccess(w, r, fmt.Sprintf("User %s@%s deleted", id, domain))
+}
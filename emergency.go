@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	// priority of an originate that marks an emergency call
+	priorityEmergency = "emergency"
+
+	// Channel variable marking emergency calls, so the dialplan, CDRs and
+	// hangup webhooks can tell them apart
+	emergencyVar = "fsapi_emergency"
+)
+
+// sipPriorities are the values of the SIP Priority header (RFC 3261)
+var sipPriorities = map[string]bool{
+	"emergency":  true,
+	"urgent":     true,
+	"normal":     true,
+	"non-urgent": true,
+}
+
+// validatePriority checks an originate's priority
+func validatePriority(priority string) error {
+	if priority == "" || sipPriorities[priority] {
+		return nil
+	}
+	names := make([]string, 0, len(sipPriorities))
+	for name := range sipPriorities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("priority must be one of: %s", strings.Join(names, ", "))
+}
+
+// priorityVars returns the originate channel variables for priority as
+// name/value pairs
+func priorityVars(priority string) [][2]string {
+	if priority == "" {
+		return nil
+	}
+	vars := [][2]string{{"sip_h_Priority", priority}}
+	if priority == priorityEmergency {
+		vars = append(vars, [2]string{emergencyVar, "true"})
+	}
+	return vars
+}
+
+// parseEmergencyNumbers parses FSAPI_EMERGENCY_NUMBERS
+func parseEmergencyNumbers(value string) ([]string, error) {
+	numbers := splitCSV(value)
+	for _, number := range numbers {
+		if !dialNumberPattern.MatchString(number) {
+			return nil, fmt.Errorf("bad number %q", number)
+		}
+	}
+	return numbers, nil
+}
+
+// emergencyDestination reports whether an originate only calls emergency
+// numbers: it dials at least one number, through either leg or a channel
+// variable, and every number it dials is on FSAPI_EMERGENCY_NUMBERS
+func (h *APIHandler) emergencyDestination(req *OriginateRequest) bool {
+	numbers := dialedNumbers(req.ALeg)
+	if req.ALegEndpoint != nil && req.ALegEndpoint.Number != "" {
+		numbers = append(numbers, req.ALegEndpoint.Number)
+	}
+	numbers = append(numbers, blegNumbers(req.BLeg)...)
+	numbers = append(numbers, channelVarNumbers(req.ChannelVariables)...)
+	if len(numbers) == 0 {
+		return false
+	}
+	for _, number := range numbers {
+		if !containsString(h.emergencyNumbers, strings.TrimPrefix(number, "+")) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkEmergency rejects priority emergency on a call to anything but an
+// emergency number, since emergency calls skip the caller ID allowlist,
+// business hours, gateway caps and the shutdown pause
+func (h *APIHandler) checkEmergency(w http.ResponseWriter, r *http.Request, req *OriginateRequest) bool {
+	if req.Priority != priorityEmergency || h.emergencyDestination(req) {
+		return true
+	}
+	h.respondError(w, r, "priority emergency is only allowed on calls to the numbers in FSAPI_EMERGENCY_NUMBERS", http.StatusBadRequest)
+	return false
+}
+
+// isEmergencyOriginate reports whether r is an originate with priority
+// emergency to an emergency number, for middleware that runs before the
+// body is decoded
+func (h *APIHandler) isEmergencyOriginate(r *http.Request) bool {
+	if r.Method != http.MethodPost || r.URL.Path != "/v1/calls/originate" {
+		return false
+	}
+	var req OriginateRequest
+	if body := peekRawBody(r); body == nil || json.Unmarshal(body, &req) != nil {
+		return false
+	}
+	return req.Priority == priorityEmergency && h.emergencyDestination(&req)
+}
+
+// reportEmergencyOriginate logs, audits and announces an emergency call
+// fs-api has placed
+func (h *APIHandler) reportEmergencyOriginate(r *http.Request, req *OriginateRequest, response string) {
+	callUUID := ""
+	if fields := strings.Fields(response); len(fields) > 1 {
+		callUUID = fields[1]
+	}
+	logWarn(getRequestID(r), fmt.Sprintf("EMERGENCY call originated: %s (caller %s, context %s)", callUUID, req.CallerIDNumber, req.Context))
+	h.audit(r, AuditEntry{
+		Action:  "call.originate.emergency",
+		Target:  callUUID,
+		Context: req.Context,
+		Details: map[string]string{
+			"aleg":             req.ALeg,
+			"caller_id_number": req.CallerIDNumber,
+		},
+	})
+	h.webhooks.dispatch("call.emergency", req.Context, map[string]interface{}{
+		"uuid":             callUUID,
+		"aleg":             req.ALeg,
+		"context":          req.Context,
+		"caller_id_number": req.CallerIDNumber,
+		"caller_id_name":   req.CallerIDName,
+	})
+}
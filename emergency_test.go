@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestEmergencyDestination(t *testing.T) {
+	h := &APIHandler{emergencyNumbers: []string{"911", "112"}}
+	tests := []struct {
+		name string
+		req  OriginateRequest
+		want bool
+	}{
+		{"gateway endpoint", OriginateRequest{ALegEndpoint: &DialTarget{Type: dialTypeGateway, Gateway: "e911", Number: "911"}}, true},
+		{"dial string", OriginateRequest{ALeg: "sofia/gateway/e911/+112", BLeg: "&park()"}, true},
+		{"phone to emergency extension", OriginateRequest{ALeg: "user/1000", BLeg: "911"}, true},
+		{"no number dialed", OriginateRequest{ALeg: "user/1000", BLeg: "&park()"}, false},
+		{"other number", OriginateRequest{ALeg: "sofia/gateway/carrier/19005551212"}, false},
+		{"emergency extension with a bridge elsewhere", OriginateRequest{ALeg: "sofia/gateway/carrier/19005551212", BLeg: "911"}, false},
+		{"bridge application", OriginateRequest{ALeg: "user/1000", BLeg: "&bridge(sofia/gateway/carrier/19005551212)"}, false},
+		{"channel variable", OriginateRequest{ALeg: "sofia/gateway/e911/911", ChannelVariables: map[string]interface{}{"execute_on_answer": "bridge sofia/gateway/carrier/19005551212"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := h.emergencyDestination(&tt.req); got != tt.want {
+				t.Errorf("emergencyDestination() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
@@ -15,9 +16,46 @@ import (
 // ESL Client Interface
 type ESLClient interface {
 	SendCommand(cmd string) (string, error)
+	Connect() error
 	Close() error
 }
 
+// ErrESLAuthFailed is wrapped into connection errors when FreeSWITCH rejects
+// the configured ESL password. Retrying will not help.
+var ErrESLAuthFailed = errors.New("ESL authentication failed")
+
+// Reconnect backoff bounds. After a failed dial, requests fail fast until
+// the backoff window has elapsed instead of each one attempting its own dial.
+const (
+	eslBackoffMin = 1 * time.Second
+	eslBackoffMax = 30 * time.Second
+)
+
+// ESLUnavailableError is returned when no ESL connection can be obtained.
+// RetryAfter is the time remaining until the next reconnect attempt.
+type ESLUnavailableError struct {
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *ESLUnavailableError) Error() string {
+	return fmt.Sprintf("ESL connection failed: %v", e.Err)
+}
+
+func (e *ESLUnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// isESLUnavailable reports whether err is (or wraps) an ESLUnavailableError
+// and returns it.
+func isESLUnavailable(err error) (*ESLUnavailableError, bool) {
+	var unavailable *ESLUnavailableError
+	if errors.As(err, &unavailable) {
+		return unavailable, true
+	}
+	return nil, false
+}
+
 // ESLgo implementation with connection pooling
 type ESLgoClient struct {
 	host     string
@@ -25,6 +63,23 @@ type ESLgoClient struct {
 	password string
 	mu       sync.Mutex
 	conn     *eslgo.Conn
+
+	// Reconnect backoff state (guarded by mu)
+	dialFailures int
+	lastDialErr  error
+	nextDial     time.Time
+
+	// Status for GET /v1/admin/esl (guarded by mu)
+	connectedAt   time.Time
+	lastSuccess   time.Time
+	lastErr       error
+	lastErrAt     time.Time
+	dials         int // Successful dials; every one after the first is a reconnect
+	totalDialErr  int
+	recentErrs    []eslErrorRecord
+	inFlight      int
+	eventConn     *eslgo.Conn // Event stream connection; nil while it is down
+	eventsStarted bool
 }
 
 func NewESLClient(host, port, password string) ESLClient {
@@ -44,23 +99,89 @@ func (esl *ESLgoClient) getConnection() (*eslgo.Conn, error) {
 		return esl.conn, nil
 	}
 
-	// Create new connection
+	// Still inside the backoff window of a previous failed dial: fail fast
+	if wait := time.Until(esl.nextDial); wait > 0 {
+		return nil, &ESLUnavailableError{RetryAfter: wait, Err: esl.lastDialErr}
+	}
+
+	return esl.dial()
+}
+
+// dial opens and authenticates a new ESL connection. Caller must hold mu.
+func (esl *ESLgoClient) dial() (*eslgo.Conn, error) {
+	// The disconnect callback may fire after a newer connection replaced
+	// this one (forced reconnect), so it only clears its own
+	var conn *eslgo.Conn
 	conn, err := eslgo.Dial(esl.host+":"+esl.port, esl.password, func() {
 		log.Println("ESL connection disconnected")
 		esl.mu.Lock()
-		esl.conn = nil
+		if esl.conn == conn {
+			esl.conn = nil
+		}
 		esl.mu.Unlock()
 	})
 	if err != nil {
-		log.Printf("Failed to connect to ESL: %v", err)
-		return nil, fmt.Errorf("ESL connection failed: %v", err)
+		// eslgo reports a rejected password as "failed to auth ..."
+		if strings.HasPrefix(err.Error(), "failed to auth") {
+			err = fmt.Errorf("%w: check ESL_PASSWORD", ErrESLAuthFailed)
+		}
+		backoff := eslBackoffMin << esl.dialFailures
+		if backoff <= 0 || backoff > eslBackoffMax {
+			backoff = eslBackoffMax
+		}
+		esl.recordError(eslErrorDial, err)
+		esl.totalDialErr++
+		esl.dialFailures++
+		esl.lastDialErr = err
+		esl.nextDial = time.Now().Add(backoff)
+		log.Printf("Failed to connect to ESL: %v (next attempt in %s)", err, backoff)
+		return nil, &ESLUnavailableError{RetryAfter: backoff, Err: err}
 	}
 
+	esl.dialFailures = 0
+	esl.lastDialErr = nil
+	esl.nextDial = time.Time{}
 	esl.conn = conn
+	esl.connectedAt = time.Now()
+	esl.dials++
 	log.Println("New ESL connection established")
 	return conn, nil
 }
 
+// Connect establishes the ESL connection immediately, ignoring any reconnect
+// backoff in effect. It is used for the startup preflight.
+func (esl *ESLgoClient) Connect() error {
+	esl.mu.Lock()
+	defer esl.mu.Unlock()
+
+	if esl.conn != nil {
+		return nil
+	}
+	_, err := esl.dial()
+	return err
+}
+
+// waitForESL blocks until the ESL connection is established or timeout
+// elapses. Authentication failures abort immediately since retrying with the
+// same password cannot succeed.
+func waitForESL(client ESLClient, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := client.Connect()
+		if err == nil {
+			return nil
+		}
+		if errors.Is(err, ErrESLAuthFailed) {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("ESL not reachable after %s: %v", timeout, err)
+		}
+		log.Printf("Waiting for ESL: %v", err)
+		time.Sleep(time.Second)
+	}
+}
+
 func (esl *ESLgoClient) SendCommand(cmd string) (string, error) {
 	log.Printf("ESL Command: %s", cmd)
 
@@ -70,6 +191,15 @@ func (esl *ESLgoClient) SendCommand(cmd string) (string, error) {
 		return "", err
 	}
 
+	esl.mu.Lock()
+	esl.inFlight++
+	esl.mu.Unlock()
+	defer func() {
+		esl.mu.Lock()
+		esl.inFlight--
+		esl.mu.Unlock()
+	}()
+
 	// Parse the command string into command and arguments
 	// Expected format: "api <command> <arguments>"
 	parts := strings.SplitN(cmd, " ", 3)
@@ -98,6 +228,7 @@ func (esl *ESLgoClient) SendCommand(cmd string) (string, error) {
 		log.Printf("Failed to send ESL command: %v", err)
 		// Connection might be broken, clear it
 		esl.mu.Lock()
+		esl.recordError(eslErrorTransport, err)
 		if esl.conn != nil {
 			esl.conn.Close()
 			esl.conn = nil
@@ -112,14 +243,30 @@ func (esl *ESLgoClient) SendCommand(cmd string) (string, error) {
 
 	log.Printf("ESL Response: %s", responseText)
 
+	// The round trip worked, whatever FreeSWITCH replied
+	esl.mu.Lock()
+	esl.lastSuccess = time.Now()
+	esl.mu.Unlock()
+
 	// Check if command was successful
 	if strings.HasPrefix(responseText, "-ERR") {
-		return responseText, fmt.Errorf("ESL error: %s", responseText)
+		err := &ESLCommandError{Reply: responseText}
+		esl.mu.Lock()
+		esl.recordError(eslErrorCommand, err)
+		esl.mu.Unlock()
+		return responseText, err
 	}
 
-	// For commands like 'status', the data is in the body, not Reply-Text
+	// For commands like 'status', the data is in the body, not Reply-Text.
+	// api commands report failures as an -ERR body rather than Reply-Text.
 	if responseBody != "" {
-		return responseBody, nil
+		err := checkESLReply(responseBody)
+		if err != nil {
+			esl.mu.Lock()
+			esl.recordError(eslErrorCommand, err)
+			esl.mu.Unlock()
+		}
+		return responseBody, err
 	}
 
 	return responseText, nil
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/percipia/eslgo"
+)
+
+// Kinds of ESL errors counted for GET /v1/admin/esl
+const (
+	eslErrorDial      = "dial"      // Connecting or authenticating failed
+	eslErrorTransport = "transport" // A command got no reply; the connection was dropped
+	eslErrorCommand   = "command"   // FreeSWITCH replied -ERR
+)
+
+// Errors older than this are dropped from the recent error counts
+const eslErrorWindow = 5 * time.Minute
+
+type eslErrorRecord struct {
+	at   time.Time
+	kind string
+}
+
+// ESLNodeStatus describes the ESL connections to one FreeSWITCH
+type ESLNodeStatus struct {
+	Node              string         `json:"node"`  // host:port
+	State             string         `json:"state"` // connected, disconnected or backoff
+	ConnectedSince    *time.Time     `json:"connected_since,omitempty"`
+	LastSuccessAt     *time.Time     `json:"last_success_at,omitempty"` // Last command that got a reply
+	LastError         string         `json:"last_error,omitempty"`
+	LastErrorAt       *time.Time     `json:"last_error_at,omitempty"`
+	Reconnects        int            `json:"reconnects"`          // Successful dials after the first
+	DialFailures      int            `json:"dial_failures"`       // Since the process started
+	RetryAfterSec     int            `json:"retry_after_sec"`     // Until the next dial is allowed; 0 outside backoff
+	InFlight          int            `json:"in_flight"`           // Commands waiting for a reply
+	PoolSize          int            `json:"pool_size"`           // Command connections (fs-api keeps one)
+	EventStream       string         `json:"event_stream"`        // connected, disconnected or disabled
+	RecentErrors      map[string]int `json:"recent_errors"`       // dial, transport and command errors
+	RecentErrorWindow int            `json:"recent_error_window"` // Seconds covered by recent_errors
+}
+
+// ESLAdmin is implemented by ESL clients that can report their connection
+// state and be forced to reconnect
+type ESLAdmin interface {
+	ESLStatus() ESLNodeStatus
+	Reconnect() error
+}
+
+// recordError notes a failure for the status endpoint. Caller must hold mu.
+func (esl *ESLgoClient) recordError(kind string, err error) {
+	now := time.Now()
+	if kind != eslErrorCommand {
+		esl.lastErr = err
+		esl.lastErrAt = now
+	}
+	cutoff := now.Add(-eslErrorWindow)
+	kept := esl.recentErrs[:0]
+	for _, e := range esl.recentErrs {
+		if e.at.After(cutoff) {
+			kept = append(kept, e)
+		}
+	}
+	esl.recentErrs = append(kept, eslErrorRecord{at: now, kind: kind})
+}
+
+// setEventConn records the event stream connection, nil while it is down
+func (esl *ESLgoClient) setEventConn(conn *eslgo.Conn) {
+	esl.mu.Lock()
+	defer esl.mu.Unlock()
+	esl.eventConn = conn
+}
+
+// ESLStatus reports the state of the command and event connections
+func (esl *ESLgoClient) ESLStatus() ESLNodeStatus {
+	esl.mu.Lock()
+	defer esl.mu.Unlock()
+
+	now := time.Now()
+	status := ESLNodeStatus{
+		Node:              esl.host + ":" + esl.port,
+		State:             "disconnected",
+		Reconnects:        max(esl.dials-1, 0),
+		DialFailures:      esl.totalDialErr,
+		InFlight:          esl.inFlight,
+		PoolSize:          1,
+		EventStream:       "disabled",
+		RecentErrors:      map[string]int{eslErrorDial: 0, eslErrorTransport: 0, eslErrorCommand: 0},
+		RecentErrorWindow: int(eslErrorWindow.Seconds()),
+	}
+	if esl.conn != nil {
+		status.State = "connected"
+		status.ConnectedSince = timePtr(esl.connectedAt)
+	} else if wait := esl.nextDial.Sub(now); wait > 0 {
+		status.State = "backoff"
+		status.RetryAfterSec = int(wait.Round(time.Second).Seconds())
+	}
+	if !esl.lastSuccess.IsZero() {
+		status.LastSuccessAt = timePtr(esl.lastSuccess)
+	}
+	if esl.lastErr != nil {
+		status.LastError = esl.lastErr.Error()
+		status.LastErrorAt = timePtr(esl.lastErrAt)
+	}
+	if esl.eventsStarted {
+		status.EventStream = "disconnected"
+		if esl.eventConn != nil {
+			status.EventStream = "connected"
+		}
+	}
+	cutoff := now.Add(-eslErrorWindow)
+	for _, e := range esl.recentErrs {
+		if e.at.After(cutoff) {
+			status.RecentErrors[e.kind]++
+		}
+	}
+	return status
+}
+
+// Reconnect drops the command connection and dials a new one at once,
+// ignoring any backoff. The event stream is dropped too and reconnects on its
+// own. Commands in flight on the old connection fail.
+func (esl *ESLgoClient) Reconnect() error {
+	esl.mu.Lock()
+	defer esl.mu.Unlock()
+
+	if esl.conn != nil {
+		esl.conn.Close()
+		esl.conn = nil
+	}
+	if esl.eventConn != nil {
+		esl.eventConn.Close()
+	}
+	esl.dialFailures = 0
+	esl.nextDial = time.Time{}
+	_, err := esl.dial()
+	return err
+}
+
+func timePtr(t time.Time) *time.Time {
+	t = t.UTC()
+	return &t
+}
+
+// eslAdmin returns the ESL client's admin interface, responding 501 when the
+// client has none
+func (h *APIHandler) eslAdmin(w http.ResponseWriter, r *http.Request) (ESLAdmin, bool) {
+	if h.eslAdminClient == nil {
+		h.respondError(w, r, "The ESL client does not report its status", http.StatusNotImplemented)
+		return nil, false
+	}
+	return h.eslAdminClient, true
+}
+
+// GET /v1/admin/esl
+func (h *APIHandler) GetESLStatus(w http.ResponseWriter, r *http.Request) {
+	if !h.requireUnrestricted(w, r) {
+		return
+	}
+	admin, ok := h.eslAdmin(w, r)
+	if !ok {
+		return
+	}
+	nodes := []ESLNodeStatus{admin.ESLStatus()}
+	h.respondJSON(w, r, map[string]interface{}{
+		"status":    "success",
+		"row_count": len(nodes),
+		"rows":      nodes,
+	})
+}
+
+// POST /v1/admin/esl/reconnect
+func (h *APIHandler) ReconnectESL(w http.ResponseWriter, r *http.Request) {
+	if !h.requireUnrestricted(w, r) {
+		return
+	}
+	admin, ok := h.eslAdmin(w, r)
+	if !ok {
+		return
+	}
+	err := admin.Reconnect()
+	entry := AuditEntry{Action: "esl.reconnect", Target: admin.ESLStatus().Node}
+	if err != nil {
+		entry.Reason = err.Error()
+	}
+	h.audit(r, entry)
+	if err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("ESL reconnect failed: %v", err), err)
+		return
+	}
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   admin.ESLStatus(),
+	})
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// ESLCommandError is returned when FreeSWITCH answers a command with -ERR.
+type ESLCommandError struct {
+	Reply string
+}
+
+func (e *ESLCommandError) Error() string {
+	return "ESL error: " + e.Reply
+}
+
+// checkESLReply returns an ESLCommandError if an api reply body is an -ERR
+func checkESLReply(body string) error {
+	trimmed := strings.TrimSpace(body)
+	if strings.HasPrefix(trimmed, "-ERR") {
+		return &ESLCommandError{Reply: trimmed}
+	}
+	return nil
+}
+
+// Machine-readable codes for classified ESL command errors
+const (
+	ErrCodeNotFound           = "not_found"
+	ErrCodeInvalidArgument    = "invalid_argument"
+	ErrCodeCommandUnavailable = "command_unavailable"
+	ErrCodeESLError           = "esl_error"
+)
+
+// -ERR reply fragments (lowercased) and what they mean. Checked in order:
+// "command not found" means the API command itself is missing (module not
+// loaded), which must not be confused with a missing channel.
+var eslErrorPatterns = []struct {
+	fragment string
+	status   int
+	code     string
+}{
+	{"command not found", http.StatusBadGateway, ErrCodeCommandUnavailable},
+	{"no such channel", http.StatusNotFound, ErrCodeNotFound},
+	{"not found", http.StatusNotFound, ErrCodeNotFound},
+	{"does not exist", http.StatusNotFound, ErrCodeNotFound},
+	{"invalid agent", http.StatusNotFound, ErrCodeNotFound},
+	{"invalid queue", http.StatusNotFound, ErrCodeNotFound},
+	{"invalid arg", http.StatusBadRequest, ErrCodeInvalidArgument},
+	{"invalid option", http.StatusBadRequest, ErrCodeInvalidArgument},
+	{"invalid", http.StatusBadRequest, ErrCodeInvalidArgument},
+	{"usage:", http.StatusBadRequest, ErrCodeInvalidArgument},
+	{"syntax", http.StatusBadRequest, ErrCodeInvalidArgument},
+	{"missing", http.StatusBadRequest, ErrCodeInvalidArgument},
+}
+
+// classifyESLError maps an ESL error to an HTTP status and error code.
+// Connection failures are handled separately by respondESLError.
+func classifyESLError(err error) (int, string) {
+	var cmdErr *ESLCommandError
+	if !errors.As(err, &cmdErr) {
+		if strings.Contains(err.Error(), "-ERR") {
+			cmdErr = &ESLCommandError{Reply: err.Error()}
+		} else {
+			return http.StatusInternalServerError, ""
+		}
+	}
+
+	reply := strings.ToLower(cmdErr.Reply)
+	for _, p := range eslErrorPatterns {
+		if strings.Contains(reply, p.fragment) {
+			return p.status, p.code
+		}
+	}
+	return http.StatusBadGateway, ErrCodeESLError
+}
+
+// isESLNotFound reports whether err is an -ERR reply meaning the target
+// (channel, queue, agent) does not exist
+func isESLNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+	_, code := classifyESLError(err)
+	return code == ErrCodeNotFound
+}
+
+// originateCause describes how a FreeSWITCH hangup cause returned by a failed
+// originate is surfaced to clients
+type originateCause struct {
+	Status int    // HTTP status code
+	Code   string // machine-readable error code
+}
+
+// Originate failure causes grouped so clients can branch on busy vs
+// unreachable vs bad request without parsing the raw -ERR string
+var originateCauses = map[string]originateCause{
+	// Callee busy or declined
+	"USER_BUSY":     {http.StatusConflict, "callee_busy"},
+	"CALL_REJECTED": {http.StatusConflict, "call_rejected"},
+
+	// Nobody answered in time
+	"NO_ANSWER":                {http.StatusGatewayTimeout, "no_answer"},
+	"NO_USER_RESPONSE":         {http.StatusGatewayTimeout, "no_answer"},
+	"ALLOTTED_TIMEOUT":         {http.StatusGatewayTimeout, "no_answer"},
+	"RECOVERY_ON_TIMER_EXPIRE": {http.StatusGatewayTimeout, "no_answer"},
+	"PROGRESS_TIMEOUT":         {http.StatusGatewayTimeout, "no_answer"},
+
+	// Destination does not exist or is not reachable by that name
+	"USER_NOT_REGISTERED":  {http.StatusNotFound, "destination_not_found"},
+	"SUBSCRIBER_ABSENT":    {http.StatusNotFound, "destination_not_found"},
+	"UNALLOCATED_NUMBER":   {http.StatusNotFound, "destination_not_found"},
+	"NO_ROUTE_DESTINATION": {http.StatusNotFound, "destination_not_found"},
+	"NO_ROUTE_TRANSIT_NET": {http.StatusNotFound, "destination_not_found"},
+	"NUMBER_CHANGED":       {http.StatusNotFound, "destination_not_found"},
+
+	// Destination or network temporarily unavailable
+	"DESTINATION_OUT_OF_ORDER":  {http.StatusServiceUnavailable, "destination_unreachable"},
+	"NETWORK_OUT_OF_ORDER":      {http.StatusServiceUnavailable, "destination_unreachable"},
+	"NORMAL_TEMPORARY_FAILURE":  {http.StatusServiceUnavailable, "destination_unreachable"},
+	"SWITCH_CONGESTION":         {http.StatusServiceUnavailable, "congestion"},
+	"NORMAL_CIRCUIT_CONGESTION": {http.StatusServiceUnavailable, "congestion"},
+	"GATEWAY_DOWN":              {http.StatusServiceUnavailable, "gateway_down"},
+	"SERVICE_UNAVAILABLE":       {http.StatusServiceUnavailable, "destination_unreachable"},
+
+	// The request itself could not be dialed
+	"INVALID_GATEWAY":          {http.StatusBadRequest, "invalid_destination"},
+	"INVALID_NUMBER_FORMAT":    {http.StatusBadRequest, "invalid_destination"},
+	"CHAN_NOT_IMPLEMENTED":     {http.StatusBadRequest, "invalid_destination"},
+	"INCOMPATIBLE_DESTINATION": {http.StatusBadRequest, "invalid_destination"},
+	"MANDATORY_IE_MISSING":     {http.StatusBadRequest, "invalid_destination"},
+}
+
+var hangupCausePattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]+$`)
+
+// parseESLErrCause extracts the hangup cause from an "-ERR <CAUSE>" reply.
+// Returns "" if the reply is not an -ERR or carries free text instead of a
+// cause name.
+func parseESLErrCause(reply string) string {
+	reply = strings.TrimSpace(reply)
+	if !strings.HasPrefix(reply, "-ERR") {
+		return ""
+	}
+	fields := strings.Fields(strings.TrimPrefix(reply, "-ERR"))
+	if len(fields) == 0 || !hangupCausePattern.MatchString(fields[0]) {
+		return ""
+	}
+	return fields[0]
+}
+
+// classifyOriginateCause maps a hangup cause to an HTTP status and code.
+// Unknown causes are reported as 502 with code "originate_failed".
+func classifyOriginateCause(cause string) originateCause {
+	if c, ok := originateCauses[cause]; ok {
+		return c
+	}
+	return originateCause{http.StatusBadGateway, "originate_failed"}
+}
@@ -0,0 +1,1177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MockESLClient is an in-memory ESLClient that simulates a FreeSWITCH switch.
+// Originate creates channels with fake UUIDs, hangup removes them, and the
+// show/uuid_dump/callcenter_config commands reflect the simulated state. It is
+// selected with FSAPI_MODE=mock so integration tests and UI development can
+// run without a FreeSWITCH instance.
+type MockESLClient struct {
+	mu       sync.Mutex
+	channels map[string]*mockChannel
+	queues   map[string]bool
+	agents   map[string]map[string]string
+	tiers    map[string]map[string]string // keyed by queue|agent
+	profiles map[string]bool              // sofia profile -> running
+	gateways map[string]string            // sofia gateway -> ping status
+	started  time.Time
+	memberID int // Last conference member id handed out
+
+	// Synthetic events, queued while mu is held and published after the
+	// command completes so subscribers may issue commands of their own
+	emit    func(*Event)
+	pending []*Event
+}
+
+type mockChannel struct {
+	UUID        string
+	Name        string
+	Direction   string
+	Dest        string
+	Context     string
+	AccountCode string
+	CIDName     string
+	CIDNum      string
+	State       string
+	CallState   string
+	BridgedTo   string
+	IsBLeg      bool
+	Created     time.Time
+	Answered    time.Time
+	Vars        map[string]string
+}
+
+func NewMockESLClient() *MockESLClient {
+	return &MockESLClient{
+		channels: make(map[string]*mockChannel),
+		queues:   make(map[string]bool),
+		agents:   make(map[string]map[string]string),
+		tiers:    make(map[string]map[string]string),
+		profiles: map[string]bool{"internal": true, "external": true},
+		gateways: map[string]string{"carrier1": "UP", "carrier2": "UP"},
+		started:  time.Now(),
+	}
+}
+
+func (m *MockESLClient) Connect() error {
+	return nil
+}
+
+func (m *MockESLClient) Close() error {
+	return nil
+}
+
+// ESLStatus reports the simulator as a permanently connected node
+func (m *MockESLClient) ESLStatus() ESLNodeStatus {
+	return ESLNodeStatus{
+		Node:              "mock",
+		State:             "connected",
+		ConnectedSince:    timePtr(m.started),
+		PoolSize:          1,
+		EventStream:       "connected",
+		RecentErrors:      map[string]int{eslErrorDial: 0, eslErrorTransport: 0, eslErrorCommand: 0},
+		RecentErrorWindow: int(eslErrorWindow.Seconds()),
+	}
+}
+
+func (m *MockESLClient) Reconnect() error {
+	return nil
+}
+
+// mockErr builds an -ERR reply the way FreeSWITCH returns it in an
+// api/response body.
+func mockErr(text string) (string, error) {
+	return "-ERR " + text + "\n", nil
+}
+
+// StreamEvents publishes the simulated channel events (create, answer,
+// bridge, hold, hangup) generated by commands.
+func (m *MockESLClient) StreamEvents(stop <-chan struct{}, publish func(*Event)) {
+	m.mu.Lock()
+	m.emit = publish
+	m.mu.Unlock()
+
+	go func() {
+		<-stop
+		m.mu.Lock()
+		m.emit = nil
+		m.mu.Unlock()
+	}()
+}
+
+// StreamLogs emits a synthetic status line every second
+func (m *MockESLClient) StreamLogs(ctx context.Context, level string, deliver func(*LogLine)) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		m.mu.Lock()
+		count := len(m.channels)
+		m.mu.Unlock()
+		lines := []*LogLine{{Level: "info", File: "esl_mock.go", Function: "StreamLogs", Text: fmt.Sprintf("mock: %d active channel(s)", count)}}
+		if level == "debug" {
+			lines = append(lines, &LogLine{Level: "debug", File: "esl_mock.go", Function: "StreamLogs", Text: "mock: heartbeat"})
+		}
+		for _, line := range lines {
+			if logLevelIncluded(line.Level, level) {
+				line.Time = time.Now().UTC()
+				deliver(line)
+			}
+		}
+	}
+}
+
+// channelEvent queues a channel event carrying the usual Caller-* and
+// variable_* headers. Caller must hold mu.
+func (m *MockESLClient) channelEvent(name string, ch *mockChannel, extra map[string]string) {
+	if m.emit == nil {
+		return
+	}
+	headers := map[string]string{
+		"Unique-ID":                   ch.UUID,
+		"Channel-Name":                ch.Name,
+		"Channel-State":               ch.State,
+		"Channel-Call-State":          ch.CallState,
+		"Call-Direction":              ch.Direction,
+		"Caller-Context":              ch.Context,
+		"Caller-Destination-Number":   ch.Dest,
+		"Caller-Caller-ID-Name":       ch.CIDName,
+		"Caller-Caller-ID-Number":     ch.CIDNum,
+		"Caller-Channel-Created-Time": strconv.FormatInt(ch.Created.UnixMicro(), 10),
+		"variable_accountcode":        ch.AccountCode,
+	}
+	if !ch.Answered.IsZero() {
+		headers["Caller-Channel-Answered-Time"] = strconv.FormatInt(ch.Answered.UnixMicro(), 10)
+	}
+	if ch.BridgedTo != "" {
+		headers["Other-Leg-Unique-ID"] = ch.BridgedTo
+	}
+	for k, v := range ch.Vars {
+		headers["variable_"+k] = v
+	}
+	for k, v := range extra {
+		headers[k] = v
+	}
+	m.pending = append(m.pending, newEvent(name, headers))
+}
+
+// mockConferenceName returns the conference ch was originated into, or ""
+func mockConferenceName(ch *mockChannel) string {
+	room, ok := strings.CutPrefix(ch.Dest, "&conference(")
+	if !ok {
+		return ""
+	}
+	room, _, _ = strings.Cut(strings.TrimSuffix(room, ")"), "@")
+	return room
+}
+
+// conferenceEvent queues a conference::maintain member event for ch.
+// Caller must hold mu.
+func (m *MockESLClient) conferenceEvent(action, name string, ch *mockChannel) {
+	m.channelEvent("CUSTOM", ch, map[string]string{
+		"Event-Subclass":  "conference::maintain",
+		"Action":          action,
+		"Conference-Name": name,
+		"Member-ID":       ch.Vars["conference_member_id"],
+		"Member-Type":     "member",
+		"Hear":            "true",
+		"Speak":           "true",
+		"Talking":         "false",
+	})
+}
+
+// hangupEvent queues CHANNEL_HANGUP_COMPLETE with duration/billsec.
+// Caller must hold mu.
+func (m *MockESLClient) hangupEvent(ch *mockChannel, cause string) {
+	if name := mockConferenceName(ch); name != "" {
+		m.conferenceEvent("del-member", name, ch)
+	}
+	now := time.Now()
+	billsec := 0
+	if !ch.Answered.IsZero() {
+		billsec = int(now.Sub(ch.Answered).Seconds())
+	}
+	m.channelEvent("CHANNEL_HANGUP_COMPLETE", ch, map[string]string{
+		"Hangup-Cause":               cause,
+		"Caller-Channel-Hangup-Time": strconv.FormatInt(now.UnixMicro(), 10),
+		"variable_hangup_cause":      cause,
+		"variable_duration":          strconv.Itoa(int(now.Sub(ch.Created).Seconds())),
+		"variable_billsec":           strconv.Itoa(billsec),
+	})
+}
+
+func (m *MockESLClient) SendCommand(cmd string) (string, error) {
+	response, err := m.execute(cmd)
+
+	m.mu.Lock()
+	events, emit := m.pending, m.emit
+	m.pending = nil
+	m.mu.Unlock()
+	if emit != nil {
+		for _, ev := range events {
+			emit(ev)
+		}
+	}
+
+	if err != nil {
+		return response, err
+	}
+	return response, checkESLReply(response)
+}
+
+// execute runs a command against the simulated state and returns the raw
+// reply body
+func (m *MockESLClient) execute(cmd string) (string, error) {
+	parts := strings.SplitN(cmd, " ", 3)
+	if len(parts) < 2 || parts[0] != "api" {
+		return "", fmt.Errorf("invalid command format: %s", cmd)
+	}
+	apiCmd := parts[1]
+	args := ""
+	if len(parts) > 2 {
+		args = parts[2]
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch apiCmd {
+	case "status":
+		return m.statusText(), nil
+	case "json":
+		return m.jsonCommand(args)
+	case "show":
+		return m.show(args)
+	case "originate":
+		return m.originate(args)
+	case "uuid_dump":
+		return m.uuidDump(args)
+	case "uuid_kill":
+		return m.uuidKill(args)
+	case "uuid_answer":
+		return m.withChannel(args, func(ch *mockChannel, _ []string) {
+			ch.State = "CS_EXECUTE"
+			ch.CallState = "ACTIVE"
+			if ch.Answered.IsZero() {
+				ch.Answered = time.Now()
+				m.channelEvent("CHANNEL_ANSWER", ch, nil)
+			}
+		})
+	case "uuid_hold":
+		off := strings.HasPrefix(args, "off ")
+		return m.withChannel(strings.TrimPrefix(args, "off "), func(ch *mockChannel, _ []string) {
+			if off {
+				ch.CallState = "ACTIVE"
+				m.channelEvent("CHANNEL_UNHOLD", ch, nil)
+			} else {
+				ch.CallState = "HELD"
+				m.channelEvent("CHANNEL_HOLD", ch, nil)
+			}
+		})
+	case "uuid_park":
+		return m.withChannel(args, func(ch *mockChannel, _ []string) {
+			m.unbridge(ch)
+			ch.State = "CS_PARK"
+			m.channelEvent("CHANNEL_PARK", ch, nil)
+		})
+	case "uuid_transfer":
+		return m.withChannel(args, func(ch *mockChannel, rest []string) {
+			if len(rest) > 0 && (rest[0] == "-bleg" || rest[0] == "-both") {
+				rest = rest[1:]
+			}
+			// An inline destination is quoted and may contain spaces
+			if len(rest) > 1 && rest[len(rest)-1] == dialplanInline {
+				ch.Dest = strings.Trim(strings.Join(rest[:len(rest)-1], " "), "'")
+				rest = nil
+			}
+			if len(rest) > 0 {
+				ch.Dest = rest[0]
+			}
+			if len(rest) > 2 {
+				ch.Context = rest[2]
+			}
+			m.unbridge(ch)
+			m.unpark(ch)
+			ch.State = "CS_EXECUTE"
+			m.inlineBridge(ch)
+		})
+	case "uuid_broadcast":
+		// The file plays, or the app::args application runs, to its end at once
+		return m.withChannel(args, func(ch *mockChannel, rest []string) {
+			if len(rest) == 0 {
+				return
+			}
+			if app, data, ok := strings.Cut(rest[0], "::"); ok {
+				m.channelEvent("CHANNEL_EXECUTE_COMPLETE", ch, map[string]string{"Application": app, "Application-Data": data})
+				return
+			}
+			playback := map[string]string{"Playback-File-Path": rest[0]}
+			m.channelEvent("PLAYBACK_START", ch, playback)
+			m.channelEvent("PLAYBACK_STOP", ch, playback)
+		})
+	case "uuid_send_dtmf":
+		// The far end presses the digits sent, so DTMF input can be tested
+		return m.withChannel(args, func(ch *mockChannel, rest []string) {
+			if len(rest) == 0 {
+				return
+			}
+			digits, _, _ := strings.Cut(rest[0], "@")
+			for _, d := range digits {
+				if strings.ContainsRune("0123456789*#ABCD", d) {
+					m.channelEvent("DTMF", ch, map[string]string{"DTMF-Digit": string(d)})
+				}
+			}
+		})
+	case "uuid_record", "uuid_break", "uuid_session_heartbeat", "sched_hangup", "uuid_set_media_stats", "uuid_debug_media", "uuid_display":
+		return m.withChannel(args, func(*mockChannel, []string) {})
+	case "uuid_setvar":
+		return m.withChannel(args, func(ch *mockChannel, rest []string) {
+			if len(rest) > 0 {
+				ch.Vars[rest[0]] = strings.Join(rest[1:], " ")
+			}
+		})
+	case "uuid_setvar_multi":
+		return m.withChannel(args, func(ch *mockChannel, rest []string) {
+			for _, kv := range strings.Split(strings.Join(rest, " "), ";") {
+				if k, v, ok := strings.Cut(kv, "="); ok {
+					ch.Vars[k] = v
+				}
+			}
+		})
+	case "uuid_getvar":
+		fields := strings.Fields(args)
+		if len(fields) < 2 {
+			return mockErr("Usage: uuid_getvar <uuid> <var>")
+		}
+		ch, ok := m.channels[fields[0]]
+		if !ok {
+			return mockErr("No such channel!")
+		}
+		if v, ok := ch.Vars[fields[1]]; ok {
+			return v, nil
+		}
+		if fields[1] == "caller_id_number" && ch.CIDNum != "" {
+			return ch.CIDNum, nil
+		}
+		return "_undef_", nil
+	case "uuid_exists":
+		_, ok := m.channels[strings.TrimSpace(args)]
+		return strconv.FormatBool(ok), nil
+	case "uuid_bridge":
+		return m.uuidBridge(args)
+	case "callcenter_config":
+		return m.callcenter(args)
+	case "module_exists":
+		return "true", nil
+	case "chat":
+		return "Sent", nil
+	case "reloadxml":
+		return "+OK [Success]", nil
+	case "xml_locate":
+		// Only callcenter.conf is located, with its loaded queues
+		if args != "configuration configuration name callcenter.conf" {
+			return "can't find anything\n", nil
+		}
+		names := make([]string, 0, len(m.queues))
+		for q := range m.queues {
+			names = append(names, q)
+		}
+		sort.Strings(names)
+		var b strings.Builder
+		b.WriteString("<configuration name=\"callcenter.conf\" description=\"CallCenter\">\n  <queues>\n")
+		for _, q := range names {
+			fmt.Fprintf(&b, "    <queue name=%q>\n      <param name=\"strategy\" value=\"longest-idle-agent\"/>\n      <param name=\"announce-frequency\" value=\"30\"/>\n    </queue>\n", q)
+		}
+		b.WriteString("  </queues>\n</configuration>\n")
+		return b.String(), nil
+	case "sched_api":
+		return "+OK Added: 1", nil
+	case "sched_del":
+		return "+OK Deleted: 1", nil
+	case "sofia_contact":
+		// The mock has no registrations
+		return "error/user_not_registered", nil
+	case "sofia":
+		return m.sofia(args)
+	case "verto":
+		return m.verto(args)
+	case "lcr":
+		return m.lcr(args)
+	case "cidlookup":
+		return m.cidlookup(args)
+	case "valet_info":
+		return m.valetInfo(args), nil
+	case "conference":
+		return m.conference(args)
+	}
+
+	return mockErr(fmt.Sprintf("%s Command not found!", apiCmd))
+}
+
+// lcr answers "lcr <digits> [profile] as xml" with two carriers for numbers
+// starting with 1 (NANP) and no routes otherwise
+func (m *MockESLClient) lcr(args string) (string, error) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return mockErr("Usage: lcr <digits> [<lcr profile>] [caller_id] [intrastate] [as xml]")
+	}
+	digits := fields[0]
+	var b strings.Builder
+	b.WriteString("<result>\n")
+	if strings.HasPrefix(digits, "1") {
+		for i, c := range []struct{ name, rate string }{{"carrier1", "0.00700"}, {"carrier2", "0.01100"}} {
+			fmt.Fprintf(&b, " <row id=\"%d\">\n  <prefix>1</prefix>\n  <carrier_name>%s</carrier_name>\n  <rate>%s</rate>\n  <codec></codec>\n  <cid></cid>\n  <dialstring>[lcr_carrier=%s,lcr_rate=%s]sofia/gateway/%s/%s</dialstring>\n </row>\n",
+				i+1, c.name, c.rate, c.name, c.rate, c.name, digits)
+		}
+	}
+	b.WriteString("</result>\n")
+	return b.String(), nil
+}
+
+// valetInfo answers "valet_info [lot]" from the channels transferred to
+// valet_park:<lot> <slot>
+func (m *MockESLClient) valetInfo(lot string) string {
+	lots := map[string][]*mockChannel{}
+	var names []string
+	for _, ch := range m.channels {
+		spec, ok := strings.CutPrefix(ch.Dest, "valet_park:")
+		if !ok {
+			continue
+		}
+		name, _, _ := strings.Cut(spec, " ")
+		if lot != "" && name != lot {
+			continue
+		}
+		if _, seen := lots[name]; !seen {
+			names = append(names, name)
+		}
+		lots[name] = append(lots[name], ch)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteString("<lots>\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "  <lot name=\"%s\">\n", name)
+		for _, ch := range lots[name] {
+			_, slot, _ := strings.Cut(strings.TrimPrefix(ch.Dest, "valet_park:"), " ")
+			fmt.Fprintf(&b, "    <extension uuid=\"%s\">%s</extension>\n", ch.UUID, slot)
+		}
+		b.WriteString("  </lot>\n")
+	}
+	b.WriteString("</lots>\n")
+	return b.String()
+}
+
+// conference answers "conference <name> list count|json_list|lock|unlock|hupall",
+// recording, vid-layout and vid-floor for the channels originated into
+// &conference(<name>[@profile])
+func (m *MockESLClient) conference(args string) (string, error) {
+	fields := strings.Fields(args)
+	if len(fields) < 2 {
+		return mockErr("Usage: conference <name> list count|lock|unlock|hupall")
+	}
+	name := fields[0]
+	var members []*mockChannel
+	for _, ch := range m.sortedChannels() {
+		if mockConferenceName(ch) == name {
+			members = append(members, ch)
+		}
+	}
+	// A conference exists while it has members
+	if len(members) == 0 {
+		return fmt.Sprintf("Conference %s not found\n", name), nil
+	}
+	switch fields[1] {
+	case "recording":
+		if len(fields) < 4 {
+			return mockErr("Usage: conference <name> recording start|stop|pause|resume <path>")
+		}
+		return fmt.Sprintf("OK %s recording %s\n", fields[2], fields[3]), nil
+	case "vid-layout":
+		if len(fields) < 3 {
+			return mockErr("Usage: conference <name> vid-layout <layout> [canvas]")
+		}
+		return fmt.Sprintf("Change to layout [%s]\n", fields[2]), nil
+	case "vid-floor", "clear-vid-floor":
+		return "OK\n", nil
+	}
+	switch strings.Join(fields[1:], " ") {
+	case "list count":
+		return strconv.Itoa(len(members)), nil
+	case "json_list":
+		type member struct {
+			Type           string          `json:"type"`
+			ID             int             `json:"id"`
+			UUID           string          `json:"uuid"`
+			CallerIDName   string          `json:"caller_id_name"`
+			CallerIDNumber string          `json:"caller_id_number"`
+			JoinTime       int             `json:"join_time"`
+			Flags          map[string]bool `json:"flags"`
+		}
+		list := []member{}
+		for _, ch := range members {
+			id, _ := strconv.Atoi(ch.Vars["conference_member_id"])
+			list = append(list, member{
+				Type: "caller", ID: id, UUID: ch.UUID, CallerIDName: ch.CIDName, CallerIDNumber: ch.CIDNum,
+				JoinTime: int(time.Since(ch.Created).Seconds()),
+				Flags:    map[string]bool{"can_hear": true, "can_speak": true, "talking": false, "is_moderator": false},
+			})
+		}
+		data, _ := json.Marshal([]map[string]interface{}{{"conference_name": name, "member_count": len(list), "members": list}})
+		return string(data), nil
+	case "lock":
+		return fmt.Sprintf("OK %s locked\n", name), nil
+	case "unlock":
+		return fmt.Sprintf("OK %s unlocked\n", name), nil
+	case "hupall":
+		for _, ch := range members {
+			delete(m.channels, ch.UUID)
+			m.hangupEvent(ch, "NORMAL_CLEARING")
+		}
+		if m.emit != nil {
+			m.pending = append(m.pending, newEvent("CUSTOM", map[string]string{
+				"Event-Subclass":  "conference::maintain",
+				"Action":          "conference-destroy",
+				"Conference-Name": name,
+			}))
+		}
+		return "OK\n", nil
+	}
+	return mockErr("Unsupported conference command")
+}
+
+// cidlookup answers "cidlookup <number>" with a fixed name for numbers
+// starting with 1 (NANP) and UNKNOWN otherwise, as mod_cidlookup does on a miss
+func (m *MockESLClient) cidlookup(args string) (string, error) {
+	number := strings.TrimPrefix(strings.TrimSpace(args), "+")
+	if number == "" {
+		return mockErr("Usage: cidlookup status|number [skipurl] [skipcitystate] [verbose]")
+	}
+	if strings.HasPrefix(number, "1") {
+		return "MOCK CALLER", nil
+	}
+	return "UNKNOWN", nil
+}
+
+func (m *MockESLClient) statusText() string {
+	uptime := time.Since(m.started)
+	return fmt.Sprintf("UP 0 years, 0 days, %d hours, %d minutes, %d seconds\nFreeSWITCH (Version mock) is ready\n%d session(s) - peak %d\n",
+		int(uptime.Hours()), int(uptime.Minutes())%60, int(uptime.Seconds())%60, len(m.channels), len(m.channels))
+}
+
+func (m *MockESLClient) jsonCommand(args string) (string, error) {
+	var req struct {
+		Command string `json:"command"`
+	}
+	if err := json.Unmarshal([]byte(args), &req); err != nil || req.Command != "status" {
+		return mockErr("invalid json command")
+	}
+	uptime := time.Since(m.started)
+	resp, _ := json.Marshal(map[string]interface{}{
+		"command": "status",
+		"status":  "success",
+		"response": map[string]interface{}{
+			"systemStatus": "ready",
+			"uptime": map[string]interface{}{
+				"years": 0, "days": int(uptime.Hours()) / 24, "hours": int(uptime.Hours()) % 24,
+				"minutes": int(uptime.Minutes()) % 60, "seconds": int(uptime.Seconds()) % 60,
+			},
+			"version": "mock",
+			"sessions": map[string]interface{}{
+				"count": map[string]interface{}{"total": len(m.channels), "active": len(m.channels)},
+			},
+		},
+	})
+	return string(resp), nil
+}
+
+// sortedChannels returns channels in creation order for stable listings.
+func (m *MockESLClient) sortedChannels() []*mockChannel {
+	list := make([]*mockChannel, 0, len(m.channels))
+	for _, ch := range m.channels {
+		list = append(list, ch)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return list
+}
+
+func (m *MockESLClient) show(args string) (string, error) {
+	what := strings.Fields(args)
+	if len(what) == 0 {
+		return mockErr("show requires an argument")
+	}
+
+	rows := make([]map[string]string, 0)
+	switch what[0] {
+	case "channels":
+		for _, ch := range m.sortedChannels() {
+			rows = append(rows, ch.row(""))
+		}
+	case "calls":
+		for _, ch := range m.sortedChannels() {
+			if ch.IsBLeg {
+				continue
+			}
+			row := ch.row("")
+			if b, ok := m.channels[ch.BridgedTo]; ok {
+				for k, v := range b.row("b_") {
+					row[k] = v
+				}
+			}
+			rows = append(rows, row)
+		}
+	case "registrations":
+	default:
+		return mockErr(fmt.Sprintf("show %s not supported by mock", what[0]))
+	}
+
+	resp, _ := json.Marshal(map[string]interface{}{
+		"row_count": len(rows),
+		"rows":      rows,
+	})
+	return string(resp), nil
+}
+
+func (ch *mockChannel) row(prefix string) map[string]string {
+	row := map[string]string{
+		prefix + "uuid":          ch.UUID,
+		prefix + "direction":     ch.Direction,
+		prefix + "created":       ch.Created.Format("2006-01-02 15:04:05"),
+		prefix + "created_epoch": strconv.FormatInt(ch.Created.Unix(), 10),
+		prefix + "name":          ch.Name,
+		prefix + "state":         ch.State,
+		prefix + "cid_name":      ch.CIDName,
+		prefix + "cid_num":       ch.CIDNum,
+		prefix + "dest":          ch.Dest,
+		prefix + "callstate":     ch.CallState,
+	}
+	if prefix == "" {
+		row["context"] = ch.Context
+		row["accountcode"] = ch.AccountCode
+		row["b_uuid"] = ch.BridgedTo
+		row["presence_id"] = ch.Vars["presence_id"]
+	}
+	return row
+}
+
+// splitVars splits originate variables on the commas outside single
+// quotes, as FreeSWITCH does
+func splitVars(s string) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i, c := range s {
+		switch {
+		case c == '\'':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// originate parses "{vars}aleg bleg [dialplan] [context] [cid_name] [cid_num] [timeout]"
+// and creates a ringing channel for the A-leg.
+func (m *MockESLClient) originate(args string) (string, error) {
+	vars := map[string]string{}
+	if strings.HasPrefix(args, "{") {
+		end := strings.Index(args, "}")
+		if end == -1 {
+			return mockErr("INVALID_VARIABLES")
+		}
+		for _, kv := range splitVars(args[1:end]) {
+			if k, v, ok := strings.Cut(kv, "="); ok {
+				vars[k] = strings.Trim(v, "'")
+			}
+		}
+		args = args[end+1:]
+	}
+
+	fields := strings.Fields(args)
+	if len(fields) < 2 {
+		return mockErr("INVALID_ARGS")
+	}
+
+	id := vars["origination_uuid"]
+	if id == "" {
+		id = uuid.New().String()
+	}
+	ch := &mockChannel{
+		UUID:        id,
+		Name:        fields[0],
+		Direction:   "outbound",
+		Dest:        fields[1],
+		Context:     "default",
+		AccountCode: vars["accountcode"],
+		CIDName:     vars["origination_caller_id_name"],
+		CIDNum:      vars["origination_caller_id_number"],
+		State:       "CS_EXECUTE",
+		CallState:   "ACTIVE",
+		Created:     time.Now(),
+		Vars:        vars,
+	}
+	ch.Answered = ch.Created
+	if len(fields) > 3 {
+		ch.Context = fields[3]
+	}
+	if strings.HasPrefix(ch.Dest, "&park") {
+		ch.State = "CS_PARK"
+	}
+	m.channels[id] = ch
+	m.channelEvent("CHANNEL_CREATE", ch, nil)
+	m.channelEvent("CHANNEL_ANSWER", ch, nil)
+	if name := mockConferenceName(ch); name != "" {
+		m.memberID++
+		ch.Vars["conference_member_id"] = strconv.Itoa(m.memberID)
+		m.conferenceEvent("add-member", name, ch)
+	}
+	// A TTS cache render: the speech recorded is a second of silence
+	if path, ok := strings.CutPrefix(ch.Dest, "&record("); ok && strings.HasPrefix(ch.Name, "loopback/app=speak:") {
+		os.WriteFile(strings.TrimSuffix(path, ")"), encodeWAV(make([]float64, 8000), 8000), 0644)
+		delete(m.channels, id)
+		m.hangupEvent(ch, "NORMAL_CLEARING")
+	}
+	return "+OK " + id, nil
+}
+
+func (m *MockESLClient) uuidDump(args string) (string, error) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return mockErr("Usage: uuid_dump <uuid> [format]")
+	}
+	ch, ok := m.channels[fields[0]]
+	if !ok {
+		return mockErr("No such channel!")
+	}
+	dump := map[string]string{
+		"Unique-ID":                 ch.UUID,
+		"Channel-Name":              ch.Name,
+		"Channel-State":             ch.State,
+		"Channel-Call-State":        ch.CallState,
+		"Call-Direction":            ch.Direction,
+		"Caller-Context":            ch.Context,
+		"Caller-Destination-Number": ch.Dest,
+		"Caller-Caller-ID-Name":     ch.CIDName,
+		"Caller-Caller-ID-Number":   ch.CIDNum,
+		"Other-Leg-Unique-ID":       ch.BridgedTo,
+		"variable_accountcode":      ch.AccountCode,
+		"variable_uuid":             ch.UUID,
+	}
+	for k, v := range ch.Vars {
+		dump["variable_"+k] = v
+	}
+	resp, _ := json.Marshal(dump)
+	return string(resp), nil
+}
+
+func (m *MockESLClient) uuidKill(args string) (string, error) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return mockErr("Usage: uuid_kill <uuid> [cause]")
+	}
+	ch, ok := m.channels[fields[0]]
+	if !ok {
+		return mockErr("No such channel!")
+	}
+	cause := "NORMAL_CLEARING"
+	if len(fields) > 1 {
+		cause = fields[1]
+	}
+	delete(m.channels, ch.UUID)
+	m.hangupEvent(ch, cause)
+	// hangup_after_bridge: the other leg goes away too, unless it is set to
+	// park after the bridge
+	if other, ok := m.channels[ch.BridgedTo]; ok {
+		if other.Vars["park_after_bridge"] == "true" {
+			m.unbridge(other)
+			other.State = "CS_PARK"
+			m.channelEvent("CHANNEL_PARK", other, nil)
+		} else {
+			delete(m.channels, other.UUID)
+			m.hangupEvent(other, cause)
+		}
+	}
+	return "+OK", nil
+}
+
+func (m *MockESLClient) uuidBridge(args string) (string, error) {
+	fields := strings.Fields(args)
+	if len(fields) < 2 {
+		return mockErr("Usage: uuid_bridge <uuid> <other_uuid>")
+	}
+	a, okA := m.channels[fields[0]]
+	b, okB := m.channels[fields[1]]
+	if !okA || !okB {
+		return mockErr("No such channel!")
+	}
+	m.unbridge(a)
+	m.unbridge(b)
+	m.unpark(a)
+	m.unpark(b)
+	a.BridgedTo, b.BridgedTo = b.UUID, a.UUID
+	b.IsBLeg = true
+	a.State, b.State = "CS_EXCHANGE_MEDIA", "CS_EXCHANGE_MEDIA"
+	a.CallState, b.CallState = "ACTIVE", "ACTIVE"
+	m.channelEvent("CHANNEL_BRIDGE", a, nil)
+	return "+OK " + b.UUID, nil
+}
+
+// inlineBridge answers and bridges the endpoint of a bridge application in
+// an inline destination ch was transferred to. Caller must hold mu.
+func (m *MockESLClient) inlineBridge(ch *mockChannel) {
+	for _, app := range strings.Split(ch.Dest, ",") {
+		dial, ok := strings.CutPrefix(app, "bridge:")
+		if !ok {
+			continue
+		}
+		if i := strings.Index(dial, "]"); strings.HasPrefix(dial, "[") && i != -1 {
+			dial = dial[i+1:]
+		}
+		now := time.Now()
+		other := &mockChannel{
+			UUID:      uuid.New().String(),
+			Name:      dial,
+			Direction: "outbound",
+			Dest:      dial,
+			Context:   ch.Context,
+			State:     "CS_EXCHANGE_MEDIA",
+			CallState: "ACTIVE",
+			BridgedTo: ch.UUID,
+			IsBLeg:    true,
+			Created:   now,
+			Answered:  now,
+			Vars:      map[string]string{},
+		}
+		m.channels[other.UUID] = other
+		ch.BridgedTo = other.UUID
+		ch.State = "CS_EXCHANGE_MEDIA"
+		m.channelEvent("CHANNEL_CREATE", other, nil)
+		m.channelEvent("CHANNEL_ANSWER", other, nil)
+		m.channelEvent("CHANNEL_BRIDGE", ch, nil)
+		return
+	}
+}
+
+// unpark announces that a parked channel leaves the park
+func (m *MockESLClient) unpark(ch *mockChannel) {
+	if ch.State == "CS_PARK" {
+		ch.State = "CS_EXECUTE"
+		m.channelEvent("CHANNEL_UNPARK", ch, nil)
+	}
+}
+
+func (m *MockESLClient) unbridge(ch *mockChannel) {
+	if ch.BridgedTo != "" {
+		m.channelEvent("CHANNEL_UNBRIDGE", ch, nil)
+	}
+	if other, ok := m.channels[ch.BridgedTo]; ok {
+		other.BridgedTo = ""
+		other.IsBLeg = false
+	}
+	ch.BridgedTo = ""
+	ch.IsBLeg = false
+}
+
+// withChannel runs fn for the channel named by the first argument, returning
+// -ERR No such channel! when it does not exist.
+func (m *MockESLClient) withChannel(args string, fn func(ch *mockChannel, rest []string)) (string, error) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return mockErr("missing uuid")
+	}
+	ch, ok := m.channels[fields[0]]
+	if !ok {
+		return mockErr("No such channel!")
+	}
+	fn(ch, fields[1:])
+	return "+OK", nil
+}
+
+// sofia simulates "sofia status [profile|gateway <name>]" and "sofia profile <name> <action>"
+func (m *MockESLClient) sofia(args string) (string, error) {
+	f := strings.Fields(args)
+	switch {
+	case len(f) == 1 && f[0] == "status":
+		names := make([]string, 0, len(m.profiles))
+		for name := range m.profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var b strings.Builder
+		b.WriteString("                     Name\t   Type\t                                       Data\tState\n")
+		b.WriteString(strings.Repeat("=", 97) + "\n")
+		running := 0
+		for i, name := range names {
+			if !m.profiles[name] {
+				continue
+			}
+			running++
+			fmt.Fprintf(&b, "%25s\t%7s\t%43s\t%s\n", name, "profile", fmt.Sprintf("sip:mod_sofia@127.0.0.1:%d", 5060+20*i), "RUNNING (0)")
+		}
+		b.WriteString(strings.Repeat("=", 97) + "\n")
+		fmt.Fprintf(&b, "%d profiles 0 aliases\n", running)
+		return b.String(), nil
+	case len(f) == 3 && f[0] == "status" && f[1] == "profile":
+		if !m.profiles[f[2]] {
+			return "Invalid Profile!\n", nil
+		}
+		return fmt.Sprintf("%s\nName             \t%s\nDomain Name      \tN/A\nAuto-NAT         \tfalse\nDBName           \tsofia_reg_%s\nDialplan         \tXML\nContext          \tpublic\nSIP-IP           \t127.0.0.1\nCALLS-IN         \t0\nCALLS-OUT        \t0\n%s\n",
+			strings.Repeat("=", 97), f[2], f[2], strings.Repeat("=", 97)), nil
+	case len(f) == 3 && f[0] == "status" && f[1] == "gateway":
+		status, ok := m.gateways[f[2]]
+		if !ok {
+			return "Invalid Gateway!\n", nil
+		}
+		return fmt.Sprintf("%s\nName    \t%s\nProfile \texternal\nState   \tNOREG\nStatus  \t%s\nCallsIN \t0\nCallsOUT\t0\n%s\n",
+			strings.Repeat("=", 97), f[2], status, strings.Repeat("=", 97)), nil
+	case len(f) == 3 && f[0] == "profile":
+		running, ok := m.profiles[f[1]]
+		if !ok {
+			return "Invalid Profile!\n", nil
+		}
+		switch f[2] {
+		case "start":
+			if running {
+				return "Failure starting " + f[1] + "\n", nil
+			}
+			m.profiles[f[1]] = true
+		case "stop":
+			m.profiles[f[1]] = false
+		case "restart", "rescan":
+		}
+		return "+OK\n", nil
+	case len(f) == 4 && f[0] == "profile" && (f[2] == "siptrace" || f[2] == "capture"):
+		if _, ok := m.profiles[f[1]]; !ok {
+			return "Invalid Profile!\n", nil
+		}
+		return "+OK\n", nil
+	}
+	return mockErr("Usage: sofia status|profile")
+}
+
+// verto simulates "verto status" with one logged-in browser client
+func (m *MockESLClient) verto(args string) (string, error) {
+	if strings.TrimSpace(args) != "status" {
+		return mockErr("Usage: verto status")
+	}
+	var b strings.Builder
+	b.WriteString("                     Name\t   Type\t                                       Data\tState\n")
+	b.WriteString(strings.Repeat("=", 97) + "\n")
+	fmt.Fprintf(&b, "%25s\t%7s\t%43s\t%s\n", "default-v4", "profile", "verto.rtc://127.0.0.1:8081", "RUNNING")
+	fmt.Fprintf(&b, "%25s\t%7s\t%43s\t%s\n", "default-v4", "client", "wss://1000@mock.local@127.0.0.1:50123", "CONN_REG (WSS)")
+	b.WriteString(strings.Repeat("=", 97) + "\n")
+	return b.String(), nil
+}
+
+// callcenter simulates the subset of callcenter_config used by the API.
+func (m *MockESLClient) callcenter(args string) (string, error) {
+	f := strings.Fields(args)
+	if len(f) < 2 {
+		return mockErr("Usage: callcenter_config <object> <action>")
+	}
+
+	switch f[0] + " " + f[1] {
+	case "queue list":
+		if len(f) >= 4 {
+			return m.queueSubList(f[2], f[3])
+		}
+		names := make([]string, 0, len(m.queues))
+		for q := range m.queues {
+			names = append(names, q)
+		}
+		sort.Strings(names)
+		var b strings.Builder
+		b.WriteString("name|strategy|moh_sound|time_base_score|tier_rules_apply|tier_rule_wait_second|tier_rule_no_agent_no_wait|discard_abandoned_after|abandoned_resume_allowed|max_wait_time|max_wait_time_with_no_agent|max_wait_time_with_no_agent_time_reached|record_template|calls_answered|calls_abandoned|ring_progressively_delay\n")
+		for _, q := range names {
+			b.WriteString(q + "|longest-idle-agent|local_stream://moh|system|false|300|true|60|false|0|0|5||0|0|10\n")
+		}
+		b.WriteString("+OK\n")
+		return b.String(), nil
+	case "queue count":
+		if len(f) >= 4 {
+			rows, _ := m.queueSubList(f[2], f[3])
+			return strconv.Itoa(len(ParsePipeDelimited(rows))), nil
+		}
+		return strconv.Itoa(len(m.queues)), nil
+	case "queue load", "queue reload":
+		if len(f) < 3 {
+			return mockErr("Invalid queue")
+		}
+		m.queues[f[2]] = true
+		return "+OK", nil
+	case "queue unload":
+		if len(f) < 3 || !m.queues[f[2]] {
+			return mockErr("Invalid queue not found")
+		}
+		delete(m.queues, f[2])
+		return "+OK", nil
+	case "agent list":
+		return m.agentList(), nil
+	case "agent add":
+		if len(f) < 4 {
+			return mockErr("Invalid Agent")
+		}
+		if _, exists := m.agents[f[2]]; exists {
+			return mockErr("Agent already exists")
+		}
+		m.agents[f[2]] = map[string]string{
+			"name": f[2], "system": "single_box", "uuid": "", "type": f[3], "contact": "",
+			"status": "Logged Out", "state": "Waiting", "max_no_answer": "0", "wrap_up_time": "0",
+			"reject_delay_time": "0", "busy_delay_time": "0", "no_answer_delay_time": "0",
+			"last_bridge_start": "0", "last_bridge_end": "0", "last_offered_call": "0",
+			"last_status_change": strconv.FormatInt(time.Now().Unix(), 10), "no_answer_count": "0",
+			"calls_answered": "0", "talk_time": "0", "ready_time": "0",
+		}
+		return "+OK", nil
+	case "agent del":
+		if len(f) < 3 {
+			return mockErr("Invalid Agent")
+		}
+		if _, ok := m.agents[f[2]]; !ok {
+			return mockErr("Invalid Agent not found")
+		}
+		delete(m.agents, f[2])
+		return "+OK", nil
+	case "agent set":
+		if len(f) < 4 {
+			return mockErr("Invalid Agent")
+		}
+		agent, ok := m.agents[f[3]]
+		if !ok {
+			return mockErr("Invalid Agent not found")
+		}
+		agent[f[2]] = strings.Trim(strings.Join(f[4:], " "), "'")
+		if f[2] == "status" {
+			agent["last_status_change"] = strconv.FormatInt(time.Now().Unix(), 10)
+		}
+		return "+OK", nil
+	case "tier list":
+		return m.tierList(""), nil
+	case "tier add":
+		if len(f) < 4 {
+			return mockErr("Invalid tier")
+		}
+		level, position := "1", "1"
+		if len(f) > 4 {
+			level = f[4]
+		}
+		if len(f) > 5 {
+			position = f[5]
+		}
+		m.tiers[f[2]+"|"+f[3]] = map[string]string{
+			"queue": f[2], "agent": f[3], "state": "Ready", "level": level, "position": position,
+		}
+		return "+OK", nil
+	case "tier del":
+		if len(f) < 4 {
+			return mockErr("Invalid tier")
+		}
+		if _, ok := m.tiers[f[2]+"|"+f[3]]; !ok {
+			return mockErr("Invalid tier not found")
+		}
+		delete(m.tiers, f[2]+"|"+f[3])
+		return "+OK", nil
+	case "tier set":
+		if len(f) < 5 {
+			return mockErr("Invalid tier")
+		}
+		tier, ok := m.tiers[f[3]+"|"+f[4]]
+		if !ok {
+			return mockErr("Invalid tier not found")
+		}
+		tier[f[2]] = strings.Trim(strings.Join(f[5:], " "), "'")
+		return "+OK", nil
+	}
+
+	return mockErr(fmt.Sprintf("Unknown callcenter_config command: %s", args))
+}
+
+func (m *MockESLClient) queueSubList(kind, queue string) (string, error) {
+	if !m.queues[queue] {
+		return mockErr("Invalid queue not found")
+	}
+	switch kind {
+	case "agents":
+		var names []string
+		for _, t := range m.tiers {
+			if t["queue"] == queue {
+				names = append(names, t["agent"])
+			}
+		}
+		return m.agentList(names...), nil
+	case "tiers":
+		return m.tierList(queue), nil
+	case "members":
+		return "queue|instance_id|uuid|session_uuid|cid_number|cid_name|system_epoch|joined_epoch|rejoined_epoch|bridge_epoch|abandoned_epoch|base_score|skill_score|serving_agent|serving_system|state|score\n+OK\n", nil
+	}
+	return mockErr("Invalid list type")
+}
+
+var mockAgentFields = []string{"name", "system", "uuid", "type", "contact", "status", "state", "max_no_answer", "wrap_up_time", "reject_delay_time", "busy_delay_time", "no_answer_delay_time", "last_bridge_start", "last_bridge_end", "last_offered_call", "last_status_change", "no_answer_count", "calls_answered", "talk_time", "ready_time"}
+
+// agentList renders agents in callcenter_config pipe format, optionally
+// limited to the given names.
+func (m *MockESLClient) agentList(only ...string) string {
+	var names []string
+	if len(only) > 0 {
+		names = only
+	} else {
+		for name := range m.agents {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(strings.Join(mockAgentFields, "|") + "\n")
+	for _, name := range names {
+		agent, ok := m.agents[name]
+		if !ok {
+			continue
+		}
+		values := make([]string, len(mockAgentFields))
+		for i, field := range mockAgentFields {
+			values[i] = agent[field]
+		}
+		b.WriteString(strings.Join(values, "|") + "\n")
+	}
+	b.WriteString("+OK\n")
+	return b.String()
+}
+
+func (m *MockESLClient) tierList(queue string) string {
+	keys := make([]string, 0, len(m.tiers))
+	for k, t := range m.tiers {
+		if queue == "" || t["queue"] == queue {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString("queue|agent|state|level|position\n")
+	for _, k := range keys {
+		t := m.tiers[k]
+		b.WriteString(strings.Join([]string{t["queue"], t["agent"], t["state"], t["level"], t["position"]}, "|") + "\n")
+	}
+	b.WriteString("+OK\n")
+	return b.String()
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/textproto"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/percipia/eslgo"
+	"github.com/percipia/eslgo/command"
+)
+
+// Event is a FreeSWITCH event as seen by fs-api subsystems. Header names are
+// stored in canonical MIME form; use Get for lookups.
+type Event struct {
+	Seq     uint64            `json:"seq"`
+	Name    string            `json:"event"`
+	Time    time.Time         `json:"timestamp"`
+	Headers map[string]string `json:"headers"`
+}
+
+func newEvent(name string, headers map[string]string) *Event {
+	ev := &Event{
+		Name:    name,
+		Time:    time.Now().UTC(),
+		Headers: make(map[string]string, len(headers)+1),
+	}
+	for k, v := range headers {
+		ev.Headers[textproto.CanonicalMIMEHeaderKey(k)] = v
+	}
+	ev.Headers["Event-Name"] = name
+	return ev
+}
+
+// Get returns a header value (case-insensitive)
+func (e *Event) Get(header string) string {
+	return e.Headers[textproto.CanonicalMIMEHeaderKey(header)]
+}
+
+// Var returns a channel variable carried by the event
+func (e *Event) Var(name string) string {
+	return e.Get("variable_" + name)
+}
+
+// UUID returns the channel UUID the event refers to, if any
+func (e *Event) UUID() string {
+	return e.Get("Unique-ID")
+}
+
+// Context returns the tenant context of the event's channel: the accountcode
+// if set, otherwise the caller's dialplan context
+func (e *Event) Context() string {
+	if ctx := e.Var("accountcode"); ctx != "" {
+		return ctx
+	}
+	return e.Get("Caller-Context")
+}
+
+// EventTime parses a FreeSWITCH microsecond epoch header (e.g.
+// Caller-Channel-Answered-Time). Zero or missing values yield the zero time.
+func (e *Event) EventTime(header string) time.Time {
+	usec, err := strconv.ParseInt(e.Get(header), 10, 64)
+	if err != nil || usec <= 0 {
+		return time.Time{}
+	}
+	return time.UnixMicro(usec).UTC()
+}
+
+// EventSource is implemented by ESL clients that can stream FreeSWITCH
+// events. StreamEvents delivers events to publish until stop is closed.
+type EventSource interface {
+	StreamEvents(stop <-chan struct{}, publish func(*Event))
+}
+
+// eventBus fans events out to in-process subscribers. Subscribers run
+// synchronously on the event goroutine and must not block.
+type eventBus struct {
+	mu     sync.RWMutex
+	seq    uint64
+	nextID int
+	subs   map[int]func(*Event)
+}
+
+// newEventBus starts the sequence at the current time in microseconds, so
+// event IDs keep increasing across restarts and a cursor from a previous run
+// never points past new events
+func newEventBus() *eventBus {
+	return &eventBus{
+		seq:  uint64(time.Now().UnixMicro()),
+		subs: make(map[int]func(*Event)),
+	}
+}
+
+// subscribe registers fn for all events and returns its unsubscribe func
+func (b *eventBus) subscribe(fn func(*Event)) func() {
+	b.mu.Lock()
+	id := b.nextID
+	b.nextID++
+	b.subs[id] = fn
+	b.mu.Unlock()
+
+	return func() {
+		b.mu.Lock()
+		delete(b.subs, id)
+		b.mu.Unlock()
+	}
+}
+
+// current returns the sequence number of the last published event
+func (b *eventBus) current() uint64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.seq
+}
+
+// publish assigns the event its sequence number and delivers it
+func (b *eventBus) publish(ev *Event) {
+	b.mu.Lock()
+	b.seq++
+	ev.Seq = b.seq
+	subs := make([]func(*Event), 0, len(b.subs))
+	for _, fn := range b.subs {
+		subs = append(subs, fn)
+	}
+	b.mu.Unlock()
+
+	for _, fn := range subs {
+		fn(ev)
+	}
+}
+
+// StreamEvents subscribes to all events on a dedicated ESL connection, so
+// event traffic never delays API commands. The connection is re-established
+// with backoff whenever it drops.
+func (esl *ESLgoClient) StreamEvents(stop <-chan struct{}, publish func(*Event)) {
+	esl.mu.Lock()
+	esl.eventsStarted = true
+	esl.mu.Unlock()
+	go func() {
+		backoff := eslBackoffMin
+		for {
+			disconnected := make(chan struct{})
+			var once sync.Once
+			conn, err := eslgo.Dial(esl.host+":"+esl.port, esl.password, func() {
+				once.Do(func() { close(disconnected) })
+			})
+			if err == nil {
+				conn.RegisterEventListener(eslgo.EventListenAll, func(ev *eslgo.Event) {
+					headers := make(map[string]string, len(ev.Headers))
+					for k := range ev.Headers {
+						headers[k] = ev.GetHeader(k)
+					}
+					publish(newEvent(ev.GetName(), headers))
+				})
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				_, err = conn.SendCommand(ctx, command.Event{Format: "plain", Listen: []string{"ALL"}})
+				cancel()
+				if err != nil {
+					conn.Close()
+				}
+			}
+
+			if err == nil {
+				log.Println("ESL event stream connected")
+				esl.setEventConn(conn)
+				backoff = eslBackoffMin
+				select {
+				case <-disconnected:
+					log.Println("ESL event stream disconnected")
+					esl.setEventConn(nil)
+				case <-stop:
+					esl.setEventConn(nil)
+					conn.Close()
+					return
+				}
+			} else {
+				log.Printf("ESL event stream connect failed: %v (next attempt in %s)", err, backoff)
+			}
+
+			select {
+			case <-time.After(backoff):
+			case <-stop:
+				return
+			}
+			if backoff *= 2; backoff > eslBackoffMax {
+				backoff = eslBackoffMax
+			}
+		}
+	}()
+}
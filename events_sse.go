@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	eventListDefaultLimit = 100
+	eventStreamBuffer     = 256
+	eventStreamPing       = 15 * time.Second
+	eventStreamRetryMS    = 3000
+)
+
+// eventHistory keeps the most recent events in a ring buffer so consumers
+// can catch up from their last cursor
+type eventHistory struct {
+	mu     sync.RWMutex
+	events []*Event
+	next   int // Ring position of the next event
+	full   bool
+	start  uint64 // Sequence number before the first recorded event
+}
+
+func newEventHistory(size int, start uint64) *eventHistory {
+	return &eventHistory{events: make([]*Event, size), start: start}
+}
+
+// record is an event bus subscriber
+func (hist *eventHistory) record(ev *Event) {
+	hist.mu.Lock()
+	hist.events[hist.next] = ev
+	hist.next = (hist.next + 1) % len(hist.events)
+	if hist.next == 0 {
+		hist.full = true
+	}
+	hist.mu.Unlock()
+}
+
+// after returns the buffered events with a sequence number above seq,
+// oldest first. complete is false when events after seq were already
+// dropped from the buffer or predate this run of fs-api.
+func (hist *eventHistory) after(seq uint64) (events []*Event, complete bool) {
+	hist.mu.RLock()
+	defer hist.mu.RUnlock()
+
+	var ordered []*Event
+	if hist.full {
+		ordered = append(ordered, hist.events[hist.next:]...)
+	}
+	ordered = append(ordered, hist.events[:hist.next]...)
+
+	complete = seq >= hist.start && (!hist.full || ordered[0].Seq <= seq+1)
+	for _, ev := range ordered {
+		if ev.Seq > seq {
+			events = append(events, ev)
+		}
+	}
+	return events, complete
+}
+
+// eventFilter selects the events a stream client asked for
+type eventFilter struct {
+	names       []string // Event-Name or Event-Subclass; a trailing "*" matches by prefix
+	uuids       []string // Watched calls
+	context     string
+	conferences []string // Watched conferences, by Conference-Name
+}
+
+// parseEventFilter reads the events, uuid, context and conference query
+// parameters
+func parseEventFilter(r *http.Request) (*eventFilter, error) {
+	q := r.URL.Query()
+	f := &eventFilter{
+		names:       splitCSV(q.Get("events")),
+		uuids:       splitCSV(q.Get("uuid")),
+		context:     q.Get("context"),
+		conferences: splitCSV(q.Get("conference")),
+	}
+	for _, id := range f.uuids {
+		if err := validateUUID(id); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// matches reports whether ev passes the filter and the caller may see it.
+// Events without a channel context are only shown to unrestricted callers.
+func (f *eventFilter) matches(r *http.Request, ev *Event) bool {
+	callContext := ev.Context()
+	if !isContextAllowed(r, callContext) {
+		return false
+	}
+	if f.context != "" && callContext != f.context {
+		return false
+	}
+	if len(f.uuids) > 0 && !containsString(f.uuids, ev.UUID()) {
+		return false
+	}
+	if len(f.conferences) > 0 && !containsString(f.conferences, ev.Get("Conference-Name")) {
+		return false
+	}
+	if len(f.names) == 0 {
+		return true
+	}
+	subclass := ev.Get("Event-Subclass")
+	for _, name := range f.names {
+		if prefix, ok := strings.CutSuffix(name, "*"); ok {
+			if strings.HasPrefix(ev.Name, prefix) || (subclass != "" && strings.HasPrefix(subclass, prefix)) {
+				return true
+			}
+		} else if name == ev.Name || name == subclass {
+			return true
+		}
+	}
+	return false
+}
+
+// eventCursor reads the ?after= cursor, falling back to the Last-Event-ID
+// header. ok is false when neither is given.
+func eventCursor(r *http.Request) (after uint64, ok bool, err error) {
+	v := r.URL.Query().Get("after")
+	if v == "" {
+		v = r.Header.Get("Last-Event-ID")
+	}
+	if v == "" {
+		return 0, false, nil
+	}
+	after, err = strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("after must be an event id")
+	}
+	return after, true, nil
+}
+
+// eventStreamRequest validates the options shared by the event endpoints
+func (h *APIHandler) eventStreamRequest(w http.ResponseWriter, r *http.Request) (*eventFilter, bool) {
+	if h.eventHistory == nil {
+		h.respondError(w, r, "Event streaming is disabled (FSAPI_EVENTS is not true)", http.StatusNotImplemented)
+		return nil, false
+	}
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if filter.context != "" && !h.validateRequestContext(w, r, filter.context) {
+		return nil, false
+	}
+	return filter, true
+}
+
+// GET /v1/events?after=&limit=&events=&uuid=&context=&conference=
+func (h *APIHandler) ListEvents(w http.ResponseWriter, r *http.Request) {
+	filter, ok := h.eventStreamRequest(w, r)
+	if !ok {
+		return
+	}
+	after, resume, err := eventCursor(r)
+	if err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := eventListDefaultLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			h.respondError(w, r, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	buffered, complete := h.eventHistory.after(after)
+	rows := []*Event{}
+	next := after
+	if !resume {
+		next = h.eventHistory.start
+	}
+	for _, ev := range buffered {
+		if len(rows) == limit {
+			break
+		}
+		// The cursor moves past filtered-out events too
+		next = ev.Seq
+		if filter.matches(r, ev) {
+			rows = append(rows, ev)
+		}
+	}
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status":    "success",
+		"row_count": len(rows),
+		"rows":      rows,
+		"next":      next,
+		"gap":       resume && !complete,
+	})
+}
+
+// GET /v1/events/sse?after=&events=&uuid=&context=&conference=
+func (h *APIHandler) StreamEventsSSE(w http.ResponseWriter, r *http.Request) {
+	filter, ok := h.eventStreamRequest(w, r)
+	if !ok {
+		return
+	}
+	lastID, resume, err := eventCursor(r)
+	if err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The server's WriteTimeout would cut the stream after 15s
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		h.respondError(w, r, "Streaming is not supported by this connection", http.StatusInternalServerError)
+		return
+	}
+
+	// Subscribe before reading the history so no event falls in between
+	live := make(chan *Event, eventStreamBuffer)
+	overflow := make(chan struct{})
+	var once sync.Once
+	unsubscribe := h.events.subscribe(func(ev *Event) {
+		select {
+		case live <- ev:
+		default:
+			once.Do(func() { close(overflow) })
+		}
+	})
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.Header().Set("X-Request-ID", getRequestID(r))
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", eventStreamRetryMS)
+
+	send := func(event string, id uint64, data interface{}) bool {
+		payload, _ := json.Marshal(data)
+		if id > 0 {
+			fmt.Fprintf(w, "id: %d\n", id)
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	sent := lastID
+	if resume {
+		missed, complete := h.eventHistory.after(lastID)
+		if !complete {
+			// The client must resynchronize: some events are gone
+			if !send("gap", 0, map[string]uint64{"last_event_id": lastID}) {
+				return
+			}
+		}
+		for _, ev := range missed {
+			if filter.matches(r, ev) && !send("event", ev.Seq, ev) {
+				return
+			}
+			sent = ev.Seq
+		}
+	}
+	rc.Flush()
+	logInfo(getRequestID(r), fmt.Sprintf("Event stream opened (events=%s, resumed after %d)", strings.Join(filter.names, ","), lastID))
+
+	ping := time.NewTicker(eventStreamPing)
+	defer ping.Stop()
+	for {
+		select {
+		case ev := <-live:
+			if ev.Seq <= sent {
+				continue // Already replayed from the history
+			}
+			sent = ev.Seq
+			if filter.matches(r, ev) && !send("event", ev.Seq, ev) {
+				return
+			}
+		case <-ping.C:
+			// Keeps proxies from closing an idle connection
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+		case <-overflow:
+			// Resuming with Last-Event-ID picks up what this client missed
+			send("end", 0, map[string]string{"reason": "slow_consumer"})
+			return
+		case <-h.streamsClosing:
+			send("end", 0, map[string]string{"reason": "shutdown"})
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,68 @@
+//go:build fsapi_ext_example
+
+// Example extension, built only with -tags fsapi_ext_example. Copy this file
+// to add deployment-specific endpoints and middleware without touching the
+// rest of the tree.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+func init() {
+	registerExtension(Extension{
+		Name: "example",
+		Routes: func(r *mux.Router, h *APIHandler) {
+			r.HandleFunc("/calls/{uuid}/note", h.exampleSetCallNote).Methods("POST")
+		},
+	})
+
+	// Counts authorized requests per credential, e.g. for chargeback
+	registerMiddleware(Middleware{
+		Name:  "example-requests",
+		Stage: StageAfterAuth,
+		Setup: func(h *APIHandler) mux.MiddlewareFunc {
+			requests := h.metrics.counter("fsapi_example_requests_total", "Authorized requests by credential.", "token")
+			return func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					requests.inc(getTokenID(r))
+					next.ServeHTTP(w, r)
+				})
+			}
+		},
+	})
+}
+
+// exampleNoteRequest is the body of POST /v1/ext/example/calls/{uuid}/note
+type exampleNoteRequest struct {
+	Note string `json:"note"`
+}
+
+// POST /v1/ext/example/calls/{uuid}/note stores a note on the call as the
+// fsapi_note channel variable
+func (h *APIHandler) exampleSetCallNote(w http.ResponseWriter, r *http.Request) {
+	callUUID := mux.Vars(r)["uuid"]
+	callInfo, ok := h.validateCallContext(w, r, callUUID)
+	if !ok {
+		return
+	}
+	var req exampleNoteRequest
+	if !h.decodeRequest(w, r, &req) {
+		return
+	}
+	if req.Note == "" || strings.ContainsAny(req.Note, "\r\n") {
+		h.respondError(w, r, "note is required and must be a single line", http.StatusBadRequest)
+		return
+	}
+	if _, err := h.eslClient.SendCommand(fmt.Sprintf("api uuid_setvar %s fsapi_note %s", callUUID, req.Note)); err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to set note: %v", err), err)
+		return
+	}
+	h.audit(r, AuditEntry{Action: "example.note", Target: callUUID, Context: callInfo.AccountCode})
+	h.respondSuccess(w, r, fmt.Sprintf("Note set on call %s", callUUID))
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+
+	"github.com/gorilla/mux"
+)
+
+// Extension adds deployment-specific endpoints without forking the handlers.
+// An extension is a file in package main that registers itself from init();
+// its routes are mounted under /v1/ext/<Name>/ behind the same request ID,
+// size limit, authentication, context and policy middleware as the built-in
+// ones, and its handlers can use everything on APIHandler (eslClient,
+// respondJSON, validateCallContext, audit, ...).
+type Extension struct {
+	Name   string                             // URL segment, lowercase letters, digits, '-' and '_'
+	Routes func(r *mux.Router, h *APIHandler) // Registers routes relative to /v1/ext/<Name>
+}
+
+var (
+	extensions      []Extension
+	extensionNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+)
+
+// registerExtension adds an extension; call it from init(). Invalid or
+// duplicate names panic, so a bad build fails at startup.
+func registerExtension(ext Extension) {
+	if !extensionNameRe.MatchString(ext.Name) {
+		panic(fmt.Sprintf("extension name %q must match %s", ext.Name, extensionNameRe))
+	}
+	if ext.Routes == nil {
+		panic(fmt.Sprintf("extension %q has no Routes", ext.Name))
+	}
+	for _, other := range extensions {
+		if other.Name == ext.Name {
+			panic(fmt.Sprintf("extension %q registered twice", ext.Name))
+		}
+	}
+	extensions = append(extensions, ext)
+}
+
+// mountExtensions registers every extension's routes on the /v1 router
+func mountExtensions(v1 *mux.Router, h *APIHandler) {
+	for _, ext := range extensions {
+		ext.Routes(v1.PathPrefix("/ext/"+ext.Name).Subrouter(), h)
+		log.Printf("Extension %q: mounted at /v1/ext/%s", ext.Name, ext.Name)
+	}
+}
+
+// Points in the middleware chain where custom middleware can run. The
+// built-in chain is request ID, body size limit, authentication,
+// X-Allowed-Contexts and policy, in that order.
+const (
+	StageBeforeAuth = "before_auth" // After the size limit; the caller is not yet authenticated
+	StageAfterAuth  = "after_auth"  // After the policy check, right before the handler
+)
+
+// Middleware is custom middleware added to every route, including /health
+// and /metrics, at one of the stages above. Within a stage, middleware runs
+// by ascending Order, then in registration order. Setup is called once at
+// startup; the middleware it returns wraps each matched request.
+type Middleware struct {
+	Name  string
+	Stage string
+	Order int
+	Setup func(h *APIHandler) mux.MiddlewareFunc
+}
+
+var middlewares []Middleware
+
+// registerMiddleware adds custom middleware; call it from init(). Unknown
+// stages and duplicate names panic, so a bad build fails at startup.
+func registerMiddleware(mw Middleware) {
+	if mw.Stage != StageBeforeAuth && mw.Stage != StageAfterAuth {
+		panic(fmt.Sprintf("middleware %q has unknown stage %q", mw.Name, mw.Stage))
+	}
+	if mw.Name == "" || mw.Setup == nil {
+		panic("middleware needs a Name and Setup")
+	}
+	for _, other := range middlewares {
+		if other.Name == mw.Name {
+			panic(fmt.Sprintf("middleware %q registered twice", mw.Name))
+		}
+	}
+	middlewares = append(middlewares, mw)
+}
+
+// useMiddleware adds the custom middleware of a stage to the router
+func useMiddleware(r *mux.Router, h *APIHandler, stage string) {
+	var staged []Middleware
+	for _, mw := range middlewares {
+		if mw.Stage == stage {
+			staged = append(staged, mw)
+		}
+	}
+	sort.SliceStable(staged, func(i, j int) bool { return staged[i].Order < staged[j].Order })
+	for _, mw := range staged {
+		r.Use(mw.Setup(h))
+		log.Printf("Middleware %q: enabled (%s, order %d)", mw.Name, stage, mw.Order)
+	}
+}
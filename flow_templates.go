@@ -0,0 +1,385 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	flowTemplatesFile = "flow_templates.json"
+
+	flowTemplateMaxParams   = 20
+	flowTemplateMaxParamLen = 256
+)
+
+var (
+	flowTemplateNamePattern  = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+	flowTemplateParamPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+
+	// {{name}} in a string field of a template step
+	flowPlaceholderPattern = regexp.MustCompile(`\{\{([^{}]*)\}\}`)
+)
+
+// FlowTemplateParam is a parameter of a flow template
+type FlowTemplateParam struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description,omitempty"`
+	Default     *string `json:"default,omitempty"` // Without one the parameter is required
+}
+
+// FlowTemplate is a named flow owned by a tenant context. Its steps may use
+// {{param}} in string fields, filled in when it is run.
+type FlowTemplate struct {
+	Name        string              `json:"name"`
+	Context     string              `json:"context"` // Tenant context (domain) owning the template; flows run in it
+	Description string              `json:"description,omitempty"`
+	Params      []FlowTemplateParam `json:"params,omitempty"`
+	Steps       []FlowStep          `json:"steps"`
+	CreatedAt   time.Time           `json:"created_at"`
+	UpdatedAt   time.Time           `json:"updated_at"`
+}
+
+// FlowRunRequest is the optional body of POST /v1/flows/{name}/run
+type FlowRunRequest struct {
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// id is the template's key and URL name, name@context
+func (t *FlowTemplate) id() string {
+	return t.Name + "@" + t.Context
+}
+
+// placeholders returns the parameter names the steps use
+func (t *FlowTemplate) placeholders() []string {
+	raw, _ := json.Marshal(t.Steps)
+	var names []string
+	for _, m := range flowPlaceholderPattern.FindAllStringSubmatch(string(raw), -1) {
+		if !containsString(names, m[1]) {
+			names = append(names, m[1])
+		}
+	}
+	return names
+}
+
+// render returns the steps with the placeholders replaced by values, or by
+// the defaults of parameters values leaves out
+func (t *FlowTemplate) render(values map[string]string) ([]FlowStep, error) {
+	declared := make(map[string]string, len(t.Params))
+	for _, p := range t.Params {
+		v, ok := values[p.Name]
+		if !ok {
+			if p.Default == nil {
+				return nil, fmt.Errorf("params.%s is required", p.Name)
+			}
+			v = *p.Default
+		}
+		declared[p.Name] = v
+	}
+	for name, v := range values {
+		if _, ok := declared[name]; !ok {
+			return nil, fmt.Errorf("params.%s is not a parameter of template %s", name, t.id())
+		}
+		if len(v) > flowTemplateMaxParamLen || strings.ContainsAny(v, "\n\r") {
+			return nil, fmt.Errorf("params.%s must be a single line of at most %d characters", name, flowTemplateMaxParamLen)
+		}
+	}
+
+	// Values are substituted JSON-escaped into the JSON of the steps, so
+	// they can only ever become (part of) a string field, which the step
+	// checks of the flow then validate
+	raw, err := json.Marshal(t.Steps)
+	if err != nil {
+		return nil, err
+	}
+	rendered := flowPlaceholderPattern.ReplaceAllStringFunc(string(raw), func(m string) string {
+		quoted, _ := json.Marshal(declared[m[2:len(m)-2]])
+		return string(quoted[1 : len(quoted)-1])
+	})
+	var steps []FlowStep
+	if err := json.Unmarshal([]byte(rendered), &steps); err != nil {
+		return nil, err
+	}
+	return steps, nil
+}
+
+// validate checks the template. A template whose parameters all have
+// defaults is checked as a flow; otherwise only the order of its steps is,
+// and the rest when it is run.
+func (t *FlowTemplate) validate() error {
+	if !flowTemplateNamePattern.MatchString(t.Name) {
+		return fmt.Errorf("name must be 1-64 letters, digits, '_' or '-'")
+	}
+	if !domainPattern.MatchString(t.Context) {
+		return fmt.Errorf("context is required")
+	}
+	if strings.ContainsAny(t.Description, "\n\r") {
+		return fmt.Errorf("description must be a single line")
+	}
+	if len(t.Params) > flowTemplateMaxParams {
+		return fmt.Errorf("params must have at most %d parameters", flowTemplateMaxParams)
+	}
+	var names []string
+	complete := true
+	for i, p := range t.Params {
+		if !flowTemplateParamPattern.MatchString(p.Name) {
+			return fmt.Errorf("params[%d]: name must be lowercase letters, digits and '_', starting with a letter", i)
+		}
+		if containsString(names, p.Name) {
+			return fmt.Errorf("params[%d]: %s is declared twice", i, p.Name)
+		}
+		if strings.ContainsAny(p.Description, "\n\r") {
+			return fmt.Errorf("params[%d]: description must be a single line", i)
+		}
+		if p.Default == nil {
+			complete = false
+		} else if len(*p.Default) > flowTemplateMaxParamLen || strings.ContainsAny(*p.Default, "\n\r") {
+			return fmt.Errorf("params[%d]: default must be a single line of at most %d characters", i, flowTemplateMaxParamLen)
+		}
+		names = append(names, p.Name)
+	}
+	used := t.placeholders()
+	for _, name := range used {
+		if !containsString(names, name) {
+			return fmt.Errorf("steps use {{%s}}, which is not in params", name)
+		}
+	}
+	for _, name := range names {
+		if !containsString(used, name) {
+			return fmt.Errorf("param %s is not used by the steps", name)
+		}
+	}
+
+	if err := checkFlowLayout(t.Steps); err != nil {
+		return err
+	}
+	if complete {
+		steps, err := t.render(nil)
+		if err != nil {
+			return err
+		}
+		req := FlowRequest{Context: t.Context, Steps: steps}
+		return req.validate()
+	}
+	return nil
+}
+
+// flowTemplates is the persisted template inventory, keyed by name@context
+type flowTemplates struct {
+	mu        sync.Mutex
+	templates map[string]*FlowTemplate
+}
+
+// newFlowTemplates loads the templates from FSAPI_DATA_DIR
+func newFlowTemplates() *flowTemplates {
+	reg := &flowTemplates{templates: make(map[string]*FlowTemplate)}
+	var templates []*FlowTemplate
+	if err := loadJSONFile(flowTemplatesFile, &templates); err != nil {
+		log.Printf("WARNING: Failed to load flow templates: %v", err)
+	}
+	for _, t := range templates {
+		reg.templates[t.id()] = t
+	}
+	return reg
+}
+
+// list returns the templates sorted by name@context
+func (reg *flowTemplates) list() []*FlowTemplate {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	templates := make([]*FlowTemplate, 0, len(reg.templates))
+	for _, t := range reg.templates {
+		templates = append(templates, t)
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].id() < templates[j].id() })
+	return templates
+}
+
+// save persists the inventory. Caller must hold mu.
+func (reg *flowTemplates) save() error {
+	templates := make([]*FlowTemplate, 0, len(reg.templates))
+	for _, t := range reg.templates {
+		templates = append(templates, t)
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].id() < templates[j].id() })
+	return saveJSONFile(flowTemplatesFile, templates)
+}
+
+// --- Flow template handlers ---
+
+// GET /v1/flows/templates
+func (h *APIHandler) ListFlowTemplates(w http.ResponseWriter, r *http.Request) {
+	contextFilter := r.URL.Query().Get("context")
+	rows := []*FlowTemplate{}
+	for _, t := range h.flowTemplates.list() {
+		if contextFilter != "" && t.Context != contextFilter {
+			continue
+		}
+		if isContextAllowed(r, t.Context) {
+			rows = append(rows, t)
+		}
+	}
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status":    "success",
+		"row_count": len(rows),
+		"rows":      rows,
+	})
+}
+
+// POST /v1/flows/templates
+func (h *APIHandler) CreateFlowTemplate(w http.ResponseWriter, r *http.Request) {
+	var t FlowTemplate
+	if !h.decodeRequest(w, r, &t) {
+		return
+	}
+	if err := t.validate(); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !h.validateRequestContext(w, r, t.Context) {
+		return
+	}
+	t.CreatedAt = time.Now().UTC()
+	t.UpdatedAt = t.CreatedAt
+
+	h.flowTemplates.mu.Lock()
+	if _, exists := h.flowTemplates.templates[t.id()]; exists {
+		h.flowTemplates.mu.Unlock()
+		h.respondError(w, r, fmt.Sprintf("Flow template %s already exists", t.id()), http.StatusConflict)
+		return
+	}
+	h.flowTemplates.templates[t.id()] = &t
+	err := h.flowTemplates.save()
+	h.flowTemplates.mu.Unlock()
+	if err != nil {
+		logWarn(getRequestID(r), fmt.Sprintf("Failed to persist flow templates: %v", err))
+	}
+
+	logInfo(getRequestID(r), fmt.Sprintf("Flow template %s created", t.id()))
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   t,
+	})
+}
+
+// lookupFlowTemplate returns the template named in the URL if the caller
+// may see it
+func (h *APIHandler) lookupFlowTemplate(w http.ResponseWriter, r *http.Request) (*FlowTemplate, bool) {
+	id := mux.Vars(r)["name"]
+	h.flowTemplates.mu.Lock()
+	t, ok := h.flowTemplates.templates[id]
+	h.flowTemplates.mu.Unlock()
+	if !ok || !isContextAllowed(r, t.Context) {
+		h.respondError(w, r, fmt.Sprintf("Flow template %s not found", id), http.StatusNotFound)
+		return nil, false
+	}
+	return t, true
+}
+
+// GET /v1/flows/templates/{name}
+func (h *APIHandler) GetFlowTemplate(w http.ResponseWriter, r *http.Request) {
+	t, ok := h.lookupFlowTemplate(w, r)
+	if !ok {
+		return
+	}
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   t,
+	})
+}
+
+// PUT /v1/flows/templates/{name}
+func (h *APIHandler) UpdateFlowTemplate(w http.ResponseWriter, r *http.Request) {
+	existing, ok := h.lookupFlowTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	var t FlowTemplate
+	if !h.decodeRequest(w, r, &t) {
+		return
+	}
+	if (t.Name != "" && t.Name != existing.Name) || (t.Context != "" && t.Context != existing.Context) {
+		h.respondError(w, r, "name and context cannot be changed", http.StatusBadRequest)
+		return
+	}
+	t.Name = existing.Name
+	t.Context = existing.Context
+	if err := t.validate(); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	t.CreatedAt = existing.CreatedAt
+	t.UpdatedAt = time.Now().UTC()
+
+	h.flowTemplates.mu.Lock()
+	h.flowTemplates.templates[t.id()] = &t
+	err := h.flowTemplates.save()
+	h.flowTemplates.mu.Unlock()
+	if err != nil {
+		logWarn(getRequestID(r), fmt.Sprintf("Failed to persist flow templates: %v", err))
+	}
+
+	logInfo(getRequestID(r), fmt.Sprintf("Flow template %s updated", t.id()))
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   t,
+	})
+}
+
+// DELETE /v1/flows/templates/{name}
+func (h *APIHandler) DeleteFlowTemplate(w http.ResponseWriter, r *http.Request) {
+	t, ok := h.lookupFlowTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	h.flowTemplates.mu.Lock()
+	delete(h.flowTemplates.templates, t.id())
+	err := h.flowTemplates.save()
+	h.flowTemplates.mu.Unlock()
+	if err != nil {
+		logWarn(getRequestID(r), fmt.Sprintf("Failed to persist flow templates: %v", err))
+	}
+
+	// Flows already started from the template run on
+	h.respondSuccess(w, r, h.trashDeleted(r, fmt.Sprintf("Flow template %s deleted", t.id()), trashFlowTemplate, t.id(), []string{t.Context}, t))
+}
+
+// POST /v1/flows/{name}/run
+//
+// Renders the template with the request's params and starts it like
+// POST /v1/flows, in the template's context.
+func (h *APIHandler) RunFlowTemplate(w http.ResponseWriter, r *http.Request) {
+	if !h.flowsEnabled(w, r) {
+		return
+	}
+	t, ok := h.lookupFlowTemplate(w, r)
+	if !ok {
+		return
+	}
+	var req FlowRunRequest
+	if !h.decodeOptionalRequest(w, r, &req) {
+		return
+	}
+	steps, err := t.render(req.Params)
+	if err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if flow, ok := h.startFlow(w, r, &FlowRequest{Context: t.Context, Steps: steps}, t.id()); ok {
+		h.respondJSON(w, r, map[string]interface{}{
+			"status": "success",
+			"data":   flow,
+		})
+	}
+}
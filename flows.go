@@ -0,0 +1,1043 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const (
+	flowMaxSteps      = 20
+	flowMaxTimeoutSec = 3600
+
+	// Ended flows are kept this long, and at most flowMaxKept of them
+	flowRetention = time.Hour
+	flowMaxKept   = 1000
+
+	// How long cancel waits for the flow to stop before answering
+	flowCancelWait = 5 * time.Second
+)
+
+// Flow step actions
+const (
+	flowActionOriginate  = "originate"   // Dial endpoint; the call parks once it rings
+	flowActionWaitAnswer = "wait_answer" // Until the call is answered
+	flowActionPlayback   = "playback"    // Play file to the call until it ends
+	flowActionSpeak      = "speak"       // Speak text to the call with text-to-speech
+	flowActionSay        = "say"         // Say a number, amount or date to the call with mod_say
+	flowActionCollect    = "collect"     // Until the caller presses a digit, after an optional prompt
+	flowActionBridge     = "bridge"      // Bridge the call to endpoint
+	flowActionHangup     = "hangup"      // Hang the call up; last step only
+)
+
+var flowActions = []string{flowActionOriginate, flowActionWaitAnswer, flowActionPlayback, flowActionSpeak, flowActionSay, flowActionCollect, flowActionBridge, flowActionHangup}
+
+// Default step timeouts in seconds
+var flowDefaultTimeouts = map[string]int{
+	flowActionOriginate:  60,
+	flowActionWaitAnswer: 60,
+	flowActionPlayback:   300,
+	flowActionSpeak:      300,
+	flowActionSay:        300,
+	flowActionCollect:    15,
+	flowActionBridge:     60,
+}
+
+// What a flow does when a step fails
+const (
+	flowOnFailureHangup   = "hangup"   // Hang the call up and fail the flow (default)
+	flowOnFailureContinue = "continue" // Go on with the next step
+	flowOnFailureStop     = "stop"     // Fail the flow, leaving the call as it is
+	flowOnFailureTransfer = "transfer" // Transfer the call to failure_destination and fail the flow
+)
+
+var flowOnFailures = []string{flowOnFailureHangup, flowOnFailureContinue, flowOnFailureStop, flowOnFailureTransfer}
+
+// Flow statuses
+const (
+	flowStatusRunning     = "running"
+	flowStatusCompleted   = "completed"
+	flowStatusFailed      = "failed"
+	flowStatusCanceled    = "canceled"
+	flowStatusInterrupted = "interrupted" // fs-api shut down while it ran
+)
+
+// flowDestinationPattern matches the extension of on_failure transfer
+var flowDestinationPattern = regexp.MustCompile(`^[A-Za-z0-9*#+_.-]{1,64}$`)
+
+// flowDigitsPattern matches the digits a collect step accepts
+var flowDigitsPattern = regexp.MustCompile(`^[0-9*#]{1,12}$`)
+
+// FlowStep is one step of a call flow
+type FlowStep struct {
+	Action             string       `json:"action"`
+	Endpoint           *DialTarget  `json:"endpoint,omitempty"`            // originate, bridge
+	CallerIDNumber     string       `json:"caller_id_number,omitempty"`    // originate
+	CallerIDName       string       `json:"caller_id_name,omitempty"`      // originate
+	File               string       `json:"file,omitempty"`                // playback, collect prompt: absolute path or local_stream://<name>
+	TTS                *AnnounceTTS `json:"tts,omitempty"`                 // speak, collect prompt
+	Say                *SayPrompt   `json:"say,omitempty"`                 // say, collect prompt
+	Digits             string       `json:"digits,omitempty"`              // collect: digits accepted; default any
+	Cause              string       `json:"cause,omitempty"`               // hangup: default FSAPI_HANGUP_CAUSE for the context
+	TimeoutSec         int          `json:"timeout_sec,omitempty"`         // Not hangup; default per action
+	OnFailure          string       `json:"on_failure,omitempty"`          // Not originate; default hangup
+	FailureDestination string       `json:"failure_destination,omitempty"` // Extension in the flow's context for on_failure transfer
+}
+
+// FlowRequest is the body of POST /v1/flows
+type FlowRequest struct {
+	Context  string     `json:"context"`
+	Steps    []FlowStep `json:"steps"`
+	Locale   string     `json:"locale,omitempty"`   // Instead of X-Locale
+	Timezone string     `json:"timezone,omitempty"` // Instead of X-Timezone
+
+	verify bool // Built by POST /v1/verify/call
+}
+
+// FlowStepResult is the outcome of a step that ran
+type FlowStepResult struct {
+	Step      int        `json:"step"`
+	Action    string     `json:"action"`
+	Status    string     `json:"status"` // running, completed, failed, or the status of a flow stopped during the step
+	Error     string     `json:"error,omitempty"`
+	Digit     string     `json:"digit,omitempty"` // Digit pressed in a collect step
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+}
+
+// Flow is a call flow run by fs-api
+type Flow struct {
+	ID        string           `json:"id"`
+	Context   string           `json:"context"`
+	Template  string           `json:"template,omitempty"` // name@context of the template it was run from
+	Verify    bool             `json:"verify,omitempty"`   // Verification call started by POST /v1/verify/call
+	Survey    string           `json:"survey,omitempty"`   // ID of the survey it asks, on an existing call
+	Locale    string           `json:"locale,omitempty"`   // Language of the call, e.g. de-DE
+	Timezone  string           `json:"timezone,omitempty"` // IANA timezone of the call
+	Status    string           `json:"status"`
+	CallUUID  string           `json:"call_uuid"`
+	Step      int              `json:"step"` // Step running, or the last one run
+	Steps     []FlowStep       `json:"steps"`
+	Results   []FlowStepResult `json:"results"`
+	Error     string           `json:"error,omitempty"`
+	CreatedAt time.Time        `json:"created_at"`
+	UpdatedAt time.Time        `json:"updated_at"`
+	EndedAt   *time.Time       `json:"ended_at,omitempty"`
+}
+
+// validate checks a step and fills in its defaults
+func (step *FlowStep) validate() error {
+	if !containsString(flowActions, step.Action) {
+		return fmt.Errorf("action must be one of: %s", strings.Join(flowActions, ", "))
+	}
+	needs := map[string]bool{}
+	switch step.Action {
+	case flowActionOriginate:
+		needs["endpoint"], needs["caller_id_number"], needs["caller_id_name"] = true, true, true
+	case flowActionBridge:
+		needs["endpoint"] = true
+	case flowActionPlayback:
+		needs["file"] = true
+	case flowActionSpeak:
+		needs["tts"] = true
+	case flowActionSay:
+		needs["say"] = true
+	case flowActionCollect:
+		needs["file"], needs["tts"], needs["say"], needs["digits"] = true, true, true, true
+	case flowActionHangup:
+		needs["cause"] = true
+	}
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{
+		{"endpoint", step.Endpoint != nil}, {"caller_id_number", step.CallerIDNumber != ""}, {"caller_id_name", step.CallerIDName != ""},
+		{"file", step.File != ""}, {"tts", step.TTS != nil}, {"say", step.Say != nil}, {"digits", step.Digits != ""}, {"cause", step.Cause != ""},
+	} {
+		if f.set && !needs[f.name] {
+			return fmt.Errorf("%s is not used with action %s", f.name, step.Action)
+		}
+	}
+
+	switch step.Action {
+	case flowActionOriginate, flowActionBridge:
+		if step.Endpoint == nil {
+			return fmt.Errorf("endpoint is required for %s", step.Action)
+		}
+		if _, err := step.Endpoint.dialString(); err != nil {
+			return fmt.Errorf("endpoint: %v", err)
+		}
+	case flowActionPlayback:
+		if step.File == "" {
+			return fmt.Errorf("file is required for playback")
+		}
+		if err := checkPlaybackArgs(step.File); err != nil {
+			return fmt.Errorf("file: %v", strings.TrimPrefix(err.Error(), "application_args "))
+		}
+	case flowActionSpeak:
+		if step.TTS == nil {
+			return fmt.Errorf("tts is required for speak")
+		}
+		if err := checkTTS(step.TTS); err != nil {
+			return err
+		}
+	case flowActionSay:
+		if step.Say == nil {
+			return fmt.Errorf("say is required for say")
+		}
+		if err := checkSay(step.Say); err != nil {
+			return err
+		}
+	case flowActionCollect:
+		prompts := 0
+		for _, set := range []bool{step.File != "", step.TTS != nil, step.Say != nil} {
+			if set {
+				prompts++
+			}
+		}
+		if prompts > 1 {
+			return fmt.Errorf("at most one of file, tts or say may prompt a collect")
+		}
+		if step.File != "" {
+			if err := checkPlaybackArgs(step.File); err != nil {
+				return fmt.Errorf("file: %v", strings.TrimPrefix(err.Error(), "application_args "))
+			}
+		}
+		if step.TTS != nil {
+			if err := checkTTS(step.TTS); err != nil {
+				return err
+			}
+		}
+		if step.Say != nil {
+			if err := checkSay(step.Say); err != nil {
+				return err
+			}
+		}
+		if step.Digits != "" && !flowDigitsPattern.MatchString(step.Digits) {
+			return fmt.Errorf("digits may only contain 0-9, * and #")
+		}
+	case flowActionHangup:
+		if step.Cause != "" {
+			if err := checkHangupCause(step.Cause); err != nil {
+				return err
+			}
+		}
+	}
+	if err := checkESLArg("caller_id_name", step.CallerIDName, eslVarValueSeparators); err != nil {
+		return err
+	}
+
+	if step.Action == flowActionHangup {
+		if step.TimeoutSec != 0 {
+			return fmt.Errorf("timeout_sec is not used with action hangup")
+		}
+	} else if step.TimeoutSec < 0 || step.TimeoutSec > flowMaxTimeoutSec {
+		return fmt.Errorf("timeout_sec must be between 1 and %d", flowMaxTimeoutSec)
+	} else if step.TimeoutSec == 0 {
+		step.TimeoutSec = flowDefaultTimeouts[step.Action]
+	}
+
+	// A failed originate leaves no call to act on
+	if step.Action == flowActionOriginate {
+		if step.OnFailure != "" || step.FailureDestination != "" {
+			return fmt.Errorf("on_failure is not used with action originate: the flow fails")
+		}
+		return nil
+	}
+	if step.OnFailure == "" {
+		step.OnFailure = flowOnFailureHangup
+	}
+	if !containsString(flowOnFailures, step.OnFailure) {
+		return fmt.Errorf("on_failure must be one of: %s", strings.Join(flowOnFailures, ", "))
+	}
+	if (step.OnFailure == flowOnFailureTransfer) != (step.FailureDestination != "") {
+		return fmt.Errorf("failure_destination is required with, and only used with, on_failure transfer")
+	}
+	if step.FailureDestination != "" && !flowDestinationPattern.MatchString(step.FailureDestination) {
+		return fmt.Errorf("failure_destination must be an extension")
+	}
+	return nil
+}
+
+// checkFlowLayout checks the order of steps: one originate first, a hangup
+// only last
+func checkFlowLayout(steps []FlowStep) error {
+	if len(steps) == 0 || len(steps) > flowMaxSteps {
+		return fmt.Errorf("steps must have 1 to %d steps", flowMaxSteps)
+	}
+	for i, step := range steps {
+		if !containsString(flowActions, step.Action) {
+			return fmt.Errorf("steps[%d]: action must be one of: %s", i, strings.Join(flowActions, ", "))
+		}
+		if (i == 0) != (step.Action == flowActionOriginate) {
+			return fmt.Errorf("steps[%d]: the first step, and only it, must be originate", i)
+		}
+		if step.Action == flowActionHangup && i != len(steps)-1 {
+			return fmt.Errorf("steps[%d]: hangup must be the last step", i)
+		}
+	}
+	return nil
+}
+
+// validate checks the request and fills in step defaults
+func (req *FlowRequest) validate() error {
+	if req.Context == "" {
+		return fmt.Errorf("context is required")
+	}
+	if err := checkFlowLayout(req.Steps); err != nil {
+		return err
+	}
+	for i := range req.Steps {
+		if err := req.Steps[i].validate(); err != nil {
+			return fmt.Errorf("steps[%d]: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// flowCall is what a flow has seen of its call on the event stream
+type flowCall struct {
+	answered  bool
+	bridges   int            // CHANNEL_BRIDGE events
+	bridgeEnd int            // bridge applications that returned
+	bridgeErr string         // Disposition of the last bridge that returned
+	playbacks map[string]int // PLAYBACK_STOP events per file
+	speaks    int            // speak applications that returned
+	says      int            // say applications that returned
+	dtmf      []string       // Digits pressed, in order
+	hangup    string         // Hangup cause once the call is gone
+}
+
+// flowRun is a flow being executed
+type flowRun struct {
+	flow   *Flow // Guarded by flows.mu
+	cancel chan struct{}
+	done   chan struct{}
+
+	cancelOnce sync.Once
+	hangup     bool // Hang the call up on cancel; set before cancel is closed
+
+	mu     sync.Mutex
+	call   flowCall
+	notify chan struct{} // Signalled when call changes
+}
+
+// flowManager runs call flows and keeps them for flowRetention after they end
+type flowManager struct {
+	h  *APIHandler
+	mu sync.Mutex
+
+	runs     map[string]*flowRun
+	ended    *counterVec
+	verified *counterVec // Verification calls that ended, by delivery status
+}
+
+func newFlowManager(h *APIHandler) *flowManager {
+	return &flowManager{
+		h:        h,
+		runs:     make(map[string]*flowRun),
+		ended:    h.metrics.counter("fsapi_flows_total", "Call flows that ended, by status.", "status"),
+		verified: h.metrics.counter("fsapi_verify_calls_total", "Verification calls that ended, by delivery status.", "status"),
+	}
+}
+
+// newFlowRun prepares flow to be run
+func newFlowRun(flow *Flow) *flowRun {
+	return &flowRun{
+		flow:   flow,
+		cancel: make(chan struct{}),
+		done:   make(chan struct{}),
+		notify: make(chan struct{}, 1),
+		call:   flowCall{playbacks: make(map[string]int)},
+	}
+}
+
+// launch keeps run and starts executing it, returning false when fs-api is
+// shutting down. vars are set on the call by an originate step.
+func (m *flowManager) launch(run *flowRun, vars []string) bool {
+	m.mu.Lock()
+	m.prune()
+	m.runs[run.flow.ID] = run
+	m.mu.Unlock()
+	if !m.h.jobs.goJob("flow "+run.flow.ID, func() { m.run(run, vars) }) {
+		m.mu.Lock()
+		delete(m.runs, run.flow.ID)
+		m.mu.Unlock()
+		return false
+	}
+	return true
+}
+
+// snapshot returns a copy of the flow. The text of a verification call's
+// speak step holds the code, so it is left out. Caller must hold mu.
+func (run *flowRun) snapshot() Flow {
+	flow := *run.flow
+	flow.Results = append([]FlowStepResult(nil), run.flow.Results...)
+	if flow.Verify && len(flow.Steps) > verifyStepSpeak && flow.Steps[verifyStepSpeak].TTS != nil {
+		flow.Steps = append([]FlowStep(nil), flow.Steps...)
+		tts := *flow.Steps[verifyStepSpeak].TTS
+		tts.Text = verifyRedactedText
+		flow.Steps[verifyStepSpeak].TTS = &tts
+	}
+	return flow
+}
+
+// prune drops flows that ended more than flowRetention ago, then the oldest
+// ended ones beyond flowMaxKept. Caller must hold mu.
+func (m *flowManager) prune() {
+	cutoff := time.Now().Add(-flowRetention)
+	var ended []*flowRun
+	for id, run := range m.runs {
+		if run.flow.EndedAt == nil {
+			continue
+		}
+		if run.flow.EndedAt.Before(cutoff) {
+			delete(m.runs, id)
+			continue
+		}
+		ended = append(ended, run)
+	}
+	if len(ended) <= flowMaxKept {
+		return
+	}
+	sort.Slice(ended, func(i, j int) bool { return ended[i].flow.EndedAt.Before(*ended[j].flow.EndedAt) })
+	for _, run := range ended[:len(ended)-flowMaxKept] {
+		delete(m.runs, run.flow.ID)
+	}
+}
+
+// handleEvent records the events of the flow's call and wakes the flow
+func (run *flowRun) handleEvent(callUUID string, ev *Event) {
+	if !strings.EqualFold(ev.UUID(), callUUID) {
+		return
+	}
+	run.mu.Lock()
+	switch ev.Name {
+	case "CHANNEL_ANSWER":
+		run.call.answered = true
+	case "CHANNEL_BRIDGE":
+		run.call.bridges++
+	case "CHANNEL_EXECUTE_COMPLETE":
+		switch ev.Get("Application") {
+		case "bridge":
+			run.call.bridgeEnd++
+			run.call.bridgeErr = ev.Var("originate_disposition")
+		case "speak":
+			run.call.speaks++
+		case "say":
+			run.call.says++
+		default:
+			run.mu.Unlock()
+			return
+		}
+	case "DTMF":
+		run.call.dtmf = append(run.call.dtmf, ev.Get("DTMF-Digit"))
+	case "PLAYBACK_STOP":
+		run.call.playbacks[ev.Get("Playback-File-Path")]++
+	case "CHANNEL_HANGUP", "CHANNEL_HANGUP_COMPLETE":
+		if run.call.hangup == "" {
+			run.call.hangup = ev.Get("Hangup-Cause")
+			if run.call.hangup == "" {
+				run.call.hangup = "NORMAL_CLEARING"
+			}
+		}
+	default:
+		run.mu.Unlock()
+		return
+	}
+	run.mu.Unlock()
+	select {
+	case run.notify <- struct{}{}:
+	default:
+	}
+}
+
+// errFlowStopped ends a wait when the flow is canceled or fs-api shuts down
+var errFlowStopped = fmt.Errorf("flow stopped")
+
+// wait blocks until cond returns true or an error, the timeout passes, or
+// the flow is stopped. cond runs with run.mu held.
+func (run *flowRun) wait(stop <-chan struct{}, timeout time.Duration, cond func(call *flowCall) (bool, error)) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		run.mu.Lock()
+		ok, err := cond(&run.call)
+		run.mu.Unlock()
+		if err != nil || ok {
+			return err
+		}
+		select {
+		case <-run.notify:
+		case <-timer.C:
+			return fmt.Errorf("timed out after %s", timeout)
+		case <-run.cancel:
+			return errFlowStopped
+		case <-stop:
+			return errFlowStopped
+		}
+	}
+}
+
+// hungUp fails a wait once the call is gone
+func hungUp(call *flowCall) error {
+	if call.hangup != "" {
+		return fmt.Errorf("call hung up (%s)", call.hangup)
+	}
+	return nil
+}
+
+// command sends an ESL command on the flow's call, taking the call's lock so
+// it does not interleave with API requests on the call
+func (m *flowManager) command(callUUID, cmd string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), callLockWait)
+	release, ok := m.h.callLocks.acquire(ctx, []string{strings.ToLower(callUUID)})
+	cancel()
+	if !ok {
+		return "", fmt.Errorf("another request on the call is still running")
+	}
+	defer release()
+	response, err := m.h.eslClient.SendCommand(cmd)
+	if cause := parseESLErrCause(response); cause != "" {
+		return response, fmt.Errorf("%s", cause)
+	}
+	return response, err
+}
+
+// speak starts tts on the flow's call and returns a check for when it has
+// been spoken. Speech in the TTS cache is played from its file. Otherwise
+// text with spaces cannot be passed through uuid_broadcast directly, so it
+// is stored on the channel first.
+func (m *flowManager) speak(run *flowRun, tts *AnnounceTTS) (func(call *flowCall) bool, error) {
+	callUUID := run.flow.CallUUID
+	run.mu.Lock()
+	spoken := run.call.speaks
+	if file, ok := m.h.ttsCache.lookup(tts); ok {
+		played := run.call.playbacks[file]
+		run.mu.Unlock()
+		_, err := m.command(callUUID, fmt.Sprintf("api uuid_broadcast %s %s aleg", callUUID, file))
+		return func(call *flowCall) bool { return call.playbacks[file] > played }, err
+	}
+	run.mu.Unlock()
+
+	if _, err := m.command(callUUID, fmt.Sprintf("api uuid_setvar %s fsapi_flow_text %s", callUUID, tts.Text)); err != nil {
+		return nil, err
+	}
+	_, err := m.command(callUUID, fmt.Sprintf("api uuid_broadcast %s speak::%s|%s|${fsapi_flow_text} aleg", callUUID, tts.Engine, tts.Voice))
+	return func(call *flowCall) bool { return call.speaks > spoken }, err
+}
+
+// say starts say on the flow's call and returns a check for when it has
+// been said. Its arguments hold spaces, so as with speech they are stored
+// on the channel first.
+func (m *flowManager) say(run *flowRun, say *SayPrompt) (func(call *flowCall) bool, error) {
+	callUUID := run.flow.CallUUID
+	args, err := say.args()
+	if err != nil {
+		return nil, err
+	}
+	run.mu.Lock()
+	said := run.call.says
+	run.mu.Unlock()
+
+	if _, err := m.command(callUUID, fmt.Sprintf("api uuid_setvar %s fsapi_flow_say %s", callUUID, args)); err != nil {
+		return nil, err
+	}
+	_, err = m.command(callUUID, fmt.Sprintf("api uuid_broadcast %s say::${fsapi_flow_say} aleg", callUUID))
+	return func(call *flowCall) bool { return call.says > said }, err
+}
+
+// update changes the flow under mu
+func (m *flowManager) update(run *flowRun, fn func(flow *Flow)) {
+	m.mu.Lock()
+	fn(run.flow)
+	run.flow.UpdatedAt = time.Now().UTC()
+	m.mu.Unlock()
+}
+
+// runStep executes one step of the flow
+func (m *flowManager) runStep(run *flowRun, step *FlowStep, vars []string) error {
+	flow := run.flow
+	callUUID := flow.CallUUID
+	stop := m.h.jobs.stopping()
+	timeout := time.Duration(step.TimeoutSec) * time.Second
+
+	switch step.Action {
+	case flowActionOriginate:
+		dial, _ := step.Endpoint.dialString()
+		if m.h.gateways != nil {
+			defer m.h.gateways.release(callUUID)
+			if full := m.h.gateways.reserve(callUUID, dialGateways(dial)); len(full) > 0 {
+				return fmt.Errorf("gateway %s is at capacity", strings.Join(full, ", "))
+			}
+		}
+		// The originate returns once the call rings, so wait_answer and the
+		// cancel endpoint see the call while it is ringing
+		vars = append(vars,
+			"origination_uuid="+callUUID,
+			"return_ring_ready=true",
+			fmt.Sprintf("originate_timeout=%d", step.TimeoutSec),
+			"fsapi_flow_id="+flow.ID,
+		)
+		if step.CallerIDNumber != "" {
+			vars = append(vars, "origination_caller_id_number="+step.CallerIDNumber)
+		}
+		if step.CallerIDName != "" {
+			vars = append(vars, fmt.Sprintf("origination_caller_id_name='%s'", step.CallerIDName))
+		}
+		response, err := m.h.eslClient.SendCommand(fmt.Sprintf("api originate {%s}%s &park() XML %s", strings.Join(vars, ","), dial, flow.Context))
+		if cause := parseESLErrCause(response); cause != "" {
+			return fmt.Errorf("originate failed: %s", cause)
+		}
+		if err != nil {
+			return fmt.Errorf("originate failed: %v", err)
+		}
+		return nil
+
+	case flowActionWaitAnswer:
+		return run.wait(stop, timeout, func(call *flowCall) (bool, error) {
+			return call.answered, hungUp(call)
+		})
+
+	case flowActionPlayback:
+		run.mu.Lock()
+		played := run.call.playbacks[step.File]
+		run.mu.Unlock()
+		if _, err := m.command(callUUID, fmt.Sprintf("api uuid_broadcast %s %s aleg", callUUID, step.File)); err != nil {
+			return fmt.Errorf("playback failed: %v", err)
+		}
+		err := run.wait(stop, timeout, func(call *flowCall) (bool, error) {
+			return call.playbacks[step.File] > played, hungUp(call)
+		})
+		if err != nil && err != errFlowStopped && !strings.HasPrefix(err.Error(), "call hung up") {
+			// Do not let the file play on into the next step
+			m.command(callUUID, fmt.Sprintf("api uuid_break %s all", callUUID))
+		}
+		return err
+
+	case flowActionSpeak, flowActionSay:
+		var done func(call *flowCall) bool
+		var err error
+		if step.Action == flowActionSpeak {
+			done, err = m.speak(run, step.TTS)
+		} else {
+			done, err = m.say(run, step.Say)
+		}
+		if err != nil {
+			return fmt.Errorf("%s failed: %v", step.Action, err)
+		}
+		err = run.wait(stop, timeout, func(call *flowCall) (bool, error) {
+			return done(call), hungUp(call)
+		})
+		if err != nil && err != errFlowStopped && !strings.HasPrefix(err.Error(), "call hung up") {
+			m.command(callUUID, fmt.Sprintf("api uuid_break %s all", callUUID))
+		}
+		if step.Action == flowActionSpeak && flow.Verify {
+			// Do not leave the code on the channel for uuid_dump and CDRs
+			m.command(callUUID, fmt.Sprintf("api uuid_setvar %s fsapi_flow_text", callUUID))
+		}
+		return err
+
+	case flowActionCollect:
+		// Digits pressed while the prompt plays count, as with
+		// play_and_get_digits
+		run.mu.Lock()
+		pressed := len(run.call.dtmf)
+		run.mu.Unlock()
+		var err error
+		switch {
+		case step.TTS != nil:
+			_, err = m.speak(run, step.TTS)
+		case step.Say != nil:
+			_, err = m.say(run, step.Say)
+		case step.File != "":
+			_, err = m.command(callUUID, fmt.Sprintf("api uuid_broadcast %s %s aleg", callUUID, step.File))
+		}
+		if err != nil {
+			return fmt.Errorf("prompt failed: %v", err)
+		}
+		var digit string
+		err = run.wait(stop, timeout, func(call *flowCall) (bool, error) {
+			if len(call.dtmf) == pressed {
+				return false, hungUp(call)
+			}
+			digit = call.dtmf[pressed]
+			if step.Digits != "" && !strings.Contains(step.Digits, digit) {
+				return false, fmt.Errorf("pressed %s, expected one of %s", digit, step.Digits)
+			}
+			return true, nil
+		})
+		if digit != "" {
+			m.update(run, func(flow *Flow) { flow.Results[len(flow.Results)-1].Digit = digit })
+		}
+		if (step.TTS != nil || step.Say != nil || step.File != "") && err != errFlowStopped && (err == nil || !strings.HasPrefix(err.Error(), "call hung up")) {
+			// Stop the prompt if it is still playing
+			m.command(callUUID, fmt.Sprintf("api uuid_break %s all", callUUID))
+		}
+		return err
+
+	case flowActionBridge:
+		dial, _ := step.Endpoint.dialString()
+		if m.h.gateways != nil {
+			if full := m.h.gateways.full(dialGateways(dial)); len(full) > 0 {
+				return fmt.Errorf("gateway %s is at capacity", strings.Join(full, ", "))
+			}
+		}
+		run.mu.Lock()
+		bridges, ended := run.call.bridges, run.call.bridgeEnd
+		run.mu.Unlock()
+		// A failed bridge parks the call again for on_failure
+		cmd := fmt.Sprintf("api uuid_transfer %s 'set:continue_on_fail=true,bridge:[leg_timeout=%d]%s,park' %s",
+			callUUID, step.TimeoutSec, dial, dialplanInline)
+		if _, err := m.command(callUUID, cmd); err != nil {
+			return fmt.Errorf("bridge failed: %v", err)
+		}
+		return run.wait(stop, timeout+5*time.Second, func(call *flowCall) (bool, error) {
+			if call.bridges > bridges {
+				return true, nil
+			}
+			if call.bridgeEnd > ended {
+				return false, fmt.Errorf("bridge failed: %s", strings.TrimPrefix(call.bridgeErr, "-ERR "))
+			}
+			return false, hungUp(call)
+		})
+
+	case flowActionHangup:
+		cause := step.Cause
+		if cause == "" {
+			cause = m.h.defaults.hangupCauseFor(flow.Context)
+		}
+		if _, err := m.command(callUUID, fmt.Sprintf("api uuid_kill %s %s", callUUID, cause)); err != nil {
+			return fmt.Errorf("hangup failed: %v", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown action %s", step.Action)
+}
+
+// run executes the flow's steps in order until one fails without
+// on_failure continue, the flow is canceled or fs-api shuts down
+func (m *flowManager) run(run *flowRun, vars []string) {
+	defer close(run.done)
+	callUUID := run.flow.CallUUID
+	unsubscribe := m.h.events.subscribe(func(ev *Event) { run.handleEvent(callUUID, ev) })
+	defer unsubscribe()
+
+	status, flowErr := flowStatusCompleted, ""
+	for i := range run.flow.Steps {
+		step := &run.flow.Steps[i]
+		m.update(run, func(flow *Flow) {
+			flow.Step = i
+			flow.Results = append(flow.Results, FlowStepResult{Step: i, Action: step.Action, Status: flowStatusRunning, StartedAt: time.Now().UTC()})
+		})
+
+		err := m.runStep(run, step, vars)
+		stepStatus := flowStatusCompleted
+		if err == errFlowStopped {
+			stepStatus = flowStatusInterrupted
+			select {
+			case <-run.cancel:
+				stepStatus = flowStatusCanceled
+			default:
+			}
+		} else if err != nil {
+			stepStatus = flowStatusFailed
+		}
+		m.update(run, func(flow *Flow) {
+			now := time.Now().UTC()
+			result := &flow.Results[len(flow.Results)-1]
+			result.Status, result.EndedAt = stepStatus, &now
+			if stepStatus == flowStatusFailed {
+				result.Error = err.Error()
+			}
+		})
+		if err == nil {
+			continue
+		}
+
+		if err == errFlowStopped {
+			status, flowErr = stepStatus, ""
+			if status == flowStatusInterrupted {
+				flowErr = "fs-api shut down"
+			} else if run.hangup {
+				m.command(callUUID, fmt.Sprintf("api uuid_kill %s %s", callUUID, m.h.defaults.hangupCauseFor(run.flow.Context)))
+			}
+			break
+		}
+
+		status, flowErr = flowStatusFailed, fmt.Sprintf("step %d (%s): %v", i, step.Action, err)
+		run.mu.Lock()
+		gone := run.call.hangup != ""
+		run.mu.Unlock()
+		if step.Action == flowActionOriginate || gone {
+			break
+		}
+		switch step.OnFailure {
+		case flowOnFailureContinue:
+			status, flowErr = flowStatusCompleted, ""
+			continue
+		case flowOnFailureHangup:
+			m.command(callUUID, fmt.Sprintf("api uuid_kill %s %s", callUUID, m.h.defaults.hangupCauseFor(run.flow.Context)))
+		case flowOnFailureTransfer:
+			if _, err := m.command(callUUID, fmt.Sprintf("api uuid_transfer %s %s XML %s", callUUID, step.FailureDestination, run.flow.Context)); err != nil {
+				flowErr += fmt.Sprintf("; transfer to %s failed: %v", step.FailureDestination, err)
+			}
+		}
+		break
+	}
+
+	var flow Flow
+	m.update(run, func(f *Flow) {
+		now := time.Now().UTC()
+		f.Status, f.Error, f.EndedAt = status, flowErr, &now
+		flow = run.snapshot()
+	})
+	m.ended.inc(status)
+	log.Printf("Flow %s on call %s %s", flow.ID, callUUID, status)
+	if status != flowStatusInterrupted {
+		m.h.webhooks.dispatch("flow."+status, flow.Context, flow)
+	}
+	if flow.Verify {
+		m.verifyEnded(flow)
+	}
+	if flow.Survey != "" {
+		m.h.surveys.flowEnded(flow)
+	}
+}
+
+// cancelRun stops the flow, hanging up its call when hangup is set
+func (run *flowRun) cancelRun(hangup bool) {
+	run.cancelOnce.Do(func() {
+		run.hangup = hangup
+		close(run.cancel)
+	})
+}
+
+// lookupFlow resolves {id}, hiding flows of other tenants
+func (h *APIHandler) lookupFlow(w http.ResponseWriter, r *http.Request) (*flowRun, bool) {
+	id := mux.Vars(r)["id"]
+	h.flows.mu.Lock()
+	run, ok := h.flows.runs[id]
+	h.flows.mu.Unlock()
+	if !ok || !isContextAllowed(r, run.flow.Context) {
+		h.respondError(w, r, fmt.Sprintf("Flow %s not found", id), http.StatusNotFound)
+		return nil, false
+	}
+	return run, true
+}
+
+// flowsEnabled responds 501 without the event stream that drives flows
+func (h *APIHandler) flowsEnabled(w http.ResponseWriter, r *http.Request) bool {
+	if h.eventHistory == nil {
+		h.respondError(w, r, "Flows require FSAPI_EVENTS=true", http.StatusNotImplemented)
+		return false
+	}
+	return true
+}
+
+// --- Flow handlers ---
+
+// POST /v1/flows
+//
+// The checks of POST /v1/calls/originate are applied to the whole flow up
+// front, so a flow is not refused halfway through.
+func (h *APIHandler) CreateFlow(w http.ResponseWriter, r *http.Request) {
+	if !h.flowsEnabled(w, r) {
+		return
+	}
+	var req FlowRequest
+	if !h.decodeRequest(w, r, &req) {
+		return
+	}
+	if flow, ok := h.startFlow(w, r, &req, ""); ok {
+		h.respondJSON(w, r, map[string]interface{}{
+			"status": "success",
+			"data":   flow,
+		})
+	}
+}
+
+// startFlow checks req and starts it, returning the new flow. template names
+// the flow template it was rendered from, if any. On failure it has
+// already responded.
+func (h *APIHandler) startFlow(w http.ResponseWriter, r *http.Request, req *FlowRequest, template string) (Flow, bool) {
+	loc, ok := h.requestLocale(w, r, req.Locale, req.Timezone)
+	if !ok {
+		return Flow{}, false
+	}
+	for i := range req.Steps {
+		if e := req.Steps[i].Endpoint; e != nil && e.Type == dialTypeGateway {
+			e.Number = h.normalizeNumber(e.Number, req.Context)
+		}
+		h.localize(loc, req.Steps[i].TTS, req.Steps[i].Say)
+	}
+	if err := req.validate(); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return Flow{}, false
+	}
+	if !h.validateRequestContext(w, r, req.Context) {
+		return Flow{}, false
+	}
+
+	var numbers []string
+	for _, step := range req.Steps {
+		if step.Endpoint == nil {
+			continue
+		}
+		if step.Endpoint.Type == dialTypeUser && !h.validateCCDomainRaw(w, r, step.Endpoint.Domain, "User") {
+			return Flow{}, false
+		}
+		dial, _ := step.Endpoint.dialString()
+		numbers = append(numbers, dialedNumbers(dial)...)
+		if step.Endpoint.Number != "" {
+			numbers = append(numbers, step.Endpoint.Number)
+		}
+	}
+	if !h.screenDestinations(w, r, "flow", numbers, blocklistContexts(r, req.Context)) {
+		return Flow{}, false
+	}
+	if !h.checkOriginateAllowed(w, r) {
+		return Flow{}, false
+	}
+	originate := req.Steps[0]
+	if !h.checkCallerIDs(w, r, &OriginateRequest{Context: req.Context, CallerIDNumber: originate.CallerIDNumber, CallerIDName: originate.CallerIDName}) {
+		return Flow{}, false
+	}
+	if ev := h.hours.evaluate(req.Context); ev != nil && !ev.Open && ev.Originate == hoursActionReject {
+		h.respondClosed(w, r, ev)
+		return Flow{}, false
+	}
+
+	now := time.Now().UTC()
+	run := newFlowRun(&Flow{
+		ID:        uuid.New().String(),
+		Context:   req.Context,
+		Template:  template,
+		Verify:    req.verify,
+		Status:    flowStatusRunning,
+		CallUUID:  uuid.New().String(),
+		Steps:     req.Steps,
+		Results:   []FlowStepResult{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	if loc != nil {
+		run.flow.Locale, run.flow.Timezone = loc.tag, loc.timezone
+	}
+	var vars []string
+	for _, kv := range h.claimCall(r, run.flow.CallUUID) {
+		vars = append(vars, fmt.Sprintf("%s=%s", kv[0], kv[1]))
+	}
+	for _, kv := range loc.vars(nil) {
+		vars = append(vars, fmt.Sprintf("%s=%s", kv[0], kv[1]))
+	}
+
+	if !h.flows.launch(run, vars) {
+		h.respondError(w, r, "fs-api is shutting down", http.StatusServiceUnavailable)
+		return Flow{}, false
+	}
+	h.flows.mu.Lock()
+	flow := run.snapshot()
+	h.flows.mu.Unlock()
+
+	logInfo(getRequestID(r), fmt.Sprintf("Started flow %s (%d steps) on call %s", flow.ID, len(flow.Steps), flow.CallUUID))
+	return flow, true
+}
+
+// GET /v1/flows
+func (h *APIHandler) ListFlows(w http.ResponseWriter, r *http.Request) {
+	contextFilter := r.URL.Query().Get("context")
+	statusFilter := r.URL.Query().Get("status")
+	h.flows.mu.Lock()
+	rows := []Flow{}
+	for _, run := range h.flows.runs {
+		if contextFilter != "" && run.flow.Context != contextFilter {
+			continue
+		}
+		if statusFilter != "" && run.flow.Status != statusFilter {
+			continue
+		}
+		if isContextAllowed(r, run.flow.Context) {
+			rows = append(rows, run.snapshot())
+		}
+	}
+	h.flows.mu.Unlock()
+	sort.Slice(rows, func(i, j int) bool { return rows[i].CreatedAt.Before(rows[j].CreatedAt) })
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status":    "success",
+		"row_count": len(rows),
+		"rows":      rows,
+	})
+}
+
+// GET /v1/flows/{id}
+func (h *APIHandler) GetFlow(w http.ResponseWriter, r *http.Request) {
+	run, ok := h.lookupFlow(w, r)
+	if !ok {
+		return
+	}
+	h.flows.mu.Lock()
+	flow := run.snapshot()
+	h.flows.mu.Unlock()
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   flow,
+	})
+}
+
+// FlowCancelRequest is the optional body of POST /v1/flows/{id}/cancel
+type FlowCancelRequest struct {
+	Hangup *bool `json:"hangup,omitempty"` // Hang the call up; default true
+}
+
+// POST /v1/flows/{id}/cancel
+func (h *APIHandler) CancelFlow(w http.ResponseWriter, r *http.Request) {
+	run, ok := h.lookupFlow(w, r)
+	if !ok {
+		return
+	}
+	var req FlowCancelRequest
+	if !h.decodeOptionalRequest(w, r, &req) {
+		return
+	}
+	h.flows.mu.Lock()
+	status := run.flow.Status
+	h.flows.mu.Unlock()
+	if status != flowStatusRunning {
+		h.respondError(w, r, fmt.Sprintf("Flow %s already %s", run.flow.ID, status), http.StatusConflict)
+		return
+	}
+
+	run.cancelRun(req.Hangup == nil || *req.Hangup)
+	select {
+	case <-run.done:
+	case <-time.After(flowCancelWait):
+	}
+	h.flows.mu.Lock()
+	flow := run.snapshot()
+	h.flows.mu.Unlock()
+
+	message := fmt.Sprintf("Flow %s canceled", flow.ID)
+	if flow.Status != flowStatusCanceled {
+		message = fmt.Sprintf("Flow %s %s before it could be canceled", flow.ID, flow.Status)
+		if flow.Status == flowStatusRunning {
+			message = fmt.Sprintf("Flow %s is being canceled", flow.ID)
+		}
+	}
+	logInfo(getRequestID(r), message)
+	h.respondJSON(w, r, SuccessResponse{
+		Status:  "success",
+		Message: message,
+		Data:    flow,
+	})
+}
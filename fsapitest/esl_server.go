@@ -0,0 +1,225 @@
+// Package fsapitest provides a contract test harness for integrations built
+// on fs-api. It runs a scripted FreeSWITCH ESL server that records every
+// command fs-api issues, and starts a real fs-api process pointed at it, so
+// downstream tests exercise the actual HTTP behavior without a switch.
+package fsapitest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Reply is a scripted response for ESL api commands matching a pattern.
+type Reply struct {
+	pattern *regexp.Regexp
+	body    string
+	times   int // remaining uses; <= 0 means unlimited
+}
+
+// Respond sets the api/response body returned for matching commands.
+func (r *Reply) Respond(body string) *Reply {
+	r.body = body
+	return r
+}
+
+// Times limits the reply to the next n matching commands.
+func (r *Reply) Times(n int) *Reply {
+	r.times = n
+	return r
+}
+
+// ESLServer is a scripted FreeSWITCH inbound event socket. Commands are
+// matched against replies in the order they were registered; unmatched
+// commands get "-ERR no scripted reply".
+type ESLServer struct {
+	Addr     string
+	Password string
+
+	t        testing.TB
+	ln       net.Listener
+	mu       sync.Mutex
+	replies  []*Reply
+	commands []string
+	wg       sync.WaitGroup
+}
+
+// NewESLServer starts a scripted ESL server on a random localhost port. It is
+// shut down automatically when the test finishes.
+func NewESLServer(t testing.TB) *ESLServer {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("fsapitest: listen: %v", err)
+	}
+
+	s := &ESLServer{
+		Addr:     ln.Addr().String(),
+		Password: "ClueCon",
+		t:        t,
+		ln:       ln,
+	}
+
+	// Defaults needed by fs-api itself (health check, status endpoint)
+	s.On(`^status$`).Respond("UP 0 years, 0 days, 0 hours, 0 minutes, 1 second\nFreeSWITCH (Version fsapitest) is ready\n")
+	s.On(`^json \{"command":"status"`).Respond(`{"command":"status","status":"success","response":{"systemStatus":"ready","version":"fsapitest"}}`)
+
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// On registers a reply for api commands (without the "api " prefix) matching
+// the regular expression. Later registrations take precedence so tests can
+// override the defaults.
+func (s *ESLServer) On(pattern string) *Reply {
+	r := &Reply{pattern: regexp.MustCompile(pattern), body: "+OK\n"}
+	s.mu.Lock()
+	s.replies = append([]*Reply{r}, s.replies...)
+	s.mu.Unlock()
+	return r
+}
+
+// Commands returns every api command received so far, without the "api "
+// prefix, in order.
+func (s *ESLServer) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+// AssertCommand fails the test unless a command matching the regular
+// expression was issued.
+func (s *ESLServer) AssertCommand(t testing.TB, pattern string) {
+	t.Helper()
+	re := regexp.MustCompile(pattern)
+	for _, cmd := range s.Commands() {
+		if re.MatchString(cmd) {
+			return
+		}
+	}
+	t.Errorf("fsapitest: no ESL command matching %q; got %q", pattern, s.Commands())
+}
+
+// AssertNoCommand fails the test if any command matching the regular
+// expression was issued.
+func (s *ESLServer) AssertNoCommand(t testing.TB, pattern string) {
+	t.Helper()
+	re := regexp.MustCompile(pattern)
+	for _, cmd := range s.Commands() {
+		if re.MatchString(cmd) {
+			t.Errorf("fsapitest: unexpected ESL command %q matching %q", cmd, pattern)
+		}
+	}
+}
+
+// Reset forgets recorded commands (replies are kept).
+func (s *ESLServer) Reset() {
+	s.mu.Lock()
+	s.commands = nil
+	s.mu.Unlock()
+}
+
+// Close stops the server and waits for connections to finish.
+func (s *ESLServer) Close() {
+	s.ln.Close()
+	s.wg.Wait()
+}
+
+func (s *ESLServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+		}()
+	}
+}
+
+// handle speaks the inbound ESL protocol: auth handshake, then one reply per
+// command block.
+func (s *ESLServer) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := textproto.NewReader(bufio.NewReader(conn))
+
+	write := func(format string, args ...interface{}) bool {
+		_, err := fmt.Fprintf(conn, format, args...)
+		return err == nil
+	}
+
+	if !write("Content-Type: auth/request\n\n") {
+		return
+	}
+
+	authed := false
+	for {
+		line, err := reader.ReadLine()
+		if err != nil {
+			return
+		}
+		// Commands are terminated by a blank line; skip any extra headers
+		for {
+			extra, err := reader.ReadLine()
+			if err != nil || extra == "" {
+				break
+			}
+		}
+
+		switch {
+		case strings.HasPrefix(line, "auth "):
+			if strings.TrimPrefix(line, "auth ") != s.Password {
+				write("Content-Type: command/reply\nReply-Text: -ERR invalid\n\n")
+				write("Content-Type: text/disconnect-notice\nContent-Length: 0\n\n")
+				return
+			}
+			authed = true
+			write("Content-Type: command/reply\nReply-Text: +OK accepted\n\n")
+		case !authed:
+			write("Content-Type: command/reply\nReply-Text: -ERR command not allowed\n\n")
+		case line == "exit":
+			write("Content-Type: command/reply\nReply-Text: +OK bye\n\n")
+			return
+		case strings.HasPrefix(line, "api "):
+			body := s.reply(strings.TrimPrefix(line, "api "))
+			write("Content-Type: api/response\nContent-Length: %d\n\n%s", len(body), body)
+		default:
+			// event/log/filter and friends
+			write("Content-Type: command/reply\nReply-Text: +OK\n\n")
+		}
+	}
+}
+
+func (s *ESLServer) reply(cmd string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commands = append(s.commands, cmd)
+
+	for _, r := range s.replies {
+		if !r.pattern.MatchString(cmd) {
+			continue
+		}
+		if r.times < 0 {
+			continue // exhausted
+		}
+		if r.times > 0 {
+			r.times--
+			if r.times == 0 {
+				r.times = -1
+			}
+		}
+		return r.body
+	}
+	return "-ERR no scripted reply\n"
+}
@@ -0,0 +1,156 @@
+package fsapitest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// eslConn is a minimal inbound ESL client for driving ESLServer
+type eslConn struct {
+	conn   net.Conn
+	reader *textproto.Reader
+}
+
+func dialESL(t *testing.T, s *ESLServer) *eslConn {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", s.Addr, 2*time.Second)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	t.Cleanup(func() { conn.Close() })
+	c := &eslConn{conn: conn, reader: textproto.NewReader(bufio.NewReader(conn))}
+	if h := c.read(t); h.Get("Content-Type") != "auth/request" {
+		t.Fatalf("expected auth/request, got %v", h)
+	}
+	return c
+}
+
+// read returns the headers of the next message; a body is returned under
+// the "Body" key
+func (c *eslConn) read(t *testing.T) textproto.MIMEHeader {
+	t.Helper()
+	h, err := c.reader.ReadMIMEHeader()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if n, _ := strconv.Atoi(h.Get("Content-Length")); n > 0 {
+		body := make([]byte, n)
+		if _, err := io.ReadFull(c.reader.R, body); err != nil {
+			t.Fatalf("read body: %v", err)
+		}
+		h.Set("Body", string(body))
+	}
+	return h
+}
+
+func (c *eslConn) send(t *testing.T, cmd string) textproto.MIMEHeader {
+	t.Helper()
+	if _, err := fmt.Fprintf(c.conn, "%s\n\n", cmd); err != nil {
+		t.Fatalf("send %q: %v", cmd, err)
+	}
+	return c.read(t)
+}
+
+func TestESLServerAuth(t *testing.T) {
+	s := NewESLServer(t)
+
+	c := dialESL(t, s)
+	if h := c.send(t, "api status"); h.Get("Reply-Text") != "-ERR command not allowed" {
+		t.Errorf("api before auth: got %v", h)
+	}
+	if h := c.send(t, "auth wrong"); h.Get("Reply-Text") != "-ERR invalid" {
+		t.Errorf("wrong password: got %v", h)
+	}
+	if h := c.read(t); h.Get("Content-Type") != "text/disconnect-notice" {
+		t.Errorf("expected disconnect notice, got %v", h)
+	}
+
+	c = dialESL(t, s)
+	if h := c.send(t, "auth "+s.Password); h.Get("Reply-Text") != "+OK accepted" {
+		t.Fatalf("auth: got %v", h)
+	}
+	if h := c.send(t, "event plain ALL"); h.Get("Reply-Text") != "+OK" {
+		t.Errorf("event: got %v", h)
+	}
+	if h := c.send(t, "exit"); h.Get("Reply-Text") != "+OK bye" {
+		t.Errorf("exit: got %v", h)
+	}
+}
+
+func TestESLServerReplies(t *testing.T) {
+	s := NewESLServer(t)
+	s.On(`^uuid_kill `).Respond("+OK\n")
+	s.On(`^uuid_kill dead`).Respond("-ERR No such channel!\n").Times(1)
+
+	c := dialESL(t, s)
+	c.send(t, "auth "+s.Password)
+
+	tests := []struct {
+		cmd  string
+		want string
+	}{
+		{"status", "UP 0 years, 0 days, 0 hours, 0 minutes, 1 second\nFreeSWITCH (Version fsapitest) is ready\n"},
+		// Latest registration first, until its uses run out
+		{"uuid_kill dead", "-ERR No such channel!\n"},
+		{"uuid_kill dead", "+OK\n"},
+		{"uuid_kill live", "+OK\n"},
+		{"show channels", "-ERR no scripted reply\n"},
+	}
+	for _, tt := range tests {
+		h := c.send(t, "api "+tt.cmd)
+		if h.Get("Content-Type") != "api/response" {
+			t.Errorf("%s: Content-Type %q", tt.cmd, h.Get("Content-Type"))
+		}
+		if got := h.Get("Body"); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+
+	cmds := s.Commands()
+	if len(cmds) != len(tests) || cmds[0] != "status" || cmds[4] != "show channels" {
+		t.Errorf("Commands() = %q", cmds)
+	}
+	s.AssertCommand(t, `^uuid_kill live$`)
+	s.AssertNoCommand(t, `^originate `)
+
+	s.Reset()
+	if cmds := s.Commands(); len(cmds) != 0 {
+		t.Errorf("Commands() after Reset = %q", cmds)
+	}
+	if got := c.send(t, "api uuid_kill dead").Get("Body"); got != "+OK\n" {
+		t.Errorf("replies should survive Reset, got %q", got)
+	}
+}
+
+// failRecorder notes failures instead of failing the test
+type failRecorder struct {
+	testing.TB
+	failed bool
+}
+
+func (r *failRecorder) Errorf(format string, args ...interface{}) { r.failed = true }
+
+func TestESLServerAssertions(t *testing.T) {
+	s := NewESLServer(t)
+	c := dialESL(t, s)
+	c.send(t, "auth "+s.Password)
+	c.send(t, "api originate sofia/internal/1000 &park()")
+
+	missing := &failRecorder{TB: t}
+	s.AssertCommand(missing, `^uuid_kill `)
+	if !missing.failed {
+		t.Error("AssertCommand passed without a matching command")
+	}
+	unexpected := &failRecorder{TB: t}
+	s.AssertNoCommand(unexpected, `^originate `)
+	if !unexpected.failed {
+		t.Error("AssertNoCommand passed with a matching command")
+	}
+}
@@ -0,0 +1,202 @@
+package fsapitest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Server is a running fs-api process wired to a scripted ESLServer.
+//
+// fs-api is built as package main and cannot be imported, so the harness runs
+// the real binary. It is located via WithBinary, the FSAPI_BINARY environment
+// variable, or "fs-api" on PATH, in that order. A missing binary fails the
+// test unless SkipWithoutBinary is given.
+type Server struct {
+	URL string
+	ESL *ESLServer
+
+	cmd    *exec.Cmd
+	client *http.Client
+}
+
+type config struct {
+	binary       string
+	env          map[string]string
+	skipNoBinary bool
+}
+
+// Option customizes Start.
+type Option func(*config)
+
+// WithBinary sets the fs-api binary to run.
+func WithBinary(path string) Option {
+	return func(c *config) { c.binary = path }
+}
+
+// SkipWithoutBinary skips the test instead of failing it when no fs-api
+// binary can be found, for suites that also run where fs-api is not built.
+func SkipWithoutBinary() Option {
+	return func(c *config) { c.skipNoBinary = true }
+}
+
+// WithEnv sets an extra environment variable for the fs-api process, e.g.
+// WithEnv("FSAPI_AUTH_TOKENS", "secret").
+func WithEnv(key, value string) Option {
+	return func(c *config) { c.env[key] = value }
+}
+
+// Start launches fs-api against a new scripted ESL server and waits until its
+// health endpoint answers. Everything is torn down when the test finishes.
+func Start(t testing.TB, opts ...Option) *Server {
+	t.Helper()
+
+	cfg := &config{binary: os.Getenv("FSAPI_BINARY"), env: map[string]string{}}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.binary == "" {
+		path, err := exec.LookPath("fs-api")
+		if err != nil {
+			if cfg.skipNoBinary {
+				t.Skip("fsapitest: fs-api binary not found; set FSAPI_BINARY or use WithBinary")
+			}
+			t.Fatal("fsapitest: fs-api binary not found; set FSAPI_BINARY or use WithBinary")
+		}
+		cfg.binary = path
+	}
+
+	esl := NewESLServer(t)
+	eslHost, eslPort, _ := net.SplitHostPort(esl.Addr)
+	port := freePort(t)
+
+	cmd := exec.Command(cfg.binary)
+	cmd.Env = append(os.Environ(),
+		"FSAPI_PORT="+port,
+		"ESL_HOST="+eslHost,
+		"ESL_PORT="+eslPort,
+		"ESL_PASSWORD="+esl.Password,
+		// Each run gets its own store
+		"FSAPI_DATA_DIR="+t.TempDir(),
+	)
+	for k, v := range cfg.env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	var logs bytes.Buffer
+	cmd.Stdout = &logs
+	cmd.Stderr = &logs
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("fsapitest: start %s: %v", cfg.binary, err)
+	}
+
+	s := &Server{
+		URL:    "http://127.0.0.1:" + port,
+		ESL:    esl,
+		cmd:    cmd,
+		client: &http.Client{Timeout: 15 * time.Second},
+	}
+	t.Cleanup(func() {
+		cmd.Process.Signal(os.Interrupt)
+		done := make(chan struct{})
+		go func() { cmd.Wait(); close(done) }()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			cmd.Process.Kill()
+		}
+		if t.Failed() {
+			t.Logf("fs-api output:\n%s", logs.String())
+		}
+	})
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		resp, err := s.client.Get(s.URL + "/health")
+		if err == nil {
+			resp.Body.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("fsapitest: fs-api did not become ready: %v\n%s", err, logs.String())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	esl.Reset()
+	return s
+}
+
+// Response is a decoded fs-api JSON response.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       map[string]interface{}
+	Raw        []byte
+}
+
+// Do sends a request to fs-api. body may be nil, a string, or any value that
+// is JSON-encoded. headers are given as alternating name/value pairs.
+func (s *Server) Do(t testing.TB, method, path string, body interface{}, headers ...string) *Response {
+	t.Helper()
+
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		reader = strings.NewReader(b)
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			t.Fatalf("fsapitest: encode body: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, s.URL+path, reader)
+	if err != nil {
+		t.Fatalf("fsapitest: new request: %v", err)
+	}
+	if reader != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		t.Fatalf("fsapitest: %s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	raw, _ := io.ReadAll(resp.Body)
+	out := &Response{StatusCode: resp.StatusCode, Header: resp.Header, Raw: raw}
+	json.Unmarshal(raw, &out.Body)
+	return out
+}
+
+// ExpectStatus fails the test unless the response has the given status code.
+func (r *Response) ExpectStatus(t testing.TB, code int) *Response {
+	t.Helper()
+	if r.StatusCode != code {
+		t.Errorf("fsapitest: expected HTTP %d, got %d: %s", code, r.StatusCode, r.Raw)
+	}
+	return r
+}
+
+func freePort(t testing.TB) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("fsapitest: reserve port: %v", err)
+	}
+	defer ln.Close()
+	return fmt.Sprint(ln.Addr().(*net.TCPAddr).Port)
+}
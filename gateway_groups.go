@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// parseGatewayGroups parses FSAPI_GATEWAY_GROUPS: "group=gw1|gw2,other=gw3|gw4"
+func parseGatewayGroups(value string) (map[string][]string, error) {
+	values, err := parseContextValues(value, func(v string) error {
+		for _, gw := range strings.Split(v, "|") {
+			if !isValidName(gw) {
+				return fmt.Errorf("%q is not a gateway name", gw)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	groups := make(map[string][]string, len(values))
+	for name, v := range values {
+		groups[name] = strings.Split(v, "|")
+	}
+	return groups, nil
+}
+
+// parseFailoverCauses parses FSAPI_GATEWAY_FAILOVER_CAUSES
+func parseFailoverCauses(value string) ([]string, error) {
+	causes := splitCSV(value)
+	for _, cause := range causes {
+		if err := checkHangupCause(cause); err != nil {
+			return nil, err
+		}
+	}
+	return causes, nil
+}
+
+// checkGatewayGroupTarget validates the aleg_endpoint of a gateway_group
+// originate: the group picks the gateway, the endpoint only gives the number
+func checkGatewayGroupTarget(t *DialTarget) error {
+	if t == nil {
+		return fmt.Errorf("gateway_group requires aleg_endpoint with the number to dial")
+	}
+	if t.Type != dialTypeGateway || t.Gateway != "" || t.User != "" || t.Domain != "" {
+		return fmt.Errorf("gateway_group takes an aleg_endpoint of type gateway with only a number")
+	}
+	if !dialNumberPattern.MatchString(t.Number) {
+		return fmt.Errorf("aleg_endpoint: number must be digits, '*' or '#', optionally with a leading '+'")
+	}
+	return nil
+}
+
+// gatewayUp reports whether "sofia status gateway <name>" shows the gateway
+// as up. Unknown gateways and gateways failing their OPTIONS pings are down.
+func (h *APIHandler) gatewayUp(gateway string) (bool, error) {
+	response, err := h.eslClient.SendCommand("api sofia status gateway " + gateway)
+	if err != nil {
+		return false, err
+	}
+	status := parseSofiaProfileStatus(response)["status"]
+	return strings.HasPrefix(status, "UP"), nil
+}
+
+// gatewayCandidates orders the gateways of group for a call: the healthy
+// ones below their cap, least busy first and in configured order on a tie.
+// full lists the healthy gateways left out for their cap.
+func (h *APIHandler) gatewayCandidates(group []string) (candidates, full []string, err error) {
+	load := map[string]int{}
+	for _, gw := range group {
+		up, err := h.gatewayUp(gw)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !up {
+			log.Printf("Gateway group: skipping %s, which is down", gw)
+			continue
+		}
+		if h.gateways != nil {
+			h.gateways.mu.Lock()
+			u := h.gateways.usage(gw)
+			h.gateways.mu.Unlock()
+			if u.Available != nil && *u.Available == 0 {
+				full = append(full, gw)
+				continue
+			}
+			load[gw] = u.Calls + u.Pending
+		}
+		candidates = append(candidates, gw)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return load[candidates[i]] < load[candidates[j]] })
+	return candidates, full, nil
+}
+
+// isFailoverCause reports whether a call failing with cause moves on to the
+// next gateway of its group
+func (h *APIHandler) isFailoverCause(cause string) bool {
+	return containsString(h.failoverCauses, cause)
+}
+
+// respondNoGateway writes the 503 for a gateway group without a gateway up
+func (h *APIHandler) respondNoGateway(w http.ResponseWriter, r *http.Request, group string) {
+	h.respondErrorBody(w, r, ErrorResponse{
+		Status:  "error",
+		Message: fmt.Sprintf("No gateway of group %s is up", group),
+		Code:    ErrCodeGatewayUnavailable,
+	}, http.StatusServiceUnavailable)
+}
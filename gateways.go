@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Gateways named by a dial string, e.g. in every route of an LCR failover list
+var dialGatewayPattern = regexp.MustCompile(`sofia/gateway/([A-Za-z0-9_.-]{1,64})/`)
+
+// Suggested wait before retrying an originate refused by a gateway cap
+const gatewayCapRetryAfter = 5
+
+func checkGatewayCap(v string) error {
+	if n, err := strconv.Atoi(v); err != nil || n <= 0 {
+		return fmt.Errorf("%q is not a positive number of calls", v)
+	}
+	return nil
+}
+
+// dialGateways returns the gateways a dial string goes out through, in
+// order and without duplicates
+func dialGateways(dial string) []string {
+	var gateways []string
+	for _, m := range dialGatewayPattern.FindAllStringSubmatch(dial, -1) {
+		if !containsString(gateways, m[1]) {
+			gateways = append(gateways, m[1])
+		}
+	}
+	return gateways
+}
+
+// eventGateway returns the gateway of a channel: the one an outbound leg was
+// dialed through, or the one an inbound call arrived from
+func eventGateway(ev *Event) string {
+	if gw := ev.Var("sip_gateway_name"); gw != "" {
+		return gw
+	}
+	if rest, ok := strings.CutPrefix(ev.Get("Channel-Name"), "sofia/gateway/"); ok {
+		gw, _, _ := strings.Cut(rest, "/")
+		return gw
+	}
+	return ""
+}
+
+// GatewayUsage is the current load of one sofia gateway
+type GatewayUsage struct {
+	Gateway   string `json:"gateway"`
+	Calls     int    `json:"calls"`   // Inbound plus outbound channels
+	Inbound   int    `json:"inbound"` // Channels from the gateway
+	Outbound  int    `json:"outbound"`
+	Pending   int    `json:"pending"`             // Originates admitted whose channel was not created yet
+	Cap       *int   `json:"cap,omitempty"`       // FSAPI_GATEWAY_CAPS limit
+	Available *int   `json:"available,omitempty"` // Calls left under the cap
+}
+
+// gatewayChannel is a live gateway channel as the tracker knows it
+type gatewayChannel struct {
+	gateway  string
+	outbound bool
+}
+
+// gatewayUsage counts the channels of every sofia gateway from channel
+// events and enforces the per-gateway call caps of FSAPI_GATEWAY_CAPS on
+// originate
+type gatewayUsage struct {
+	h    *APIHandler
+	caps map[string]string // Gateway -> calls, "*" default
+
+	mu       sync.Mutex
+	channels map[string]gatewayChannel // UUID -> channel
+	pending  map[string][]string       // Call UUID -> gateways reserved until its channel shows up
+
+	calls    *counterVec
+	rejected *counterVec
+}
+
+func newGatewayUsage(h *APIHandler, caps map[string]string) *gatewayUsage {
+	return &gatewayUsage{
+		h:        h,
+		caps:     caps,
+		channels: make(map[string]gatewayChannel),
+		pending:  make(map[string][]string),
+		calls:    h.metrics.gauge("fsapi_gateway_calls", "Concurrent channels by sofia gateway.", "gateway"),
+		rejected: h.metrics.counter("fsapi_gateway_cap_rejections_total", "Originates refused because the gateway was at its cap, by gateway.", "gateway"),
+	}
+}
+
+// capFor returns the call cap of gateway, if one is set
+func (g *gatewayUsage) capFor(gateway string) (int, bool) {
+	v, ok := g.caps[gateway]
+	if !ok {
+		v, ok = g.caps["*"]
+	}
+	if !ok {
+		return 0, false
+	}
+	n, _ := strconv.Atoi(v)
+	return n, true
+}
+
+// handleEvent is the event bus subscriber
+func (g *gatewayUsage) handleEvent(ev *Event) {
+	uuid := ev.UUID()
+	if uuid == "" {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	switch ev.Name {
+	case "CHANNEL_CREATE":
+		// The reservation is replaced by the channel itself
+		delete(g.pending, uuid)
+		gw := eventGateway(ev)
+		if gw == "" {
+			return
+		}
+		if _, known := g.channels[uuid]; known {
+			return
+		}
+		g.channels[uuid] = gatewayChannel{gateway: gw, outbound: ev.Get("Call-Direction") == "outbound"}
+		g.calls.set(float64(g.usage(gw).Calls), gw)
+	case "CHANNEL_HANGUP_COMPLETE", "CHANNEL_DESTROY":
+		ch, known := g.channels[uuid]
+		if !known {
+			return
+		}
+		delete(g.channels, uuid)
+		g.calls.set(float64(g.usage(ch.gateway).Calls), ch.gateway)
+	}
+}
+
+// usage computes the load of gateway. Caller must hold mu.
+func (g *gatewayUsage) usage(gateway string) GatewayUsage {
+	u := GatewayUsage{Gateway: gateway}
+	for _, ch := range g.channels {
+		if ch.gateway != gateway {
+			continue
+		}
+		if ch.outbound {
+			u.Outbound++
+		} else {
+			u.Inbound++
+		}
+	}
+	u.Calls = u.Inbound + u.Outbound
+	for _, gateways := range g.pending {
+		if containsString(gateways, gateway) {
+			u.Pending++
+		}
+	}
+	if limit, ok := g.capFor(gateway); ok {
+		available := max(limit-u.Calls-u.Pending, 0)
+		u.Cap, u.Available = &limit, &available
+	}
+	return u
+}
+
+// full returns those of gateways that are at their cap
+func (g *gatewayUsage) full(gateways []string) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.atCap(gateways)
+}
+
+// atCap returns those of gateways that are at their cap. Caller must hold mu.
+func (g *gatewayUsage) atCap(gateways []string) []string {
+	var full []string
+	for _, gw := range gateways {
+		if u := g.usage(gw); u.Available != nil && *u.Available == 0 {
+			full = append(full, gw)
+		}
+	}
+	return full
+}
+
+// reserve admits an originate of callUUID through gateways, holding a slot
+// on each until the call's channel is created or release is called. It
+// returns the gateways at their cap instead when there are any.
+func (g *gatewayUsage) reserve(callUUID string, gateways []string) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if full := g.atCap(gateways); len(full) > 0 {
+		return full
+	}
+	g.pending[callUUID] = gateways
+	return nil
+}
+
+// release drops the reservation of callUUID once its originate returned
+func (g *gatewayUsage) release(callUUID string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.pending, callUUID)
+}
+
+// run drops channels whose destroy event was missed until shutdown starts
+func (g *gatewayUsage) run() {
+	ticker := time.NewTicker(realtimeReconcileInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			g.reconcile()
+		case <-g.h.jobs.stopping():
+			return
+		}
+	}
+}
+
+// reconcile drops tracked channels FreeSWITCH no longer has
+func (g *gatewayUsage) reconcile() {
+	response, err := g.h.eslClient.SendCommand("api show channels as json")
+	if err != nil {
+		return
+	}
+	var data struct {
+		Rows []struct {
+			UUID string `json:"uuid"`
+		} `json:"rows"`
+	}
+	if err := json.Unmarshal([]byte(response), &data); err != nil {
+		return
+	}
+	live := make(map[string]bool, len(data.Rows))
+	for _, row := range data.Rows {
+		live[row.UUID] = true
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for uuid, ch := range g.channels {
+		if !live[uuid] {
+			delete(g.channels, uuid)
+			g.calls.set(float64(g.usage(ch.gateway).Calls), ch.gateway)
+		}
+	}
+}
+
+// respondGatewayFull writes the 429 for an originate refused by gateway caps
+func (h *APIHandler) respondGatewayFull(w http.ResponseWriter, r *http.Request, full []string) {
+	for _, gw := range full {
+		h.gateways.rejected.inc(gw)
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(gatewayCapRetryAfter))
+	h.respondErrorBody(w, r, ErrorResponse{
+		Status:     "error",
+		Message:    fmt.Sprintf("Gateway %s is at capacity", strings.Join(full, ", ")),
+		Code:       ErrCodeGatewayFull,
+		RetryAfter: gatewayCapRetryAfter,
+	}, http.StatusTooManyRequests)
+}
+
+// GET /v1/gateways/{name}/usage
+func (h *APIHandler) GetGatewayUsage(w http.ResponseWriter, r *http.Request) {
+	if !h.requireUnrestricted(w, r) {
+		return
+	}
+	name := mux.Vars(r)["name"]
+	if !isValidName(name) {
+		h.respondError(w, r, "gateway name must be letters, digits, '_', '.' or '-'", http.StatusBadRequest)
+		return
+	}
+	if h.gateways == nil {
+		h.respondError(w, r, "Gateway usage requires FSAPI_EVENTS=true", http.StatusServiceUnavailable)
+		return
+	}
+
+	h.gateways.mu.Lock()
+	usage := h.gateways.usage(name)
+	h.gateways.mu.Unlock()
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   usage,
+	})
+}
@@ -0,0 +1,656 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+// Limits on one query. Related fields lead back to each other (a queue's
+// agents have queues), so without them a short query can multiply rows
+// without end.
+const (
+	graphMaxDepth = 6     // Nested fields, not counting introspection
+	graphMaxRows  = 10000 // Rows resolved over the whole query
+)
+
+// Row fields exposed per type. Values are passed through as FreeSWITCH
+// prints them, like the REST list endpoints do.
+var (
+	graphCallFields = []string{
+		"uuid", "direction", "created", "created_epoch", "name", "state", "cid_name", "cid_num",
+		"ip_addr", "dest", "presence_id", "accountcode", "callstate", "callee_name", "callee_num",
+		"call_uuid", "hostname", "b_uuid", "b_direction", "b_name", "b_state", "b_cid_name",
+		"b_cid_num", "b_dest", "b_callstate", "b_callee_name", "b_callee_num", "call_created_epoch",
+	}
+	graphChannelFields = []string{
+		"uuid", "direction", "created", "created_epoch", "name", "state", "cid_name", "cid_num",
+		"ip_addr", "dest", "application", "application_data", "dialplan", "context", "read_codec",
+		"read_rate", "write_codec", "write_rate", "secure", "hostname", "presence_id", "accountcode",
+		"callstate", "callee_name", "callee_num", "callee_direction", "call_uuid",
+	}
+	graphRegistrationFields = []string{
+		"reg_user", "realm", "token", "url", "expires", "network_ip", "network_port", "network_proto",
+		"hostname", "metadata",
+	}
+	graphQueueFields = []string{
+		"name", "strategy", "moh_sound", "time_base_score", "tier_rules_apply", "tier_rule_wait_second",
+		"tier_rule_no_agent_no_wait", "discard_abandoned_after", "abandoned_resume_allowed",
+		"max_wait_time", "max_wait_time_with_no_agent", "max_wait_time_with_no_agent_time_reached",
+		"record_template", "calls_answered", "calls_abandoned", "ring_progressively_delay",
+	}
+	graphAgentFields = []string{
+		"name", "instance_id", "uuid", "type", "contact", "status", "state", "max_no_answer",
+		"wrap_up_time", "reject_delay_time", "busy_delay_time", "no_answer_delay_time",
+		"last_bridge_start", "last_bridge_end", "last_offered_call", "last_status_change",
+		"no_answer_count", "calls_answered", "talk_time", "ready_time", "external_calls_count",
+	}
+	graphTierFields = []string{"queue", "agent", "state", "level", "position"}
+)
+
+// graphRow is one FreeSWITCH row; every value is a string
+type graphRow map[string]string
+
+// graphLoader fetches each list at most once per GraphQL request and
+// applies the caller's context restrictions
+type graphLoader struct {
+	h     *APIHandler
+	r     *http.Request
+	mu    sync.Mutex
+	lists map[string][]graphRow
+	errs  map[string]error
+	rows  int // Resolved so far, see graphMaxRows
+}
+
+func newGraphLoader(h *APIHandler, r *http.Request) *graphLoader {
+	return &graphLoader{h: h, r: r, lists: map[string][]graphRow{}, errs: map[string]error{}}
+}
+
+// load returns the named list, fetching it with fetch the first time
+func (l *graphLoader) load(name string, fetch func() ([]graphRow, error)) ([]graphRow, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if rows, ok := l.lists[name]; ok {
+		return rows, l.errs[name]
+	}
+	rows, err := fetch()
+	l.lists[name], l.errs[name] = rows, err
+	return rows, err
+}
+
+// resolved counts the rows a field resolved to, failing once the query has
+// resolved more than graphMaxRows
+func (l *graphLoader) resolved(v interface{}) error {
+	n := 0
+	switch rows := v.(type) {
+	case []graphRow:
+		n = len(rows)
+	case graphRow:
+		n = 1
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rows += n
+	if l.rows > graphMaxRows {
+		return fmt.Errorf("query resolves more than %d rows", graphMaxRows)
+	}
+	return nil
+}
+
+// showRows runs "show <what> as json" and flattens the rows to strings
+func (l *graphLoader) showRows(what string) ([]graphRow, error) {
+	response, err := l.h.eslClient.SendCommand(fmt.Sprintf("api show %s as json", what))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve %s: %v", what, err)
+	}
+	var data struct {
+		Rows []map[string]interface{} `json:"rows"`
+	}
+	// An empty table is printed as {"row_count":0}
+	if err := json.Unmarshal([]byte(response), &data); err != nil {
+		return nil, fmt.Errorf("failed to parse %s data: %v", what, err)
+	}
+	rows := make([]graphRow, 0, len(data.Rows))
+	for _, raw := range data.Rows {
+		row := graphRow{}
+		for k, v := range raw {
+			if v != nil {
+				row[k] = fmt.Sprint(v)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func (l *graphLoader) channels() ([]graphRow, error) {
+	return l.load("channels", func() ([]graphRow, error) {
+		rows, err := l.showRows("channels")
+		if err != nil || isUnrestrictedAccess(l.r) {
+			return rows, err
+		}
+		filtered := []graphRow{}
+		for _, row := range rows {
+			callContext := row["accountcode"]
+			if callContext == "" {
+				callContext = row["context"]
+			}
+			if isContextAllowed(l.r, callContext) {
+				filtered = append(filtered, row)
+			}
+		}
+		return filtered, nil
+	})
+}
+
+func (l *graphLoader) calls() ([]graphRow, error) {
+	return l.load("calls", func() ([]graphRow, error) {
+		rows, err := l.showRows("calls")
+		if err != nil || isUnrestrictedAccess(l.r) {
+			return rows, err
+		}
+		// Same rule as GET /v1/calls: accountcode, else the channel context
+		contextMap := l.h.channelContextMap()
+		filtered := []graphRow{}
+		for _, row := range rows {
+			callContext := row["accountcode"]
+			if callContext == "" {
+				callContext = contextMap[row["uuid"]]
+			}
+			if callContext != "" && isContextAllowed(l.r, callContext) {
+				filtered = append(filtered, row)
+			}
+		}
+		return filtered, nil
+	})
+}
+
+func (l *graphLoader) registrations() ([]graphRow, error) {
+	return l.load("registrations", func() ([]graphRow, error) {
+		rows, err := l.showRows("registrations")
+		if err != nil || isUnrestrictedAccess(l.r) {
+			return rows, err
+		}
+		filtered := []graphRow{}
+		for _, row := range rows {
+			if isContextAllowed(l.r, row["realm"]) {
+				filtered = append(filtered, row)
+			}
+		}
+		return filtered, nil
+	})
+}
+
+// ccRows runs a callcenter_config list and filters it like the REST
+// endpoints do
+func (l *graphLoader) ccRows(name, args string, filter func([]map[string]string, []string) []map[string]string) ([]graphRow, error) {
+	return l.load(name, func() ([]graphRow, error) {
+		response, err := l.h.sendCCCommand(args)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %v", name, err)
+		}
+		parsed := ParsePipeDelimited(response)
+		if !isUnrestrictedAccess(l.r) {
+			parsed = filter(parsed, getAllowedContexts(l.r))
+		}
+		rows := make([]graphRow, 0, len(parsed))
+		for _, row := range parsed {
+			rows = append(rows, graphRow(row))
+		}
+		return rows, nil
+	})
+}
+
+func (l *graphLoader) queues() ([]graphRow, error) {
+	return l.ccRows("queues", "queue list", func(rows []map[string]string, allowed []string) []map[string]string {
+		return filterByDomain(rows, "name", allowed)
+	})
+}
+
+func (l *graphLoader) agents() ([]graphRow, error) {
+	return l.ccRows("agents", "agent list", filterAgentsByDomain)
+}
+
+func (l *graphLoader) tiers() ([]graphRow, error) {
+	return l.ccRows("tiers", "tier list", func(rows []map[string]string, allowed []string) []map[string]string {
+		return filterByDomain(rows, "queue", allowed)
+	})
+}
+
+// graphSelect returns the rows of list whose key equals one of values.
+// Empty values select nothing.
+func graphSelect(list func() ([]graphRow, error), key string, values ...string) ([]graphRow, error) {
+	rows, err := list()
+	if err != nil {
+		return nil, err
+	}
+	matched := []graphRow{}
+	for _, row := range rows {
+		if row[key] != "" && containsString(values, row[key]) {
+			matched = append(matched, row)
+		}
+	}
+	return matched, nil
+}
+
+// graphFirst is graphSelect for a single related row (nil when absent)
+func graphFirst(list func() ([]graphRow, error), key, value string) (interface{}, error) {
+	rows, err := graphSelect(list, key, value)
+	if err != nil || len(rows) == 0 {
+		return nil, err
+	}
+	return rows[0], nil
+}
+
+// graphFilter applies the string arguments of a root field to a list
+func graphFilter(rows []graphRow, args map[string]interface{}) []graphRow {
+	filtered := []graphRow{}
+	for _, row := range rows {
+		match := true
+		for key, v := range args {
+			if s, _ := v.(string); s != "" && row[key] != s {
+				match = false
+				break
+			}
+		}
+		if match {
+			filtered = append(filtered, row)
+		}
+	}
+	return filtered
+}
+
+// presenceUser turns a presence_id or agent contact into "user@domain"
+func presenceUser(value string) string {
+	// Contacts look like "{domain_name=d,...}user/1000@d"
+	if i := strings.LastIndex(value, "}"); i >= 0 {
+		value = value[i+1:]
+	}
+	if i := strings.LastIndex(value, "/"); i >= 0 {
+		value = value[i+1:]
+	}
+	return value
+}
+
+type graphLoaderKey struct{}
+
+func withGraphLoader(r *http.Request, l *graphLoader) context.Context {
+	return context.WithValue(r.Context(), graphLoaderKey{}, l)
+}
+
+func graphLoaderFrom(p graphql.ResolveParams) *graphLoader {
+	return p.Context.Value(graphLoaderKey{}).(*graphLoader)
+}
+
+// countRows makes field count the rows it resolves to against graphMaxRows
+func countRows(field *graphql.Field) *graphql.Field {
+	resolve := field.Resolve
+	field.Resolve = func(p graphql.ResolveParams) (interface{}, error) {
+		v, err := resolve(p)
+		if err != nil {
+			return nil, err
+		}
+		if err := graphLoaderFrom(p).resolved(v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+	return field
+}
+
+// graphQueryDepth returns how deeply the fields of query nest, following
+// fragments. Introspection fields are not counted. A query that does not
+// parse has depth 0 and is left to graphql.Do to report.
+func graphQueryDepth(query string) int {
+	doc, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		return 0
+	}
+	fragments := map[string]*ast.FragmentDefinition{}
+	for _, def := range doc.Definitions {
+		if frag, ok := def.(*ast.FragmentDefinition); ok && frag.Name != nil {
+			fragments[frag.Name.Value] = frag
+		}
+	}
+	// visiting guards against fragments that spread themselves, which
+	// validation rejects later
+	visiting := map[string]bool{}
+	var depth func(set *ast.SelectionSet) int
+	depth = func(set *ast.SelectionSet) int {
+		if set == nil {
+			return 0
+		}
+		deepest := 0
+		for _, sel := range set.Selections {
+			d := 0
+			switch sel := sel.(type) {
+			case *ast.Field:
+				if sel.Name != nil && strings.HasPrefix(sel.Name.Value, "__") {
+					continue
+				}
+				d = 1 + depth(sel.SelectionSet)
+			case *ast.InlineFragment:
+				d = depth(sel.SelectionSet)
+			case *ast.FragmentSpread:
+				frag := fragments[sel.Name.Value]
+				if frag == nil || visiting[sel.Name.Value] {
+					continue
+				}
+				visiting[sel.Name.Value] = true
+				d = depth(frag.SelectionSet)
+				visiting[sel.Name.Value] = false
+			}
+			if d > deepest {
+				deepest = d
+			}
+		}
+		return deepest
+	}
+	deepest := 0
+	for _, def := range doc.Definitions {
+		if op, ok := def.(*ast.OperationDefinition); ok {
+			if d := depth(op.SelectionSet); d > deepest {
+				deepest = d
+			}
+		}
+	}
+	return deepest
+}
+
+// graphObject builds an object type whose scalar fields read the row and
+// whose related fields come from edges
+func graphObject(name, description string, fields []string, edges func() graphql.Fields) *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name:        name,
+		Description: description,
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			all := graphql.Fields{}
+			for _, field := range fields {
+				key := field
+				all[key] = &graphql.Field{
+					Type: graphql.String,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						if v, ok := p.Source.(graphRow)[key]; ok {
+							return v, nil
+						}
+						return nil, nil
+					},
+				}
+			}
+			for key, edge := range edges() {
+				all[key] = countRows(edge)
+			}
+			return all
+		}),
+	})
+}
+
+// newGraphSchema builds the read-only schema. Types reference each other,
+// so related fields are declared in thunks.
+func newGraphSchema() (graphql.Schema, error) {
+	var callType, channelType, registrationType, queueType, agentType, tierType *graphql.Object
+
+	rowsOf := func(t **graphql.Object) graphql.Output {
+		return graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(*t)))
+	}
+	row := func(p graphql.ResolveParams) graphRow { return p.Source.(graphRow) }
+
+	channelType = graphObject("Channel", "A FreeSWITCH channel (show channels)", graphChannelFields, func() graphql.Fields {
+		return graphql.Fields{
+			"call": &graphql.Field{
+				Type:        callType,
+				Description: "The call this channel is a leg of",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					l := graphLoaderFrom(p)
+					calls, err := graphSelect(l.calls, "uuid", row(p)["uuid"])
+					if err == nil && len(calls) == 0 {
+						calls, err = graphSelect(l.calls, "b_uuid", row(p)["uuid"])
+					}
+					if err != nil || len(calls) == 0 {
+						return nil, err
+					}
+					return calls[0], nil
+				},
+			},
+			"registrations": &graphql.Field{
+				Type:        rowsOf(&registrationType),
+				Description: "Registrations of the channel's presence user",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return graphRegistrationsOf(graphLoaderFrom(p), presenceUser(row(p)["presence_id"]))
+				},
+			},
+		}
+	})
+
+	callType = graphObject("Call", "A bridged or unbridged call (show calls)", graphCallFields, func() graphql.Fields {
+		return graphql.Fields{
+			"a_leg": &graphql.Field{
+				Type: channelType,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return graphFirst(graphLoaderFrom(p).channels, "uuid", row(p)["uuid"])
+				},
+			},
+			"b_leg": &graphql.Field{
+				Type: channelType,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return graphFirst(graphLoaderFrom(p).channels, "uuid", row(p)["b_uuid"])
+				},
+			},
+		}
+	})
+
+	registrationType = graphObject("Registration", "A SIP registration (show registrations)", graphRegistrationFields, func() graphql.Fields {
+		return graphql.Fields{
+			"channels": &graphql.Field{
+				Type:        rowsOf(&channelType),
+				Description: "Channels whose presence_id is this user",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return graphSelect(graphLoaderFrom(p).channels, "presence_id", row(p)["reg_user"]+"@"+row(p)["realm"])
+				},
+			},
+			"agents": &graphql.Field{
+				Type:        rowsOf(&agentType),
+				Description: "Callcenter agents whose contact rings this user",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					agents, err := graphLoaderFrom(p).agents()
+					if err != nil {
+						return nil, err
+					}
+					user := row(p)["reg_user"] + "@" + row(p)["realm"]
+					matched := []graphRow{}
+					for _, agent := range agents {
+						if presenceUser(agent["contact"]) == user {
+							matched = append(matched, agent)
+						}
+					}
+					return matched, nil
+				},
+			},
+		}
+	})
+
+	queueType = graphObject("Queue", "A callcenter queue", graphQueueFields, func() graphql.Fields {
+		return graphql.Fields{
+			"tiers": &graphql.Field{
+				Type: rowsOf(&tierType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return graphSelect(graphLoaderFrom(p).tiers, "queue", row(p)["name"])
+				},
+			},
+			"agents": &graphql.Field{
+				Type:        rowsOf(&agentType),
+				Description: "Agents with a tier in this queue",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					l := graphLoaderFrom(p)
+					tiers, err := graphSelect(l.tiers, "queue", row(p)["name"])
+					if err != nil {
+						return nil, err
+					}
+					names := make([]string, 0, len(tiers))
+					for _, tier := range tiers {
+						names = append(names, tier["agent"])
+					}
+					return graphSelect(l.agents, "name", names...)
+				},
+			},
+		}
+	})
+
+	agentType = graphObject("Agent", "A callcenter agent", graphAgentFields, func() graphql.Fields {
+		return graphql.Fields{
+			"tiers": &graphql.Field{
+				Type: rowsOf(&tierType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return graphSelect(graphLoaderFrom(p).tiers, "agent", row(p)["name"])
+				},
+			},
+			"queues": &graphql.Field{
+				Type: rowsOf(&queueType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					l := graphLoaderFrom(p)
+					tiers, err := graphSelect(l.tiers, "agent", row(p)["name"])
+					if err != nil {
+						return nil, err
+					}
+					names := make([]string, 0, len(tiers))
+					for _, tier := range tiers {
+						names = append(names, tier["queue"])
+					}
+					return graphSelect(l.queues, "name", names...)
+				},
+			},
+			"registrations": &graphql.Field{
+				Type:        rowsOf(&registrationType),
+				Description: "Registrations of the user the agent's contact rings",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return graphRegistrationsOf(graphLoaderFrom(p), presenceUser(row(p)["contact"]))
+				},
+			},
+		}
+	})
+
+	tierType = graphObject("Tier", "A callcenter tier (agent-to-queue assignment)", graphTierFields, func() graphql.Fields {
+		return graphql.Fields{
+			"queue_info": &graphql.Field{
+				Type: queueType,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return graphFirst(graphLoaderFrom(p).queues, "name", row(p)["queue"])
+				},
+			},
+			"agent_info": &graphql.Field{
+				Type: agentType,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return graphFirst(graphLoaderFrom(p).agents, "name", row(p)["agent"])
+				},
+			},
+		}
+	})
+
+	// Root list fields accept string arguments that filter on the
+	// same-named row field
+	list := func(t **graphql.Object, load func(*graphLoader) ([]graphRow, error), args ...string) *graphql.Field {
+		config := graphql.FieldConfigArgument{}
+		for _, arg := range args {
+			config[arg] = &graphql.ArgumentConfig{Type: graphql.String}
+		}
+		return countRows(&graphql.Field{
+			Type: rowsOf(t),
+			Args: config,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				rows, err := load(graphLoaderFrom(p))
+				if err != nil {
+					return nil, err
+				}
+				return graphFilter(rows, p.Args), nil
+			},
+		})
+	}
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"calls":         list(&callType, (*graphLoader).calls, "uuid", "accountcode"),
+			"channels":      list(&channelType, (*graphLoader).channels, "uuid", "context", "accountcode", "presence_id"),
+			"registrations": list(&registrationType, (*graphLoader).registrations, "reg_user", "realm"),
+			"queues":        list(&queueType, (*graphLoader).queues, "name"),
+			"agents":        list(&agentType, (*graphLoader).agents, "name", "status", "state"),
+			"tiers":         list(&tierType, (*graphLoader).tiers, "queue", "agent", "state"),
+		},
+	})
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query})
+}
+
+// graphRegistrationsOf returns the registrations of user ("user@realm")
+func graphRegistrationsOf(l *graphLoader, user string) ([]graphRow, error) {
+	regs, err := l.registrations()
+	if err != nil {
+		return nil, err
+	}
+	matched := []graphRow{}
+	for _, reg := range regs {
+		if user != "" && reg["reg_user"]+"@"+reg["realm"] == user {
+			matched = append(matched, reg)
+		}
+	}
+	return matched, nil
+}
+
+// GraphQLRequest is the standard GraphQL-over-HTTP request body
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// GET/POST /v1/graphql
+func (h *APIHandler) GraphQL(w http.ResponseWriter, r *http.Request) {
+	if h.graphSchema == nil {
+		h.respondError(w, r, "GraphQL is disabled (FSAPI_GRAPHQL is not true)", http.StatusNotImplemented)
+		return
+	}
+
+	var req GraphQLRequest
+	if r.Method == http.MethodGet {
+		req.Query = r.URL.Query().Get("query")
+		req.OperationName = r.URL.Query().Get("operationName")
+		if v := r.URL.Query().Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				h.respondError(w, r, "variables must be a JSON object", http.StatusBadRequest)
+				return
+			}
+		}
+	} else if !h.decodeRequest(w, r, &req) {
+		return
+	}
+	if req.Query == "" {
+		h.respondError(w, r, "query is required", http.StatusBadRequest)
+		return
+	}
+
+	if depth := graphQueryDepth(req.Query); depth > graphMaxDepth {
+		h.respondError(w, r, fmt.Sprintf("query nests fields %d levels deep; at most %d are allowed", depth, graphMaxDepth), http.StatusBadRequest)
+		return
+	}
+
+	loader := newGraphLoader(h, r)
+	result := graphql.Do(graphql.Params{
+		Schema:         *h.graphSchema,
+		RequestString:  req.Query,
+		OperationName:  req.OperationName,
+		VariableValues: req.Variables,
+		Context:        withGraphLoader(r, loader),
+	})
+	if result.HasErrors() {
+		logWarn(getRequestID(r), fmt.Sprintf("GraphQL query returned %d error(s): %v", len(result.Errors), result.Errors[0].Message))
+	}
+
+	// GraphQL clients expect {"data": ..., "errors": [...]}, not the REST envelope
+	h.respondJSON(w, r, result)
+}
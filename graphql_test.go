@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/graphql-go/graphql"
+)
+
+func TestGraphQueryDepth(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  int
+	}{
+		{"flat", `{ calls { uuid } }`, 2},
+		{"related", `{ queues { name tiers { agent_info { registrations { url } } } } }`, 5},
+		{"deepest operation counts", `{ calls { uuid } } query Q { queues { agents { queues { name } } } }`, 4},
+		{"fragment", `{ queues { ...Q } } fragment Q on Queue { agents { queues { name } } }`, 4},
+		{"inline fragment", `{ agents { ... on Agent { queues { name } } } }`, 3},
+		{"introspection not counted", `{ __schema { types { fields { type { ofType { ofType { name } } } } } } }`, 0},
+		{"self-spreading fragment", `{ agents { ...A } } fragment A on Agent { queues { agents { ...A } } }`, 3},
+		{"does not parse", `{ calls { uuid `, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := graphQueryDepth(tt.query); got != tt.want {
+				t.Errorf("graphQueryDepth() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGraphMaxRows(t *testing.T) {
+	schema, err := newGraphSchema()
+	if err != nil {
+		t.Fatalf("newGraphSchema() = %v", err)
+	}
+	// Every agent in every queue: each level multiplies the rows
+	var queues, agents, tiers []graphRow
+	for i := 0; i < 30; i++ {
+		queues = append(queues, graphRow{"name": fmt.Sprintf("q%d@acme.example", i)})
+		agents = append(agents, graphRow{"name": fmt.Sprintf("a%d@acme.example", i)})
+	}
+	for _, q := range queues {
+		for _, a := range agents {
+			tiers = append(tiers, graphRow{"queue": q["name"], "agent": a["name"]})
+		}
+	}
+	run := func(query string) *graphql.Result {
+		loader := newGraphLoader(&APIHandler{}, httptest.NewRequest("POST", "/v1/graphql", nil))
+		loader.lists = map[string][]graphRow{"queues": queues, "agents": agents, "tiers": tiers}
+		return graphql.Do(graphql.Params{Schema: schema, RequestString: query, Context: withGraphLoader(loader.r, loader)})
+	}
+
+	if result := run(`{ queues { agents { name } } }`); result.HasErrors() {
+		t.Errorf("930 rows: %v", result.Errors)
+	}
+	result := run(`{ queues { agents { queues { name } } } }`)
+	if !result.HasErrors() || !strings.Contains(result.Errors[0].Message, "rows") {
+		t.Errorf("27930 rows: errors = %v, want the row limit", result.Errors)
+	}
+}
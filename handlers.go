@@ -3,10 +3,15 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/graphql-go/graphql"
 )
 
 // Context keys
@@ -23,12 +28,70 @@ func getRequestID(r *http.Request) string {
 
 // API Handlers
 type APIHandler struct {
-	eslClient ESLClient
+	eslClient        ESLClient
+	jobs             *jobTracker
+	healthModules    []string
+	webhooks         *webhookManager
+	events           *eventBus
+	cdrs             *cdrStore
+	callcenter       *ccTracker
+	agentActivity    *agentActivityLog
+	announcers       *ccAnnouncers
+	overflow         *queueOverflow
+	slaThresholds    map[string]time.Duration
+	screenPopVars    []string
+	presence         *presenceSync  // Nil unless FSAPI_PRESENCE_SYNC is set
+	realtime         *realtimeStats // Nil when the event stream is disabled
+	gateways         *gatewayUsage  // Nil when the event stream is disabled
+	routeStats       *routeStats    // Nil when the event stream is disabled
+	gatewayGroups    map[string][]string
+	failoverCauses   []string // Causes that move a gateway group call to the next gateway
+	emergencyNumbers []string // Destinations an originate may give priority emergency
+	cdrVars          []string
+	dids             *didRegistry
+	blocklist        *blocklist
+	hours            *businessHours
+	callerIDs        *callerIDs
+	alerts           *alertManager
+	flows            *flowManager
+	flowTemplates    *flowTemplates
+	surveys          *surveyManager
+	trash            *trash
+	ttsCache         *ttsCache         // Nil without FSAPI_TTS_CACHE_DIR
+	ttsVoices        map[string]string // FSAPI_TTS_VOICES: engine:locale -> voice
+	numbers          *numberNormalizer // Nil without FSAPI_NUMBER_COUNTRY
+	rooms            *conferenceRooms
+	recordings       *conferenceRecordings
+	roster           *conferenceRoster
+	xmlCurlSections  []string
+	bodyLimits       map[string]int64 // Request body limit per route class
+	metrics          *metricsRegistry
+	sessions         *sessionStore // Nil when no credentials are configured
+	policy           *policyClient // External authorization; nil when FSAPI_POLICY_URL is unset
+	logSource        LogSource
+	eslAdminClient   ESLAdmin // Nil when the ESL client cannot report its status
+	switchMon        *switchMonitor
+	recalls          *parkRecalls // Parked calls with a scheduled recall
+	defaults         callDefaults // Hangup cause, DTMF duration and park app per context
+	traces           *callTraces
+	callLocks        *callLocks      // Serializes mutating requests per call UUID
+	eventHistory     *eventHistory   // Nil when the event stream is disabled
+	graphSchema      *graphql.Schema // Nil when FSAPI_GRAPHQL is off
+	streamsClosing   chan struct{}   // Closed when srv.Shutdown starts so streams end
 }
 
-func NewAPIHandler(eslHost, eslPort, eslPassword string) *APIHandler {
+func NewAPIHandler(eslClient ESLClient) *APIHandler {
+	jobs := newJobTracker()
+	traces := newCallTraces()
+	metrics := newMetricsRegistry()
 	return &APIHandler{
-		eslClient: NewESLClient(eslHost, eslPort, eslPassword),
+		eslClient:      &trackedESLClient{ESLClient: eslClient, jobs: jobs, traces: traces},
+		jobs:           jobs,
+		traces:         traces,
+		callLocks:      newCallLocks(metrics),
+		announcers:     newCCAnnouncers(),
+		metrics:        metrics,
+		streamsClosing: make(chan struct{}),
 	}
 }
 
@@ -45,22 +108,83 @@ func (h *APIHandler) respondSuccess(w http.ResponseWriter, r *http.Request, mess
 	})
 }
 
+// respondAction answers a call control request with message and what was
+// done under data
+func (h *APIHandler) respondAction(w http.ResponseWriter, r *http.Request, message string, result *CallActionResult) {
+	requestID := getRequestID(r)
+	logInfo(requestID, message)
+
+	h.respondJSON(w, r, SuccessResponse{
+		Status:  "success",
+		Message: message,
+		Data:    result,
+	})
+}
+
+// runCallAction sends cmd for a call control request, recording its reply
+// and timing
+func (h *APIHandler) runCallAction(callUUID, action, cmd string, params map[string]interface{}) (*CallActionResult, error) {
+	result := &CallActionResult{
+		UUID:       callUUID,
+		Action:     action,
+		Parameters: params,
+		Command:    cmd,
+		SentAt:     time.Now().UTC(),
+	}
+	response, err := h.eslClient.SendCommand(cmd)
+	result.CompletedAt = time.Now().UTC()
+	result.Result = strings.TrimSpace(response)
+	return result, err
+}
+
 func (h *APIHandler) respondError(w http.ResponseWriter, r *http.Request, message string, statusCode int) {
+	h.respondErrorBody(w, r, ErrorResponse{
+		Status:  "error",
+		Message: message,
+	}, statusCode)
+}
+
+// respondErrorBody writes a fully populated error payload
+func (h *APIHandler) respondErrorBody(w http.ResponseWriter, r *http.Request, body ErrorResponse, statusCode int) {
 	requestID := getRequestID(r)
 
 	if statusCode >= 500 {
-		logError(requestID, message, nil)
+		logError(requestID, body.Message, nil)
 	} else {
-		logWarn(requestID, message)
+		logWarn(requestID, body.Message)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("X-Request-ID", requestID)
 	w.WriteHeader(statusCode)
-	json.NewEncoder(w).Encode(ErrorResponse{
+	json.NewEncoder(w).Encode(body)
+}
+
+// respondESLError maps an ESL error to an HTTP status and writes the error.
+// When the ESL connection is down, the response is a 503 with a
+// machine-readable code and a Retry-After header taken from the reconnect
+// backoff state.
+func (h *APIHandler) respondESLError(w http.ResponseWriter, r *http.Request, message string, err error) {
+	body := ErrorResponse{
 		Status:  "error",
 		Message: message,
-	})
+	}
+
+	if unavailable, ok := isESLUnavailable(err); ok {
+		retryAfter := int(math.Ceil(unavailable.RetryAfter.Seconds()))
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		body.Code = ErrCodeESLUnavailable
+		body.RetryAfter = retryAfter
+		h.respondErrorBody(w, r, body, http.StatusServiceUnavailable)
+		return
+	}
+
+	statusCode, code := classifyESLError(err)
+	body.Code = code
+	h.respondErrorBody(w, r, body, statusCode)
 }
 
 // Helper to determine appropriate HTTP status code based on error
@@ -72,13 +196,15 @@ func (h *APIHandler) getErrorStatusCode(err error) int {
 	errMsg := err.Error()
 
 	// ESL connection errors -> Service Unavailable
-	if strings.Contains(errMsg, "ESL connection failed") {
+	if _, ok := isESLUnavailable(err); ok || strings.Contains(errMsg, "ESL connection failed") {
 		return http.StatusServiceUnavailable
 	}
 
-	// ESL command errors -> Bad Gateway (upstream service error)
+	// ESL command errors -> 404/400 when the -ERR is recognized, otherwise
+	// Bad Gateway (upstream service error)
 	if strings.Contains(errMsg, "ESL error") || strings.Contains(errMsg, "-ERR") {
-		return http.StatusBadGateway
+		statusCode, _ := classifyESLError(err)
+		return statusCode
 	}
 
 	// Default to Internal Server Error for unknown errors
@@ -97,29 +223,27 @@ func (h *APIHandler) HangupCall(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate call context
-	if _, ok := h.validateCallContext(w, r, callUUID); !ok {
+	callInfo, ok := h.validateCallContext(w, r, callUUID)
+	if !ok {
 		return
 	}
 
 	var req HangupRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		// Use default cause if no body provided
-		req.Cause = "NORMAL_CLEARING"
+	if !h.decodeOptionalRequest(w, r, &req) {
+		return
 	}
-
 	if req.Cause == "" {
-		req.Cause = "NORMAL_CLEARING"
+		req.Cause = h.defaults.hangupCauseFor(callInfo.AccountCode)
 	}
 
 	cmd := fmt.Sprintf("api uuid_kill %s %s", callUUID, req.Cause)
-	_, err := h.eslClient.SendCommand(cmd)
+	result, err := h.runCallAction(callUUID, "hangup", cmd, map[string]interface{}{"cause": req.Cause})
 	if err != nil {
-		statusCode := h.getErrorStatusCode(err)
-		h.respondError(w, r, fmt.Sprintf("Failed to hangup call: %v", err), statusCode)
+		h.respondESLError(w, r, fmt.Sprintf("Failed to hangup call: %v", err), err)
 		return
 	}
 
-	h.respondSuccess(w, r, fmt.Sprintf("Call %s hung up with cause %s", callUUID, req.Cause))
+	h.respondAction(w, r, fmt.Sprintf("Call %s hung up with cause %s", callUUID, req.Cause), result)
 }
 
 // POST /v1/calls/{uuid}/transfer
@@ -134,25 +258,62 @@ func (h *APIHandler) TransferCall(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate call context
-	if _, ok := h.validateCallContext(w, r, callUUID); !ok {
+	callInfo, ok := h.validateCallContext(w, r, callUUID)
+	if !ok {
 		return
 	}
 
 	var req TransferRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.respondError(w, r, "Invalid request body", http.StatusBadRequest)
+	if !h.decodeRequest(w, r, &req) {
 		return
 	}
 
+	// Voicemail builds its own destination, dialplan and context
+	if req.ToVoicemail != "" {
+		if req.Destination != "" || req.Dialplan != "" || req.Context != "" {
+			h.respondError(w, r, "to_voicemail cannot be combined with destination, dialplan or context", http.StatusBadRequest)
+			return
+		}
+		var err error
+		req.Destination, req.Dialplan, req.Context, err = voicemailTransfer(req.ToVoicemail)
+		if err != nil {
+			h.respondError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !h.validateCCDomainRaw(w, r, extractDomain(req.ToVoicemail), "Mailbox") {
+			return
+		}
+	}
+
 	// Only destination is required
 	if req.Destination == "" {
-		h.respondError(w, r, "destination is required", http.StatusBadRequest)
+		h.respondError(w, r, "destination or to_voicemail is required", http.StatusBadRequest)
+		return
+	}
+	tenant := callInfo.AccountCode
+	if tenant == "" {
+		tenant = req.Context
+	}
+	if req.ToVoicemail == "" {
+		req.Destination = h.normalizeNumber(req.Destination, tenant)
+	}
+	if !h.screenDestinations(w, r, "transfer", []string{req.Destination}, blocklistContexts(r, callInfo.AccountCode, req.Context)) {
 		return
 	}
 
-	// Default to "aleg" if not specified
-	if req.Leg == "" {
-		req.Leg = "aleg"
+	// Outside the tenant's business hours transfers may be refused or sent
+	// to the after-hours destination; voicemail is always reachable
+	if ev := h.hours.evaluate(tenant); ev != nil && !ev.Open && req.ToVoicemail == "" {
+		switch ev.Transfer {
+		case hoursActionReject:
+			h.respondClosed(w, r, ev)
+			return
+		case hoursActionReroute:
+			logInfo(getRequestID(r), fmt.Sprintf("%s is closed; transfer to %s rerouted", tenant, req.Destination))
+			req.Destination = ev.AfterHoursDestination
+			req.Dialplan = "XML"
+			req.Context = tenant
+		}
 	}
 
 	// Validate leg parameter
@@ -162,6 +323,20 @@ func (h *APIHandler) TransferCall(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := validateBilling(req.Billing); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Tag the call before transferring so the new destination inherits the billing context
+	for _, kv := range billingVars(req.Billing) {
+		setCmd := fmt.Sprintf("api uuid_setvar %s %s %s", callUUID, kv[0], kv[1])
+		if _, err := h.eslClient.SendCommand(setCmd); err != nil {
+			h.respondESLError(w, r, fmt.Sprintf("Failed to set billing variables: %v", err), err)
+			return
+		}
+	}
+
 	// Build the command: uuid_transfer <uuid> [-bleg|-both] <dest-exten> [<dialplan>] [<context>]
 	var cmd strings.Builder
 	cmd.WriteString("api uuid_transfer ")
@@ -183,44 +358,58 @@ func (h *APIHandler) TransferCall(w http.ResponseWriter, r *http.Request) {
 	// Add destination (required)
 	cmd.WriteString(req.Destination)
 
-	// Add dialplan and context as a pair (both or neither)
-	// If context is provided, dialplan defaults to "XML"
+	// Add dialplan and context as a pair (both or neither); the inline
+	// dialplan takes no context
 	if req.Context != "" {
-		dialplan := req.Dialplan
-		if dialplan == "" {
-			dialplan = "XML"
-		}
 		cmd.WriteString(" ")
-		cmd.WriteString(dialplan)
+		cmd.WriteString(req.Dialplan)
 		cmd.WriteString(" ")
 		cmd.WriteString(req.Context)
+	} else if req.Dialplan == dialplanInline {
+		cmd.WriteString(" ")
+		cmd.WriteString(dialplanInline)
 	}
 
-	_, err := h.eslClient.SendCommand(cmd.String())
+	params := map[string]interface{}{"destination": req.Destination, "leg": leg}
+	if req.Dialplan != "" {
+		params["dialplan"] = req.Dialplan
+	}
+	if req.Context != "" {
+		params["context"] = req.Context
+	}
+	if req.ToVoicemail != "" {
+		params["to_voicemail"] = req.ToVoicemail
+	}
+	if req.Billing != nil {
+		params["billing"] = req.Billing
+	}
+	result, err := h.runCallAction(callUUID, "transfer", cmd.String(), params)
 	if err != nil {
-		statusCode := h.getErrorStatusCode(err)
-		h.respondError(w, r, fmt.Sprintf("Failed to transfer call: %v", err), statusCode)
+		h.respondESLError(w, r, fmt.Sprintf("Failed to transfer call: %v", err), err)
 		return
 	}
 
 	// Build success message
 	var message strings.Builder
-	message.WriteString(fmt.Sprintf("Call %s (%s) transferred to %s", callUUID, legType, req.Destination))
-	if req.Dialplan != "" {
+	if req.ToVoicemail != "" {
+		message.WriteString(fmt.Sprintf("Call %s (%s) transferred to voicemail %s", callUUID, legType, req.ToVoicemail))
+	} else {
+		message.WriteString(fmt.Sprintf("Call %s (%s) transferred to %s", callUUID, legType, req.Destination))
+	}
+	if req.ToVoicemail == "" && req.Dialplan != "" {
 		message.WriteString(fmt.Sprintf(" dialplan %s", req.Dialplan))
 	}
-	if req.Context != "" {
+	if req.ToVoicemail == "" && req.Context != "" {
 		message.WriteString(fmt.Sprintf(" context %s", req.Context))
 	}
 
-	h.respondSuccess(w, r, message.String())
+	h.respondAction(w, r, message.String(), result)
 }
 
 // POST /v1/calls/bridge
 func (h *APIHandler) BridgeCalls(w http.ResponseWriter, r *http.Request) {
 	var req BridgeRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.respondError(w, r, "Invalid request body", http.StatusBadRequest)
+	if !h.decodeRequest(w, r, &req) {
 		return
 	}
 
@@ -247,15 +436,90 @@ func (h *APIHandler) BridgeCalls(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Validate variable names before touching either channel
+	for _, name := range req.TransferVariables {
+		if !isValidChannelVarName(name) {
+			h.respondError(w, r, fmt.Sprintf("invalid variable name in transfer_variables: '%s'", name), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Copy selected variables from the A-leg to the B-leg
+	for _, name := range req.TransferVariables {
+		value, err := h.eslClient.SendCommand(fmt.Sprintf("api uuid_getvar %s %s", req.UUIDA, name))
+		if err != nil {
+			h.respondESLError(w, r, fmt.Sprintf("Failed to read variable %s from uuid_a: %v", name, err), err)
+			return
+		}
+		value = strings.TrimSpace(value)
+		if value == "_undef_" {
+			continue
+		}
+		if _, err := h.eslClient.SendCommand(fmt.Sprintf("api uuid_setvar %s %s %s", req.UUIDB, name, value)); err != nil {
+			h.respondESLError(w, r, fmt.Sprintf("Failed to set variable %s on uuid_b: %v", name, err), err)
+			return
+		}
+	}
+
+	// Park both legs instead of hanging up when the bridge ends
+	if req.ParkAfterBridge {
+		for _, legUUID := range []string{req.UUIDA, req.UUIDB} {
+			if _, err := h.eslClient.SendCommand(fmt.Sprintf("api uuid_setvar %s park_after_bridge true", legUUID)); err != nil {
+				h.respondESLError(w, r, fmt.Sprintf("Failed to set park_after_bridge: %v", err), err)
+				return
+			}
+		}
+	}
+
 	cmd := fmt.Sprintf("api uuid_bridge %s %s", req.UUIDA, req.UUIDB)
-	_, err := h.eslClient.SendCommand(cmd)
+	params := map[string]interface{}{"uuid_b": req.UUIDB, "park_after_bridge": req.ParkAfterBridge}
+	if len(req.TransferVariables) > 0 {
+		params["transfer_variables"] = req.TransferVariables
+	}
+	result, err := h.runCallAction(req.UUIDA, "bridge", cmd, params)
+	if err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to bridge calls: %v", err), err)
+		return
+	}
+
+	message := fmt.Sprintf("Calls %s and %s bridged", req.UUIDA, req.UUIDB)
+	if req.ParkAfterBridge {
+		message += " (park after bridge)"
+	}
+	if req.CallerIDLookup {
+		if name := h.displayCallerName(r, req.UUIDA, req.UUIDB); name != "" {
+			message += fmt.Sprintf(" (caller name %s)", name)
+			params["caller_name"] = name
+		}
+	}
+	h.respondAction(w, r, message, result)
+}
+
+// POST /v1/calls/{uuid}/unbridge
+func (h *APIHandler) UnbridgeCall(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	callUUID := vars["uuid"]
+
+	// Validate UUID
+	if err := validateUUID(callUUID); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Validate call context
+	if _, ok := h.validateCallContext(w, r, callUUID); !ok {
+		return
+	}
+
+	// Transferring both legs to park splits the bridge and keeps both alive
+	cmd := fmt.Sprintf("api uuid_transfer %s -both park inline", callUUID)
+	result, err := h.runCallAction(callUUID, "unbridge", cmd, nil)
 	if err != nil {
-		statusCode := h.getErrorStatusCode(err)
-		h.respondError(w, r, fmt.Sprintf("Failed to bridge calls: %v", err), statusCode)
+		h.respondESLError(w, r, fmt.Sprintf("Failed to unbridge call: %v", err), err)
 		return
 	}
 
-	h.respondSuccess(w, r, fmt.Sprintf("Calls %s and %s bridged", req.UUIDA, req.UUIDB))
+	h.respondAction(w, r, fmt.Sprintf("Call %s unbridged, both legs parked", callUUID), result)
 }
 
 // POST /v1/calls/{uuid}/answer
@@ -275,14 +539,13 @@ func (h *APIHandler) AnswerCall(w http.ResponseWriter, r *http.Request) {
 	}
 
 	cmd := fmt.Sprintf("api uuid_answer %s", callUUID)
-	_, err := h.eslClient.SendCommand(cmd)
+	result, err := h.runCallAction(callUUID, "answer", cmd, nil)
 	if err != nil {
-		statusCode := h.getErrorStatusCode(err)
-		h.respondError(w, r, fmt.Sprintf("Failed to answer call: %v", err), statusCode)
+		h.respondESLError(w, r, fmt.Sprintf("Failed to answer call: %v", err), err)
 		return
 	}
 
-	h.respondSuccess(w, r, fmt.Sprintf("Call %s answered", callUUID))
+	h.respondAction(w, r, fmt.Sprintf("Call %s answered", callUUID), result)
 }
 
 // POST /v1/calls/{uuid}/hold
@@ -302,8 +565,7 @@ func (h *APIHandler) ControlHold(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req HoldRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.respondError(w, r, "Invalid request body", http.StatusBadRequest)
+	if !h.decodeRequest(w, r, &req) {
 		return
 	}
 
@@ -319,14 +581,13 @@ func (h *APIHandler) ControlHold(w http.ResponseWriter, r *http.Request) {
 		cmd = fmt.Sprintf("api uuid_hold off %s", callUUID)
 	}
 
-	_, err := h.eslClient.SendCommand(cmd)
+	result, err := h.runCallAction(callUUID, req.Action, cmd, nil)
 	if err != nil {
-		statusCode := h.getErrorStatusCode(err)
-		h.respondError(w, r, fmt.Sprintf("Failed to %s call: %v", req.Action, err), statusCode)
+		h.respondESLError(w, r, fmt.Sprintf("Failed to %s call: %v", req.Action, err), err)
 		return
 	}
 
-	h.respondSuccess(w, r, fmt.Sprintf("Call %s %s", callUUID, req.Action))
+	h.respondAction(w, r, fmt.Sprintf("Call %s %s", callUUID, req.Action), result)
 }
 
 // POST /v1/calls/{uuid}/record
@@ -346,8 +607,7 @@ func (h *APIHandler) ControlRecording(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req RecordRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.respondError(w, r, "Invalid request body", http.StatusBadRequest)
+	if !h.decodeRequest(w, r, &req) {
 		return
 	}
 
@@ -372,14 +632,17 @@ func (h *APIHandler) ControlRecording(w http.ResponseWriter, r *http.Request) {
 		cmd = fmt.Sprintf("api uuid_record %s stop all", callUUID)
 	}
 
-	_, err := h.eslClient.SendCommand(cmd)
+	var params map[string]interface{}
+	if req.Filename != "" && req.Action == "start" {
+		params = map[string]interface{}{"filename": req.Filename}
+	}
+	result, err := h.runCallAction(callUUID, "record_"+req.Action, cmd, params)
 	if err != nil {
-		statusCode := h.getErrorStatusCode(err)
-		h.respondError(w, r, fmt.Sprintf("Failed to %s recording: %v", req.Action, err), statusCode)
+		h.respondESLError(w, r, fmt.Sprintf("Failed to %s recording: %v", req.Action, err), err)
 		return
 	}
 
-	h.respondSuccess(w, r, fmt.Sprintf("Recording %s for call %s", req.Action, callUUID))
+	h.respondAction(w, r, fmt.Sprintf("Recording %s for call %s", req.Action, callUUID), result)
 }
 
 // POST /v1/calls/{uuid}/dtmf
@@ -394,13 +657,13 @@ func (h *APIHandler) SendDTMF(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate call context
-	if _, ok := h.validateCallContext(w, r, callUUID); !ok {
+	callInfo, ok := h.validateCallContext(w, r, callUUID)
+	if !ok {
 		return
 	}
 
 	var req DTMFRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.respondError(w, r, "Invalid request body", http.StatusBadRequest)
+	if !h.decodeRequest(w, r, &req) {
 		return
 	}
 
@@ -411,18 +674,17 @@ func (h *APIHandler) SendDTMF(w http.ResponseWriter, r *http.Request) {
 
 	duration := req.Duration
 	if duration == 0 {
-		duration = 100
+		duration = h.defaults.dtmfDurationFor(callInfo.AccountCode)
 	}
 
 	cmd := fmt.Sprintf("api uuid_send_dtmf %s %s@%d", callUUID, req.Digits, duration)
-	_, err := h.eslClient.SendCommand(cmd)
+	result, err := h.runCallAction(callUUID, "dtmf", cmd, map[string]interface{}{"digits": req.Digits, "duration": duration})
 	if err != nil {
-		statusCode := h.getErrorStatusCode(err)
-		h.respondError(w, r, fmt.Sprintf("Failed to send DTMF: %v", err), statusCode)
+		h.respondESLError(w, r, fmt.Sprintf("Failed to send DTMF: %v", err), err)
 		return
 	}
 
-	h.respondSuccess(w, r, fmt.Sprintf("DTMF %s sent to call %s", req.Digits, callUUID))
+	h.respondAction(w, r, fmt.Sprintf("DTMF %s sent to call %s", req.Digits, callUUID), result)
 }
 
 // POST /v1/calls/{uuid}/park
@@ -437,19 +699,131 @@ func (h *APIHandler) ParkCall(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate call context
-	if _, ok := h.validateCallContext(w, r, callUUID); !ok {
+	callInfo, ok := h.validateCallContext(w, r, callUUID)
+	if !ok {
+		return
+	}
+
+	// Body is optional; without it the call is parked with no recall
+	var req ParkRequest
+	if !h.decodeOptionalRequest(w, r, &req) {
+		return
+	}
+
+	if req.RecallAfterSec < 0 {
+		h.respondError(w, r, "recall_after_sec must not be negative", http.StatusBadRequest)
+		return
+	}
+	if req.RecallTo != "" && req.RecallAfterSec == 0 {
+		h.respondError(w, r, "recall_to requires recall_after_sec", http.StatusBadRequest)
+		return
+	}
+
+	// Numbered park orbit (mod_valet_parking) instead of anonymous park
+	if slot := r.URL.Query().Get("slot"); slot != "" {
+		if req.RecallAfterSec > 0 {
+			h.respondError(w, r, "recall_after_sec cannot be combined with slot", http.StatusBadRequest)
+			return
+		}
+		h.parkInSlot(w, r, callUUID, callInfo.AccountCode, slot)
 		return
 	}
+	recallTo := req.RecallTo
+	if req.RecallAfterSec > 0 {
+		// Without events a retrieved call would still be recalled
+		if h.eventHistory == nil {
+			h.respondError(w, r, "recall_after_sec requires the event stream (FSAPI_EVENTS=true)", http.StatusNotImplemented)
+			return
+		}
+		if recallTo != "" && !dialNumberPattern.MatchString(recallTo) {
+			h.respondError(w, r, "recall_to must be an extension (digits, '*' or '#', optionally with a leading '+')", http.StatusBadRequest)
+			return
+		}
+		// The parker has to be found before uuid_park breaks the bridge
+		if recallTo == "" {
+			parker, err := h.parkerNumber(callUUID)
+			if err != nil {
+				h.respondESLError(w, r, fmt.Sprintf("Failed to find the parker: %v", err), err)
+				return
+			}
+			if !dialNumberPattern.MatchString(parker) {
+				h.respondError(w, r, "recall_to is required: the call is not bridged to a party to recall to", http.StatusBadRequest)
+				return
+			}
+			recallTo = parker
+		}
+	}
+
+	// Parking again replaces an earlier recall
+	h.cancelParkRecall(callUUID)
 
 	cmd := fmt.Sprintf("api uuid_park %s", callUUID)
-	_, err := h.eslClient.SendCommand(cmd)
+	var params map[string]interface{}
+	if req.RecallAfterSec > 0 {
+		params = map[string]interface{}{"recall_after_sec": req.RecallAfterSec, "recall_to": recallTo}
+	}
+	result, err := h.runCallAction(callUUID, "park", cmd, params)
 	if err != nil {
-		statusCode := h.getErrorStatusCode(err)
-		h.respondError(w, r, fmt.Sprintf("Failed to park call: %v", err), statusCode)
+		h.respondESLError(w, r, fmt.Sprintf("Failed to park call: %v", err), err)
+		return
+	}
+
+	if req.RecallAfterSec > 0 {
+		if err := h.scheduleParkRecall(callUUID, recallTo, callInfo.AccountCode, req.RecallAfterSec); err != nil {
+			h.respondESLError(w, r, fmt.Sprintf("Call parked but the recall could not be scheduled: %v", err), err)
+			return
+		}
+		h.respondAction(w, r, fmt.Sprintf("Call %s parked (recall to %s in %ds)", callUUID, recallTo, req.RecallAfterSec), result)
 		return
 	}
 
-	h.respondSuccess(w, r, fmt.Sprintf("Call %s parked", callUUID))
+	h.respondAction(w, r, fmt.Sprintf("Call %s parked", callUUID), result)
+}
+
+// POST /v1/calls/{uuid}/heartbeat
+func (h *APIHandler) SessionHeartbeat(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	callUUID := vars["uuid"]
+
+	// Validate UUID
+	if err := validateUUID(callUUID); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Validate call context
+	if _, ok := h.validateCallContext(w, r, callUUID); !ok {
+		return
+	}
+
+	// Body is optional; defaults enable a 60s heartbeat
+	var req HeartbeatRequest
+	if !h.decodeOptionalRequest(w, r, &req) {
+		return
+	}
+
+	if req.IntervalSec < 0 {
+		h.respondError(w, r, "interval_sec must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	interval := strconv.Itoa(req.IntervalSec)
+	if req.Disable {
+		interval = "0"
+	}
+
+	cmd := fmt.Sprintf("api uuid_session_heartbeat %s %s", callUUID, interval)
+	result, err := h.runCallAction(callUUID, "heartbeat", cmd, map[string]interface{}{"interval_sec": req.IntervalSec, "disable": req.Disable})
+	if err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to set session heartbeat: %v", err), err)
+		return
+	}
+
+	if req.Disable {
+		h.respondAction(w, r, fmt.Sprintf("Session heartbeat disabled for call %s", callUUID), result)
+		return
+	}
+	h.respondAction(w, r, fmt.Sprintf("Session heartbeat enabled for call %s every %ds", callUUID, req.IntervalSec), result)
 }
 
 // POST /v1/calls/originate
@@ -457,14 +831,71 @@ func (h *APIHandler) OriginateCall(w http.ResponseWriter, r *http.Request) {
 	requestID := getRequestID(r)
 
 	var req OriginateRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.respondError(w, r, "Invalid request body", http.StatusBadRequest)
+	if !h.decodeRequest(w, r, &req) {
 		return
 	}
 
+	// Destinations are dialed in E.164 when FSAPI_NUMBER_COUNTRY is set
+	if req.ALegEndpoint != nil && req.ALegEndpoint.Type == dialTypeGateway {
+		req.ALegEndpoint.Number = h.normalizeNumber(req.ALegEndpoint.Number, req.Context)
+	}
+	if !strings.HasPrefix(req.BLeg, "&") {
+		req.BLeg = h.normalizeNumber(req.BLeg, req.Context)
+	}
+
 	// Validate required fields
-	if req.ALeg == "" {
-		h.respondError(w, r, "aleg is required", http.StatusBadRequest)
+	if req.RouteVia != "" && req.RouteVia != routeViaLCR {
+		h.respondError(w, r, "route_via must be lcr when set", http.StatusBadRequest)
+		return
+	}
+	if req.LCRProfile != "" && (req.RouteVia != routeViaLCR || !isValidName(req.LCRProfile)) {
+		h.respondError(w, r, "lcr_profile requires route_via lcr and must be a profile name", http.StatusBadRequest)
+		return
+	}
+	if req.GatewayGroup != "" {
+		if req.RouteVia != "" {
+			h.respondError(w, r, "gateway_group and route_via are mutually exclusive", http.StatusBadRequest)
+			return
+		}
+		if _, ok := h.gatewayGroups[req.GatewayGroup]; !ok {
+			h.respondError(w, r, fmt.Sprintf("unknown gateway_group %q", req.GatewayGroup), http.StatusBadRequest)
+			return
+		}
+		if err := checkGatewayGroupTarget(req.ALegEndpoint); err != nil {
+			h.respondError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.ALegEndpoint != nil {
+		if req.ALeg != "" {
+			h.respondError(w, r, "aleg and aleg_endpoint are mutually exclusive", http.StatusBadRequest)
+			return
+		}
+		if req.GatewayGroup != "" {
+			// The A-leg is dialed through the group's gateways below
+		} else if req.RouteVia == routeViaLCR {
+			// The A-leg is filled in from the LCR routes below
+			if err := checkLCRTarget(req.ALegEndpoint); err != nil {
+				h.respondError(w, r, err.Error(), http.StatusBadRequest)
+				return
+			}
+		} else {
+			dial, err := req.ALegEndpoint.dialString()
+			if err != nil {
+				h.respondError(w, r, "aleg_endpoint: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if req.ALegEndpoint.Type == dialTypeUser && !h.validateCCDomainRaw(w, r, req.ALegEndpoint.Domain, "User") {
+				return
+			}
+			req.ALeg = dial
+		}
+	} else if req.RouteVia == routeViaLCR {
+		h.respondError(w, r, "route_via lcr requires aleg_endpoint with the number to route", http.StatusBadRequest)
+		return
+	}
+	if req.ALeg == "" && req.RouteVia != routeViaLCR && req.GatewayGroup == "" {
+		h.respondError(w, r, "aleg or aleg_endpoint is required", http.StatusBadRequest)
 		return
 	}
 
@@ -475,9 +906,194 @@ func (h *APIHandler) OriginateCall(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// If bleg is not provided, default to park
+	if req.MaxDurationSec < 0 {
+		h.respondError(w, r, "max_duration_sec must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	if err := validateBilling(req.Billing); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := validatePriority(req.Priority); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The call is tracked, and its gateway slots reserved, under its
+	// origination_uuid, so a caller-chosen one must be a usable key
+	if v, ok := req.ChannelVariables["origination_uuid"]; ok {
+		if s, isString := v.(string); !isString || s == "" {
+			h.respondError(w, r, "origination_uuid must be a non-empty string", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := validateStirShaken(req.StirShaken, req.CallerIDNumber); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The locale sets the call's language and timezone, and the voice of
+	// a whisper that names none
+	loc, ok := h.requestLocale(w, r, req.Locale, req.Timezone)
+	if !ok {
+		return
+	}
+	if req.Whisper != nil {
+		h.localize(loc, req.Whisper.TTS, nil)
+	}
+
+	if err := validateWhisper(req.Whisper); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.CallerIDLookup && !e164Pattern.MatchString(req.CallerIDNumber) {
+		h.respondError(w, r, "caller_id_lookup requires caller_id_number to be a phone number", http.StatusBadRequest)
+		return
+	}
+
+	// Structured application instead of a raw B-leg
+	if req.Application != "" || req.ApplicationArgs != "" {
+		app, err := originateApplication(&req)
+		if err != nil {
+			h.respondError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Application == "callcenter" && !h.validateCCDomain(w, r, req.ApplicationArgs, "Queue") {
+			return
+		}
+		req.BLeg = app
+	}
+
+	// Toll-fraud screening of the numbers dialed out by either leg or a
+	// channel variable, and of the B-leg extension
+	numbers := dialedNumbers(req.ALeg)
+	if req.ALegEndpoint != nil && req.ALegEndpoint.Number != "" {
+		numbers = append(numbers, req.ALegEndpoint.Number)
+	}
+	numbers = append(numbers, blegNumbers(req.BLeg)...)
+	numbers = append(numbers, channelVarNumbers(req.ChannelVariables)...)
+	if !h.screenDestinations(w, r, "originate", numbers, blocklistContexts(r, req.Context)) {
+		return
+	}
+	if !h.checkEmergency(w, r, &req) {
+		return
+	}
+
+	// Emergency calls are attempted even while FreeSWITCH announces a
+	// shutdown or module reload
+	emergency := req.Priority == priorityEmergency
+	if !emergency && !h.checkOriginateAllowed(w, r) {
+		return
+	}
+
+	// Tenants present only the caller IDs on their allowlist; emergency
+	// calls go out whatever number they present
+	if !emergency && !h.checkCallerIDs(w, r, &req) {
+		return
+	}
+
+	// Outside the context's business hours the call may be refused, and a
+	// call without a B-leg goes to the after-hours destination
+	if ev := h.hours.evaluate(req.Context); ev != nil && !ev.Open && !emergency {
+		if ev.Originate == hoursActionReject {
+			h.respondClosed(w, r, ev)
+			return
+		}
+		if ev.AfterHoursDestination != "" && req.BLeg == "" {
+			req.BLeg = ev.AfterHoursDestination
+			if req.Dialplan == "" {
+				req.Dialplan = "XML"
+			}
+		}
+	}
+
+	// Least-cost routing: dial the carriers mod_lcr returns, cheapest first
+	var routes []LCRRoute
+	if req.RouteVia == routeViaLCR {
+		var err error
+		routes, err = h.lcrRoutes(req.ALegEndpoint.Number, req.LCRProfile)
+		if err != nil {
+			h.respondESLError(w, r, fmt.Sprintf("Failed to look up LCR routes: %v", err), err)
+			return
+		}
+		if len(routes) == 0 {
+			h.respondErrorBody(w, r, ErrorResponse{
+				Status:  "error",
+				Message: fmt.Sprintf("No LCR route for %s", req.ALegEndpoint.Number),
+				Code:    ErrCodeNoRoute,
+			}, http.StatusNotFound)
+			return
+		}
+		// Carriers whose gateways are at their cap are skipped
+		if h.gateways != nil && !emergency {
+			var open []LCRRoute
+			var full []string
+			for _, route := range routes {
+				if f := h.gateways.full(dialGateways(route.DialString)); len(f) > 0 {
+					for _, gw := range f {
+						if !containsString(full, gw) {
+							full = append(full, gw)
+						}
+					}
+					continue
+				}
+				open = append(open, route)
+			}
+			if len(open) == 0 {
+				h.respondGatewayFull(w, r, full)
+				return
+			}
+			routes = open
+		}
+		req.ALeg = lcrDialString(routes)
+	}
+
+	// Gateway group: the least busy gateway that is up is dialed first, the
+	// others in turn while calls fail with a failover cause
+	alegs := []string{req.ALeg}
+	if req.GatewayGroup != "" {
+		gateways, full, err := h.gatewayCandidates(h.gatewayGroups[req.GatewayGroup])
+		if err != nil {
+			h.respondESLError(w, r, fmt.Sprintf("Failed to check gateway status: %v", err), err)
+			return
+		}
+		if len(gateways) == 0 {
+			if len(full) > 0 {
+				h.respondGatewayFull(w, r, full)
+			} else {
+				h.respondNoGateway(w, r, req.GatewayGroup)
+			}
+			return
+		}
+		alegs = alegs[:0]
+		for _, gw := range gateways {
+			target := *req.ALegEndpoint
+			target.Gateway = gw
+			dial, err := target.dialString()
+			if err != nil {
+				h.respondError(w, r, "aleg_endpoint: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			alegs = append(alegs, dial)
+		}
+	}
+
+	// CNAM is cosmetic: a failed lookup leaves the name empty rather than
+	// failing the call
+	if req.CallerIDLookup && req.CallerIDName == "" {
+		name, err := h.cidLookup(req.CallerIDNumber)
+		if err != nil {
+			logWarn(requestID, fmt.Sprintf("Caller ID lookup for %s failed: %v", req.CallerIDNumber, err))
+		}
+		req.CallerIDName = name
+	}
+
 	if req.BLeg == "" {
-		req.BLeg = "&park()"
+		req.BLeg = h.defaults.parkAppFor(req.Context)
 	}
 
 	// Build channel variables string
@@ -498,6 +1114,18 @@ func (h *APIHandler) OriginateCall(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// The new call's UUID is chosen up front so the originate command shows
+	// up in the call's command log under this request, and the call is
+	// tagged with the request from the start
+	callUUID, ok := req.ChannelVariables["origination_uuid"].(string)
+	if !ok {
+		callUUID = uuid.New().String()
+		vars = append(vars, "origination_uuid="+callUUID)
+	}
+	for _, kv := range h.claimCall(r, callUUID) {
+		vars = append(vars, fmt.Sprintf("%s=%s", kv[0], kv[1]))
+	}
+
 	// Add caller ID as channel variables (these take precedence)
 	if req.CallerIDNumber != "" {
 		vars = append(vars, fmt.Sprintf("origination_caller_id_number=%s", req.CallerIDNumber))
@@ -507,22 +1135,41 @@ func (h *APIHandler) OriginateCall(w http.ResponseWriter, r *http.Request) {
 		vars = append(vars, fmt.Sprintf("origination_caller_id_name='%s'", req.CallerIDName))
 	}
 
+	// Billing tags ride along as channel variables into CDRs and events
+	for _, kv := range billingVars(req.Billing) {
+		vars = append(vars, fmt.Sprintf("%s=%s", kv[0], kv[1]))
+	}
+
+	// STIR/SHAKEN headers go out on the INVITE to the carrier
+	for _, kv := range stirShakenVars(req.StirShaken) {
+		vars = append(vars, fmt.Sprintf("%s=%s", kv[0], kv[1]))
+	}
+	for _, kv := range priorityVars(req.Priority) {
+		vars = append(vars, fmt.Sprintf("%s=%s", kv[0], kv[1]))
+	}
+	for _, kv := range loc.vars(req.ChannelVariables) {
+		vars = append(vars, fmt.Sprintf("%s=%s", kv[0], kv[1]))
+	}
+
+	// The answering party hears the whisper before the call is connected
+	for _, kv := range h.whisperVars(req.Whisper, req.BLeg) {
+		vars = append(vars, fmt.Sprintf("%s=%s", kv[0], kv[1]))
+	}
+
+	// Enforce the max duration on the A-leg from answer. A dedicated
+	// execute_on_answer_* name leaves a client-supplied execute_on_answer intact.
+	if req.MaxDurationSec > 0 {
+		vars = append(vars, fmt.Sprintf("execute_on_answer_fsapi_max_duration='sched_hangup +%d ALLOTTED_TIMEOUT'", req.MaxDurationSec))
+	}
+
 	var channelVars string
 	if len(vars) > 0 {
 		channelVars = fmt.Sprintf("{%s}", strings.Join(vars, ","))
 	}
 
 	// Build the originate command: originate {vars}aleg bleg [dialplan] [context] [cid_name] [cid_num] [timeout]
+	// The A-leg goes in between when the command is sent
 	var cmd strings.Builder
-	cmd.WriteString("api originate ")
-
-	// Add channel variables if present
-	if channelVars != "" {
-		cmd.WriteString(channelVars)
-	}
-
-	// Add A-leg
-	cmd.WriteString(req.ALeg)
 	cmd.WriteString(" ")
 
 	// Add B-leg (can be extension or &application)
@@ -560,35 +1207,97 @@ func (h *APIHandler) OriginateCall(w http.ResponseWriter, r *http.Request) {
 		cmd.WriteString(fmt.Sprintf("%d", req.TimeoutSec))
 	}
 
-	// Send the originate command
-	response, err := h.eslClient.SendCommand(cmd.String())
+	// Send the originate command, once per gateway of a gateway group until
+	// one does not fail with a failover cause. Gateway caps hold a slot from
+	// each attempt until the A-leg's channel exists.
+	if h.gateways != nil {
+		defer h.gateways.release(callUUID)
+	}
+	var response, gateway string
+	var err error
+	var full []string
+	sent := false
+	for _, aleg := range alegs {
+		if gateways := dialGateways(aleg); h.gateways != nil && !emergency && len(gateways) > 0 {
+			if f := h.gateways.reserve(callUUID, gateways); len(f) > 0 {
+				full = append(full, f...)
+				continue
+			}
+		}
+		response, err = h.eslClient.SendCommand("api originate " + channelVars + aleg + cmd.String())
+		sent = true
+		if req.GatewayGroup == "" {
+			break
+		}
+		gateway = dialGateways(aleg)[0]
+		cause := parseESLErrCause(response)
+		if cause == "" || !h.isFailoverCause(cause) {
+			break
+		}
+		logWarn(requestID, fmt.Sprintf("Originate via gateway %s failed with %s; trying the next gateway", gateway, cause))
+	}
+	if !sent {
+		h.respondGatewayFull(w, r, full)
+		return
+	}
 	if err != nil {
-		statusCode := h.getErrorStatusCode(err)
-		h.respondError(w, r, fmt.Sprintf("Failed to originate call: %v", err), statusCode)
+		if cause := parseESLErrCause(response); cause != "" {
+			h.respondOriginateFailure(w, r, cause)
+			return
+		}
+		h.respondESLError(w, r, fmt.Sprintf("Failed to originate call: %v", err), err)
+		return
+	}
+
+	// FreeSWITCH reports originate failures as "-ERR <CAUSE>" in the api body
+	if cause := parseESLErrCause(response); cause != "" {
+		h.respondOriginateFailure(w, r, cause)
 		return
 	}
 
 	logInfo(requestID, "Call originated successfully")
+	if emergency {
+		h.reportEmergencyOriginate(r, &req, response)
+	}
 
 	// Return the response (usually contains job UUID or call UUID)
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("X-Request-ID", requestID)
 	w.WriteHeader(http.StatusOK)
+	data := map[string]interface{}{
+		"response": strings.TrimSpace(response),
+	}
+	if routes != nil && isUnrestrictedAccess(r) {
+		// Carrier rates are only shown to callers that may read GET /v1/lcr
+		data["lcr_routes"] = routes
+	}
+	if gateway != "" {
+		data["gateway"] = gateway
+	}
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"status": "success",
-		"data": map[string]interface{}{
-			"response": strings.TrimSpace(response),
-		},
+		"data":   data,
 	})
 }
 
+// respondOriginateFailure reports a failed originate with the hangup cause
+// mapped to a distinct HTTP status and code
+func (h *APIHandler) respondOriginateFailure(w http.ResponseWriter, r *http.Request, cause string) {
+	classified := classifyOriginateCause(cause)
+	h.respondErrorBody(w, r, ErrorResponse{
+		Status:  "error",
+		Message: fmt.Sprintf("Failed to originate call: %s", cause),
+		Code:    classified.Code,
+		Cause:   cause,
+	}, classified.Status)
+}
+
 // GET /v1/calls
 func (h *APIHandler) ListCalls(w http.ResponseWriter, r *http.Request) {
 	requestID := getRequestID(r)
 
 	// Check if X-Allowed-Contexts header is present
-	allowedContextsHeader := r.Header.Get("X-Allowed-Contexts")
-	if allowedContextsHeader == "" {
+	if !contextsDeclared(r) {
 		h.respondError(w, r, "X-Allowed-Contexts header is required for this endpoint", http.StatusBadRequest)
 		return
 	}
@@ -600,8 +1309,7 @@ func (h *APIHandler) ListCalls(w http.ResponseWriter, r *http.Request) {
 	// Step 1: Get all calls from FreeSWITCH
 	callsResponse, err := h.eslClient.SendCommand("api show calls as json")
 	if err != nil {
-		statusCode := h.getErrorStatusCode(err)
-		h.respondError(w, r, fmt.Sprintf("Failed to retrieve calls: %v", err), statusCode)
+		h.respondESLError(w, r, fmt.Sprintf("Failed to retrieve calls: %v", err), err)
 		return
 	}
 
@@ -619,37 +1327,22 @@ func (h *APIHandler) ListCalls(w http.ResponseWriter, r *http.Request) {
 	// Step 3: Filter calls based on allowed contexts
 	var filteredCalls []map[string]interface{}
 
+	// Calls with an empty accountcode take their context from their channel,
+	// both for filtering and for normalizing their numbers
+	var contextMap map[string]string
+	if !unrestricted || h.numbers != nil {
+		contextMap = h.channelContextMap()
+	}
+
 	if unrestricted {
 		// Wildcard or no restrictions - return all calls
 		filteredCalls = callsData.Rows
 		logInfo(requestID, fmt.Sprintf("Retrieved all calls (unrestricted access): %d calls", len(filteredCalls)))
 	} else {
-		// Build a context lookup from channels for calls with empty accountcode
-		contextMap := map[string]string{}
-		channelsResponse, err := h.eslClient.SendCommand("api show channels as json")
-		if err == nil {
-			var channelsData struct {
-				Rows []struct {
-					UUID    string `json:"uuid"`
-					Context string `json:"context"`
-				} `json:"rows"`
-			}
-			if json.Unmarshal([]byte(channelsResponse), &channelsData) == nil {
-				for _, ch := range channelsData.Rows {
-					contextMap[ch.UUID] = ch.Context
-				}
-			}
-		}
-
 		// Filter by allowed contexts
 		for _, call := range callsData.Rows {
 			// Prefer accountcode, fall back to channel context
-			callContext, _ := call["accountcode"].(string)
-			if callContext == "" {
-				if uuid, _ := call["uuid"].(string); uuid != "" {
-					callContext = contextMap[uuid]
-				}
-			}
+			callContext := resolveCallContext(call, contextMap)
 			if callContext == "" {
 				continue
 			}
@@ -665,6 +1358,10 @@ func (h *APIHandler) ListCalls(w http.ResponseWriter, r *http.Request) {
 		logInfo(requestID, fmt.Sprintf("Retrieved filtered calls for contexts %v: %d calls", allowedContexts, len(filteredCalls)))
 	}
 
+	for _, call := range filteredCalls {
+		h.normalizeCallRow(call, resolveCallContext(call, contextMap))
+	}
+
 	// Step 4: Return the filtered calls
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("X-Request-ID", requestID)
@@ -676,6 +1373,39 @@ func (h *APIHandler) ListCalls(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// channelContextMap returns a channel UUID -> dialplan context lookup built
+// from "show channels". Errors yield an empty map.
+func (h *APIHandler) channelContextMap() map[string]string {
+	contextMap := map[string]string{}
+	channelsResponse, err := h.eslClient.SendCommand("api show channels as json")
+	if err == nil {
+		var channelsData struct {
+			Rows []struct {
+				UUID    string `json:"uuid"`
+				Context string `json:"context"`
+			} `json:"rows"`
+		}
+		if json.Unmarshal([]byte(channelsResponse), &channelsData) == nil {
+			for _, ch := range channelsData.Rows {
+				contextMap[ch.UUID] = ch.Context
+			}
+		}
+	}
+	return contextMap
+}
+
+// resolveCallContext returns a "show calls" row's context: the accountcode
+// if set, otherwise the channel's dialplan context from contextMap
+func resolveCallContext(call map[string]interface{}, contextMap map[string]string) string {
+	callContext, _ := call["accountcode"].(string)
+	if callContext == "" {
+		if uuid, _ := call["uuid"].(string); uuid != "" {
+			callContext = contextMap[uuid]
+		}
+	}
+	return callContext
+}
+
 // GET /v1/calls/{uuid}
 func (h *APIHandler) GetCallDetails(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -689,7 +1419,8 @@ func (h *APIHandler) GetCallDetails(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate call context (this also checks if call exists)
-	if _, ok := h.validateCallContext(w, r, callUUID); !ok {
+	callCtx, ok := h.validateCallContext(w, r, callUUID)
+	if !ok {
 		return
 	}
 
@@ -698,18 +1429,14 @@ func (h *APIHandler) GetCallDetails(w http.ResponseWriter, r *http.Request) {
 	showCallsCmd := "api show calls as json"
 	callsResponse, err := h.eslClient.SendCommand(showCallsCmd)
 	if err != nil {
-		statusCode := h.getErrorStatusCode(err)
-		h.respondError(w, r, fmt.Sprintf("Failed to retrieve call information: %v", err), statusCode)
+		h.respondESLError(w, r, fmt.Sprintf("Failed to retrieve call information: %v", err), err)
 		return
 	}
 
-	// Step 2: Parse JSON response to extract UUIDs
+	// Step 2: Parse JSON response
 	var callsData struct {
-		RowCount int `json:"row_count"`
-		Rows     []struct {
-			UUID  string `json:"uuid"`
-			BUUID string `json:"b_uuid"`
-		} `json:"rows"`
+		RowCount int                      `json:"row_count"`
+		Rows     []map[string]interface{} `json:"rows"`
 	}
 
 	if err := json.Unmarshal([]byte(callsResponse), &callsData); err != nil {
@@ -719,25 +1446,20 @@ func (h *APIHandler) GetCallDetails(w http.ResponseWriter, r *http.Request) {
 
 	// Find the specific call by UUID (check both A-leg and B-leg UUIDs)
 	var aLegUUID, bLegUUID string
-	var callFound bool
+	var callInfo map[string]interface{}
 	for _, row := range callsData.Rows {
-		if row.UUID == callUUID {
-			// Input UUID matches A-leg
-			aLegUUID = row.UUID
-			bLegUUID = row.BUUID
-			callFound = true
-			break
-		} else if row.BUUID == callUUID {
-			// Input UUID matches B-leg
-			aLegUUID = row.UUID
-			bLegUUID = row.BUUID
-			callFound = true
+		rowUUID, _ := row["uuid"].(string)
+		rowBUUID, _ := row["b_uuid"].(string)
+		if rowUUID == callUUID || rowBUUID == callUUID {
+			aLegUUID = rowUUID
+			bLegUUID = rowBUUID
+			callInfo = row
 			break
 		}
 	}
 
 	// Check if call was found
-	if !callFound {
+	if callInfo == nil {
 		h.respondError(w, r, fmt.Sprintf("Call %s not found", callUUID), http.StatusNotFound)
 		return
 	}
@@ -776,58 +1498,22 @@ func (h *APIHandler) GetCallDetails(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Parse call_info JSON and extract the first row
-	var callInfoWrapper struct {
-		RowCount int                      `json:"row_count"`
-		Rows     []map[string]interface{} `json:"rows"`
-	}
-	if err := json.Unmarshal([]byte(callsResponse), &callInfoWrapper); err != nil {
-		logWarn(requestID, fmt.Sprintf("Failed to parse call info: %v", err))
-		h.respondError(w, r, fmt.Sprintf("Failed to parse call info: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	// Validate that we got data (we already validated the call exists)
-	if len(callInfoWrapper.Rows) == 0 {
-		h.respondError(w, r, "Call data not found in response", http.StatusInternalServerError)
-		return
-	}
-
 	logInfo(requestID, fmt.Sprintf("Call details retrieved for %s", callUUID))
 
-	// Return the complete call information with clean structure
-	// Note: We build the response manually to control field ordering in JSON output
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("X-Request-ID", requestID)
-	w.WriteHeader(http.StatusOK)
-
-	// Build response with ordered keys: status, call_info, aleg (uuid then details), bleg (uuid then details)
-	var responseJSON strings.Builder
-	responseJSON.WriteString(`{"status":"success","call_info":`)
-
-	// Just use call_info as-is from FreeSWITCH (preserves their ordering)
-	callInfoJSON, _ := json.Marshal(callInfoWrapper.Rows[0])
-	responseJSON.Write(callInfoJSON)
-
-	responseJSON.WriteString(`,"aleg":{"uuid":"`)
-	responseJSON.WriteString(aLegUUID)
-	responseJSON.WriteString(`","details":`)
-	aLegJSON, _ := json.Marshal(aLegDetails)
-	responseJSON.Write(aLegJSON)
-	responseJSON.WriteString(`}`)
-
+	// The struct's field order gives the documented key order: status,
+	// call_info, aleg, bleg
+	response := CallDetailsResponse{
+		Status:     "success",
+		CallInfo:   callInfo,
+		ALeg:       CallLeg{UUID: aLegUUID, Details: aLegDetails},
+		StirShaken: stirShakenFromDump(aLegDetails),
+		Version:    callCtx.ETag,
+	}
+	w.Header().Set("ETag", callCtx.ETag)
 	if bLegUUID != "" {
-		responseJSON.WriteString(`,"bleg":{"uuid":"`)
-		responseJSON.WriteString(bLegUUID)
-		responseJSON.WriteString(`","details":`)
-		bLegJSON, _ := json.Marshal(bLegDetails)
-		responseJSON.Write(bLegJSON)
-		responseJSON.WriteString(`}`)
+		response.BLeg = &CallLeg{UUID: bLegUUID, Details: bLegDetails}
 	}
-
-	responseJSON.WriteString(`}`)
-
-	w.Write([]byte(responseJSON.String()))
+	h.respondJSON(w, r, response)
 }
 
 // GET /v1/status
@@ -837,8 +1523,7 @@ func (h *APIHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
 	// Send status command to FreeSWITCH using JSON format
 	response, err := h.eslClient.SendCommand(`api json {"command":"status","data":""}`)
 	if err != nil {
-		statusCode := h.getErrorStatusCode(err)
-		h.respondError(w, r, fmt.Sprintf("Failed to get FreeSWITCH status: %v", err), statusCode)
+		h.respondESLError(w, r, fmt.Sprintf("Failed to get FreeSWITCH status: %v", err), err)
 		return
 	}
 
@@ -874,7 +1559,7 @@ func (h *APIHandler) ListRegistrations(w http.ResponseWriter, r *http.Request) {
 	requestID := getRequestID(r)
 
 	// X-Allowed-Contexts header is required
-	if r.Header.Get("X-Allowed-Contexts") == "" {
+	if !contextsDeclared(r) {
 		h.respondError(w, r, "X-Allowed-Contexts header is required for this endpoint", http.StatusBadRequest)
 		return
 	}
@@ -884,8 +1569,7 @@ func (h *APIHandler) ListRegistrations(w http.ResponseWriter, r *http.Request) {
 
 	response, err := h.eslClient.SendCommand("api show registrations as json")
 	if err != nil {
-		statusCode := h.getErrorStatusCode(err)
-		h.respondError(w, r, fmt.Sprintf("Failed to retrieve registrations: %v", err), statusCode)
+		h.respondESLError(w, r, fmt.Sprintf("Failed to retrieve registrations: %v", err), err)
 		return
 	}
 
@@ -934,7 +1618,7 @@ func (h *APIHandler) CountRegistrations(w http.ResponseWriter, r *http.Request)
 	requestID := getRequestID(r)
 
 	// X-Allowed-Contexts header is required
-	if r.Header.Get("X-Allowed-Contexts") == "" {
+	if !contextsDeclared(r) {
 		h.respondError(w, r, "X-Allowed-Contexts header is required for this endpoint", http.StatusBadRequest)
 		return
 	}
@@ -944,8 +1628,7 @@ func (h *APIHandler) CountRegistrations(w http.ResponseWriter, r *http.Request)
 
 	response, err := h.eslClient.SendCommand("api show registrations as json")
 	if err != nil {
-		statusCode := h.getErrorStatusCode(err)
-		h.respondError(w, r, fmt.Sprintf("Failed to retrieve registrations: %v", err), statusCode)
+		h.respondESLError(w, r, fmt.Sprintf("Failed to retrieve registrations: %v", err), err)
 		return
 	}
 
@@ -992,6 +1675,9 @@ func (h *APIHandler) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	// Try to send a simple command to test ESL connection
 	_, err := h.eslClient.SendCommand("api status")
 	if err != nil {
+		if unavailable, ok := isESLUnavailable(err); ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(unavailable.RetryAfter.Seconds()))))
+		}
 		w.WriteHeader(http.StatusServiceUnavailable)
 		json.NewEncoder(w).Encode(map[string]string{
 			"status":  "unhealthy",
@@ -1001,9 +1687,51 @@ func (h *APIHandler) HealthCheck(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{
-		"status":  "healthy",
+	// FreeSWITCH announced a shutdown or endpoint module unload
+	switchState, remaining := h.switchMon.state()
+
+	if len(h.healthModules) == 0 && switchState == "" {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":  "healthy",
+			"version": Version,
+		})
+		return
+	}
+
+	// Verify configured modules are loaded; a switch with mod_callcenter
+	// unloaded otherwise only shows up as -ERR on the callcenter routes
+	modules := make(map[string]string, len(h.healthModules))
+	allLoaded := true
+	for _, module := range h.healthModules {
+		response, err := h.eslClient.SendCommand(fmt.Sprintf("api module_exists %s", module))
+		switch {
+		case err != nil:
+			modules[module] = "unknown"
+			allLoaded = false
+		case strings.TrimSpace(response) == "true":
+			modules[module] = "loaded"
+		default:
+			modules[module] = "not_loaded"
+			allLoaded = false
+		}
+	}
+
+	status, code := "healthy", http.StatusOK
+	if !allLoaded || switchState != "" {
+		status, code = "degraded", http.StatusServiceUnavailable
+	}
+	body := map[string]interface{}{
+		"status":  status,
 		"version": Version,
-	})
+	}
+	if len(h.healthModules) > 0 {
+		body["modules"] = modules
+	}
+	if switchState != "" {
+		body["switch"] = switchState
+		w.Header().Set("Retry-After", strconv.Itoa(max(int(math.Ceil(remaining.Seconds())), 1)))
+	}
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
 }
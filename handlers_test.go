@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// scriptedESL answers api commands from a fixed table
+type scriptedESL map[string]string
+
+func (s scriptedESL) SendCommand(cmd string) (string, error) {
+	if resp, ok := s[cmd]; ok {
+		return resp, nil
+	}
+	return "", errors.New("-ERR no reply for " + cmd)
+}
+
+func (s scriptedESL) Connect() error { return nil }
+func (s scriptedESL) Close() error   { return nil }
+
+// topLevelKeys returns the keys of the JSON object in data, in order
+func topLevelKeys(t *testing.T, data []byte) []string {
+	t.Helper()
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		t.Fatalf("response is not a JSON object: %s", data)
+	}
+	var keys []string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			t.Fatalf("read key: %v", err)
+		}
+		keys = append(keys, tok.(string))
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			t.Fatalf("read value of %v: %v", tok, err)
+		}
+	}
+	return keys
+}
+
+func TestGetCallDetailsHostileVariables(t *testing.T) {
+	const (
+		otherA = "11111111-1111-1111-1111-111111111111"
+		otherB = "22222222-2222-2222-2222-222222222222"
+		aleg   = "33333333-3333-3333-3333-333333333333"
+		bleg   = "44444444-4444-4444-4444-444444444444"
+	)
+	hostile := `he said "hi" \ and left` + "\n\t}{,"
+
+	dump := func(uuid string) string {
+		data, _ := json.Marshal(map[string]string{
+			"Unique-ID":            uuid,
+			"variable_accountcode": "customer1.example.com",
+			"variable_note":        hostile,
+			`variable_"quoted"`:    `\\`,
+		})
+		return string(data)
+	}
+	calls, _ := json.Marshal(map[string]interface{}{
+		"row_count": 2,
+		"rows": []map[string]string{
+			{"uuid": otherA, "b_uuid": otherB, "cid_name": "someone else"},
+			{"uuid": aleg, "b_uuid": bleg, "cid_name": hostile},
+		},
+	})
+	esl := scriptedESL{
+		"api show calls as json":          string(calls),
+		"api uuid_dump " + aleg + " json": dump(aleg),
+		"api uuid_dump " + bleg + " json": dump(bleg),
+	}
+
+	for _, requested := range []string{aleg, bleg} {
+		h := NewAPIHandler(esl)
+		req := mux.SetURLVars(httptest.NewRequest("GET", "/v1/calls/"+requested, nil), map[string]string{"uuid": requested})
+		rec := httptest.NewRecorder()
+		h.GetCallDetails(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: HTTP %d: %s", requested, rec.Code, rec.Body)
+		}
+		body := rec.Body.Bytes()
+		if !json.Valid(body) {
+			t.Fatalf("%s: invalid JSON: %s", requested, body)
+		}
+		wantKeys := []string{"status", "call_info", "aleg", "bleg", "state_version"}
+		if keys := topLevelKeys(t, body); !reflect.DeepEqual(keys, wantKeys) {
+			t.Errorf("%s: keys %v, want %v", requested, keys, wantKeys)
+		}
+
+		var resp struct {
+			CallInfo map[string]string `json:"call_info"`
+			ALeg     CallLeg           `json:"aleg"`
+			BLeg     CallLeg           `json:"bleg"`
+		}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			t.Fatalf("%s: decode: %v", requested, err)
+		}
+		// The call's own row, not the first one
+		if resp.CallInfo["uuid"] != aleg || resp.CallInfo["cid_name"] != hostile {
+			t.Errorf("%s: call_info %v is not the call's row", requested, resp.CallInfo)
+		}
+		if resp.ALeg.UUID != aleg || resp.BLeg.UUID != bleg {
+			t.Errorf("%s: legs %s/%s, want %s/%s", requested, resp.ALeg.UUID, resp.BLeg.UUID, aleg, bleg)
+		}
+		for _, leg := range []CallLeg{resp.ALeg, resp.BLeg} {
+			if leg.Details["variable_note"] != hostile || leg.Details[`variable_"quoted"`] != `\\` {
+				t.Errorf("%s: %s details mangled: %v", requested, leg.UUID, leg.Details)
+			}
+		}
+	}
+}
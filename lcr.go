@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// route_via value of POST /v1/calls/originate that lets mod_lcr pick the
+// gateways for aleg_endpoint.number
+const routeViaLCR = "lcr"
+
+// LCRRoute is one route candidate returned by mod_lcr, cheapest first
+type LCRRoute struct {
+	Prefix     string  `json:"prefix"`  // Digit prefix that matched
+	Carrier    string  `json:"carrier"` // Carrier name
+	Rate       float64 `json:"rate"`
+	Codec      string  `json:"codec,omitempty"`
+	CID        string  `json:"cid,omitempty"` // Caller ID rewrite for the carrier
+	DialString string  `json:"dial_string"`   // Ready to originate, including the carrier's per-leg variables
+}
+
+// lcrResult is the "lcr <digits> [profile] as xml" reply
+type lcrResult struct {
+	Rows []struct {
+		Prefix      string `xml:"prefix"`
+		CarrierName string `xml:"carrier_name"`
+		Rate        string `xml:"rate"`
+		Codec       string `xml:"codec"`
+		CID         string `xml:"cid"`
+		DialString  string `xml:"dialstring"`
+	} `xml:"row"`
+}
+
+// lcrRoutes asks mod_lcr for the routes to number, cheapest first. An empty
+// profile uses mod_lcr's default profile.
+func (h *APIHandler) lcrRoutes(number, profile string) ([]LCRRoute, error) {
+	cmd := "api lcr " + strings.TrimPrefix(number, "+")
+	if profile != "" {
+		cmd += " " + profile
+	}
+	response, err := h.eslClient.SendCommand(cmd + " as xml")
+	if err != nil {
+		return nil, err
+	}
+	routes := []LCRRoute{}
+	if !strings.Contains(response, "<row") {
+		return routes, nil // "No Routes" or an empty result
+	}
+	var result lcrResult
+	if err := xml.Unmarshal([]byte(response), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse lcr output: %v", err)
+	}
+	for _, row := range result.Rows {
+		rate, _ := strconv.ParseFloat(strings.TrimSpace(row.Rate), 64)
+		routes = append(routes, LCRRoute{
+			Prefix:     strings.TrimSpace(row.Prefix),
+			Carrier:    strings.TrimSpace(row.CarrierName),
+			Rate:       rate,
+			Codec:      strings.TrimSpace(row.Codec),
+			CID:        strings.TrimSpace(row.CID),
+			DialString: strings.TrimSpace(row.DialString),
+		})
+	}
+	return routes, nil
+}
+
+// checkLCRTarget validates an aleg_endpoint routed via LCR: a gateway
+// endpoint with a number and no gateway, which mod_lcr picks
+func checkLCRTarget(t *DialTarget) error {
+	if t.Type != dialTypeGateway || t.Gateway != "" || t.User != "" || t.Domain != "" {
+		return fmt.Errorf("route_via lcr takes an aleg_endpoint of type gateway with only a number")
+	}
+	if !e164Pattern.MatchString(t.Number) {
+		return fmt.Errorf("aleg_endpoint: number must be an E.164 number (up to 15 digits, optional leading '+')")
+	}
+	return nil
+}
+
+// lcrDialString joins the routes' dial strings so FreeSWITCH fails over from
+// the cheapest carrier to the next
+func lcrDialString(routes []LCRRoute) string {
+	dials := make([]string, len(routes))
+	for i, route := range routes {
+		dials[i] = route.DialString
+	}
+	return strings.Join(dials, "|")
+}
+
+// GET /v1/lcr?number=E164[&profile=name]
+func (h *APIHandler) GetLCRRoutes(w http.ResponseWriter, r *http.Request) {
+	if !h.requireUnrestricted(w, r) {
+		return
+	}
+	number := r.URL.Query().Get("number")
+	if !e164Pattern.MatchString(number) {
+		h.respondError(w, r, "number must be an E.164 number (up to 15 digits, optional leading '+')", http.StatusBadRequest)
+		return
+	}
+	profile := r.URL.Query().Get("profile")
+	if profile != "" && !isValidName(profile) {
+		h.respondError(w, r, "invalid profile name", http.StatusBadRequest)
+		return
+	}
+
+	routes, err := h.lcrRoutes(number, profile)
+	if err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to look up LCR routes: %v", err), err)
+		return
+	}
+	h.respondJSON(w, r, map[string]interface{}{
+		"status":    "success",
+		"row_count": len(routes),
+		"rows":      routes,
+	})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// jobTracker counts in-flight ESL commands and background jobs so that
+// shutdown can wait for them before the ESL connection is closed.
+type jobTracker struct {
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+	active   int
+	draining bool
+	stopCh   chan struct{}
+	hooks    []shutdownHook
+}
+
+// shutdownHook persists subsystem state (e.g. undelivered work) once draining
+// has finished or timed out, so it can be resumed on the next start.
+type shutdownHook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+func newJobTracker() *jobTracker {
+	return &jobTracker{stopCh: make(chan struct{})}
+}
+
+// stopping returns a channel that is closed when draining starts. Long-running
+// loops and retry waits select on it to exit early.
+func (t *jobTracker) stopping() <-chan struct{} {
+	return t.stopCh
+}
+
+// begin registers a unit of work. It returns false once draining has started,
+// in which case new background jobs should not be launched.
+func (t *jobTracker) begin() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.draining {
+		return false
+	}
+	t.active++
+	t.wg.Add(1)
+	return true
+}
+
+// track registers a unit of work even while draining. Used for ESL commands
+// issued by requests that are already being served.
+func (t *jobTracker) track() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active++
+	t.wg.Add(1)
+}
+
+// end marks a unit of work registered with begin as finished.
+func (t *jobTracker) end() {
+	t.mu.Lock()
+	t.active--
+	t.mu.Unlock()
+	t.wg.Done()
+}
+
+// inFlight returns the number of currently tracked operations.
+func (t *jobTracker) inFlight() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.active
+}
+
+// goJob runs fn in a tracked goroutine. Returns false if the tracker is
+// draining and the job was not started.
+func (t *jobTracker) goJob(name string, fn func()) bool {
+	if !t.begin() {
+		log.Printf("Not starting background job %s: shutting down", name)
+		return false
+	}
+	go func() {
+		defer t.end()
+		fn()
+	}()
+	return true
+}
+
+// onShutdown registers a hook that runs after draining.
+func (t *jobTracker) onShutdown(name string, fn func(ctx context.Context) error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.hooks = append(t.hooks, shutdownHook{name: name, fn: fn})
+}
+
+// drain stops accepting new background jobs, waits (bounded by ctx) for
+// in-flight work to finish, then runs the registered shutdown hooks.
+func (t *jobTracker) drain(ctx context.Context) {
+	t.mu.Lock()
+	if !t.draining {
+		t.draining = true
+		close(t.stopCh)
+	}
+	hooks := t.hooks
+	t.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Println("All in-flight ESL operations and background jobs finished")
+	case <-ctx.Done():
+		log.Printf("Drain timed out with %d operation(s) still in flight", t.inFlight())
+	}
+
+	for _, hook := range hooks {
+		if err := hook.fn(context.Background()); err != nil {
+			log.Printf("Shutdown hook %s failed: %v", hook.name, err)
+		}
+	}
+}
+
+// trackedESLClient wraps an ESLClient so every command counts as in-flight
+// work for the job tracker and is traced under the calls it names. Calls a
+// mutating API request changes are first tagged with its request ID.
+type trackedESLClient struct {
+	ESLClient
+	jobs   *jobTracker
+	traces *callTraces
+}
+
+func (c *trackedESLClient) SendCommand(cmd string) (string, error) {
+	c.jobs.track()
+	defer c.jobs.end()
+	for _, tag := range c.traces.correlationCommands(cmd) {
+		// Fails harmlessly when the call is gone; not traced itself
+		c.ESLClient.SendCommand(tag)
+	}
+	response, err := c.ESLClient.SendCommand(cmd)
+	c.traces.recordCommand(cmd, response, err)
+	return response, err
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Headers giving the locale of a request; a request's locale and timezone
+// fields take precedence
+const (
+	localeHeader   = "X-Locale"
+	timezoneHeader = "X-Timezone"
+)
+
+// localeTagPattern matches a language, optionally with a region: de, de-DE
+// or pt_BR
+var localeTagPattern = regexp.MustCompile(`^([A-Za-z]{2,3})(?:[-_]([A-Za-z]{2}))?$`)
+
+// callLocale is the language and timezone the calls and prompts of a
+// request are made in
+type callLocale struct {
+	tag      string // e.g. de-DE
+	language string // e.g. de
+	timezone string // IANA name, e.g. Europe/Berlin
+}
+
+// parseLocale checks a language tag and a timezone, either of which may be
+// empty, and returns nil when both are
+func parseLocale(tag, timezone string) (*callLocale, error) {
+	if tag == "" && timezone == "" {
+		return nil, nil
+	}
+	loc := &callLocale{timezone: timezone}
+	if tag != "" {
+		m := localeTagPattern.FindStringSubmatch(tag)
+		if m == nil {
+			return nil, fmt.Errorf("locale %q must be a language, optionally with a region (e.g. de or de-DE)", tag)
+		}
+		loc.language = strings.ToLower(m[1])
+		loc.tag = loc.language
+		if m[2] != "" {
+			loc.tag += "-" + strings.ToUpper(m[2])
+		}
+	}
+	if timezone != "" {
+		if _, err := time.LoadLocation(timezone); err != nil || timezone == "Local" {
+			return nil, fmt.Errorf("timezone %q is not an IANA timezone (e.g. Europe/Berlin)", timezone)
+		}
+	}
+	return loc, nil
+}
+
+// requestLocale returns the locale of r from the locale and timezone of its
+// body, or else its X-Locale and X-Timezone headers; nil when it has none.
+// On failure it has already responded.
+func (h *APIHandler) requestLocale(w http.ResponseWriter, r *http.Request, tag, timezone string) (*callLocale, bool) {
+	if tag == "" {
+		tag = strings.TrimSpace(r.Header.Get(localeHeader))
+	}
+	if timezone == "" {
+		timezone = strings.TrimSpace(r.Header.Get(timezoneHeader))
+	}
+	loc, err := parseLocale(tag, timezone)
+	if err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return loc, true
+}
+
+// vars returns the channel variables that carry loc onto a call:
+// default_language for sound prompts and phrases, and timezone for the
+// dates and times the call says. Variables in set are left as they are.
+func (loc *callLocale) vars(set map[string]interface{}) [][2]string {
+	if loc == nil {
+		return nil
+	}
+	var vars [][2]string
+	for _, kv := range [][2]string{{"default_language", loc.language}, {"timezone", loc.timezone}} {
+		if _, ok := set[kv[0]]; kv[1] != "" && !ok {
+			vars = append(vars, kv)
+		}
+	}
+	return vars
+}
+
+// parseTTSVoices parses FSAPI_TTS_VOICES: "engine:locale=voice,...", the
+// voice a TTS prompt of engine uses in a locale when it names none
+func parseTTSVoices(value string) (map[string]string, error) {
+	values, err := parseContextValues(value, func(v string) error {
+		if !isValidChannelVarName(v) {
+			return fmt.Errorf("%q is not a voice name", v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	voices := make(map[string]string, len(values))
+	for key, voice := range values {
+		engine, tag, ok := strings.Cut(key, ":")
+		if !ok || !isValidChannelVarName(engine) {
+			return nil, fmt.Errorf("invalid entry %q (expected engine:locale=voice)", key)
+		}
+		loc, err := parseLocale(tag, "")
+		if err != nil || loc == nil {
+			return nil, fmt.Errorf("invalid entry %q (expected engine:locale=voice)", key)
+		}
+		voices[engine+":"+loc.tag] = voice
+	}
+	return voices, nil
+}
+
+// localize fills in what the prompts of a request leave out in loc: the
+// language a value is said in, and the voice of tts from FSAPI_TTS_VOICES
+// for the locale or its language
+func (h *APIHandler) localize(loc *callLocale, tts *AnnounceTTS, say *SayPrompt) {
+	if loc == nil || loc.language == "" {
+		return
+	}
+	if say != nil && say.Language == "" {
+		say.Language = loc.language
+	}
+	if tts != nil && tts.Voice == "" {
+		if voice, ok := h.ttsVoices[tts.Engine+":"+loc.tag]; ok {
+			tts.Voice = voice
+		} else if voice, ok := h.ttsVoices[tts.Engine+":"+loc.language]; ok {
+			tts.Voice = voice
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Console log levels accepted by the ESL "log" command, in FreeSWITCH's
+// numeric order (0 = console ... 7 = debug)
+var logLevels = []string{"console", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+const (
+	logStreamDefaultIdle = 300 * time.Second
+	logStreamMaxIdle     = time.Hour
+	logStreamBuffer      = 256
+)
+
+// LogLine is one FreeSWITCH console log line
+type LogLine struct {
+	Time     time.Time `json:"timestamp"`
+	Level    string    `json:"level"`
+	File     string    `json:"file,omitempty"`
+	Line     string    `json:"line,omitempty"`
+	Function string    `json:"function,omitempty"`
+	UUID     string    `json:"uuid,omitempty"`
+	Text     string    `json:"text"`
+}
+
+// LogSource is implemented by ESL clients that can stream the FreeSWITCH
+// console log. StreamLogs delivers lines at level and above until ctx is
+// done or the connection fails.
+type LogSource interface {
+	StreamLogs(ctx context.Context, level string, deliver func(*LogLine)) error
+}
+
+// logLevelIncluded reports whether a line at lineLevel is shown by a
+// subscription at level
+func logLevelIncluded(lineLevel, level string) bool {
+	for _, l := range logLevels {
+		if l == lineLevel {
+			return true
+		}
+		if l == level {
+			return false
+		}
+	}
+	return false
+}
+
+// readESLMessage reads one ESL message: MIME headers followed by a body of
+// Content-Length bytes
+func readESLMessage(reader *textproto.Reader) (textproto.MIMEHeader, []byte, error) {
+	header, err := reader.ReadMIMEHeader()
+	if err != nil {
+		return nil, nil, err
+	}
+	var body []byte
+	if length, err := strconv.Atoi(header.Get("Content-Length")); err == nil && length > 0 {
+		body = make([]byte, length)
+		if _, err := io.ReadFull(reader.R, body); err != nil {
+			return nil, nil, err
+		}
+	}
+	return header, body, nil
+}
+
+// StreamLogs opens a dedicated ESL connection and subscribes to the console
+// log. eslgo drops the connection on text/log/data frames, so this speaks
+// the plain ESL protocol.
+func (esl *ESLgoClient) StreamLogs(ctx context.Context, level string, deliver func(*LogLine)) error {
+	conn, err := net.DialTimeout("tcp", esl.host+":"+esl.port, 10*time.Second)
+	if err != nil {
+		return &ESLUnavailableError{Err: err}
+	}
+	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	reader := textproto.NewReader(bufio.NewReader(conn))
+	command := func(cmd string) error {
+		if _, err := fmt.Fprintf(conn, "%s\n\n", cmd); err != nil {
+			return err
+		}
+		for {
+			header, _, err := readESLMessage(reader)
+			if err != nil {
+				return err
+			}
+			if header.Get("Content-Type") != "command/reply" {
+				continue
+			}
+			if reply := header.Get("Reply-Text"); strings.HasPrefix(reply, "-ERR") {
+				return fmt.Errorf("%s", reply)
+			}
+			return nil
+		}
+	}
+
+	if header, _, err := readESLMessage(reader); err != nil || header.Get("Content-Type") != "auth/request" {
+		return &ESLUnavailableError{Err: fmt.Errorf("no auth request from FreeSWITCH: %v", err)}
+	}
+	if err := command("auth " + esl.password); err != nil {
+		return &ESLUnavailableError{Err: fmt.Errorf("%w: check ESL_PASSWORD", ErrESLAuthFailed)}
+	}
+	if err := command("log " + level); err != nil {
+		return err
+	}
+
+	for {
+		header, body, err := readESLMessage(reader)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		switch header.Get("Content-Type") {
+		case "text/log/data":
+			lineLevel := header.Get("Log-Level")
+			if n, err := strconv.Atoi(lineLevel); err == nil && n >= 0 && n < len(logLevels) {
+				lineLevel = logLevels[n]
+			}
+			deliver(&LogLine{
+				Time:     time.Now().UTC(),
+				Level:    lineLevel,
+				File:     header.Get("Log-File"),
+				Line:     header.Get("Log-Line"),
+				Function: header.Get("Log-Func"),
+				UUID:     header.Get("User-Data"),
+				Text:     strings.TrimRight(string(body), "\r\n"),
+			})
+		case "text/disconnect-notice":
+			return fmt.Errorf("FreeSWITCH closed the log stream")
+		}
+	}
+}
+
+// GET /v1/admin/logs/stream?level=&idle_timeout=
+func (h *APIHandler) StreamLogs(w http.ResponseWriter, r *http.Request) {
+	if !h.requireUnrestricted(w, r) {
+		return
+	}
+	source := h.logSource
+	if source == nil {
+		h.respondError(w, r, "Log streaming is not supported by this ESL backend", http.StatusNotImplemented)
+		return
+	}
+
+	level := r.URL.Query().Get("level")
+	if level == "" {
+		level = "info"
+	}
+	if !containsString(logLevels, level) {
+		h.respondError(w, r, fmt.Sprintf("invalid level '%s': must be one of: %s", level, strings.Join(logLevels, ", ")), http.StatusBadRequest)
+		return
+	}
+	idle := logStreamDefaultIdle
+	if v := r.URL.Query().Get("idle_timeout"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds <= 0 || time.Duration(seconds)*time.Second > logStreamMaxIdle {
+			h.respondError(w, r, fmt.Sprintf("idle_timeout must be between 1 and %d seconds", int(logStreamMaxIdle.Seconds())), http.StatusBadRequest)
+			return
+		}
+		idle = time.Duration(seconds) * time.Second
+	}
+
+	// The server's WriteTimeout would cut the stream after 15s
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		h.respondError(w, r, "Streaming is not supported by this connection", http.StatusInternalServerError)
+		return
+	}
+
+	h.audit(r, AuditEntry{
+		Action:  "admin.logs.stream",
+		Target:  level,
+		Details: map[string]string{"idle_timeout": idle.String()},
+	})
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	lines := make(chan *LogLine, logStreamBuffer)
+	done := make(chan error, 1)
+	var dropped atomic.Int64
+	go func() {
+		done <- source.StreamLogs(ctx, level, func(line *LogLine) {
+			// Never let a slow client stall the ESL reader
+			select {
+			case lines <- line:
+			default:
+				dropped.Add(1)
+			}
+		})
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Request-ID", getRequestID(r))
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	send := func(event string, data interface{}) bool {
+		payload, _ := json.Marshal(data)
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	timer := time.NewTimer(idle)
+	defer timer.Stop()
+	for {
+		select {
+		case line := <-lines:
+			if !send("log", line) {
+				return
+			}
+			timer.Reset(idle)
+		case <-timer.C:
+			send("end", map[string]string{"reason": "idle_timeout"})
+			return
+		case err := <-done:
+			if err != nil {
+				logWarn(getRequestID(r), fmt.Sprintf("Log stream failed: %v", err))
+				send("end", map[string]string{"reason": "error", "error": err.Error()})
+			}
+			return
+		case <-h.streamsClosing:
+			send("end", map[string]string{"reason": "shutdown"})
+			return
+		case <-r.Context().Done():
+			if n := dropped.Load(); n > 0 {
+				logInfo(getRequestID(r), fmt.Sprintf("Log stream closed, %d line(s) dropped for a slow client", n))
+			}
+			return
+		}
+	}
+}
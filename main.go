@@ -2,12 +2,17 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
-	"strings"
+	"path/filepath"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -16,78 +21,833 @@ import (
 
 const Version = "0.4.2"
 
+// HTTP server timeouts
+const (
+	serverReadTimeout  = 15 * time.Second
+	serverWriteTimeout = 15 * time.Second
+	serverIdleTimeout  = 60 * time.Second
+)
+
 var (
 	FSAPI_PORT        = getEnv("FSAPI_PORT", "37274")
 	ESL_HOST          = getEnv("ESL_HOST", "localhost")
 	ESL_PORT          = getEnv("ESL_PORT", "8021")
 	ESL_PASSWORD      = getEnv("ESL_PASSWORD", "ClueCon")
 	FSAPI_AUTH_TOKENS = getEnv("FSAPI_AUTH_TOKENS", "")
+
+	// Brute-force protection: lock a source IP or a rejected token (by its full fingerprint) out for FSAPI_AUTH_LOCKOUT seconds
+	// after FSAPI_AUTH_MAX_FAILURES failures within FSAPI_AUTH_FAILURE_WINDOW seconds (0 disables);
+	// failed attempts are delayed by up to FSAPI_AUTH_TARPIT seconds
+	FSAPI_AUTH_MAX_FAILURES   = getEnv("FSAPI_AUTH_MAX_FAILURES", "10")
+	FSAPI_AUTH_FAILURE_WINDOW = getEnv("FSAPI_AUTH_FAILURE_WINDOW", "60")
+	FSAPI_AUTH_LOCKOUT        = getEnv("FSAPI_AUTH_LOCKOUT", "300")
+	FSAPI_AUTH_TARPIT         = getEnv("FSAPI_AUTH_TARPIT", "0")
+
+	// Longest lifetime in seconds of a session token minted with POST /v1/auth/sessions
+	FSAPI_SESSION_MAX_TTL = getEnv("FSAPI_SESSION_MAX_TTL", "3600")
+
+	// HMAC request signing keys "keyid:secret,..." and the accepted timestamp skew in seconds
+	FSAPI_SIGNING_KEYS     = getEnv("FSAPI_SIGNING_KEYS", "")
+	FSAPI_SIGNATURE_WINDOW = getEnv("FSAPI_SIGNATURE_WINDOW", "300")
+
+	// Single-use nonces on signed mutating requests, accepted within
+	// FSAPI_REPLAY_WINDOW seconds of their timestamp
+	FSAPI_REPLAY_PROTECTION = getEnv("FSAPI_REPLAY_PROTECTION", "false")
+	FSAPI_REPLAY_WINDOW     = getEnv("FSAPI_REPLAY_WINDOW", "60")
+
+	// External authorization endpoint asked before mutating requests (OPA-style); empty disables it
+	FSAPI_POLICY_URL       = getEnv("FSAPI_POLICY_URL", "")
+	FSAPI_POLICY_TIMEOUT   = getEnv("FSAPI_POLICY_TIMEOUT", "2")
+	FSAPI_POLICY_CACHE_TTL = getEnv("FSAPI_POLICY_CACHE_TTL", "30")
+	FSAPI_POLICY_FAIL_OPEN = getEnv("FSAPI_POLICY_FAIL_OPEN", "false")
+
+	// "live" (default) talks to FreeSWITCH; "mock" uses the in-memory simulator
+	FSAPI_MODE = getEnv("FSAPI_MODE", "live")
+
+	// Startup ESL preflight
+	FSAPI_WAIT_FOR_ESL     = getEnv("FSAPI_WAIT_FOR_ESL", "false")
+	FSAPI_ESL_WAIT_TIMEOUT = getEnv("FSAPI_ESL_WAIT_TIMEOUT", "30")
+
+	// Comma-separated FreeSWITCH modules the health check verifies (e.g. mod_callcenter)
+	FSAPI_HEALTH_MODULES = getEnv("FSAPI_HEALTH_MODULES", "")
+
+	// Seconds to wait for in-flight ESL operations on shutdown
+	FSAPI_DRAIN_TIMEOUT = getEnv("FSAPI_DRAIN_TIMEOUT", "15")
+
+	// Directory for persisted state: the SQLite database, or JSON files with FSAPI_STORE=file
+	FSAPI_DATA_DIR = getEnv("FSAPI_DATA_DIR", "/var/lib/fs-api")
+
+	// Storage driver for persisted state: sqlite, postgres or file (JSON files in FSAPI_DATA_DIR)
+	FSAPI_STORE = getEnv("FSAPI_STORE", "sqlite")
+
+	// SQLite database path (default FSAPI_DATA_DIR/fs-api.db) or Postgres connection URL
+	FSAPI_STORE_DSN = getEnv("FSAPI_STORE_DSN", "")
+
+	// Subscribe to FreeSWITCH events (CDRs, hangup webhooks) over a second ESL connection
+	FSAPI_EVENTS = getEnv("FSAPI_EVENTS", "true")
+
+	// Defaults for omitted request fields as "context=value,*=value"
+	FSAPI_HANGUP_CAUSE  = getEnv("FSAPI_HANGUP_CAUSE", "*=NORMAL_CLEARING")
+	FSAPI_DTMF_DURATION = getEnv("FSAPI_DTMF_DURATION", "*=100")
+	FSAPI_PARK_APP      = getEnv("FSAPI_PARK_APP", "*=&park()")
+
+	// SIP header carrying the attestation level originates ask for to the signing service ("" to not send one)
+	FSAPI_STIR_ATTESTATION_HEADER = getEnv("FSAPI_STIR_ATTESTATION_HEADER", "X-Attestation")
+
+	// Numbers an originate with priority emergency may call
+	FSAPI_EMERGENCY_NUMBERS = getEnv("FSAPI_EMERGENCY_NUMBERS", "911,112")
+
+	// Voicemail profile for transfers to_voicemail, and the dialplan extension to use instead of the voicemail app (e.g. "*99{user}")
+	FSAPI_VOICEMAIL_PROFILE   = getEnv("FSAPI_VOICEMAIL_PROFILE", "default")
+	FSAPI_VOICEMAIL_EXTENSION = getEnv("FSAPI_VOICEMAIL_EXTENSION", "")
+
+	// mod_conference profile of conference rooms, and the join link template shown for them ({room}, {context})
+	FSAPI_CONFERENCE_PROFILE  = getEnv("FSAPI_CONFERENCE_PROFILE", "default")
+	FSAPI_CONFERENCE_JOIN_URL = getEnv("FSAPI_CONFERENCE_JOIN_URL", "")
+
+	// Directory FreeSWITCH writes conference recordings under, in a subdirectory per context
+	FSAPI_RECORDING_DIR = getEnv("FSAPI_RECORDING_DIR", "/var/lib/freeswitch/recordings")
+
+	// Seconds originates are refused after FreeSWITCH announces a shutdown or endpoint module unload
+	FSAPI_SWITCH_PAUSE = getEnv("FSAPI_SWITCH_PAUSE", "30")
+
+	// Seconds deleted webhooks, DIDs and flow templates stay restorable in the trash; 0 deletes them at once
+	FSAPI_TRASH_RETENTION = getEnv("FSAPI_TRASH_RETENTION", "604800")
+
+	// Number of most recent CDRs kept in memory and in cdrs.jsonl
+	FSAPI_CDR_RETENTION = getEnv("FSAPI_CDR_RETENTION", "10000")
+
+	// Queue service-level answer thresholds: "queue=seconds,*=seconds"
+	FSAPI_SLA_THRESHOLDS = getEnv("FSAPI_SLA_THRESHOLDS", "*=20")
+
+	// Directory included by callcenter.conf.xml for API-provisioned queues; empty disables provisioning
+	FSAPI_CC_QUEUE_DIR = getEnv("FSAPI_CC_QUEUE_DIR", "")
+
+	// Directory under which API-provisioned users are written as <domain>/<id>.xml; empty disables provisioning
+	FSAPI_DIRECTORY_DIR = getEnv("FSAPI_DIRECTORY_DIR", "")
+
+	// Directory included by sofia profiles' <aliases> for API-provisioned domain aliases; empty disables them
+	FSAPI_SOFIA_ALIAS_DIR = getEnv("FSAPI_SOFIA_ALIAS_DIR", "")
+
+	// Directory under which uploaded prompts are stored as <context>/<name>; empty disables the media API
+	FSAPI_MEDIA_DIR = getEnv("FSAPI_MEDIA_DIR", "")
+
+	// Directory rendered TTS speech is cached in, and the most it may hold in bytes; empty disables the cache
+	FSAPI_TTS_CACHE_DIR       = getEnv("FSAPI_TTS_CACHE_DIR", "")
+	FSAPI_TTS_CACHE_MAX_BYTES = getEnv("FSAPI_TTS_CACHE_MAX_BYTES", "1073741824")
+
+	// TTS voices by engine and request locale: "engine:locale=voice,..."
+	FSAPI_TTS_VOICES = getEnv("FSAPI_TTS_VOICES", "")
+
+	// Dialplan include file rewritten from the DID registry on every change; empty disables it
+	FSAPI_DID_DIALPLAN_FILE = getEnv("FSAPI_DID_DIALPLAN_FILE", "")
+
+	// Dialplan context whose calls are routed by the DID registry
+	FSAPI_DID_CONTEXT = getEnv("FSAPI_DID_CONTEXT", "public")
+
+	// mod_xml_curl sections served from fs-api's stores: directory,dialplan,configuration; empty disables it
+	FSAPI_XML_CURL_SECTIONS = getEnv("FSAPI_XML_CURL_SECTIONS", "")
+
+	// odbc-dsn setting of the callcenter.conf served over xml_curl
+	FSAPI_CC_ODBC_DSN = getEnv("FSAPI_CC_ODBC_DSN", "")
+
+	// Recent events kept for ?after= / Last-Event-ID catch-up
+	FSAPI_EVENT_BUFFER = getEnv("FSAPI_EVENT_BUFFER", "1000")
+
+	// Request body size limits in bytes per route class: "calls=bytes,callcenter=bytes,provisioning=bytes,xml_curl=bytes,graphql=bytes,*=bytes"
+	FSAPI_BODY_LIMITS = getEnv("FSAPI_BODY_LIMITS", "*=1048576")
+
+	// Read-only GraphQL endpoint at /v1/graphql (true/false)
+	FSAPI_GRAPHQL = getEnv("FSAPI_GRAPHQL", "false")
+
+	// Channel variables copied into CDRs and call.hangup webhooks
+	FSAPI_CDR_VARS = getEnv("FSAPI_CDR_VARS", "")
+
+	// Link to a capture server search reported by SIP captures; {call_id}, {uuid}, {start}, {end} are substituted
+	FSAPI_CAPTURE_URL = getEnv("FSAPI_CAPTURE_URL", "")
+
+	// Channel variables (e.g. collected IVR digits) included in agent.screen_pop webhooks
+	FSAPI_SCREENPOP_VARS = getEnv("FSAPI_SCREENPOP_VARS", "")
+
+	// Agent status from SIP registrations: "domain=mode,*=mode" with mode off, logout or both; empty disables it
+	FSAPI_PRESENCE_SYNC = getEnv("FSAPI_PRESENCE_SYNC", "")
+
+	// Concurrent call and new-channel-per-second alert thresholds: "context=value,*=value,total=value"
+	FSAPI_CALL_ALERTS = getEnv("FSAPI_CALL_ALERTS", "")
+	FSAPI_SPS_ALERTS  = getEnv("FSAPI_SPS_ALERTS", "")
+
+	// Concurrent call caps enforced on originate: "gateway=calls,*=calls"; requires FSAPI_EVENTS
+	FSAPI_GATEWAY_CAPS = getEnv("FSAPI_GATEWAY_CAPS", "")
+
+	// Gateway groups for originate's gateway_group: "group=gw1|gw2,other=gw3|gw4",
+	// and the hangup causes after which a call moves on to the next gateway
+	FSAPI_GATEWAY_GROUPS          = getEnv("FSAPI_GATEWAY_GROUPS", "")
+	FSAPI_GATEWAY_FAILOVER_CAUSES = getEnv("FSAPI_GATEWAY_FAILOVER_CAUSES", "GATEWAY_DOWN,NORMAL_TEMPORARY_FAILURE,NETWORK_OUT_OF_ORDER,DESTINATION_OUT_OF_ORDER,RECOVERY_ON_TIMER_EXPIRE,SWITCH_CONGESTION,NORMAL_CIRCUIT_CONGESTION")
+
+	// Seconds of finished gateway calls kept for ASR, ACD and PDD by route
+	FSAPI_ROUTE_STATS_WINDOW = getEnv("FSAPI_ROUTE_STATS_WINDOW", "3600")
+
+	// Default country for E.164 number normalization: "context=CC[:trunk],*=CC"; empty disables it
+	FSAPI_NUMBER_COUNTRY = getEnv("FSAPI_NUMBER_COUNTRY", "")
+
+	// SMTP relay (host:port) and sender for alert e-mails; empty disables e-mail
+	FSAPI_SMTP_ADDR     = getEnv("FSAPI_SMTP_ADDR", "")
+	FSAPI_SMTP_USERNAME = getEnv("FSAPI_SMTP_USERNAME", "")
+	FSAPI_SMTP_PASSWORD = getEnv("FSAPI_SMTP_PASSWORD", "")
+	FSAPI_SMTP_FROM     = getEnv("FSAPI_SMTP_FROM", "fs-api@localhost")
+
+	// Long-call watchdog: "context=seconds,*=seconds"; empty disables it
+	FSAPI_WATCHDOG_MAX_DURATION = getEnv("FSAPI_WATCHDOG_MAX_DURATION", "")
+	FSAPI_WATCHDOG_ACTION       = getEnv("FSAPI_WATCHDOG_ACTION", "flag")
+	FSAPI_WATCHDOG_WARN_BEFORE  = getEnv("FSAPI_WATCHDOG_WARN_BEFORE", "60")
+	FSAPI_WATCHDOG_INTERVAL     = getEnv("FSAPI_WATCHDOG_INTERVAL", "30")
 )
 
 func main() {
-	handler := NewAPIHandler(ESL_HOST, ESL_PORT, ESL_PASSWORD)
+	validate := flag.Bool("validate-config", false, "Check the environment configuration, print a report and exit (status 1 if invalid)")
+	checkESL := flag.Bool("check-esl", false, "With -validate-config, also connect to FreeSWITCH ESL")
+	reportJSON := flag.Bool("json", false, "With -validate-config, print the report as JSON")
+	pidFile := flag.String("pid-file", "", "Write the process ID to this file while running")
+	flag.Parse()
+	if *validate {
+		report := validateConfig(*checkESL)
+		report.write(os.Stdout, *reportJSON)
+		if !report.Valid {
+			os.Exit(exitConfig)
+		}
+		return
+	}
 
-	// Parse authentication tokens
-	var authTokens []string
-	if FSAPI_AUTH_TOKENS != "" {
-		tokens := strings.Split(FSAPI_AUTH_TOKENS, ",")
-		for _, token := range tokens {
-			trimmed := strings.TrimSpace(token)
-			if trimmed != "" {
-				authTokens = append(authTokens, trimmed)
+	var eslClient ESLClient
+	switch FSAPI_MODE {
+	case "live":
+		eslClient = NewESLClient(ESL_HOST, ESL_PORT, ESL_PASSWORD)
+	case "mock":
+		log.Println("WARNING: FSAPI_MODE=mock - using simulated ESL backend, no FreeSWITCH calls will be made")
+		eslClient = NewMockESLClient()
+	default:
+		fatalConfig("Invalid FSAPI_MODE: %q (expected live or mock)", FSAPI_MODE)
+	}
+
+	// Only used at shutdown, but a typo should stop the start, not the drain
+	drainSec, err := strconv.Atoi(FSAPI_DRAIN_TIMEOUT)
+	if err != nil || drainSec < 0 {
+		fatalConfig("Invalid FSAPI_DRAIN_TIMEOUT: %q", FSAPI_DRAIN_TIMEOUT)
+	}
+
+	// Persisted state; everything below loads from it
+	if !containsString(storeDrivers, FSAPI_STORE) {
+		fatalConfig("Invalid FSAPI_STORE: %q (expected sqlite, postgres or file)", FSAPI_STORE)
+	}
+	store, err := openStore(FSAPI_STORE, FSAPI_STORE_DSN)
+	if err != nil {
+		fatalUnavailable("Failed to open %s store: %v", FSAPI_STORE, err)
+	}
+	dataStore = store
+	log.Printf("Persisting state in the %s store", store.Driver())
+
+	handler := NewAPIHandler(eslClient)
+
+	// ESL preflight: either block until connected (FSAPI_WAIT_FOR_ESL=true)
+	// or make one attempt in the background so a bad password is logged at
+	// startup rather than on the first API call
+	if FSAPI_WAIT_FOR_ESL == "true" {
+		waitSec, err := strconv.Atoi(FSAPI_ESL_WAIT_TIMEOUT)
+		if err != nil || waitSec <= 0 {
+			fatalConfig("Invalid FSAPI_ESL_WAIT_TIMEOUT: %q", FSAPI_ESL_WAIT_TIMEOUT)
+		}
+		log.Printf("Waiting up to %ds for ESL at %s:%s", waitSec, ESL_HOST, ESL_PORT)
+		if err := waitForESL(handler.eslClient, time.Duration(waitSec)*time.Second); err != nil {
+			if errors.Is(err, ErrESLAuthFailed) {
+				fatalConfig("ESL preflight failed: %v", err)
+			}
+			fatalUnavailable("ESL preflight failed: %v", err)
+		}
+		log.Println("ESL preflight succeeded")
+	} else {
+		go func() {
+			if err := handler.eslClient.Connect(); err != nil {
+				log.Printf("WARNING: ESL preflight failed: %v", err)
 			}
+		}()
+	}
+
+	// Modules whose presence is verified by the health check
+	handler.healthModules = splitCSV(FSAPI_HEALTH_MODULES)
+
+	// Webhook registry; resumes deliveries left pending by the last shutdown
+	handler.webhooks = newWebhookManager(handler.jobs)
+
+	// DID inventory
+	handler.dids = newDIDRegistry()
+	handler.blocklist = newBlocklist()
+	handler.hours = newBusinessHours()
+	handler.callerIDs = newCallerIDs()
+	handler.alerts = newAlertManager(handler)
+	handler.flows = newFlowManager(handler)
+	handler.flowTemplates = newFlowTemplates()
+	handler.surveys = newSurveyManager(handler)
+
+	// Deleted entities stay restorable until they are purged
+	trashRetention, err := strconv.Atoi(FSAPI_TRASH_RETENTION)
+	if err != nil || trashRetention < 0 {
+		fatalConfig("Invalid FSAPI_TRASH_RETENTION: %q", FSAPI_TRASH_RETENTION)
+	}
+	handler.trash = newTrash(time.Duration(trashRetention) * time.Second)
+	go handler.runTrashPurge()
+
+	// Conference rooms; the schedule runs for the life of the process
+	handler.rooms = newConferenceRooms()
+	handler.recordings = newConferenceRecordings()
+	go handler.runConferenceSchedule()
+
+	if FSAPI_GRAPHQL == "true" {
+		schema, err := newGraphSchema()
+		if err != nil {
+			fatalConfig("Failed to build GraphQL schema: %v", err)
+		}
+		handler.graphSchema = &schema
+		log.Println("GraphQL endpoint: ENABLED")
+	}
+
+	handler.xmlCurlSections = splitCSV(FSAPI_XML_CURL_SECTIONS)
+	for _, section := range handler.xmlCurlSections {
+		if !containsString(xmlCurlSectionNames, section) {
+			fatalConfig("Invalid FSAPI_XML_CURL_SECTIONS: unknown section %q", section)
+		}
+	}
+
+	// Event stream and CDR store
+	cdrRetention, err := strconv.Atoi(FSAPI_CDR_RETENTION)
+	if err != nil || cdrRetention <= 0 {
+		fatalConfig("Invalid FSAPI_CDR_RETENTION: %q", FSAPI_CDR_RETENTION)
+	}
+	handler.events = newEventBus()
+	eventBufferSize, err := strconv.Atoi(FSAPI_EVENT_BUFFER)
+	if err != nil || eventBufferSize <= 0 {
+		fatalConfig("Invalid FSAPI_EVENT_BUFFER: %q", FSAPI_EVENT_BUFFER)
+	}
+	routeStatsWindow, err := strconv.Atoi(FSAPI_ROUTE_STATS_WINDOW)
+	if err != nil || routeStatsWindow <= 0 {
+		fatalConfig("Invalid FSAPI_ROUTE_STATS_WINDOW: %q", FSAPI_ROUTE_STATS_WINDOW)
+	}
+	handler.cdrs = newCDRStore(cdrRetention)
+	handler.cdrVars = splitCSV(FSAPI_CDR_VARS)
+	for _, name := range handler.cdrVars {
+		if !isValidChannelVarName(name) {
+			fatalConfig("Invalid FSAPI_CDR_VARS: bad variable name %q", name)
+		}
+	}
+	handler.events.subscribe(handler.handleHangupEvent)
+	handler.events.subscribe(handler.traces.recordEvent)
+
+	// Callcenter member and agent tracking for queue SLA and utilization
+	handler.slaThresholds, err = parseContextDurations(FSAPI_SLA_THRESHOLDS)
+	if err != nil {
+		fatalConfig("Invalid FSAPI_SLA_THRESHOLDS: %v", err)
+	}
+	handler.callcenter = newCCTracker(ccSLAMaxWindow)
+	handler.events.subscribe(handler.callcenter.handleEvent)
+	handler.overflow = newQueueOverflow(handler.metrics)
+	handler.events.subscribe(handler.handleOverflowEvent)
+	go handler.runQueueOverflow()
+	handler.agentActivity = newAgentActivityLog()
+	handler.events.subscribe(handler.agentActivity.handleEvent)
+	handler.screenPopVars = splitCSV(FSAPI_SCREENPOP_VARS)
+	for _, name := range handler.screenPopVars {
+		if !isValidChannelVarName(name) {
+			fatalConfig("Invalid FSAPI_SCREENPOP_VARS: bad variable name %q", name)
+		}
+	}
+	handler.events.subscribe(handler.handleScreenPopEvent)
+	if FSAPI_PRESENCE_SYNC != "" {
+		modes, err := parseContextValues(FSAPI_PRESENCE_SYNC, checkPresenceSyncMode)
+		if err != nil {
+			fatalConfig("Invalid FSAPI_PRESENCE_SYNC: %v", err)
+		}
+		handler.presence = newPresenceSync(modes)
+		handler.events.subscribe(handler.handlePresenceEvent)
+		log.Printf("Agent presence sync: ENABLED (%s)", FSAPI_PRESENCE_SYNC)
+	}
+
+	// Defaults for fields requests leave out
+	handler.defaults.hangupCause, err = parseContextValues(FSAPI_HANGUP_CAUSE, checkHangupCause)
+	if err != nil {
+		fatalConfig("Invalid FSAPI_HANGUP_CAUSE: %v", err)
+	}
+	handler.defaults.dtmfDuration, err = parseContextValues(FSAPI_DTMF_DURATION, checkDTMFDuration)
+	if err != nil {
+		fatalConfig("Invalid FSAPI_DTMF_DURATION: %v", err)
+	}
+	handler.defaults.parkApp, err = parseContextValues(FSAPI_PARK_APP, checkParkApp)
+	if err != nil {
+		fatalConfig("Invalid FSAPI_PARK_APP: %v", err)
+	}
+
+	if FSAPI_STIR_ATTESTATION_HEADER != "" && !sipHeaderNamePattern.MatchString(FSAPI_STIR_ATTESTATION_HEADER) {
+		fatalConfig("Invalid FSAPI_STIR_ATTESTATION_HEADER: %q", FSAPI_STIR_ATTESTATION_HEADER)
+	}
+	if handler.emergencyNumbers, err = parseEmergencyNumbers(FSAPI_EMERGENCY_NUMBERS); err != nil {
+		fatalConfig("Invalid FSAPI_EMERGENCY_NUMBERS: %v", err)
+	}
+
+	if err := checkVoicemailProfile(FSAPI_VOICEMAIL_PROFILE); err != nil {
+		fatalConfig("Invalid FSAPI_VOICEMAIL_PROFILE: %v", err)
+	}
+	if err := checkVoicemailExtension(FSAPI_VOICEMAIL_EXTENSION); err != nil {
+		fatalConfig("Invalid FSAPI_VOICEMAIL_EXTENSION: %v", err)
+	}
+	if err := checkConferenceProfile(FSAPI_CONFERENCE_PROFILE); err != nil {
+		fatalConfig("Invalid FSAPI_CONFERENCE_PROFILE: %v", err)
+	}
+	if err := checkConferenceJoinURL(FSAPI_CONFERENCE_JOIN_URL); err != nil {
+		fatalConfig("Invalid FSAPI_CONFERENCE_JOIN_URL: %v", err)
+	}
+	if err := validateFilePath(FSAPI_RECORDING_DIR); err != nil {
+		fatalConfig("Invalid FSAPI_RECORDING_DIR: %v", err)
+	}
+
+	// FreeSWITCH shutdown and endpoint module reload announcements
+	switchPauseSec, err := strconv.Atoi(FSAPI_SWITCH_PAUSE)
+	if err != nil || switchPauseSec < 0 {
+		fatalConfig("Invalid FSAPI_SWITCH_PAUSE: %q", FSAPI_SWITCH_PAUSE)
+	}
+	handler.switchMon = newSwitchMonitor(handler.webhooks, handler.metrics, time.Duration(switchPauseSec)*time.Second)
+	handler.events.subscribe(handler.switchMon.handleEvent)
+
+	// Park recalls are cancelled when the call is retrieved
+	handler.recalls = newParkRecalls()
+	handler.events.subscribe(handler.handleParkRecallEvent)
+
+	// Surveys start when the agent leg of a call hangs up
+	handler.events.subscribe(handler.surveys.handleEvent)
+
+	// Speech rendered for the TTS cache is moved into place when its render
+	// channel hangs up
+	if FSAPI_TTS_CACHE_DIR != "" {
+		if FSAPI_EVENTS != "true" {
+			fatalConfig("FSAPI_TTS_CACHE_DIR requires FSAPI_EVENTS=true to see renders finish")
+		}
+		if info, err := os.Stat(FSAPI_TTS_CACHE_DIR); err != nil || !info.IsDir() {
+			fatalConfig("Invalid FSAPI_TTS_CACHE_DIR: %q is not a directory", FSAPI_TTS_CACHE_DIR)
 		}
+		maxBytes, err := strconv.ParseInt(FSAPI_TTS_CACHE_MAX_BYTES, 10, 64)
+		if err != nil || maxBytes <= 0 {
+			fatalConfig("Invalid FSAPI_TTS_CACHE_MAX_BYTES: %q", FSAPI_TTS_CACHE_MAX_BYTES)
+		}
+		handler.ttsCache = newTTSCache(handler, FSAPI_TTS_CACHE_DIR, maxBytes)
+		handler.events.subscribe(handler.ttsCache.handleEvent)
+	}
+
+	// Conference recordings that mod_conference stops on its own, and the
+	// member roster
+	handler.events.subscribe(handler.handleConferenceEvent)
+	handler.roster = newConferenceRoster()
+	handler.events.subscribe(handler.roster.handleEvent)
+
+	// Queue, user and alias provisioning write include files, and the media
+	// API prompts, that FreeSWITCH must be able to read
+	for name, dir := range map[string]string{"FSAPI_CC_QUEUE_DIR": FSAPI_CC_QUEUE_DIR, "FSAPI_DIRECTORY_DIR": FSAPI_DIRECTORY_DIR, "FSAPI_SOFIA_ALIAS_DIR": FSAPI_SOFIA_ALIAS_DIR, "FSAPI_MEDIA_DIR": FSAPI_MEDIA_DIR} {
+		if dir == "" {
+			continue
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			fatalConfig("Invalid %s: %q is not a directory", name, dir)
+		}
+	}
+	if FSAPI_DID_DIALPLAN_FILE != "" {
+		if info, err := os.Stat(filepath.Dir(FSAPI_DID_DIALPLAN_FILE)); err != nil || !info.IsDir() {
+			fatalConfig("Invalid FSAPI_DID_DIALPLAN_FILE: directory of %q does not exist", FSAPI_DID_DIALPLAN_FILE)
+		}
+	}
+	if source, ok := eslClient.(LogSource); ok {
+		handler.logSource = source
+	}
+	if admin, ok := eslClient.(ESLAdmin); ok {
+		handler.eslAdminClient = admin
+	}
+	callAlerts, err := parseContextValues(FSAPI_CALL_ALERTS, checkAlertThreshold)
+	if err != nil {
+		fatalConfig("Invalid FSAPI_CALL_ALERTS: %v", err)
+	}
+	spsAlerts, err := parseContextValues(FSAPI_SPS_ALERTS, checkAlertThreshold)
+	if err != nil {
+		fatalConfig("Invalid FSAPI_SPS_ALERTS: %v", err)
+	}
+	gatewayCaps, err := parseContextValues(FSAPI_GATEWAY_CAPS, checkGatewayCap)
+	if err != nil {
+		fatalConfig("Invalid FSAPI_GATEWAY_CAPS: %v", err)
+	}
+	if len(gatewayCaps) > 0 && FSAPI_EVENTS != "true" {
+		fatalConfig("FSAPI_GATEWAY_CAPS requires FSAPI_EVENTS=true to count gateway calls")
+	}
+	handler.gatewayGroups, err = parseGatewayGroups(FSAPI_GATEWAY_GROUPS)
+	if err != nil {
+		fatalConfig("Invalid FSAPI_GATEWAY_GROUPS: %v", err)
+	}
+	handler.failoverCauses, err = parseFailoverCauses(FSAPI_GATEWAY_FAILOVER_CAUSES)
+	if err != nil {
+		fatalConfig("Invalid FSAPI_GATEWAY_FAILOVER_CAUSES: %v", err)
+	}
+	handler.ttsVoices, err = parseTTSVoices(FSAPI_TTS_VOICES)
+	if err != nil {
+		fatalConfig("Invalid FSAPI_TTS_VOICES: %v", err)
+	}
+	handler.numbers, err = newNumberNormalizer(FSAPI_NUMBER_COUNTRY)
+	if err != nil {
+		fatalConfig("Invalid FSAPI_NUMBER_COUNTRY: %v", err)
+	}
+	if source, ok := eslClient.(EventSource); ok && FSAPI_EVENTS == "true" {
+		handler.eventHistory = newEventHistory(eventBufferSize, handler.events.current())
+		handler.events.subscribe(handler.eventHistory.record)
+		// Realtime gauges and gateway usage are only kept while events flow
+		handler.realtime = newRealtimeStats(handler, callAlerts, spsAlerts)
+		handler.events.subscribe(handler.realtime.handleEvent)
+		go handler.realtime.run()
+		handler.gateways = newGatewayUsage(handler, gatewayCaps)
+		handler.events.subscribe(handler.gateways.handleEvent)
+		handler.routeStats = newRouteStats(handler.metrics, time.Duration(routeStatsWindow)*time.Second)
+		handler.events.subscribe(handler.routeStats.handleEvent)
+		go handler.gateways.run()
+		go handler.alerts.run()
+		source.StreamEvents(handler.jobs.stopping(), handler.events.publish)
+		log.Println("FreeSWITCH event stream: ENABLED")
+	}
+
+	// Long-call watchdog
+	if FSAPI_WATCHDOG_MAX_DURATION != "" {
+		limits, err := parseContextDurations(FSAPI_WATCHDOG_MAX_DURATION)
+		if err != nil {
+			fatalConfig("Invalid FSAPI_WATCHDOG_MAX_DURATION: %v", err)
+		}
+		if FSAPI_WATCHDOG_ACTION != watchdogActionFlag && FSAPI_WATCHDOG_ACTION != watchdogActionHangup {
+			fatalConfig("Invalid FSAPI_WATCHDOG_ACTION: %q (expected flag or hangup)", FSAPI_WATCHDOG_ACTION)
+		}
+		warnSec, err := strconv.Atoi(FSAPI_WATCHDOG_WARN_BEFORE)
+		if err != nil || warnSec < 0 {
+			fatalConfig("Invalid FSAPI_WATCHDOG_WARN_BEFORE: %q", FSAPI_WATCHDOG_WARN_BEFORE)
+		}
+		intervalSec, err := strconv.Atoi(FSAPI_WATCHDOG_INTERVAL)
+		if err != nil || intervalSec <= 0 {
+			fatalConfig("Invalid FSAPI_WATCHDOG_INTERVAL: %q", FSAPI_WATCHDOG_INTERVAL)
+		}
+		watchdog := newCallWatchdog(handler, limits, FSAPI_WATCHDOG_ACTION,
+			time.Duration(warnSec)*time.Second, time.Duration(intervalSec)*time.Second)
+		go watchdog.run()
+		log.Printf("Call watchdog: ENABLED (%d limit(s), action %s, every %ds)", len(limits), FSAPI_WATCHDOG_ACTION, intervalSec)
+	}
+
+	handler.bodyLimits, err = parseBodyLimits(FSAPI_BODY_LIMITS)
+	if err != nil {
+		fatalConfig("Invalid FSAPI_BODY_LIMITS: %v", err)
+	}
+
+	// Parse authentication tokens
+	authTokens := splitCSV(FSAPI_AUTH_TOKENS)
+
+	// Request signing keys, accepted alongside bearer tokens
+	var signer *requestSigner
+	if FSAPI_SIGNING_KEYS != "" {
+		keys, err := parseSigningKeys(FSAPI_SIGNING_KEYS)
+		if err != nil {
+			fatalConfig("Invalid FSAPI_SIGNING_KEYS: %v", err)
+		}
+		windowSec, err := strconv.Atoi(FSAPI_SIGNATURE_WINDOW)
+		if err != nil || windowSec <= 0 {
+			fatalConfig("Invalid FSAPI_SIGNATURE_WINDOW: %q", FSAPI_SIGNATURE_WINDOW)
+		}
+		signer = &requestSigner{keys: keys, window: time.Duration(windowSec) * time.Second}
+		log.Printf("Request signing: ENABLED (%d key(s), %ds window)", len(keys), windowSec)
+	}
+	if FSAPI_REPLAY_PROTECTION == "true" {
+		if signer == nil {
+			fatalConfig("FSAPI_REPLAY_PROTECTION requires FSAPI_SIGNING_KEYS")
+		}
+		replaySec, err := strconv.Atoi(FSAPI_REPLAY_WINDOW)
+		if err != nil || replaySec <= 0 {
+			fatalConfig("Invalid FSAPI_REPLAY_WINDOW: %q", FSAPI_REPLAY_WINDOW)
+		}
+		// A timestamp outside the signing window fails anyway
+		signer.replay = newReplayGuard(min(time.Duration(replaySec)*time.Second, signer.window), handler.metrics)
+		log.Printf("Replay protection: ENABLED (%ds window)", int(signer.replay.window.Seconds()))
+	}
+
+	// Session tokens are minted from bearer tokens or signing keys
+	if len(authTokens) > 0 || signer != nil {
+		maxTTLSec, err := strconv.Atoi(FSAPI_SESSION_MAX_TTL)
+		if err != nil || maxTTLSec <= 0 {
+			fatalConfig("Invalid FSAPI_SESSION_MAX_TTL: %q", FSAPI_SESSION_MAX_TTL)
+		}
+		handler.sessions = newSessionStore(time.Duration(maxTTLSec) * time.Second)
+	}
+
+	// Failed authentication tracking
+	var guard *authGuard
+	maxFailures, err := strconv.Atoi(FSAPI_AUTH_MAX_FAILURES)
+	if err != nil || maxFailures < 0 {
+		fatalConfig("Invalid FSAPI_AUTH_MAX_FAILURES: %q", FSAPI_AUTH_MAX_FAILURES)
+	}
+	if maxFailures > 0 {
+		windowSec, err := strconv.Atoi(FSAPI_AUTH_FAILURE_WINDOW)
+		if err != nil || windowSec <= 0 {
+			fatalConfig("Invalid FSAPI_AUTH_FAILURE_WINDOW: %q", FSAPI_AUTH_FAILURE_WINDOW)
+		}
+		lockoutSec, err := strconv.Atoi(FSAPI_AUTH_LOCKOUT)
+		if err != nil || lockoutSec <= 0 {
+			fatalConfig("Invalid FSAPI_AUTH_LOCKOUT: %q", FSAPI_AUTH_LOCKOUT)
+		}
+		tarpitSec, err := strconv.Atoi(FSAPI_AUTH_TARPIT)
+		if err != nil || tarpitSec < 0 {
+			fatalConfig("Invalid FSAPI_AUTH_TARPIT: %q", FSAPI_AUTH_TARPIT)
+		}
+		guard = newAuthGuard(maxFailures, time.Duration(windowSec)*time.Second, time.Duration(lockoutSec)*time.Second,
+			time.Duration(tarpitSec)*time.Second, handler.metrics, handler.audit)
+	}
+
+	// External authorization policy
+	if FSAPI_POLICY_URL != "" {
+		if u, err := url.Parse(FSAPI_POLICY_URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fatalConfig("Invalid FSAPI_POLICY_URL: %q", FSAPI_POLICY_URL)
+		}
+		timeoutSec, err := strconv.Atoi(FSAPI_POLICY_TIMEOUT)
+		if err != nil || timeoutSec <= 0 {
+			fatalConfig("Invalid FSAPI_POLICY_TIMEOUT: %q", FSAPI_POLICY_TIMEOUT)
+		}
+		cacheSec, err := strconv.Atoi(FSAPI_POLICY_CACHE_TTL)
+		if err != nil || cacheSec < 0 {
+			fatalConfig("Invalid FSAPI_POLICY_CACHE_TTL: %q", FSAPI_POLICY_CACHE_TTL)
+		}
+		if FSAPI_POLICY_FAIL_OPEN != "true" && FSAPI_POLICY_FAIL_OPEN != "false" {
+			fatalConfig("Invalid FSAPI_POLICY_FAIL_OPEN: %q (expected true or false)", FSAPI_POLICY_FAIL_OPEN)
+		}
+		failOpen := FSAPI_POLICY_FAIL_OPEN == "true"
+		handler.policy = newPolicyClient(FSAPI_POLICY_URL, time.Duration(timeoutSec)*time.Second,
+			time.Duration(cacheSec)*time.Second, failOpen, handler.metrics)
+		log.Printf("Authorization policy: ENABLED (%s, %ds cache, fail open: %t)", FSAPI_POLICY_URL, cacheSec, failOpen)
 	}
 
 	r := mux.NewRouter()
 
-	// Apply middlewares (auth must be first)
+	// Apply middlewares. The size limit comes before auth so signature
+	// verification reads a bounded body. Custom middleware registered with
+	// registerMiddleware runs at the before_auth and after_auth stages.
 	r.Use(requestIDMiddleware)
-	r.Use(bearerAuthMiddleware(authTokens))
+	r.Use(handler.requestSizeLimitMiddleware)
+	useMiddleware(r, handler, StageBeforeAuth)
+	r.Use(bearerAuthMiddleware(authConfig{tokens: authTokens, signer: signer, guard: guard, sessions: handler.sessions}))
 	r.Use(contextAuthMiddleware)
-	r.Use(requestSizeLimitMiddleware)
+	r.Use(handler.commandSourceMiddleware)
+	r.Use(handler.policyMiddleware)
+	r.Use(handler.callLockMiddleware)
+	useMiddleware(r, handler, StageAfterAuth)
 
 	v1 := r.PathPrefix("/v1").Subrouter()
 
 	// Register all endpoints
+	v1.HandleFunc("/auth/sessions", handler.ListSessions).Methods("GET")
+	v1.HandleFunc("/auth/sessions", handler.CreateSession).Methods("POST")
+	v1.HandleFunc("/auth/sessions/{id}", handler.RevokeSession).Methods("DELETE")
+	v1.HandleFunc("/calls/{uuid}/debug", handler.GetCallDebug).Methods("GET")
+	v1.HandleFunc("/calls/{uuid}/commands", handler.ListCallCommands).Methods("GET")
+	v1.HandleFunc("/calls/{uuid}/capture", handler.CaptureCall).Methods("POST")
 	v1.HandleFunc("/calls/{uuid}/hangup", handler.HangupCall).Methods("POST")
 	v1.HandleFunc("/calls/{uuid}/transfer", handler.TransferCall).Methods("POST")
 	v1.HandleFunc("/calls/bridge", handler.BridgeCalls).Methods("POST")
+	v1.HandleFunc("/calls/{uuid}/unbridge", handler.UnbridgeCall).Methods("POST")
 	v1.HandleFunc("/calls/{uuid}/answer", handler.AnswerCall).Methods("POST")
 	v1.HandleFunc("/calls/{uuid}/hold", handler.ControlHold).Methods("POST")
 	v1.HandleFunc("/calls/{uuid}/record", handler.ControlRecording).Methods("POST")
 	v1.HandleFunc("/calls/{uuid}/dtmf", handler.SendDTMF).Methods("POST")
 	v1.HandleFunc("/calls/{uuid}/park", handler.ParkCall).Methods("POST")
+	v1.HandleFunc("/calls/{uuid}/state", handler.GetCallState).Methods("GET")
+	v1.HandleFunc("/calls/{uuid}/state", handler.EnsureCallState).Methods("PUT")
+	v1.HandleFunc("/park-slots", handler.ListParkSlots).Methods("GET")
+	v1.HandleFunc("/park-slots/{slot}/retrieve", handler.RetrieveParkSlot).Methods("POST")
+	v1.HandleFunc("/calls/{uuid}/heartbeat", handler.SessionHeartbeat).Methods("POST")
+	v1.HandleFunc("/calls/{uuid}/survey", handler.CreateSurvey).Methods("POST")
+	v1.HandleFunc("/surveys", handler.ListSurveys).Methods("GET")
 	v1.HandleFunc("/calls/originate", handler.OriginateCall).Methods("POST")
 	v1.HandleFunc("/calls", handler.ListCalls).Methods("GET")
 	v1.HandleFunc("/calls/{uuid}", handler.GetCallDetails).Methods("GET")
 	v1.HandleFunc("/status", handler.GetStatus).Methods("GET")
+	v1.HandleFunc("/search", handler.Search).Methods("GET")
+	v1.HandleFunc("/lcr", handler.GetLCRRoutes).Methods("GET")
+	v1.HandleFunc("/tools/cidlookup", handler.LookupCallerID).Methods("GET")
+	v1.HandleFunc("/tools/normalize", handler.NormalizeNumber).Methods("GET")
+	v1.HandleFunc("/meta", handler.GetMeta).Methods("GET")
+	v1.HandleFunc("/cdrs", handler.ListCDRs).Methods("GET")
+	v1.HandleFunc("/graphql", handler.GraphQL).Methods("GET", "POST")
+	v1.HandleFunc("/events", handler.ListEvents).Methods("GET")
+	v1.HandleFunc("/events/sse", handler.StreamEventsSSE).Methods("GET")
+	v1.HandleFunc("/stats/domains/{domain}", handler.GetDomainStats).Methods("GET")
+	v1.HandleFunc("/stats/realtime", handler.GetRealtimeStats).Methods("GET")
+	v1.HandleFunc("/stats/routes", handler.GetRouteStats).Methods("GET")
+
+	// Alert endpoints - /rules must be registered before /rules/{id}
+	v1.HandleFunc("/alerts", handler.ListAlerts).Methods("GET")
+	v1.HandleFunc("/alerts/rules", handler.ListAlertRules).Methods("GET")
+	v1.HandleFunc("/alerts/rules", handler.CreateAlertRule).Methods("POST")
+	v1.HandleFunc("/alerts/rules/{id}", handler.DeleteAlertRule).Methods("DELETE")
+
+	// Call flows run server-side - register /templates before /{id}
+	v1.HandleFunc("/flows", handler.ListFlows).Methods("GET")
+	v1.HandleFunc("/flows", handler.CreateFlow).Methods("POST")
+	v1.HandleFunc("/flows/templates", handler.ListFlowTemplates).Methods("GET")
+	v1.HandleFunc("/flows/templates", handler.CreateFlowTemplate).Methods("POST")
+	v1.HandleFunc("/flows/templates/{name}", handler.GetFlowTemplate).Methods("GET")
+	v1.HandleFunc("/flows/templates/{name}", handler.UpdateFlowTemplate).Methods("PUT")
+	v1.HandleFunc("/flows/templates/{name}", handler.DeleteFlowTemplate).Methods("DELETE")
+	v1.HandleFunc("/flows/{id}", handler.GetFlow).Methods("GET")
+	v1.HandleFunc("/flows/{id}/cancel", handler.CancelFlow).Methods("POST")
+	v1.HandleFunc("/flows/{name}/run", handler.RunFlowTemplate).Methods("POST")
+
+	// Verification calls speak a code and run as flows
+	v1.HandleFunc("/verify/call", handler.CreateVerifyCall).Methods("POST")
+	v1.HandleFunc("/verify/call/{id}", handler.GetVerifyCall).Methods("GET")
 
 	// Registration endpoints - /count must be registered before /{user} if we add that later
 	v1.HandleFunc("/registrations", handler.ListRegistrations).Methods("GET")
 	v1.HandleFunc("/registrations/count", handler.CountRegistrations).Methods("GET")
 
+	// Webhook endpoints
+	v1.HandleFunc("/webhooks", handler.ListWebhooks).Methods("GET")
+	v1.HandleFunc("/webhooks", handler.CreateWebhook).Methods("POST")
+	v1.HandleFunc("/webhooks/{id}", handler.GetWebhook).Methods("GET")
+	v1.HandleFunc("/webhooks/{id}", handler.DeleteWebhook).Methods("DELETE")
+	v1.HandleFunc("/webhooks/{id}/deliveries", handler.ListWebhookDeliveries).Methods("GET")
+	v1.HandleFunc("/webhooks/{id}/deliveries/{delivery}/retry", handler.RetryWebhookDelivery).Methods("POST")
+
+	// mod_xml_curl gateway
+	v1.HandleFunc("/xml_curl", handler.XMLCurl).Methods("POST")
+
+	// Tenant call policies
+	v1.HandleFunc("/policies/hours", handler.ListBusinessHours).Methods("GET")
+	v1.HandleFunc("/policies/hours/evaluate", handler.EvaluateBusinessHours).Methods("GET")
+	v1.HandleFunc("/policies/hours/{context}", handler.GetBusinessHours).Methods("GET")
+	v1.HandleFunc("/policies/hours/{context}", handler.SetBusinessHours).Methods("PUT")
+	v1.HandleFunc("/policies/hours/{context}", handler.DeleteBusinessHours).Methods("DELETE")
+	v1.HandleFunc("/blocklist", handler.ListBlocklist).Methods("GET")
+	v1.HandleFunc("/blocklist", handler.CreateBlocklistEntry).Methods("POST")
+	v1.HandleFunc("/blocklist/{id}", handler.DeleteBlocklistEntry).Methods("DELETE")
+	v1.HandleFunc("/callerids", handler.ListCallerIDs).Methods("GET")
+	v1.HandleFunc("/callerids", handler.CreateCallerID).Methods("POST")
+	v1.HandleFunc("/callerids/{id}", handler.GetCallerID).Methods("GET")
+	v1.HandleFunc("/callerids/{id}", handler.UpdateCallerID).Methods("PUT")
+	v1.HandleFunc("/callerids/{id}", handler.DeleteCallerID).Methods("DELETE")
+
+	// DID registry - register /dids/dialplan before /dids/{number}
+	v1.HandleFunc("/dids", handler.ListDIDs).Methods("GET")
+	v1.HandleFunc("/dids", handler.CreateDID).Methods("POST")
+	v1.HandleFunc("/dids/dialplan", handler.GetDIDDialplan).Methods("GET")
+	v1.HandleFunc("/dids/{number}", handler.GetDID).Methods("GET")
+	v1.HandleFunc("/dids/{number}", handler.UpdateDID).Methods("PUT")
+	v1.HandleFunc("/dids/{number}", handler.DeleteDID).Methods("DELETE")
+
+	// Conference rooms
+	v1.HandleFunc("/conference-rooms", handler.ListConferenceRooms).Methods("GET")
+	v1.HandleFunc("/conference-rooms", handler.CreateConferenceRoom).Methods("POST")
+	v1.HandleFunc("/conference-rooms/{room}", handler.GetConferenceRoom).Methods("GET")
+	v1.HandleFunc("/conference-rooms/{room}", handler.UpdateConferenceRoom).Methods("PUT")
+	v1.HandleFunc("/conference-rooms/{room}", handler.DeleteConferenceRoom).Methods("DELETE")
+	v1.HandleFunc("/conference-rooms/{room}/join", handler.JoinConferenceRoom).Methods("POST")
+	v1.HandleFunc("/conference-rooms/{room}/recording", handler.ControlConferenceRecording).Methods("POST")
+	v1.HandleFunc("/conference-rooms/{room}/recordings", handler.ListConferenceRecordings).Methods("GET")
+	v1.HandleFunc("/conference-rooms/{room}/layout", handler.SetConferenceLayout).Methods("POST")
+	v1.HandleFunc("/conference-rooms/{room}/floor", handler.SetConferenceFloor).Methods("POST")
+	v1.HandleFunc("/conferences/{name}/members", handler.ListConferenceMembers).Methods("GET")
+
+	// Directory user provisioning
+	v1.HandleFunc("/users", handler.ListUsers).Methods("GET")
+	v1.HandleFunc("/users", handler.CreateUser).Methods("POST")
+	v1.HandleFunc("/users/{user}", handler.GetUser).Methods("GET")
+	v1.HandleFunc("/users/{user}", handler.UpdateUser).Methods("PUT")
+	v1.HandleFunc("/users/{user}", handler.DeleteUser).Methods("DELETE")
+
+	// Prompt and announcement files
+	v1.HandleFunc("/media", handler.ListMedia).Methods("GET")
+	v1.HandleFunc("/media/{context}/{name}", handler.GetMedia).Methods("GET")
+	v1.HandleFunc("/media/{context}/{name}", handler.UploadMedia).Methods("PUT")
+	v1.HandleFunc("/media/{context}/{name}", handler.DeleteMedia).Methods("DELETE")
+
+	// TTS cache (unrestricted access only)
+	v1.HandleFunc("/tts/cache", handler.GetTTSCacheStats).Methods("GET")
+	v1.HandleFunc("/tts/cache", handler.PurgeTTSCache).Methods("DELETE")
+
+	// Sofia profile administration (unrestricted access only) - register
+	// /aliases before /{action} to avoid mux conflicts
+	v1.HandleFunc("/sofia/profiles", handler.ListSofiaProfiles).Methods("GET")
+	v1.HandleFunc("/gateways/{name}/usage", handler.GetGatewayUsage).Methods("GET")
+	v1.HandleFunc("/sofia/profiles/{profile}", handler.GetSofiaProfile).Methods("GET")
+	v1.HandleFunc("/sofia/profiles/{profile}/aliases", handler.ListSofiaAliases).Methods("GET")
+	v1.HandleFunc("/sofia/profiles/{profile}/aliases", handler.AddSofiaAlias).Methods("POST")
+	v1.HandleFunc("/sofia/profiles/{profile}/aliases/{domain}", handler.DeleteSofiaAlias).Methods("DELETE")
+	v1.HandleFunc("/sofia/profiles/{profile}/{action}", handler.SofiaProfileAction).Methods("POST")
+
+	// Verto (WebRTC) clients
+	v1.HandleFunc("/verto/clients", handler.ListVertoClients).Methods("GET")
+	v1.HandleFunc("/verto/clients/{login}", handler.DisconnectVertoClient).Methods("DELETE")
+
+	// Console log streaming (unrestricted access only)
+	v1.HandleFunc("/admin/logs/stream", handler.StreamLogs).Methods("GET")
+
+	// ESL connection status and forced reconnect (unrestricted access only)
+	v1.HandleFunc("/admin/esl", handler.GetESLStatus).Methods("GET")
+	v1.HandleFunc("/admin/esl/reconnect", handler.ReconnectESL).Methods("POST")
+
+	// Configuration export and import for backup and migration (unrestricted access only)
+	v1.HandleFunc("/admin/export", handler.ExportConfig).Methods("GET")
+	v1.HandleFunc("/admin/import", handler.ImportConfig).Methods("POST")
+
+	// Audit log
+	v1.HandleFunc("/audit", handler.ListAudit).Methods("GET")
+
+	// Trash of deleted webhooks, DIDs and flow templates
+	v1.HandleFunc("/trash", handler.ListTrash).Methods("GET")
+	v1.HandleFunc("/trash/{id}", handler.PurgeTrashEntry).Methods("DELETE")
+	v1.HandleFunc("/trash/{id}/restore", handler.RestoreTrashEntry).Methods("POST")
+
 	// Callcenter endpoints
 	cc := v1.PathPrefix("/callcenter").Subrouter()
+	cc.HandleFunc("/stats", handler.CCStats).Methods("GET")
 
 	// Queue endpoints - register /queues/count before /{queue_name} to avoid mux conflicts
 	cc.HandleFunc("/queues", handler.CCListQueues).Methods("GET")
+	cc.HandleFunc("/queues", handler.CCCreateQueue).Methods("POST")
 	cc.HandleFunc("/queues/count", handler.CCCountQueues).Methods("GET")
+	cc.HandleFunc("/queues/{queue_name}", handler.CCGetQueue).Methods("GET")
+	cc.HandleFunc("/queues/{queue_name}", handler.CCUpdateQueue).Methods("PUT")
+	cc.HandleFunc("/queues/{queue_name}", handler.CCDeleteQueue).Methods("DELETE")
+	cc.HandleFunc("/queues/{queue_name}/config", handler.CCGetQueueConfig).Methods("GET")
 	cc.HandleFunc("/queues/{queue_name}/agents", handler.CCListQueueAgents).Methods("GET")
 	cc.HandleFunc("/queues/{queue_name}/agents/count", handler.CCCountQueueAgents).Methods("GET")
 	cc.HandleFunc("/queues/{queue_name}/members", handler.CCListQueueMembers).Methods("GET")
 	cc.HandleFunc("/queues/{queue_name}/members/count", handler.CCCountQueueMembers).Methods("GET")
+	cc.HandleFunc("/queues/{queue_name}/members/{uuid}/position", handler.CCMemberPosition).Methods("GET")
 	cc.HandleFunc("/queues/{queue_name}/tiers", handler.CCListQueueTiers).Methods("GET")
 	cc.HandleFunc("/queues/{queue_name}/tiers/count", handler.CCCountQueueTiers).Methods("GET")
 	cc.HandleFunc("/queues/{queue_name}/load", handler.CCLoadQueue).Methods("POST")
 	cc.HandleFunc("/queues/{queue_name}/unload", handler.CCUnloadQueue).Methods("POST")
 	cc.HandleFunc("/queues/{queue_name}/reload", handler.CCReloadQueue).Methods("POST")
+	cc.HandleFunc("/queues/{queue_name}/sla", handler.CCQueueSLA).Methods("GET")
+	cc.HandleFunc("/queues/{queue_name}/announce", handler.CCAnnounceQueue).Methods("POST")
+	cc.HandleFunc("/queues/{queue_name}/announce", handler.CCStopQueueAnnounce).Methods("DELETE")
+	cc.HandleFunc("/queues/{queue_name}/abandoned", handler.CCListAbandoned).Methods("GET")
+	cc.HandleFunc("/queues/{queue_name}/abandoned/{id}/callback", handler.CCCallbackAbandoned).Methods("POST")
+	cc.HandleFunc("/queues/{queue_name}/overflow", handler.CCGetOverflowRule).Methods("GET")
+	cc.HandleFunc("/queues/{queue_name}/overflow", handler.CCSetOverflowRule).Methods("PUT")
+	cc.HandleFunc("/queues/{queue_name}/overflow", handler.CCDeleteOverflowRule).Methods("DELETE")
+	cc.HandleFunc("/overflow", handler.CCListOverflowRules).Methods("GET")
 
 	// Agent endpoints
 	cc.HandleFunc("/agents", handler.CCListAgents).Methods("GET")
 	cc.HandleFunc("/agents", handler.CCAddAgent).Methods("POST")
+	cc.HandleFunc("/agents/status", handler.CCBulkAgentStatus).Methods("PUT") // Before /agents/{agent_name}
 	cc.HandleFunc("/agents/{agent_name}", handler.CCDeleteAgent).Methods("DELETE")
 	cc.HandleFunc("/agents/{agent_name}", handler.CCSetAgent).Methods("PUT")
+	cc.HandleFunc("/agents/{agent_name}/utilization", handler.CCAgentUtilization).Methods("GET")
+	cc.HandleFunc("/agents/{agent_name}/wrapup/extend", handler.CCExtendWrapUp).Methods("POST")
+	cc.HandleFunc("/agents/{agent_name}/wrapup/end", handler.CCEndWrapUp).Methods("POST")
+	cc.HandleFunc("/agents/{agent_name}/force", handler.CCForceAgent).Methods("POST")
 
 	// Tier endpoints
 	cc.HandleFunc("/tiers", handler.CCListTiers).Methods("GET")
@@ -95,9 +855,18 @@ func main() {
 	cc.HandleFunc("/tiers", handler.CCDeleteTier).Methods("DELETE")
 	cc.HandleFunc("/tiers", handler.CCSetTier).Methods("PUT")
 
+	// Deployment-specific endpoints under /v1/ext/<name>
+	mountExtensions(v1, handler)
+
 	// Health check endpoint
 	r.HandleFunc("/health", handler.HealthCheck).Methods("GET")
 
+	// Prometheus metrics
+	r.HandleFunc("/metrics", handler.Metrics).Methods("GET")
+
+	// Session scopes cover exactly the areas routed above
+	sessionAreas = routeAreas(r)
+
 	// Bind to all interfaces (0.0.0.0) instead of just localhost
 	addr := fmt.Sprintf(":%s", FSAPI_PORT)
 	log.Printf("FreeSWITCH Call Control API v%s starting on %s (all interfaces)", Version, addr)
@@ -115,21 +884,34 @@ func main() {
 	srv := &http.Server{
 		Addr:         addr,
 		Handler:      r,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		ReadTimeout:  serverReadTimeout,
+		WriteTimeout: serverWriteTimeout,
+		IdleTimeout:  serverIdleTimeout,
 	}
 
-	log.Printf("Server configured with ReadTimeout: 15s, WriteTimeout: 15s, IdleTimeout: 60s")
+	srv.RegisterOnShutdown(func() { close(handler.streamsClosing) })
+
+	log.Printf("Server configured with ReadTimeout: %s, WriteTimeout: %s, IdleTimeout: %s", serverReadTimeout, serverWriteTimeout, serverIdleTimeout)
 
-	// Start server in a goroutine
+	// Bind before reporting readiness, then serve in a goroutine
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		fatalUnavailable("Server error: %v", err)
+	}
+	if *pidFile != "" {
+		if err := writePIDFile(*pidFile); err != nil {
+			fatalConfig("Invalid -pid-file: %v", err)
+		}
+		defer os.Remove(*pidFile)
+	}
 	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server error: %v", err)
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			fatalUnavailable("Server error: %v", err)
 		}
 	}()
 
 	log.Println("Server started successfully")
+	sdNotify("READY=1\nSTATUS=Serving on " + addr)
 
 	// Wait for interrupt signal to gracefully shutdown the server
 	quit := make(chan os.Signal, 1)
@@ -137,6 +919,7 @@ func main() {
 	<-quit
 
 	log.Println("Shutting down server...")
+	sdNotify("STOPPING=1\nSTATUS=Draining")
 
 	// Create shutdown context with 30 second timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -149,10 +932,19 @@ func main() {
 		log.Println("Server shutdown gracefully")
 	}
 
+	// Wait for in-flight ESL commands and background jobs before closing ESL
+	log.Printf("Draining in-flight operations (timeout %ds)...", drainSec)
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), time.Duration(drainSec)*time.Second)
+	handler.jobs.drain(drainCtx)
+	drainCancel()
+
 	// Close ESL connection
 	if err := handler.eslClient.Close(); err != nil {
 		log.Printf("Error closing ESL client: %v", err)
 	}
+	if err := dataStore.Close(); err != nil {
+		log.Printf("Error closing %s store: %v", dataStore.Driver(), err)
+	}
 
 	log.Println("Server exited")
 }
@@ -0,0 +1,566 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Prompts and announcements are stored one file per name under
+// FSAPI_MEDIA_DIR/<context>/, so tenants can upload the files that
+// playback, announcements, whispers and flows reference by path.
+
+// mediaDefaultBodyLimit is the upload limit when FSAPI_BODY_LIMITS leaves
+// the media class out
+const mediaDefaultBodyLimit = 20 << 20
+
+const (
+	mediaMinSampleRate = 8000
+	mediaMaxSampleRate = 48000
+	mediaMaxNameLength = 128
+)
+
+// mediaNamePattern matches stored file names; the extension names the format
+var mediaNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*(\.[A-Za-z0-9_-]+)*\.(wav|mp3)$`)
+
+// MediaFile describes a stored media file
+type MediaFile struct {
+	Context     string    `json:"context"`
+	Name        string    `json:"name"`
+	Path        string    `json:"path"`               // Absolute path to give playback, announcements and flows
+	Format      string    `json:"format"`             // wav or mp3
+	Encoding    string    `json:"encoding,omitempty"` // WAV only: pcm8, pcm16, pcm24, pcm32, float32, float64, alaw or ulaw
+	SampleRate  int       `json:"sample_rate,omitempty"`
+	Channels    int       `json:"channels,omitempty"`
+	DurationSec float64   `json:"duration_sec,omitempty"`
+	Size        int64     `json:"size"`
+	ModifiedAt  time.Time `json:"modified_at"`
+}
+
+// WAV format tags
+const (
+	wavFormatPCM        = 1
+	wavFormatFloat      = 3
+	wavFormatALaw       = 6
+	wavFormatMuLaw      = 7
+	wavFormatExtensible = 0xFFFE
+)
+
+// wavInfo is the format and sample data location of a WAV file
+type wavInfo struct {
+	format     uint16 // With WAVE_FORMAT_EXTENSIBLE resolved to its subformat
+	channels   int
+	sampleRate int
+	bits       int
+	dataOffset int64
+	dataSize   int64
+}
+
+// encoding names the sample encoding, or "" when FreeSWITCH cannot be
+// relied on to play it
+func (wi *wavInfo) encoding() string {
+	switch {
+	case wi.format == wavFormatPCM && (wi.bits == 8 || wi.bits == 16 || wi.bits == 24 || wi.bits == 32):
+		return fmt.Sprintf("pcm%d", wi.bits)
+	case wi.format == wavFormatFloat && (wi.bits == 32 || wi.bits == 64):
+		return fmt.Sprintf("float%d", wi.bits)
+	case wi.format == wavFormatALaw && wi.bits == 8:
+		return "alaw"
+	case wi.format == wavFormatMuLaw && wi.bits == 8:
+		return "ulaw"
+	}
+	return ""
+}
+
+func (wi *wavInfo) frameSize() int {
+	return wi.channels * wi.bits / 8
+}
+
+// readWAVInfo walks the RIFF chunks of a WAV file up to its sample data
+func readWAVInfo(r io.ReadSeeker) (*wavInfo, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil || string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("not a RIFF/WAVE file")
+	}
+
+	var info *wavInfo
+	offset := int64(12)
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return nil, fmt.Errorf("no data chunk")
+		}
+		offset += 8
+		id := string(chunk[0:4])
+		chunkSize := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+		switch id {
+		case "fmt ":
+			if chunkSize < 16 || chunkSize > 1024 {
+				return nil, fmt.Errorf("invalid fmt chunk")
+			}
+			fmtData := make([]byte, chunkSize)
+			if _, err := io.ReadFull(r, fmtData); err != nil {
+				return nil, fmt.Errorf("truncated fmt chunk")
+			}
+			info = &wavInfo{
+				format:     binary.LittleEndian.Uint16(fmtData[0:2]),
+				channels:   int(binary.LittleEndian.Uint16(fmtData[2:4])),
+				sampleRate: int(binary.LittleEndian.Uint32(fmtData[4:8])),
+				bits:       int(binary.LittleEndian.Uint16(fmtData[14:16])),
+			}
+			// The subformat GUID starts with the format tag
+			if info.format == wavFormatExtensible && chunkSize >= 26 {
+				info.format = binary.LittleEndian.Uint16(fmtData[24:26])
+			}
+		case "data":
+			if info == nil {
+				return nil, fmt.Errorf("data chunk before fmt chunk")
+			}
+			// Streaming writers leave the size at its maximum
+			if chunkSize > size-offset {
+				chunkSize = size - offset
+			}
+			info.dataOffset = offset
+			info.dataSize = chunkSize
+			return info, nil
+		}
+		// Chunks are padded to an even size
+		next := offset + chunkSize + chunkSize%2
+		if _, err := r.Seek(next, io.SeekStart); err != nil {
+			return nil, err
+		}
+		offset = next
+	}
+}
+
+// checkWAV checks that FreeSWITCH can play a WAV file as is
+func checkWAV(info *wavInfo) error {
+	if info.encoding() == "" {
+		return fmt.Errorf("unsupported WAV encoding (format %d, %d bits); use PCM, IEEE float, A-law or mu-law", info.format, info.bits)
+	}
+	if info.channels < 1 || info.channels > 2 {
+		return fmt.Errorf("WAV files must be mono or stereo")
+	}
+	if info.sampleRate < mediaMinSampleRate || info.sampleRate > mediaMaxSampleRate {
+		return fmt.Errorf("sample rate must be between %d and %d Hz", mediaMinSampleRate, mediaMaxSampleRate)
+	}
+	return nil
+}
+
+// isMP3 reports whether data starts with an ID3 tag or an MPEG audio
+// layer III frame
+func isMP3(data []byte) bool {
+	if bytes.HasPrefix(data, []byte("ID3")) {
+		return true
+	}
+	return len(data) >= 4 && data[0] == 0xFF && data[1]&0xE0 == 0xE0 && data[1]&0x06 == 0x02
+}
+
+// decodeWAV returns the samples of a WAV file mixed down to mono, in [-1, 1]
+func decodeWAV(data []byte, info *wavInfo) []float64 {
+	frame := info.frameSize()
+	width := info.bits / 8
+	pcm := data[info.dataOffset : info.dataOffset+info.dataSize]
+	samples := make([]float64, len(pcm)/frame)
+	for i := range samples {
+		var sum float64
+		for c := 0; c < info.channels; c++ {
+			b := pcm[i*frame+c*width:]
+			switch info.encoding() {
+			case "pcm8":
+				sum += (float64(b[0]) - 128) / 128
+			case "pcm16":
+				sum += float64(int16(binary.LittleEndian.Uint16(b))) / (1 << 15)
+			case "pcm24":
+				sum += float64(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24)>>8) / (1 << 23)
+			case "pcm32":
+				sum += float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31)
+			case "float32":
+				sum += float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+			case "float64":
+				sum += math.Float64frombits(binary.LittleEndian.Uint64(b))
+			case "alaw":
+				sum += float64(alawDecode(b[0])) / (1 << 15)
+			case "ulaw":
+				sum += float64(ulawDecode(b[0])) / (1 << 15)
+			}
+		}
+		samples[i] = sum / float64(info.channels)
+	}
+	return samples
+}
+
+// alawDecode expands a G.711 A-law sample to 16-bit linear
+func alawDecode(v byte) int16 {
+	v ^= 0x55
+	t := int16(v&0x0F) << 4
+	seg := (v & 0x70) >> 4
+	switch seg {
+	case 0:
+		t += 8
+	case 1:
+		t += 0x108
+	default:
+		t = (t + 0x108) << (seg - 1)
+	}
+	if v&0x80 != 0 {
+		return t
+	}
+	return -t
+}
+
+// ulawDecode expands a G.711 mu-law sample to 16-bit linear
+func ulawDecode(v byte) int16 {
+	v = ^v
+	t := (int16(v&0x0F) << 3) + 0x84
+	t <<= (v & 0x70) >> 4
+	if v&0x80 != 0 {
+		return 0x84 - t
+	}
+	return t - 0x84
+}
+
+// resample converts samples between rates with a Blackman-windowed sinc
+// filter, low-passed below the lower of the two Nyquist frequencies
+func resample(in []float64, from, to int) []float64 {
+	if from == to {
+		return in
+	}
+	const halfTaps = 16
+	ratio := float64(to) / float64(from)
+	cutoff := math.Min(1, ratio)
+	width := halfTaps / cutoff
+	out := make([]float64, int(float64(len(in))*ratio))
+	for i := range out {
+		t := float64(i) / ratio
+		lo := max(int(math.Ceil(t-width)), 0)
+		hi := min(int(math.Floor(t+width)), len(in)-1)
+		var sum float64
+		for j := lo; j <= hi; j++ {
+			x := t - float64(j)
+			u := x / width
+			window := 0.42 + 0.5*math.Cos(math.Pi*u) + 0.08*math.Cos(2*math.Pi*u)
+			sum += in[j] * cutoff * sinc(cutoff*x) * window
+		}
+		out[i] = sum
+	}
+	return out
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// encodeWAV writes mono samples as a 16-bit PCM WAV file
+func encodeWAV(samples []float64, rate int) []byte {
+	dataSize := len(samples) * 2
+	buf := bytes.NewBuffer(make([]byte, 0, 44+dataSize))
+	le := func(v interface{}) { binary.Write(buf, binary.LittleEndian, v) }
+	buf.WriteString("RIFF")
+	le(uint32(36 + dataSize))
+	buf.WriteString("WAVEfmt ")
+	le(uint32(16))
+	le(uint16(wavFormatPCM))
+	le(uint16(1))
+	le(uint32(rate))
+	le(uint32(rate * 2))
+	le(uint16(2))
+	le(uint16(16))
+	buf.WriteString("data")
+	le(uint32(dataSize))
+	for _, s := range samples {
+		le(int16(math.Round(math.Max(-1, math.Min(1, s)) * math.MaxInt16)))
+	}
+	return buf.Bytes()
+}
+
+// prepareMedia checks an upload against the format its name gives and,
+// when rate is set, transcodes it to mono 16-bit PCM at that rate
+func prepareMedia(name string, data []byte, rate int) ([]byte, error) {
+	if strings.HasSuffix(name, ".mp3") {
+		if rate != 0 {
+			return nil, fmt.Errorf("rate is only supported for WAV uploads")
+		}
+		if !isMP3(data) {
+			return nil, fmt.Errorf("file is not an MP3")
+		}
+		return data, nil
+	}
+
+	info, err := readWAVInfo(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid WAV file: %v", err)
+	}
+	if err := checkWAV(info); err != nil {
+		return nil, err
+	}
+	if rate == 0 {
+		return data, nil
+	}
+	samples := resample(decodeWAV(data, info), info.sampleRate, rate)
+	return encodeWAV(samples, rate), nil
+}
+
+// mediaFileInfo describes a stored file from its header
+func mediaFileInfo(context, name string) (*MediaFile, error) {
+	path := mediaPath(context, name)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	m := &MediaFile{
+		Context:    context,
+		Name:       name,
+		Path:       path,
+		Format:     strings.TrimPrefix(filepath.Ext(name), "."),
+		Size:       stat.Size(),
+		ModifiedAt: stat.ModTime().UTC(),
+	}
+	if m.Format == "wav" {
+		info, err := readWAVInfo(f)
+		if err != nil {
+			return nil, err
+		}
+		m.Encoding = info.encoding()
+		m.SampleRate = info.sampleRate
+		m.Channels = info.channels
+		if frame := info.frameSize(); frame > 0 && info.sampleRate > 0 {
+			seconds := float64(info.dataSize/int64(frame)) / float64(info.sampleRate)
+			m.DurationSec = math.Round(seconds*1000) / 1000
+		}
+	}
+	return m, nil
+}
+
+func mediaPath(context, name string) string {
+	return filepath.Join(FSAPI_MEDIA_DIR, context, name)
+}
+
+// mediaEnabled writes a 501 when media storage is disabled
+func (h *APIHandler) mediaEnabled(w http.ResponseWriter, r *http.Request) bool {
+	if FSAPI_MEDIA_DIR == "" {
+		h.respondError(w, r, "Media storage is disabled (FSAPI_MEDIA_DIR is not set)", http.StatusNotImplemented)
+		return false
+	}
+	return true
+}
+
+// mediaTarget reads {context} and {name} from the URL and checks access to
+// the context. On failure the error response has been written.
+func (h *APIHandler) mediaTarget(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	vars := mux.Vars(r)
+	context, name := vars["context"], vars["name"]
+	if !domainPattern.MatchString(context) {
+		h.respondError(w, r, "context must be a valid context name", http.StatusBadRequest)
+		return "", "", false
+	}
+	if len(name) > mediaMaxNameLength || !mediaNamePattern.MatchString(name) {
+		h.respondError(w, r, fmt.Sprintf("name may only contain letters, digits and . _ - (up to %d characters) and must end in .wav or .mp3", mediaMaxNameLength), http.StatusBadRequest)
+		return "", "", false
+	}
+	if !h.validateRequestContext(w, r, context) {
+		return "", "", false
+	}
+	return context, name, true
+}
+
+// --- Media handlers ---
+
+// GET /v1/media?context=
+func (h *APIHandler) ListMedia(w http.ResponseWriter, r *http.Request) {
+	if !h.mediaEnabled(w, r) {
+		return
+	}
+	context := r.URL.Query().Get("context")
+	if context == "" || !domainPattern.MatchString(context) {
+		h.respondError(w, r, "context query parameter is required", http.StatusBadRequest)
+		return
+	}
+	if !h.validateRequestContext(w, r, context) {
+		return
+	}
+
+	entries, err := os.ReadDir(filepath.Join(FSAPI_MEDIA_DIR, context))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		h.respondError(w, r, fmt.Sprintf("Failed to list media: %v", err), http.StatusInternalServerError)
+		return
+	}
+	rows := []MediaFile{}
+	for _, entry := range entries {
+		if entry.IsDir() || !mediaNamePattern.MatchString(entry.Name()) {
+			continue
+		}
+		m, err := mediaFileInfo(context, entry.Name())
+		if err != nil {
+			logWarn(getRequestID(r), fmt.Sprintf("Skipping unreadable media file %s: %v", mediaPath(context, entry.Name()), err))
+			continue
+		}
+		rows = append(rows, *m)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Name < rows[j].Name })
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status":    "success",
+		"row_count": len(rows),
+		"rows":      rows,
+	})
+}
+
+// PUT /v1/media/{context}/{name}?rate=
+//
+// The body is the file itself. An existing file of the same name is
+// replaced.
+func (h *APIHandler) UploadMedia(w http.ResponseWriter, r *http.Request) {
+	if !h.mediaEnabled(w, r) {
+		return
+	}
+	context, name, ok := h.mediaTarget(w, r)
+	if !ok {
+		return
+	}
+	rate := 0
+	if v := r.URL.Query().Get("rate"); v != "" {
+		rate, _ = strconv.Atoi(v)
+		if rate != 8000 && rate != 16000 {
+			h.respondError(w, r, "rate must be 8000 or 16000", http.StatusBadRequest)
+			return
+		}
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			h.respondBodyTooLarge(w, r, tooLarge.Limit)
+			return
+		}
+		h.respondError(w, r, fmt.Sprintf("Failed to read upload: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(data) == 0 {
+		h.respondError(w, r, "request body must hold the file", http.StatusBadRequest)
+		return
+	}
+	data, err = prepareMedia(name, data, rate)
+	if err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// FreeSWITCH reads the file, so it is written world-readable, and
+	// renamed into place so playback never sees a partial file
+	dir := filepath.Join(FSAPI_MEDIA_DIR, context)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		h.respondError(w, r, fmt.Sprintf("Failed to store media: %v", err), http.StatusInternalServerError)
+		return
+	}
+	tmp, err := os.CreateTemp(dir, "."+name+".*.tmp")
+	if err != nil {
+		h.respondError(w, r, fmt.Sprintf("Failed to store media: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), mediaPath(context, name))
+	}
+	if err != nil {
+		h.respondError(w, r, fmt.Sprintf("Failed to store media: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	m, err := mediaFileInfo(context, name)
+	if err != nil {
+		h.respondError(w, r, fmt.Sprintf("Failed to read media: %v", err), http.StatusInternalServerError)
+		return
+	}
+	logInfo(getRequestID(r), fmt.Sprintf("Media file %s/%s stored (%d bytes)", context, name, m.Size))
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   m,
+	})
+}
+
+// GET /v1/media/{context}/{name}
+func (h *APIHandler) GetMedia(w http.ResponseWriter, r *http.Request) {
+	if !h.mediaEnabled(w, r) {
+		return
+	}
+	context, name, ok := h.mediaTarget(w, r)
+	if !ok {
+		return
+	}
+
+	m, err := mediaFileInfo(context, name)
+	if errors.Is(err, os.ErrNotExist) {
+		h.respondError(w, r, fmt.Sprintf("Media file %s/%s not found", context, name), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.respondError(w, r, fmt.Sprintf("Failed to read media: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   m,
+	})
+}
+
+// DELETE /v1/media/{context}/{name}
+func (h *APIHandler) DeleteMedia(w http.ResponseWriter, r *http.Request) {
+	if !h.mediaEnabled(w, r) {
+		return
+	}
+	context, name, ok := h.mediaTarget(w, r)
+	if !ok {
+		return
+	}
+
+	err := os.Remove(mediaPath(context, name))
+	if errors.Is(err, os.ErrNotExist) {
+		h.respondError(w, r, fmt.Sprintf("Media file %s/%s not found", context, name), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.respondError(w, r, fmt.Sprintf("Failed to delete media: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	logInfo(getRequestID(r), fmt.Sprintf("Media file %s/%s deleted", context, name))
+	h.respondSuccess(w, r, fmt.Sprintf("Media file %s/%s deleted", context, name))
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+)
+
+// Set at build time with
+// -ldflags "-X main.GitCommit=$(git rev-parse HEAD) -X main.BuildDate=$(date -u +%FT%TZ)".
+// When unset, the VCS stamp the Go toolchain embeds is used.
+var (
+	GitCommit = ""
+	BuildDate = ""
+)
+
+// buildInfo returns the commit and build date, falling back to the
+// vcs.revision and vcs.time embedded by go build
+func buildInfo() (commit, date string) {
+	commit, date = GitCommit, BuildDate
+	if info, ok := debug.ReadBuildInfo(); ok {
+		modified := false
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if commit == "" {
+					commit = s.Value
+				}
+			case "vcs.time":
+				if date == "" {
+					date = s.Value
+				}
+			case "vcs.modified":
+				modified = s.Value == "true"
+			}
+		}
+		if modified && GitCommit == "" && commit != "" {
+			commit += "-dirty"
+		}
+	}
+	if commit == "" {
+		commit = "unknown"
+	}
+	if date == "" {
+		date = "unknown"
+	}
+	return commit, date
+}
+
+// envInt returns a validated integer setting; main has already rejected bad
+// values at startup
+func envInt(value string) int {
+	n, _ := strconv.Atoi(value)
+	return n
+}
+
+// GET /v1/meta
+func (h *APIHandler) GetMeta(w http.ResponseWriter, r *http.Request) {
+	commit, date := buildInfo()
+
+	extNames := []string{}
+	for _, ext := range extensions {
+		extNames = append(extNames, ext.Name)
+	}
+	xmlCurl := h.xmlCurlSections
+	if xmlCurl == nil {
+		xmlCurl = []string{}
+	}
+
+	h.respondJSON(w, r, MetaResponse{
+		Status: "success",
+		Build: MetaBuild{
+			Version:   Version,
+			GitCommit: commit,
+			BuildDate: date,
+			GoVersion: runtime.Version(),
+		},
+		Mode: FSAPI_MODE,
+		Features: MetaFeatures{
+			Events:              h.eventHistory != nil,
+			CDRStore:            h.eventHistory != nil,
+			Webhooks:            h.webhooks != nil,
+			GraphQL:             h.graphSchema != nil,
+			XMLCurl:             xmlCurl,
+			QueueProvisioning:   FSAPI_CC_QUEUE_DIR != "",
+			UserProvisioning:    FSAPI_DIRECTORY_DIR != "",
+			SofiaAliases:        FSAPI_SOFIA_ALIAS_DIR != "",
+			Media:               FSAPI_MEDIA_DIR != "",
+			TTSCache:            h.ttsCache != nil,
+			DIDDialplan:         FSAPI_DID_DIALPLAN_FILE != "",
+			LogStream:           h.logSource != nil,
+			Watchdog:            FSAPI_WATCHDOG_MAX_DURATION != "",
+			BearerTokens:        FSAPI_AUTH_TOKENS != "",
+			RequestSigning:      FSAPI_SIGNING_KEYS != "",
+			SessionTokens:       h.sessions != nil,
+			AuthorizationPolicy: h.policy != nil,
+			MultiNode:           false,
+			Store:               dataStore.Driver(),
+			Extensions:          extNames,
+		},
+		Limits: MetaLimits{
+			BodyBytes:          h.bodyLimits,
+			CDRRetention:       envInt(FSAPI_CDR_RETENTION),
+			EventBuffer:        envInt(FSAPI_EVENT_BUFFER),
+			SessionMaxTTLSec:   envInt(FSAPI_SESSION_MAX_TTL),
+			SignatureWindowSec: envInt(FSAPI_SIGNATURE_WINDOW),
+			AuthMaxFailures:    envInt(FSAPI_AUTH_MAX_FAILURES),
+			DrainTimeoutSec:    envInt(FSAPI_DRAIN_TIMEOUT),
+			ReadTimeoutSec:     int(serverReadTimeout.Seconds()),
+			WriteTimeoutSec:    int(serverWriteTimeout.Seconds()),
+		},
+	})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// metricsRegistry holds fs-api's own counters and gauges and renders them
+// in the Prometheus text format
+type metricsRegistry struct {
+	mu       sync.Mutex
+	counters []*counterVec
+}
+
+// counterVec is a counter with one series per combination of label values.
+// A gauge is a counterVec whose series are set rather than incremented.
+type counterVec struct {
+	name   string
+	help   string
+	kind   string // counter or gauge
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64 // Label values joined by "\xff"
+}
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{}
+}
+
+// counter registers a counter
+func (m *metricsRegistry) counter(name, help string, labels ...string) *counterVec {
+	return m.register(&counterVec{name: name, help: help, kind: "counter", labels: labels})
+}
+
+// gauge registers a gauge
+func (m *metricsRegistry) gauge(name, help string, labels ...string) *counterVec {
+	return m.register(&counterVec{name: name, help: help, kind: "gauge", labels: labels})
+}
+
+func (m *metricsRegistry) register(c *counterVec) *counterVec {
+	c.values = make(map[string]float64)
+	m.mu.Lock()
+	m.counters = append(m.counters, c)
+	m.mu.Unlock()
+	return c
+}
+
+// inc adds one to the series with the given label values
+func (c *counterVec) inc(labelValues ...string) {
+	c.mu.Lock()
+	c.values[strings.Join(labelValues, "\xff")]++
+	c.mu.Unlock()
+}
+
+// add adds value to the series with the given label values
+func (c *counterVec) add(value float64, labelValues ...string) {
+	c.mu.Lock()
+	c.values[strings.Join(labelValues, "\xff")] += value
+	c.mu.Unlock()
+}
+
+// set sets the series with the given label values of a gauge
+func (c *counterVec) set(value float64, labelValues ...string) {
+	c.mu.Lock()
+	c.values[strings.Join(labelValues, "\xff")] = value
+	c.mu.Unlock()
+}
+
+// write renders the counter in the Prometheus text format
+func (c *counterVec) write(b *strings.Builder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", c.name, c.help, c.name, c.kind)
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b.WriteString(c.name)
+		if len(c.labels) > 0 {
+			values := strings.Split(k, "\xff")
+			pairs := make([]string, len(c.labels))
+			for i, label := range c.labels {
+				pairs[i] = fmt.Sprintf("%s=%q", label, values[i])
+			}
+			b.WriteString("{" + strings.Join(pairs, ",") + "}")
+		}
+		fmt.Fprintf(b, " %g\n", c.values[k])
+	}
+}
+
+// GET /metrics
+func (h *APIHandler) Metrics(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	h.metrics.mu.Lock()
+	counters := append([]*counterVec{}, h.metrics.counters...)
+	h.metrics.mu.Unlock()
+	for _, c := range counters {
+		c.write(&b)
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Header().Set("X-Request-ID", getRequestID(r))
+	w.Write([]byte(b.String()))
+}
@@ -2,10 +2,15 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -21,10 +26,79 @@ func requestIDMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// requestSizeLimitMiddleware limits the size of request bodies
-func requestSizeLimitMiddleware(next http.Handler) http.Handler {
+// defaultBodyLimit applies to route classes FSAPI_BODY_LIMITS leaves out
+const defaultBodyLimit = 1 << 20
+
+// Route classes for FSAPI_BODY_LIMITS, by path prefix. Other paths use "*".
+var bodyLimitClasses = []struct{ class, prefix string }{
+	{"calls", "/v1/calls"},
+	{"callcenter", "/v1/callcenter"},
+	{"provisioning", "/v1/users"},
+	{"provisioning", "/v1/dids"},
+	{"provisioning", "/v1/sofia"},
+	{"provisioning", "/v1/webhooks"},
+	{"xml_curl", "/v1/xml_curl"},
+	{"graphql", "/v1/graphql"},
+	{"media", "/v1/media"},
+}
+
+// parseBodyLimits parses "class=bytes,*=bytes" into a lookup map
+func parseBodyLimits(value string) (map[string]int64, error) {
+	limits := map[string]int64{"*": defaultBodyLimit, "media": mediaDefaultBodyLimit}
+	for _, entry := range splitCSV(value) {
+		class, sizeStr, ok := strings.Cut(entry, "=")
+		class = strings.TrimSpace(class)
+		if !ok || class == "" {
+			return nil, fmt.Errorf("invalid entry %q (expected class=bytes)", entry)
+		}
+		known := class == "*"
+		for _, c := range bodyLimitClasses {
+			known = known || c.class == class
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown route class %q", class)
+		}
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeStr), 10, 64)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid size for %q: %q", class, sizeStr)
+		}
+		limits[class] = size
+	}
+	return limits, nil
+}
+
+// bodyLimit returns the request body limit for a path
+func (h *APIHandler) bodyLimit(path string) int64 {
+	for _, c := range bodyLimitClasses {
+		if path == c.prefix || strings.HasPrefix(path, c.prefix+"/") {
+			if limit, ok := h.bodyLimits[c.class]; ok {
+				return limit
+			}
+			break
+		}
+	}
+	if limit, ok := h.bodyLimits["*"]; ok {
+		return limit
+	}
+	return defaultBodyLimit
+}
+
+// requestSizeLimitMiddleware limits the size of request bodies per route
+// class. GET and HEAD bodies are never read, so those requests (including
+// long-lived streams) are left alone.
+func (h *APIHandler) requestSizeLimitMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		r.Body = http.MaxBytesReader(w, r.Body, 1048576) // 1MB limit
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		limit := h.bodyLimit(r.URL.Path)
+		// A declared length over the limit is refused before any of it is read
+		if r.ContentLength > limit {
+			h.respondBodyTooLarge(w, r, limit)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
 		next.ServeHTTP(w, r)
 	})
 }
@@ -40,33 +114,182 @@ func isLocalhost(r *http.Request) bool {
 	return host == "127.0.0.1" || host == "::1" || host == "localhost"
 }
 
-// bearerAuthMiddleware validates bearer token authentication
-func bearerAuthMiddleware(allowedTokens []string) func(http.Handler) http.Handler {
+// writeAuthError writes a 401 with a JSON error body
+func writeAuthError(w http.ResponseWriter, message string) {
+	body, _ := json.Marshal(ErrorResponse{Status: "error", Message: message})
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	http.Error(w, string(body), http.StatusUnauthorized)
+}
+
+// authConfig holds the credentials bearerAuthMiddleware accepts
+type authConfig struct {
+	tokens   []string
+	signer   *requestSigner // Nil unless FSAPI_SIGNING_KEYS is set
+	guard    *authGuard     // Nil when brute-force protection is off
+	sessions *sessionStore  // Nil when there are no credentials to mint sessions from
+}
+
+// credentialConfigured reports whether the token or signing key a session
+// was minted with is still configured, so removing it also revokes its
+// sessions
+func (a authConfig) credentialConfigured(tokenID string) bool {
+	if keyID, ok := strings.CutPrefix(tokenID, "key_"); ok {
+		if a.signer == nil {
+			return false
+		}
+		_, found := a.signer.keys[keyID]
+		return found
+	}
+	for _, token := range a.tokens {
+		if tokenFingerprint(token) == tokenID {
+			return true
+		}
+	}
+	return false
+}
+
+// bearerAuthMiddleware validates bearer token authentication, a session
+// token, or an HMAC request signature when a signer is set and the request
+// carries one. A configured guard counts failures and refuses locked-out
+// sources.
+func bearerAuthMiddleware(auth authConfig) func(http.Handler) http.Handler {
+	allowedTokens, signer, guard := auth.tokens, auth.signer, auth.guard
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// If no tokens configured, allow all requests (backward compatibility)
-			if len(allowedTokens) == 0 {
+			if len(allowedTokens) == 0 && signer == nil {
 				next.ServeHTTP(w, r)
 				return
 			}
 
-			// Extract Authorization header
+			// reject records a failed attempt, holds the response back while
+			// the source is being tarpitted, then writes the 401
+			reject := func(credential, reason, message string) {
+				if guard != nil {
+					if delay := guard.fail(r, credential, reason); delay > 0 {
+						select {
+						case <-time.After(delay):
+						case <-r.Context().Done():
+						}
+					}
+				}
+				writeAuthError(w, message)
+			}
+			// lockedOut refuses a request from a locked-out source IP, even
+			// with valid credentials. A locked-out token is only checked once
+			// it has failed again, so a token that validates is never refused.
+			lockedOut := func(credential string) bool {
+				if guard == nil {
+					return false
+				}
+				wait, locked := guard.locked(r, credential)
+				if !locked {
+					return false
+				}
+				retryAfter := int(wait.Seconds()) + 1
+				body, _ := json.Marshal(ErrorResponse{
+					Status:     "error",
+					Message:    "Too many failed authentication attempts; try again later",
+					Code:       ErrCodeAuthLocked,
+					RetryAfter: retryAfter,
+				})
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				http.Error(w, string(body), http.StatusTooManyRequests)
+				return true
+			}
+
+			if signer != nil && r.Header.Get(signatureHeader) != "" {
+				// Key IDs are not secret, so signed requests are only
+				// tracked by source IP
+				if lockedOut("") {
+					return
+				}
+				keyID, err := signer.verify(r)
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					body, _ := json.Marshal(ErrorResponse{
+						Status:  "error",
+						Message: fmt.Sprintf("Request body exceeds the %d byte limit", tooLarge.Limit),
+						Code:    ErrCodeBodyTooLarge,
+						Limit:   tooLarge.Limit,
+					})
+					http.Error(w, string(body), http.StatusRequestEntityTooLarge)
+					return
+				}
+				// A refused nonce comes with a valid signature, so it is
+				// not counted as a failed authentication
+				var replayed *replayError
+				if errors.As(err, &replayed) {
+					logWarn(getRequestID(r), fmt.Sprintf("Rejected signed request: %v", err))
+					body, _ := json.Marshal(ErrorResponse{Status: "error", Message: err.Error(), Code: ErrCodeReplayedRequest})
+					http.Error(w, string(body), http.StatusUnauthorized)
+					return
+				}
+				if err != nil {
+					logWarn(getRequestID(r), fmt.Sprintf("Rejected signed request: %v", err))
+					reject("", "invalid_signature", err.Error())
+					return
+				}
+				ctx := context.WithValue(r.Context(), tokenIDKey, "key_"+keyID)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
+			// Extract Authorization header. Browsers cannot set headers on
+			// EventSource, so GET requests may pass a session token as
+			// ?access_token= instead.
 			authHeader := r.Header.Get("Authorization")
+			if v := r.URL.Query().Get("access_token"); authHeader == "" && r.Method == http.MethodGet && strings.HasPrefix(v, sessionTokenPrefix) {
+				authHeader = "Bearer " + v
+			}
 			if authHeader == "" {
-				w.Header().Set("WWW-Authenticate", "Bearer")
-				http.Error(w, `{"status":"error","message":"Missing Authorization header"}`, http.StatusUnauthorized)
+				if !lockedOut("") {
+					reject("", "missing_credentials", "Missing Authorization header")
+				}
 				return
 			}
 
-			// Check for Bearer prefix
+			// Check for Bearer prefix. mod_xml_curl can only send Basic
+			// credentials, so the xml_curl gateway also accepts a token as the
+			// Basic password.
 			parts := strings.SplitN(authHeader, " ", 2)
+			if len(parts) == 2 && parts[0] == "Basic" && r.URL.Path == xmlCurlPath {
+				if decoded, err := base64.StdEncoding.DecodeString(parts[1]); err == nil {
+					if i := strings.Index(string(decoded), ":"); i >= 0 {
+						parts = []string{"Bearer", string(decoded[i+1:])}
+					}
+				}
+			}
 			if len(parts) != 2 || parts[0] != "Bearer" {
-				w.Header().Set("WWW-Authenticate", "Bearer")
-				http.Error(w, `{"status":"error","message":"Invalid Authorization header format. Expected: Bearer <token>"}`, http.StatusUnauthorized)
+				if !lockedOut("") {
+					reject("", "malformed_header", "Invalid Authorization header format. Expected: Bearer <token>")
+				}
 				return
 			}
 
 			token := parts[1]
+			if lockedOut("") {
+				return
+			}
+
+			if auth.sessions != nil && strings.HasPrefix(token, sessionTokenPrefix) {
+				session, ok := auth.sessions.lookup(token)
+				if !ok || !auth.credentialConfigured(session.Parent) {
+					if !lockedOut(token) {
+						reject(token, "invalid_session", "Invalid or expired session token")
+					}
+					return
+				}
+				if scope := requestScope(r); !session.allows(scope) {
+					body, _ := json.Marshal(ErrorResponse{Status: "error", Message: fmt.Sprintf("Session token does not have the %s scope", scope)})
+					http.Error(w, string(body), http.StatusForbidden)
+					return
+				}
+				ctx := context.WithValue(r.Context(), tokenIDKey, "ses_"+session.ID)
+				ctx = context.WithValue(ctx, sessionKey, session)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
 
 			// Validate token against allowed tokens
 			validToken := false
@@ -78,13 +301,15 @@ func bearerAuthMiddleware(allowedTokens []string) func(http.Handler) http.Handle
 			}
 
 			if !validToken {
-				w.Header().Set("WWW-Authenticate", "Bearer")
-				http.Error(w, `{"status":"error","message":"Invalid authentication token"}`, http.StatusUnauthorized)
+				if !lockedOut(token) {
+					reject(token, "invalid_token", "Invalid authentication token")
+				}
 				return
 			}
 
-			// Token is valid, proceed
-			next.ServeHTTP(w, r)
+			// Token is valid, proceed; the fingerprint identifies the caller in the audit log
+			ctx := context.WithValue(r.Context(), tokenIDKey, tokenFingerprint(token))
+			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Numbers shorter than this after formatting is stripped are extensions or
+// service codes and are not made E.164
+const numberMinNationalDigits = 7
+
+var (
+	// Formatting people put in phone numbers: "+1 (514) 555-0100", "020.7946.0000"
+	numberFormatting = strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "")
+
+	// <country code>[:<trunk prefix>] of FSAPI_NUMBER_COUNTRY
+	numberCountryPattern = regexp.MustCompile(`^([1-9][0-9]{0,2})(?::([0-9]{0,2}))?$`)
+
+	plainNumberPattern = regexp.MustCompile(`^\+?[0-9]+$`)
+)
+
+func checkNumberCountry(v string) error {
+	if !numberCountryPattern.MatchString(v) {
+		return fmt.Errorf("%q is not a country calling code with an optional :trunk prefix (e.g. 1, 44 or 39:)", v)
+	}
+	return nil
+}
+
+// numberCountry is a tenant's default country for national numbers
+type numberCountry struct {
+	code  string // Country calling code, e.g. "44"
+	trunk string // National prefix dropped before the country code is added, e.g. "0"
+}
+
+// numberNormalizer turns the numbers clients and FreeSWITCH use into E.164
+// with a default country per context (FSAPI_NUMBER_COUNTRY)
+type numberNormalizer struct {
+	countries map[string]numberCountry // Context -> country, "*" default
+}
+
+// newNumberNormalizer parses FSAPI_NUMBER_COUNTRY: "context=CC[:trunk],*=CC".
+// The trunk prefix defaults to "1" for country code 1 and "0" otherwise. It
+// returns nil when the setting is empty.
+func newNumberNormalizer(value string) (*numberNormalizer, error) {
+	values, err := parseContextValues(value, checkNumberCountry)
+	if err != nil || len(values) == 0 {
+		return nil, err
+	}
+	n := &numberNormalizer{countries: make(map[string]numberCountry, len(values))}
+	for ctx, v := range values {
+		m := numberCountryPattern.FindStringSubmatch(v)
+		c := numberCountry{code: m[1], trunk: m[2]}
+		if !strings.Contains(v, ":") {
+			c.trunk = "0"
+			if c.code == "1" {
+				c.trunk = "1"
+			}
+		}
+		n.countries[ctx] = c
+	}
+	return n, nil
+}
+
+// country returns the default country of context, if one is set
+func (n *numberNormalizer) country(context string) (numberCountry, bool) {
+	c, ok := n.countries[context]
+	if !ok {
+		c, ok = n.countries["*"]
+	}
+	return c, ok
+}
+
+// normalize returns number in E.164 for a tenant in context. Formatting is
+// stripped; international prefixes ("00", or "011" in country code 1) become
+// '+'; national numbers get the country code. Short numbers keep their
+// digits, and anything that is not a phone number is returned unchanged.
+func (n *numberNormalizer) normalize(number, context string) string {
+	c, ok := n.country(context)
+	if !ok {
+		return number
+	}
+	digits := numberFormatting.Replace(strings.TrimSpace(number))
+	if !plainNumberPattern.MatchString(digits) {
+		return number
+	}
+	if strings.HasPrefix(digits, "+") {
+		return digits
+	}
+	exit := "00"
+	if c.code == "1" {
+		exit = "011"
+	}
+	if rest, ok := strings.CutPrefix(digits, exit); ok && len(rest) >= numberMinNationalDigits {
+		return "+" + rest
+	}
+	if len(digits) < numberMinNationalDigits {
+		return digits
+	}
+	national := digits
+	if c.trunk != "" {
+		national = strings.TrimPrefix(digits, c.trunk)
+	}
+	// North American numbers are all ten digits; shorter ones are local dialing
+	if c.code == "1" && len(national) != 10 {
+		return digits
+	}
+	return "+" + c.code + national
+}
+
+// normalizeNumber normalizes number for context, or returns it unchanged
+// when FSAPI_NUMBER_COUNTRY is not set
+func (h *APIHandler) normalizeNumber(number, context string) string {
+	if h.numbers == nil || number == "" {
+		return number
+	}
+	return h.numbers.normalize(number, context)
+}
+
+// Number fields of "show calls" rows, for both legs
+var callNumberFields = []string{"cid_num", "dest", "callee_num", "b_cid_num", "b_dest", "b_callee_num"}
+
+// normalizeCallRow normalizes the numbers of a "show calls" row in place
+func (h *APIHandler) normalizeCallRow(call map[string]interface{}, context string) {
+	if h.numbers == nil {
+		return
+	}
+	for _, field := range callNumberFields {
+		if v, ok := call[field].(string); ok {
+			call[field] = h.numbers.normalize(v, context)
+		}
+	}
+}
+
+// normalizeCDR normalizes the numbers of a CDR in place
+func (h *APIHandler) normalizeCDR(rec *CDR) {
+	rec.CallerIDNumber = h.normalizeNumber(rec.CallerIDNumber, rec.Context)
+	rec.DestinationNumber = h.normalizeNumber(rec.DestinationNumber, rec.Context)
+	rec.CalleeIDNumber = h.normalizeNumber(rec.CalleeIDNumber, rec.Context)
+}
+
+// GET /v1/tools/normalize?number=&context=
+func (h *APIHandler) NormalizeNumber(w http.ResponseWriter, r *http.Request) {
+	if h.numbers == nil {
+		h.respondError(w, r, "Number normalization requires FSAPI_NUMBER_COUNTRY", http.StatusNotImplemented)
+		return
+	}
+	number := r.URL.Query().Get("number")
+	context := r.URL.Query().Get("context")
+	if number == "" {
+		h.respondError(w, r, "number is required", http.StatusBadRequest)
+		return
+	}
+	if context != "" && !isContextAllowed(r, context) {
+		h.respondError(w, r, fmt.Sprintf("Context '%s' is not in your allowed contexts", context), http.StatusForbidden)
+		return
+	}
+
+	normalized := h.numbers.normalize(number, context)
+	c, _ := h.numbers.country(context)
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data": map[string]interface{}{
+			"number":       number,
+			"normalized":   normalized,
+			"e164":         e164Pattern.MatchString(normalized) && strings.HasPrefix(normalized, "+"),
+			"country_code": c.code,
+		},
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestNumberNormalize(t *testing.T) {
+	n, err := newNumberNormalizer("*=1,uk.example=44,it.example=39:")
+	if err != nil {
+		t.Fatalf("newNumberNormalizer() = %v", err)
+	}
+	tests := []struct {
+		name    string
+		number  string
+		context string
+		want    string
+	}{
+		{"NANP 10 digits", "(514) 555-0100", "acme.example", "+15145550100"},
+		{"NANP 11 digits with trunk prefix", "1-514-555-0100", "acme.example", "+15145550100"},
+		{"NANP local dialing", "555-0100", "acme.example", "5550100"},
+		{"NANP 011 exit code", "011 44 20 7946 0000", "acme.example", "+442079460000"},
+		{"00 is not the NANP exit code", "0044 20 7946 0000", "acme.example", "00442079460000"},
+		{"already E.164", "+44 20 7946 0000", "acme.example", "+442079460000"},
+		{"trunk prefix stripped", "020 7946 0000", "uk.example", "+442079460000"},
+		{"00 exit code", "0044 20 7946 0000", "uk.example", "+442079460000"},
+		{"00 exit code to NANP", "001 514 555 0100", "uk.example", "+15145550100"},
+		{"national without trunk prefix", "20 7946 0000", "uk.example", "+442079460000"},
+		{"no trunk prefix keeps the leading 0", "06 6982 0000", "it.example", "+390669820000"},
+		{"emergency short code", "911", "acme.example", "911"},
+		{"short code", "112", "uk.example", "112"},
+		{"extension", "1000", "uk.example", "1000"},
+		{"service code", "*97", "uk.example", "*97"},
+		{"SIP URI", "sip:1000@example.com", "uk.example", "sip:1000@example.com"},
+		{"letters", "alice", "acme.example", "alice"},
+		{"mixed digits and letters", "555-CALL-NOW", "acme.example", "555-CALL-NOW"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := n.normalize(tt.number, tt.context); got != tt.want {
+				t.Errorf("normalize(%q, %q) = %q, want %q", tt.number, tt.context, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNumberNormalizeWithoutDefault(t *testing.T) {
+	n, err := newNumberNormalizer("uk.example=44")
+	if err != nil {
+		t.Fatalf("newNumberNormalizer() = %v", err)
+	}
+	if got := n.normalize("020 7946 0000", "other.example"); got != "020 7946 0000" {
+		t.Errorf("context without a country: normalize() = %q, want it unchanged", got)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// conferenceNamePattern matches a conference room, optionally with a profile:
+// room or room@profile
+var conferenceNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+(@[A-Za-z0-9_.-]+)?$`)
+
+// originateApps are the applications POST /v1/calls/originate can connect a
+// call to through application/application_args, with the check applied to
+// the arguments. Arguments are only ever passed through after a check, so
+// clients cannot inject channel variable expansion or extra applications.
+var originateApps = map[string]func(args string) error{
+	"park":       checkNoAppArgs,
+	"echo":       checkNoAppArgs,
+	"playback":   checkPlaybackArgs,
+	"conference": checkConferenceArgs,
+	"callcenter": checkCallcenterArgs,
+}
+
+func checkNoAppArgs(args string) error {
+	if args != "" {
+		return fmt.Errorf("takes no application_args")
+	}
+	return nil
+}
+
+func checkPlaybackArgs(args string) error {
+	if args == "" {
+		return fmt.Errorf("application_args must be the file to play")
+	}
+	if err := checkESLArg("application_args", args, eslArgSeparators); err != nil {
+		return err
+	}
+	if stream, ok := strings.CutPrefix(args, "local_stream://"); ok {
+		if !isValidChannelVarName(stream) {
+			return fmt.Errorf("invalid local_stream name %q", stream)
+		}
+		return nil
+	}
+	if err := validateFilePath(args); err != nil {
+		return fmt.Errorf("invalid file: %v", err)
+	}
+	return nil
+}
+
+func checkConferenceArgs(args string) error {
+	if !conferenceNamePattern.MatchString(args) {
+		return fmt.Errorf("application_args must be a conference name, optionally with @profile")
+	}
+	return nil
+}
+
+func checkCallcenterArgs(args string) error {
+	if !queueNamePattern.MatchString(args) {
+		return fmt.Errorf("application_args must be a queue name (name@domain)")
+	}
+	return nil
+}
+
+// originateApplication validates application/application_args and returns
+// the inline application to use as the B-leg, e.g. "&playback(/tmp/a.wav)"
+func originateApplication(req *OriginateRequest) (string, error) {
+	if req.Application == "" {
+		return "", fmt.Errorf("application_args requires application")
+	}
+	if req.BLeg != "" {
+		return "", fmt.Errorf("bleg and application are mutually exclusive")
+	}
+	check, ok := originateApps[req.Application]
+	if !ok {
+		names := make([]string, 0, len(originateApps))
+		for name := range originateApps {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("application must be one of: %s", strings.Join(names, ", "))
+	}
+	if err := check(req.ApplicationArgs); err != nil {
+		return "", fmt.Errorf("%s: %v", req.Application, err)
+	}
+	return fmt.Sprintf("&%s(%s)", req.Application, req.ApplicationArgs), nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// parkRecalls tracks parked calls with a recall scheduled in FreeSWITCH's
+// scheduler (sched_api group fsapi_recall_<uuid>), so the recall can be
+// cancelled when the call leaves the park some other way
+type parkRecalls struct {
+	mu      sync.Mutex
+	pending map[string]bool
+}
+
+func newParkRecalls() *parkRecalls {
+	return &parkRecalls{pending: make(map[string]bool)}
+}
+
+// take removes callUUID's recall, reporting whether there was one
+func (p *parkRecalls) take(callUUID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.pending[callUUID] {
+		return false
+	}
+	delete(p.pending, callUUID)
+	return true
+}
+
+func (p *parkRecalls) add(callUUID string) {
+	p.mu.Lock()
+	p.pending[callUUID] = true
+	p.mu.Unlock()
+}
+
+func parkRecallGroup(callUUID string) string {
+	return "fsapi_recall_" + callUUID
+}
+
+// scheduleParkRecall has FreeSWITCH transfer the parked call to recallTo
+// after delaySec seconds. callContext is the XML context to transfer in;
+// empty keeps the call's own.
+func (h *APIHandler) scheduleParkRecall(callUUID, recallTo, callContext string, delaySec int) error {
+	transfer := fmt.Sprintf("uuid_transfer %s %s", callUUID, recallTo)
+	if callContext != "" {
+		transfer += " XML " + callContext
+	}
+	cmd := fmt.Sprintf("api sched_api +%d %s %s", delaySec, parkRecallGroup(callUUID), transfer)
+	if _, err := h.eslClient.SendCommand(cmd); err != nil {
+		return err
+	}
+	h.recalls.add(callUUID)
+	return nil
+}
+
+// cancelParkRecall drops callUUID's pending recall, if any
+func (h *APIHandler) cancelParkRecall(callUUID string) {
+	if !h.recalls.take(callUUID) {
+		return
+	}
+	// Fails harmlessly when the recall has already run
+	h.eslClient.SendCommand("api sched_del " + parkRecallGroup(callUUID))
+}
+
+// handleParkRecallEvent cancels the recall of a call that was retrieved from
+// the park (or recalled, which unparks it too) or hung up
+func (h *APIHandler) handleParkRecallEvent(ev *Event) {
+	switch ev.Name {
+	case "CHANNEL_UNPARK", "CHANNEL_HANGUP_COMPLETE":
+		h.cancelParkRecall(ev.UUID())
+	}
+}
+
+// parkerNumber returns the number of the party callUUID is bridged to, the
+// default recall target: the dialed number of an outbound leg, the caller ID
+// of an inbound one. It is empty when the call is not bridged.
+func (h *APIHandler) parkerNumber(callUUID string) (string, error) {
+	dump := func(uuid string) (map[string]interface{}, error) {
+		response, err := h.eslClient.SendCommand(fmt.Sprintf("api uuid_dump %s json", uuid))
+		if err != nil {
+			return nil, err
+		}
+		var data map[string]interface{}
+		if err := json.Unmarshal([]byte(response), &data); err != nil {
+			return nil, fmt.Errorf("failed to parse uuid_dump: %v", err)
+		}
+		return data, nil
+	}
+
+	call, err := dump(callUUID)
+	if err != nil {
+		return "", err
+	}
+	other, _ := call["Other-Leg-Unique-ID"].(string)
+	if other == "" {
+		return "", nil
+	}
+	parker, err := dump(other)
+	if err != nil {
+		return "", err
+	}
+	field := "Caller-Caller-ID-Number"
+	if direction, _ := parker["Call-Direction"].(string); direction == "outbound" {
+		field = "Caller-Destination-Number"
+	}
+	number, _ := parker[field].(string)
+	return number, nil
+}
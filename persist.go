@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// loadJSONFile reads the document name into v. A missing document is not
+// an error and leaves v untouched.
+func loadJSONFile(name string, v interface{}) error {
+	data, err := dataStore.Load(name)
+	if err != nil || data == nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("corrupt %s: %v", name, err)
+	}
+	return nil
+}
+
+// saveJSONFile atomically stores v as the JSON document name
+func saveJSONFile(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return dataStore.Save(name, data)
+}
+
+// removeJSONFile deletes the document name if it exists
+func removeJSONFile(name string) error {
+	return dataStore.Remove(name)
+}
+
+// appendJSONLine appends v as one JSON record to the log name
+func appendJSONLine(name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return dataStore.Append(name, data)
+}
+
+// loadJSONLines calls fn for every record of the log name. A missing log is
+// not an error; malformed records are skipped.
+func loadJSONLines(name string, fn func(line []byte)) error {
+	return dataStore.Records(name, func(record []byte) {
+		if json.Valid(record) {
+			fn(record)
+		}
+	})
+}
+
+// rewriteJSONLines atomically replaces the log name with count JSON
+// records, the i-th produced by item(i)
+func rewriteJSONLines(name string, count int, item func(i int) interface{}) error {
+	records := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		data, err := json.Marshal(item(i))
+		if err != nil {
+			return err
+		}
+		records = append(records, data)
+	}
+	return dataStore.Rewrite(name, records)
+}
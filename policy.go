@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const policyCacheMaxEntries = 10000 // Cached decisions; expired ones are dropped first beyond this
+
+// PolicyInput is what the policy endpoint is asked about, wrapped as
+// {"input": ...} like an OPA data API query
+type PolicyInput struct {
+	Token     string `json:"token"`     // Credential fingerprint (tok_..., key_<id>, ses_<id>), never the secret
+	Method    string `json:"method"`    // HTTP method
+	Route     string `json:"route"`     // Route template, e.g. /v1/calls/{uuid}/hangup
+	Context   string `json:"context"`   // Target context; empty when the request names none
+	Operation string `json:"operation"` // "<area>:write", as in session scopes
+	Emergency bool   `json:"emergency"` // An originate with priority emergency, which quota policies should let through
+}
+
+// policyDecision is the policy's answer for one input
+type policyDecision struct {
+	allow   bool
+	reason  string
+	expires time.Time
+}
+
+// policyClient asks an external endpoint whether a mutating request may run,
+// caching decisions for cacheTTL
+type policyClient struct {
+	url      string
+	client   *http.Client
+	cacheTTL time.Duration // 0 disables caching
+	failOpen bool          // Allow requests when the endpoint cannot be reached
+
+	decisions *counterVec
+
+	mu    sync.Mutex
+	cache map[PolicyInput]policyDecision
+}
+
+func newPolicyClient(url string, timeout, cacheTTL time.Duration, failOpen bool, metrics *metricsRegistry) *policyClient {
+	return &policyClient{
+		url:       url,
+		client:    &http.Client{Timeout: timeout},
+		cacheTTL:  cacheTTL,
+		failOpen:  failOpen,
+		decisions: metrics.counter("fsapi_policy_decisions_total", "External policy decisions by result and source.", "result", "source"),
+		cache:     make(map[PolicyInput]policyDecision),
+	}
+}
+
+// decide returns the cached or freshly fetched decision for input
+func (p *policyClient) decide(r *http.Request, input PolicyInput) (policyDecision, error) {
+	now := time.Now()
+	p.mu.Lock()
+	d, ok := p.cache[input]
+	p.mu.Unlock()
+	if ok && now.Before(d.expires) {
+		p.decisions.inc(decisionResult(d), "cache")
+		return d, nil
+	}
+
+	d, err := p.query(r, input)
+	if err != nil {
+		p.decisions.inc("error", "remote")
+		return d, err
+	}
+	p.decisions.inc(decisionResult(d), "remote")
+	if p.cacheTTL > 0 {
+		d.expires = now.Add(p.cacheTTL)
+		p.mu.Lock()
+		p.prune(now)
+		p.cache[input] = d
+		p.mu.Unlock()
+	}
+	return d, nil
+}
+
+func decisionResult(d policyDecision) string {
+	if d.allow {
+		return "allow"
+	}
+	return "deny"
+}
+
+// query POSTs {"input": ...} to the policy endpoint. The answer may be
+// {"result": true}, {"result": {"allow": true, "reason": "..."}} or
+// {"allow": true, "reason": "..."}; anything else denies.
+func (p *policyClient) query(r *http.Request, input PolicyInput) (policyDecision, error) {
+	payload, err := json.Marshal(map[string]PolicyInput{"input": input})
+	if err != nil {
+		return policyDecision{}, err
+	}
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, p.url, bytes.NewReader(payload))
+	if err != nil {
+		return policyDecision{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Request-ID", getRequestID(r))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return policyDecision{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return policyDecision{}, fmt.Errorf("policy endpoint returned HTTP %d", resp.StatusCode)
+	}
+
+	var answer struct {
+		Result json.RawMessage `json:"result"`
+		Allow  bool            `json:"allow"`
+		Reason string          `json:"reason"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&answer); err != nil {
+		return policyDecision{}, fmt.Errorf("invalid policy response: %w", err)
+	}
+	if len(answer.Result) == 0 {
+		return policyDecision{allow: answer.Allow, reason: answer.Reason}, nil
+	}
+	var allow bool
+	if err := json.Unmarshal(answer.Result, &allow); err == nil {
+		return policyDecision{allow: allow}, nil
+	}
+	var result struct {
+		Allow  bool   `json:"allow"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(answer.Result, &result); err != nil {
+		return policyDecision{}, fmt.Errorf("invalid policy result: %s", answer.Result)
+	}
+	return policyDecision{allow: result.Allow, reason: result.Reason}, nil
+}
+
+// prune drops expired decisions once the cache is full, then arbitrary
+// ones. Caller must hold mu.
+func (p *policyClient) prune(now time.Time) {
+	if len(p.cache) < policyCacheMaxEntries {
+		return
+	}
+	for input, d := range p.cache {
+		if !now.Before(d.expires) {
+			delete(p.cache, input)
+		}
+	}
+	for input := range p.cache {
+		if len(p.cache) < policyCacheMaxEntries {
+			break
+		}
+		delete(p.cache, input)
+	}
+}
+
+// bodyFields are the body fields middleware looks at before the handler
+type bodyFields struct {
+	Context string `json:"context"`
+}
+
+// peekBody decodes bodyFields from the request body and puts the body back
+// for the handler
+func peekBody(r *http.Request) bodyFields {
+	var fields bodyFields
+	if body := peekRawBody(r); body != nil {
+		json.Unmarshal(body, &fields)
+	}
+	return fields
+}
+
+// peekRawBody reads the request body and puts it back for the handler. It
+// returns nil when there is no body or it cannot be read.
+func peekRawBody(r *http.Request) []byte {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	body, err := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+	if err != nil {
+		return nil
+	}
+	return body
+}
+
+// targetContext is the context a mutating request acts on: the "context"
+// field of its body, the {context} path variable, or the context of the
+// call named by {uuid}. The call's info, when looked up, is also returned.
+func (h *APIHandler) targetContext(r *http.Request) (string, *CallContextInfo) {
+	if ctx := peekBody(r).Context; ctx != "" {
+		return ctx, nil
+	}
+	if ctx := mux.Vars(r)["context"]; ctx != "" {
+		return ctx, nil
+	}
+	if callUUID := mux.Vars(r)["uuid"]; callUUID != "" {
+		if info, err := h.getCallContext(callUUID); err == nil && info.Found {
+			return info.AccountCode, info
+		}
+	}
+	return "", nil
+}
+
+// policyMiddleware asks the external policy endpoint, when configured,
+// before any mutating request runs. GraphQL is read-only, so it is not
+// asked about.
+func (h *APIHandler) policyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.policy == nil || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions || r.URL.Path == "/v1/graphql" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// The handler reuses the call's info rather than dumping it again
+		target, callInfo := h.targetContext(r)
+		if callInfo != nil {
+			r = r.WithContext(context.WithValue(r.Context(), callContextKey, callInfo))
+		}
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if tmpl, err := current.GetPathTemplate(); err == nil {
+				route = tmpl
+			}
+		}
+		input := PolicyInput{
+			Token:     getTokenID(r),
+			Method:    r.Method,
+			Route:     route,
+			Context:   target,
+			Operation: requestScope(r),
+			Emergency: h.isEmergencyOriginate(r),
+		}
+
+		d, err := h.policy.decide(r, input)
+		if err != nil {
+			if h.policy.failOpen {
+				logWarn(getRequestID(r), fmt.Sprintf("Policy check failed, allowing (FSAPI_POLICY_FAIL_OPEN): %v", err))
+				next.ServeHTTP(w, r)
+				return
+			}
+			// An unreachable policy service must not stop an emergency call
+			if input.Emergency {
+				logWarn(getRequestID(r), fmt.Sprintf("Policy check failed, allowing emergency originate: %v", err))
+				next.ServeHTTP(w, r)
+				return
+			}
+			h.respondErrorBody(w, r, ErrorResponse{
+				Status:  "error",
+				Message: fmt.Sprintf("Authorization policy unavailable: %v", err),
+				Code:    ErrCodePolicyUnavailable,
+			}, http.StatusServiceUnavailable)
+			return
+		}
+		if !d.allow {
+			message := "Denied by authorization policy"
+			if d.reason != "" {
+				message += ": " + d.reason
+			}
+			h.audit(r, AuditEntry{
+				Action:  "policy.deny",
+				Target:  input.Method + " " + input.Route,
+				Context: input.Context,
+				Reason:  d.reason,
+			})
+			h.respondErrorBody(w, r, ErrorResponse{
+				Status:  "error",
+				Message: message,
+				Code:    ErrCodePolicyDenied,
+			}, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// FSAPI_PRESENCE_SYNC modes, per agent domain
+const (
+	presenceSyncOff    = "off"    // Registrations do not change agent status
+	presenceSyncLogout = "logout" // Log the agent out when its last registration goes away
+	presenceSyncBoth   = "both"   // Also make it Available again when it re-registers
+)
+
+// Registration events of mod_sofia
+var presenceRegisterSubclasses = []string{"sofia::register", "sofia::unregister", "sofia::expire"}
+
+func checkPresenceSyncMode(v string) error {
+	switch v {
+	case presenceSyncOff, presenceSyncLogout, presenceSyncBoth:
+		return nil
+	}
+	return fmt.Errorf("%q is not a presence sync mode (off, logout or both)", v)
+}
+
+// presenceSync keeps callcenter agent status in line with the SIP
+// registrations of their contacts. Only agents it logged out itself are
+// made Available again, so an agent who logged out on purpose stays out.
+type presenceSync struct {
+	modes map[string]string // Domain -> mode, "*" for every other domain
+
+	mu        sync.Mutex
+	loggedOut map[string]bool // Agents logged out because their registration went away
+}
+
+func newPresenceSync(modes map[string]string) *presenceSync {
+	return &presenceSync{modes: modes, loggedOut: make(map[string]bool)}
+}
+
+// registrationAddress returns the user@domain a sofia registration event is
+// about. register and unregister name it in from-user/from-host, expire in
+// user/host.
+func registrationAddress(ev *Event) string {
+	user, host := ev.Get("from-user"), ev.Get("from-host")
+	if user == "" {
+		user, host = ev.Get("user"), ev.Get("host")
+	}
+	if user == "" || host == "" {
+		return ""
+	}
+	return strings.ToLower(user + "@" + host)
+}
+
+// stillRegistered reports whether address has a registration left, e.g. a
+// second phone after the desk phone unregistered
+func (h *APIHandler) stillRegistered(address string) (bool, error) {
+	response, err := h.eslClient.SendCommand("api sofia_contact */" + address)
+	if err != nil {
+		return false, err
+	}
+	return !strings.Contains(response, "user_not_registered"), nil
+}
+
+// handlePresenceEvent logs out the agents of a user whose last registration
+// went away and, in mode both, makes them Available when it comes back
+func (h *APIHandler) handlePresenceEvent(ev *Event) {
+	if ev.Name != "CUSTOM" || !containsString(presenceRegisterSubclasses, ev.Get("Event-Subclass")) {
+		return
+	}
+	address := registrationAddress(ev)
+	if address == "" {
+		return
+	}
+	domain := address[strings.Index(address, "@")+1:]
+	mode := contextValue(h.presence.modes, domain, presenceSyncOff)
+	registered := ev.Get("Event-Subclass") == "sofia::register"
+	if mode == presenceSyncOff || (registered && mode != presenceSyncBoth) {
+		return
+	}
+
+	if !registered {
+		still, err := h.stillRegistered(address)
+		if err != nil {
+			log.Printf("WARNING: Presence sync: failed to check the registrations of %s: %v", address, err)
+			return
+		}
+		if still {
+			return
+		}
+	}
+
+	response, err := h.sendCCCommand("agent list")
+	if err != nil {
+		log.Printf("WARNING: Presence sync: failed to list agents: %v", err)
+		return
+	}
+	for _, agent := range ParsePipeDelimited(response) {
+		if strings.ToLower(agentSIPAddress(agent["contact"])) != address {
+			continue
+		}
+		name := agent["name"]
+		status := ccAgentStatuses[0] // Logged Out
+		h.presence.mu.Lock()
+		if registered {
+			// Only agents this sync logged out
+			if !h.presence.loggedOut[name] || agent["status"] != ccAgentStatuses[0] {
+				delete(h.presence.loggedOut, name)
+				h.presence.mu.Unlock()
+				continue
+			}
+			delete(h.presence.loggedOut, name)
+			status = "Available"
+		} else {
+			if agent["status"] == status {
+				h.presence.mu.Unlock()
+				continue
+			}
+			if mode == presenceSyncBoth {
+				h.presence.loggedOut[name] = true
+			}
+		}
+		h.presence.mu.Unlock()
+
+		if err := h.setAgentStatus(name, status, ""); err != nil {
+			log.Printf("WARNING: Presence sync: failed to set agent %s to %s: %v", name, status, err)
+			continue
+		}
+		log.Printf("Presence sync: agent %s set to %s (%s %s)", name, status, address, strings.TrimPrefix(ev.Get("Event-Subclass"), "sofia::"))
+	}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// New channels per second are averaged over this window
+	realtimeSPSWindow = 5 * time.Second
+
+	// How often SPS alerts are re-evaluated, as the rate falls without events
+	realtimeTick = time.Second
+
+	// How often channels whose destroy event was missed are dropped
+	realtimeReconcileInterval = time.Minute
+
+	// An alert clears once the value falls below this share of its threshold
+	realtimeClearRatio = 0.9
+
+	// Key of FSAPI_CALL_ALERTS and FSAPI_SPS_ALERTS for the switch-wide totals
+	realtimeTotalKey = "total"
+)
+
+// Alerted metrics
+const (
+	realtimeMetricCalls = "calls"
+	realtimeMetricSPS   = "sps"
+)
+
+func checkAlertThreshold(v string) error {
+	if n, err := strconv.ParseFloat(v, 64); err != nil || n <= 0 {
+		return fmt.Errorf("%q is not a positive number", v)
+	}
+	return nil
+}
+
+// RealtimeGauge is the current load of one context, or of the whole switch
+type RealtimeGauge struct {
+	Context        string   `json:"context,omitempty"`
+	Calls          int      `json:"calls"`    // Channels that started a call (not the B-legs they bridged to)
+	Channels       int      `json:"channels"` // All channels
+	SPS            float64  `json:"sps"`      // New channels per second over the last 5 seconds
+	CallsThreshold *float64 `json:"calls_threshold,omitempty"`
+	SPSThreshold   *float64 `json:"sps_threshold,omitempty"`
+	Alerting       []string `json:"alerting"` // Metrics above their threshold
+}
+
+// realtimeChannel is a live channel as the tracker knows it
+type realtimeChannel struct {
+	context string
+	call    bool
+}
+
+// realtimeStats keeps per-context gauges of concurrent calls and channels
+// and the channel creation rate from channel events, and raises alerts when
+// they cross the thresholds of FSAPI_CALL_ALERTS and FSAPI_SPS_ALERTS
+type realtimeStats struct {
+	h          *APIHandler
+	callLimits map[string]string // Context -> threshold, "*" default, "total" switch-wide
+	spsLimits  map[string]string
+
+	mu       sync.Mutex
+	channels map[string]realtimeChannel // UUID -> channel
+	created  map[string][]time.Time     // Context -> creation times within realtimeSPSWindow
+	alerting map[string]bool            // context|metric -> above threshold
+
+	callsGauge    *counterVec
+	channelsGauge *counterVec
+	spsGauge      *counterVec
+	alerts        *counterVec
+}
+
+func newRealtimeStats(h *APIHandler, callLimits, spsLimits map[string]string) *realtimeStats {
+	return &realtimeStats{
+		h:             h,
+		callLimits:    callLimits,
+		spsLimits:     spsLimits,
+		channels:      make(map[string]realtimeChannel),
+		created:       make(map[string][]time.Time),
+		alerting:      make(map[string]bool),
+		callsGauge:    h.metrics.gauge("fsapi_concurrent_calls", "Concurrent calls by context.", "context"),
+		channelsGauge: h.metrics.gauge("fsapi_concurrent_channels", "Concurrent channels by context.", "context"),
+		spsGauge:      h.metrics.gauge("fsapi_sessions_per_second", "New channels per second over the last 5 seconds, by context.", "context"),
+		alerts:        h.metrics.counter("fsapi_realtime_alerts_total", "Concurrent call and SPS thresholds crossed, by metric.", "metric"),
+	}
+}
+
+// threshold returns the threshold of metric for key, if one is set. The
+// "*" default does not apply to the switch-wide totals.
+func (rt *realtimeStats) threshold(metric, key string) (float64, bool) {
+	limits := rt.callLimits
+	if metric == realtimeMetricSPS {
+		limits = rt.spsLimits
+	}
+	v, ok := limits[key]
+	if !ok && key != realtimeTotalKey {
+		v, ok = limits["*"]
+	}
+	if !ok {
+		return 0, false
+	}
+	n, _ := strconv.ParseFloat(v, 64)
+	return n, true
+}
+
+// handleEvent is the event bus subscriber
+func (rt *realtimeStats) handleEvent(ev *Event) {
+	uuid := ev.UUID()
+	if uuid == "" {
+		return
+	}
+	var changed []string
+	rt.mu.Lock()
+	ch, known := rt.channels[uuid]
+	switch {
+	case ev.Name == "CHANNEL_CREATE":
+		if known {
+			break
+		}
+		// Legs created by a bridge name the channel that originated them
+		ch = realtimeChannel{context: ev.Context(), call: ev.Get("Other-Type") != "originator"}
+		rt.channels[uuid] = ch
+		rt.created[ch.context] = append(rt.created[ch.context], ev.Time)
+		changed = []string{ch.context}
+	case ev.Name == "CHANNEL_HANGUP_COMPLETE" || ev.Name == "CHANNEL_DESTROY":
+		if !known {
+			break
+		}
+		delete(rt.channels, uuid)
+		changed = []string{ch.context}
+	case known && ev.Context() != "" && ev.Context() != ch.context:
+		// The dialplan set the accountcode after the channel was created
+		changed = []string{ch.context, ev.Context()}
+		ch.context = ev.Context()
+		rt.channels[uuid] = ch
+	}
+	rt.mu.Unlock()
+
+	if len(changed) > 0 {
+		rt.evaluate(append(changed, realtimeTotalKey), time.Now())
+	}
+}
+
+// gauges computes the current gauges of every context and the switch-wide
+// total. Caller must hold mu.
+func (rt *realtimeStats) gauges(now time.Time) map[string]*RealtimeGauge {
+	gauges := map[string]*RealtimeGauge{realtimeTotalKey: {}}
+	get := func(context string) *RealtimeGauge {
+		g, ok := gauges[context]
+		if !ok {
+			g = &RealtimeGauge{Context: context}
+			gauges[context] = g
+		}
+		return g
+	}
+	for _, ch := range rt.channels {
+		for _, g := range []*RealtimeGauge{get(ch.context), gauges[realtimeTotalKey]} {
+			g.Channels++
+			if ch.call {
+				g.Calls++
+			}
+		}
+	}
+	cutoff := now.Add(-realtimeSPSWindow)
+	for context, times := range rt.created {
+		drop := 0
+		for drop < len(times) && times[drop].Before(cutoff) {
+			drop++
+		}
+		// Emptied but kept, so contexts seen since start are reported at zero
+		times = times[drop:]
+		rt.created[context] = times
+		sps := float64(len(times)) / realtimeSPSWindow.Seconds()
+		get(context).SPS = sps
+		gauges[realtimeTotalKey].SPS += sps
+	}
+	for key, g := range gauges {
+		g.SPS = math.Round(g.SPS*10) / 10
+		g.Alerting = []string{}
+		if v, ok := rt.threshold(realtimeMetricCalls, key); ok {
+			g.CallsThreshold = &v
+		}
+		if v, ok := rt.threshold(realtimeMetricSPS, key); ok {
+			g.SPSThreshold = &v
+		}
+		for _, metric := range []string{realtimeMetricCalls, realtimeMetricSPS} {
+			if rt.alerting[key+"|"+metric] {
+				g.Alerting = append(g.Alerting, metric)
+			}
+		}
+	}
+	return gauges
+}
+
+// evaluate updates the metrics of keys (contexts or "total") and raises or
+// clears their alerts
+func (rt *realtimeStats) evaluate(keys []string, now time.Time) {
+	type alert struct {
+		key, metric, event string
+		value, threshold   float64
+	}
+	var alerts []alert
+	rt.mu.Lock()
+	gauges := rt.gauges(now)
+	for _, key := range keys {
+		g, ok := gauges[key]
+		if !ok {
+			g = &RealtimeGauge{Context: key}
+		}
+		// Prometheus sums the contexts for the switch-wide series
+		if key != realtimeTotalKey {
+			rt.callsGauge.set(float64(g.Calls), key)
+			rt.channelsGauge.set(float64(g.Channels), key)
+			rt.spsGauge.set(g.SPS, key)
+		}
+
+		for metric, value := range map[string]float64{realtimeMetricCalls: float64(g.Calls), realtimeMetricSPS: g.SPS} {
+			threshold, ok := rt.threshold(metric, key)
+			if !ok {
+				continue
+			}
+			id := key + "|" + metric
+			switch {
+			case !rt.alerting[id] && value >= threshold:
+				rt.alerting[id] = true
+				alerts = append(alerts, alert{key, metric, "stats.threshold.exceeded", value, threshold})
+			case rt.alerting[id] && value < threshold*realtimeClearRatio:
+				delete(rt.alerting, id)
+				alerts = append(alerts, alert{key, metric, "stats.threshold.cleared", value, threshold})
+			}
+		}
+	}
+	rt.mu.Unlock()
+
+	for _, a := range alerts {
+		context := a.key
+		if a.key == realtimeTotalKey {
+			context = ""
+		}
+		if a.event == "stats.threshold.exceeded" {
+			rt.alerts.inc(a.metric)
+		}
+		log.Printf("Realtime stats: %s %s %s (%g, threshold %g)", a.key, a.metric, a.event, a.value, a.threshold)
+		rt.h.webhooks.dispatch(a.event, context, map[string]interface{}{
+			"context":   context,
+			"metric":    a.metric,
+			"value":     a.value,
+			"threshold": a.threshold,
+		})
+	}
+}
+
+// run re-evaluates every context once a second, so SPS gauges fall and SPS
+// alerts clear without events, and drops channels whose destroy event was
+// missed, until shutdown starts
+func (rt *realtimeStats) run() {
+	ticker := time.NewTicker(realtimeTick)
+	defer ticker.Stop()
+	lastReconcile := time.Now()
+	for {
+		select {
+		case now := <-ticker.C:
+			if now.Sub(lastReconcile) >= realtimeReconcileInterval {
+				rt.reconcile()
+				lastReconcile = now
+			}
+			rt.mu.Lock()
+			keys := []string{realtimeTotalKey}
+			for key := range rt.gauges(now) {
+				if key != realtimeTotalKey {
+					keys = append(keys, key)
+				}
+			}
+			rt.mu.Unlock()
+			rt.evaluate(keys, now)
+		case <-rt.h.jobs.stopping():
+			return
+		}
+	}
+}
+
+// reconcile drops tracked channels FreeSWITCH no longer has
+func (rt *realtimeStats) reconcile() {
+	response, err := rt.h.eslClient.SendCommand("api show channels as json")
+	if err != nil {
+		return
+	}
+	var data struct {
+		Rows []struct {
+			UUID string `json:"uuid"`
+		} `json:"rows"`
+	}
+	if err := json.Unmarshal([]byte(response), &data); err != nil {
+		return
+	}
+	live := make(map[string]bool, len(data.Rows))
+	for _, row := range data.Rows {
+		live[row.UUID] = true
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	// A channel created after the listing may not be in it yet
+	for uuid := range rt.channels {
+		if !live[uuid] {
+			delete(rt.channels, uuid)
+		}
+	}
+}
+
+// GET /v1/stats/realtime
+func (h *APIHandler) GetRealtimeStats(w http.ResponseWriter, r *http.Request) {
+	if h.realtime == nil {
+		h.respondError(w, r, "Realtime statistics require FSAPI_EVENTS=true", http.StatusServiceUnavailable)
+		return
+	}
+	contextFilter := r.URL.Query().Get("context")
+
+	h.realtime.mu.Lock()
+	gauges := h.realtime.gauges(time.Now())
+	h.realtime.mu.Unlock()
+
+	rows := []*RealtimeGauge{}
+	for key, g := range gauges {
+		if key == realtimeTotalKey || (contextFilter != "" && key != contextFilter) || !isContextAllowed(r, key) {
+			continue
+		}
+		rows = append(rows, g)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Context < rows[j].Context })
+
+	data := map[string]interface{}{
+		"contexts":     rows,
+		"generated_at": time.Now().UTC(),
+	}
+	// The switch-wide load is not broken down by tenant
+	if isUnrestrictedAccess(r) {
+		data["total"] = gauges[realtimeTotalKey]
+	}
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   data,
+	})
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FieldError describes one problem with a request body field
+type FieldError struct {
+	Field   string `json:"field"` // Dotted path, e.g. "billing.rate_plan_id" or "variables[2]"
+	Message string `json:"message"`
+}
+
+// bodyError is a request body that does not match its schema
+type bodyError struct {
+	errors []FieldError
+}
+
+func (e *bodyError) Error() string {
+	parts := make([]string, 0, len(e.errors))
+	for _, fe := range e.errors {
+		if fe.Field == "" {
+			parts = append(parts, fe.Message)
+		} else {
+			parts = append(parts, fmt.Sprintf("%s: %s", fe.Field, fe.Message))
+		}
+	}
+	return "Invalid request body: " + strings.Join(parts, "; ")
+}
+
+// contentTypeError is a non-empty body that is not declared as JSON
+type contentTypeError struct {
+	contentType string
+}
+
+func (e *contentTypeError) Error() string {
+	if e.contentType == "" {
+		return "Content-Type header is required; request bodies must be application/json"
+	}
+	return fmt.Sprintf("Unsupported Content-Type %q; request bodies must be application/json", e.contentType)
+}
+
+// requestDefaults is implemented by request types with documented defaults
+// for omitted fields. decodeRequest applies them after decoding, including
+// when an optional body is absent.
+type requestDefaults interface {
+	applyDefaults()
+}
+
+var (
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	timeType       = reflect.TypeOf(time.Time{})
+)
+
+// decodeJSONBody decodes the request body into v, rejecting fields that v
+// does not declare and values of the wrong JSON type. All problems are
+// reported at once as a *bodyError. An empty body returns io.EOF so callers
+// can decide whether the body is optional; a non-empty body must be sent as
+// application/json or a *contentTypeError is returned.
+func decodeJSONBody(r *http.Request, v interface{}) error {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return io.EOF
+	}
+	contentType := r.Header.Get("Content-Type")
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType != "application/json" {
+		return &contentTypeError{contentType: contentType}
+	}
+
+	var raw interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return &bodyError{errors: []FieldError{{Message: fmt.Sprintf("malformed JSON: %v", err)}}}
+	}
+	if decoder.More() {
+		return &bodyError{errors: []FieldError{{Message: "malformed JSON: unexpected data after the JSON value"}}}
+	}
+
+	var problems []FieldError
+	checkJSONValue(raw, reflect.TypeOf(v).Elem(), "", &problems)
+	if len(problems) > 0 {
+		return &bodyError{errors: problems}
+	}
+
+	strict := json.NewDecoder(bytes.NewReader(data))
+	strict.DisallowUnknownFields()
+	if err := strict.Decode(v); err != nil {
+		return &bodyError{errors: []FieldError{{Message: err.Error()}}}
+	}
+	return nil
+}
+
+// checkJSONValue compares a decoded JSON value with the Go type it will be
+// stored in and records unknown fields and type mismatches
+func checkJSONValue(value interface{}, t reflect.Type, path string, problems *[]FieldError) {
+	if value == nil {
+		return // null leaves the field at its zero value
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == rawMessageType || t.Kind() == reflect.Interface {
+		return
+	}
+	if reflect.PointerTo(t).Implements(reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()) && t != timeType {
+		return // Custom decoding; let it judge its input
+	}
+
+	mismatch := func(want string) {
+		*problems = append(*problems, FieldError{Field: path, Message: fmt.Sprintf("must be %s", want)})
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if t == timeType {
+			if _, ok := value.(string); !ok {
+				mismatch("an RFC 3339 timestamp string")
+			}
+			return
+		}
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			mismatch("an object")
+			return
+		}
+		fields := jsonFieldTypes(t)
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fieldType, name, ok := lookupJSONField(fields, key)
+			if !ok {
+				message := "unknown field"
+				if suggestion := closestJSONField(fields, key); suggestion != "" {
+					message = fmt.Sprintf("unknown field (did you mean %q?)", suggestion)
+				}
+				*problems = append(*problems, FieldError{Field: joinFieldPath(path, key), Message: message})
+				continue
+			}
+			checkJSONValue(object[key], fieldType, joinFieldPath(path, name), problems)
+		}
+	case reflect.Map:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			mismatch("an object")
+			return
+		}
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			checkJSONValue(object[key], t.Elem(), joinFieldPath(path, key), problems)
+		}
+	case reflect.Slice, reflect.Array:
+		list, ok := value.([]interface{})
+		if !ok {
+			mismatch("an array")
+			return
+		}
+		for i, item := range list {
+			checkJSONValue(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), problems)
+		}
+	case reflect.String:
+		if _, ok := value.(string); !ok {
+			mismatch("a string")
+		}
+	case reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			mismatch("a boolean")
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, ok := value.(json.Number)
+		if !ok {
+			mismatch("an integer")
+			return
+		}
+		if _, err := n.Int64(); err != nil {
+			mismatch("an integer")
+		}
+	case reflect.Float32, reflect.Float64:
+		if _, ok := value.(json.Number); !ok {
+			mismatch("a number")
+		}
+	}
+}
+
+// jsonFieldTypes maps the JSON names of a struct's fields (including those
+// of embedded structs) to their types
+func jsonFieldTypes(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			embedded := f.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				for k, v := range jsonFieldTypes(embedded) {
+					fields[k] = v
+				}
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+// lookupJSONField finds key the way encoding/json does: exact match first,
+// then case-insensitive
+func lookupJSONField(fields map[string]reflect.Type, key string) (reflect.Type, string, bool) {
+	if t, ok := fields[key]; ok {
+		return t, key, true
+	}
+	for name, t := range fields {
+		if strings.EqualFold(name, key) {
+			return t, name, true
+		}
+	}
+	return nil, "", false
+}
+
+// closestJSONField suggests the declared field nearest to a misspelled key
+func closestJSONField(fields map[string]reflect.Type, key string) string {
+	best, bestDistance := "", 3 // Only suggest close matches
+	for name := range fields {
+		if d := editDistance(strings.ToLower(key), strings.ToLower(name)); d < bestDistance || (d == bestDistance && best != "" && name < best) {
+			best, bestDistance = name, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func joinFieldPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// decodeRequest decodes a required JSON body into v and applies its
+// defaults. It writes the error response and returns false on failure.
+func (h *APIHandler) decodeRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := decodeJSONBody(r, v); err != nil {
+		h.respondBodyError(w, r, err)
+		return false
+	}
+	if d, ok := v.(requestDefaults); ok {
+		d.applyDefaults()
+	}
+	return true
+}
+
+// decodeOptionalRequest is decodeRequest for endpoints whose body may be
+// omitted entirely; v then holds only its defaults
+func (h *APIHandler) decodeOptionalRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := decodeJSONBody(r, v); err != nil && err != io.EOF {
+		h.respondBodyError(w, r, err)
+		return false
+	}
+	if d, ok := v.(requestDefaults); ok {
+		d.applyDefaults()
+	}
+	return true
+}
+
+// respondBodyError writes the response for an error from decodeJSONBody:
+// 400 empty_body, 400 invalid_body, 413 body_too_large or 415
+// unsupported_media_type
+func (h *APIHandler) respondBodyError(w http.ResponseWriter, r *http.Request, err error) {
+	if err == io.EOF {
+		h.respondErrorBody(w, r, ErrorResponse{
+			Status:  "error",
+			Message: "Request body is required",
+			Code:    ErrCodeEmptyBody,
+		}, http.StatusBadRequest)
+		return
+	}
+	if ce, ok := err.(*contentTypeError); ok {
+		h.respondErrorBody(w, r, ErrorResponse{
+			Status:  "error",
+			Message: ce.Error(),
+			Code:    ErrCodeUnsupportedMedia,
+		}, http.StatusUnsupportedMediaType)
+		return
+	}
+	if be, ok := err.(*bodyError); ok {
+		h.respondErrorBody(w, r, ErrorResponse{
+			Status:  "error",
+			Message: be.Error(),
+			Code:    ErrCodeInvalidBody,
+			Errors:  be.errors,
+		}, http.StatusBadRequest)
+		return
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		h.respondBodyTooLarge(w, r, tooLarge.Limit)
+		return
+	}
+	h.respondError(w, r, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+}
+
+// respondBodyTooLarge writes a 413 naming the route's body limit
+func (h *APIHandler) respondBodyTooLarge(w http.ResponseWriter, r *http.Request, limit int64) {
+	h.respondErrorBody(w, r, ErrorResponse{
+		Status:  "error",
+		Message: fmt.Sprintf("Request body exceeds the %d byte limit", limit),
+		Code:    ErrCodeBodyTooLarge,
+		Limit:   limit,
+	}, http.StatusRequestEntityTooLarge)
+}
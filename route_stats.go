@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Groupings of GET /v1/stats/routes
+const (
+	routeStatsByGateway = "gateway"
+	routeStatsByContext = "context"
+)
+
+// RouteStats are the carrier quality figures of one gateway or context over
+// a window
+type RouteStats struct {
+	Gateway  string   `json:"gateway,omitempty"`
+	Context  string   `json:"context,omitempty"`
+	Attempts int      `json:"attempts"`          // Outbound legs through a gateway that ended
+	Answered int      `json:"answered"`          // Those of them that were answered
+	ASR      float64  `json:"asr"`               // Answer-seizure ratio, percent of attempts answered
+	ACDSec   float64  `json:"acd_sec"`           // Average billable duration of the answered calls
+	PDDSec   *float64 `json:"pdd_sec,omitempty"` // Average post-dial delay of the attempts that rang or answered
+	Minutes  float64  `json:"minutes"`           // Billable minutes of the answered calls
+}
+
+// routeAttempt is one finished outbound gateway leg
+type routeAttempt struct {
+	at       time.Time
+	gateway  string
+	context  string
+	answered bool
+	billsec  int
+	pdd      time.Duration // Negative when the leg never rang or answered
+}
+
+// routeStats computes ASR, ACD and PDD per gateway and context from the
+// hangups of outbound gateway legs, keeping the attempts of the last window
+// for the stats API and cumulative counters for Prometheus
+type routeStats struct {
+	window time.Duration
+
+	mu       sync.Mutex
+	attempts []routeAttempt // Oldest first
+
+	attemptsTotal *counterVec
+	answeredTotal *counterVec
+	billsecTotal  *counterVec
+	pddTotal      *counterVec
+	pddCount      *counterVec
+}
+
+func newRouteStats(metrics *metricsRegistry, window time.Duration) *routeStats {
+	return &routeStats{
+		window:        window,
+		attemptsTotal: metrics.counter("fsapi_route_attempts_total", "Outbound gateway legs that ended, by gateway and context.", "gateway", "context"),
+		answeredTotal: metrics.counter("fsapi_route_answered_total", "Outbound gateway legs that were answered, by gateway and context.", "gateway", "context"),
+		billsecTotal:  metrics.counter("fsapi_route_billsec_total", "Billable seconds of answered outbound gateway legs, by gateway and context.", "gateway", "context"),
+		pddTotal:      metrics.counter("fsapi_route_pdd_seconds_total", "Post-dial delay of outbound gateway legs that rang or answered, by gateway and context.", "gateway", "context"),
+		pddCount:      metrics.counter("fsapi_route_pdd_measured_total", "Outbound gateway legs whose post-dial delay was measured, by gateway and context.", "gateway", "context"),
+	}
+}
+
+// postDialDelay returns the time from a leg's creation to its first ringback
+// (180/183) or, without one, to its answer; negative when there was neither
+func postDialDelay(ev *Event) time.Duration {
+	created := ev.EventTime("Caller-Channel-Created-Time")
+	if created.IsZero() {
+		return -1
+	}
+	for _, header := range []string{"Caller-Channel-Progress-Time", "Caller-Channel-Progress-Media-Time", "Caller-Channel-Answered-Time"} {
+		if t := ev.EventTime(header); !t.IsZero() && !t.Before(created) {
+			return t.Sub(created)
+		}
+	}
+	return -1
+}
+
+// handleEvent is the event bus subscriber
+func (rs *routeStats) handleEvent(ev *Event) {
+	if ev.Name != "CHANNEL_HANGUP_COMPLETE" || ev.Get("Call-Direction") != "outbound" {
+		return
+	}
+	gw := eventGateway(ev)
+	if gw == "" {
+		return
+	}
+	a := routeAttempt{
+		at:       ev.Time,
+		gateway:  gw,
+		context:  ev.Context(),
+		answered: !ev.EventTime("Caller-Channel-Answered-Time").IsZero(),
+		pdd:      postDialDelay(ev),
+	}
+	if a.at.IsZero() {
+		a.at = time.Now()
+	}
+	if a.answered {
+		a.billsec, _ = strconv.Atoi(ev.Var("billsec"))
+	}
+
+	rs.attemptsTotal.inc(a.gateway, a.context)
+	if a.answered {
+		rs.answeredTotal.inc(a.gateway, a.context)
+		rs.billsecTotal.add(float64(a.billsec), a.gateway, a.context)
+	}
+	if a.pdd >= 0 {
+		rs.pddTotal.add(a.pdd.Seconds(), a.gateway, a.context)
+		rs.pddCount.inc(a.gateway, a.context)
+	}
+
+	rs.mu.Lock()
+	rs.attempts = append(rs.attempts, a)
+	rs.prune(time.Now())
+	rs.mu.Unlock()
+}
+
+// prune drops attempts older than the window. Caller must hold mu.
+func (rs *routeStats) prune(now time.Time) {
+	cutoff := now.Add(-rs.window)
+	drop := 0
+	for drop < len(rs.attempts) && rs.attempts[drop].at.Before(cutoff) {
+		drop++
+	}
+	rs.attempts = rs.attempts[drop:]
+}
+
+// since returns the attempts that ended after t
+func (rs *routeStats) since(t time.Time) []routeAttempt {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.prune(time.Now())
+	i := sort.Search(len(rs.attempts), func(i int) bool { return rs.attempts[i].at.After(t) })
+	return append([]routeAttempt(nil), rs.attempts[i:]...)
+}
+
+// summarizeRoute computes the stats of attempts taken together
+func summarizeRoute(attempts []routeAttempt) *RouteStats {
+	s := &RouteStats{Attempts: len(attempts)}
+	billsec, pddN := 0, 0
+	var pdd time.Duration
+	for _, a := range attempts {
+		if a.answered {
+			s.Answered++
+			billsec += a.billsec
+		}
+		if a.pdd >= 0 {
+			pdd += a.pdd
+			pddN++
+		}
+	}
+	if s.Attempts > 0 {
+		s.ASR = math.Round(float64(s.Answered)/float64(s.Attempts)*1000) / 10
+	}
+	if s.Answered > 0 {
+		s.ACDSec = math.Round(float64(billsec)/float64(s.Answered)*10) / 10
+	}
+	s.Minutes = math.Round(float64(billsec)/60*10) / 10
+	if pddN > 0 {
+		avg := math.Round(pdd.Seconds()/float64(pddN)*100) / 100
+		s.PDDSec = &avg
+	}
+	return s
+}
+
+// summarizeRoutes computes the stats of attempts, grouped by gateway or context
+func summarizeRoutes(attempts []routeAttempt, by string) []*RouteStats {
+	groups := map[string][]routeAttempt{}
+	for _, a := range attempts {
+		key := a.gateway
+		if by == routeStatsByContext {
+			key = a.context
+		}
+		groups[key] = append(groups[key], a)
+	}
+
+	rows := make([]*RouteStats, 0, len(groups))
+	for key, group := range groups {
+		s := summarizeRoute(group)
+		if by == routeStatsByContext {
+			s.Context = key
+		} else {
+			s.Gateway = key
+		}
+		rows = append(rows, s)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].Gateway+rows[i].Context < rows[j].Gateway+rows[j].Context
+	})
+	return rows
+}
+
+// GET /v1/stats/routes
+func (h *APIHandler) GetRouteStats(w http.ResponseWriter, r *http.Request) {
+	if h.routeStats == nil {
+		h.respondError(w, r, "Route statistics require FSAPI_EVENTS=true", http.StatusServiceUnavailable)
+		return
+	}
+	q := r.URL.Query()
+	by := q.Get("by")
+	if by == "" {
+		by = routeStatsByGateway
+	}
+	if by != routeStatsByGateway && by != routeStatsByContext {
+		h.respondError(w, r, "by must be gateway or context", http.StatusBadRequest)
+		return
+	}
+	window := h.routeStats.window
+	if v := q.Get("window"); v != "" {
+		sec, err := strconv.Atoi(v)
+		if err != nil || sec <= 0 || time.Duration(sec)*time.Second > h.routeStats.window {
+			h.respondError(w, r, fmt.Sprintf("window must be between 1 and %d seconds", int(h.routeStats.window.Seconds())), http.StatusBadRequest)
+			return
+		}
+		window = time.Duration(sec) * time.Second
+	}
+	gatewayFilter, contextFilter := q.Get("gateway"), q.Get("context")
+
+	// Restricted callers see the attempts of their own contexts, also when
+	// grouped by gateway
+	now := time.Now().UTC()
+	var attempts []routeAttempt
+	for _, a := range h.routeStats.since(now.Add(-window)) {
+		if (gatewayFilter != "" && a.gateway != gatewayFilter) || (contextFilter != "" && a.context != contextFilter) {
+			continue
+		}
+		if isContextAllowed(r, a.context) {
+			attempts = append(attempts, a)
+		}
+	}
+
+	rows := summarizeRoutes(attempts, by)
+	h.respondJSON(w, r, map[string]interface{}{
+		"status":       "success",
+		"by":           by,
+		"window_sec":   int(window.Seconds()),
+		"generated_at": now,
+		"row_count":    len(rows),
+		"rows":         rows,
+	})
+}
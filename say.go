@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default mod_say language when a prompt names none
+const sayDefaultLanguage = "en"
+
+// sayLanguagePattern matches a mod_say module, optionally with the
+// language it is asked for, e.g. en or es:es_MX
+var sayLanguagePattern = regexp.MustCompile(`^[a-z]{2,3}(:[a-z]{2,3}(_[A-Z]{2})?)?$`)
+
+// sayType is how a say prompt type is passed to the say application, and
+// the values it accepts
+type sayType struct {
+	name    string // mod_say type
+	method  string // mod_say method
+	pattern *regexp.Regexp
+}
+
+// sayTypes are the say prompt types. Dates and times are spoken from Unix
+// seconds, in the channel's timezone variable or the switch's default
+// timezone.
+var sayTypes = map[string]sayType{
+	"number":    {"NUMBER", "PRONOUNCED", regexp.MustCompile(`^-?[0-9]{1,9}$`)},
+	"digits":    {"NUMBER", "ITERATED", regexp.MustCompile(`^[0-9]{1,32}$`)},
+	"ordinal":   {"NUMBER", "COUNTED", regexp.MustCompile(`^[0-9]{1,9}$`)},
+	"currency":  {"CURRENCY", "PRONOUNCED", regexp.MustCompile(`^-?[0-9]{1,9}(\.[0-9]{1,2})?$`)},
+	"date":      {"CURRENT_DATE", "PRONOUNCED", nil},
+	"time":      {"CURRENT_TIME", "PRONOUNCED", nil},
+	"date_time": {"CURRENT_DATE_TIME", "PRONOUNCED", nil},
+}
+
+var sayTypeNames = []string{"number", "digits", "ordinal", "currency", "date", "time", "date_time"}
+
+var sayGenders = []string{"masculine", "feminine", "neuter"}
+
+// SayPrompt speaks a dynamic value, such as an amount or a date, with the
+// say application instead of a pre-rendered file
+type SayPrompt struct {
+	Type     string `json:"type"`               // number, digits, ordinal, currency, date, time or date_time
+	Value    string `json:"value"`              // Dates and times: Unix seconds or RFC 3339
+	Language string `json:"language,omitempty"` // mod_say module, e.g. en, de or es:es_MX; default en
+	Gender   string `json:"gender,omitempty"`   // masculine, feminine or neuter, for languages that inflect numbers
+}
+
+// checkSay checks that say can be passed to the say application
+func checkSay(say *SayPrompt) error {
+	if _, ok := sayTypes[say.Type]; !ok {
+		return fmt.Errorf("say.type must be one of: %s", strings.Join(sayTypeNames, ", "))
+	}
+	if say.Language != "" && !sayLanguagePattern.MatchString(say.Language) {
+		return fmt.Errorf("say.language must be a mod_say language such as en, de or es:es_MX")
+	}
+	if say.Gender != "" && !containsString(sayGenders, say.Gender) {
+		return fmt.Errorf("say.gender must be one of: %s", strings.Join(sayGenders, ", "))
+	}
+	if say.Value == "" {
+		return fmt.Errorf("say.value is required")
+	}
+	_, err := say.args()
+	return err
+}
+
+// args returns the arguments of the say application for say
+func (say *SayPrompt) args() (string, error) {
+	t := sayTypes[say.Type]
+	value := say.Value
+	if t.pattern == nil {
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			ts, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return "", fmt.Errorf("say.value must be Unix seconds or an RFC 3339 time for %s", say.Type)
+			}
+			value = strconv.FormatInt(ts.Unix(), 10)
+		}
+	} else if !t.pattern.MatchString(value) {
+		return "", fmt.Errorf("say.value is not a valid %s", say.Type)
+	}
+
+	lang := say.Language
+	if lang == "" {
+		lang = sayDefaultLanguage
+	}
+	args := []string{lang, t.name, t.method}
+	if say.Gender != "" {
+		args = append(args, strings.ToUpper(say.Gender))
+	}
+	return strings.Join(append(args, value), " "), nil
+}
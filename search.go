@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// searchTypes are the resources GET /v1/search looks through, in the order
+// of its hits
+var searchTypes = []string{"call", "channel", "registration", "agent", "queue"}
+
+// searchFields are the fields of each resource a search matches against.
+// Contexts and realms are left out so that a domain does not match every
+// resource in it; hits carry their domain instead.
+var searchFields = map[string][]string{
+	"call": {"uuid", "name", "cid_name", "cid_num", "dest", "callee_name", "callee_num", "presence_id",
+		"b_uuid", "b_name", "b_cid_name", "b_cid_num", "b_dest", "b_callee_name", "b_callee_num", "b_presence_id"},
+	"channel":      {"uuid", "call_uuid", "name", "cid_name", "cid_num", "dest", "callee_name", "callee_num", "presence_id", "ip_addr"},
+	"registration": {"user", "reg_user", "url", "network_ip"},
+	"agent":        {"name", "contact"},
+	"queue":        {"name"},
+}
+
+// Minimum length of a search query, so that it cannot match everything
+const searchMinQueryLength = 2
+
+// SearchHit is a resource that matched a search
+type SearchHit struct {
+	Type   string                 `json:"type"` // call, channel, registration, agent or queue
+	ID     string                 `json:"id"`
+	Domain string                 `json:"domain,omitempty"`
+	Fields []string               `json:"fields"`         // Fields the query matched
+	Link   string                 `json:"link,omitempty"` // Endpoint with more about the resource
+	Data   map[string]interface{} `json:"data"`
+}
+
+// searchMatch returns the fields of row that contain query, which is lower
+// case
+func searchMatch(kind string, row map[string]interface{}, query string) []string {
+	var fields []string
+	for _, field := range searchFields[kind] {
+		if v, ok := row[field].(string); ok && strings.Contains(strings.ToLower(v), query) {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// showRows returns the rows of "show <what> as json"
+func (h *APIHandler) showRows(what string) ([]map[string]interface{}, error) {
+	response, err := h.eslClient.SendCommand(fmt.Sprintf("api show %s as json", what))
+	if err != nil {
+		return nil, err
+	}
+	var data struct {
+		Rows []map[string]interface{} `json:"rows"`
+	}
+	if err := json.Unmarshal([]byte(response), &data); err != nil {
+		return nil, fmt.Errorf("failed to parse %s data: %v", what, err)
+	}
+	return data.Rows, nil
+}
+
+// ccRows returns the rows of a callcenter_config list as show rows
+func (h *APIHandler) ccRows(args string) ([]map[string]interface{}, error) {
+	response, err := h.sendCCCommand(args)
+	if err != nil {
+		return nil, err
+	}
+	var rows []map[string]interface{}
+	for _, row := range ParsePipeDelimited(response) {
+		data := make(map[string]interface{}, len(row))
+		for k, v := range row {
+			data[k] = v
+		}
+		rows = append(rows, data)
+	}
+	return rows, nil
+}
+
+// searchRows returns the rows of a resource type with the id, domain and
+// link of each
+func (h *APIHandler) searchRows(kind string, contextMap map[string]string) ([]map[string]interface{}, func(map[string]interface{}) (id, domain, link string), error) {
+	str := func(row map[string]interface{}, field string) string {
+		v, _ := row[field].(string)
+		return v
+	}
+	switch kind {
+	case "call":
+		rows, err := h.showRows("calls")
+		return rows, func(row map[string]interface{}) (string, string, string) {
+			id := str(row, "uuid")
+			return id, resolveCallContext(row, contextMap), "/v1/calls/" + id
+		}, err
+	case "channel":
+		rows, err := h.showRows("channels")
+		return rows, func(row map[string]interface{}) (string, string, string) {
+			id := str(row, "uuid")
+			return id, str(row, "context"), "/v1/calls/" + id
+		}, err
+	case "registration":
+		rows, err := h.showRows("registrations")
+		for _, row := range rows {
+			row["user"] = str(row, "reg_user") + "@" + str(row, "realm")
+		}
+		// Registrations have no endpoint of their own
+		return rows, func(row map[string]interface{}) (string, string, string) {
+			return str(row, "user"), str(row, "realm"), ""
+		}, err
+	case "agent":
+		rows, err := h.ccRows("agent list")
+		return rows, func(row map[string]interface{}) (string, string, string) {
+			id := str(row, "name")
+			return id, ExtractDomainFromContact(str(row, "contact")), "/v1/callcenter/agents/" + url.PathEscape(id) + "/utilization"
+		}, err
+	default:
+		rows, err := h.ccRows("queue list")
+		return rows, func(row map[string]interface{}) (string, string, string) {
+			id := str(row, "name")
+			return id, extractDomain(id), "/v1/callcenter/queues/" + url.PathEscape(id)
+		}, err
+	}
+}
+
+// GET /v1/search?q=1001@customer1.example.com
+func (h *APIHandler) Search(w http.ResponseWriter, r *http.Request) {
+	requestID := getRequestID(r)
+
+	if !contextsDeclared(r) {
+		h.respondError(w, r, "X-Allowed-Contexts header is required for this endpoint", http.StatusBadRequest)
+		return
+	}
+	allowedContexts := getAllowedContexts(r)
+	unrestricted := isUnrestrictedAccess(r)
+
+	query := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q")))
+	if len(query) < searchMinQueryLength {
+		h.respondError(w, r, fmt.Sprintf("q must be at least %d characters", searchMinQueryLength), http.StatusBadRequest)
+		return
+	}
+
+	kinds := searchTypes
+	if v := r.URL.Query().Get("type"); v != "" {
+		kinds = splitCSV(v)
+		valid := len(kinds) > 0
+		for _, kind := range kinds {
+			valid = valid && containsString(searchTypes, kind)
+		}
+		if !valid {
+			h.respondError(w, r, fmt.Sprintf("type must be one or more of: %s", strings.Join(searchTypes, ", ")), http.StatusBadRequest)
+			return
+		}
+	}
+
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			h.respondError(w, r, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	// Calls without an accountcode take the context of their channel
+	var contextMap map[string]string
+	if containsString(kinds, "call") {
+		contextMap = h.channelContextMap()
+	}
+
+	hits := []SearchHit{}
+	skipped := []string{}
+	var lastErr error
+	searched, truncated := 0, false
+	for _, kind := range searchTypes {
+		if !containsString(kinds, kind) {
+			continue
+		}
+		searched++
+		rows, describe, err := h.searchRows(kind, contextMap)
+		if err != nil {
+			// mod_callcenter is optional; search what is available
+			logWarn(requestID, fmt.Sprintf("Search skipped %s: %v", kind, err))
+			skipped = append(skipped, kind)
+			lastErr = err
+			continue
+		}
+		for _, row := range rows {
+			fields := searchMatch(kind, row, query)
+			if len(fields) == 0 {
+				continue
+			}
+			id, domain, link := describe(row)
+			if !unrestricted && (domain == "" || !containsString(allowedContexts, domain)) {
+				continue
+			}
+			if len(hits) == limit {
+				truncated = true
+				break
+			}
+			hits = append(hits, SearchHit{Type: kind, ID: id, Domain: domain, Fields: fields, Link: link, Data: row})
+		}
+		if truncated {
+			break
+		}
+	}
+	if len(skipped) == searched {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to search: %v", lastErr), lastErr)
+		return
+	}
+
+	logInfo(requestID, fmt.Sprintf("Search for %q in contexts %v: %d hits", query, allowedContexts, len(hits)))
+	h.respondJSON(w, r, map[string]interface{}{
+		"status":    "success",
+		"row_count": len(hits),
+		"rows":      hits,
+		"truncated": truncated,
+		"skipped":   skipped,
+	})
+}
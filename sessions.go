@@ -0,0 +1,348 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const (
+	sessionKey         contextKey = "session"
+	sessionTokenPrefix            = "fss_"
+	sessionDefaultTTL             = 15 * time.Minute
+	sessionsFile                  = "sessions.json"
+)
+
+// Areas a session scope can name: the first path segment under /v1 of the
+// registered routes ("ext" covers every extension), plus "system" for
+// /health and /metrics. main sets them from the router with routeAreas.
+var sessionAreas []string
+
+// sessionlessAreas are never scopes: sessions can never mint sessions
+var sessionlessAreas = []string{"auth"}
+
+// routeAreas returns the areas of router's routes as requestScope names
+// them, sorted
+func routeAreas(router *mux.Router) []string {
+	seen := map[string]bool{}
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		area := "system"
+		if rest, ok := strings.CutPrefix(path, "/v1/"); ok {
+			area, _, _ = strings.Cut(rest, "/")
+		}
+		if !containsString(sessionlessAreas, area) {
+			seen[area] = true
+		}
+		return nil
+	})
+	areas := make([]string, 0, len(seen))
+	for area := range seen {
+		areas = append(areas, area)
+	}
+	sort.Strings(areas)
+	return areas
+}
+
+// Session is a short-lived token minted by a long-lived credential, limited
+// to some scopes and contexts
+type Session struct {
+	ID        string    `json:"id"`
+	Scopes    []string  `json:"scopes"`             // "<area>:read" or "<area>:write"
+	Contexts  []string  `json:"contexts,omitempty"` // Empty means unrestricted
+	Parent    string    `json:"parent"`             // Fingerprint of the minting token or signing key
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// allows reports whether the session's scopes cover a request scope
+func (s *Session) allows(scope string) bool {
+	return containsString(s.Scopes, scope)
+}
+
+// sessionStore keeps sessions by token hash, persisted so that they
+// outlive a restart. The tokens themselves are never stored.
+type sessionStore struct {
+	maxTTL time.Duration
+
+	mu     sync.Mutex
+	byHash map[string]*Session
+}
+
+// newSessionStore loads the live sessions from the store
+func newSessionStore(maxTTL time.Duration) *sessionStore {
+	s := &sessionStore{maxTTL: maxTTL, byHash: make(map[string]*Session)}
+	if err := loadJSONFile(sessionsFile, &s.byHash); err != nil {
+		log.Printf("WARNING: Failed to load sessions: %v", err)
+	}
+	s.prune()
+	return s
+}
+
+// save persists the sessions. Caller must hold mu.
+func (s *sessionStore) save() {
+	if err := saveJSONFile(sessionsFile, s.byHash); err != nil {
+		log.Printf("WARNING: Failed to persist sessions: %v", err)
+	}
+}
+
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// create stores a session and returns its token, which is not kept
+func (s *sessionStore) create(session *Session) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := sessionTokenPrefix + base64.RawURLEncoding.EncodeToString(raw)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune()
+	s.byHash[hashSessionToken(token)] = session
+	s.save()
+	return token, nil
+}
+
+// lookup returns the live session of a token
+func (s *sessionStore) lookup(token string) (*Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.byHash[hashSessionToken(token)]
+	if !ok || time.Now().After(session.ExpiresAt) {
+		return nil, false
+	}
+	return session, true
+}
+
+// list returns the live sessions minted by parent, or all of them when
+// parent is empty, oldest first
+func (s *sessionStore) list(parent string) []*Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune()
+	sessions := []*Session{}
+	for _, session := range s.byHash {
+		if parent == "" || session.Parent == parent {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].CreatedAt.Before(sessions[j].CreatedAt) })
+	return sessions
+}
+
+// revoke deletes the session with the given ID if parent minted it (any
+// session when parent is empty)
+func (s *sessionStore) revoke(id, parent string) (*Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, session := range s.byHash {
+		if session.ID == id && (parent == "" || session.Parent == parent) {
+			delete(s.byHash, hash)
+			s.save()
+			return session, true
+		}
+	}
+	return nil, false
+}
+
+// prune drops expired sessions. Caller must hold mu.
+func (s *sessionStore) prune() {
+	now := time.Now()
+	pruned := false
+	for hash, session := range s.byHash {
+		if now.After(session.ExpiresAt) {
+			delete(s.byHash, hash)
+			pruned = true
+		}
+	}
+	if pruned {
+		s.save()
+	}
+}
+
+// getSession returns the session the request authenticated with
+func getSession(r *http.Request) (*Session, bool) {
+	session, ok := r.Context().Value(sessionKey).(*Session)
+	return session, ok
+}
+
+// requestScope is the scope a session needs for a request. GraphQL is
+// read-only whatever the method.
+func requestScope(r *http.Request) string {
+	area := "system"
+	if rest, ok := strings.CutPrefix(r.URL.Path, "/v1/"); ok {
+		area, _, _ = strings.Cut(rest, "/")
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead || area == "graphql" {
+		return area + ":read"
+	}
+	return area + ":write"
+}
+
+// validateScopes checks requested session scopes
+func validateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("scopes is required")
+	}
+	for _, scope := range scopes {
+		area, access, ok := strings.Cut(scope, ":")
+		if scope == callerIDOverrideScope {
+			continue
+		}
+		if !ok || !containsString(sessionAreas, area) || (access != "read" && access != "write") {
+			return fmt.Errorf("invalid scope %q (expected <area>:read, <area>:write or %s, area one of %s)", scope, callerIDOverrideScope, strings.Join(sessionAreas, ", "))
+		}
+	}
+	return nil
+}
+
+// sessionsEnabled responds 501 when no credentials are configured to mint
+// sessions from, and 403 when the caller is itself a session
+func (h *APIHandler) sessionsEnabled(w http.ResponseWriter, r *http.Request) bool {
+	if h.sessions == nil {
+		h.respondError(w, r, "Session tokens require FSAPI_AUTH_TOKENS or FSAPI_SIGNING_KEYS", http.StatusNotImplemented)
+		return false
+	}
+	if _, ok := getSession(r); ok {
+		h.respondError(w, r, "Session tokens cannot manage sessions", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// POST /v1/auth/sessions
+func (h *APIHandler) CreateSession(w http.ResponseWriter, r *http.Request) {
+	if !h.sessionsEnabled(w, r) {
+		return
+	}
+	var req SessionCreateRequest
+	if !h.decodeRequest(w, r, &req) {
+		return
+	}
+	if err := validateScopes(req.Scopes); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ttl := time.Duration(req.TTLSec) * time.Second
+	if req.TTLSec == 0 {
+		ttl = min(sessionDefaultTTL, h.sessions.maxTTL)
+	}
+	if ttl <= 0 || ttl > h.sessions.maxTTL {
+		h.respondError(w, r, fmt.Sprintf("ttl_sec must be between 1 and %d", int(h.sessions.maxTTL.Seconds())), http.StatusBadRequest)
+		return
+	}
+
+	// A session never reaches further than the caller: restricted callers
+	// default to their own contexts
+	contexts := req.Contexts
+	if len(contexts) == 0 && !isUnrestrictedAccess(r) {
+		contexts = getAllowedContexts(r)
+	}
+	for _, ctx := range contexts {
+		if !h.validateRequestContext(w, r, ctx) {
+			return
+		}
+	}
+	// Only callers that may present any caller ID can pass that on
+	if containsString(req.Scopes, callerIDOverrideScope) && (!callerIDOverride(r) || len(contexts) > 0) {
+		h.respondError(w, r, callerIDOverrideScope+" requires unrestricted access and a session without contexts", http.StatusForbidden)
+		return
+	}
+
+	now := time.Now().UTC()
+	session := &Session{
+		ID:        uuid.New().String(),
+		Scopes:    req.Scopes,
+		Contexts:  contexts,
+		Parent:    getTokenID(r),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	token, err := h.sessions.create(session)
+	if err != nil {
+		h.respondError(w, r, fmt.Sprintf("Failed to create session: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	h.audit(r, AuditEntry{
+		Action:  "sessions.create",
+		Target:  session.ID,
+		Context: strings.Join(contexts, ","),
+		Details: map[string]string{
+			"scopes":  strings.Join(session.Scopes, ","),
+			"ttl_sec": strconv.Itoa(int(ttl.Seconds())),
+		},
+	})
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data": map[string]interface{}{
+			"id":         session.ID,
+			"token":      token,
+			"scopes":     session.Scopes,
+			"contexts":   session.Contexts,
+			"created_at": session.CreatedAt,
+			"expires_at": session.ExpiresAt,
+		},
+	})
+}
+
+// sessionOwner limits listing and revocation to the caller's own sessions;
+// unrestricted callers see every session
+func sessionOwner(r *http.Request) string {
+	if isUnrestrictedAccess(r) {
+		return ""
+	}
+	return getTokenID(r)
+}
+
+// GET /v1/auth/sessions
+func (h *APIHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	if !h.sessionsEnabled(w, r) {
+		return
+	}
+	sessions := h.sessions.list(sessionOwner(r))
+	h.respondJSON(w, r, map[string]interface{}{
+		"status":    "success",
+		"row_count": len(sessions),
+		"rows":      sessions,
+	})
+}
+
+// DELETE /v1/auth/sessions/{id}
+func (h *APIHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	if !h.sessionsEnabled(w, r) {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	session, ok := h.sessions.revoke(id, sessionOwner(r))
+	if !ok {
+		h.respondError(w, r, fmt.Sprintf("Session %s not found", id), http.StatusNotFound)
+		return
+	}
+	h.audit(r, AuditEntry{
+		Action:  "sessions.revoke",
+		Target:  session.ID,
+		Context: strings.Join(session.Contexts, ","),
+	})
+	h.respondSuccess(w, r, fmt.Sprintf("Session %s revoked", id))
+}
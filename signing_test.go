@@ -0,0 +1,237 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newSignedRequest builds a request signed with secret at ts
+func newSignedRequest(method, target, body, keyID, secret string, ts time.Time, nonce string) *http.Request {
+	timestamp := strconv.FormatInt(ts.Unix(), 10)
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(signatureKeyHeader, keyID)
+	req.Header.Set(signatureTimestampHeader, timestamp)
+	if nonce != "" {
+		req.Header.Set(signatureNonceHeader, nonce)
+	}
+	req.Header.Set(signatureHeader, signRequest(secret, timestamp, nonce, method, req.URL.RequestURI(), []byte(body)))
+	return req
+}
+
+func TestSignatureBase(t *testing.T) {
+	tests := []struct {
+		name   string
+		nonce  string
+		method string
+		uri    string
+		body   string
+		want   string
+	}{
+		{"without nonce", "", "GET", "/v1/calls?context=acme", "", "1700000000\nGET\n/v1/calls?context=acme\n"},
+		{"with nonce", "n0nce-0123456789ab", "POST", "/v1/calls/originate", `{"aleg":"user/1000"}`, "1700000000\nn0nce-0123456789ab\nPOST\n/v1/calls/originate\n{\"aleg\":\"user/1000\"}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(signatureBase("1700000000", tt.nonce, tt.method, tt.uri, []byte(tt.body))); got != tt.want {
+				t.Errorf("signatureBase() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// The nonce is signed, so it cannot be swapped for another one
+	a := signRequest("secret", "1700000000", "nonce-aaaaaaaaaaaa", "POST", "/v1/calls/originate", nil)
+	b := signRequest("secret", "1700000000", "nonce-bbbbbbbbbbbb", "POST", "/v1/calls/originate", nil)
+	if a == b {
+		t.Error("signatures with different nonces are equal")
+	}
+}
+
+func TestSignedRequestVerify(t *testing.T) {
+	signer := &requestSigner{keys: map[string]string{"ops": "s3cret"}, window: 5 * time.Minute}
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		req     *http.Request
+		wantErr string
+	}{
+		{"valid GET", newSignedRequest("GET", "/v1/calls", "", "ops", "s3cret", now, ""), ""},
+		{"valid POST", newSignedRequest("POST", "/v1/calls/originate", `{"aleg":"user/1000"}`, "ops", "s3cret", now, ""), ""},
+		{"inside window", newSignedRequest("GET", "/v1/calls", "", "ops", "s3cret", now.Add(-4*time.Minute), ""), ""},
+		{"clock ahead inside window", newSignedRequest("GET", "/v1/calls", "", "ops", "s3cret", now.Add(4*time.Minute), ""), ""},
+		{"too old", newSignedRequest("GET", "/v1/calls", "", "ops", "s3cret", now.Add(-6*time.Minute), ""), "signing window"},
+		{"too far ahead", newSignedRequest("GET", "/v1/calls", "", "ops", "s3cret", now.Add(6*time.Minute), ""), "signing window"},
+		{"unknown key", newSignedRequest("GET", "/v1/calls", "", "dev", "s3cret", now, ""), "unknown signing key"},
+		{"wrong secret", newSignedRequest("GET", "/v1/calls", "", "ops", "guess", now, ""), "invalid request signature"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyID, err := signer.verify(tt.req)
+			if tt.wantErr == "" {
+				if err != nil || keyID != "ops" {
+					t.Errorf("verify() = %q, %v; want ops, nil", keyID, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("verify() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// A tampered URI or body no longer matches the signature
+	req := newSignedRequest("POST", "/v1/calls/originate", `{"aleg":"user/1000"}`, "ops", "s3cret", now, "")
+	req.Body = io.NopCloser(strings.NewReader(`{"aleg":"user/1001"}`))
+	if _, err := signer.verify(req); err == nil {
+		t.Error("verify() accepted a tampered body")
+	}
+	req = newSignedRequest("DELETE", "/v1/calls/a", "", "ops", "s3cret", now, "")
+	req.URL.Path = "/v1/calls/b"
+	if _, err := signer.verify(req); err == nil {
+		t.Error("verify() accepted a tampered path")
+	}
+
+	// The handler still gets the body after verification
+	req = newSignedRequest("POST", "/v1/calls/originate", `{"aleg":"user/1000"}`, "ops", "s3cret", now, "")
+	if _, err := signer.verify(req); err != nil {
+		t.Fatalf("verify() = %v", err)
+	}
+	if body, _ := io.ReadAll(req.Body); string(body) != `{"aleg":"user/1000"}` {
+		t.Errorf("body after verify = %q", body)
+	}
+}
+
+func TestReplayGuardCheck(t *testing.T) {
+	g := newReplayGuard(time.Minute, newMetricsRegistry())
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		keyID   string
+		nonce   string
+		at      time.Time
+		wantErr string
+	}{
+		{"first use", "ops", "nonce-0000000000000001", now, ""},
+		{"replayed", "ops", "nonce-0000000000000001", now, "already been used"},
+		{"same nonce of another key", "dev", "nonce-0000000000000001", now, ""},
+		{"missing nonce", "ops", "", now, "need an"},
+		{"short nonce", "ops", "abc", now, "16 to 128"},
+		{"bad characters", "ops", "nonce/0000000000000002", now, "16 to 128"},
+		{"too old", "ops", "nonce-0000000000000003", now.Add(-2 * time.Minute), "replay window"},
+		{"too far ahead", "ops", "nonce-0000000000000004", now.Add(2 * time.Minute), "replay window"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := g.check(tt.keyID, tt.nonce, tt.at)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("check() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("check() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReplayGuardPrune(t *testing.T) {
+	g := newReplayGuard(time.Minute, newMetricsRegistry())
+	now := time.Now()
+
+	// One nonce whose timestamp has since left the window, one still in it
+	old := now.Add(-59 * time.Second)
+	if err := g.check("ops", "nonce-old-000000000000", old); err != nil {
+		t.Fatalf("check() = %v", err)
+	}
+	g.seen["ops:nonce-old-000000000000"] = now.Add(-time.Second)
+	if err := g.check("ops", "nonce-live-00000000000", now); err != nil {
+		t.Fatalf("check() = %v", err)
+	}
+
+	// The next prune forgets the expired nonce, whose replay is still
+	// refused by its timestamp, and keeps the one inside the window
+	g.pruned = time.Time{}
+	if err := g.check("ops", "nonce-other-0000000000", now); err != nil {
+		t.Fatalf("check() = %v", err)
+	}
+	if _, ok := g.seen["ops:nonce-old-000000000000"]; ok {
+		t.Error("expired nonce was not pruned")
+	}
+	if err := g.check("ops", "nonce-live-00000000000", now); err == nil || !strings.Contains(err.Error(), "already been used") {
+		t.Errorf("nonce replayed after pruning: check() = %v, want already used", err)
+	}
+	if err := g.check("ops", "nonce-old-000000000000", old.Add(-2*time.Second)); err == nil || !strings.Contains(err.Error(), "replay window") {
+		t.Errorf("pruned nonce replayed: check() = %v, want outside the window", err)
+	}
+}
+
+func TestSignedRequestNonceUsedOnlyWhenValid(t *testing.T) {
+	signer := &requestSigner{keys: map[string]string{"ops": "s3cret"}, window: 5 * time.Minute}
+	signer.replay = newReplayGuard(time.Minute, newMetricsRegistry())
+	now := time.Now()
+	const nonce = "nonce-0123456789abcdef"
+
+	// A forged request does not burn the nonce of the genuine one
+	forged := newSignedRequest("POST", "/v1/calls/originate", "{}", "ops", "guess", now, nonce)
+	if _, err := signer.verify(forged); err == nil || !strings.Contains(err.Error(), "invalid request signature") {
+		t.Fatalf("forged request: verify() = %v, want invalid signature", err)
+	}
+	genuine := newSignedRequest("POST", "/v1/calls/originate", "{}", "ops", "s3cret", now, nonce)
+	if _, err := signer.verify(genuine); err != nil {
+		t.Fatalf("genuine request: verify() = %v", err)
+	}
+	replay := newSignedRequest("POST", "/v1/calls/originate", "{}", "ops", "s3cret", now, nonce)
+	if _, err := signer.verify(replay); err == nil || !strings.Contains(err.Error(), "already been used") {
+		t.Errorf("replayed request: verify() = %v, want already used", err)
+	}
+
+	// Mutating requests need a nonce; reads do not and never use one up
+	if _, err := signer.verify(newSignedRequest("DELETE", "/v1/calls/a", "", "ops", "s3cret", now, "")); err == nil {
+		t.Error("mutating request without a nonce was accepted")
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := signer.verify(newSignedRequest("GET", "/v1/calls", "", "ops", "s3cret", now, nonce)); err != nil {
+			t.Errorf("GET %d: verify() = %v", i+1, err)
+		}
+	}
+}
+
+func TestReplayedRequestIsNotAnAuthFailure(t *testing.T) {
+	signer := &requestSigner{keys: map[string]string{"ops": "s3cret"}, window: 5 * time.Minute}
+	signer.replay = newReplayGuard(time.Minute, newMetricsRegistry())
+	guard := newAuthGuard(2, time.Minute, time.Minute, 0, newMetricsRegistry(), func(*http.Request, AuditEntry) {})
+	handler := bearerAuthMiddleware(authConfig{signer: signer, guard: guard})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	now := time.Now()
+	const nonce = "nonce-0123456789abcdef"
+
+	call := func(nonce string) *httptest.ResponseRecorder {
+		req := newSignedRequest("POST", "/v1/calls/originate", "{}", "ops", "s3cret", now, nonce)
+		req.RemoteAddr = "192.0.2.1:5060"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := call(nonce); rec.Code != http.StatusOK {
+		t.Fatalf("first use: HTTP %d, want 200", rec.Code)
+	}
+	// More replays than the lockout allows failures
+	for i := 0; i < 3; i++ {
+		rec := call(nonce)
+		if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), `"code":"`+ErrCodeReplayedRequest+`"`) {
+			t.Fatalf("replay %d: HTTP %d %s, want 401 with code %s", i+1, rec.Code, rec.Body.String(), ErrCodeReplayedRequest)
+		}
+	}
+	if rec := call("nonce-fedcba9876543210"); rec.Code != http.StatusOK {
+		t.Errorf("fresh nonce after replays: HTTP %d, want 200", rec.Code)
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Domain aliases are written one file per alias into
+// FSAPI_SOFIA_ALIAS_DIR/<profile>/, which the profile's <aliases> section
+// includes:
+//
+//	<X-PRE-PROCESS cmd="include" data="../fsapi-aliases/internal/*.xml"/>
+//
+// After a change fs-api runs reloadxml and rescans the profile.
+
+var sofiaProfileActions = []string{"start", "stop", "restart", "rescan"}
+
+// SofiaProfile is one profile from "sofia status"
+type SofiaProfile struct {
+	Name     string   `json:"name"`
+	URL      string   `json:"url"`
+	State    string   `json:"state"`
+	Aliases  []string `json:"aliases"`
+	Gateways []string `json:"gateways"`
+}
+
+// parseSofiaStatus parses the tab-separated table printed by "sofia status"
+func parseSofiaStatus(response string) []*SofiaProfile {
+	profiles := map[string]*SofiaProfile{}
+	profile := func(name string) *SofiaProfile {
+		p, ok := profiles[name]
+		if !ok {
+			p = &SofiaProfile{Name: name, Aliases: []string{}, Gateways: []string{}}
+			profiles[name] = p
+		}
+		return p
+	}
+
+	for _, line := range strings.Split(response, "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 4 {
+			continue
+		}
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		name, kind, data, state := fields[0], fields[1], fields[2], fields[3]
+		switch kind {
+		case "profile":
+			p := profile(name)
+			p.URL = data
+			p.State = state
+		case "alias":
+			// The alias row's data is the profile it points to
+			p := profile(data)
+			p.Aliases = append(p.Aliases, name)
+		case "gateway":
+			if i := strings.Index(name, "::"); i > 0 {
+				p := profile(name[:i])
+				p.Gateways = append(p.Gateways, name[i+2:])
+			}
+		}
+	}
+
+	list := make([]*SofiaProfile, 0, len(profiles))
+	for _, p := range profiles {
+		// Aliases can point at a profile that is not running
+		if p.URL == "" && p.State == "" {
+			p.State = "NOT RUNNING"
+		}
+		sort.Strings(p.Aliases)
+		sort.Strings(p.Gateways)
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// parseSofiaProfileStatus parses the "key<TAB>value" lines printed by
+// "sofia status profile <name>". Keys are lowercased with spaces and dashes
+// turned into underscores (e.g. "SIP-IP" -> "sip_ip").
+func parseSofiaProfileStatus(response string) map[string]string {
+	settings := map[string]string{}
+	for _, line := range strings.Split(response, "\n") {
+		if strings.HasPrefix(line, "=") {
+			continue
+		}
+		parts := strings.SplitN(line, "\t", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.ToLower(strings.Join(strings.Fields(parts[0]), "_"))
+		key = strings.ReplaceAll(key, "-", "_")
+		if key != "" {
+			settings[key] = strings.TrimSpace(parts[1])
+		}
+	}
+	return settings
+}
+
+// sofiaProfileParam reads and validates the {profile} URL variable
+func (h *APIHandler) sofiaProfileParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	profile := mux.Vars(r)["profile"]
+	if !isValidName(profile) {
+		h.respondError(w, r, "invalid profile name", http.StatusBadRequest)
+		return "", false
+	}
+	return profile, true
+}
+
+// GET /v1/sofia/profiles
+func (h *APIHandler) ListSofiaProfiles(w http.ResponseWriter, r *http.Request) {
+	if !h.requireUnrestricted(w, r) {
+		return
+	}
+
+	response, err := h.eslClient.SendCommand("api sofia status")
+	if err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to retrieve sofia status: %v", err), err)
+		return
+	}
+	rows := parseSofiaStatus(response)
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status":    "success",
+		"row_count": len(rows),
+		"rows":      rows,
+	})
+}
+
+// GET /v1/sofia/profiles/{profile}
+func (h *APIHandler) GetSofiaProfile(w http.ResponseWriter, r *http.Request) {
+	if !h.requireUnrestricted(w, r) {
+		return
+	}
+	profile, ok := h.sofiaProfileParam(w, r)
+	if !ok {
+		return
+	}
+
+	response, err := h.eslClient.SendCommand(fmt.Sprintf("api sofia status profile %s", profile))
+	if err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to retrieve profile %s: %v", profile, err), err)
+		return
+	}
+	// An unknown profile is reported as plain text, not -ERR
+	if strings.Contains(response, "Invalid Profile") {
+		h.respondError(w, r, fmt.Sprintf("Profile %s not found", profile), http.StatusNotFound)
+		return
+	}
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data": map[string]interface{}{
+			"name":     profile,
+			"settings": parseSofiaProfileStatus(response),
+		},
+	})
+}
+
+// POST /v1/sofia/profiles/{profile}/{action}
+func (h *APIHandler) SofiaProfileAction(w http.ResponseWriter, r *http.Request) {
+	if !h.requireUnrestricted(w, r) {
+		return
+	}
+	profile, ok := h.sofiaProfileParam(w, r)
+	if !ok {
+		return
+	}
+	action := mux.Vars(r)["action"]
+	if !containsString(sofiaProfileActions, action) {
+		h.respondError(w, r, fmt.Sprintf("invalid action '%s': must be one of: %s", action, strings.Join(sofiaProfileActions, ", ")), http.StatusBadRequest)
+		return
+	}
+
+	response, err := h.eslClient.SendCommand(fmt.Sprintf("api sofia profile %s %s", profile, action))
+	if err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to %s profile %s: %v", action, profile, err), err)
+		return
+	}
+	if strings.Contains(response, "Invalid Profile") {
+		h.respondError(w, r, fmt.Sprintf("Profile %s not found", profile), http.StatusNotFound)
+		return
+	}
+
+	h.respondSuccess(w, r, fmt.Sprintf("Profile %s %s: %s", profile, action, strings.TrimSpace(response)))
+}
+
+// --- Domain aliases ---
+
+type sofiaAliasXML struct {
+	XMLName xml.Name `xml:"include"`
+	Alias   struct {
+		Name string `xml:"name,attr"`
+	} `xml:"alias"`
+}
+
+// sofiaAliasEnabled writes a 501 when alias provisioning is disabled
+func (h *APIHandler) sofiaAliasEnabled(w http.ResponseWriter, r *http.Request) bool {
+	if FSAPI_SOFIA_ALIAS_DIR == "" {
+		h.respondError(w, r, "Alias provisioning is disabled (FSAPI_SOFIA_ALIAS_DIR is not set)", http.StatusNotImplemented)
+		return false
+	}
+	return true
+}
+
+// aliasActive reports whether "sofia status" lists domain as an alias of
+// profile
+func (h *APIHandler) aliasActive(profile, domain string) bool {
+	response, err := h.eslClient.SendCommand("api sofia status")
+	if err != nil {
+		return false
+	}
+	for _, p := range parseSofiaStatus(response) {
+		if p.Name == profile {
+			return containsString(p.Aliases, domain)
+		}
+	}
+	return false
+}
+
+// applyAliases reloads the XML registry and rescans the profile
+func (h *APIHandler) applyAliases(profile string) error {
+	if _, err := h.eslClient.SendCommand("api reloadxml"); err != nil {
+		return err
+	}
+	_, err := h.eslClient.SendCommand(fmt.Sprintf("api sofia profile %s rescan", profile))
+	return err
+}
+
+// GET /v1/sofia/profiles/{profile}/aliases
+func (h *APIHandler) ListSofiaAliases(w http.ResponseWriter, r *http.Request) {
+	if !h.requireUnrestricted(w, r) || !h.sofiaAliasEnabled(w, r) {
+		return
+	}
+	profile, ok := h.sofiaProfileParam(w, r)
+	if !ok {
+		return
+	}
+
+	files, err := filepath.Glob(filepath.Join(FSAPI_SOFIA_ALIAS_DIR, profile, "*.xml"))
+	if err != nil {
+		h.respondError(w, r, fmt.Sprintf("Failed to list aliases: %v", err), http.StatusInternalServerError)
+		return
+	}
+	rows := make([]string, 0, len(files))
+	for _, file := range files {
+		rows = append(rows, strings.TrimSuffix(filepath.Base(file), ".xml"))
+	}
+	sort.Strings(rows)
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status":    "success",
+		"row_count": len(rows),
+		"rows":      rows,
+	})
+}
+
+// POST /v1/sofia/profiles/{profile}/aliases
+func (h *APIHandler) AddSofiaAlias(w http.ResponseWriter, r *http.Request) {
+	if !h.requireUnrestricted(w, r) || !h.sofiaAliasEnabled(w, r) {
+		return
+	}
+	profile, ok := h.sofiaProfileParam(w, r)
+	if !ok {
+		return
+	}
+
+	var req SofiaAliasRequest
+	if !h.decodeRequest(w, r, &req) {
+		return
+	}
+	if !domainPattern.MatchString(req.Domain) {
+		h.respondError(w, r, "domain must be a valid domain name", http.StatusBadRequest)
+		return
+	}
+
+	var file sofiaAliasXML
+	file.Alias.Name = req.Domain
+	data, err := xml.MarshalIndent(file, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Join(FSAPI_SOFIA_ALIAS_DIR, profile), 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(filepath.Join(FSAPI_SOFIA_ALIAS_DIR, profile, req.Domain+".xml"), append(data, '\n'), 0644)
+	}
+	if err != nil {
+		h.respondError(w, r, fmt.Sprintf("Failed to write alias: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := h.applyAliases(profile); err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Alias written but profile rescan failed: %v", err), err)
+		return
+	}
+
+	// Some FreeSWITCH versions only read aliases when a profile starts
+	active := h.aliasActive(profile, req.Domain)
+	logInfo(getRequestID(r), fmt.Sprintf("Alias %s added to profile %s (active: %t)", req.Domain, profile, active))
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data": map[string]interface{}{
+			"profile": profile,
+			"domain":  req.Domain,
+			"active":  active,
+		},
+	})
+}
+
+// DELETE /v1/sofia/profiles/{profile}/aliases/{domain}
+func (h *APIHandler) DeleteSofiaAlias(w http.ResponseWriter, r *http.Request) {
+	if !h.requireUnrestricted(w, r) || !h.sofiaAliasEnabled(w, r) {
+		return
+	}
+	profile, ok := h.sofiaProfileParam(w, r)
+	if !ok {
+		return
+	}
+	domain := mux.Vars(r)["domain"]
+	if !domainPattern.MatchString(domain) {
+		h.respondError(w, r, "domain must be a valid domain name", http.StatusBadRequest)
+		return
+	}
+
+	err := os.Remove(filepath.Join(FSAPI_SOFIA_ALIAS_DIR, profile, domain+".xml"))
+	if errors.Is(err, os.ErrNotExist) {
+		h.respondError(w, r, fmt.Sprintf("Alias %s of profile %s not found", domain, profile), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.respondError(w, r, fmt.Sprintf("Failed to remove alias: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := h.applyAliases(profile); err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Alias removed but profile rescan failed: %v", err), err)
+		return
+	}
+
+	h.respondSuccess(w, r, fmt.Sprintf("Alias %s removed from profile %s", domain, profile))
+}
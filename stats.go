@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// DomainStats is the live snapshot returned by GET /v1/stats/domains/{domain}
+type DomainStats struct {
+	Domain             string               `json:"domain"`
+	ActiveCalls        int                  `json:"active_calls"`
+	CallsToday         int                  `json:"calls_today"`
+	AnsweredToday      int                  `json:"answered_today"`
+	AverageDurationSec float64              `json:"average_duration_sec"`
+	Callcenter         *CallcenterOccupancy `json:"callcenter"`
+	GeneratedAt        time.Time            `json:"generated_at"`
+}
+
+// CallcenterOccupancy summarizes a domain's agents. Occupancy is the share of
+// logged-in agents currently on a queue call.
+type CallcenterOccupancy struct {
+	Agents    int     `json:"agents"`
+	LoggedIn  int     `json:"logged_in"`
+	Available int     `json:"available"`
+	OnCall    int     `json:"on_call"`
+	Occupancy float64 `json:"occupancy"`
+}
+
+// countActiveCalls returns the number of active calls in domain
+func (h *APIHandler) countActiveCalls(domain string) (int, error) {
+	callsResponse, err := h.eslClient.SendCommand("api show calls as json")
+	if err != nil {
+		return 0, err
+	}
+	var callsData struct {
+		Rows []map[string]interface{} `json:"rows"`
+	}
+	if err := json.Unmarshal([]byte(callsResponse), &callsData); err != nil {
+		return 0, fmt.Errorf("failed to parse calls data: %v", err)
+	}
+
+	contextMap := h.channelContextMap()
+	count := 0
+	for _, call := range callsData.Rows {
+		if resolveCallContext(call, contextMap) == domain {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// callcenterOccupancy reports agent occupancy for domain, matching agents by
+// the domain_name in their contact string
+func (h *APIHandler) callcenterOccupancy(domain string) (*CallcenterOccupancy, error) {
+	response, err := h.sendCCCommand("agent list")
+	if err != nil {
+		return nil, err
+	}
+
+	occ := &CallcenterOccupancy{}
+	for _, agent := range filterAgentsByDomain(ParsePipeDelimited(response), []string{domain}) {
+		occ.Agents++
+		if agent["status"] == "Logged Out" {
+			continue
+		}
+		occ.LoggedIn++
+		if agent["status"] == "Available" || agent["status"] == "Available (On Demand)" {
+			occ.Available++
+		}
+		if agent["state"] == "In a queue call" {
+			occ.OnCall++
+		}
+	}
+	if occ.LoggedIn > 0 {
+		occ.Occupancy = math.Round(float64(occ.OnCall)/float64(occ.LoggedIn)*1000) / 1000
+	}
+	return occ, nil
+}
+
+// GetDomainStats handles GET /v1/stats/domains/{domain}
+func (h *APIHandler) GetDomainStats(w http.ResponseWriter, r *http.Request) {
+	domain := mux.Vars(r)["domain"]
+	requestID := getRequestID(r)
+
+	if !isContextAllowed(r, domain) {
+		h.respondError(w, r,
+			fmt.Sprintf("Domain '%s' is not in your allowed contexts", domain),
+			http.StatusForbidden)
+		return
+	}
+
+	active, err := h.countActiveCalls(domain)
+	if err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to retrieve calls: %v", err), err)
+		return
+	}
+
+	stats := DomainStats{
+		Domain:      domain,
+		ActiveCalls: active,
+		GeneratedAt: time.Now().UTC(),
+	}
+
+	// Today's totals come from stored CDRs. Both legs of a bridged call
+	// produce a CDR; count each pair once.
+	now := time.Now()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	rows := h.cdrs.query(midnight, func(rec *CDR) bool { return rec.Context == domain })
+	seen := make(map[string]bool, len(rows))
+	for _, rec := range rows {
+		seen[rec.UUID] = true
+	}
+	billsec := 0
+	for _, rec := range rows {
+		if seen[rec.OtherLegUUID] && rec.OtherLegUUID < rec.UUID {
+			continue
+		}
+		stats.CallsToday++
+		if rec.AnswerTime != nil {
+			stats.AnsweredToday++
+			billsec += rec.BillSec
+		}
+	}
+	if stats.AnsweredToday > 0 {
+		stats.AverageDurationSec = math.Round(float64(billsec)/float64(stats.AnsweredToday)*10) / 10
+	}
+
+	// mod_callcenter is optional; leave occupancy out when it is unavailable
+	if occ, err := h.callcenterOccupancy(domain); err != nil {
+		logWarn(requestID, fmt.Sprintf("Callcenter occupancy unavailable for %s: %v", domain, err))
+	} else {
+		stats.Callcenter = occ
+	}
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   stats,
+	})
+}
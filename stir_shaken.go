@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// Channel variable recording the attestation an originate asked for, so
+	// it shows up in CDRs next to the call
+	stirVarAttestation = "fsapi_stir_attestation"
+
+	maxIdentityLength = 4096
+)
+
+var (
+	// Identity header values go inside originate {var=value} blocks, so
+	// separators, quotes, whitespace and variable expansion are not allowed
+	identityHeaderPattern = regexp.MustCompile(`^[A-Za-z0-9._~+/=;:<>@?&%-]+$`)
+	sipHeaderNamePattern  = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9-]{0,63}$`)
+	verstatPattern        = regexp.MustCompile(`(?i)verstat=([A-Za-z-]+)`)
+)
+
+// StirShakenInfo carries STIR/SHAKEN data for an outbound call. identity is
+// sent as the Identity header for carriers that take calls signed upstream;
+// attestation asks the signing service behind FSAPI_STIR_ATTESTATION_HEADER
+// for a level.
+type StirShakenInfo struct {
+	Attestation string `json:"attestation,omitempty"` // A, B or C
+	Identity    string `json:"identity,omitempty"`    // Full Identity header value: PASSporT;info=<url>;alg=ES256;ppt=shaken
+}
+
+// StirShakenStatus is what a call's A-leg says about its verification, from
+// the verstat parameter and the PASSporT of an inbound Identity header
+type StirShakenStatus struct {
+	Verstat     string `json:"verstat,omitempty"`     // e.g. TN-Validation-Passed
+	Attestation string `json:"attestation,omitempty"` // attest claim of the PASSporT, or the attestation header
+	OrigID      string `json:"origid,omitempty"`      // origid claim of the PASSporT
+	Identity    bool   `json:"identity"`              // Whether the call carried an Identity header
+}
+
+// validateStirShaken checks the stir_shaken object of an originate
+func validateStirShaken(s *StirShakenInfo, callerIDNumber string) error {
+	if s == nil {
+		return nil
+	}
+	if s.Attestation == "" && s.Identity == "" {
+		return fmt.Errorf("stir_shaken needs attestation or identity")
+	}
+	if s.Attestation != "" {
+		switch s.Attestation {
+		case "A", "B", "C":
+		default:
+			return fmt.Errorf("stir_shaken.attestation must be A, B or C")
+		}
+		// Attestation is a claim about the calling number
+		if callerIDNumber == "" {
+			return fmt.Errorf("stir_shaken.attestation requires caller_id_number")
+		}
+	}
+	if s.Identity != "" {
+		if len(s.Identity) > maxIdentityLength || !identityHeaderPattern.MatchString(s.Identity) {
+			return fmt.Errorf("stir_shaken.identity is longer than %d characters or contains invalid characters", maxIdentityLength)
+		}
+		if _, err := passportClaims(s.Identity); err != nil {
+			return fmt.Errorf("stir_shaken.identity: %v", err)
+		}
+	}
+	return nil
+}
+
+// stirShakenVars returns the originate channel variables for s as name/value
+// pairs
+func stirShakenVars(s *StirShakenInfo) [][2]string {
+	if s == nil {
+		return nil
+	}
+	var vars [][2]string
+	if s.Identity != "" {
+		vars = append(vars, [2]string{"sip_h_Identity", s.Identity})
+	}
+	if s.Attestation != "" {
+		vars = append(vars, [2]string{stirVarAttestation, s.Attestation})
+		if FSAPI_STIR_ATTESTATION_HEADER != "" {
+			vars = append(vars, [2]string{"sip_h_" + FSAPI_STIR_ATTESTATION_HEADER, s.Attestation})
+		}
+	}
+	return vars
+}
+
+// passportClaims decodes the claims of the PASSporT at the start of an
+// Identity header. The signature is not verified; that is the job of the
+// verification service that sets verstat.
+func passportClaims(identity string) (map[string]interface{}, error) {
+	token, _, _ := strings.Cut(identity, ";")
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("does not start with a PASSporT (header.claims.signature)")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("PASSporT claims are not base64url")
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("PASSporT claims are not JSON")
+	}
+	return claims, nil
+}
+
+// stirShakenFromDump reads an inbound leg's STIR/SHAKEN status from its
+// uuid_dump, or nil when the call carries none
+func stirShakenFromDump(dump map[string]interface{}) *StirShakenStatus {
+	get := func(name string) string {
+		v, _ := dump["variable_"+name].(string)
+		return v
+	}
+	status := &StirShakenStatus{Verstat: get("sip_verstat")}
+	if status.Verstat == "" {
+		// verstat is a parameter of the P-Asserted-Identity or From URI
+		for _, name := range []string{"sip_P-Asserted-Identity", "sip_from_params", "sip_full_from"} {
+			if m := verstatPattern.FindStringSubmatch(get(name)); m != nil {
+				status.Verstat = m[1]
+				break
+			}
+		}
+	}
+
+	identity := get("sip_identity")
+	if identity == "" {
+		identity = get("sip_h_Identity")
+	}
+	if identity != "" {
+		status.Identity = true
+		if claims, err := passportClaims(identity); err == nil {
+			status.Attestation, _ = claims["attest"].(string)
+			status.OrigID, _ = claims["origid"].(string)
+		}
+	}
+	if status.Attestation == "" && FSAPI_STIR_ATTESTATION_HEADER != "" {
+		status.Attestation = get("sip_h_" + FSAPI_STIR_ATTESTATION_HEADER)
+	}
+
+	if status.Verstat == "" && status.Attestation == "" && !status.Identity {
+		return nil
+	}
+	return status
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Storage drivers selectable with FSAPI_STORE
+const (
+	storeSQLite   = "sqlite"
+	storePostgres = "postgres"
+	storeFile     = "file"
+)
+
+var storeDrivers = []string{storeSQLite, storePostgres, storeFile}
+
+// Store holds fs-api's persisted state: documents, replaced as a whole on
+// every save (webhooks, DIDs, rules), and logs, appended to one record at a
+// time (audit, CDRs, deliveries). Names are the file names of the file
+// driver, e.g. "dids.json" and "cdrs.jsonl".
+type Store interface {
+	// Load returns the document name, or nil when there is none
+	Load(name string) ([]byte, error)
+	Save(name string, data []byte) error
+	Remove(name string) error
+
+	// Append adds a record to the log name
+	Append(name string, record []byte) error
+	// Records calls fn for every record of the log name, oldest first
+	Records(name string, fn func(record []byte)) error
+	// Rewrite replaces the log name with records
+	Rewrite(name string, records [][]byte) error
+
+	Driver() string
+	Close() error
+}
+
+// dataStore is the store persist.go reads and writes; main replaces it with
+// the one FSAPI_STORE selects before anything is loaded
+var dataStore Store = fileStore{}
+
+// openStore opens the FSAPI_STORE driver and brings its schema up to date
+func openStore(driver, dsn string) (Store, error) {
+	switch driver {
+	case storeFile:
+		return fileStore{}, nil
+	case storeSQLite:
+		if dsn == "" {
+			dsn = filepath.Join(FSAPI_DATA_DIR, "fs-api.db")
+		}
+		return openSQLStore(sqliteDialect, dsn)
+	case storePostgres:
+		if dsn == "" {
+			return nil, fmt.Errorf("FSAPI_STORE_DSN is required for postgres")
+		}
+		return openSQLStore(postgresDialect, dsn)
+	default:
+		return nil, fmt.Errorf("unknown driver %q (expected one of %v)", driver, storeDrivers)
+	}
+}
+
+// fileStore keeps every document and log in its own file under
+// FSAPI_DATA_DIR, as fs-api did before it had a database
+type fileStore struct{}
+
+func (fileStore) Driver() string { return storeFile }
+func (fileStore) Close() error   { return nil }
+
+func (fileStore) Load(name string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(FSAPI_DATA_DIR, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// Save writes the document atomically
+func (fileStore) Save(name string, data []byte) error {
+	return writeDataFile(name, data)
+}
+
+func (fileStore) Remove(name string) error {
+	err := os.Remove(filepath.Join(FSAPI_DATA_DIR, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Append writes the record as one line
+func (fileStore) Append(name string, record []byte) error {
+	if err := os.MkdirAll(FSAPI_DATA_DIR, 0750); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(FSAPI_DATA_DIR, name), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(record, '\n'))
+	return err
+}
+
+// Records skips empty lines; a missing file is an empty log
+func (fileStore) Records(name string, fn func(record []byte)) error {
+	return readDataLines(filepath.Join(FSAPI_DATA_DIR, name), fn)
+}
+
+// Rewrite replaces the file atomically
+func (fileStore) Rewrite(name string, records [][]byte) error {
+	var data []byte
+	for _, record := range records {
+		data = append(append(data, record...), '\n')
+	}
+	return writeDataFile(name, data)
+}
+
+// writeDataFile atomically writes FSAPI_DATA_DIR/name
+func writeDataFile(name string, data []byte) error {
+	if err := os.MkdirAll(FSAPI_DATA_DIR, 0750); err != nil {
+		return err
+	}
+	path := filepath.Join(FSAPI_DATA_DIR, name)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readDataLines calls fn for every non-empty line of path. A missing file
+// has no lines.
+func readDataLines(path string, fn func(line []byte)) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		if line := scanner.Bytes(); len(line) > 0 {
+			fn(line)
+		}
+	}
+	return scanner.Err()
+}
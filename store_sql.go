@@ -0,0 +1,280 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+// sqlDialect is what differs between the SQL drivers
+type sqlDialect struct {
+	driver    string // FSAPI_STORE value
+	sqlDriver string // database/sql driver name
+	serial    string // Auto-increment primary key column
+	numbered  bool   // Placeholders are $1, $2... instead of ?
+	lock      string // Serializes migrations across instances sharing the database
+}
+
+var (
+	sqliteDialect = &sqlDialect{
+		driver:    storeSQLite,
+		sqlDriver: "sqlite",
+		serial:    "INTEGER PRIMARY KEY AUTOINCREMENT",
+	}
+	postgresDialect = &sqlDialect{
+		driver:    storePostgres,
+		sqlDriver: "pgx",
+		serial:    "BIGSERIAL PRIMARY KEY",
+		numbered:  true,
+		lock:      "SELECT pg_advisory_xact_lock(37274)",
+	}
+)
+
+// storeMigration is one step of the schema, applied once per database in
+// version order
+type storeMigration struct {
+	version int
+	name    string
+	up      func(s *sqlStore, tx *sql.Tx) error
+}
+
+// Append only: a released migration is never changed, a new one is added
+var storeMigrations = []storeMigration{
+	{1, "documents and records", func(s *sqlStore, tx *sql.Tx) error {
+		for _, stmt := range []string{
+			`CREATE TABLE documents (name TEXT PRIMARY KEY, data TEXT NOT NULL, updated_at TEXT NOT NULL)`,
+			`CREATE TABLE records (id ` + s.dialect.serial + `, log TEXT NOT NULL, data TEXT NOT NULL, created_at TEXT NOT NULL)`,
+			`CREATE INDEX records_log ON records (log, id)`,
+		} {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{2, "import files from FSAPI_DATA_DIR", importDataFiles},
+}
+
+// sqlStore keeps documents and log records in two tables of a SQLite or
+// Postgres database
+type sqlStore struct {
+	db      *sql.DB
+	dialect *sqlDialect
+}
+
+// openSQLStore connects and applies the migrations the database lacks
+func openSQLStore(dialect *sqlDialect, dsn string) (*sqlStore, error) {
+	if dialect == sqliteDialect && !strings.HasPrefix(dsn, "file:") {
+		if err := os.MkdirAll(filepath.Dir(dsn), 0750); err != nil {
+			return nil, err
+		}
+		dsn = "file:" + dsn + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	}
+	db, err := sql.Open(dialect.sqlDriver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if dialect == sqliteDialect {
+		// One writer at a time; queueing here beats SQLITE_BUSY
+		db.SetMaxOpenConns(1)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := &sqlStore{db: db, dialect: dialect}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *sqlStore) Driver() string { return s.dialect.driver }
+func (s *sqlStore) Close() error   { return s.db.Close() }
+
+// q rewrites the ? placeholders of query for the dialect
+func (s *sqlStore) q(query string) string {
+	if !s.dialect.numbered {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// migrate applies the migrations in order, each in its own transaction
+func (s *sqlStore) migrate() error {
+	if _, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY, name TEXT NOT NULL, applied_at TEXT NOT NULL)`); err != nil {
+		return err
+	}
+	for _, m := range storeMigrations {
+		if err := s.apply(m); err != nil {
+			return fmt.Errorf("migration %d (%s): %v", m.version, m.name, err)
+		}
+	}
+	return nil
+}
+
+// apply runs m unless the database already has it
+func (s *sqlStore) apply(m storeMigration) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if s.dialect.lock != "" {
+		if _, err := tx.Exec(s.dialect.lock); err != nil {
+			return err
+		}
+	}
+
+	var applied int
+	if err := tx.QueryRow(s.q(`SELECT COUNT(*) FROM schema_migrations WHERE version = ?`), m.version).Scan(&applied); err != nil {
+		return err
+	}
+	if applied > 0 {
+		return nil
+	}
+	if err := m.up(s, tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(s.q(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`),
+		m.version, m.name, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Applied %s store migration %d: %s", s.dialect.driver, m.version, m.name)
+	return nil
+}
+
+// importDataFiles copies the state files of the file driver into the
+// database, so that switching drivers keeps webhooks, DIDs, the audit log
+// and the rest. The files are left in place.
+func importDataFiles(s *sqlStore, tx *sql.Tx) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	docs, _ := filepath.Glob(filepath.Join(FSAPI_DATA_DIR, "*.json"))
+	for _, path := range docs {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(s.q(`INSERT INTO documents (name, data, updated_at) VALUES (?, ?, ?)`),
+			filepath.Base(path), string(data), now); err != nil {
+			return err
+		}
+	}
+
+	logs, _ := filepath.Glob(filepath.Join(FSAPI_DATA_DIR, "*.jsonl"))
+	records := 0
+	for _, path := range logs {
+		var insertErr error
+		err := readDataLines(path, func(line []byte) {
+			if insertErr == nil {
+				_, insertErr = tx.Exec(s.q(`INSERT INTO records (log, data, created_at) VALUES (?, ?, ?)`),
+					filepath.Base(path), string(line), now)
+				records++
+			}
+		})
+		if err == nil {
+			err = insertErr
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", filepath.Base(path), err)
+		}
+	}
+	if len(docs)+len(logs) > 0 {
+		log.Printf("Imported %d documents and %d log records from %s", len(docs), records, FSAPI_DATA_DIR)
+	}
+	return nil
+}
+
+func (s *sqlStore) Load(name string) ([]byte, error) {
+	var data string
+	err := s.db.QueryRow(s.q(`SELECT data FROM documents WHERE name = ?`), name).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []byte(data), nil
+}
+
+func (s *sqlStore) Save(name string, data []byte) error {
+	_, err := s.db.Exec(s.q(`INSERT INTO documents (name, data, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET data = excluded.data, updated_at = excluded.updated_at`),
+		name, string(data), time.Now().UTC().Format(time.RFC3339Nano))
+	return err
+}
+
+func (s *sqlStore) Remove(name string) error {
+	_, err := s.db.Exec(s.q(`DELETE FROM documents WHERE name = ?`), name)
+	return err
+}
+
+func (s *sqlStore) Append(name string, record []byte) error {
+	_, err := s.db.Exec(s.q(`INSERT INTO records (log, data, created_at) VALUES (?, ?, ?)`),
+		name, string(record), time.Now().UTC().Format(time.RFC3339Nano))
+	return err
+}
+
+func (s *sqlStore) Records(name string, fn func(record []byte)) error {
+	rows, err := s.db.Query(s.q(`SELECT data FROM records WHERE log = ? ORDER BY id`), name)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return err
+		}
+		fn([]byte(data))
+	}
+	return rows.Err()
+}
+
+// Rewrite replaces the records in one transaction
+func (s *sqlStore) Rewrite(name string, records [][]byte) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(s.q(`DELETE FROM records WHERE log = ?`), name); err != nil {
+		return err
+	}
+	insert, err := tx.Prepare(s.q(`INSERT INTO records (log, data, created_at) VALUES (?, ?, ?)`))
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	for _, record := range records {
+		if _, err := insert.Exec(name, string(record), now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
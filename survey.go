@@ -0,0 +1,462 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const (
+	surveysFile = "surveys.json"
+
+	// Ended surveys kept; the oldest are dropped
+	surveyMaxKept = 10000
+
+	surveyMaxQuestions    = 10
+	surveyDefaultDigits   = "12345"
+	surveyDefaultTimeout  = 10
+	surveyMaxTimeoutSec   = 60
+	surveyMaxAnswers      = 20
+	surveyMaxAnswerLen    = 64
+	surveyAnswerVarPrefix = "fsapi_survey_" // Channel variables an IVR sets per answer
+	surveyIDVar           = "fsapi_survey"  // Set on the call while a survey is armed
+)
+
+// Survey statuses
+const (
+	surveyStatusArmed      = "armed"       // Waiting for the agent to hang up
+	surveyStatusRunning    = "running"     // The customer is in the survey
+	surveyStatusCompleted  = "completed"   // Every question answered (destination: any answer)
+	surveyStatusPartial    = "partial"     // Some questions answered
+	surveyStatusNoResponse = "no_response" // Nothing answered
+	surveyStatusSkipped    = "skipped"     // The customer hung up first
+	surveyStatusFailed     = "failed"      // The survey could not start, or fs-api shut down
+)
+
+// SurveyPrompt is a file or text-to-speech played to the customer
+type SurveyPrompt struct {
+	File string       `json:"file,omitempty"` // Absolute path or local_stream://<name>
+	TTS  *AnnounceTTS `json:"tts,omitempty"`
+}
+
+// SurveyQuestion is a question fs-api asks, answered with one digit
+type SurveyQuestion struct {
+	Name       string       `json:"name"` // Key of the answer
+	File       string       `json:"file,omitempty"`
+	TTS        *AnnounceTTS `json:"tts,omitempty"`
+	Digits     string       `json:"digits,omitempty"`      // Accepted; default 12345
+	TimeoutSec int          `json:"timeout_sec,omitempty"` // To answer; default 10
+}
+
+// SurveyRequest is the body of POST /v1/calls/{uuid}/survey
+type SurveyRequest struct {
+	Name        string           `json:"name,omitempty"`        // Label to find the responses by, e.g. csat
+	Destination string           `json:"destination,omitempty"` // Survey IVR extension in the call's context, or
+	Questions   []SurveyQuestion `json:"questions,omitempty"`   // questions fs-api asks
+	Closing     *SurveyPrompt    `json:"closing,omitempty"`     // Played after the questions
+}
+
+// Survey is a survey armed on a call, and its responses
+type Survey struct {
+	ID          string            `json:"id"`
+	Context     string            `json:"context"`
+	CallUUID    string            `json:"call_uuid"`
+	Name        string            `json:"name,omitempty"`
+	Destination string            `json:"destination,omitempty"`
+	Questions   []string          `json:"questions,omitempty"` // Names of the questions asked
+	Status      string            `json:"status"`
+	Agent       string            `json:"agent,omitempty"`      // cc_agent of the call, when it came through a queue
+	AgentUUID   string            `json:"agent_uuid,omitempty"` // Leg that hung up and started the survey
+	FlowID      string            `json:"flow_id,omitempty"`    // Flow asking the questions
+	Answers     map[string]string `json:"answers"`
+	Error       string            `json:"error,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	StartedAt   *time.Time        `json:"started_at,omitempty"`
+	EndedAt     *time.Time        `json:"ended_at,omitempty"`
+
+	req       SurveyRequest
+	parked    bool // The customer leg is parked
+	agentGone bool // The leg it was bridged to hung up
+}
+
+// snapshot returns a copy of the survey. Caller must hold surveys.mu.
+func (s *Survey) snapshot() Survey {
+	survey := *s
+	survey.Answers = make(map[string]string, len(s.Answers))
+	for k, v := range s.Answers {
+		survey.Answers[k] = v
+	}
+	return survey
+}
+
+// steps builds the flow asking the questions. Each question that is not
+// answered moves on to the next one.
+func (req *SurveyRequest) steps() ([]FlowStep, error) {
+	var steps []FlowStep
+	for i, q := range req.Questions {
+		step := FlowStep{Action: flowActionCollect, File: q.File, TTS: q.TTS, Digits: q.Digits, TimeoutSec: q.TimeoutSec, OnFailure: flowOnFailureContinue}
+		if step.Digits == "" {
+			step.Digits = surveyDefaultDigits
+		}
+		if step.TimeoutSec == 0 {
+			step.TimeoutSec = surveyDefaultTimeout
+		}
+		if step.TimeoutSec < 0 || step.TimeoutSec > surveyMaxTimeoutSec {
+			return nil, fmt.Errorf("questions[%d]: timeout_sec must be between 1 and %d", i, surveyMaxTimeoutSec)
+		}
+		if (q.File == "") == (q.TTS == nil) {
+			return nil, fmt.Errorf("questions[%d]: exactly one of file or tts is required", i)
+		}
+		if err := step.validate(); err != nil {
+			return nil, fmt.Errorf("questions[%d]: %v", i, err)
+		}
+		steps = append(steps, step)
+	}
+	if c := req.Closing; c != nil {
+		if (c.File == "") == (c.TTS == nil) {
+			return nil, fmt.Errorf("closing: exactly one of file or tts is required")
+		}
+		step := FlowStep{Action: flowActionPlayback, File: c.File, OnFailure: flowOnFailureContinue}
+		if c.TTS != nil {
+			step = FlowStep{Action: flowActionSpeak, TTS: c.TTS, OnFailure: flowOnFailureContinue}
+		}
+		if err := step.validate(); err != nil {
+			return nil, fmt.Errorf("closing: %v", err)
+		}
+		steps = append(steps, step)
+	}
+	return append(steps, FlowStep{Action: flowActionHangup}), nil
+}
+
+// validate checks the request
+func (req *SurveyRequest) validate() error {
+	if req.Name != "" && !flowTemplateNamePattern.MatchString(req.Name) {
+		return fmt.Errorf("name may only contain letters, digits, '_' and '-' (at most 64)")
+	}
+	if (req.Destination == "") == (len(req.Questions) == 0) {
+		return fmt.Errorf("exactly one of destination or questions is required")
+	}
+	if req.Destination != "" {
+		if !flowDestinationPattern.MatchString(req.Destination) {
+			return fmt.Errorf("destination must be an extension")
+		}
+		if req.Closing != nil {
+			return fmt.Errorf("closing is only used with questions")
+		}
+		return nil
+	}
+	if len(req.Questions) > surveyMaxQuestions {
+		return fmt.Errorf("at most %d questions", surveyMaxQuestions)
+	}
+	seen := map[string]bool{}
+	for i, q := range req.Questions {
+		if !flowTemplateParamPattern.MatchString(q.Name) {
+			return fmt.Errorf("questions[%d]: name must be lowercase letters, digits and '_', starting with a letter", i)
+		}
+		if seen[q.Name] {
+			return fmt.Errorf("questions[%d]: duplicate name %s", i, q.Name)
+		}
+		seen[q.Name] = true
+	}
+	_, err := req.steps()
+	return err
+}
+
+// surveyManager keeps surveys armed on calls, starts them when the agent
+// hangs up, and stores their responses
+type surveyManager struct {
+	h  *APIHandler
+	mu sync.Mutex
+
+	surveys map[string]*Survey
+	byCall  map[string]*Survey // Armed and running surveys by lowercase call UUID
+	ended   *counterVec
+}
+
+func newSurveyManager(h *APIHandler) *surveyManager {
+	m := &surveyManager{
+		h:       h,
+		surveys: make(map[string]*Survey),
+		byCall:  make(map[string]*Survey),
+		ended:   h.metrics.counter("fsapi_surveys_total", "Surveys that ended, by status.", "status"),
+	}
+	var surveys []*Survey
+	if err := loadJSONFile(surveysFile, &surveys); err != nil {
+		log.Printf("WARNING: Failed to load surveys: %v", err)
+	}
+	for _, s := range surveys {
+		m.surveys[s.ID] = s
+	}
+	return m
+}
+
+// save persists the ended surveys, dropping the oldest beyond
+// surveyMaxKept. Caller must hold mu.
+func (m *surveyManager) save() error {
+	var ended []*Survey
+	for _, s := range m.surveys {
+		if s.EndedAt != nil {
+			ended = append(ended, s)
+		}
+	}
+	sort.Slice(ended, func(i, j int) bool { return ended[i].EndedAt.Before(*ended[j].EndedAt) })
+	if len(ended) > surveyMaxKept {
+		for _, s := range ended[:len(ended)-surveyMaxKept] {
+			delete(m.surveys, s.ID)
+		}
+		ended = ended[len(ended)-surveyMaxKept:]
+	}
+	return saveJSONFile(surveysFile, ended)
+}
+
+// end records how s ended. Caller must hold mu.
+func (m *surveyManager) end(s *Survey, status, errMsg string) {
+	now := time.Now().UTC()
+	s.Status, s.Error, s.EndedAt = status, errMsg, &now
+	delete(m.byCall, strings.ToLower(s.CallUUID))
+	if err := m.save(); err != nil {
+		log.Printf("WARNING: Failed to save surveys: %v", err)
+	}
+	m.ended.inc(status)
+	log.Printf("Survey %s on call %s %s", s.ID, s.CallUUID, status)
+	m.h.webhooks.dispatch("survey."+status, s.Context, s.snapshot())
+}
+
+// handleEvent follows the calls with an armed survey: the survey starts
+// once the agent has hung up and the customer leg is parked after the
+// bridge, in either order, and is skipped when the customer hangs up first
+func (m *surveyManager) handleEvent(ev *Event) {
+	switch ev.Name {
+	case "CHANNEL_PARK", "CHANNEL_UNPARK", "CHANNEL_HANGUP", "CHANNEL_HANGUP_COMPLETE":
+	default:
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	callUUID := strings.ToLower(ev.UUID())
+	s, ok := m.byCall[callUUID]
+	if !ok {
+		// The agent leg hanging up
+		s, ok = m.byCall[strings.ToLower(ev.Get("Other-Leg-Unique-ID"))]
+		if ok && s.Status == surveyStatusArmed && strings.HasPrefix(ev.Name, "CHANNEL_HANGUP") && !s.agentGone {
+			s.agentGone, s.AgentUUID = true, ev.UUID()
+			if s.parked {
+				m.start(s)
+			}
+		}
+		return
+	}
+	switch {
+	case ev.Name == "CHANNEL_PARK" && s.Status == surveyStatusArmed:
+		s.parked = true
+		if agent := ev.Var("cc_agent"); agent != "" {
+			s.Agent = agent
+		}
+		if s.agentGone {
+			m.start(s)
+		}
+	case ev.Name == "CHANNEL_UNPARK":
+		s.parked = false
+	case ev.Name == "CHANNEL_HANGUP_COMPLETE" && s.Status == surveyStatusArmed:
+		m.end(s, surveyStatusSkipped, "")
+	case ev.Name == "CHANNEL_HANGUP_COMPLETE" && s.Status == surveyStatusRunning && s.Destination != "":
+		// The IVR leaves its answers in fsapi_survey_<name> variables
+		for k, v := range ev.Headers {
+			name, ok := strings.CutPrefix(k, "variable_"+surveyAnswerVarPrefix)
+			if !ok || name == "" || v == "" || len(s.Answers) >= surveyMaxAnswers {
+				continue
+			}
+			if len(v) > surveyMaxAnswerLen {
+				v = v[:surveyMaxAnswerLen]
+			}
+			s.Answers[name] = v
+		}
+		status := surveyStatusCompleted
+		if len(s.Answers) == 0 {
+			status = surveyStatusNoResponse
+		}
+		m.end(s, status, "")
+	}
+}
+
+// start sends the parked customer into the survey. Caller must hold mu.
+func (m *surveyManager) start(s *Survey) {
+	now := time.Now().UTC()
+	s.Status, s.StartedAt = surveyStatusRunning, &now
+	if s.Destination != "" {
+		// Event subscribers must not block on a command
+		survey := s.snapshot()
+		if !m.h.jobs.goJob("survey "+s.ID, func() {
+			_, err := m.h.eslClient.SendCommand(fmt.Sprintf("api uuid_transfer %s %s XML %s", survey.CallUUID, survey.Destination, survey.Context))
+			if err != nil {
+				m.mu.Lock()
+				if s.Status == surveyStatusRunning {
+					m.end(s, surveyStatusFailed, fmt.Sprintf("transfer to %s failed: %v", survey.Destination, err))
+				}
+				m.mu.Unlock()
+			}
+		}) {
+			m.end(s, surveyStatusFailed, "fs-api is shutting down")
+		}
+		return
+	}
+
+	steps, _ := s.req.steps()
+	s.FlowID = uuid.New().String()
+	run := newFlowRun(&Flow{
+		ID:        s.FlowID,
+		Context:   s.Context,
+		Survey:    s.ID,
+		Status:    flowStatusRunning,
+		CallUUID:  s.CallUUID,
+		Steps:     steps,
+		Results:   []FlowStepResult{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	if !m.h.flows.launch(run, nil) {
+		m.end(s, surveyStatusFailed, "fs-api is shutting down")
+	}
+}
+
+// flowEnded records the answers of the flow that asked a survey's questions
+func (m *surveyManager) flowEnded(flow Flow) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.surveys[flow.Survey]
+	if !ok || s.Status != surveyStatusRunning {
+		return
+	}
+	for _, result := range flow.Results {
+		if result.Action == flowActionCollect && result.Status == flowStatusCompleted && result.Step < len(s.Questions) {
+			s.Answers[s.Questions[result.Step]] = result.Digit
+		}
+	}
+	switch {
+	case len(s.Answers) == len(s.Questions):
+		m.end(s, surveyStatusCompleted, "")
+	case len(s.Answers) > 0:
+		m.end(s, surveyStatusPartial, "")
+	case flow.Status == flowStatusInterrupted:
+		m.end(s, surveyStatusFailed, flow.Error)
+	default:
+		m.end(s, surveyStatusNoResponse, "")
+	}
+}
+
+// surveysEnabled responds 501 without the event stream that starts surveys
+func (h *APIHandler) surveysEnabled(w http.ResponseWriter, r *http.Request) bool {
+	if h.eventHistory == nil {
+		h.respondError(w, r, "Surveys require FSAPI_EVENTS=true", http.StatusNotImplemented)
+		return false
+	}
+	return true
+}
+
+// --- Survey handlers ---
+
+// POST /v1/calls/{uuid}/survey
+//
+// The call is the customer leg: it is kept up when the bridge ends, and the
+// survey starts when the other leg hangs up.
+func (h *APIHandler) CreateSurvey(w http.ResponseWriter, r *http.Request) {
+	if !h.surveysEnabled(w, r) {
+		return
+	}
+	callUUID := mux.Vars(r)["uuid"]
+	if err := validateUUID(callUUID); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	callInfo, ok := h.validateCallContext(w, r, callUUID)
+	if !ok {
+		return
+	}
+	var req SurveyRequest
+	if !h.decodeRequest(w, r, &req) {
+		return
+	}
+	if err := req.validate(); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.surveys.mu.Lock()
+	_, armed := h.surveys.byCall[strings.ToLower(callUUID)]
+	h.surveys.mu.Unlock()
+	if armed {
+		h.respondError(w, r, fmt.Sprintf("Call %s already has a survey", callUUID), http.StatusConflict)
+		return
+	}
+
+	s := &Survey{
+		ID:          uuid.New().String(),
+		Context:     callInfo.AccountCode,
+		CallUUID:    callUUID,
+		Name:        req.Name,
+		Destination: req.Destination,
+		Status:      surveyStatusArmed,
+		Answers:     map[string]string{},
+		CreatedAt:   time.Now().UTC(),
+		req:         req,
+	}
+	for _, q := range req.Questions {
+		s.Questions = append(s.Questions, q.Name)
+	}
+	cmd := fmt.Sprintf("api uuid_setvar_multi %s park_after_bridge=true;hangup_after_bridge=false;%s=%s", callUUID, surveyIDVar, s.ID)
+	if _, err := h.eslClient.SendCommand(cmd); err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to arm survey: %v", err), err)
+		return
+	}
+
+	h.surveys.mu.Lock()
+	if _, armed := h.surveys.byCall[strings.ToLower(callUUID)]; armed {
+		h.surveys.mu.Unlock()
+		h.respondError(w, r, fmt.Sprintf("Call %s already has a survey", callUUID), http.StatusConflict)
+		return
+	}
+	h.surveys.surveys[s.ID] = s
+	h.surveys.byCall[strings.ToLower(callUUID)] = s
+	survey := s.snapshot()
+	h.surveys.mu.Unlock()
+
+	logInfo(getRequestID(r), fmt.Sprintf("Armed survey %s on call %s", s.ID, callUUID))
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   survey,
+	})
+}
+
+// GET /v1/surveys
+func (h *APIHandler) ListSurveys(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	h.surveys.mu.Lock()
+	rows := []Survey{}
+	for _, s := range h.surveys.surveys {
+		if (q.Get("context") != "" && s.Context != q.Get("context")) ||
+			(q.Get("name") != "" && s.Name != q.Get("name")) ||
+			(q.Get("status") != "" && s.Status != q.Get("status")) ||
+			(q.Get("call_uuid") != "" && !strings.EqualFold(s.CallUUID, q.Get("call_uuid"))) {
+			continue
+		}
+		if isContextAllowed(r, s.Context) {
+			rows = append(rows, s.snapshot())
+		}
+	}
+	h.surveys.mu.Unlock()
+	sort.Slice(rows, func(i, j int) bool { return rows[i].CreatedAt.Before(rows[j].CreatedAt) })
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status":    "success",
+		"row_count": len(rows),
+		"rows":      rows,
+	})
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Switch states reported by the health check while a shutdown or endpoint
+// module unload is in progress
+const (
+	switchShuttingDown   = "shutting_down"
+	switchModuleUnloaded = "module_unloaded"
+)
+
+// switchMonitor follows FreeSWITCH's own announcements that it is shutting
+// down or unloading an endpoint module (mod_sofia, mod_verto, ...). For pause
+// after an announcement, the health check reports degraded and originates
+// are refused with 503 and Retry-After, so clients back off instead of
+// getting a burst of 502s. A switch.* webhook and a metric are emitted for
+// each announcement.
+type switchMonitor struct {
+	mu           sync.Mutex
+	webhooks     *webhookManager
+	pause        time.Duration
+	shutdownAt   time.Time
+	shutdownCore string               // Core-UUID of the instance shutting down
+	unloaded     map[string]time.Time // Endpoint module -> unload time
+	counted      *counterVec
+	paused       *counterVec
+}
+
+func newSwitchMonitor(webhooks *webhookManager, metrics *metricsRegistry, pause time.Duration) *switchMonitor {
+	return &switchMonitor{
+		webhooks: webhooks,
+		pause:    pause,
+		unloaded: make(map[string]time.Time),
+		counted:  metrics.counter("fsapi_switch_events_total", "FreeSWITCH shutdown and endpoint module load/unload announcements.", "event"),
+		paused:   metrics.counter("fsapi_originates_paused_total", "Originates refused while FreeSWITCH was shutting down or reloading an endpoint module."),
+	}
+}
+
+// handleEvent is subscribed to the event bus
+func (s *switchMonitor) handleEvent(ev *Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	core := ev.Get("Core-UUID")
+	if s.shutdownCore != "" && core != "" && core != s.shutdownCore {
+		// Events from a new instance: FreeSWITCH has restarted
+		log.Printf("FreeSWITCH is back after shutdown (core %s)", core)
+		s.shutdownAt, s.shutdownCore = time.Time{}, ""
+		clear(s.unloaded)
+	}
+
+	switch ev.Name {
+	case "SHUTDOWN":
+		if !s.shutdownAt.IsZero() {
+			return
+		}
+		s.shutdownAt, s.shutdownCore = now, core
+		s.counted.inc("shutdown")
+		log.Printf("WARNING: FreeSWITCH announced shutdown; pausing originates for %s", s.pause)
+		s.webhooks.dispatch("switch.shutdown", "", map[string]interface{}{
+			"core_uuid": core,
+			"info":      ev.Get("Event-Info"),
+			"pause_sec": int(s.pause.Seconds()),
+		})
+
+	case "MODULE_UNLOAD", "MODULE_LOAD":
+		// One event is sent per interface; only endpoints affect calls, and
+		// the unloads that follow SHUTDOWN are part of it
+		module := ev.Get("key")
+		if ev.Get("type") != "endpoint" || module == "" || !s.shutdownAt.IsZero() {
+			return
+		}
+		_, wasUnloaded := s.unloaded[module]
+		if ev.Name == "MODULE_UNLOAD" {
+			if wasUnloaded {
+				return
+			}
+			s.unloaded[module] = now
+			s.counted.inc("module_unload")
+			log.Printf("WARNING: FreeSWITCH unloaded endpoint module %s; pausing originates for %s", module, s.pause)
+			s.webhooks.dispatch("switch.module_unload", "", map[string]interface{}{
+				"module":    module,
+				"pause_sec": int(s.pause.Seconds()),
+			})
+			return
+		}
+		if !wasUnloaded {
+			return
+		}
+		delete(s.unloaded, module)
+		s.counted.inc("module_load")
+		log.Printf("FreeSWITCH loaded endpoint module %s again", module)
+		s.webhooks.dispatch("switch.module_load", "", map[string]interface{}{
+			"module": module,
+		})
+	}
+}
+
+// state returns the current switch state and how long it lasts, or "" when
+// FreeSWITCH has announced nothing within the pause
+func (s *switchMonitor) state() (string, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if !s.shutdownAt.IsZero() {
+		if remaining := s.shutdownAt.Add(s.pause).Sub(now); remaining > 0 {
+			return switchShuttingDown, remaining
+		}
+	}
+	var longest time.Duration
+	for _, at := range s.unloaded {
+		longest = max(longest, at.Add(s.pause).Sub(now))
+	}
+	if longest > 0 {
+		return switchModuleUnloaded, longest
+	}
+	return "", 0
+}
+
+// checkOriginateAllowed responds 503 and returns false while FreeSWITCH is
+// shutting down or reloading an endpoint module
+func (h *APIHandler) checkOriginateAllowed(w http.ResponseWriter, r *http.Request) bool {
+	state, remaining := h.switchMon.state()
+	if state == "" {
+		return true
+	}
+	h.switchMon.paused.inc()
+	retryAfter := max(int(math.Ceil(remaining.Seconds())), 1)
+	message := "FreeSWITCH is shutting down; originates are paused"
+	if state == switchModuleUnloaded {
+		message = "FreeSWITCH is reloading an endpoint module; originates are paused"
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	h.respondErrorBody(w, r, ErrorResponse{
+		Status:     "error",
+		Message:    message,
+		Code:       ErrCodeSwitchUnavailable,
+		RetryAfter: retryAfter,
+	}, http.StatusServiceUnavailable)
+	return false
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Exit statuses, following sysexits.h, so supervisors can tell a
+// configuration mistake (restarting will not help) from a transient failure
+const (
+	exitConfig      = 78 // EX_CONFIG: invalid settings or rejected ESL credentials
+	exitUnavailable = 69 // EX_UNAVAILABLE: ESL unreachable at startup, port in use, ...
+)
+
+// fatalConfig logs a configuration error and exits with exitConfig
+func fatalConfig(format string, args ...interface{}) {
+	log.Printf("FATAL: "+format, args...)
+	os.Exit(exitConfig)
+}
+
+// fatalUnavailable logs a failure that may clear on its own and exits with
+// exitUnavailable
+func fatalUnavailable(format string, args ...interface{}) {
+	log.Printf("FATAL: "+format, args...)
+	os.Exit(exitUnavailable)
+}
+
+// sdNotify sends a state change ("READY=1", "STOPPING=1", "STATUS=...") to
+// systemd when running as a Type=notify service. Without NOTIFY_SOCKET it
+// does nothing.
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:] // Abstract namespace
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		log.Printf("WARNING: sd_notify %q failed: %v", state, err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Printf("WARNING: sd_notify %q failed: %v", state, err)
+	}
+}
+
+// writePIDFile writes the process ID to path, refusing to overwrite the file
+// of another running fs-api
+func writePIDFile(path string) error {
+	if data, err := os.ReadFile(path); err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && pid != os.Getpid() && processAlive(pid) {
+			return fmt.Errorf("%s belongs to running process %d", path, pid)
+		}
+	}
+	return os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// processAlive reports whether a process with the given ID exists
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return p.Signal(syscall.Signal(0)) == nil
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const (
+	trashFile          = "trash.json"
+	trashPurgeInterval = time.Minute
+)
+
+// Types of entities deleted into the trash
+const (
+	trashWebhook      = "webhook"
+	trashDID          = "did"
+	trashFlowTemplate = "flow_template"
+)
+
+var trashTypes = []string{trashWebhook, trashDID, trashFlowTemplate}
+
+// TrashEntry is a deleted entity kept until it is restored or purged
+type TrashEntry struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`               // webhook, did or flow_template
+	Key       string          `json:"key"`                // Webhook ID, DID number or template name@context
+	Contexts  []string        `json:"contexts,omitempty"` // Empty: only unrestricted callers see it
+	DeletedAt time.Time       `json:"deleted_at"`
+	PurgeAt   time.Time       `json:"purge_at"`
+	Data      json.RawMessage `json:"data"` // The entity as it was stored
+}
+
+// visible reports whether the caller may see the entry: it must have
+// access to all of its contexts
+func (entry *TrashEntry) visible(r *http.Request) bool {
+	if isUnrestrictedAccess(r) {
+		return true
+	}
+	if len(entry.Contexts) == 0 {
+		return false
+	}
+	for _, ctx := range entry.Contexts {
+		if !isContextAllowed(r, ctx) {
+			return false
+		}
+	}
+	return true
+}
+
+// view returns the entry as the API shows it, without webhook secrets
+func (entry *TrashEntry) view() TrashEntry {
+	view := *entry
+	if entry.Type == trashWebhook {
+		var hook Webhook
+		if json.Unmarshal(entry.Data, &hook) == nil {
+			view.Data, _ = json.Marshal(hook.view())
+		}
+	}
+	return view
+}
+
+// trash holds deleted webhooks, DIDs and flow templates for retention,
+// after which they are purged. With no retention entities are deleted at
+// once.
+type trash struct {
+	retention time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*TrashEntry
+}
+
+// newTrash loads the trash from FSAPI_DATA_DIR
+func newTrash(retention time.Duration) *trash {
+	t := &trash{retention: retention, entries: make(map[string]*TrashEntry)}
+	var entries []*TrashEntry
+	if err := loadJSONFile(trashFile, &entries); err != nil {
+		log.Printf("WARNING: Failed to load trash: %v", err)
+	}
+	for _, entry := range entries {
+		t.entries[entry.ID] = entry
+	}
+	return t
+}
+
+// save persists the trash. Caller must hold mu.
+func (t *trash) save() error {
+	entries := make([]*TrashEntry, 0, len(t.entries))
+	for _, entry := range t.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].DeletedAt.Before(entries[j].DeletedAt) })
+	return saveJSONFile(trashFile, entries)
+}
+
+// put keeps a deleted entity, returning nil when the trash is disabled
+func (t *trash) put(kind, key string, contexts []string, v interface{}) (*TrashEntry, error) {
+	if t.retention <= 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	entry := &TrashEntry{
+		ID:        uuid.New().String(),
+		Type:      kind,
+		Key:       key,
+		Contexts:  contexts,
+		DeletedAt: now,
+		PurgeAt:   now.Add(t.retention),
+		Data:      data,
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entries[entry.ID] = entry
+	return entry, t.save()
+}
+
+// remove takes an entry out of the trash
+func (t *trash) remove(id string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.entries, id)
+	return t.save()
+}
+
+// purge drops the entries whose retention has run out
+func (t *trash) purge(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	purged := 0
+	for id, entry := range t.entries {
+		if now.After(entry.PurgeAt) {
+			delete(t.entries, id)
+			purged++
+		}
+	}
+	if purged == 0 {
+		return
+	}
+	log.Printf("Purged %d expired trash entries", purged)
+	if err := t.save(); err != nil {
+		log.Printf("WARNING: Failed to persist trash: %v", err)
+	}
+}
+
+// runTrashPurge purges expired trash entries until shutdown starts
+func (h *APIHandler) runTrashPurge() {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			h.trash.purge(now.UTC())
+		case <-h.jobs.stopping():
+			return
+		}
+	}
+}
+
+// trashDeleted keeps an entity a request deleted and returns the message
+// answering the request
+func (h *APIHandler) trashDeleted(r *http.Request, message, kind, key string, contexts []string, v interface{}) string {
+	entry, err := h.trash.put(kind, key, contexts, v)
+	if err != nil {
+		logWarn(getRequestID(r), fmt.Sprintf("Failed to persist trash: %v", err))
+	}
+	if entry == nil {
+		return message
+	}
+	return fmt.Sprintf("%s; restorable as trash entry %s until %s", message, entry.ID, entry.PurgeAt.Format(time.RFC3339))
+}
+
+// lookupTrashEntry returns the entry named in the URL if the caller may
+// see it
+func (h *APIHandler) lookupTrashEntry(w http.ResponseWriter, r *http.Request) (*TrashEntry, bool) {
+	id := mux.Vars(r)["id"]
+	h.trash.mu.Lock()
+	entry, ok := h.trash.entries[id]
+	h.trash.mu.Unlock()
+	if !ok || !entry.visible(r) {
+		h.respondError(w, r, fmt.Sprintf("Trash entry %s not found", id), http.StatusNotFound)
+		return nil, false
+	}
+	return entry, true
+}
+
+// GET /v1/trash
+func (h *APIHandler) ListTrash(w http.ResponseWriter, r *http.Request) {
+	kind := r.URL.Query().Get("type")
+	if kind != "" && !containsString(trashTypes, kind) {
+		h.respondError(w, r, fmt.Sprintf("type must be one of: %s", strings.Join(trashTypes, ", ")), http.StatusBadRequest)
+		return
+	}
+
+	h.trash.mu.Lock()
+	rows := []TrashEntry{}
+	for _, entry := range h.trash.entries {
+		if (kind == "" || entry.Type == kind) && entry.visible(r) {
+			rows = append(rows, entry.view())
+		}
+	}
+	h.trash.mu.Unlock()
+	// Most recently deleted first
+	sort.Slice(rows, func(i, j int) bool { return rows[i].DeletedAt.After(rows[j].DeletedAt) })
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status":    "success",
+		"row_count": len(rows),
+		"rows":      rows,
+	})
+}
+
+// POST /v1/trash/{id}/restore
+//
+// Puts the entity back as it was deleted, unless another one has taken its
+// key (webhook ID, DID number or template name) since.
+func (h *APIHandler) RestoreTrashEntry(w http.ResponseWriter, r *http.Request) {
+	entry, ok := h.lookupTrashEntry(w, r)
+	if !ok {
+		return
+	}
+
+	var restored interface{}
+	var exists bool
+	var err error
+	switch entry.Type {
+	case trashWebhook:
+		var hook Webhook
+		if err = json.Unmarshal(entry.Data, &hook); err == nil {
+			h.webhooks.mu.Lock()
+			if _, exists = h.webhooks.hooks[hook.ID]; !exists {
+				h.webhooks.hooks[hook.ID] = &hook
+				if err := h.webhooks.save(); err != nil {
+					logWarn(getRequestID(r), fmt.Sprintf("Failed to persist webhooks: %v", err))
+				}
+			}
+			h.webhooks.mu.Unlock()
+			restored = hook.view()
+		}
+	case trashDID:
+		var did DID
+		if err = json.Unmarshal(entry.Data, &did); err == nil {
+			// DIDs deleted before numbers were stored without '+'
+			did.Number = strings.TrimPrefix(did.Number, "+")
+			h.dids.mu.Lock()
+			if _, exists = h.dids.dids[did.Number]; !exists {
+				h.dids.dids[did.Number] = &did
+				if err := h.dids.save(); err != nil {
+					logWarn(getRequestID(r), fmt.Sprintf("Failed to persist DIDs: %v", err))
+				}
+			}
+			h.dids.mu.Unlock()
+			if !exists {
+				if err := h.writeDIDDialplan(); err != nil {
+					logWarn(getRequestID(r), fmt.Sprintf("Failed to write DID dialplan: %v", err))
+				}
+			}
+			restored = did
+		}
+	case trashFlowTemplate:
+		var t FlowTemplate
+		if err = json.Unmarshal(entry.Data, &t); err == nil {
+			h.flowTemplates.mu.Lock()
+			if _, exists = h.flowTemplates.templates[t.id()]; !exists {
+				h.flowTemplates.templates[t.id()] = &t
+				if err := h.flowTemplates.save(); err != nil {
+					logWarn(getRequestID(r), fmt.Sprintf("Failed to persist flow templates: %v", err))
+				}
+			}
+			h.flowTemplates.mu.Unlock()
+			restored = t
+		}
+	default:
+		err = fmt.Errorf("unknown type %q", entry.Type)
+	}
+	if err != nil {
+		h.respondError(w, r, fmt.Sprintf("Failed to restore trash entry %s: %v", entry.ID, err), http.StatusInternalServerError)
+		return
+	}
+	if exists {
+		h.respondError(w, r, fmt.Sprintf("Cannot restore %s %s: it exists again", entry.Type, entry.Key), http.StatusConflict)
+		return
+	}
+
+	if err := h.trash.remove(entry.ID); err != nil {
+		logWarn(getRequestID(r), fmt.Sprintf("Failed to persist trash: %v", err))
+	}
+	h.audit(r, AuditEntry{
+		Action:  "trash.restore",
+		Target:  entry.Type + " " + entry.Key,
+		Context: strings.Join(entry.Contexts, ","),
+	})
+	h.respondJSON(w, r, SuccessResponse{
+		Status:  "success",
+		Message: fmt.Sprintf("Restored %s %s", entry.Type, entry.Key),
+		Data:    restored,
+	})
+}
+
+// DELETE /v1/trash/{id}
+func (h *APIHandler) PurgeTrashEntry(w http.ResponseWriter, r *http.Request) {
+	entry, ok := h.lookupTrashEntry(w, r)
+	if !ok {
+		return
+	}
+	if err := h.trash.remove(entry.ID); err != nil {
+		logWarn(getRequestID(r), fmt.Sprintf("Failed to persist trash: %v", err))
+	}
+	h.audit(r, AuditEntry{
+		Action:  "trash.purge",
+		Target:  entry.Type + " " + entry.Key,
+		Context: strings.Join(entry.Contexts, ","),
+	})
+	h.respondSuccess(w, r, fmt.Sprintf("Purged %s %s", entry.Type, entry.Key))
+}
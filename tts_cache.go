@@ -0,0 +1,390 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Speech rendered by a TTS engine is cached as a WAV file per engine, voice
+// and text under FSAPI_TTS_CACHE_DIR/<engine>/<voice>/<sha256>.wav. The
+// first time a text is spoken it is spoken live while FreeSWITCH renders
+// it into the cache on a loopback channel; later requests play the file.
+
+const (
+	ttsRenderMaxSec     = 300 // Longest speech a render records
+	ttsRenderStaleAfter = (ttsRenderMaxSec + 60) * time.Second
+	ttsMaxRenders       = 4 // Renders running at once
+	ttsDefaultVoice     = "default"
+)
+
+// ttsCacheFilePattern matches cached files relative to the cache directory
+var ttsCacheFilePattern = regexp.MustCompile(`^[^/.][^/]*/[^/.][^/]*/[0-9a-f]{64}\.wav$`)
+
+type ttsCacheEntry struct {
+	key      string // Path relative to the cache directory
+	size     int64
+	lastUsed time.Time
+}
+
+type ttsRender struct {
+	key     string
+	started time.Time
+}
+
+// ttsCache holds rendered speech and renders cache misses. A nil *ttsCache
+// never hits.
+type ttsCache struct {
+	h        *APIHandler
+	dir      string
+	maxBytes int64
+
+	mu        sync.Mutex
+	entries   map[string]*ttsCacheEntry
+	rendering map[string]*ttsRender // By render channel UUID
+	bytes     int64
+
+	hits, misses, rendered, renderFailed, evicted int64
+
+	lookups *counterVec
+	renders *counterVec
+	size    *counterVec
+}
+
+func newTTSCache(h *APIHandler, dir string, maxBytes int64) *ttsCache {
+	c := &ttsCache{
+		h:         h,
+		dir:       dir,
+		maxBytes:  maxBytes,
+		entries:   make(map[string]*ttsCacheEntry),
+		rendering: make(map[string]*ttsRender),
+		lookups:   h.metrics.counter("fsapi_tts_cache_lookups_total", "TTS cache lookups, by result (hit or miss).", "result"),
+		renders:   h.metrics.counter("fsapi_tts_cache_renders_total", "Speech rendered into the TTS cache, by result (ok or failed).", "result"),
+		size:      h.metrics.gauge("fsapi_tts_cache_bytes", "Size of the files in the TTS cache."),
+	}
+
+	// Renders cut short by a restart leave partial files behind
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, path)
+		if strings.HasSuffix(path, ".part.wav") {
+			os.Remove(path)
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || !ttsCacheFilePattern.MatchString(filepath.ToSlash(rel)) {
+			return nil
+		}
+		c.entries[rel] = &ttsCacheEntry{key: rel, size: info.Size(), lastUsed: info.ModTime()}
+		c.bytes += info.Size()
+		return nil
+	})
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	log.Printf("TTS cache: %d files, %d bytes in %s", len(c.entries), c.bytes, dir)
+	return c
+}
+
+// ttsCacheKey returns the cache file of tts relative to the cache
+// directory, or "" when the text cannot be rendered safely
+func ttsCacheKey(tts *AnnounceTTS) string {
+	voice := tts.Voice
+	if voice == "" {
+		voice = ttsDefaultVoice
+	}
+	// The text is passed quoted in the render's channel variables
+	if strings.HasPrefix(tts.Engine, ".") || strings.HasPrefix(voice, ".") || checkESLArg("tts.text", tts.Text, "'${}") != nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(tts.Engine + "\x00" + tts.Voice + "\x00" + tts.Text))
+	return filepath.Join(tts.Engine, voice, hex.EncodeToString(sum[:])+".wav")
+}
+
+// lookup returns the cached file of tts. On a miss the text is rendered
+// in the background, so the caller speaks it live this time.
+func (c *ttsCache) lookup(tts *AnnounceTTS) (string, bool) {
+	if c == nil || tts == nil {
+		return "", false
+	}
+	key := ttsCacheKey(tts)
+	if key == "" {
+		return "", false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		path := filepath.Join(c.dir, key)
+		if _, err := os.Stat(path); err == nil {
+			e.lastUsed = time.Now()
+			c.hits++
+			c.lookups.inc("hit")
+			return path, true
+		}
+		// Removed behind our back
+		c.remove(e)
+	}
+	c.misses++
+	c.lookups.inc("miss")
+
+	now := time.Now()
+	for id, render := range c.rendering {
+		if now.Sub(render.started) > ttsRenderStaleAfter {
+			delete(c.rendering, id)
+		}
+		if render.key == key {
+			return "", false
+		}
+	}
+	if len(c.rendering) >= ttsMaxRenders {
+		return "", false
+	}
+	id := uuid.New().String()
+	c.rendering[id] = &ttsRender{key: key, started: now}
+	text := *tts
+	if !c.h.jobs.goJob("tts render", func() { c.render(id, key, &text) }) {
+		delete(c.rendering, id)
+	}
+	return "", false
+}
+
+// render records tts into the cache on a loopback channel that speaks it.
+// The file is moved into place when the channel hangs up.
+func (c *ttsCache) render(id, key string, tts *AnnounceTTS) {
+	path := filepath.Join(c.dir, key)
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err == nil {
+		cmd := fmt.Sprintf("api originate {origination_uuid=%s,fsapi_tts_render=%s,fsapi_tts_text='%s'}loopback/app=speak:%s|%s|${fsapi_tts_text} &record(%s %d)",
+			id, strings.TrimSuffix(filepath.Base(key), ".wav"), tts.Text, tts.Engine, tts.Voice, partPath(path), ttsRenderMaxSec)
+		var response string
+		response, err = c.h.eslClient.SendCommand(cmd)
+		if cause := parseESLErrCause(response); cause != "" {
+			err = fmt.Errorf("%s", cause)
+		}
+	}
+	if err != nil {
+		log.Printf("TTS cache: rendering with %s failed: %v", tts.Engine, err)
+		c.mu.Lock()
+		if _, ok := c.rendering[id]; ok {
+			delete(c.rendering, id)
+			c.failed(path)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// partPath is where a render records before the file is complete
+func partPath(path string) string {
+	return strings.TrimSuffix(path, ".wav") + ".part.wav"
+}
+
+// failed counts a failed render and removes what it left. Caller must
+// hold mu.
+func (c *ttsCache) failed(path string) {
+	os.Remove(partPath(path))
+	c.renderFailed++
+	c.renders.inc("failed")
+}
+
+// handleEvent moves a render into the cache once its channel hangs up
+func (c *ttsCache) handleEvent(ev *Event) {
+	if ev.Name != "CHANNEL_HANGUP_COMPLETE" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	render, ok := c.rendering[ev.UUID()]
+	if !ok {
+		return
+	}
+	delete(c.rendering, ev.UUID())
+
+	path := filepath.Join(c.dir, render.key)
+	info, err := os.Stat(partPath(path))
+	// Anything shorter than a WAV header holds no speech
+	if err != nil || info.Size() <= 44 || os.Rename(partPath(path), path) != nil {
+		log.Printf("TTS cache: render %s produced no audio (%s)", ev.UUID(), ev.Get("Hangup-Cause"))
+		c.failed(path)
+		return
+	}
+	if e, ok := c.entries[render.key]; ok {
+		c.bytes -= e.size
+	}
+	c.entries[render.key] = &ttsCacheEntry{key: render.key, size: info.Size(), lastUsed: time.Now()}
+	c.bytes += info.Size()
+	c.rendered++
+	c.renders.inc("ok")
+	c.evict()
+}
+
+// remove drops an entry and its file. Caller must hold mu.
+func (c *ttsCache) remove(e *ttsCacheEntry) {
+	os.Remove(filepath.Join(c.dir, e.key))
+	delete(c.entries, e.key)
+	c.bytes -= e.size
+	c.size.set(float64(c.bytes))
+}
+
+// evict removes the least recently used files until the cache fits in
+// maxBytes. Caller must hold mu.
+func (c *ttsCache) evict() {
+	if c.bytes > c.maxBytes {
+		entries := make([]*ttsCacheEntry, 0, len(c.entries))
+		for _, e := range c.entries {
+			entries = append(entries, e)
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].lastUsed.Before(entries[j].lastUsed) })
+		for _, e := range entries {
+			if c.bytes <= c.maxBytes {
+				break
+			}
+			c.remove(e)
+			c.evicted++
+		}
+	}
+	c.size.set(float64(c.bytes))
+}
+
+// TTSCacheStats is the body of GET /v1/tts/cache
+type TTSCacheStats struct {
+	Entries        int                       `json:"entries"`
+	Bytes          int64                     `json:"bytes"`
+	MaxBytes       int64                     `json:"max_bytes"`
+	Hits           int64                     `json:"hits"`
+	Misses         int64                     `json:"misses"`
+	HitRatio       float64                   `json:"hit_ratio"` // Of lookups since start
+	Renders        int64                     `json:"renders"`
+	RenderFailures int64                     `json:"render_failures"`
+	Rendering      int                       `json:"rendering"`
+	Evictions      int64                     `json:"evictions"`
+	Engines        map[string]TTSEngineUsage `json:"engines"`
+}
+
+// TTSEngineUsage is the share of the cache held by one engine
+type TTSEngineUsage struct {
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+}
+
+func (c *ttsCache) stats() TTSCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := TTSCacheStats{
+		Entries:        len(c.entries),
+		Bytes:          c.bytes,
+		MaxBytes:       c.maxBytes,
+		Hits:           c.hits,
+		Misses:         c.misses,
+		Renders:        c.rendered,
+		RenderFailures: c.renderFailed,
+		Rendering:      len(c.rendering),
+		Evictions:      c.evicted,
+		Engines:        map[string]TTSEngineUsage{},
+	}
+	if lookups := c.hits + c.misses; lookups > 0 {
+		s.HitRatio = float64(c.hits) / float64(lookups)
+	}
+	for key, e := range c.entries {
+		engine, _, _ := strings.Cut(filepath.ToSlash(key), "/")
+		usage := s.Engines[engine]
+		usage.Entries++
+		usage.Bytes += e.size
+		s.Engines[engine] = usage
+	}
+	return s
+}
+
+// purge removes the entries of engine and voice ("" for any) and returns
+// how many files and bytes were removed. With text only the entry of that
+// text is removed.
+func (c *ttsCache) purge(engine, voice, text string) (int, int64) {
+	var only string
+	if text != "" {
+		if only = ttsCacheKey(&AnnounceTTS{Engine: engine, Voice: voice, Text: text}); only == "" {
+			return 0, 0
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	purged, freed := 0, int64(0)
+	for key, e := range c.entries {
+		parts := strings.Split(filepath.ToSlash(key), "/")
+		match := (engine == "" || parts[0] == engine) && (voice == "" || parts[1] == voice)
+		if only != "" {
+			match = key == only
+		}
+		if match {
+			purged++
+			freed += e.size
+			c.remove(e)
+		}
+	}
+	return purged, freed
+}
+
+// --- TTS cache handlers ---
+
+// ttsCacheEnabled writes a 501 when the TTS cache is disabled
+func (h *APIHandler) ttsCacheEnabled(w http.ResponseWriter, r *http.Request) bool {
+	if h.ttsCache == nil {
+		h.respondError(w, r, "TTS cache is disabled (FSAPI_TTS_CACHE_DIR is not set)", http.StatusNotImplemented)
+		return false
+	}
+	return true
+}
+
+// GET /v1/tts/cache
+func (h *APIHandler) GetTTSCacheStats(w http.ResponseWriter, r *http.Request) {
+	if !h.requireUnrestricted(w, r) || !h.ttsCacheEnabled(w, r) {
+		return
+	}
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   h.ttsCache.stats(),
+	})
+}
+
+// DELETE /v1/tts/cache?engine=&voice=&text=
+//
+// Without parameters the whole cache is purged. text needs engine and
+// removes the one rendering of that text by engine and voice.
+func (h *APIHandler) PurgeTTSCache(w http.ResponseWriter, r *http.Request) {
+	if !h.requireUnrestricted(w, r) || !h.ttsCacheEnabled(w, r) {
+		return
+	}
+	q := r.URL.Query()
+	engine, voice, text := q.Get("engine"), q.Get("voice"), q.Get("text")
+	if (engine != "" && !isValidChannelVarName(engine)) || (voice != "" && !isValidChannelVarName(voice)) {
+		h.respondError(w, r, "engine and voice may only contain letters, digits and . _ -", http.StatusBadRequest)
+		return
+	}
+	if (voice != "" || text != "") && engine == "" {
+		h.respondError(w, r, "voice and text need engine", http.StatusBadRequest)
+		return
+	}
+
+	purged, freed := h.ttsCache.purge(engine, voice, text)
+	logInfo(getRequestID(r), fmt.Sprintf("TTS cache purged: %d files, %d bytes", purged, freed))
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data": map[string]interface{}{
+			"purged": purged,
+			"bytes":  freed,
+		},
+	})
+}
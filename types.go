@@ -1,30 +1,105 @@
 package main
 
+import "time"
+
 // Request/Response Structures
 type SuccessResponse struct {
-	Status  string `json:"status"`
-	Message string `json:"message"`
+	Status  string      `json:"status"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// CallActionResult is the data of a call control response: what was done to
+// which call, as sent to FreeSWITCH
+type CallActionResult struct {
+	UUID        string                 `json:"uuid"`
+	Action      string                 `json:"action"`               // e.g. hangup, transfer, hold
+	Parameters  map[string]interface{} `json:"parameters,omitempty"` // Parameters applied, defaults included
+	Command     string                 `json:"command"`              // ESL command sent
+	Result      string                 `json:"result"`               // FreeSWITCH's raw reply
+	SentAt      time.Time              `json:"sent_at"`
+	CompletedAt time.Time              `json:"completed_at"`
 }
 
 type ErrorResponse struct {
-	Status  string `json:"status"`
-	Message string `json:"message"`
+	Status     string       `json:"status"`
+	Message    string       `json:"message"`
+	Code       string       `json:"code,omitempty"`        // Machine-readable error code
+	RetryAfter int          `json:"retry_after,omitempty"` // Seconds until a retry is worthwhile
+	Cause      string       `json:"cause,omitempty"`       // FreeSWITCH hangup cause (originate failures)
+	Errors     []FieldError `json:"errors,omitempty"`      // Request body problems (code invalid_body)
+	Limit      int64        `json:"limit,omitempty"`       // Body size limit in bytes (code body_too_large)
+}
+
+// Machine-readable error codes
+const (
+	ErrCodeESLUnavailable     = "esl_unavailable"
+	ErrCodeInvalidBody        = "invalid_body"
+	ErrCodeEmptyBody          = "empty_body"
+	ErrCodeUnsupportedMedia   = "unsupported_media_type"
+	ErrCodeBodyTooLarge       = "body_too_large"
+	ErrCodeAuthLocked         = "auth_locked"
+	ErrCodeReplayedRequest    = "replayed_request"
+	ErrCodePolicyDenied       = "policy_denied"
+	ErrCodePolicyUnavailable  = "policy_unavailable"
+	ErrCodeSwitchUnavailable  = "switch_unavailable"
+	ErrCodeNoRoute            = "no_route"
+	ErrCodeGatewayFull        = "gateway_full"
+	ErrCodeGatewayUnavailable = "gateway_unavailable"
+	ErrCodeDestinationBlocked = "destination_blocked"
+	ErrCodeOutsideHours       = "outside_hours"
+	ErrCodeCallerIDNotAllowed = "caller_id_not_allowed"
+	ErrCodeCallStateChanged   = "call_state_changed"
+	ErrCodeCallBusy           = "call_busy"
+)
+
+// CallLeg is one leg in CallDetailsResponse: its UUID and uuid_dump output
+type CallLeg struct {
+	UUID    string                 `json:"uuid"`
+	Details map[string]interface{} `json:"details"`
+}
+
+// CallDetailsResponse is returned by GET /v1/calls/{uuid}. JSON keys follow
+// the field order.
+type CallDetailsResponse struct {
+	Status     string                 `json:"status"`
+	CallInfo   map[string]interface{} `json:"call_info"` // The call's row from "show calls"
+	ALeg       CallLeg                `json:"aleg"`
+	BLeg       *CallLeg               `json:"bleg,omitempty"`        // Absent for unbridged calls
+	StirShaken *StirShakenStatus      `json:"stir_shaken,omitempty"` // A-leg verification status; absent when the call carries none
+	Version    string                 `json:"state_version"`         // ETag of the requested leg, for If-Match
 }
 
 type HangupRequest struct {
-	Cause string `json:"cause"`
+	Cause string `json:"cause"` // Optional: hangup cause (default FSAPI_HANGUP_CAUSE for the call's context)
 }
 
 type TransferRequest struct {
-	Destination string `json:"destination"`        // Required: destination extension
-	Dialplan    string `json:"dialplan,omitempty"` // Optional: dialplan type (e.g., "XML")
-	Context     string `json:"context,omitempty"`  // Optional: dialplan context
-	Leg         string `json:"leg,omitempty"`      // Optional: "aleg" (default), "bleg", or "both"
+	Destination string       `json:"destination"`            // Required unless to_voicemail: destination extension
+	ToVoicemail string       `json:"to_voicemail,omitempty"` // Optional instead of destination: mailbox as user@domain
+	Dialplan    string       `json:"dialplan,omitempty"`     // Optional: dialplan type (e.g., "XML")
+	Context     string       `json:"context,omitempty"`      // Optional: dialplan context
+	Leg         string       `json:"leg,omitempty"`          // Optional: "aleg" (default), "bleg", or "both"
+	Billing     *BillingInfo `json:"billing,omitempty"`      // Optional: billing tags stored on the call
+}
+
+// applyDefaults: leg "aleg"; dialplan "XML" when a context is given, since
+// uuid_transfer takes dialplan and context as a pair
+func (req *TransferRequest) applyDefaults() {
+	if req.Leg == "" {
+		req.Leg = "aleg"
+	}
+	if req.Context != "" && req.Dialplan == "" {
+		req.Dialplan = "XML"
+	}
 }
 
 type BridgeRequest struct {
-	UUIDA string `json:"uuid_a"`
-	UUIDB string `json:"uuid_b"`
+	UUIDA             string   `json:"uuid_a"`
+	UUIDB             string   `json:"uuid_b"`
+	ParkAfterBridge   bool     `json:"park_after_bridge,omitempty"`  // Optional: park both legs when the bridge ends
+	TransferVariables []string `json:"transfer_variables,omitempty"` // Optional: channel variables copied from uuid_a to uuid_b
+	CallerIDLookup    bool     `json:"caller_id_lookup,omitempty"`   // Optional: show uuid_a's looked-up caller name on uuid_b
 }
 
 type HoldRequest struct {
@@ -38,16 +113,154 @@ type RecordRequest struct {
 
 type DTMFRequest struct {
 	Digits   string `json:"digits"`
-	Duration int    `json:"duration,omitempty"`
+	Duration int    `json:"duration,omitempty"` // Optional: ms per digit (default FSAPI_DTMF_DURATION for the call's context)
 }
 
 type OriginateRequest struct {
 	ALeg             string                 `json:"aleg"`
-	BLeg             string                 `json:"bleg"`
+	ALegEndpoint     *DialTarget            `json:"aleg_endpoint,omitempty"`    // Optional instead of aleg: endpoint fields turned into the dial string
+	BLeg             string                 `json:"bleg"`                       // Optional: default FSAPI_PARK_APP for the context
+	Application      string                 `json:"application,omitempty"`      // Optional instead of bleg: park, echo, playback, conference or callcenter
+	ApplicationArgs  string                 `json:"application_args,omitempty"` // Arguments of application, checked per application
+	RouteVia         string                 `json:"route_via,omitempty"`        // Optional: "lcr" lets mod_lcr pick the gateways for aleg_endpoint.number
+	LCRProfile       string                 `json:"lcr_profile,omitempty"`      // Optional with route_via lcr: mod_lcr profile (default profile when empty)
+	GatewayGroup     string                 `json:"gateway_group,omitempty"`    // Optional: FSAPI_GATEWAY_GROUPS group whose gateways dial aleg_endpoint.number
 	Dialplan         string                 `json:"dialplan,omitempty"`
 	Context          string                 `json:"context,omitempty"`
 	CallerIDName     string                 `json:"caller_id_name,omitempty"`
 	CallerIDNumber   string                 `json:"caller_id_number,omitempty"`
+	CallerIDLookup   bool                   `json:"caller_id_lookup,omitempty"` // Optional: fill caller_id_name from mod_cidlookup
 	TimeoutSec       int                    `json:"timeout_sec,omitempty"`
+	MaxDurationSec   int                    `json:"max_duration_sec,omitempty"` // Optional: hang up this many seconds after answer
 	ChannelVariables map[string]interface{} `json:"channel_variables,omitempty"`
+	Billing          *BillingInfo           `json:"billing,omitempty"`     // Optional: billing tags stored on the call
+	StirShaken       *StirShakenInfo        `json:"stir_shaken,omitempty"` // Optional: Identity header and attestation level for the carrier
+	Priority         string                 `json:"priority,omitempty"`    // Optional: SIP Priority; "emergency" also bypasses the originate pause
+	Whisper          *WhisperPrompt         `json:"whisper,omitempty"`     // Optional: played to the answering party (the agent, for a queue) before connecting
+	Locale           string                 `json:"locale,omitempty"`      // Optional instead of X-Locale: language of the call, e.g. de-DE
+	Timezone         string                 `json:"timezone,omitempty"`    // Optional instead of X-Timezone: IANA timezone of the call
+}
+
+// BillingInfo tags a call for downstream billing. The values are stored as
+// channel variables and echoed into CDRs and hangup webhooks.
+type BillingInfo struct {
+	RatePlanID  string `json:"rate_plan_id,omitempty"`
+	CustomerRef string `json:"customer_ref,omitempty"`
+}
+
+type ParkRequest struct {
+	RecallAfterSec int    `json:"recall_after_sec,omitempty"` // Optional: transfer the call to recall_to if still parked after this many seconds
+	RecallTo       string `json:"recall_to,omitempty"`        // Optional with recall_after_sec: extension to recall to (default the parker)
+}
+
+type ParkSlotRetrieveRequest struct {
+	User string `json:"user"`          // Required: directory user (user@domain) the parked call is bridged to
+	Lot  string `json:"lot,omitempty"` // Optional: park lot (default the user's domain)
+}
+
+type ConferenceJoinRequest struct {
+	User string `json:"user"` // Required: directory user (user@domain) called into the room
+}
+
+type ConferenceRecordingRequest struct {
+	Action string `json:"action"` // start, stop, pause or resume
+}
+
+type ConferenceLayoutRequest struct {
+	Layout string `json:"layout"`           // Required: vid-layout name, e.g. 2x2 or group:grid
+	Canvas int    `json:"canvas,omitempty"` // Optional: canvas id for multi-canvas conferences
+}
+
+type ConferenceFloorRequest struct {
+	MemberID int  `json:"member_id,omitempty"` // Member to give the video floor to
+	Force    bool `json:"force,omitempty"`     // Keep the floor on the member until it is cleared
+	Clear    bool `json:"clear,omitempty"`     // Release the floor to the active speaker
+}
+
+type HeartbeatRequest struct {
+	IntervalSec int  `json:"interval_sec,omitempty"` // Optional: heartbeat interval (default 60)
+	Disable     bool `json:"disable,omitempty"`      // Optional: turn the heartbeat off
+}
+
+func (req *HeartbeatRequest) applyDefaults() {
+	if req.IntervalSec == 0 {
+		req.IntervalSec = 60
+	}
+}
+
+type WebhookCreateRequest struct {
+	URL      string   `json:"url"`                // Required: http(s) callback URL
+	Events   []string `json:"events,omitempty"`   // Optional: event filter, "call.*" wildcards allowed
+	Contexts []string `json:"contexts,omitempty"` // Optional: context filter (required for restricted access)
+	Secret   string   `json:"secret,omitempty"`   // Optional: HMAC-SHA256 signing secret
+}
+
+type SessionCreateRequest struct {
+	Scopes   []string `json:"scopes"`             // Required: e.g. ["calls:read", "events:read"]
+	Contexts []string `json:"contexts,omitempty"` // Optional: contexts the session may access (default: the caller's)
+	TTLSec   int      `json:"ttl_sec,omitempty"`  // Optional: lifetime (default 900, max FSAPI_SESSION_MAX_TTL)
+}
+
+type SofiaAliasRequest struct {
+	Domain string `json:"domain"` // Required: domain to alias to the profile
+}
+
+// CaptureRequest enables per-call tracing for a bounded duration
+type CaptureRequest struct {
+	DurationSec int    `json:"duration_sec,omitempty"` // Default 60, max 600
+	Media       *bool  `json:"media,omitempty"`        // uuid_debug_media (default true)
+	SIP         string `json:"sip,omitempty"`          // none (default), siptrace or capture
+}
+
+// MetaResponse is returned by GET /v1/meta for feature detection
+type MetaResponse struct {
+	Status   string       `json:"status"`
+	Build    MetaBuild    `json:"build"`
+	Mode     string       `json:"mode"` // live or mock
+	Features MetaFeatures `json:"features"`
+	Limits   MetaLimits   `json:"limits"`
+}
+
+type MetaBuild struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"git_commit"` // "unknown" when built without VCS information
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+// MetaFeatures lists optional subsystems and whether this server runs them
+type MetaFeatures struct {
+	Events              bool     `json:"events"`    // FreeSWITCH event stream (/v1/events, SSE)
+	CDRStore            bool     `json:"cdr_store"` // CDRs recorded from hangup events
+	Webhooks            bool     `json:"webhooks"`
+	GraphQL             bool     `json:"graphql"`
+	XMLCurl             []string `json:"xml_curl"` // Sections served; empty when disabled
+	QueueProvisioning   bool     `json:"queue_provisioning"`
+	UserProvisioning    bool     `json:"user_provisioning"`
+	SofiaAliases        bool     `json:"sofia_aliases"`
+	Media               bool     `json:"media"`
+	TTSCache            bool     `json:"tts_cache"`
+	DIDDialplan         bool     `json:"did_dialplan"`
+	LogStream           bool     `json:"log_stream"`
+	Watchdog            bool     `json:"watchdog"`
+	BearerTokens        bool     `json:"bearer_tokens"`
+	RequestSigning      bool     `json:"request_signing"`
+	SessionTokens       bool     `json:"session_tokens"`
+	AuthorizationPolicy bool     `json:"authorization_policy"`
+	MultiNode           bool     `json:"multi_node"` // Always false: one fs-api serves one FreeSWITCH
+	Store               string   `json:"store"`      // Storage driver: sqlite, postgres or file
+	Extensions          []string `json:"extensions"` // Names mounted under /v1/ext
+}
+
+// MetaLimits are the limits in effect
+type MetaLimits struct {
+	BodyBytes          map[string]int64 `json:"body_bytes"` // Per FSAPI_BODY_LIMITS route class, "*" for the rest
+	CDRRetention       int              `json:"cdr_retention"`
+	EventBuffer        int              `json:"event_buffer"`
+	SessionMaxTTLSec   int              `json:"session_max_ttl_sec"`
+	SignatureWindowSec int              `json:"signature_window_sec"`
+	AuthMaxFailures    int              `json:"auth_max_failures"` // 0 when lockouts are disabled
+	DrainTimeoutSec    int              `json:"drain_timeout_sec"`
+	ReadTimeoutSec     int              `json:"read_timeout_sec"`
+	WriteTimeoutSec    int              `json:"write_timeout_sec"`
 }
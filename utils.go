@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 
 	"github.com/google/uuid"
@@ -18,6 +19,18 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// splitCSV splits a comma-separated configuration value, trimming whitespace
+// and dropping empty entries
+func splitCSV(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if trimmed := strings.TrimSpace(item); trimmed != "" {
+			items = append(items, trimmed)
+		}
+	}
+	return items
+}
+
 // UUID Validation
 func validateUUID(uuidStr string) error {
 	if _, err := uuid.Parse(uuidStr); err != nil {
@@ -26,6 +39,56 @@ func validateUUID(uuidStr string) error {
 	return nil
 }
 
+// isValidChannelVarName reports whether name is safe to use as a FreeSWITCH
+// channel variable name in an ESL command
+func isValidChannelVarName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !(c == '_' || c == '-' || c == '.' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	// Names fs-api passes to FreeSWITCH as a single command argument: sofia,
+	// LCR, voicemail and conference profiles, gateways, valet lots
+	namePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+	// Phone numbers in E.164 form, with or without the leading '+'
+	e164Pattern = regexp.MustCompile(`^\+?[0-9]{1,15}$`)
+)
+
+// isValidName reports whether name is safe to use as a profile, gateway or
+// lot name in an ESL command
+func isValidName(name string) bool {
+	return namePattern.MatchString(name)
+}
+
+// Characters that end or expand a value where fs-api places it in an ESL
+// command, for checkESLArg
+const (
+	// One application argument or file: whitespace splits it, quotes, '$',
+	// braces and parentheses change how FreeSWITCH parses it
+	eslArgSeparators = " \t'\"${}()"
+	// A value inside an originate {var=value,...} or [var=value,...] block
+	eslVarValueSeparators = "'\",{}[]"
+)
+
+// checkESLArg rejects a value that could escape its place in an ESL
+// command: a line break, which ends the command, or one of separators
+func checkESLArg(field, value, separators string) error {
+	for _, c := range value {
+		if c == '\n' || c == '\r' || strings.ContainsRune(separators, c) {
+			return fmt.Errorf("%s must not contain %q", field, c)
+		}
+	}
+	return nil
+}
+
 // Path Validation for recording filenames
 func validateFilePath(path string) error {
 	if path == "" {
@@ -71,3 +134,13 @@ func logError(requestID, message string, err error) {
 func logWarn(requestID, message string) {
 	log.Printf("[WARN] [%s] %s", requestID, message)
 }
+
+// containsString reports whether list contains v
+func containsString(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// defaultValetLot is the park lot of calls without a context
+const defaultValetLot = "default"
+
+var parkSlotPattern = regexp.MustCompile(`^[0-9]{1,6}$`)
+
+// ParkSlot is an occupied slot of a mod_valet_parking lot
+type ParkSlot struct {
+	Lot            string `json:"lot"`
+	Slot           string `json:"slot"`
+	UUID           string `json:"uuid"`
+	CallerIDName   string `json:"caller_id_name,omitempty"`
+	CallerIDNumber string `json:"caller_id_number,omitempty"`
+}
+
+// valetInfo is the "valet_info [lot]" reply
+type valetInfo struct {
+	Lots []struct {
+		Name       string `xml:"name,attr"`
+		Extensions []struct {
+			UUID string `xml:"uuid,attr"`
+			Slot string `xml:",chardata"`
+		} `xml:"extension"`
+	} `xml:"lot"`
+}
+
+// valetLot returns the park lot of a context. Each context parks in its own
+// lot, so tenants cannot see or pick up each other's slots.
+func valetLot(callContext string) string {
+	if callContext == "" {
+		return defaultValetLot
+	}
+	return callContext
+}
+
+// parkSlots returns the occupied slots of lot, or of every lot when lot is
+// empty, with the parked callers' caller ID
+func (h *APIHandler) parkSlots(lot string) ([]ParkSlot, error) {
+	response, err := h.eslClient.SendCommand(strings.TrimSpace("api valet_info " + lot))
+	if err != nil {
+		return nil, err
+	}
+	var info valetInfo
+	if err := xml.Unmarshal([]byte(response), &info); err != nil {
+		return nil, fmt.Errorf("failed to parse valet_info output: %v", err)
+	}
+
+	slots := []ParkSlot{}
+	for _, l := range info.Lots {
+		for _, ext := range l.Extensions {
+			slots = append(slots, ParkSlot{Lot: l.Name, Slot: strings.TrimSpace(ext.Slot), UUID: ext.UUID})
+		}
+	}
+	if len(slots) == 0 {
+		return slots, nil
+	}
+
+	// Caller ID for all slots from a single channel listing
+	response, err = h.eslClient.SendCommand("api show channels as json")
+	if err != nil {
+		return nil, err
+	}
+	var channels struct {
+		Rows []map[string]interface{} `json:"rows"`
+	}
+	if err := json.Unmarshal([]byte(response), &channels); err != nil {
+		return nil, fmt.Errorf("failed to parse channels: %v", err)
+	}
+	byUUID := make(map[string]map[string]interface{}, len(channels.Rows))
+	for _, row := range channels.Rows {
+		if uuid, _ := row["uuid"].(string); uuid != "" {
+			byUUID[uuid] = row
+		}
+	}
+	for i := range slots {
+		if row, ok := byUUID[slots[i].UUID]; ok {
+			slots[i].CallerIDName, _ = row["cid_name"].(string)
+			slots[i].CallerIDNumber, _ = row["cid_num"].(string)
+		}
+	}
+	return slots, nil
+}
+
+// findParkSlot returns the call parked in slot of lot, or nil
+func (h *APIHandler) findParkSlot(lot, slot string) (*ParkSlot, error) {
+	slots, err := h.parkSlots(lot)
+	if err != nil {
+		return nil, err
+	}
+	for i := range slots {
+		if slots[i].Lot == lot && slots[i].Slot == slot {
+			return &slots[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// parkInSlot moves a call into a numbered slot of its context's lot, for
+// POST /v1/calls/{uuid}/park?slot=
+func (h *APIHandler) parkInSlot(w http.ResponseWriter, r *http.Request, callUUID, callContext, slot string) {
+	if !parkSlotPattern.MatchString(slot) {
+		h.respondError(w, r, "slot must be a number of up to 6 digits", http.StatusBadRequest)
+		return
+	}
+	lot := valetLot(callContext)
+	if !isValidName(lot) {
+		h.respondError(w, r, fmt.Sprintf("The call's context %q cannot be used as a park lot", lot), http.StatusBadRequest)
+		return
+	}
+
+	// mod_valet_parking would bridge the call to whoever occupies the slot
+	parked, err := h.findParkSlot(lot, slot)
+	if err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to read park slots: %v", err), err)
+		return
+	}
+	if parked != nil {
+		h.respondError(w, r, fmt.Sprintf("Park slot %s in lot %s is occupied", slot, lot), http.StatusConflict)
+		return
+	}
+
+	h.cancelParkRecall(callUUID)
+	cmd := fmt.Sprintf("api uuid_transfer %s 'valet_park:%s %s' %s", callUUID, lot, slot, dialplanInline)
+	result, err := h.runCallAction(callUUID, "park", cmd, map[string]interface{}{"lot": lot, "slot": slot})
+	if err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to park call: %v", err), err)
+		return
+	}
+	h.respondAction(w, r, fmt.Sprintf("Call %s parked in slot %s of lot %s", callUUID, slot, lot), result)
+}
+
+// GET /v1/park-slots[?lot=]
+func (h *APIHandler) ListParkSlots(w http.ResponseWriter, r *http.Request) {
+	lot := r.URL.Query().Get("lot")
+	if lot != "" {
+		if !isValidName(lot) {
+			h.respondError(w, r, "invalid lot name", http.StatusBadRequest)
+			return
+		}
+		if !h.validateCCDomainRaw(w, r, lot, "Park lot") {
+			return
+		}
+	}
+
+	slots, err := h.parkSlots(lot)
+	if err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to read park slots: %v", err), err)
+		return
+	}
+	if !isUnrestrictedAccess(r) {
+		allowed := getAllowedContexts(r)
+		visible := []ParkSlot{}
+		for _, s := range slots {
+			for _, ctx := range allowed {
+				if s.Lot == ctx {
+					visible = append(visible, s)
+					break
+				}
+			}
+		}
+		slots = visible
+	}
+	h.respondJSON(w, r, map[string]interface{}{
+		"status":    "success",
+		"row_count": len(slots),
+		"rows":      slots,
+	})
+}
+
+// POST /v1/park-slots/{slot}/retrieve
+func (h *APIHandler) RetrieveParkSlot(w http.ResponseWriter, r *http.Request) {
+	slot := mux.Vars(r)["slot"]
+	if !parkSlotPattern.MatchString(slot) {
+		h.respondError(w, r, "slot must be a number of up to 6 digits", http.StatusBadRequest)
+		return
+	}
+
+	var req ParkSlotRetrieveRequest
+	if !h.decodeRequest(w, r, &req) {
+		return
+	}
+	user, domain, ok := strings.Cut(req.User, "@")
+	if !ok || !userIDPattern.MatchString(user) || !domainPattern.MatchString(domain) {
+		h.respondError(w, r, "user must be a directory user as user@domain", http.StatusBadRequest)
+		return
+	}
+	lot := req.Lot
+	if lot == "" {
+		lot = domain
+	}
+	if !isValidName(lot) {
+		h.respondError(w, r, "invalid lot name", http.StatusBadRequest)
+		return
+	}
+	if !h.validateCCDomainRaw(w, r, lot, "Park lot") || !h.validateCCDomainRaw(w, r, domain, "User") {
+		return
+	}
+
+	parked, err := h.findParkSlot(lot, slot)
+	if err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to read park slots: %v", err), err)
+		return
+	}
+	if parked == nil {
+		h.respondErrorBody(w, r, ErrorResponse{
+			Status:  "error",
+			Message: fmt.Sprintf("Park slot %s in lot %s is empty", slot, lot),
+			Code:    ErrCodeNotFound,
+		}, http.StatusNotFound)
+		return
+	}
+
+	// The parked call rings the user and is bridged when they answer
+	cmd := fmt.Sprintf("api uuid_transfer %s bridge:user/%s@%s %s", parked.UUID, user, domain, dialplanInline)
+	result, err := h.runCallAction(parked.UUID, "retrieve", cmd, map[string]interface{}{"lot": lot, "slot": slot, "user": req.User})
+	if err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to retrieve parked call: %v", err), err)
+		return
+	}
+	h.respondAction(w, r, fmt.Sprintf("Call %s in slot %s of lot %s sent to %s", parked.UUID, slot, lot, req.User), result)
+}
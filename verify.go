@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	verifyDefaultRepeat     = 2
+	verifyMaxRepeat         = 5
+	verifyDefaultTimeoutSec = 45
+
+	verifyDefaultText    = "Your verification code is {code}."
+	verifyDefaultConfirm = "Press {digit} to confirm."
+	verifyRedactedText   = "(hidden: holds the code)" // Shown instead of the spoken text
+)
+
+// Verification call delivery statuses
+const (
+	verifyStatusCalling     = "calling"     // Ringing, or speaking the code
+	verifyStatusDelivered   = "delivered"   // Code spoken to the end
+	verifyStatusConfirmed   = "confirmed"   // Code spoken and confirm_digit pressed
+	verifyStatusUnconfirmed = "unconfirmed" // Code spoken, but confirm_digit not pressed
+	verifyStatusNoAnswer    = "no_answer"   // Rang but was not answered in time
+	verifyStatusHungUp      = "hung_up"     // Answered, then hung up before the code was spoken
+	verifyStatusFailed      = "failed"      // The call could not be placed, or fs-api shut down
+	verifyStatusCanceled    = "canceled"    // Canceled through POST /v1/flows/{id}/cancel
+)
+
+// verifyCodePattern matches the codes a verification call speaks
+var verifyCodePattern = regexp.MustCompile(`^[0-9]{3,10}$`)
+
+// Steps of the flow behind a verification call
+const (
+	verifyStepOriginate = iota
+	verifyStepAnswer
+	verifyStepSpeak
+	verifyStepConfirm // Only with confirm_digit
+)
+
+// VerifyCallRequest is the body of POST /v1/verify/call
+type VerifyCallRequest struct {
+	Context        string       `json:"context"`
+	Endpoint       *DialTarget  `json:"endpoint"`
+	CallerIDNumber string       `json:"caller_id_number,omitempty"`
+	CallerIDName   string       `json:"caller_id_name,omitempty"`
+	Code           string       `json:"code"`                    // 3 to 10 digits
+	TTS            *AnnounceTTS `json:"tts"`                     // text must hold {code}; default verifyDefaultText
+	Repeat         int          `json:"repeat,omitempty"`        // Times the text is spoken; default 2
+	ConfirmDigit   string       `json:"confirm_digit,omitempty"` // Digit the callee presses to confirm
+	ConfirmText    string       `json:"confirm_text,omitempty"`  // May hold {digit}; default verifyDefaultConfirm
+	TimeoutSec     int          `json:"timeout_sec,omitempty"`   // To answer; default 45
+}
+
+// VerifyCall is the delivery status of a verification call
+type VerifyCall struct {
+	ID        string     `json:"id"` // ID of the flow placing the call
+	Context   string     `json:"context"`
+	CallUUID  string     `json:"call_uuid"`
+	Status    string     `json:"status"`
+	Digit     string     `json:"digit,omitempty"` // Digit pressed when asked to confirm
+	Error     string     `json:"error,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+}
+
+// flow checks the request and builds the flow that places the call. Flow
+// step checks run when the flow starts.
+func (req *VerifyCallRequest) flow() (*FlowRequest, error) {
+	if req.Endpoint == nil {
+		return nil, fmt.Errorf("endpoint is required")
+	}
+	if !verifyCodePattern.MatchString(req.Code) {
+		return nil, fmt.Errorf("code must be 3 to 10 digits")
+	}
+	if req.TTS == nil || req.TTS.Engine == "" {
+		return nil, fmt.Errorf("tts.engine is required")
+	}
+	text := req.TTS.Text
+	if text == "" {
+		text = verifyDefaultText
+	}
+	if !strings.Contains(text, "{code}") {
+		return nil, fmt.Errorf("tts.text must contain {code}")
+	}
+	if err := checkTTS(&AnnounceTTS{Engine: req.TTS.Engine, Voice: req.TTS.Voice, Text: text}); err != nil {
+		return nil, err
+	}
+	if req.Repeat == 0 {
+		req.Repeat = verifyDefaultRepeat
+	}
+	if req.Repeat < 1 || req.Repeat > verifyMaxRepeat {
+		return nil, fmt.Errorf("repeat must be between 1 and %d", verifyMaxRepeat)
+	}
+	if req.ConfirmDigit == "" && req.ConfirmText != "" {
+		return nil, fmt.Errorf("confirm_text is only used with confirm_digit")
+	}
+	if req.ConfirmDigit != "" && (len(req.ConfirmDigit) != 1 || req.ConfirmDigit[0] < '0' || req.ConfirmDigit[0] > '9') {
+		return nil, fmt.Errorf("confirm_digit must be a single digit 0-9")
+	}
+	if strings.ContainsAny(req.ConfirmText, "\n\r") {
+		return nil, fmt.Errorf("confirm_text must be a single line")
+	}
+	if req.TimeoutSec == 0 {
+		req.TimeoutSec = verifyDefaultTimeoutSec
+	}
+
+	// Spaced digits are read one by one rather than as a number
+	code := strings.Join(strings.Split(req.Code, ""), " ")
+	message := strings.ReplaceAll(text, "{code}", code)
+	spoken := make([]string, req.Repeat)
+	for i := range spoken {
+		spoken[i] = message
+	}
+
+	steps := []FlowStep{
+		{Action: flowActionOriginate, Endpoint: req.Endpoint, CallerIDNumber: req.CallerIDNumber, CallerIDName: req.CallerIDName, TimeoutSec: req.TimeoutSec},
+		{Action: flowActionWaitAnswer, TimeoutSec: req.TimeoutSec},
+		{Action: flowActionSpeak, TTS: &AnnounceTTS{Engine: req.TTS.Engine, Voice: req.TTS.Voice, Text: strings.Join(spoken, " ")}},
+	}
+	if req.ConfirmDigit != "" {
+		confirm := req.ConfirmText
+		if confirm == "" {
+			confirm = verifyDefaultConfirm
+		}
+		steps = append(steps, FlowStep{
+			Action: flowActionCollect,
+			TTS:    &AnnounceTTS{Engine: req.TTS.Engine, Voice: req.TTS.Voice, Text: strings.ReplaceAll(confirm, "{digit}", req.ConfirmDigit)},
+			Digits: req.ConfirmDigit,
+		})
+	}
+	steps = append(steps, FlowStep{Action: flowActionHangup})
+	return &FlowRequest{Context: req.Context, Steps: steps, verify: true}, nil
+}
+
+// verifyCall derives the delivery status of a verification call from the
+// results of its flow
+func verifyCall(flow Flow) VerifyCall {
+	v := VerifyCall{
+		ID:        flow.ID,
+		Context:   flow.Context,
+		CallUUID:  flow.CallUUID,
+		Error:     flow.Error,
+		CreatedAt: flow.CreatedAt,
+		EndedAt:   flow.EndedAt,
+	}
+	completed := func(step int) bool {
+		return len(flow.Results) > step && flow.Results[step].Status == flowStatusCompleted
+	}
+	confirm := len(flow.Steps) > verifyStepConfirm && flow.Steps[verifyStepConfirm].Action == flowActionCollect
+	if confirm && len(flow.Results) > verifyStepConfirm {
+		v.Digit = flow.Results[verifyStepConfirm].Digit
+	}
+
+	switch {
+	case flow.Status == flowStatusRunning:
+		v.Status = verifyStatusCalling
+	case flow.Status == flowStatusCanceled:
+		v.Status = verifyStatusCanceled
+	case !completed(verifyStepAnswer):
+		v.Status = verifyStatusFailed
+		if completed(verifyStepOriginate) && flow.Status == flowStatusFailed {
+			v.Status = verifyStatusNoAnswer
+		}
+	case !completed(verifyStepSpeak):
+		v.Status = verifyStatusFailed
+		if len(flow.Results) > verifyStepSpeak && strings.HasPrefix(flow.Results[verifyStepSpeak].Error, "call hung up") {
+			v.Status = verifyStatusHungUp
+		}
+	case confirm && completed(verifyStepConfirm):
+		v.Status = verifyStatusConfirmed
+	case confirm:
+		v.Status = verifyStatusUnconfirmed
+	default:
+		v.Status = verifyStatusDelivered
+	}
+	return v
+}
+
+// verifyEnded reports how a verification call ended
+func (m *flowManager) verifyEnded(flow Flow) {
+	v := verifyCall(flow)
+	m.verified.inc(v.Status)
+	if flow.Status != flowStatusInterrupted {
+		m.h.webhooks.dispatch("verify."+v.Status, v.Context, v)
+	}
+}
+
+// --- Verification call handlers ---
+
+// POST /v1/verify/call
+//
+// The call runs as a flow, so the checks of POST /v1/calls/originate apply
+// and POST /v1/flows/{id}/cancel stops it.
+func (h *APIHandler) CreateVerifyCall(w http.ResponseWriter, r *http.Request) {
+	if !h.flowsEnabled(w, r) {
+		return
+	}
+	var req VerifyCallRequest
+	if !h.decodeRequest(w, r, &req) {
+		return
+	}
+	flowReq, err := req.flow()
+	if err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flow, ok := h.startFlow(w, r, flowReq, "")
+	if !ok {
+		return
+	}
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   verifyCall(flow),
+	})
+}
+
+// GET /v1/verify/call/{id}
+func (h *APIHandler) GetVerifyCall(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	h.flows.mu.Lock()
+	var flow Flow
+	run, ok := h.flows.runs[id]
+	if ok {
+		flow = run.snapshot()
+	}
+	h.flows.mu.Unlock()
+	if !ok || !flow.Verify || !isContextAllowed(r, flow.Context) {
+		h.respondError(w, r, fmt.Sprintf("Verification call %s not found", id), http.StatusNotFound)
+		return
+	}
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   verifyCall(flow),
+	})
+}
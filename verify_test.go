@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVerifyFlowSnapshotHidesCode(t *testing.T) {
+	req := &VerifyCallRequest{
+		Context:  "acme.example",
+		Endpoint: &DialTarget{Type: dialTypeUser, User: "1000", Domain: "acme.example"},
+		Code:     "482913",
+		TTS:      &AnnounceTTS{Engine: "flite", Voice: "kal"},
+	}
+	freq, err := req.flow()
+	if err != nil {
+		t.Fatalf("flow() = %v", err)
+	}
+	if text := freq.Steps[verifyStepSpeak].TTS.Text; !strings.Contains(text, "4 8 2 9 1 3") {
+		t.Fatalf("speak text = %q, want it to hold the code", text)
+	}
+
+	run := newFlowRun(&Flow{ID: "f1", Context: freq.Context, Verify: true, Steps: freq.Steps})
+	flow := run.snapshot()
+	if text := flow.Steps[verifyStepSpeak].TTS.Text; text != verifyRedactedText {
+		t.Errorf("snapshot speak text = %q, want %q", text, verifyRedactedText)
+	}
+	// The running flow still speaks the code
+	if text := run.flow.Steps[verifyStepSpeak].TTS.Text; !strings.Contains(text, "4 8 2 9 1 3") {
+		t.Errorf("flow speak text changed to %q", text)
+	}
+
+	// Other flows are shown as they are
+	plain := newFlowRun(&Flow{ID: "f2", Context: freq.Context, Steps: freq.Steps})
+	if text := plain.snapshot().Steps[verifyStepSpeak].TTS.Text; text == verifyRedactedText {
+		t.Error("snapshot of a flow that is not a verification call was redacted")
+	}
+}
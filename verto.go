@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// VertoClient is one WebSocket connection from "verto status"
+type VertoClient struct {
+	Profile    string `json:"profile"`
+	Login      string `json:"login"`  // user@domain, empty before login
+	Remote     string `json:"remote"` // host:port of the browser
+	Transport  string `json:"transport"`
+	Registered bool   `json:"registered"`
+}
+
+// parseVertoStatus parses the client rows of "verto status":
+//
+//	default-v4	client	wss://1000@example.com@10.0.0.9:53412	CONN_REG (WSS)
+func parseVertoStatus(response string) []*VertoClient {
+	clients := []*VertoClient{}
+	for _, line := range strings.Split(response, "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 4 || strings.TrimSpace(fields[1]) != "client" {
+			continue
+		}
+		data := strings.TrimSpace(fields[2])
+		state := strings.TrimSpace(fields[3])
+
+		client := &VertoClient{Profile: strings.TrimSpace(fields[0])}
+		if i := strings.Index(data, "://"); i >= 0 {
+			client.Transport = strings.ToUpper(data[:i])
+			data = data[i+3:]
+		}
+		// The login itself contains '@', the remote address follows the last one
+		if i := strings.LastIndex(data, "@"); i >= 0 {
+			client.Login, client.Remote = data[:i], data[i+1:]
+		} else {
+			client.Remote = data
+		}
+		client.Registered = strings.HasPrefix(state, "CONN_REG")
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		if clients[i].Login != clients[j].Login {
+			return clients[i].Login < clients[j].Login
+		}
+		return clients[i].Remote < clients[j].Remote
+	})
+	return clients
+}
+
+// vertoClients returns the connected clients visible to the caller
+func (h *APIHandler) vertoClients(r *http.Request) ([]*VertoClient, error) {
+	response, err := h.eslClient.SendCommand("api verto status")
+	if err != nil {
+		return nil, err
+	}
+	visible := []*VertoClient{}
+	for _, client := range parseVertoStatus(response) {
+		// Clients that have not logged in belong to no context
+		if client.Login == "" && !isUnrestrictedAccess(r) {
+			continue
+		}
+		if client.Login != "" && !isContextAllowed(r, extractDomain(client.Login)) {
+			continue
+		}
+		visible = append(visible, client)
+	}
+	return visible, nil
+}
+
+// GET /v1/verto/clients
+func (h *APIHandler) ListVertoClients(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.vertoClients(r)
+	if err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to retrieve verto status: %v", err), err)
+		return
+	}
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status":    "success",
+		"row_count": len(rows),
+		"rows":      rows,
+	})
+}
+
+// DELETE /v1/verto/clients/{login}
+//
+// FreeSWITCH has no command to close a single verto WebSocket, so this hangs
+// up the calls of the client's login instead.
+func (h *APIHandler) DisconnectVertoClient(w http.ResponseWriter, r *http.Request) {
+	login := mux.Vars(r)["login"]
+	if !h.validateCCDomain(w, r, login, "Verto client") {
+		return
+	}
+
+	clients, err := h.vertoClients(r)
+	if err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to retrieve verto status: %v", err), err)
+		return
+	}
+	connected := false
+	for _, client := range clients {
+		connected = connected || client.Login == login
+	}
+	if !connected {
+		h.respondError(w, r, fmt.Sprintf("Verto client %s not found", login), http.StatusNotFound)
+		return
+	}
+
+	response, err := h.eslClient.SendCommand("api show channels as json")
+	if err != nil {
+		h.respondESLError(w, r, fmt.Sprintf("Failed to retrieve channels: %v", err), err)
+		return
+	}
+	var channels struct {
+		Rows []map[string]string `json:"rows"`
+	}
+	if err := json.Unmarshal([]byte(response), &channels); err != nil {
+		h.respondError(w, r, fmt.Sprintf("Failed to parse channels: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	hungUp := []string{}
+	for _, ch := range channels.Rows {
+		if !strings.HasPrefix(ch["name"], "verto.rtc/") || ch["presence_id"] != login {
+			continue
+		}
+		cause := h.defaults.hangupCauseFor(extractDomain(login))
+		if _, err := h.eslClient.SendCommand(fmt.Sprintf("api uuid_kill %s %s", ch["uuid"], cause)); err != nil {
+			logWarn(getRequestID(r), fmt.Sprintf("Failed to hang up verto call %s: %v", ch["uuid"], err))
+			continue
+		}
+		hungUp = append(hungUp, ch["uuid"])
+	}
+
+	logInfo(getRequestID(r), fmt.Sprintf("Verto client %s: %d call(s) hung up", login, len(hungUp)))
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data": map[string]interface{}{
+			"login":         login,
+			"calls_hung_up": hungUp,
+		},
+	})
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// dialplanInline is the dialplan that runs applications given in place of
+// the extension
+const dialplanInline = "inline"
+
+var voicemailExtensionPattern = regexp.MustCompile(`^[A-Za-z0-9*#+_.-]*\{user\}[A-Za-z0-9*#+_.-]*$`)
+
+func checkVoicemailProfile(v string) error {
+	if !isValidName(v) {
+		return fmt.Errorf("%q is not a profile name", v)
+	}
+	return nil
+}
+
+func checkVoicemailExtension(v string) error {
+	if v != "" && !voicemailExtensionPattern.MatchString(v) {
+		return fmt.Errorf("%q must be an extension containing {user}, e.g. *99{user}", v)
+	}
+	return nil
+}
+
+// voicemailTransfer returns the uuid_transfer destination, dialplan and
+// context that send a call to the mailbox user@domain: the extension
+// FSAPI_VOICEMAIL_EXTENSION in the domain's XML context when it is set, or
+// else the voicemail application run inline
+func voicemailTransfer(mailbox string) (dest, dialplan, context string, err error) {
+	user, domain, ok := strings.Cut(mailbox, "@")
+	if !ok || !userIDPattern.MatchString(user) || !domainPattern.MatchString(domain) {
+		return "", "", "", fmt.Errorf("to_voicemail must be a mailbox as user@domain")
+	}
+	if FSAPI_VOICEMAIL_EXTENSION != "" {
+		return strings.ReplaceAll(FSAPI_VOICEMAIL_EXTENSION, "{user}", user), "XML", domain, nil
+	}
+	// Quoted so uuid_transfer keeps the application's arguments together
+	return fmt.Sprintf("'answer,voicemail:%s %s %s'", FSAPI_VOICEMAIL_PROFILE, domain, user), dialplanInline, "", nil
+}
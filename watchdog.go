@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Watchdog actions for calls exceeding their max duration
+const (
+	watchdogActionFlag   = "flag"
+	watchdogActionHangup = "hangup"
+)
+
+// callWatchdog periodically scans active calls and flags or hangs up calls
+// that exceed the max duration configured for their context. A
+// call.watchdog.warning webhook is sent warnBefore ahead of the cutoff and
+// call.watchdog.exceeded when it is reached.
+type callWatchdog struct {
+	h          *APIHandler
+	limits     map[string]time.Duration // context -> max duration, "*" = default
+	action     string
+	warnBefore time.Duration
+	interval   time.Duration
+
+	warned   map[string]bool
+	exceeded map[string]bool
+}
+
+// parseContextDurations parses "ctx=seconds,*=seconds" into a lookup map
+func parseContextDurations(value string) (map[string]time.Duration, error) {
+	limits := make(map[string]time.Duration)
+	for _, entry := range splitCSV(value) {
+		ctx, secStr, ok := strings.Cut(entry, "=")
+		ctx = strings.TrimSpace(ctx)
+		if !ok || ctx == "" {
+			return nil, fmt.Errorf("invalid entry %q (expected context=seconds)", entry)
+		}
+		sec, err := strconv.Atoi(strings.TrimSpace(secStr))
+		if err != nil || sec <= 0 {
+			return nil, fmt.Errorf("invalid duration for %q: %q", ctx, secStr)
+		}
+		limits[ctx] = time.Duration(sec) * time.Second
+	}
+	return limits, nil
+}
+
+func newCallWatchdog(h *APIHandler, limits map[string]time.Duration, action string, warnBefore, interval time.Duration) *callWatchdog {
+	return &callWatchdog{
+		h:          h,
+		limits:     limits,
+		action:     action,
+		warnBefore: warnBefore,
+		interval:   interval,
+		warned:     make(map[string]bool),
+		exceeded:   make(map[string]bool),
+	}
+}
+
+// run scans calls every interval until shutdown starts
+func (wd *callWatchdog) run() {
+	ticker := time.NewTicker(wd.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			wd.check()
+		case <-wd.h.jobs.stopping():
+			return
+		}
+	}
+}
+
+func (wd *callWatchdog) limitFor(callContext string) (time.Duration, bool) {
+	if limit, ok := wd.limits[callContext]; ok {
+		return limit, true
+	}
+	limit, ok := wd.limits["*"]
+	return limit, ok
+}
+
+func (wd *callWatchdog) check() {
+	callsResponse, err := wd.h.eslClient.SendCommand("api show calls as json")
+	if err != nil {
+		log.Printf("Watchdog: failed to retrieve calls: %v", err)
+		return
+	}
+
+	var callsData struct {
+		Rows []map[string]interface{} `json:"rows"`
+	}
+	if err := json.Unmarshal([]byte(callsResponse), &callsData); err != nil {
+		log.Printf("Watchdog: failed to parse calls data: %v", err)
+		return
+	}
+
+	now := time.Now()
+	contextMap := wd.h.channelContextMap()
+	seen := make(map[string]bool, len(callsData.Rows))
+
+	for _, call := range callsData.Rows {
+		callUUID, _ := call["uuid"].(string)
+		createdStr, _ := call["created_epoch"].(string)
+		created, err := strconv.ParseInt(createdStr, 10, 64)
+		if callUUID == "" || err != nil {
+			continue
+		}
+		seen[callUUID] = true
+
+		callContext := resolveCallContext(call, contextMap)
+		limit, ok := wd.limitFor(callContext)
+		if !ok {
+			continue
+		}
+
+		age := now.Sub(time.Unix(created, 0))
+		data := map[string]interface{}{
+			"uuid":             callUUID,
+			"duration_sec":     int(age.Seconds()),
+			"max_duration_sec": int(limit.Seconds()),
+			"action":           wd.action,
+		}
+
+		switch {
+		case age >= limit && !wd.exceeded[callUUID]:
+			wd.exceeded[callUUID] = true
+			action := wd.action
+			if action == watchdogActionHangup && wd.isEmergency(callUUID) {
+				// Emergency calls are flagged and reported, never cut off
+				action = watchdogActionFlag
+				data["action"] = action
+				data["emergency"] = true
+			}
+			wd.enforce(callUUID, callContext, age, action)
+			wd.h.webhooks.dispatch("call.watchdog.exceeded", callContext, data)
+		case age >= limit-wd.warnBefore && age < limit && !wd.warned[callUUID]:
+			wd.warned[callUUID] = true
+			data["seconds_remaining"] = int((limit - age).Seconds())
+			wd.h.webhooks.dispatch("call.watchdog.warning", callContext, data)
+		}
+	}
+
+	// Forget calls that have ended
+	for callUUID := range wd.warned {
+		if !seen[callUUID] {
+			delete(wd.warned, callUUID)
+		}
+	}
+	for callUUID := range wd.exceeded {
+		if !seen[callUUID] {
+			delete(wd.exceeded, callUUID)
+		}
+	}
+}
+
+// isEmergency reports whether the call is marked with fsapi_emergency
+func (wd *callWatchdog) isEmergency(callUUID string) bool {
+	value, err := wd.h.eslClient.SendCommand(fmt.Sprintf("api uuid_getvar %s %s", callUUID, emergencyVar))
+	return err == nil && strings.TrimSpace(value) == "true"
+}
+
+func (wd *callWatchdog) enforce(callUUID, callContext string, age time.Duration, action string) {
+	var cmd string
+	if action == watchdogActionHangup {
+		cmd = fmt.Sprintf("api uuid_kill %s ALLOTTED_TIMEOUT", callUUID)
+	} else {
+		cmd = fmt.Sprintf("api uuid_setvar %s fsapi_watchdog_exceeded true", callUUID)
+	}
+	log.Printf("Watchdog: call %s in context '%s' exceeded max duration after %s (%s)",
+		callUUID, callContext, age.Round(time.Second), action)
+	if _, err := wd.h.eslClient.SendCommand(cmd); err != nil {
+		log.Printf("Watchdog: failed to %s call %s: %v", action, callUUID, err)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	webhookDeliveriesFile   = "webhook_deliveries.jsonl"
+	webhookDeliveryLogLimit = 5000
+)
+
+// Delivery states
+const (
+	webhookDeliveryPending   = "pending"
+	webhookDeliveryDelivered = "delivered"
+	webhookDeliveryFailed    = "failed"
+)
+
+var webhookDeliveryStates = []string{webhookDeliveryPending, webhookDeliveryDelivered, webhookDeliveryFailed}
+
+// WebhookAttempt is one POST of a delivery
+type WebhookAttempt struct {
+	Time       time.Time `json:"time"`
+	StatusCode int       `json:"status_code,omitempty"` // Absent when no response was received
+	LatencyMS  int64     `json:"latency_ms"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// WebhookDeliveryRecord is the logged history of one delivery. The payload
+// is kept for replays and left out of API responses.
+type WebhookDeliveryRecord struct {
+	ID          string           `json:"id"`
+	WebhookID   string           `json:"webhook_id"`
+	Event       string           `json:"event"`
+	PayloadHash string           `json:"payload_sha256"`
+	Payload     json.RawMessage  `json:"payload,omitempty"`
+	Status      string           `json:"status"`
+	Retries     int              `json:"retries"` // Manual replays
+	Attempts    []WebhookAttempt `json:"attempts"`
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+}
+
+// loadDeliveryLog reads webhook_deliveries.jsonl, where every state change
+// of a delivery appends its full record (the last line wins), and compacts
+// the file to the most recent webhookDeliveryLogLimit deliveries
+func (m *webhookManager) loadDeliveryLog() {
+	lines := 0
+	err := loadJSONLines(webhookDeliveriesFile, func(line []byte) {
+		var rec WebhookDeliveryRecord
+		if json.Unmarshal(line, &rec) != nil || rec.ID == "" {
+			return
+		}
+		lines++
+		if _, ok := m.deliveries[rec.ID]; !ok {
+			m.deliveryOrder = append(m.deliveryOrder, rec.ID)
+		}
+		m.deliveries[rec.ID] = &rec
+	})
+	if err != nil {
+		log.Printf("WARNING: Failed to load webhook delivery log: %v", err)
+	}
+	m.trimDeliveryLog()
+	if lines > len(m.deliveryOrder) {
+		err := rewriteJSONLines(webhookDeliveriesFile, len(m.deliveryOrder), func(i int) interface{} {
+			return m.deliveries[m.deliveryOrder[i]]
+		})
+		if err != nil {
+			log.Printf("WARNING: Failed to compact webhook delivery log: %v", err)
+		}
+	}
+}
+
+// trimDeliveryLog forgets the oldest deliveries beyond the limit. Caller
+// must hold mu.
+func (m *webhookManager) trimDeliveryLog() {
+	for len(m.deliveryOrder) > webhookDeliveryLogLimit {
+		delete(m.deliveries, m.deliveryOrder[0])
+		m.deliveryOrder = m.deliveryOrder[1:]
+	}
+}
+
+// logDelivery records a newly queued delivery, unless it is already
+// logged (a resumed or replayed delivery). Caller must hold mu.
+func (m *webhookManager) logDelivery(d *webhookDelivery) *WebhookDeliveryRecord {
+	if rec, ok := m.deliveries[d.ID]; ok {
+		return rec
+	}
+	sum := sha256.Sum256(d.Payload)
+	now := time.Now().UTC()
+	rec := &WebhookDeliveryRecord{
+		ID:          d.ID,
+		WebhookID:   d.WebhookID,
+		Event:       d.Event,
+		PayloadHash: hex.EncodeToString(sum[:]),
+		Payload:     d.Payload,
+		Status:      webhookDeliveryPending,
+		Attempts:    []WebhookAttempt{},
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	m.deliveries[rec.ID] = rec
+	m.deliveryOrder = append(m.deliveryOrder, rec.ID)
+	m.trimDeliveryLog()
+	return rec
+}
+
+// updateDelivery sets the delivery's status, adds attempt when it is not
+// nil, and appends the record to the log
+func (m *webhookManager) updateDelivery(d *webhookDelivery, status string, attempt *WebhookAttempt) {
+	m.mu.Lock()
+	rec := m.logDelivery(d)
+	rec.Status = status
+	if attempt != nil {
+		rec.Attempts = append(rec.Attempts, *attempt)
+	}
+	rec.UpdatedAt = time.Now().UTC()
+	snapshot := *rec
+	m.mu.Unlock()
+
+	m.appendDeliveryLog(&snapshot)
+}
+
+func (m *webhookManager) appendDeliveryLog(rec *WebhookDeliveryRecord) {
+	if err := appendJSONLine(webhookDeliveriesFile, rec); err != nil {
+		log.Printf("WARNING: Failed to log webhook delivery %s: %v", rec.ID, err)
+	}
+}
+
+// webhookNoResponse replaces the connection error of an attempt that got
+// no response when shown to a restricted caller, so the delivery log cannot
+// be used to probe which hosts and ports fs-api can reach
+const webhookNoResponse = "no response"
+
+// view copies the record without its payload. Unless showErrors is set,
+// connection errors are replaced by webhookNoResponse.
+func (rec *WebhookDeliveryRecord) view(showErrors bool) *WebhookDeliveryRecord {
+	v := *rec
+	v.Payload = nil
+	v.Attempts = append([]WebhookAttempt{}, rec.Attempts...)
+	if !showErrors {
+		for i := range v.Attempts {
+			if v.Attempts[i].StatusCode == 0 && v.Attempts[i].Error != "" {
+				v.Attempts[i].Error = webhookNoResponse
+			}
+		}
+	}
+	return &v
+}
+
+// visibleWebhook looks up the {id} webhook, writing a 404 when it does not
+// exist or the caller may not see it
+func (h *APIHandler) visibleWebhook(w http.ResponseWriter, r *http.Request) (*Webhook, bool) {
+	id := mux.Vars(r)["id"]
+
+	h.webhooks.mu.Lock()
+	hook, ok := h.webhooks.hooks[id]
+	h.webhooks.mu.Unlock()
+	if !ok || !webhookVisible(r, hook) {
+		h.respondError(w, r, fmt.Sprintf("Webhook %s not found", id), http.StatusNotFound)
+		return nil, false
+	}
+	return hook, true
+}
+
+// ListWebhookDeliveries handles GET /v1/webhooks/{id}/deliveries
+func (h *APIHandler) ListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	hook, ok := h.visibleWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status != "" && !containsString(webhookDeliveryStates, status) {
+		h.respondError(w, r, fmt.Sprintf("invalid status '%s': must be one of: pending, delivered, failed", status), http.StatusBadRequest)
+		return
+	}
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			h.respondError(w, r, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	h.webhooks.mu.Lock()
+	rows := []*WebhookDeliveryRecord{}
+	for _, id := range h.webhooks.deliveryOrder {
+		rec := h.webhooks.deliveries[id]
+		if rec.WebhookID == hook.ID && (status == "" || rec.Status == status) {
+			rows = append(rows, rec.view(isUnrestrictedAccess(r)))
+		}
+	}
+	h.webhooks.mu.Unlock()
+
+	// Most recent deliveries win when the limit applies
+	if len(rows) > limit {
+		rows = rows[len(rows)-limit:]
+	}
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status":    "success",
+		"row_count": len(rows),
+		"rows":      rows,
+	})
+}
+
+// RetryWebhookDelivery handles POST /v1/webhooks/{id}/deliveries/{delivery}/retry.
+// The replay keeps the delivery ID, so receivers can deduplicate on
+// X-FSAPI-Delivery.
+func (h *APIHandler) RetryWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	hook, ok := h.visibleWebhook(w, r)
+	if !ok {
+		return
+	}
+	deliveryID := mux.Vars(r)["delivery"]
+
+	h.webhooks.mu.Lock()
+	rec, ok := h.webhooks.deliveries[deliveryID]
+	if !ok || rec.WebhookID != hook.ID {
+		h.webhooks.mu.Unlock()
+		h.respondError(w, r, fmt.Sprintf("Delivery %s not found", deliveryID), http.StatusNotFound)
+		return
+	}
+	if _, pending := h.webhooks.pending[deliveryID]; pending {
+		h.webhooks.mu.Unlock()
+		h.respondError(w, r, fmt.Sprintf("Delivery %s is still pending", deliveryID), http.StatusConflict)
+		return
+	}
+	d := &webhookDelivery{
+		ID:        rec.ID,
+		WebhookID: rec.WebhookID,
+		Event:     rec.Event,
+		Payload:   rec.Payload,
+	}
+	rec.Status = webhookDeliveryPending
+	rec.Retries++
+	rec.UpdatedAt = time.Now().UTC()
+	h.webhooks.pending[d.ID] = d
+	snapshot := *rec
+	h.webhooks.mu.Unlock()
+
+	h.webhooks.appendDeliveryLog(&snapshot)
+	h.webhooks.startDelivery(d)
+
+	logInfo(getRequestID(r), fmt.Sprintf("Webhook %s delivery %s queued for retry", hook.ID, d.ID))
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   snapshot.view(isUnrestrictedAccess(r)),
+	})
+}
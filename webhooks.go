@@ -0,0 +1,486 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const (
+	webhooksFile        = "webhooks.json"
+	webhookPendingFile  = "webhook_pending.json"
+	webhookMaxAttempts  = 5
+	webhookRetryBackoff = 2 * time.Second
+)
+
+// Webhook is a registered HTTP callback for fs-api events
+type Webhook struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events,omitempty"`   // Empty = all events; "call.*" matches by prefix
+	Contexts  []string  `json:"contexts,omitempty"` // Empty = all contexts
+	Secret    string    `json:"secret,omitempty"`   // HMAC-SHA256 signing secret
+	CreatedAt time.Time `json:"created_at"`
+	// Registered by a restricted caller: delivered to public addresses only
+	Restricted bool `json:"restricted,omitempty"`
+}
+
+// WebhookEvent is the JSON envelope POSTed to webhook URLs
+type WebhookEvent struct {
+	ID        string      `json:"id"`
+	Event     string      `json:"event"`
+	Context   string      `json:"context,omitempty"`
+	Timestamp time.Time   `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+// webhookDelivery is one event queued for one webhook
+type webhookDelivery struct {
+	ID        string          `json:"id"`
+	WebhookID string          `json:"webhook_id"`
+	Event     string          `json:"event"`
+	Payload   json.RawMessage `json:"payload"`
+	Attempts  int             `json:"attempts"`
+}
+
+type webhookManager struct {
+	mu            sync.Mutex
+	hooks         map[string]*Webhook
+	pending       map[string]*webhookDelivery
+	deliveries    map[string]*WebhookDeliveryRecord
+	deliveryOrder []string // Oldest first
+	jobs          *jobTracker
+	client        *http.Client
+	publicClient  *http.Client // For restricted webhooks: refuses internal addresses
+}
+
+// errWebhookAddress is returned when a restricted webhook's host resolves
+// to an address tenants may not reach
+var errWebhookAddress = fmt.Errorf("address not allowed for this webhook")
+
+// publicWebhookAddress reports whether a restricted webhook may connect to
+// addr: not loopback, private, link-local, multicast or unspecified
+func publicWebhookAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsValid() && addr.IsGlobalUnicast() && !addr.IsPrivate() &&
+		!netip.MustParsePrefix("100.64.0.0/10").Contains(addr) // Carrier-grade NAT
+}
+
+// newPublicWebhookClient returns a client that checks every address it
+// connects to, after DNS resolution and on each redirect, so a webhook
+// cannot be pointed at fs-api, FreeSWITCH or other internal services.
+// It ignores proxy settings, which would hide the real destination.
+func newPublicWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			ap, err := netip.ParseAddrPort(address)
+			if err != nil || !publicWebhookAddress(ap.Addr()) {
+				return errWebhookAddress
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: 10 * time.Second},
+	}
+}
+
+// newWebhookManager loads registered webhooks and any deliveries left
+// undelivered by the previous shutdown, and resumes those deliveries
+func newWebhookManager(jobs *jobTracker) *webhookManager {
+	m := &webhookManager{
+		hooks:        make(map[string]*Webhook),
+		pending:      make(map[string]*webhookDelivery),
+		deliveries:   make(map[string]*WebhookDeliveryRecord),
+		jobs:         jobs,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		publicClient: newPublicWebhookClient(),
+	}
+	m.loadDeliveryLog()
+
+	var hooks []*Webhook
+	if err := loadJSONFile(webhooksFile, &hooks); err != nil {
+		log.Printf("WARNING: Failed to load webhooks: %v", err)
+	}
+	for _, hook := range hooks {
+		m.hooks[hook.ID] = hook
+	}
+
+	var pending []*webhookDelivery
+	if err := loadJSONFile(webhookPendingFile, &pending); err != nil {
+		log.Printf("WARNING: Failed to load pending webhook deliveries: %v", err)
+	}
+	if len(pending) > 0 {
+		log.Printf("Resuming %d undelivered webhook(s)", len(pending))
+		removeJSONFile(webhookPendingFile)
+	}
+	for _, d := range pending {
+		m.pending[d.ID] = d
+		m.logDelivery(d)
+		m.startDelivery(d)
+	}
+
+	jobs.onShutdown("webhooks", m.persistPending)
+	return m
+}
+
+// matches reports whether the webhook subscribes to event in callContext
+func (hook *Webhook) matches(event, callContext string) bool {
+	if len(hook.Contexts) > 0 {
+		found := false
+		for _, ctx := range hook.Contexts {
+			if ctx == callContext {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(hook.Events) == 0 {
+		return true
+	}
+	for _, e := range hook.Events {
+		if e == event || (strings.HasSuffix(e, "*") && strings.HasPrefix(event, strings.TrimSuffix(e, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// dispatch queues event for every matching webhook. Delivery happens in
+// tracked background jobs so shutdown waits for (or persists) them.
+func (m *webhookManager) dispatch(event, callContext string, data interface{}) {
+	payload, err := json.Marshal(WebhookEvent{
+		ID:        uuid.New().String(),
+		Event:     event,
+		Context:   callContext,
+		Timestamp: time.Now().UTC(),
+		Data:      data,
+	})
+	if err != nil {
+		log.Printf("Failed to encode webhook event %s: %v", event, err)
+		return
+	}
+
+	m.mu.Lock()
+	var deliveries []*webhookDelivery
+	var records []WebhookDeliveryRecord
+	for _, hook := range m.hooks {
+		if !hook.matches(event, callContext) {
+			continue
+		}
+		d := &webhookDelivery{
+			ID:        uuid.New().String(),
+			WebhookID: hook.ID,
+			Event:     event,
+			Payload:   payload,
+		}
+		m.pending[d.ID] = d
+		deliveries = append(deliveries, d)
+		records = append(records, *m.logDelivery(d))
+	}
+	m.mu.Unlock()
+
+	for i, d := range deliveries {
+		m.appendDeliveryLog(&records[i])
+		m.startDelivery(d)
+	}
+}
+
+func (m *webhookManager) startDelivery(d *webhookDelivery) {
+	// When draining, the delivery stays pending and is persisted
+	m.jobs.goJob("webhook "+d.ID, func() { m.deliver(d) })
+}
+
+// deliver POSTs the payload, retrying with exponential backoff. Deliveries
+// that are still failing when shutdown starts remain pending.
+func (m *webhookManager) deliver(d *webhookDelivery) {
+	backoff := webhookRetryBackoff
+	for {
+		m.mu.Lock()
+		hook, ok := m.hooks[d.WebhookID]
+		d.Attempts++
+		attempt := d.Attempts
+		m.mu.Unlock()
+
+		if !ok {
+			m.updateDelivery(d, webhookDeliveryFailed, nil)
+			m.finish(d) // webhook deleted meanwhile
+			return
+		}
+
+		start := time.Now()
+		statusCode, err := m.post(hook, d)
+		result := &WebhookAttempt{Time: start.UTC(), StatusCode: statusCode, LatencyMS: time.Since(start).Milliseconds()}
+		if err == nil {
+			m.updateDelivery(d, webhookDeliveryDelivered, result)
+			m.finish(d)
+			return
+		}
+		result.Error = err.Error()
+		log.Printf("Webhook %s delivery %s attempt %d failed: %v", hook.ID, d.ID, attempt, err)
+
+		if attempt >= webhookMaxAttempts {
+			log.Printf("Webhook %s delivery %s abandoned after %d attempts", hook.ID, d.ID, attempt)
+			m.updateDelivery(d, webhookDeliveryFailed, result)
+			m.finish(d)
+			return
+		}
+		m.updateDelivery(d, webhookDeliveryPending, result)
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-m.jobs.stopping():
+			return
+		}
+	}
+}
+
+// post sends one attempt and returns the response status code (0 when no
+// response was received)
+func (m *webhookManager) post(hook *Webhook, d *webhookDelivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "fs-api/"+Version)
+	req.Header.Set("X-FSAPI-Event", d.Event)
+	req.Header.Set("X-FSAPI-Delivery", d.ID)
+	if hook.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(hook.Secret))
+		mac.Write(d.Payload)
+		req.Header.Set("X-FSAPI-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	client := m.client
+	if hook.Restricted {
+		client = m.publicClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func (m *webhookManager) finish(d *webhookDelivery) {
+	m.mu.Lock()
+	delete(m.pending, d.ID)
+	m.mu.Unlock()
+}
+
+// persistPending saves undelivered webhooks for resume on the next start
+func (m *webhookManager) persistPending(_ context.Context) error {
+	m.mu.Lock()
+	pending := make([]*webhookDelivery, 0, len(m.pending))
+	for _, d := range m.pending {
+		pending = append(pending, d)
+	}
+	m.mu.Unlock()
+
+	if len(pending) == 0 {
+		return removeJSONFile(webhookPendingFile)
+	}
+	log.Printf("Persisting %d undelivered webhook(s)", len(pending))
+	return saveJSONFile(webhookPendingFile, pending)
+}
+
+// save persists the webhook registrations. Caller must hold mu.
+func (m *webhookManager) save() error {
+	hooks := make([]*Webhook, 0, len(m.hooks))
+	for _, hook := range m.hooks {
+		hooks = append(hooks, hook)
+	}
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].CreatedAt.Before(hooks[j].CreatedAt) })
+	return saveJSONFile(webhooksFile, hooks)
+}
+
+// --- Webhook handlers ---
+
+// webhookView hides the signing secret in API responses
+type webhookView struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Contexts  []string  `json:"contexts"`
+	HasSecret bool      `json:"has_secret"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (hook *Webhook) view() webhookView {
+	return webhookView{
+		ID:        hook.ID,
+		URL:       hook.URL,
+		Events:    append([]string{}, hook.Events...),
+		Contexts:  append([]string{}, hook.Contexts...),
+		HasSecret: hook.Secret != "",
+		CreatedAt: hook.CreatedAt,
+	}
+}
+
+// webhookVisible reports whether a restricted caller may see/manage the
+// webhook: every context it is scoped to must be allowed. Unscoped webhooks
+// are admin-only.
+func webhookVisible(r *http.Request, hook *Webhook) bool {
+	if isUnrestrictedAccess(r) {
+		return true
+	}
+	if len(hook.Contexts) == 0 {
+		return false
+	}
+	for _, ctx := range hook.Contexts {
+		if !isContextAllowed(r, ctx) {
+			return false
+		}
+	}
+	return true
+}
+
+// ListWebhooks handles GET /v1/webhooks
+func (h *APIHandler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	h.webhooks.mu.Lock()
+	rows := make([]webhookView, 0, len(h.webhooks.hooks))
+	for _, hook := range h.webhooks.hooks {
+		if webhookVisible(r, hook) {
+			rows = append(rows, hook.view())
+		}
+	}
+	h.webhooks.mu.Unlock()
+	sort.Slice(rows, func(i, j int) bool { return rows[i].CreatedAt.Before(rows[j].CreatedAt) })
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status":    "success",
+		"row_count": len(rows),
+		"rows":      rows,
+	})
+}
+
+// checkWebhookURL checks that a webhook URL is an absolute http(s) URL and,
+// for a restricted caller, that it does not name an internal IP address.
+// Host names are checked when delivering.
+func checkWebhookURL(u string, restricted bool) error {
+	parsed, err := url.Parse(u)
+	if u == "" || err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("url must be an absolute http(s) URL")
+	}
+	if addr, err := netip.ParseAddr(parsed.Hostname()); restricted && err == nil && !publicWebhookAddress(addr) {
+		return fmt.Errorf("url must not point to a loopback, private or link-local address")
+	}
+	return nil
+}
+
+// CreateWebhook handles POST /v1/webhooks
+func (h *APIHandler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req WebhookCreateRequest
+	if !h.decodeRequest(w, r, &req) {
+		return
+	}
+
+	if err := checkWebhookURL(req.URL, !isUnrestrictedAccess(r)); err != nil {
+		h.respondError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Restricted callers must scope the webhook to their own contexts
+	if !isUnrestrictedAccess(r) {
+		if len(req.Contexts) == 0 {
+			h.respondError(w, r, "contexts is required for restricted access", http.StatusBadRequest)
+			return
+		}
+		for _, ctx := range req.Contexts {
+			if !h.validateRequestContext(w, r, ctx) {
+				return
+			}
+		}
+	}
+
+	hook := &Webhook{
+		ID:         uuid.New().String(),
+		URL:        req.URL,
+		Events:     req.Events,
+		Contexts:   req.Contexts,
+		Secret:     req.Secret,
+		CreatedAt:  time.Now().UTC(),
+		Restricted: !isUnrestrictedAccess(r),
+	}
+
+	h.webhooks.mu.Lock()
+	h.webhooks.hooks[hook.ID] = hook
+	err := h.webhooks.save()
+	h.webhooks.mu.Unlock()
+	if err != nil {
+		logWarn(getRequestID(r), fmt.Sprintf("Failed to persist webhooks: %v", err))
+	}
+
+	logInfo(getRequestID(r), fmt.Sprintf("Webhook %s created for %s", hook.ID, hook.URL))
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   hook.view(),
+	})
+}
+
+// GetWebhook handles GET /v1/webhooks/{id}
+func (h *APIHandler) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	h.webhooks.mu.Lock()
+	hook, ok := h.webhooks.hooks[id]
+	h.webhooks.mu.Unlock()
+	if !ok || !webhookVisible(r, hook) {
+		h.respondError(w, r, fmt.Sprintf("Webhook %s not found", id), http.StatusNotFound)
+		return
+	}
+
+	h.respondJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"data":   hook.view(),
+	})
+}
+
+// DeleteWebhook handles DELETE /v1/webhooks/{id}
+func (h *APIHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	h.webhooks.mu.Lock()
+	hook, ok := h.webhooks.hooks[id]
+	if !ok || !webhookVisible(r, hook) {
+		h.webhooks.mu.Unlock()
+		h.respondError(w, r, fmt.Sprintf("Webhook %s not found", id), http.StatusNotFound)
+		return
+	}
+	delete(h.webhooks.hooks, id)
+	err := h.webhooks.save()
+	h.webhooks.mu.Unlock()
+	if err != nil {
+		logWarn(getRequestID(r), fmt.Sprintf("Failed to persist webhooks: %v", err))
+	}
+
+	h.respondSuccess(w, r, h.trashDeleted(r, fmt.Sprintf("Webhook %s deleted", id), trashWebhook, id, hook.Contexts, hook))
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+func TestPublicWebhookAddress(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.0.0.5", false},
+		{"172.16.0.1", false},
+		{"192.168.1.10", false},
+		{"100.64.0.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"224.0.0.1", false},
+		{"::ffff:127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := publicWebhookAddress(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("publicWebhookAddress(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestCheckWebhookURLRestricted(t *testing.T) {
+	tests := []struct {
+		url        string
+		restricted bool
+		ok         bool
+	}{
+		{"https://hooks.example.com/fs-api", true, true},
+		{"http://127.0.0.1:37274/v1/calls", true, false},
+		{"http://169.254.169.254/latest/meta-data", true, false},
+		{"http://[::1]:8021/", true, false},
+		{"http://127.0.0.1:8080/hook", false, true},
+		{"ftp://hooks.example.com/", false, false},
+	}
+	for _, tt := range tests {
+		if err := checkWebhookURL(tt.url, tt.restricted); (err == nil) != tt.ok {
+			t.Errorf("checkWebhookURL(%q, %v) = %v, want ok %v", tt.url, tt.restricted, err, tt.ok)
+		}
+	}
+}
+
+func TestPublicWebhookClientRefusesLoopback(t *testing.T) {
+	// Nothing needs to listen: the dial is refused before connecting
+	_, err := newPublicWebhookClient().Get("http://127.0.0.1:1/")
+	if err == nil || !errors.Is(err, errWebhookAddress) {
+		t.Errorf("Get(loopback) error = %v, want %v", err, errWebhookAddress)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// WhisperPrompt is played to the party that answers an originate before the
+// call is connected, e.g. "call from the website, lead 123"
+type WhisperPrompt struct {
+	File string       `json:"file,omitempty"` // Absolute path or local_stream://<name>
+	TTS  *AnnounceTTS `json:"tts,omitempty"`
+}
+
+// validateWhisper checks that exactly one of file and tts is set and that
+// it can be passed as a quoted channel variable
+func validateWhisper(whisper *WhisperPrompt) error {
+	if whisper == nil {
+		return nil
+	}
+	if (whisper.File == "") == (whisper.TTS == nil) {
+		return fmt.Errorf("whisper needs exactly one of file or tts")
+	}
+	if whisper.File != "" {
+		if err := checkPlaybackArgs(whisper.File); err != nil {
+			return fmt.Errorf("whisper.file: %v", strings.TrimPrefix(err.Error(), "application_args "))
+		}
+		if err := checkESLArg("whisper.file", whisper.File, "!"); err != nil {
+			return err
+		}
+		return nil
+	}
+	if err := checkTTS(whisper.TTS); err != nil {
+		return fmt.Errorf("whisper.%v", err)
+	}
+	return checkESLArg("whisper.tts.text", whisper.TTS.Text, "'\"!$")
+}
+
+// whisperFile returns what plays the whisper: its file, the speech in the
+// TTS cache, or text spoken through the tts:// file format
+func (h *APIHandler) whisperFile(whisper *WhisperPrompt) string {
+	if whisper.File != "" {
+		return whisper.File
+	}
+	if file, ok := h.ttsCache.lookup(whisper.TTS); ok {
+		return file
+	}
+	return fmt.Sprintf("tts://%s|%s|%s", whisper.TTS.Engine, whisper.TTS.Voice, whisper.TTS.Text)
+}
+
+// whisperVars returns the originate channel variables that play whisper as
+// name/value pairs. A call sent into a queue (bleg &callcenter) whispers to
+// the agent mod_callcenter connects it to; any other call to its A-leg once
+// answered, before the B-leg runs.
+func (h *APIHandler) whisperVars(whisper *WhisperPrompt, bleg string) [][2]string {
+	if whisper == nil {
+		return nil
+	}
+	file := h.whisperFile(whisper)
+	if strings.HasPrefix(bleg, "&callcenter(") {
+		return [][2]string{{"cc_outbound_announce", "'" + file + "'"}}
+	}
+	return [][2]string{
+		{"group_confirm_file", "'playback " + file + "'"},
+		{"group_confirm_key", "exec"},
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// fs-api can act as the mod_xml_curl gateway for the directory, dialplan and
+// configuration sections (FSAPI_XML_CURL_SECTIONS), answering from its own
+// stores:
+//
+//	directory      users provisioned under FSAPI_DIRECTORY_DIR
+//	dialplan       the DID registry, for calls in FSAPI_DID_CONTEXT, and
+//	               conference room extensions in their room's context
+//	configuration  callcenter.conf with the queues under FSAPI_CC_QUEUE_DIR
+//
+// Anything fs-api does not know is answered "not found", so FreeSWITCH falls
+// back to its static XML.
+
+var xmlCurlSectionNames = []string{"directory", "dialplan", "configuration"}
+
+const xmlCurlPath = "/v1/xml_curl"
+
+type xmlCurlDocument struct {
+	XMLName  xml.Name         `xml:"document"`
+	Type     string           `xml:"type,attr"`
+	Sections []xmlCurlSection `xml:"section"`
+}
+
+type xmlCurlSection struct {
+	Name          string                `xml:"name,attr"`
+	Result        *xmlCurlResult        `xml:"result,omitempty"`
+	Domain        *xmlCurlDomain        `xml:"domain,omitempty"`
+	Context       *xmlCurlContext       `xml:"context,omitempty"`
+	Configuration *xmlCurlConfiguration `xml:"configuration,omitempty"`
+}
+
+type xmlCurlResult struct {
+	Status string `xml:"status,attr"`
+}
+
+type xmlCurlDomain struct {
+	Name   string                  `xml:"name,attr"`
+	Params []directoryXMLNameValue `xml:"params>param"`
+	Users  []directoryXMLUser      `xml:"users>user"`
+}
+
+type xmlCurlContext struct {
+	Name       string            `xml:"name,attr"`
+	Extensions []didXMLExtension `xml:"extension"`
+}
+
+type xmlCurlConfiguration struct {
+	Name     string          `xml:"name,attr"`
+	Settings []queueXMLParam `xml:"settings>param"`
+	Queues   []queueXML      `xml:"queues>queue"`
+}
+
+// Standard dial-string so user/<id>@<domain> reaches registered contacts
+const xmlCurlDialString = "{^^:sip_invite_domain=${dialed_domain}:presence_id=${dialed_user}@${dialed_domain}}${sofia_contact(*/${dialed_user}@${dialed_domain})},${verto_contact(${dialed_user}@${dialed_domain})}"
+
+// xmlCurlDirectory answers a user lookup (sip_auth, user_call, ...) from the
+// provisioned directory. Domain-wide lookups (gateways, network lists) are
+// left to the static XML.
+func xmlCurlDirectory(r *http.Request) *xmlCurlSection {
+	domain, user := r.PostFormValue("domain"), r.PostFormValue("user")
+	if FSAPI_DIRECTORY_DIR == "" || r.PostFormValue("purpose") != "" {
+		return nil
+	}
+	if !domainPattern.MatchString(domain) || !userIDPattern.MatchString(user) {
+		return nil
+	}
+	u, err := loadDirectoryUser(user, domain)
+	if err != nil {
+		return nil
+	}
+	return &xmlCurlSection{
+		Name: "directory",
+		Domain: &xmlCurlDomain{
+			Name:   domain,
+			Params: []directoryXMLNameValue{{"dial-string", xmlCurlDialString}},
+			Users:  []directoryXMLUser{u.xmlUser()},
+		},
+	}
+}
+
+// xmlCurlDialplan routes calls to registered DIDs and conference room
+// extensions
+func (h *APIHandler) xmlCurlDialplan(r *http.Request) *xmlCurlSection {
+	context := r.PostFormValue("Hunt-Context")
+	if context == "" {
+		context = r.PostFormValue("Caller-Context")
+	}
+	number := r.PostFormValue("Hunt-Destination-Number")
+	if number == "" {
+		number = r.PostFormValue("Caller-Destination-Number")
+	}
+	if room := h.rooms.byExtension(context, number); room != nil {
+		return &xmlCurlSection{
+			Name: "dialplan",
+			Context: &xmlCurlContext{
+				Name:       context,
+				Extensions: []didXMLExtension{h.conferenceRoomExtension(room)},
+			},
+		}
+	}
+	if context != FSAPI_DID_CONTEXT {
+		return nil
+	}
+	did := h.dids.lookup(number)
+	if did == nil {
+		return nil
+	}
+	return &xmlCurlSection{
+		Name: "dialplan",
+		Context: &xmlCurlContext{
+			Name:       context,
+			Extensions: []didXMLExtension{didExtension(did)},
+		},
+	}
+}
+
+// xmlCurlConfig serves callcenter.conf with the provisioned queues. Agents
+// and tiers live in mod_callcenter's database and are not part of it.
+func xmlCurlConfig(r *http.Request) *xmlCurlSection {
+	if r.PostFormValue("key_value") != "callcenter.conf" || FSAPI_CC_QUEUE_DIR == "" {
+		return nil
+	}
+	conf := &xmlCurlConfiguration{Name: "callcenter.conf", Queues: []queueXML{}}
+	if FSAPI_CC_ODBC_DSN != "" {
+		conf.Settings = append(conf.Settings, queueXMLParam{Name: "odbc-dsn", Value: FSAPI_CC_ODBC_DSN})
+	}
+	files, _ := filepath.Glob(filepath.Join(FSAPI_CC_QUEUE_DIR, "*.xml"))
+	sort.Strings(files)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var q queueXMLFile
+		if err := xml.Unmarshal(data, &q); err != nil {
+			continue
+		}
+		conf.Queues = append(conf.Queues, q.Queue)
+	}
+	return &xmlCurlSection{Name: "configuration", Configuration: conf}
+}
+
+// POST /v1/xml_curl
+func (h *APIHandler) XMLCurl(w http.ResponseWriter, r *http.Request) {
+	if len(h.xmlCurlSections) == 0 {
+		h.respondError(w, r, "xml_curl bindings are disabled (FSAPI_XML_CURL_SECTIONS is not set)", http.StatusNotImplemented)
+		return
+	}
+	if !h.requireUnrestricted(w, r) {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			h.respondBodyTooLarge(w, r, tooLarge.Limit)
+			return
+		}
+		h.respondError(w, r, "Invalid form body", http.StatusBadRequest)
+		return
+	}
+
+	var section *xmlCurlSection
+	name := r.PostFormValue("section")
+	if containsString(h.xmlCurlSections, name) {
+		switch name {
+		case "directory":
+			section = xmlCurlDirectory(r)
+		case "dialplan":
+			section = h.xmlCurlDialplan(r)
+		case "configuration":
+			section = xmlCurlConfig(r)
+		}
+	}
+	if section == nil {
+		section = &xmlCurlSection{Name: "result", Result: &xmlCurlResult{Status: "not found"}}
+	}
+
+	data, err := xml.MarshalIndent(xmlCurlDocument{Type: "freeswitch/xml", Sections: []xmlCurlSection{*section}}, "", "  ")
+	if err != nil {
+		h.respondError(w, r, "Failed to render XML", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write(append(data, '\n'))
+}